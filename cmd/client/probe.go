@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"reverse-tunnel/internal/cli"
+	"reverse-tunnel/internal/config"
+	"reverse-tunnel/internal/pqctls"
+	"reverse-tunnel/internal/tunnel"
+)
+
+// probeTimeout probe 子命令 TCP 连接和 TLS 握手的默认超时
+const probeTimeout = 10 * time.Second
+
+// probeCommand 执行 probe 子命令：只与服务器完成一次 PQC mTLS 握手，输出协商的密钥交换组、签名算法和服务器证书后断开，
+// 不发送 HELLO/INIT、不建立隧道，用于在部署客户端之前确认服务器的证书和算法配置。握手成功时返回 0
+func probeCommand(args []string) int {
+	fs := cli.NewFlagSet("client", "probe", "只与服务器完成一次 PQC mTLS 握手，报告协商结果和服务器证书后断开，不建立隧道。用法: client probe [参数] <服务器地址>（参数须写在服务器地址之前）")
+	configFile := fs.String("config", "", "从客户端配置文件读取 tls 配置（及未指定服务器地址时的第一个服务器），指定后忽略其他 --tls-* 参数")
+	tlsCert := fs.String("tls-cert", "/root/pq-certs/client.crt", "客户端证书文件路径（留空则不发送证书，只适用于单向 TLS 的服务器）")
+	tlsKey := fs.String("tls-key", "/root/pq-certs/client.key", "客户端私钥文件路径")
+	tlsCA := fs.String("tls-ca", "/root/pq-certs/ca.crt", "CA 证书文件路径（用于验证服务器证书）")
+	tlsMinSecurityLevel := fs.Int("tls-min-security-level", 0, "要求的最低 NIST 安全级别（1-5，0 表示接受全部参数集）")
+	tlsServerFingerprint := fs.String("tls-server-fingerprint", "", "固定的服务器证书 SHA-256 指纹，未同时指定 --tls-ca 时只校验指纹而不通过 CA 验证")
+	timeout := fs.Duration("timeout", probeTimeout, "TCP 连接和 TLS 握手各自的超时")
+	fs.Parse(args)
+
+	var server string
+	if fs.NArg() > 0 {
+		server = fs.Arg(0)
+	}
+	if *configFile != "" {
+		cfg, err := config.LoadClientConfig(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "加载配置文件失败: %v\n", err)
+			return 1
+		}
+		*tlsCert, *tlsKey, *tlsCA, *tlsMinSecurityLevel = cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA, cfg.TLS.MinSecurityLevel
+		*tlsServerFingerprint = cfg.TLS.ServerFingerprint
+		if server == "" {
+			server, _, _ = strings.Cut(string(cfg.Server), ",")
+		}
+	} else if *tlsServerFingerprint != "" && !flagPassed(fs, "tls-ca") {
+		// 只固定指纹时不使用默认的 CA 路径
+		*tlsCA = ""
+	}
+	if server == "" {
+		fs.Usage()
+		return 2
+	}
+
+	var fingerprint []byte
+	if *tlsServerFingerprint != "" {
+		var err error
+		if fingerprint, err = pqctls.ParseFingerprint(*tlsServerFingerprint); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			return 2
+		}
+	}
+
+	start := time.Now()
+	conn, err := probeServer(server, *tlsCert, *tlsKey, *tlsCA, fingerprint, *tlsMinSecurityLevel, *timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "PQC mTLS 握手失败: %s: %v\n", server, err)
+		return 1
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	fmt.Printf("PQC mTLS 握手成功: %s (%v)\n", server, time.Since(start).Round(time.Millisecond))
+	fmt.Printf("  协议版本: %s\n", state.Version)
+	fmt.Printf("  密码套件: %s\n", state.CipherSuite)
+	fmt.Printf("  密钥交换组: %s\n", state.Group)
+	fmt.Printf("  签名算法: %s\n", state.Sigalg)
+	fmt.Printf("  ALPN: %s\n", orNone(state.NegotiatedProtocol))
+	cert, err := conn.PeerCertificate()
+	if err != nil {
+		fmt.Printf("  服务器证书: 无法获取 (%v)\n", err)
+		return 0
+	}
+	printProbeCert(cert)
+	return 0
+}
+
+// probeServer 建立 TCP 连接并完成 PQC mTLS 握手（ALPN 与控制连接相同），返回已握手的连接
+// fingerprint 非 nil 时校验服务器证书的 SHA-256 指纹（见 PQCDialer.SetServerFingerprint）
+func probeServer(address, certFile, keyFile, caFile string, fingerprint []byte, level int, timeout time.Duration) (*pqctls.PQCConn, error) {
+	if err := pqctls.Ready(); err != nil {
+		return nil, fmt.Errorf("PQC mTLS 初始化失败: %v", err)
+	}
+	dialer, err := pqctls.NewPQCDialerOpenSSL(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, fmt.Errorf("创建 PQC TLS 拨号器失败: %v", err)
+	}
+	defer dialer.Close()
+	dialer.SetServerFingerprint(fingerprint)
+	if err := dialer.SetALPNProtocols([]string{tunnel.ControlALPN}); err != nil {
+		return nil, fmt.Errorf("设置 ALPN 失败: %v", err)
+	}
+	if level > 0 {
+		if err := dialer.SetMinSecurityLevel(level); err != nil {
+			return nil, fmt.Errorf("设置最低安全级别失败: %v", err)
+		}
+	}
+	dialer.SetDialTimeout(timeout)
+	dialer.SetHandshakeTimeout(timeout)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*pqctls.PQCConn), nil
+}
+
+// printProbeCert 输出服务器证书的主题、颁发者、有效期和 SAN
+func printProbeCert(cert *x509.Certificate) {
+	fmt.Printf("  服务器证书:\n")
+	fmt.Printf("    主题: %s\n", cert.Subject)
+	fmt.Printf("    颁发者: %s\n", cert.Issuer)
+	fmt.Printf("    序列号: %s\n", cert.SerialNumber)
+	fingerprint := sha256.Sum256(cert.Raw)
+	fmt.Printf("    SHA-256 指纹: %s\n", pqctls.FormatFingerprint(fingerprint[:]))
+	validity := fmt.Sprintf("剩余 %d 天", int(time.Until(cert.NotAfter).Hours()/24))
+	if time.Now().After(cert.NotAfter) {
+		validity = "已过期"
+	}
+	fmt.Printf("    有效期: %s 至 %s（%s）\n", cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339), validity)
+	if len(cert.DNSNames) > 0 {
+		fmt.Printf("    DNS SAN: %s\n", strings.Join(cert.DNSNames, ", "))
+	}
+	if len(cert.IPAddresses) > 0 {
+		ips := make([]string, len(cert.IPAddresses))
+		for i, ip := range cert.IPAddresses {
+			ips[i] = ip.String()
+		}
+		fmt.Printf("    IP SAN: %s\n", strings.Join(ips, ", "))
+	}
+}
+
+// orNone 空字符串显示为 "(无)"
+func orNone(s string) string {
+	if s == "" {
+		return "(无)"
+	}
+	return s
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"reverse-tunnel/internal/tunnel"
+)
+
+// watchDumpState 收到 SIGUSR1 时将所有客户端的状态快照（见 Server.DumpState）逐行写入运行日志，
+// 用于在没有开启管理接口时排查线上问题
+func watchDumpState(server *tunnel.Server) {
+	usr1Chan := make(chan os.Signal, 1)
+	signal.Notify(usr1Chan, syscall.SIGUSR1)
+	go func() {
+		for range usr1Chan {
+			log.Printf("收到 SIGUSR1，输出状态快照")
+			var buf bytes.Buffer
+			server.DumpState(&buf)
+			scanner := bufio.NewScanner(&buf)
+			for scanner.Scan() {
+				log.Print(scanner.Text())
+			}
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+package main
+
+import "reverse-tunnel/internal/tunnel"
+
+// watchDumpState Windows 没有 SIGUSR1，不支持通过信号输出状态快照
+func watchDumpState(server *tunnel.Server) {}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"reverse-tunnel/internal/cli"
+	"reverse-tunnel/internal/pqctls"
+)
+
+// genCertsCommand 执行 gencerts 子命令：在输出目录生成 ML-DSA-65 自签名的服务器证书和客户端证书，
+// 以及同时包含两者的 ca.crt（双方都以它作为 CA 文件，各自信任对端的自签名证书），用于快速上手和测试。
+// 生产环境应使用由自己的 CA 签发的证书
+func genCertsCommand(args []string) int {
+	fs := cli.NewFlagSet("server", "gencerts", "生成用于快速上手和测试的 ML-DSA-65 自签名证书：server.crt/server.key、client.crt/client.key 及同时包含两张证书的 ca.crt（服务器和客户端的 --tls-ca 都使用它）。生产环境请使用由自己的 CA 签发的证书")
+	outDir := fs.String("out", ".", "输出目录（不存在时创建）")
+	days := fs.Int("days", 365, "证书有效期（天）")
+	serverCN := fs.String("server-cn", "reverse-tunnel-server", "服务器证书的 CN")
+	clientCN := fs.String("client-cn", "reverse-tunnel-client", "客户端证书的 CN（即客户端身份，配额策略按它匹配）")
+	force := fs.Bool("force", false, "覆盖输出目录中已存在的证书和私钥")
+	fs.Parse(args)
+
+	if err := genCerts(*outDir, *serverCN, *clientCN, time.Duration(*days)*24*time.Hour, *force); err != nil {
+		fmt.Fprintf(os.Stderr, "生成证书失败: %v\n", err)
+		return 1
+	}
+	fmt.Printf("已在 %s 生成 server.crt、server.key、client.crt、client.key 和 ca.crt（有效期 %d 天）\n", *outDir, *days)
+	fmt.Printf("  服务器: --tls --tls-cert=%s --tls-key=%s --tls-ca=%s\n",
+		filepath.Join(*outDir, "server.crt"), filepath.Join(*outDir, "server.key"), filepath.Join(*outDir, "ca.crt"))
+	fmt.Printf("  客户端: --tls --tls-cert=%s --tls-key=%s --tls-ca=%s\n",
+		filepath.Join(*outDir, "client.crt"), filepath.Join(*outDir, "client.key"), filepath.Join(*outDir, "ca.crt"))
+	return 0
+}
+
+// genCerts 生成证书并写入 dir，force 为 false 时任一文件已存在即失败（不覆盖现有的私钥）
+func genCerts(dir, serverCN, clientCN string, validFor time.Duration, force bool) error {
+	if validFor <= 0 {
+		return fmt.Errorf("有效期必须大于 0")
+	}
+	if err := pqctls.Ready(); err != nil {
+		return fmt.Errorf("PQC mTLS 初始化失败: %v", err)
+	}
+	names := []string{"server.crt", "server.key", "client.crt", "client.key", "ca.crt"}
+	if !force {
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return fmt.Errorf("%s 已存在（使用 --force 覆盖）", filepath.Join(dir, name))
+			}
+		}
+	}
+
+	serverCert, serverKey, err := pqctls.GenerateSelfSignedCert(serverCN, validFor)
+	if err != nil {
+		return err
+	}
+	clientCert, clientKey, err := pqctls.GenerateSelfSignedCert(clientCN, validFor)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	contents := [][]byte{serverCert, serverKey, clientCert, clientKey, bytes.Join([][]byte{serverCert, clientCert}, nil)}
+	for i, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), contents[i], 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,28 +3,134 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"reverse-tunnel/internal/cli"
 	"reverse-tunnel/internal/config"
+	"reverse-tunnel/internal/pqctls"
+	"reverse-tunnel/internal/proto"
 	"reverse-tunnel/internal/tunnel"
 )
 
+// serverCommands 服务器的子命令
+var serverCommands = []cli.Command{
+	{Name: "run", Summary: "启动服务器", Run: runServer},
+	{Name: "validate", Summary: "校验配置（配置文件或命令行参数）、证书和策略文件后退出，不监听端口", Run: validateServer},
+	{Name: "selftest", Summary: "在本进程内启动服务器、客户端和回显服务，校验往返数据后退出", Run: selfTestCommand},
+	{Name: "gencerts", Summary: "生成用于快速上手和测试的 ML-DSA-65 自签名服务器/客户端证书", Run: genCertsCommand},
+}
+
 func main() {
-	// 解析命令行参数
-	configFile := flag.String("config", "", "配置文件路径（JSON 格式，如果指定则忽略其他命令行参数）")
-	controlListen := flag.String("control-listen", ":7000", "控制/隧道端口监听地址（供 client 连接）")
-	publicListen := flag.String("public-listen", "", "对外暴露的端口监听地址（供外部访问，留空则由客户端指定）")
+	os.Exit(cli.Dispatch("server", serverCommands, "run", os.Args[1:]))
+}
+
+// parseServerFlags 在 fs 上定义 run 和 validate 共用的参数并解析 args，返回配置及配置文件路径（未使用配置文件时为空）
+// 指定了 --config 时从配置文件加载并忽略其他参数，否则由命令行参数构造配置；配置无效时退出
+func parseServerFlags(fs *flag.FlagSet, args []string) (*config.ServerConfig, string) {
+	configFile := fs.String("config", "", "配置文件路径、http(s):// URL 或 -（标准输入），JSON 格式，如果指定则忽略其他命令行参数")
+	configAllowUnknown := fs.Bool("config-allow-unknown", false, "加载配置文件时忽略未知的配置项（记录警告），用于旧版本读取为新版本编写的配置；默认未知的配置项导致加载失败")
+	name := fs.String("name", "", "实例名称（可选），标记运行日志的每一行，并作为指标的 name 标签和访问/安全日志记录的 name 字段")
+	controlListen := fs.String("control-listen", ":7000", "控制/隧道端口监听地址（供 client 连接）")
+	publicListen := fs.String("public-listen", "", "对外暴露的端口监听地址（供外部访问，留空则由客户端指定）")
+	globalRouting := fs.String("global-routing", "", "全局公开端口的路由策略：hostname（只按主机名）、round-robin（轮流）或 weighted（按客户端权重）；留空时多个客户端匹配同一连接则拒绝该连接")
+	allowInsecureControl := fs.Bool("allow-insecure-control", false, "允许控制端口在非回环地址上以明文或不验证客户端证书的方式监听（只记录警告）；默认未启用 --tls 和客户端证书验证时拒绝监听非回环地址")
+	clientPortBindAddr := fs.String("client-port-bind-addr", "", "客户端指定的远程端口绑定的 IP 地址（留空则绑定所有接口）")
+	portPool := fs.String("port-pool", "", "租给客户端的固定远程端口池，以逗号分隔的端口和端口范围（例如 20000-20099,20200，留空则不启用）")
+	accessLog := fs.String("access-log", "", "公开连接访问日志文件路径（JSON Lines，留空则不记录）")
+	securityLog := fs.String("security-log", "", "被拒绝的控制连接握手的安全日志文件路径（JSON Lines，留空则不记录）")
+	frameTrace := fs.String("frame-trace", "", "控制连接帧跟踪文件路径（每个帧一行，不含负载内容，用于排查帧错位，留空则不记录）")
+	controlWriteTimeout := fs.Int("control-write-timeout", config.DefaultControlWriteTimeout, "控制连接单帧写入超时（秒，包括等待其他帧写完的时间，0 表示不设超时）")
+	maxControlLifetime := fs.Int("max-control-conn-lifetime", 0, "控制连接最大存活时间（秒，0 表示不限制）")
+	healthCheckInterval := fs.Int("health-check-interval", 0, "向客户端发送健康检查的间隔（秒，0 表示不检查），本地服务不可用的客户端不参与全局公开端口的路由")
+	shutdownTimeout := fs.Int("shutdown-timeout", 0, "关闭时清理资源的最长时间，超时后放弃剩余的关闭操作（秒，0 表示默认 10 秒）")
+	publicQueueSize := fs.Int("public-queue-size", 0, "公开连接队列容量（0 表示默认 100）")
+	publicQueuePolicy := fs.String("public-queue-policy", "block", "公开连接队列满时的策略：block 或 reject")
+	publicWorkers := fs.Int("public-workers", 0, "处理公开连接的 worker 数量（0 表示默认 8）")
+	publicClientQueueSize := fs.Int("public-client-queue-size", 0, "全局公开端口每个客户端最多排队的连接数，按客户端轮流处理（0 表示不启用）")
+	publicErrorResponse := fs.String("public-error-response", "", "客户端连接本地服务失败时向外部连接回复的错误：留空直接关闭，http 回复 HTTP 502")
+	publicSourceMaxConns := fs.Int("public-source-max-conns", 0, "每个来源 IP 同时打开的公开连接数上限（0 表示不限制）")
+	publicSourceConnRate := fs.Int("public-source-conn-rate", 0, "每个来源 IP 每秒新建的公开连接数上限（0 表示不限制）")
+	publicSourceConnBurst := fs.Int("public-source-conn-burst", 0, "每个来源 IP 允许的突发连接数（0 表示等于 --public-source-conn-rate）")
+	clientShareMaxConns := fs.Int("client-share-max-conns", 0, "全局公开端口上每个客户端同时打开的连接数上限，份额用完的客户端不再分到新连接（0 表示不限制）")
+	clientShareConnRate := fs.Int("client-share-conn-rate", 0, "全局公开端口上每个客户端每秒新建的连接数上限（0 表示不限制）")
+	maxForwarders := fs.Int("max-forwarders", 0, "转发公开连接数据的 goroutine 数上限（每个公开连接一个），达到上限时关闭新的公开连接（0 表示不限制）")
+	noClientPolicy := fs.String("no-client-policy", "close", "全局公开端口没有可用客户端时的策略：close（关闭连接）、hold（等待客户端连接）或 error（回复 HTTP 503）")
+	noClientHoldTimeout := fs.Int("no-client-hold-timeout", 0, "hold 策略等待客户端连接的最长时间（秒，0 表示默认 5 秒）")
+	noClientMaxHolds := fs.Int("no-client-max-holds", 0, "hold 策略同时等待客户端的连接数上限，达到上限时关闭新连接（0 表示默认 1024）")
+	pausePolicy := fs.String("pause-policy", "reject", "客户端被管理接口暂停期间新公开连接的策略：reject（关闭连接）或 hold（等待客户端恢复）")
+	pauseHoldTimeout := fs.Int("pause-hold-timeout", 0, "hold 策略等待客户端恢复的最长时间（秒，0 表示默认 30 秒）")
+	publicFallbackFile := fs.String("public-fallback-file", "", "全局公开端口没有可用客户端时回复给 HTTP 请求的静态页面文件（例如维护页，留空则不启用）")
+	publicFallbackStatus := fs.Int("public-fallback-status", 0, "静态页面的 HTTP 状态码（0 表示默认 503）")
+	maxBytesPerConn := fs.Int64("max-bytes-per-conn", 0, "单个公开连接最多传输的字节数（两个方向合计，0 表示不限制），超出后强制关闭")
+	connSetupTimeout := fs.Int("conn-setup-timeout", 0, "发送 NEW_CONN 后等待客户端建立本地连接的最长时间（秒，0 表示不限制），超时后关闭公开连接")
+	maxPendingNewConns := fs.Int("max-pending-new-conns", 0, "每个客户端已发送 NEW_CONN、尚未确认建立的连接数上限，达到上限时暂停向其转发新的公开连接（0 表示不限制）")
+	connIdleTimeout := fs.Int("conn-idle-timeout", 0, "公开连接的空闲超时，两个方向都没有数据超过该时间时关闭（秒，0 表示不限制）")
+	connMaxLifetime := fs.Int("conn-max-lifetime", 0, "公开连接的最长存活时间，到期后关闭（秒，0 表示不限制）")
+	maxObservers := fs.Int("max-observers", 0, "观察者连接数上限（0 表示不接受观察者），观察者只接收隧道事件，不分配端口、不参与转发")
+	initTimeout := fs.Int("init-timeout", 0, "控制连接建立后等待客户端首个 HELLO 或 INIT 的宽限期（秒，0 表示不限制），超时后断开控制连接")
+	maxInitPayload := fs.Int("max-init-payload", 0, "INIT 帧负载的字节数上限（0 表示协议上限 4096）")
+	initBudgetBytes := fs.Int("init-budget-bytes", 0, "控制连接完成 INIT 之前最多读取的字节数（含帧头，0 表示不限制），超出后断开控制连接")
+	initBudgetTimeout := fs.Int("init-budget-timeout", 0, "控制连接建立后完成 INIT 的最长时间（秒，0 表示不限制），超时后断开控制连接")
+	randomConnIDBase := fs.Bool("random-conn-id-base", false, "每个控制连接的 connID 从随机起点开始（默认从 1 开始），同一控制连接上仍严格递增")
+	traceContext := fs.Bool("trace-context", false, "为 HTTP 公开连接的第一个请求注入 W3C traceparent 请求头（沿用请求携带的 trace-id，只应在 HTTP 隧道上启用）")
+	lazyNewConn := fs.Bool("lazy-new-conn", false, "公开连接发送第一个字节后才通知客户端建立本地连接（只应用于客户端先发送数据的协议，如 HTTP）")
+	lazyNewConnTimeout := fs.Int("lazy-new-conn-timeout", 0, "启用 --lazy-new-conn 时等待首字节的最长时间（秒，0 表示默认 10 秒）")
+	httpCompression := fs.Bool("http-compression", false, "按内容类型 gzip 压缩 HTTP/1.1 公开连接的响应（只压缩文本、JSON 等可压缩类型，只应在 HTTP 隧道上启用）")
+	frameMACKey := fs.String("frame-mac-key", "", "控制连接帧完整性校验的共享密钥（仅用于未启用 TLS 的明文模式，至少 16 字节，留空则不启用）")
+	requirePublicEndpoint := fs.Bool("require-public-endpoint", false, "未配置 --public-listen 时断开没有请求远程端口的客户端（默认只记录警告）")
+	requiredFeatures := fs.String("required-features", "", "客户端必须支持的协议特性，以逗号分隔（data_keepalive、assignment_info，留空表示不要求）")
+	maxDataPayload := fs.Int("max-data-payload", 0, "能接收的 DATA 帧负载上限（字节，0 表示默认 4096，最大 32768），与客户端协商后使用双方的较小值")
+	network := fs.String("network", "tcp", "监听网络类型：tcp（双栈）、tcp4 或 tcp6")
+	socketReadBuffer := fs.Int("socket-read-buffer", 0, "控制端口和公开端口 socket 的接收缓冲区大小（字节，0 表示系统默认）")
+	dscp := fs.Int("dscp", 0, "控制端口和公开端口 socket 的 DSCP 标记（0-63，0 表示不标记，例如 46 为 EF、10 为 AF11），只在 Linux 和 macOS 上生效")
+	socketWriteBuffer := fs.Int("socket-write-buffer", 0, "控制端口和公开端口 socket 的发送缓冲区大小（字节，0 表示系统默认）")
+	transport := fs.String("transport", "tcp", "控制连接的传输：tcp 或 websocket")
+	wsPath := fs.String("ws-path", tunnel.DefaultWebSocketPath, "WebSocket 传输的升级路径")
+	strictAux := fs.Bool("strict-aux-listeners", false, "指标/状态监听器绑定失败时退出（默认记录警告并继续运行）")
+	enablePprof := fs.Bool("enable-pprof", false, "在指标/状态监听器上挂载 /debug/pprof/（需要 --admin-token）")
+	enableStatusUI := fs.Bool("enable-status-ui", false, "在指标/状态监听器上挂载内置 HTML 状态页 /ui/（需要 --admin-token）")
+	adminToken := fs.String("admin-token", "", "管理接口令牌（pprof、状态页和连接管理接口，Authorization: Bearer <token>）")
+	maxFrameRate := fs.Int("max-frame-rate", 0, "每个控制连接每秒最多处理的帧数（0 表示不限制）")
+	frameRatePolicy := fs.String("frame-rate-policy", "throttle", "帧速率超限时的策略：throttle（延迟处理）或 drop（断开）")
+	decodeErrorLimit := fs.Int("decode-error-limit", 0, "同一来源（客户端身份或 IP）累计多少次控制帧解码错误后暂时拒绝其重连（0 表示不限制）")
+	decodeErrorBackoff := fs.Int("decode-error-backoff", 0, "解码错误的计数窗口和拒绝重连的时长（秒，0 表示默认 60 秒）")
+	policyFile := fs.String("policy-file", "", "按客户端身份（证书 CN）的配额策略文件路径（JSON，留空则不限制，修改后或收到 SIGHUP 时重新加载）")
+	policyRevoke := fs.Bool("policy-revoke-connected", false, "重新加载策略后断开身份已被撤销的在线客户端")
+	duplicateIdentity := fs.String("duplicate-identity-policy", "allow", "相同身份（证书 CN）的客户端重复连接时的策略：allow（允许）、reject-new（拒绝新客户端）或 replace-old（断开原客户端）")
+	portFile := fs.String("port-file", "", "隧道就绪后写入端口分配（JSON）的文件路径（留空则不写）")
+	portWebhook := fs.String("port-webhook", "", "端口分配/释放时 POST 事件的 webhook URL（留空则不推送）")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "OpenTelemetry 指标推送地址（OTLP/HTTP，例如 http://otel-collector:4318/v1/metrics，留空则不推送）")
+	otlpInterval := fs.Int("otlp-interval", 0, "OTLP 指标推送间隔（秒，0 表示默认 60）")
+	eventWebhook := fs.String("event-webhook", "", "批量 POST 隧道生命周期事件（客户端连接/就绪/注销、公开连接建立/结束）的 webhook URL（留空则不导出）")
+	meteringFile := fs.String("metering-file", "", "按客户端身份累计用量的计量文件，启动时恢复并周期性写入（留空则不写）")
+	meteringWebhook := fs.String("metering-webhook", "", "周期性 POST 计量快照的 webhook URL（留空则不推送）")
+	meteringInterval := fs.Int("metering-interval", 0, "写入/推送计量快照的间隔（秒，0 表示默认 60 秒）")
+	metricsListen := fs.String("metrics-listen", "", "指标/状态 HTTP 监听地址（提供 /metrics 和 /status，留空则不启用）")
+	framePayloadHistogram := fs.Bool("frame-payload-histogram", false, "在 /metrics 中输出 DATA 帧负载大小直方图（按发送/接收方向，用于调整 DATA 帧分块大小）")
+	publicTLSCert := fs.String("public-tls-cert", "", "全局公开端口终止 TLS 使用的证书（例如通配符证书，留空则原样转发）")
+	publicTLSKey := fs.String("public-tls-key", "", "全局公开端口终止 TLS 使用的私钥")
 	
 	// PQC mTLS 参数
-	useTLS := flag.Bool("tls", false, "启用 PQC mTLS")
-	tlsCert := flag.String("tls-cert", "/root/pq-certs/server.crt", "服务器证书文件路径")
-	tlsKey := flag.String("tls-key", "/root/pq-certs/server.key", "服务器私钥文件路径")
-	tlsCA := flag.String("tls-ca", "/root/pq-certs/ca.crt", "CA 证书文件路径（用于验证客户端证书）")
+	useTLS := fs.Bool("tls", false, "启用 PQC mTLS")
+	tlsCert := fs.String("tls-cert", "/root/pq-certs/server.crt", "服务器证书文件路径")
+	tlsKey := fs.String("tls-key", "/root/pq-certs/server.key", "服务器私钥文件路径")
+	tlsCA := fs.String("tls-ca", "/root/pq-certs/ca.crt", "CA 证书文件路径（用于验证客户端证书）")
+	tlsSessionResumption := fs.Bool("tls-session-resumption", false, "允许客户端恢复 TLS 会话（降低重连握手开销，恢复的会话不重新校验证书）")
+	tlsRequireClientCert := fs.Bool("tls-require-client-cert", true, "要求客户端证书（mTLS），设为 false 时为单向 TLS，没有证书的客户端身份为空（需要拒绝空身份的 --policy-file）")
+	tlsMinSecurityLevel := fs.Int("tls-min-security-level", 0, "要求的最低 NIST 安全级别（1-5，例如 3 表示至少 ML-KEM-768/ML-DSA-65，0 表示接受全部参数集）")
+	tlsMaxHandshakes := fs.Int("tls-max-handshakes", 0, "同时进行的 PQC mTLS 握手数上限，防止大量连接在认证前耗尽 CPU（0 表示不并发握手，逐个完成）")
+	tlsHandshakeLimitPolicy := fs.String("tls-handshake-limit-policy", "queue", "握手数达到上限时的策略：queue（暂停接受新连接）或 reject（关闭新连接）")
+	tlsLogPeerCertChain := fs.Bool("tls-log-peer-cert-chain", false, "客户端注册成功时把其完整证书链（主题、颁发者、序列号、有效期）写入运行日志和安全日志，用于合规审计")
+	tlsKeyLogFile := fs.String("tls-key-log-file", "", "TLS 密钥日志文件路径（仅用于调试，任何拿到该文件的人都能解密隧道流量；留空则使用 SSLKEYLOGFILE 环境变量）")
 	
-	flag.Parse()
+	fs.Parse(args)
+	config.AllowUnknownFields = *configAllowUnknown
 
 	// 如果指定了配置文件，从配置文件加载
 	var cfg *config.ServerConfig
@@ -34,17 +140,201 @@ func main() {
 		if err != nil {
 			log.Fatalf("加载配置文件失败: %v", err)
 		}
-		log.Printf("已从配置文件加载: %s", *configFile)
 	} else {
 		// 否则使用命令行参数
 		cfg = &config.ServerConfig{
+			Name:          *name,
 			ControlListen: *controlListen,
 			PublicListen:  *publicListen,
+			GlobalRouting: *globalRouting,
+
+			AllowInsecureControl: *allowInsecureControl,
+
+			ClientPortBindAddr: *clientPortBindAddr,
+
+			ControlWriteTimeout: *controlWriteTimeout,
+			ShutdownTimeout:     *shutdownTimeout,
+			AccessLog:           *accessLog,
+			SecurityLog:         *securityLog,
+			FrameTrace:          *frameTrace,
+			MetricsListen:       *metricsListen,
+			StrictAuxListeners:  *strictAux,
+			EnablePprof:         *enablePprof,
+			EnableStatusUI:      *enableStatusUI,
+			AdminToken:          *adminToken,
+			Network:             *network,
+			SocketReadBuffer:    *socketReadBuffer,
+			SocketWriteBuffer:   *socketWriteBuffer,
+			DSCP:                *dscp,
+			Transport:           *transport,
+			WSPath:              *wsPath,
+
+			HealthCheckInterval:   *healthCheckInterval,
+			PolicyFile:            *policyFile,
+			PolicyRevokeConnected: *policyRevoke,
+			FramePayloadHistogram: *framePayloadHistogram,
+			PortFile:              *portFile,
+			PortWebhook:           *portWebhook,
+			EventWebhook:          *eventWebhook,
+			OTLP:                  config.OTLPConfig{Endpoint: *otlpEndpoint, Interval: *otlpInterval},
+			MeteringFile:          *meteringFile,
+			MeteringWebhook:       *meteringWebhook,
+			MeteringInterval:      *meteringInterval,
+			FrameRatePolicy:       *frameRatePolicy,
+			MaxDataPayload:        *maxDataPayload,
+
+			PublicQueueSize:       *publicQueueSize,
+			PublicQueuePolicy:     *publicQueuePolicy,
+			PublicWorkers:         *publicWorkers,
+			PublicClientQueueSize: *publicClientQueueSize,
+			PublicErrorResponse:   *publicErrorResponse,
+			NoClientPolicy:        *noClientPolicy,
+			NoClientHoldTimeout:   *noClientHoldTimeout,
+			NoClientMaxHolds:      *noClientMaxHolds,
+			PausePolicy:           *pausePolicy,
+			PauseHoldTimeout:      *pauseHoldTimeout,
+			MaxObservers:          *maxObservers,
+			RandomConnIDBase:      *randomConnIDBase,
+			TraceContext:          *traceContext,
+			HTTPCompression:       *httpCompression,
+			FrameMACKey:           *frameMACKey,
+
+			RequirePublicEndpoint:   *requirePublicEndpoint,
+			DuplicateIdentityPolicy: *duplicateIdentity,
+			LazyNewConn:             *lazyNewConn,
+			LazyNewConnTimeout:      *lazyNewConnTimeout,
+			DecodeErrorLimit:        *decodeErrorLimit,
+			DecodeErrorBackoff:      *decodeErrorBackoff,
+
+			Limits: config.ServerLimits{
+				PublicSourceMaxConns:   *publicSourceMaxConns,
+				PublicSourceConnRate:   *publicSourceConnRate,
+				PublicSourceConnBurst:  *publicSourceConnBurst,
+				ClientShareMaxConns:    *clientShareMaxConns,
+				ClientShareConnRate:    *clientShareConnRate,
+				MaxForwarders:          *maxForwarders,
+				MaxBytesPerConn:        *maxBytesPerConn,
+				ConnSetupTimeout:       *connSetupTimeout,
+				ConnIdleTimeout:        *connIdleTimeout,
+				ConnMaxLifetime:        *connMaxLifetime,
+				MaxPendingNewConns:     *maxPendingNewConns,
+				InitTimeout:            *initTimeout,
+				MaxControlConnLifetime: *maxControlLifetime,
+				MaxFrameRate:           *maxFrameRate,
+				MaxInitPayload:         *maxInitPayload,
+				InitBudgetBytes:        *initBudgetBytes,
+				InitBudgetTimeout:      *initBudgetTimeout,
+			},
+		}
+		if err := config.ValidateNetwork(cfg.Network); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateClientPortBindAddr(cfg.ClientPortBindAddr); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateQueuePolicy(cfg.PublicQueuePolicy); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateFrameRatePolicy(cfg.FrameRatePolicy); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateDuplicateIdentityPolicy(cfg.DuplicateIdentityPolicy); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateGlobalRouting(cfg.GlobalRouting); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidatePublicErrorResponse(cfg.PublicErrorResponse); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateNoClientPolicy(cfg.NoClientPolicy); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidatePausePolicy(cfg.PausePolicy); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateMaxBytesPerConn(cfg.Limits.MaxBytesPerConn); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateMaxInitPayload(cfg.Limits.MaxInitPayload); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		ports, err := config.ParsePortPool(*portPool)
+		if err != nil {
+			log.Fatalf("错误: --port-pool: %v", err)
+		}
+		cfg.PortPool = ports
+		if err := config.ValidatePortPool(cfg.PortPool); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if *requiredFeatures != "" {
+			for _, item := range strings.Split(*requiredFeatures, ",") {
+				cfg.RequiredFeatures = append(cfg.RequiredFeatures, strings.TrimSpace(item))
+			}
+		}
+		if err := config.ValidateRequiredFeatures(cfg.RequiredFeatures); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateMaxDataPayload(cfg.MaxDataPayload); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateOTLP(cfg.OTLP); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateSocketBuffers(cfg.SocketReadBuffer, cfg.SocketWriteBuffer); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateDSCP(cfg.DSCP); err != nil {
+			log.Fatalf("错误: %v", err)
 		}
 		cfg.TLS.Enabled = *useTLS
 		cfg.TLS.Cert = *tlsCert
 		cfg.TLS.Key = *tlsKey
 		cfg.TLS.CA = *tlsCA
+		cfg.TLS.SessionResumption = *tlsSessionResumption
+		cfg.TLS.RequireClientCert = *tlsRequireClientCert
+		cfg.TLS.MinSecurityLevel = *tlsMinSecurityLevel
+		cfg.TLS.MaxHandshakes = *tlsMaxHandshakes
+		cfg.TLS.HandshakeLimitPolicy = *tlsHandshakeLimitPolicy
+		cfg.TLS.KeyLogFile = *tlsKeyLogFile
+		cfg.TLS.LogPeerCertChain = *tlsLogPeerCertChain
+		cfg.PublicTLS.Cert = *publicTLSCert
+		cfg.PublicTLS.Key = *publicTLSKey
+		cfg.PublicFallback.BodyFile = *publicFallbackFile
+		cfg.PublicFallback.Status = *publicFallbackStatus
+		if err := config.ValidateTransport(cfg.Transport, cfg.TLS.Enabled); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateFrameMACKey(cfg.FrameMACKey, cfg.TLS.Enabled); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidateHandshakeLimitPolicy(cfg.TLS.HandshakeLimitPolicy); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		if err := config.ValidatePublicFallback(cfg.PublicFallback.BodyFile, cfg.PublicFallback.Status); err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+	}
+
+	return cfg, *configFile
+}
+
+// runServer 执行 run 子命令：启动服务器，直到收到 SIGINT/SIGTERM
+func runServer(args []string) int {
+	fs := cli.NewFlagSet("server", "run", "启动服务器。指定 --config 时从配置文件加载并忽略其他参数")
+	selfTestMode := fs.Bool("self-test", false, "等同于 selftest 子命令（兼容旧用法）")
+	cfg, configFile := parseServerFlags(fs, args)
+	if *selfTestMode {
+		return runSelfTest(cfg.TLS.Enabled)
+	}
+
+	// 实例名称标记运行日志的每一行（位于时间戳之后），汇总多个实例的日志时区分来源
+	if cfg.Name != "" {
+		log.SetPrefix("name=" + cfg.Name + " ")
+		log.SetFlags(log.Flags() | log.Lmsgprefix)
+	}
+	if configFile != "" {
+		log.Printf("已从配置文件加载: %s", configFile)
 	}
 
 	// 创建支持优雅退出的 context
@@ -65,32 +355,518 @@ func main() {
 	// 打印启动信息
 	log.Printf("反向隧道服务器启动中...")
 	log.Printf("控制端口监听: %s", cfg.ControlListen)
+	if err := checkControlExposure(cfg); err != nil {
+		log.Fatalf("错误: %v", err)
+	}
 	if cfg.PublicListen != "" {
 		log.Printf("对外端口监听: %s", cfg.PublicListen)
 	} else {
-		log.Printf("对外端口: 由客户端指定")
+		log.Printf("对外端口: 由客户端指定（未请求远程端口的客户端没有公开入口）")
 	}
 	if cfg.TLS.Enabled {
+		// OpenSSL 在包初始化时加载配置文件，PQC 算法不可用时在启动阶段直接退出
+		if err := pqctls.Ready(); err != nil {
+			log.Fatalf("PQC mTLS 初始化失败: %v", err)
+		}
+		// 证书缺失或无效时在启动阶段退出，而不是在每次连接时失败
+		if err := pqctls.CheckCertificateFiles(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA); err != nil {
+			log.Fatalf("PQC mTLS 证书配置无效: %v", err)
+		}
+		// 密钥日志必须在创建监听器/拨号器之前启用
+		keyLogFile := cfg.TLS.KeyLogFile
+		if keyLogFile == "" {
+			keyLogFile = os.Getenv(pqctls.KeyLogFileEnv)
+		}
+		if keyLogFile != "" {
+			if err := pqctls.SetKeyLogFile(keyLogFile); err != nil {
+				log.Fatalf("启用 TLS 密钥日志失败: %v", err)
+			}
+			log.Printf("警告: TLS 密钥日志已启用，每次握手的会话密钥都写入 %s，任何拿到该文件的人都能解密抓包中的隧道流量。仅用于调试，排查完毕后请关闭并删除该文件", keyLogFile)
+		}
 		log.Printf("PQC mTLS: 已启用")
+		log.Printf("  OpenSSL 配置: %s", pqctls.OpenSSLConfPath())
+		if cfg.TLS.MinSecurityLevel > 0 {
+			groups, sigalgs, err := pqctls.PQCAlgorithms(cfg.TLS.MinSecurityLevel)
+			if err != nil {
+				log.Fatalf("错误: %v", err)
+			}
+			log.Printf("  最低安全级别: %d（密钥交换 %s，签名 %s）", cfg.TLS.MinSecurityLevel, groups, sigalgs)
+		}
 		log.Printf("  证书: %s", cfg.TLS.Cert)
 		log.Printf("  私钥: %s", cfg.TLS.Key)
 		log.Printf("  CA: %s", cfg.TLS.CA)
+		if cfg.TLS.SessionResumption {
+			log.Printf("  会话恢复: 已启用")
+		}
+		if !cfg.TLS.RequireClientCert {
+			log.Printf("  客户端证书: 不要求（单向 TLS）")
+		}
+		if cfg.TLS.MaxHandshakes > 0 {
+			log.Printf("  并发握手上限: %d (策略 %s)", cfg.TLS.MaxHandshakes, cfg.TLS.HandshakeLimitPolicy)
+		}
+	}
+
+	if cfg.ControlWriteTimeout > 0 {
+		log.Printf("控制连接写入超时: %d 秒", cfg.ControlWriteTimeout)
+	}
+
+	// 可选配置项
+	var opts []tunnel.ServerOption
+	if cfg.Name != "" {
+		opts = append(opts, tunnel.WithServerName(cfg.Name))
+	}
+	if cfg.Network != "" {
+		opts = append(opts, tunnel.WithServerNetwork(cfg.Network))
+	}
+	if cfg.ClientPortBindAddr != "" {
+		log.Printf("客户端指定的远程端口绑定地址: %s", cfg.ClientPortBindAddr)
+		opts = append(opts, tunnel.WithServerClientPortBindAddr(cfg.ClientPortBindAddr))
+	}
+	if len(cfg.PortPool) > 0 {
+		if cfg.PublicListen != "" {
+			log.Printf("警告: 已设置全局公开端口 %s，远程端口池不生效", cfg.PublicListen)
+		} else {
+			log.Printf("远程端口池: %d 个端口，请求远程端口 0 的客户端从池中租用", len(cfg.PortPool))
+			opts = append(opts, tunnel.WithServerPortPool(cfg.PortPool))
+		}
+	}
+	socketBuffers := tunnel.SocketBuffers{Read: cfg.SocketReadBuffer, Write: cfg.SocketWriteBuffer, DSCP: cfg.DSCP}
+	if cfg.SocketReadBuffer > 0 || cfg.SocketWriteBuffer > 0 {
+		log.Printf("socket 缓冲区: 接收=%d 字节, 发送=%d 字节", cfg.SocketReadBuffer, cfg.SocketWriteBuffer)
+		opts = append(opts, tunnel.WithServerSocketBuffers(cfg.SocketReadBuffer, cfg.SocketWriteBuffer))
+	}
+	if cfg.DSCP > 0 {
+		log.Printf("socket DSCP 标记: %d", cfg.DSCP)
+		opts = append(opts, tunnel.WithServerDSCP(cfg.DSCP))
+	}
+	if cfg.Transport == tunnel.TransportWebSocket {
+		transport := &tunnel.WebSocketTransport{Path: cfg.WSPath, SocketBuffers: socketBuffers}
+		log.Printf("控制连接传输: %s", transport)
+		opts = append(opts, tunnel.WithServerTransport(transport))
+	}
+	if cfg.PublicQueueSize > 0 || cfg.PublicQueuePolicy != "" || cfg.PublicWorkers > 0 {
+		opts = append(opts, tunnel.WithServerPublicQueue(cfg.PublicQueueSize, cfg.PublicQueuePolicy, cfg.PublicWorkers))
+	}
+	if cfg.PublicClientQueueSize > 0 {
+		log.Printf("全局公开端口公平队列: 每个客户端最多排队 %d 个连接", cfg.PublicClientQueueSize)
+		opts = append(opts, tunnel.WithServerPublicClientQueue(cfg.PublicClientQueueSize))
+	}
+	if cfg.PublicErrorResponse != "" {
+		log.Printf("客户端连接本地服务失败时向外部连接回复: %s", cfg.PublicErrorResponse)
+		opts = append(opts, tunnel.WithServerPublicErrorResponse(cfg.PublicErrorResponse))
+	}
+	if cfg.Limits.PublicSourceMaxConns > 0 || cfg.Limits.PublicSourceConnRate > 0 {
+		log.Printf("按来源 IP 限制公开连接: 并发 %d，速率 %d/秒（突发 %d），0 表示不限制", cfg.Limits.PublicSourceMaxConns, cfg.Limits.PublicSourceConnRate, cfg.Limits.PublicSourceConnBurst)
+		opts = append(opts, tunnel.WithServerPublicSourceLimit(cfg.Limits.PublicSourceMaxConns, cfg.Limits.PublicSourceConnRate, cfg.Limits.PublicSourceConnBurst))
+	}
+	if cfg.Limits.ClientShareMaxConns > 0 || cfg.Limits.ClientShareConnRate > 0 {
+		log.Printf("全局公开端口按客户端限制份额: 并发 %d，速率 %d/秒，0 表示不限制", cfg.Limits.ClientShareMaxConns, cfg.Limits.ClientShareConnRate)
+		opts = append(opts, tunnel.WithServerClientShareLimit(cfg.Limits.ClientShareMaxConns, cfg.Limits.ClientShareConnRate))
+	}
+	if cfg.Limits.MaxForwarders > 0 {
+		log.Printf("转发 goroutine 数上限: %d", cfg.Limits.MaxForwarders)
+		opts = append(opts, tunnel.WithServerMaxForwarders(cfg.Limits.MaxForwarders))
+	}
+	if cfg.NoClientPolicy != "" && cfg.NoClientPolicy != tunnel.NoClientPolicyClose {
+		log.Printf("全局公开端口没有可用客户端时: %s", cfg.NoClientPolicy)
+		opts = append(opts, tunnel.WithServerNoClientPolicy(cfg.NoClientPolicy, time.Duration(cfg.NoClientHoldTimeout)*time.Second))
+		if cfg.NoClientMaxHolds > 0 {
+			opts = append(opts, tunnel.WithServerNoClientMaxHolds(cfg.NoClientMaxHolds))
+		}
+	}
+	if cfg.PausePolicy != "" && cfg.PausePolicy != tunnel.PausePolicyReject {
+		log.Printf("客户端暂停期间的新公开连接: %s", cfg.PausePolicy)
+		opts = append(opts, tunnel.WithServerPausePolicy(cfg.PausePolicy, time.Duration(cfg.PauseHoldTimeout)*time.Second))
+	}
+	if cfg.Limits.MaxBytesPerConn > 0 {
+		log.Printf("单个公开连接传输字节配额: %d", cfg.Limits.MaxBytesPerConn)
+		opts = append(opts, tunnel.WithServerMaxBytesPerConn(cfg.Limits.MaxBytesPerConn))
+	}
+	if cfg.Limits.ConnSetupTimeout > 0 {
+		log.Printf("等待客户端建立本地连接的超时: %d 秒", cfg.Limits.ConnSetupTimeout)
+		opts = append(opts, tunnel.WithServerConnSetupTimeout(time.Duration(cfg.Limits.ConnSetupTimeout)*time.Second))
+	}
+	if cfg.Limits.MaxPendingNewConns > 0 {
+		log.Printf("每个客户端建立中的连接数上限: %d", cfg.Limits.MaxPendingNewConns)
+		opts = append(opts, tunnel.WithServerMaxPendingNewConns(cfg.Limits.MaxPendingNewConns))
+	}
+	if cfg.MaxObservers > 0 {
+		log.Printf("观察者连接数上限: %d", cfg.MaxObservers)
+		opts = append(opts, tunnel.WithServerMaxObservers(cfg.MaxObservers))
+	}
+	if cfg.Limits.InitTimeout > 0 {
+		log.Printf("等待客户端 HELLO 或 INIT 的宽限期: %d 秒", cfg.Limits.InitTimeout)
+		opts = append(opts, tunnel.WithServerInitTimeout(time.Duration(cfg.Limits.InitTimeout)*time.Second))
+	}
+	if cfg.Limits.MaxInitPayload > 0 || cfg.Limits.InitBudgetBytes > 0 || cfg.Limits.InitBudgetTimeout > 0 {
+		log.Printf("INIT 之前的握手预算: INIT 负载上限 %d 字节, 读取 %d 字节, 时限 %d 秒（0 表示默认或不限制）",
+			cfg.Limits.MaxInitPayload, cfg.Limits.InitBudgetBytes, cfg.Limits.InitBudgetTimeout)
+		opts = append(opts, tunnel.WithServerInitBudget(cfg.Limits.MaxInitPayload, cfg.Limits.InitBudgetBytes, time.Duration(cfg.Limits.InitBudgetTimeout)*time.Second))
+	}
+	if cfg.Limits.ConnIdleTimeout > 0 {
+		log.Printf("公开连接空闲超时: %d 秒", cfg.Limits.ConnIdleTimeout)
+		opts = append(opts, tunnel.WithServerConnIdleTimeout(time.Duration(cfg.Limits.ConnIdleTimeout)*time.Second))
+	}
+	if cfg.Limits.ConnMaxLifetime > 0 {
+		log.Printf("公开连接最长存活时间: %d 秒", cfg.Limits.ConnMaxLifetime)
+		opts = append(opts, tunnel.WithServerConnMaxLifetime(time.Duration(cfg.Limits.ConnMaxLifetime)*time.Second))
+	}
+	if cfg.RandomConnIDBase {
+		log.Printf("connID 起点: 每个控制连接随机")
+		opts = append(opts, tunnel.WithServerRandomConnIDBase(true))
+	}
+	if cfg.TraceContext {
+		log.Printf("W3C Trace Context: 为 HTTP 公开连接注入 traceparent")
+		opts = append(opts, tunnel.WithServerTraceContext(true))
+	}
+	if cfg.LazyNewConn {
+		log.Printf("延迟 NEW_CONN: 公开连接发送第一个字节后才通知客户端 (等待 %d 秒，0 表示默认)", cfg.LazyNewConnTimeout)
+		opts = append(opts, tunnel.WithServerLazyNewConn(true, time.Duration(cfg.LazyNewConnTimeout)*time.Second))
+	}
+	if cfg.HTTPCompression {
+		log.Printf("HTTP 响应压缩: 按内容类型 gzip 压缩公开连接的响应")
+		opts = append(opts, tunnel.WithServerHTTPCompression(true))
+	}
+	if cfg.FrameMACKey != "" {
+		log.Printf("控制连接帧完整性校验: 已启用 (HMAC-SHA256)")
+		opts = append(opts, tunnel.WithServerFrameMAC([]byte(cfg.FrameMACKey)))
+	}
+	if cfg.RequirePublicEndpoint && cfg.PublicListen == "" {
+		log.Printf("拒绝没有请求远程端口的客户端")
+		opts = append(opts, tunnel.WithServerRequirePublicEndpoint(true))
+	}
+	if len(cfg.RequiredFeatures) > 0 {
+		features, _ := proto.ParseFeatures(cfg.RequiredFeatures) // 已在加载配置时校验
+		log.Printf("客户端必须支持的协议特性: %s", features)
+		opts = append(opts, tunnel.WithServerRequiredFeatures(features))
+	}
+	if cfg.MaxDataPayload > 0 {
+		log.Printf("DATA 帧负载上限: %d 字节（与客户端协商）", cfg.MaxDataPayload)
+		opts = append(opts, tunnel.WithServerMaxDataPayload(cfg.MaxDataPayload))
+	}
+	if cfg.Limits.MaxControlConnLifetime > 0 {
+		log.Printf("控制连接最大存活时间: %d 秒", cfg.Limits.MaxControlConnLifetime)
+		opts = append(opts, tunnel.WithServerMaxControlConnLifetime(time.Duration(cfg.Limits.MaxControlConnLifetime)*time.Second))
+	}
+	if cfg.HealthCheckInterval > 0 {
+		log.Printf("客户端健康检查间隔: %d 秒", cfg.HealthCheckInterval)
+		opts = append(opts, tunnel.WithServerHealthCheck(time.Duration(cfg.HealthCheckInterval)*time.Second))
+	}
+	if cfg.PublicListen != "" {
+		if cfg.GlobalRouting != "" {
+			log.Printf("全局公开端口路由策略: %s", cfg.GlobalRouting)
+			opts = append(opts, tunnel.WithServerGlobalRouting(cfg.GlobalRouting))
+		} else {
+			log.Printf("全局公开端口路由策略: 未配置（多个客户端匹配同一连接时拒绝该连接，多副本部署请配置 global_routing）")
+		}
+	}
+	if cfg.DuplicateIdentityPolicy != "" && cfg.DuplicateIdentityPolicy != tunnel.DuplicateIdentityAllow {
+		log.Printf("重复身份策略: %s", cfg.DuplicateIdentityPolicy)
+		opts = append(opts, tunnel.WithServerDuplicateIdentityPolicy(cfg.DuplicateIdentityPolicy))
+	}
+	if cfg.DecodeErrorLimit > 0 {
+		log.Printf("解码错误限制: 同一来源累计 %d 次后拒绝重连 (窗口 %d 秒，0 表示默认)", cfg.DecodeErrorLimit, cfg.DecodeErrorBackoff)
+		opts = append(opts, tunnel.WithServerDecodeErrorLimit(cfg.DecodeErrorLimit, time.Duration(cfg.DecodeErrorBackoff)*time.Second))
+	}
+	if cfg.Limits.MaxFrameRate > 0 {
+		log.Printf("控制连接帧速率上限: %d 帧/秒 (策略 %s)", cfg.Limits.MaxFrameRate, cfg.FrameRatePolicy)
+		opts = append(opts, tunnel.WithServerMaxFrameRate(cfg.Limits.MaxFrameRate, cfg.FrameRatePolicy))
+	}
+	if cfg.ControlWriteTimeout > 0 {
+		opts = append(opts, tunnel.WithServerControlWriteTimeout(time.Duration(cfg.ControlWriteTimeout)*time.Second))
+	}
+	if cfg.ShutdownTimeout > 0 {
+		log.Printf("关闭清理超时: %d 秒", cfg.ShutdownTimeout)
+		opts = append(opts, tunnel.WithServerShutdownTimeout(time.Duration(cfg.ShutdownTimeout)*time.Second))
+	}
+	if cfg.MetricsListen != "" {
+		opts = append(opts, tunnel.WithServerMetricsListen(cfg.MetricsListen))
+	}
+	if cfg.FramePayloadHistogram {
+		opts = append(opts, tunnel.WithServerFramePayloadHistogram(true))
+	}
+	if cfg.StrictAuxListeners {
+		opts = append(opts, tunnel.WithServerStrictAuxListeners(true))
+	}
+	if cfg.EnablePprof {
+		opts = append(opts, tunnel.WithServerPprof(true))
+	}
+	if cfg.EnableStatusUI {
+		opts = append(opts, tunnel.WithServerStatusUI(true))
+	}
+	if cfg.AdminToken != "" {
+		opts = append(opts, tunnel.WithServerAdminToken(cfg.AdminToken))
+	}
+	if cfg.PolicyFile != "" {
+		policy, err := tunnel.LoadPolicyFile(cfg.PolicyFile)
+		if err != nil {
+			log.Fatalf("加载配额策略失败: %v", err)
+		}
+		log.Printf("配额策略: %s (%d 个身份，撤销在线客户端=%v)", cfg.PolicyFile, len(policy.Clients), cfg.PolicyRevokeConnected)
+		opts = append(opts, tunnel.WithServerPolicy(policy), tunnel.WithServerPolicyReload(cfg.PolicyFile, cfg.PolicyRevokeConnected))
+	}
+	if publicTLSEnabled(cfg) {
+		store, err := tunnel.NewPublicCertStore(cfg.PublicTLS.Cert, cfg.PublicTLS.Key, publicCertFiles(cfg))
+		if err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		log.Printf("全局公开端口终止 TLS: 默认证书 %q, 按 SNI 选择证书的主机名 %v（按 SNI 路由）", cfg.PublicTLS.Cert, store.Hostnames())
+		opts = append(opts, tunnel.WithServerPublicCertStore(store))
+	}
+	if cfg.PublicFallback.BodyFile != "" {
+		fallback, err := tunnel.NewPublicFallback(cfg.PublicFallback.Status, cfg.PublicFallback.ContentType, cfg.PublicFallback.BodyFile)
+		if err != nil {
+			log.Fatalf("错误: %v", err)
+		}
+		log.Printf("没有可用客户端时回复静态页面: %s", cfg.PublicFallback.BodyFile)
+		opts = append(opts, tunnel.WithServerPublicFallback(fallback))
+	}
+	if cfg.PortFile != "" || cfg.PortWebhook != "" {
+		log.Printf("端口分配通知: 文件=%q webhook=%q", cfg.PortFile, cfg.PortWebhook)
+		opts = append(opts, tunnel.WithServerPortNotify(cfg.PortFile, cfg.PortWebhook))
+	}
+	if cfg.OTLP.Endpoint != "" {
+		opts = append(opts, tunnel.WithServerOTLPMetrics(tunnel.OTLPConfig{
+			Endpoint: cfg.OTLP.Endpoint,
+			Interval: time.Duration(cfg.OTLP.Interval) * time.Second,
+			Headers:  cfg.OTLP.Headers,
+		}))
+	}
+	if cfg.EventWebhook != "" {
+		log.Printf("生命周期事件导出: webhook=%q", cfg.EventWebhook)
+		opts = append(opts, tunnel.WithServerEventSink(tunnel.NewWebhookEventSink(cfg.EventWebhook)))
+	}
+	if cfg.MeteringFile != "" || cfg.MeteringWebhook != "" {
+		log.Printf("计量记录: 文件=%q webhook=%q 间隔=%d秒（0 表示默认 60 秒）", cfg.MeteringFile, cfg.MeteringWebhook, cfg.MeteringInterval)
+		opts = append(opts, tunnel.WithServerMetering(cfg.MeteringFile, cfg.MeteringWebhook, time.Duration(cfg.MeteringInterval)*time.Second))
+	}
+	if cfg.TLS.MinSecurityLevel > 0 {
+		opts = append(opts, tunnel.WithServerTLSMinSecurityLevel(cfg.TLS.MinSecurityLevel))
+	}
+	if cfg.TLS.SessionResumption {
+		opts = append(opts, tunnel.WithServerTLSSessionResumption(true))
+	}
+	if !cfg.TLS.RequireClientCert {
+		opts = append(opts, tunnel.WithServerTLSRequireClientCert(false))
+	}
+	if cfg.TLS.MaxHandshakes > 0 {
+		opts = append(opts, tunnel.WithServerMaxHandshakes(cfg.TLS.MaxHandshakes, cfg.TLS.HandshakeLimitPolicy))
+	}
+	if cfg.TLS.LogPeerCertChain {
+		log.Printf("记录客户端证书链: 已启用")
+		opts = append(opts, tunnel.WithServerLogPeerCertChain(true))
+	}
+	// 日志文件在收到 SIGHUP 时按原路径重新打开，配合 logrotate 轮转
+	var logFiles []*tunnel.LogFile
+	if cfg.AccessLog != "" {
+		accessLogFile, err := tunnel.OpenLogFile(cfg.AccessLog)
+		if err != nil {
+			log.Fatalf("打开访问日志文件失败: %v", err)
+		}
+		defer accessLogFile.Close()
+		logFiles = append(logFiles, accessLogFile)
+		log.Printf("访问日志: %s", cfg.AccessLog)
+		opts = append(opts, tunnel.WithServerAccessLog(accessLogFile))
+	}
+	if cfg.SecurityLog != "" {
+		securityLogFile, err := tunnel.OpenLogFile(cfg.SecurityLog)
+		if err != nil {
+			log.Fatalf("打开安全日志文件失败: %v", err)
+		}
+		defer securityLogFile.Close()
+		logFiles = append(logFiles, securityLogFile)
+		log.Printf("安全日志: %s", cfg.SecurityLog)
+		opts = append(opts, tunnel.WithServerSecurityLog(securityLogFile))
+	}
+	if cfg.FrameTrace != "" {
+		frameTraceFile, err := tunnel.OpenLogFile(cfg.FrameTrace)
+		if err != nil {
+			log.Fatalf("打开帧跟踪文件失败: %v", err)
+		}
+		defer frameTraceFile.Close()
+		logFiles = append(logFiles, frameTraceFile)
+		log.Printf("帧跟踪: %s", cfg.FrameTrace)
+		opts = append(opts, tunnel.WithServerFrameTracer(tunnel.NewFrameTraceWriter(frameTraceFile)))
 	}
 
 	// 创建并运行服务器
 	var server *tunnel.Server
 	if cfg.TLS.Enabled {
-		server = tunnel.NewServerWithTLS(cfg.ControlListen, cfg.PublicListen, cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA)
+		server = tunnel.NewServerWithTLS(cfg.ControlListen, cfg.PublicListen, cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA, opts...)
 	} else {
-		server = tunnel.NewServer(cfg.ControlListen, cfg.PublicListen)
+		server = tunnel.NewServer(cfg.ControlListen, cfg.PublicListen, opts...)
 	}
+
+	// SIGHUP 重新打开日志文件，重新加载配置文件（若有）和配额策略
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			reopenLogFiles(logFiles)
+			if configFile != "" {
+				log.Printf("收到 SIGHUP，重新加载配置文件...")
+				cfg = reloadServerConfig(server, configFile, cfg)
+			}
+			if cfg.PolicyFile == "" {
+				continue
+			}
+			log.Printf("收到 SIGHUP，重新加载配额策略...")
+			if err := server.ReloadPolicy(); err != nil {
+				log.Printf("重新加载配额策略失败，保留原策略: %v", err)
+			}
+		}
+	}()
+
+	// SIGUSR1 将所有客户端的状态快照写入日志
+	watchDumpState(server)
+
 	if err := server.Run(ctx); err != nil {
 		// context.Canceled 是正常的退出情况（如 Ctrl+C），不视为错误
 		if err != context.Canceled {
 			log.Printf("服务器运行错误: %v", err)
-			os.Exit(1)
+			return 1
 		}
 	}
 
 	log.Printf("服务器已退出")
+	return 0
+}
+
+// reopenLogFiles 按原路径重新打开日志文件（logrotate 重命名后，之后的记录写入新文件），失败时继续写入原文件
+func reopenLogFiles(files []*tunnel.LogFile) {
+	for _, f := range files {
+		if err := f.Reopen(); err != nil {
+			log.Printf("重新打开日志文件 %s 失败，继续写入原文件: %v", f.Path(), err)
+			continue
+		}
+		log.Printf("已重新打开日志文件: %s", f.Path())
+	}
+}
+
+// validateServer 执行 validate 子命令：校验配置及其引用的证书、策略文件，不监听端口，配置有效时返回 0
+func validateServer(args []string) int {
+	fs := cli.NewFlagSet("server", "validate", "校验配置（--config 指定的配置文件或命令行参数，参数与 run 相同）及其引用的证书和策略文件后退出，不监听端口")
+	cfg, configFile := parseServerFlags(fs, args)
+	if err := checkControlExposure(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "配置无效: %v\n", err)
+		return 1
+	}
+	if err := checkServerFiles(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "配置无效: %v\n", err)
+		return 1
+	}
+	if configFile != "" {
+		fmt.Printf("配置有效: %s\n", configFile)
+	} else {
+		fmt.Printf("配置有效\n")
+	}
+	return 0
+}
+
+// checkControlExposure 拒绝在非回环地址上暴露未启用 mTLS 的控制端口，设置 allow_insecure_control 时只记录警告
+func checkControlExposure(cfg *config.ServerConfig) error {
+	err := config.ValidateControlExposure(cfg.ControlListen, cfg.TLS.Enabled, cfg.TLS.RequireClientCert)
+	if err != nil && cfg.AllowInsecureControl {
+		log.Printf("警告: 控制端口 %s 对外暴露且未通过 mTLS 认证客户端（已设置 allow_insecure_control），请确认网络已隔离", cfg.ControlListen)
+		return nil
+	}
+	return err
+}
+
+// checkServerFiles 校验配置引用的证书、私钥和策略文件（run 在启动阶段做同样的检查）
+func checkServerFiles(cfg *config.ServerConfig) error {
+	if cfg.TLS.Enabled {
+		if err := pqctls.Ready(); err != nil {
+			return fmt.Errorf("PQC mTLS 初始化失败: %v", err)
+		}
+		if err := pqctls.CheckCertificateFiles(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA); err != nil {
+			return fmt.Errorf("PQC mTLS 证书配置无效: %v", err)
+		}
+		if cfg.TLS.MinSecurityLevel > 0 {
+			if _, _, err := pqctls.PQCAlgorithms(cfg.TLS.MinSecurityLevel); err != nil {
+				return err
+			}
+		}
+	}
+	// 单向 TLS 下服务器没有其他手段认证没有证书的客户端，策略必须拒绝空身份（run 时由服务器检查）
+	anonymous := cfg.TLS.Enabled && !cfg.TLS.RequireClientCert
+	if anonymous && cfg.PolicyFile == "" {
+		return fmt.Errorf("tls.require_client_cert 为 false 时必须配置拒绝空身份的 policy_file")
+	}
+	if cfg.PolicyFile != "" {
+		policy, err := tunnel.LoadPolicyFile(cfg.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("加载配额策略失败: %v", err)
+		}
+		if anonymous && policy.AllowsAnonymous() {
+			return fmt.Errorf("tls.require_client_cert 为 false 时配额策略必须拒绝空身份（策略不能包含 default 或列出空身份）")
+		}
+	}
+	if publicTLSEnabled(cfg) {
+		if _, err := tunnel.NewPublicCertStore(cfg.PublicTLS.Cert, cfg.PublicTLS.Key, publicCertFiles(cfg)); err != nil {
+			return err
+		}
+	}
+	if cfg.PublicFallback.BodyFile != "" {
+		if _, err := tunnel.NewPublicFallback(cfg.PublicFallback.Status, cfg.PublicFallback.ContentType, cfg.PublicFallback.BodyFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reloadServerConfig 重新读取配置文件，将可热加载的配置项应用到运行中的服务器（不断开已连接的客户端），
+// 并记录需要重启才能生效的修改。返回当前生效的配置：可热加载的配置项取新值，其余保持原值
+func reloadServerConfig(server *tunnel.Server, path string, cur *config.ServerConfig) *config.ServerConfig {
+	next, err := config.LoadServerConfig(path)
+	if err != nil {
+		log.Printf("重新加载配置文件失败，保留当前配置: %v", err)
+		return cur
+	}
+	for _, field := range config.ServerRestartRequired(cur, next) {
+		log.Printf("警告: 配置项 %s 已修改，需要重启才能生效", field)
+	}
+
+	server.SetControlWriteTimeout(time.Duration(next.ControlWriteTimeout) * time.Second)
+	server.SetShutdownTimeout(time.Duration(next.ShutdownTimeout) * time.Second)
+	server.SetMaxControlConnLifetime(time.Duration(next.Limits.MaxControlConnLifetime) * time.Second)
+	server.SetFrameRateLimit(next.Limits.MaxFrameRate, next.FrameRatePolicy)
+	server.SetPolicyRevoke(next.PolicyRevokeConnected)
+	// 每次重新加载都重新读取证书文件（文件路径不变时同样生效，例如证书续期）
+	publicTLS := cur.PublicTLS
+	switch {
+	case publicTLSEnabled(cur) && publicTLSEnabled(next):
+		if err := server.ReloadPublicCerts(next.PublicTLS.Cert, next.PublicTLS.Key, publicCertFiles(next)); err != nil {
+			log.Printf("重新加载公开端口 TLS 证书失败，保留原证书: %v", err)
+		} else {
+			publicTLS = next.PublicTLS
+		}
+	case publicTLSEnabled(cur) != publicTLSEnabled(next):
+		log.Printf("警告: 启用或停用公开端口 TLS（public_tls）需要重启才能生效")
+	}
+
+	applied := *cur
+	applied.ControlWriteTimeout = next.ControlWriteTimeout
+	applied.ShutdownTimeout = next.ShutdownTimeout
+	applied.Limits.MaxControlConnLifetime = next.Limits.MaxControlConnLifetime
+	applied.Limits.MaxFrameRate = next.Limits.MaxFrameRate
+	applied.FrameRatePolicy = next.FrameRatePolicy
+	applied.PolicyRevokeConnected = next.PolicyRevokeConnected
+	applied.PublicTLS = publicTLS
+	log.Printf("配置文件已重新加载: %s", path)
+	return &applied
+}
+
+// publicTLSEnabled 判断是否在全局公开端口上终止 TLS（配置了默认证书或按主机名的证书）
+func publicTLSEnabled(cfg *config.ServerConfig) bool {
+	return cfg.PublicTLS.Cert != "" || cfg.PublicTLS.Key != "" || len(cfg.PublicTLS.Certs) > 0
+}
+
+// publicCertFiles 将配置文件的 public_tls.certs 转换为按主机名的证书文件
+func publicCertFiles(cfg *config.ServerConfig) map[string]tunnel.PublicCertFiles {
+	hosts := make(map[string]tunnel.PublicCertFiles, len(cfg.PublicTLS.Certs))
+	for host, c := range cfg.PublicTLS.Certs {
+		hosts[host] = tunnel.PublicCertFiles{Cert: c.Cert, Key: c.Key}
+	}
+	return hosts
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"reverse-tunnel/internal/cli"
+	"reverse-tunnel/internal/pqctls"
+	"reverse-tunnel/internal/tunnel"
+)
+
+// 自检参数
+const (
+	selfTestTimeout     = 30 * time.Second // 自检的总超时
+	selfTestPayloadSize = 64 * 1024        // 往返校验的数据量
+)
+
+// selfTestCommand 执行 selftest 子命令
+func selfTestCommand(args []string) int {
+	fs := cli.NewFlagSet("server", "selftest", "在本进程内启动服务器、客户端和内部回显服务，通过公开端口校验往返数据后退出（成功退出码 0）。控制端口和公开端口使用临时分配的空闲端口，不影响正在运行的实例")
+	useTLS := fs.Bool("tls", false, "控制连接使用 PQC mTLS（临时生成的证书），校验 OpenSSL/oqs-provider 和握手")
+	fs.Parse(args)
+	return runSelfTest(*useTLS)
+}
+
+// runSelfTest 在本进程内启动服务器、指向内部回显服务的客户端，通过公开端口发送随机数据并校验回显，返回进程退出码
+// useTLS 为 true 时控制连接使用 PQC mTLS（证书为临时生成的 ML-DSA-65 自签名证书），覆盖 OpenSSL/oqs-provider 和握手
+// 控制端口和公开端口使用临时分配的空闲端口，不影响正在运行的实例
+func runSelfTest(useTLS bool) int {
+	start := time.Now()
+	summary, err := selfTest(useTLS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "自检失败: %v\n", err)
+		return 1
+	}
+	fmt.Printf("自检通过 (%v): %s\n", time.Since(start).Round(time.Millisecond), summary)
+	return 0
+}
+
+// selfTest 执行自检，成功时返回结果摘要
+func selfTest(useTLS bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	// 内部回显服务
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("启动回显服务失败: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	publicPort, err := freePort()
+	if err != nil {
+		return "", err
+	}
+
+	// 客户端请求的公开端口就绪后通知
+	assigned := make(chan tunnel.PortEvent, 1)
+	serverOpts := []tunnel.ServerOption{tunnel.WithServerPortCallback(func(ev tunnel.PortEvent) {
+		if ev.Event == "assigned" {
+			select {
+			case assigned <- ev:
+			default:
+			}
+		}
+	})}
+
+	mode := "TCP"
+	var server *tunnel.Server
+	var client *tunnel.Client
+	if useTLS {
+		if err := pqctls.Ready(); err != nil {
+			return "", fmt.Errorf("PQC mTLS 初始化失败: %v", err)
+		}
+		dir, err := os.MkdirTemp("", "reverse-tunnel-self-test-")
+		if err != nil {
+			return "", fmt.Errorf("创建临时目录失败: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		certFile, keyFile, err := writeSelfTestCert(dir)
+		if err != nil {
+			return "", err
+		}
+		// 同一张自签名证书同时作为双方的证书和 CA
+		transport := &tunnel.PQCTLSTransport{CertFile: certFile, KeyFile: keyFile, CAFile: certFile}
+		controlListener, err := transport.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", fmt.Errorf("启动 PQC mTLS 控制端口失败: %v", err)
+		}
+		controlAddr := controlListener.Addr().String()
+		server = tunnel.NewServerWithTLS(controlAddr, "", certFile, keyFile, certFile, append(serverOpts, tunnel.WithServerControlListener(controlListener))...)
+		client = tunnel.NewClientWithTLS(controlAddr, echo.Addr().String(), publicPort, certFile, keyFile, certFile, "127.0.0.1")
+		mode = "PQC mTLS"
+	} else {
+		controlListener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", fmt.Errorf("启动控制端口失败: %v", err)
+		}
+		controlAddr := controlListener.Addr().String()
+		server = tunnel.NewServer(controlAddr, "", append(serverOpts, tunnel.WithServerControlListener(controlListener))...)
+		client = tunnel.NewClient(controlAddr, echo.Addr().String(), publicPort)
+	}
+
+	// 控制端口已在上面绑定，客户端第一次连接即可成功
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- server.Run(ctx) }()
+	go client.Run(ctx)
+	defer func() {
+		cancel()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		client.Shutdown(shutdownCtx)
+		server.Shutdown(shutdownCtx)
+	}()
+
+	var ev tunnel.PortEvent
+	select {
+	case ev = <-assigned:
+	case err := <-serverErr:
+		return "", fmt.Errorf("服务器启动失败: %v", err)
+	case <-ctx.Done():
+		return "", fmt.Errorf("%v 内客户端未完成注册和端口分配（检查上方日志中的握手或连接错误）", selfTestTimeout)
+	}
+
+	// 通过公开端口发送随机数据，校验回显
+	payload := make([]byte, selfTestPayloadSize)
+	if _, err := rand.Read(payload); err != nil {
+		return "", fmt.Errorf("生成测试数据失败: %v", err)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("127.0.0.1:%d", ev.Port))
+	if err != nil {
+		return "", fmt.Errorf("连接公开端口失败: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	roundTripStart := time.Now()
+	go conn.Write(payload)
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return "", fmt.Errorf("读取回显失败: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		return "", fmt.Errorf("回显数据与发送的数据不一致")
+	}
+	roundTrip := time.Since(roundTripStart)
+
+	summary := fmt.Sprintf("控制连接 %s，公开端口 %d，往返 %d 字节用时 %v", mode, ev.Port, len(payload), roundTrip.Round(time.Microsecond))
+	if useTLS {
+		for _, st := range pqctls.HandshakeStats() {
+			if st.Role == pqctls.RoleClient && st.Outcome == pqctls.OutcomeOK {
+				summary += fmt.Sprintf("，密钥交换组 %s", st.Group)
+				break
+			}
+		}
+	}
+	return summary, nil
+}
+
+// writeSelfTestCert 在 dir 中写入临时生成的 ML-DSA-65 自签名证书和私钥，返回文件路径
+func writeSelfTestCert(dir string) (certFile, keyFile string, err error) {
+	certPEM, keyPEM, err := pqctls.GenerateSelfSignedCert("reverse-tunnel-self-test", time.Hour)
+	if err != nil {
+		return "", "", fmt.Errorf("生成自检证书失败: %v", err)
+	}
+	certFile = filepath.Join(dir, "self-test.crt")
+	keyFile = filepath.Join(dir, "self-test.key")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		return "", "", fmt.Errorf("写入自检证书失败: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return "", "", fmt.Errorf("写入自检私钥失败: %v", err)
+	}
+	log.Printf("自检证书已生成: %s", certFile)
+	return certFile, keyFile, nil
+}
+
+// freePort 返回 127.0.0.1 上当前空闲的 TCP 端口
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("分配临时端口失败: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,69 @@
+// Package cli 是 cmd/server 和 cmd/client 共用的最小子命令分发器（基于标准库 flag，每个子命令使用自己的 FlagSet）
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Command 一个子命令
+type Command struct {
+	Name    string                  // 子命令名称
+	Summary string                  // 一行说明（显示在命令列表中）
+	Run     func(args []string) int // 执行子命令，args 为子命令之后的参数，返回进程退出码
+}
+
+// Output 命令列表和错误提示的输出目标（测试中替换）
+var Output io.Writer = os.Stderr
+
+// Dispatch 按第一个参数选择子命令并执行，返回进程退出码
+// 第一个参数为空或以 - 开头时执行默认子命令 def（兼容不带子命令的旧用法，例如 server --config=server.json）；
+// help 或 help <子命令> 输出命令列表或该子命令的参数说明；未知子命令输出命令列表并返回 2（与 flag 解析错误相同）
+func Dispatch(prog string, commands []Command, def string, args []string) int {
+	name := def
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		if len(args) == 0 {
+			Usage(prog, commands, def)
+			return 0
+		}
+		name, args = args[0], []string{"-h"}
+	}
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd.Run(args)
+		}
+	}
+	fmt.Fprintf(Output, "未知的子命令: %s\n\n", name)
+	Usage(prog, commands, def)
+	return 2
+}
+
+// Usage 输出命令列表
+func Usage(prog string, commands []Command, def string) {
+	fmt.Fprintf(Output, "用法: %s <子命令> [参数]\n\n子命令:\n", prog)
+	for _, cmd := range commands {
+		summary := cmd.Summary
+		if cmd.Name == def {
+			summary += "（默认）"
+		}
+		fmt.Fprintf(Output, "  %-10s %s\n", cmd.Name, summary)
+	}
+	fmt.Fprintf(Output, "\n不带子命令时执行 %s。%s help <子命令> 或 %s <子命令> -h 查看子命令的参数\n", def, prog, prog)
+}
+
+// NewFlagSet 创建子命令的 FlagSet，-h 输出 "用法: prog cmd [参数]"、说明和参数列表
+func NewFlagSet(prog, name, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(prog+" "+name, flag.ExitOnError)
+	fs.SetOutput(Output)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: %s %s [参数]\n\n%s\n\n参数:\n", prog, name, summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
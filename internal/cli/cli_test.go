@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestDispatch 测试按第一个参数选择子命令：不带子命令或以 - 开头时执行默认子命令，未知子命令返回 2，help 输出命令列表
+func TestDispatch(t *testing.T) {
+	var out bytes.Buffer
+	defer func(w io.Writer) { Output = w }(Output)
+	Output = &out
+
+	var ran string
+	var gotArgs []string
+	cmd := func(name string) Command {
+		return Command{Name: name, Summary: name + " 说明", Run: func(args []string) int {
+			ran, gotArgs = name, args
+			return 0
+		}}
+	}
+	commands := []Command{cmd("run"), cmd("validate")}
+
+	tests := []struct {
+		args     []string
+		wantCmd  string
+		wantArgs string
+		wantCode int
+	}{
+		{nil, "run", "", 0},
+		{[]string{"--config=server.json"}, "run", "--config=server.json", 0},
+		{[]string{"validate", "--config=server.json"}, "validate", "--config=server.json", 0},
+		{[]string{"help", "validate"}, "validate", "-h", 0},
+		{[]string{"bogus"}, "", "", 2},
+		{[]string{"help"}, "", "", 0},
+	}
+	for _, tt := range tests {
+		ran, gotArgs = "", nil
+		out.Reset()
+		code := Dispatch("server", commands, "run", tt.args)
+		if code != tt.wantCode || ran != tt.wantCmd || strings.Join(gotArgs, " ") != tt.wantArgs {
+			t.Errorf("Dispatch(%q) = %d, 执行 %q %q; 期望 %d, %q %q", tt.args, code, ran, gotArgs, tt.wantCode, tt.wantCmd, tt.wantArgs)
+		}
+		if tt.wantCmd == "" && !strings.Contains(out.String(), "validate   validate 说明") {
+			t.Errorf("Dispatch(%q) 应输出命令列表: %q", tt.args, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "run 说明（默认）") {
+		t.Errorf("命令列表应标出默认子命令: %q", out.String())
+	}
+}
@@ -3,13 +3,136 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// ConfigAuthEnv 远程配置请求的 Authorization 头取值所在的环境变量（例如 "Bearer xxx"）
+const ConfigAuthEnv = "RT_CONFIG_AUTH"
+
+// 远程配置获取超时和大小上限
+const (
+	remoteConfigTimeout = 10 * time.Second
+	maxConfigSize       = 1 << 20
 )
 
 // ServerConfig 服务器配置
 type ServerConfig struct {
+	Name string `json:"name"` // 实例名称（可选），标记运行日志的每一行，并作为指标的 name 标签和访问/安全日志记录的 name 字段
+
 	ControlListen string `json:"control_listen"` // 控制端口监听地址（默认 :7000）
 	PublicListen  string `json:"public_listen"`  // 公开端口监听地址（可选，留空则由客户端指定）
+	GlobalRouting string `json:"global_routing"` // 全局公开端口的路由策略：hostname、round-robin 或 weighted（留空时多个客户端匹配同一连接则拒绝）
+
+	AllowInsecureControl bool `json:"allow_insecure_control"` // 允许控制端口在非回环地址上以明文或不验证客户端证书的方式监听（只记录警告，默认拒绝启动）
+
+	ClientPortBindAddr string `json:"client_port_bind_addr"` // 客户端指定的远程端口绑定的 IP 地址（可选，留空则绑定所有接口）
+
+	PortPool []int `json:"port_pool"` // 租给客户端的固定远程端口池（可选），请求远程端口 0 的客户端从池中租用一个空闲端口
+
+	RequirePublicEndpoint bool `json:"require_public_endpoint"` // public_listen 为空时断开没有请求远程端口的客户端（默认只记录警告）
+
+	ControlWriteTimeout int `json:"control_write_timeout"` // 控制连接单帧写入超时（秒，0 表示不设超时）
+	ShutdownTimeout     int `json:"shutdown_timeout"`      // 关闭时清理资源的最长时间（秒，0 表示默认 10 秒）
+
+	AccessLog   string `json:"access_log"`   // 公开连接访问日志文件路径（JSON Lines，留空则不记录）
+	SecurityLog string `json:"security_log"` // 被拒绝的控制连接握手的安全日志文件路径（JSON Lines，留空则不记录）
+	FrameTrace  string `json:"frame_trace"`  // 控制连接帧跟踪文件路径（每个帧一行，不含负载内容，用于排查帧错位，留空则不记录）
+
+	MetricsListen      string `json:"metrics_listen"`       // 指标/状态 HTTP 监听地址（例如 127.0.0.1:9100，留空则不启用）
+	StrictAuxListeners bool   `json:"strict_aux_listeners"` // 指标/状态监听器绑定失败时使服务器启动失败（默认记录警告并继续）
+	EnablePprof        bool   `json:"enable_pprof"`         // 在指标/状态监听器上挂载 /debug/pprof/（需要 admin_token）
+	EnableStatusUI     bool   `json:"enable_status_ui"`     // 在指标/状态监听器上挂载内置 HTML 状态页 /ui/（需要 admin_token）
+	AdminToken         string `json:"admin_token"`          // 管理接口令牌（Authorization: Bearer <token>）
+
+	OTLP OTLPConfig `json:"otlp"` // OpenTelemetry 指标推送（可选，与 metrics_listen 导出相同的指标）
+
+	FramePayloadHistogram bool `json:"frame_payload_histogram"` // 在 /metrics 中输出 DATA 帧负载大小直方图（默认 false）
+
+	Network string `json:"network"` // 监听网络类型：tcp（默认，双栈）、tcp4 或 tcp6
+
+	SocketReadBuffer  int `json:"socket_read_buffer"`  // 控制端口和公开端口 socket 的接收缓冲区大小（字节，0 表示系统默认）
+	SocketWriteBuffer int `json:"socket_write_buffer"` // 控制端口和公开端口 socket 的发送缓冲区大小（字节，0 表示系统默认）
+	DSCP              int `json:"dscp"`                // 控制端口和公开端口 socket 的 DSCP 标记（0-63，0 表示不标记）
+
+	Transport string `json:"transport"` // 控制连接的传输：tcp（默认）或 websocket
+	WSPath    string `json:"ws_path"`   // WebSocket 传输的升级路径（默认 /tunnel）
+
+	PublicQueueSize   int    `json:"public_queue_size"`   // 公开连接队列容量（0 表示默认 100）
+	PublicQueuePolicy string `json:"public_queue_policy"` // 队列满时的策略：block（默认，阻塞 accept）或 reject（关闭新连接）
+	PublicWorkers     int    `json:"public_workers"`      // 处理公开连接的 worker 数量（0 表示默认 8）
+
+	PublicClientQueueSize int `json:"public_client_queue_size"` // 全局公开端口每个客户端最多排队的连接数（0 表示不启用公平队列）
+
+	PublicErrorResponse string `json:"public_error_response"` // 客户端连接本地服务失败时向外部连接回复的错误：空（默认，直接关闭）或 http（HTTP 502）
+
+	NoClientPolicy      string `json:"no_client_policy"`       // 全局公开端口没有可用客户端时的策略：close（默认）、hold（等待客户端连接）或 error（回复 HTTP 503）
+	NoClientHoldTimeout int    `json:"no_client_hold_timeout"` // hold 策略等待客户端连接的最长时间（秒，0 表示默认 5 秒）
+	NoClientMaxHolds    int    `json:"no_client_max_holds"`    // hold 策略同时等待客户端的连接数上限（0 表示默认 1024）
+
+	PausePolicy      string `json:"pause_policy"`       // 客户端被管理接口暂停期间新公开连接的策略：reject（默认）或 hold（等待客户端恢复）
+	PauseHoldTimeout int    `json:"pause_hold_timeout"` // hold 策略等待客户端恢复的最长时间（秒，0 表示默认 30 秒）
+
+	MaxObservers int `json:"max_observers"` // 观察者连接数上限（0 表示不接受观察者），观察者只接收隧道事件，不分配端口、不参与转发
+
+	RandomConnIDBase bool `json:"random_conn_id_base"` // 每个控制连接的 connID 从随机起点开始（默认 false，从 1 开始）
+
+	TraceContext bool `json:"trace_context"` // 为 HTTP 公开连接的第一个请求注入 W3C traceparent 请求头（默认 false）
+
+	HTTPCompression bool `json:"http_compression"` // 按内容类型 gzip 压缩 HTTP/1.1 公开连接的响应（默认 false）
+
+	LazyNewConn        bool `json:"lazy_new_conn"`         // 公开连接发送第一个字节后才通知客户端建立本地连接（默认 false）
+	LazyNewConnTimeout int  `json:"lazy_new_conn_timeout"` // 等待首字节的最长时间（秒，0 表示默认 10 秒）
+
+	FrameMACKey string `json:"frame_mac_key"` // 控制连接帧完整性校验的共享密钥（仅用于未启用 TLS 的明文模式，留空则不启用，客户端须使用相同的密钥）
+
+	HealthCheckInterval int `json:"health_check_interval"` // 向客户端发送健康检查的间隔（秒，0 表示不检查），本地服务不可用的客户端不参与全局公开端口的路由
+
+	FrameRatePolicy string `json:"frame_rate_policy"` // limits.max_frame_rate 超限时的策略：throttle（默认，延迟处理）或 drop（断开）
+
+	DecodeErrorLimit   int `json:"decode_error_limit"`   // 同一来源累计多少次控制帧解码错误后暂时拒绝其重连（0 表示不限制）
+	DecodeErrorBackoff int `json:"decode_error_backoff"` // 解码错误的计数窗口和拒绝重连的时长（秒，0 表示默认 60 秒）
+
+	RequiredFeatures []string `json:"required_features"` // 客户端必须支持的协议特性（例如 data_keepalive），未进行特性协商或不支持时断开控制连接
+	MaxDataPayload   int      `json:"max_data_payload"`  // 能接收的 DATA 帧负载上限（字节，0 表示默认 4096，最大 32768），在 HELLO 中与客户端协商，使用双方的较小值
+
+	PolicyFile            string `json:"policy_file"`             // 按客户端身份（证书 CN）的配额策略文件路径（JSON，留空则不限制）
+	PolicyRevokeConnected bool   `json:"policy_revoke_connected"` // 重新加载策略后断开身份已被撤销的在线客户端
+
+	DuplicateIdentityPolicy string `json:"duplicate_identity_policy"` // 相同身份（证书 CN）的客户端重复连接时的策略：allow（默认）、reject-new 或 replace-old
+
+	PortFile    string `json:"port_file"`    // 隧道就绪后写入端口分配（JSON）的文件路径（留空则不写）
+	PortWebhook string `json:"port_webhook"` // 端口分配/释放时 POST 事件的 webhook URL（留空则不推送）
+
+	EventWebhook string `json:"event_webhook"` // 批量 POST 隧道生命周期事件的 webhook URL（留空则不导出）
+
+	MeteringFile     string `json:"metering_file"`     // 按身份累计用量的计量文件（启动时恢复并周期性写入，留空则不写）
+	MeteringWebhook  string `json:"metering_webhook"`  // 周期性 POST 计量快照的 webhook URL（留空则不推送）
+	MeteringInterval int    `json:"metering_interval"` // 写入/推送计量快照的间隔（秒，0 表示默认 60 秒）
+
+	Limits ServerLimits `json:"limits"` // 连接数、速率、超时和字节数限制
+
+	// 旧版本写在顶层的限制配置项，加载时合并到 Limits 后清零，只用于兼容旧配置文件（请改用 limits 块）
+	PublicSourceMaxConns   int   `json:"public_source_max_conns"`
+	PublicSourceConnRate   int   `json:"public_source_conn_rate"`
+	PublicSourceConnBurst  int   `json:"public_source_conn_burst"`
+	MaxForwarders          int   `json:"max_forwarders"`
+	MaxBytesPerConn        int64 `json:"max_bytes_per_conn"`
+	ConnSetupTimeout       int   `json:"conn_setup_timeout"`
+	ConnIdleTimeout        int   `json:"conn_idle_timeout"`
+	ConnMaxLifetime        int   `json:"conn_max_lifetime"`
+	InitTimeout            int   `json:"init_timeout"`
+	MaxControlConnLifetime int   `json:"max_control_conn_lifetime"`
+	MaxFrameRate           int   `json:"max_frame_rate"`
 	
 	// PQC mTLS 配置（可选）
 	TLS struct {
@@ -17,14 +140,109 @@ type ServerConfig struct {
 		Cert    string `json:"cert"`    // 服务器证书文件路径
 		Key     string `json:"key"`     // 服务器私钥文件路径
 		CA      string `json:"ca"`      // CA 证书文件路径（用于验证客户端证书）
+
+		SessionResumption bool `json:"session_resumption"`  // 允许客户端恢复 TLS 会话（默认禁用，每次完整握手）
+		MinSecurityLevel  int  `json:"min_security_level"`  // 要求的最低 NIST 安全级别（1-5，0 表示接受全部参数集）
+		RequireClientCert bool `json:"require_client_cert"` // 要求客户端证书（默认 true，即 mTLS；false 为单向 TLS）
+
+		MaxHandshakes        int    `json:"max_handshakes"`         // 同时进行的握手数上限（0 表示不并发握手，逐个完成）
+		HandshakeLimitPolicy string `json:"handshake_limit_policy"` // 握手数达到上限时的策略：queue（默认，暂停接受新连接）或 reject（关闭新连接）
+
+		KeyLogFile string `json:"key_log_file"` // TLS 密钥日志文件路径（仅用于调试，留空则使用 SSLKEYLOGFILE 环境变量，都未设置时不记录）
+
+		LogPeerCertChain bool `json:"log_peer_cert_chain"` // 客户端注册成功时把其完整证书链写入运行日志和安全日志（用于合规审计，默认关闭）
 	} `json:"tls"`
+
+	// 全局公开端口终止 TLS 的配置（可选，标准 TLS，例如通配符证书 *.tunnel.example.com）
+	PublicTLS struct {
+		Cert string `json:"cert"` // 默认证书文件路径（留空且 certs 为空则原样转发公开连接）
+		Key  string `json:"key"`  // 默认证书的私钥文件路径
+
+		Certs map[string]PublicTLSCert `json:"certs"` // 按 SNI 选择的每个主机名的证书（键为主机名，支持 *.example.com 通配符），没有匹配时使用默认证书
+	} `json:"public_tls"`
+
+	// 全局公开端口没有可用客户端时回复的静态 HTTP 页面（可选，例如维护页）
+	PublicFallback struct {
+		BodyFile    string `json:"body_file"`    // 响应体文件路径（留空则不启用）
+		Status      int    `json:"status"`       // HTTP 状态码（200-599，0 表示默认 503）
+		ContentType string `json:"content_type"` // 响应的 Content-Type（留空则为 text/html; charset=utf-8）
+	} `json:"public_fallback"`
 }
 
 // ClientConfig 客户端配置
 type ClientConfig struct {
-	Server     string `json:"server"`      // 服务器地址（例如 1.2.3.4:7000，必填）
-	Local      string `json:"local"`       // 本地服务地址（例如 127.0.0.1:80，必填）
-	RemotePort int    `json:"remote_port"` // 远程端口（服务器要监听的端口，0 表示由服务器指定）
+	Name string `json:"name"` // 实例名称（可选），标记运行日志的每一行，并作为指标的 name 标签
+
+	Server     AddrList `json:"server"`      // 服务器地址（例如 1.2.3.4:7000，必填；多个服务器以逗号分隔或写为数组，连接失败时依次切换）
+	Local      string   `json:"local"`       // 本地服务地址（例如 127.0.0.1:80，必填；多个后端以逗号分隔）
+	RemotePort int      `json:"remote_port"` // 远程端口（服务器要监听的端口，0 表示由服务器指定）
+
+	LeaseRemotePort bool `json:"lease_remote_port"` // remote_port 为 0 时从服务器的端口池租用一个远程端口（服务器需配置 port_pool）
+
+	ExitOnFatal bool `json:"exit_on_fatal"` // 遇到致命错误（身份被拒绝、证书无效、协议不兼容）时以非零状态退出，而不是重连（默认关闭）
+
+	LocalReadyTimeout int  `json:"local_ready_timeout"` // 连接服务器前等待本地服务就绪的超时（秒，0 表示不预检）
+	LocalTCPFastOpen  bool `json:"local_tcp_fastopen"`  // 拨号本地服务时启用 TCP Fast Open（仅 Linux）
+
+	LocalDialSource string `json:"local_dial_source"` // 拨号本地服务使用的源 IP（多网卡主机，留空则由系统选择）
+
+	LocalPoolSize  int  `json:"local_pool_size"`  // 本地连接池大小（保持的预热连接数，0 表示不启用）
+	LocalPoolReuse bool `json:"local_pool_reuse"` // 公开连接关闭后将本地连接放回池中复用（仅适用于无状态协议）
+
+	LocalMultiplex bool `json:"local_multiplex"` // 与本地服务保持一条持久连接，公开连接作为其上按隧道帧格式收发的流（后端必须实现该约定，默认关闭）
+
+	LocalBalance          string `json:"local_balance"`           // local 包含多个后端时的负载均衡策略：round_robin（默认）或 random
+	LocalUnhealthyTimeout int    `json:"local_unhealthy_timeout"` // 被动健康检查：拨号失败的后端被跳过的时长（秒，0 表示不启用）
+
+	CircuitBreakerFailures int `json:"circuit_breaker_failures"` // 本地服务熔断：连续拨号失败多少次后熔断（0 表示不启用）
+	CircuitBreakerCooldown int `json:"circuit_breaker_cooldown"` // 熔断持续时间，之后试探恢复（秒，0 表示默认 30）
+
+	LocalDialWorkers int `json:"local_dial_workers"` // 同时拨号本地服务的连接数上限，超出的排队等待（0 表示不启用，在帧读取循环中逐个拨号）
+	LocalDialQueue   int `json:"local_dial_queue"`   // 排队等待拨号的连接数上限，超过时直接关闭新连接（0 表示默认 1024）
+
+	ControlWriteTimeout int `json:"control_write_timeout"` // 控制连接单帧写入超时（秒，0 表示不设超时）
+
+	FrameTrace string `json:"frame_trace"` // 控制连接帧跟踪文件路径（每个帧一行，不含负载内容，用于排查帧错位，留空则不记录）
+
+	Network string `json:"network"` // 连接服务器的网络类型：tcp（默认）、tcp4 或 tcp6
+
+	SocketReadBuffer  int `json:"socket_read_buffer"`  // 控制连接和本地连接 socket 的接收缓冲区大小（字节，0 表示系统默认）
+	SocketWriteBuffer int `json:"socket_write_buffer"` // 控制连接和本地连接 socket 的发送缓冲区大小（字节，0 表示系统默认）
+	DSCP              int `json:"dscp"`                // 控制连接和本地连接 socket 的 DSCP 标记（0-63，0 表示不标记）
+
+	Transport string `json:"transport"` // 连接服务器的传输：tcp（默认）或 websocket，必须与服务器一致
+	WSPath    string `json:"ws_path"`   // WebSocket 传输的升级路径（默认 /tunnel）
+
+	FrameMACKey string `json:"frame_mac_key"` // 控制连接帧完整性校验的共享密钥（仅用于未启用 TLS 的明文模式，留空则不启用，必须与服务器一致）
+
+	HTTPProxy string `json:"http_proxy"` // 上游 HTTP 代理（可选，http://[用户名:密码@]主机:端口），通过 CONNECT 连接服务器
+
+	Hostname  string   `json:"hostname"`  // 主机名路由键（可选，支持 *.example.com，服务器使用全局公开端口时按 SNI/Host 路由）
+	Hostnames []string `json:"hostnames"` // 更多主机名路由键（与 hostname 合并，启用 mTLS 时必须被客户端证书的 SAN 覆盖）
+	Weight    int      `json:"weight"`    // 负载均衡权重（1-1000，0 表示默认权重 1），多个客户端匹配同一路由键时服务器按权重分配公开连接
+
+	DataKeepalive int `json:"data_keepalive"` // 数据连接保活间隔（秒，0 表示不启用）
+	FrameBuffer   int `json:"frame_buffer"`   // 已读取、等待处理的控制连接帧数上限（0 表示默认 10）
+
+	RequiredFeatures []string `json:"required_features"` // 服务器必须支持的协议特性（例如 assignment_info），服务器不支持时断开并重连
+	MaxDataPayload   int      `json:"max_data_payload"`  // 能接收的 DATA 帧负载上限（字节，0 表示默认 4096，最大 32768），在 HELLO 中与服务器协商，使用双方的较小值
+
+	PprofListen string `json:"pprof_listen"` // pprof 调试监听地址（例如 127.0.0.1:6060，留空则不启用，需要 admin_token）
+	AdminToken  string `json:"admin_token"`  // 调试接口令牌（Authorization: Bearer <token>）
+
+	FramePayloadHistogram bool `json:"frame_payload_histogram"` // 在 pprof 监听器的 /metrics 中输出 DATA 帧负载大小直方图（默认 false）
+
+	OTLP OTLPConfig `json:"otlp"` // OpenTelemetry 指标推送（可选，与 pprof 监听器的 /metrics 导出相同的指标）
+
+	LocalRoutes []LocalRouteConfig `json:"local_routes"` // 按公开连接来源 IP 选择本地服务（按顺序匹配，未命中使用 local）
+	HostRoutes  []HostRouteConfig  `json:"host_routes"`  // 按公开连接主机名（SNI/Host）选择本地服务（优先于 local_routes，主机名同时注册到服务器）
+
+	Limits ClientLimits `json:"limits"` // 本地连接和控制连接的超时限制
+
+	// 旧版本写在顶层的限制配置项，加载时合并到 Limits 后清零，只用于兼容旧配置文件（请改用 limits 块）
+	MaxControlConnLifetime int `json:"max_control_conn_lifetime"`
+	ConnIdleTimeout        int `json:"conn_idle_timeout"`
+	ConnMaxLifetime        int `json:"conn_max_lifetime"`
 	
 	// PQC mTLS 配置（可选）
 	TLS struct {
@@ -33,40 +251,536 @@ type ClientConfig struct {
 		Key        string `json:"key"`            // 客户端私钥文件路径
 		CA         string `json:"ca"`            // CA 证书文件路径（用于验证服务器证书）
 		ServerName string `json:"server_name"`    // 服务器名称（TLS SNI，留空则使用服务器地址）
+
+		// 固定的服务器证书 SHA-256 指纹（十六进制，可用冒号分隔），握手后指纹不匹配时拒绝连接；
+		// ca 为空时只校验指纹，同时指定 ca 时两者都必须通过
+		ServerFingerprint string `json:"server_fingerprint"`
+
+		SessionResumption bool `json:"session_resumption"` // 重连时恢复 TLS 会话（默认禁用，需要服务器同时启用）
+		MinSecurityLevel  int  `json:"min_security_level"` // 要求的最低 NIST 安全级别（1-5，0 表示接受全部参数集）
+		ConnectTimeout    int  `json:"connect_timeout"`    // 连接服务器的 TCP 超时（秒，0 表示默认 10 秒）
+		HandshakeTimeout  int  `json:"handshake_timeout"`  // TLS 握手超时（秒，0 表示默认 10 秒）
+
+		KeyLogFile string `json:"key_log_file"` // TLS 密钥日志文件路径（仅用于调试，留空则使用 SSLKEYLOGFILE 环境变量，都未设置时不记录）
 	} `json:"tls"`
+
+	// 连接本地服务的 TLS 配置（可选，标准 TLS，用于本地服务为 HTTPS/mTLS 的情况）
+	LocalTLS struct {
+		Enabled    bool   `json:"enabled"`     // 是否使用 TLS 连接本地服务
+		Cert       string `json:"cert"`        // 客户端证书文件路径（本地服务要求 mTLS 时指定）
+		Key        string `json:"key"`         // 客户端私钥文件路径
+		CA         string `json:"ca"`          // CA 证书文件路径（留空则使用系统根证书）
+		ServerName string `json:"server_name"` // 本地服务名称（留空则使用本地地址的主机名）
+	} `json:"local_tls"`
+}
+
+// PublicTLSCert 全局公开端口某个主机名使用的证书（配置文件的 public_tls.certs）
+type PublicTLSCert struct {
+	Cert string `json:"cert"` // 证书文件路径
+	Key  string `json:"key"`  // 私钥文件路径
+}
+
+// ServerLimits 服务器的限制配置（配置文件的 limits 块），所有配置项的零值都表示不限制或不启用
+type ServerLimits struct {
+	PublicSourceMaxConns  int `json:"public_source_max_conns"`  // 每个来源 IP 同时打开的公开连接数上限
+	PublicSourceConnRate  int `json:"public_source_conn_rate"`  // 每个来源 IP 每秒新建的公开连接数上限
+	PublicSourceConnBurst int `json:"public_source_conn_burst"` // 每个来源 IP 允许的突发连接数（0 表示等于 public_source_conn_rate）
+
+	ClientShareMaxConns int `json:"client_share_max_conns"` // 每个客户端在全局公开端口上同时打开的连接数上限
+	ClientShareConnRate int `json:"client_share_conn_rate"` // 每个客户端在全局公开端口上每秒新建的连接数上限
+
+	MaxForwarders int `json:"max_forwarders"` // 转发公开连接数据的 goroutine 数上限（每个公开连接一个）
+
+	MaxBytesPerConn int64 `json:"max_bytes_per_conn"` // 单个公开连接最多传输的字节数（两个方向合计），超出后强制关闭
+
+	ConnSetupTimeout int `json:"conn_setup_timeout"` // 发送 NEW_CONN 后等待客户端建立本地连接的最长时间（秒），超时后关闭公开连接
+	ConnIdleTimeout  int `json:"conn_idle_timeout"`  // 公开连接的空闲超时（秒），两个方向都没有数据超过该时间时关闭
+	ConnMaxLifetime  int `json:"conn_max_lifetime"`  // 公开连接的最长存活时间（秒），到期后关闭
+
+	MaxPendingNewConns int `json:"max_pending_new_conns"` // 每个客户端已发送 NEW_CONN、尚未确认建立的连接数上限，达到上限时暂停向其转发新的公开连接
+
+	InitTimeout            int `json:"init_timeout"`              // 控制连接建立后等待客户端首个 HELLO 或 INIT 的宽限期（秒），超时后断开控制连接
+	MaxControlConnLifetime int `json:"max_control_conn_lifetime"` // 控制连接最大存活时间（秒）
+	MaxFrameRate           int `json:"max_frame_rate"`            // 每个控制连接每秒最多处理的帧数
+
+	MaxInitPayload    int `json:"max_init_payload"`    // INIT 帧负载的字节数上限（不能超过协议上限 4096）
+	InitBudgetBytes   int `json:"init_budget_bytes"`   // 控制连接完成 INIT 之前最多读取的字节数（含帧头），超出后断开控制连接
+	InitBudgetTimeout int `json:"init_budget_timeout"` // 控制连接建立后完成 INIT 的最长时间（秒），超时后断开控制连接
+}
+
+// OTLPConfig OpenTelemetry 指标推送配置（配置文件的 otlp 块，服务器和客户端相同）
+type OTLPConfig struct {
+	Endpoint string            `json:"endpoint"` // OTLP/HTTP 指标接收地址（例如 http://otel-collector:4318/v1/metrics，留空则不推送）
+	Interval int               `json:"interval"` // 推送间隔（秒，0 表示默认 60）
+	Headers  map[string]string `json:"headers"`  // 附加的请求头（例如认证令牌）
+}
+
+// ClientLimits 客户端的限制配置（配置文件的 limits 块），所有配置项的零值都表示不限制
+type ClientLimits struct {
+	ConnIdleTimeout        int `json:"conn_idle_timeout"`         // 本地连接的空闲超时（秒），两个方向都没有数据超过该时间时关闭
+	ConnMaxLifetime        int `json:"conn_max_lifetime"`         // 本地连接的最长存活时间（秒），到期后关闭
+	MaxControlConnLifetime int `json:"max_control_conn_lifetime"` // 控制连接最大存活时间（秒）
+}
+
+// mergeLegacyLimits 把旧版本写在顶层的限制配置项合并到 config 的 Limits 字段（按字段名对应）并清零顶层配置项，之后只读取 Limits；
+// 同一配置项在两处设置了不同的值时返回错误。加入 limits 块之后新增的配置项没有对应的顶层配置项
+func mergeLegacyLimits(config interface{}) error {
+	rv := reflect.ValueOf(config).Elem()
+	limits := rv.FieldByName("Limits")
+	t := limits.Type()
+	for i := 0; i < t.NumField(); i++ {
+		legacy := rv.FieldByName(t.Field(i).Name)
+		if !legacy.IsValid() || legacy.IsZero() {
+			continue
+		}
+		field := limits.Field(i)
+		if !field.IsZero() && field.Int() != legacy.Int() {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			return fmt.Errorf("配置项 limits.%s (%d) 与顶层的 %s (%d) 冲突，请只保留 limits.%s", name, field.Int(), name, legacy.Int(), name)
+		}
+		field.Set(legacy)
+		legacy.SetZero()
+	}
+	return nil
+}
+
+// AddrList 地址列表，以逗号分隔的字符串保存
+// JSON 中可以写为单个字符串（兼容只有一个地址的旧配置，也可以以逗号分隔多个地址）或字符串数组
+type AddrList string
+
+// UnmarshalJSON 接受字符串或字符串数组
+func (l *AddrList) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case nil:
+		return nil
+	case string:
+		*l = AddrList(v)
+		return nil
+	case []interface{}:
+		addrs := make([]string, 0, len(v))
+		for _, item := range v {
+			addr, ok := item.(string)
+			if !ok {
+				return &json.UnmarshalTypeError{Value: "array of " + jsonKind(item), Type: reflect.TypeOf(addrs)}
+			}
+			addrs = append(addrs, addr)
+		}
+		*l = AddrList(strings.Join(addrs, ","))
+		return nil
+	default:
+		return &json.UnmarshalTypeError{Value: jsonKind(v), Type: reflect.TypeOf("")}
+	}
+}
+
+// jsonKind 返回解析为 interface{} 的 JSON 值的类型名称（用于类型错误信息）
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "null"
+	}
+}
+
+// LocalRouteConfig 按来源 IP 路由的规则配置
+type LocalRouteConfig struct {
+	CIDR  string `json:"cidr"`  // 来源 IP 网段（例如 10.0.0.0/8）
+	Local string `json:"local"` // 命中时连接的本地服务地址
+}
+
+// HostRouteConfig 按主机名路由的规则配置
+type HostRouteConfig struct {
+	Hostname string `json:"hostname"` // 主机名路由键（例如 app.example.com，支持 *.example.com）
+	Local    string `json:"local"`    // 命中时连接的本地服务地址
 }
 
-// LoadServerConfig 从 JSON 文件加载服务器配置
+// readConfigSource 读取配置内容
+// 支持本地文件路径、"-"（标准输入）以及 http(s):// URL
+func readConfigSource(configPath string) ([]byte, error) {
+	switch {
+	case configPath == "-":
+		return io.ReadAll(io.LimitReader(os.Stdin, maxConfigSize))
+	case strings.HasPrefix(configPath, "http://") || strings.HasPrefix(configPath, "https://"):
+		return fetchRemoteConfig(configPath)
+	default:
+		return os.ReadFile(configPath)
+	}
+}
+
+// fetchRemoteConfig 通过 HTTP(S) 获取配置，非 2xx 响应视为错误
+// 如果设置了环境变量 RT_CONFIG_AUTH，其值作为 Authorization 请求头发送
+func fetchRemoteConfig(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth := os.Getenv(ConfigAuthEnv); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	client := &http.Client{Timeout: remoteConfigTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("远程配置返回 HTTP %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxConfigSize))
+}
+
+// serverConfigLimits 服务器配置中有上限的配置项（其余整数配置项只要求不为负数）
+var serverConfigLimits = map[string]int64{
+	"tls.min_security_level": 5,
+	"public_fallback.status": 599,
+}
+
+// clientConfigLimits 客户端配置中有上限的配置项
+var clientConfigLimits = map[string]int64{
+	"remote_port":            65535,
+	"weight":                 maxWeight,
+	"tls.min_security_level": 5,
+}
+
+// DefaultControlWriteTimeout 服务器 control_write_timeout 的默认值（秒）：停止读取的客户端在该时长后被断开，
+// 不会让向它转发数据的 goroutine 无限期阻塞
+const DefaultControlWriteTimeout = 30
+
+// LoadServerConfig 从 JSON 配置加载服务器配置
+// 配置项拼写错误（未知的配置项）、类型不匹配或取值超出范围时返回指出该配置项的错误
+// configPath 可以是本地文件路径、"-"（标准输入）或 http(s):// URL
 func LoadServerConfig(configPath string) (*ServerConfig, error) {
-	data, err := os.ReadFile(configPath)
+	data, err := readConfigSource(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("读取配置文件失败: %w", err)
 	}
 
 	var config ServerConfig
-	if err := json.Unmarshal(data, &config); err != nil {
+	// 未配置时默认要求客户端证书（mTLS），并为控制连接写入设置超时（显式配置为 0 时不设超时）
+	config.TLS.RequireClientCert = true
+	config.ControlWriteTimeout = DefaultControlWriteTimeout
+	if err := decodeConfig(data, &config); err != nil {
 		return nil, fmt.Errorf("解析配置文件失败: %w", err)
 	}
+	if err := validateRanges(&config, serverConfigLimits); err != nil {
+		return nil, err
+	}
+	if err := mergeLegacyLimits(&config); err != nil {
+		return nil, err
+	}
 
 	// 设置默认值
 	if config.ControlListen == "" {
 		config.ControlListen = ":7000"
 	}
+	if err := ValidateNetwork(config.Network); err != nil {
+		return nil, err
+	}
+	if err := ValidateClientPortBindAddr(config.ClientPortBindAddr); err != nil {
+		return nil, err
+	}
+	if err := ValidatePortPool(config.PortPool); err != nil {
+		return nil, err
+	}
+	if err := ValidateQueuePolicy(config.PublicQueuePolicy); err != nil {
+		return nil, err
+	}
+	if err := ValidateFrameRatePolicy(config.FrameRatePolicy); err != nil {
+		return nil, err
+	}
+	if err := ValidateDuplicateIdentityPolicy(config.DuplicateIdentityPolicy); err != nil {
+		return nil, err
+	}
+	if err := ValidateGlobalRouting(config.GlobalRouting); err != nil {
+		return nil, err
+	}
+	if err := ValidatePublicErrorResponse(config.PublicErrorResponse); err != nil {
+		return nil, err
+	}
+	if err := ValidateNoClientPolicy(config.NoClientPolicy); err != nil {
+		return nil, err
+	}
+	if err := ValidatePausePolicy(config.PausePolicy); err != nil {
+		return nil, err
+	}
+	if err := ValidateMaxBytesPerConn(config.Limits.MaxBytesPerConn); err != nil {
+		return nil, err
+	}
+	if err := ValidateMaxInitPayload(config.Limits.MaxInitPayload); err != nil {
+		return nil, err
+	}
+	if err := ValidateRequiredFeatures(config.RequiredFeatures); err != nil {
+		return nil, err
+	}
+	if err := ValidateMaxDataPayload(config.MaxDataPayload); err != nil {
+		return nil, err
+	}
+	if err := ValidateOTLP(config.OTLP); err != nil {
+		return nil, err
+	}
+	if err := ValidateSocketBuffers(config.SocketReadBuffer, config.SocketWriteBuffer); err != nil {
+		return nil, err
+	}
+	if err := ValidateDSCP(config.DSCP); err != nil {
+		return nil, err
+	}
+	if err := ValidateTransport(config.Transport, config.TLS.Enabled); err != nil {
+		return nil, err
+	}
+	if err := ValidateFrameMACKey(config.FrameMACKey, config.TLS.Enabled); err != nil {
+		return nil, err
+	}
+	if err := ValidateHandshakeLimitPolicy(config.TLS.HandshakeLimitPolicy); err != nil {
+		return nil, err
+	}
+	if err := ValidatePublicFallback(config.PublicFallback.BodyFile, config.PublicFallback.Status); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
 
-// LoadClientConfig 从 JSON 文件加载客户端配置
+// ServerHotReloadFields 服务器运行时可重新加载的配置项（JSON 字段名），其余配置项修改后需要重启才能生效
+// policy_file 指向的策略文件内容同样可以重新加载，但 policy_file 路径本身需要重启；
+// public_tls 的证书只能在启动时已启用公开端口 TLS 时重新加载，启用或停用公开端口 TLS 需要重启
+var ServerHotReloadFields = []string{
+	"control_write_timeout",
+	"shutdown_timeout",
+	"limits.max_control_conn_lifetime",
+	"limits.max_frame_rate",
+	"frame_rate_policy",
+	"policy_revoke_connected",
+	"public_tls.cert",
+	"public_tls.key",
+	"public_tls.certs",
+}
+
+// ServerRestartRequired 返回 old 和 new 之间修改过、但需要重启才能生效的配置项（JSON 字段名，嵌套字段形如 tls.cert）
+func ServerRestartRequired(old, new *ServerConfig) []string {
+	hot := make(map[string]bool, len(ServerHotReloadFields))
+	for _, name := range ServerHotReloadFields {
+		hot[name] = true
+	}
+	var changed []string
+	diffFields(reflect.ValueOf(*old), reflect.ValueOf(*new), "", func(name string) {
+		if !hot[name] {
+			changed = append(changed, name)
+		}
+	})
+	return changed
+}
+
+// diffFields 逐个比较结构体的字段，对值不同的字段以 JSON 字段名调用 fn（嵌套结构体递归比较）
+func diffFields(a, b reflect.Value, prefix string, fn func(name string)) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := prefix + strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if t.Field(i).Type.Kind() == reflect.Struct {
+			diffFields(a.Field(i), b.Field(i), name+".", fn)
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			fn(name)
+		}
+	}
+}
+
+// ValidateMaxBytesPerConn 校验单个公开连接的传输字节配额（0 表示不限制，不能为负数）
+func ValidateMaxBytesPerConn(n int64) error {
+	if n < 0 {
+		return fmt.Errorf("无效的 max_bytes_per_conn: %d（不能为负数）", n)
+	}
+	return nil
+}
+
+// ValidateSocketBuffers 校验 socket 缓冲区大小（0 表示系统默认，不能为负数）
+func ValidateSocketBuffers(read, write int) error {
+	if read < 0 || write < 0 {
+		return fmt.Errorf("无效的 socket 缓冲区大小: socket_read_buffer=%d, socket_write_buffer=%d（不能为负数）", read, write)
+	}
+	return nil
+}
+
+// ValidateDSCP 校验 socket 的 DSCP 标记（0 表示不标记，DSCP 为 6 位）
+func ValidateDSCP(dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return fmt.Errorf("无效的 dscp: %d（必须在 0-63 之间）", dscp)
+	}
+	return nil
+}
+
+// ValidateFrameBuffer 校验客户端等待处理的控制连接帧数上限（0 表示默认，不能为负数）
+func ValidateFrameBuffer(n int) error {
+	if n < 0 {
+		return fmt.Errorf("无效的 frame_buffer: %d（不能为负数）", n)
+	}
+	return nil
+}
+
+// ValidateQueuePolicy 校验公开连接队列策略（空表示默认的 block）
+func ValidateQueuePolicy(policy string) error {
+	switch policy {
+	case "", "block", "reject":
+		return nil
+	default:
+		return fmt.Errorf("public_queue_policy 必须是 block 或 reject，得到 %q", policy)
+	}
+}
+
+// ValidateFrameRatePolicy 校验帧速率超限策略（空表示默认的 throttle）
+func ValidateFrameRatePolicy(policy string) error {
+	switch policy {
+	case "", "throttle", "drop":
+		return nil
+	default:
+		return fmt.Errorf("frame_rate_policy 必须是 throttle 或 drop，得到 %q", policy)
+	}
+}
+
+// ValidateDuplicateIdentityPolicy 校验重复身份策略（空表示默认的 allow）
+func ValidateDuplicateIdentityPolicy(policy string) error {
+	switch policy {
+	case "", "allow", "reject-new", "replace-old":
+		return nil
+	default:
+		return fmt.Errorf("duplicate_identity_policy 必须是 allow、reject-new 或 replace-old，得到 %q", policy)
+	}
+}
+
+// ValidateGlobalRouting 校验全局公开端口的路由策略（空表示未配置）
+func ValidateGlobalRouting(policy string) error {
+	switch policy {
+	case "", "hostname", "round-robin", "weighted":
+		return nil
+	default:
+		return fmt.Errorf("global_routing 必须是 hostname、round-robin 或 weighted，得到 %q", policy)
+	}
+}
+
+// ValidateHandshakeLimitPolicy 校验并发握手数达到上限时的策略（空表示默认的 queue）
+func ValidateHandshakeLimitPolicy(policy string) error {
+	switch policy {
+	case "", "queue", "reject":
+		return nil
+	default:
+		return fmt.Errorf("tls.handshake_limit_policy 必须是 queue 或 reject，得到 %q", policy)
+	}
+}
+
+// ValidatePublicErrorResponse 校验公开连接错误响应（空表示直接关闭）
+func ValidatePublicErrorResponse(mode string) error {
+	switch mode {
+	case "", "http":
+		return nil
+	default:
+		return fmt.Errorf("public_error_response 必须为空或 http，得到 %q", mode)
+	}
+}
+
+// ValidateNoClientPolicy 校验没有可用客户端时的策略（空表示默认的 close）
+func ValidateNoClientPolicy(policy string) error {
+	switch policy {
+	case "", "close", "hold", "error":
+		return nil
+	default:
+		return fmt.Errorf("no_client_policy 必须是 close、hold 或 error，得到 %q", policy)
+	}
+}
+
+// ValidatePausePolicy 校验客户端暂停期间新公开连接的策略（空表示默认的 reject）
+func ValidatePausePolicy(policy string) error {
+	switch policy {
+	case "", "reject", "hold":
+		return nil
+	default:
+		return fmt.Errorf("pause_policy 必须是 reject 或 hold，得到 %q", policy)
+	}
+}
+
+// ValidatePublicFallback 校验没有可用客户端时回复的静态页面（body_file 为空表示不启用，此时不能设置 status）
+func ValidatePublicFallback(bodyFile string, status int) error {
+	if bodyFile == "" && status != 0 {
+		return fmt.Errorf("设置了 public_fallback.status 但未设置 public_fallback.body_file")
+	}
+	if status != 0 && (status < 200 || status > 599) {
+		return fmt.Errorf("无效的 public_fallback.status: %d（必须在 200-599 之间）", status)
+	}
+	return nil
+}
+
+// ValidateMaxDataPayload 校验 DATA 帧负载上限（0 表示默认，不能超过 proto.MaxDataPayloadSize）
+func ValidateMaxDataPayload(n int) error {
+	if n < 0 || n > proto.MaxDataPayloadSize {
+		return fmt.Errorf("无效的 max_data_payload: %d（必须在 0-%d 之间）", n, proto.MaxDataPayloadSize)
+	}
+	return nil
+}
+
+// ValidateOTLP 校验 OTLP 推送配置：endpoint 为空（不推送）或 http/https URL，interval 不能为负数
+func ValidateOTLP(cfg OTLPConfig) error {
+	if cfg.Interval < 0 {
+		return fmt.Errorf("无效的 otlp.interval: %d（不能为负数）", cfg.Interval)
+	}
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("无效的 otlp.endpoint: %q（必须是 http:// 或 https:// URL）", cfg.Endpoint)
+	}
+	return nil
+}
+
+// ValidateMaxInitPayload 校验 INIT 帧负载上限（0 表示协议上限，不能超过 proto.MaxInitPayloadSize）
+func ValidateMaxInitPayload(n int) error {
+	if n < 0 || n > proto.MaxInitPayloadSize {
+		return fmt.Errorf("无效的 max_init_payload: %d（必须在 0-%d 之间）", n, proto.MaxInitPayloadSize)
+	}
+	return nil
+}
+
+// ValidateRequiredFeatures 校验必需的协议特性名称（见 proto.ParseFeatures）
+func ValidateRequiredFeatures(names []string) error {
+	if _, err := proto.ParseFeatures(names); err != nil {
+		return fmt.Errorf("required_features 无效: %w", err)
+	}
+	return nil
+}
+
+// LoadClientConfig 从 JSON 配置加载客户端配置
+// 与 LoadServerConfig 相同，拒绝未知的配置项并校验取值范围
+// configPath 可以是本地文件路径、"-"（标准输入）或 http(s):// URL
 func LoadClientConfig(configPath string) (*ClientConfig, error) {
-	data, err := os.ReadFile(configPath)
+	data, err := readConfigSource(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("读取配置文件失败: %w", err)
 	}
 
 	var config ClientConfig
-	if err := json.Unmarshal(data, &config); err != nil {
+	if err := decodeConfig(data, &config); err != nil {
 		return nil, fmt.Errorf("解析配置文件失败: %w", err)
 	}
+	if err := validateRanges(&config, clientConfigLimits); err != nil {
+		return nil, err
+	}
+	if err := mergeLegacyLimits(&config); err != nil {
+		return nil, err
+	}
 
 	// 验证必填字段
 	if config.Server == "" {
@@ -75,7 +789,265 @@ func LoadClientConfig(configPath string) (*ClientConfig, error) {
 	if config.Local == "" {
 		return nil, fmt.Errorf("配置文件中 local 字段必填")
 	}
+	if err := config.ExpandLocalTemplates(); err != nil {
+		return nil, err
+	}
+	if err := ValidateNetwork(config.Network); err != nil {
+		return nil, err
+	}
+	if err := ValidateLocalBalance(config.LocalBalance); err != nil {
+		return nil, err
+	}
+	if err := ValidateLocalDialSource(config.LocalDialSource); err != nil {
+		return nil, err
+	}
+	if err := ValidateRequiredFeatures(config.RequiredFeatures); err != nil {
+		return nil, err
+	}
+	if err := ValidateMaxDataPayload(config.MaxDataPayload); err != nil {
+		return nil, err
+	}
+	if err := ValidateOTLP(config.OTLP); err != nil {
+		return nil, err
+	}
+	if err := ValidateSocketBuffers(config.SocketReadBuffer, config.SocketWriteBuffer); err != nil {
+		return nil, err
+	}
+	if err := ValidateDSCP(config.DSCP); err != nil {
+		return nil, err
+	}
+	if err := ValidateFrameBuffer(config.FrameBuffer); err != nil {
+		return nil, err
+	}
+	if err := ValidateTransport(config.Transport, config.TLS.Enabled); err != nil {
+		return nil, err
+	}
+	if err := ValidateFrameMACKey(config.FrameMACKey, config.TLS.Enabled); err != nil {
+		return nil, err
+	}
+	if config.LocalTLS.Enabled && (config.LocalTLS.Cert == "") != (config.LocalTLS.Key == "") {
+		return nil, fmt.Errorf("local_tls 的 cert 和 key 必须同时指定")
+	}
 
 	return &config, nil
 }
 
+// ValidateNetwork 校验网络类型（空表示默认的 tcp）
+func ValidateNetwork(network string) error {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+		return nil
+	default:
+		return fmt.Errorf("network 必须是 tcp、tcp4 或 tcp6，得到 %q", network)
+	}
+}
+
+// ValidateControlExposure 校验控制端口的暴露范围：监听非回环地址（包括留空主机名或 0.0.0.0 等所有接口）时
+// 必须启用 PQC mTLS 并要求客户端证书，否则任何能访问该地址的人都能注册客户端、占用公开端口
+func ValidateControlExposure(addr string, tlsEnabled, requireClientCert bool) error {
+	if tlsEnabled && requireClientCert {
+		return nil
+	}
+	if isLoopbackAddr(addr) {
+		return nil
+	}
+	auth := "未启用 PQC mTLS（tls.enabled）"
+	if tlsEnabled {
+		auth = "未要求客户端证书（tls.require_client_cert）"
+	}
+	return fmt.Errorf("control_listen %q 不是回环地址，但%s，控制端口对外暴露时必须启用 mTLS 认证客户端；"+
+		"只在本机使用时请监听 127.0.0.1，确认网络已隔离时可设置 allow_insecure_control", addr, auth)
+}
+
+// isLoopbackAddr 返回监听地址是否只绑定回环接口（localhost 或回环 IP）
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ValidatePortPool 校验远程端口池：端口在 1-65535 之间且不重复
+func ValidatePortPool(ports []int) error {
+	seen := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("port_pool 中的端口必须在 1-65535 之间，得到 %d", port)
+		}
+		if seen[port] {
+			return fmt.Errorf("port_pool 中的端口 %d 重复", port)
+		}
+		seen[port] = true
+	}
+	return nil
+}
+
+// ParsePortPool 解析以逗号分隔的端口和端口范围（例如 "20000-20099,20200"），用于命令行参数
+func ParsePortPool(spec string) ([]int, error) {
+	var ports []int
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		loStr, hiStr, isRange := strings.Cut(item, "-")
+		lo, err := strconv.Atoi(loStr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的端口 %q", item)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(hiStr); err != nil || hi < lo {
+				return nil, fmt.Errorf("无效的端口范围 %q", item)
+			}
+		}
+		if lo <= 0 || hi > 65535 {
+			return nil, fmt.Errorf("端口必须在 1-65535 之间，得到 %q", item)
+		}
+		for port := lo; port <= hi; port++ {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// ValidateClientPortBindAddr 校验客户端指定的远程端口绑定的地址（空表示所有接口），必须是 IP 地址，IPv6 地址可以带方括号
+func ValidateClientPortBindAddr(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	if net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")) == nil {
+		return fmt.Errorf("client_port_bind_addr 必须是 IP 地址（不含端口，留空表示所有接口），得到 %q", addr)
+	}
+	return nil
+}
+
+// ValidateTransport 校验控制连接的传输（空表示默认的 tcp）
+// PQC mTLS 需要直接持有 TCP socket，不能与 websocket 传输同时使用
+func ValidateTransport(transport string, tlsEnabled bool) error {
+	switch transport {
+	case "", "tcp":
+		return nil
+	case "websocket":
+		if tlsEnabled {
+			return fmt.Errorf("transport 为 websocket 时不支持 PQC mTLS（tls.enabled），需要加密时请在前面部署终止 TLS 的反向代理")
+		}
+		return nil
+	default:
+		return fmt.Errorf("transport 必须是 tcp 或 websocket，得到 %q", transport)
+	}
+}
+
+// maxWeight 客户端负载均衡权重的最大值（与服务器接受的最大权重一致）
+const maxWeight = 1000
+
+// ValidateWeight 校验客户端负载均衡权重（0 表示默认权重，不能为负数或超过 maxWeight）
+func ValidateWeight(weight int) error {
+	if weight < 0 || weight > maxWeight {
+		return fmt.Errorf("无效的 weight: %d（取值范围 0-%d）", weight, maxWeight)
+	}
+	return nil
+}
+
+// minFrameMACKeyLen 帧完整性校验共享密钥的最小长度（字节）
+const minFrameMACKeyLen = 16
+
+// ValidateFrameMACKey 校验控制连接帧完整性校验的共享密钥（空表示不启用）
+// 启用 PQC mTLS 时 TLS 已保证完整性，不能同时配置
+func ValidateFrameMACKey(key string, tlsEnabled bool) error {
+	if key == "" {
+		return nil
+	}
+	if tlsEnabled {
+		return fmt.Errorf("frame_mac_key 仅用于未启用 TLS 的明文模式，启用 PQC mTLS（tls.enabled）时不能同时配置")
+	}
+	if len(key) < minFrameMACKeyLen {
+		return fmt.Errorf("frame_mac_key 太短: %d 字节（至少 %d 字节）", len(key), minFrameMACKeyLen)
+	}
+	return nil
+}
+
+// ValidateLocalBalance 校验本地后端负载均衡策略（空表示默认的 round_robin）
+func ValidateLocalBalance(strategy string) error {
+	switch strategy {
+	case "", "round_robin", "random":
+		return nil
+	default:
+		return fmt.Errorf("local_balance 必须是 round_robin 或 random，得到 %q", strategy)
+	}
+}
+
+// ValidateLocalDialSource 校验本地拨号源地址（空表示由系统选择，否则必须是 IP 地址）
+func ValidateLocalDialSource(source string) error {
+	if source != "" && net.ParseIP(source) == nil {
+		return fmt.Errorf("local_dial_source 必须是 IP 地址，得到 %q", source)
+	}
+	return nil
+}
+
+// ExpandLocalTemplates 展开 local、local_routes 及 host_routes 中的地址模板
+// 目前支持 {remote_port}，替换为 remote_port 的值；模板无效时在加载阶段报错，而不是在每个连接上失败
+// local 包含多个以逗号分隔的后端时逐个展开
+func (c *ClientConfig) ExpandLocalTemplates() error {
+	backends := strings.Split(c.Local, ",")
+	for i, backend := range backends {
+		local, err := ExpandLocalTemplate(strings.TrimSpace(backend), c.RemotePort)
+		if err != nil {
+			return fmt.Errorf("local 地址模板无效: %w", err)
+		}
+		backends[i] = local
+	}
+	c.Local = strings.Join(backends, ",")
+
+	for i, route := range c.LocalRoutes {
+		local, err := ExpandLocalTemplate(route.Local, c.RemotePort)
+		if err != nil {
+			return fmt.Errorf("local_routes[%d] 地址模板无效: %w", i, err)
+		}
+		c.LocalRoutes[i].Local = local
+	}
+	for i, route := range c.HostRoutes {
+		local, err := ExpandLocalTemplate(route.Local, c.RemotePort)
+		if err != nil {
+			return fmt.Errorf("host_routes[%d] 地址模板无效: %w", i, err)
+		}
+		c.HostRoutes[i].Local = local
+	}
+	return nil
+}
+
+// ExpandLocalTemplate 将地址模板中的占位符替换为实际值并校验结果为 host:port
+// 不含占位符的地址原样返回
+func ExpandLocalTemplate(tmpl string, remotePort int) (string, error) {
+	// 内置本地服务（builtin:echo、builtin:http）不是网络地址，只校验名称
+	if strings.HasPrefix(tmpl, "builtin:") {
+		if tmpl != "builtin:echo" && tmpl != "builtin:http" {
+			return "", fmt.Errorf("未知的内置本地服务 %q（支持 builtin:echo、builtin:http）", tmpl)
+		}
+		return tmpl, nil
+	}
+	if !strings.Contains(tmpl, "{") && !strings.Contains(tmpl, "}") {
+		return tmpl, nil
+	}
+
+	addr := tmpl
+	if strings.Contains(addr, "{remote_port}") {
+		if remotePort <= 0 {
+			return "", fmt.Errorf("%q 使用了 {remote_port}，但未指定 remote_port", tmpl)
+		}
+		addr = strings.ReplaceAll(addr, "{remote_port}", strconv.Itoa(remotePort))
+	}
+	if strings.ContainsAny(addr, "{}") {
+		return "", fmt.Errorf("%q 包含未知的占位符（支持 {remote_port}）", tmpl)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", fmt.Errorf("%q 展开后不是有效地址: %w", tmpl, err)
+	}
+	return addr, nil
+}
+
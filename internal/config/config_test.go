@@ -0,0 +1,311 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLoadClientConfigFromFile 测试从本地文件加载配置（原有行为）
+func TestLoadClientConfigFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.json")
+	if err := os.WriteFile(path, []byte(`{"server":"127.0.0.1:7000","local":"127.0.0.1:80"}`), 0644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+
+	cfg, err := LoadClientConfig(path)
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.Server != "127.0.0.1:7000" || cfg.Local != "127.0.0.1:80" {
+		t.Errorf("配置内容不匹配: %+v", cfg)
+	}
+}
+
+// TestLoadServerConfigFromURL 测试从 HTTP URL 加载配置并携带认证头
+func TestLoadServerConfigFromURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"control_listen":":7100"}`))
+	}))
+	defer ts.Close()
+
+	t.Setenv(ConfigAuthEnv, "Bearer secret")
+	cfg, err := LoadServerConfig(ts.URL)
+	if err != nil {
+		t.Fatalf("加载远程配置失败: %v", err)
+	}
+	if cfg.ControlListen != ":7100" {
+		t.Errorf("control_listen 不匹配: %q", cfg.ControlListen)
+	}
+
+	t.Setenv(ConfigAuthEnv, "")
+	_, err = LoadServerConfig(ts.URL)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("非 2xx 响应应返回包含状态码的错误，得到: %v", err)
+	}
+}
+
+// TestRequireClientCertDefault 测试未配置 tls.require_client_cert 时默认要求客户端证书，显式配置为 false 时不要求
+func TestRequireClientCertDefault(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		config string
+		want   bool
+	}{
+		{`{"tls":{"enabled":true}}`, true},
+		{`{"tls":{"enabled":true,"require_client_cert":false}}`, false},
+	} {
+		path := filepath.Join(dir, "server.json")
+		if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+			t.Fatalf("写入配置文件失败: %v", err)
+		}
+		cfg, err := LoadServerConfig(path)
+		if err != nil {
+			t.Fatalf("加载配置 %s 失败: %v", tt.config, err)
+		}
+		if cfg.TLS.RequireClientCert != tt.want {
+			t.Errorf("配置 %s 的 tls.require_client_cert = %v, 期望 %v", tt.config, cfg.TLS.RequireClientCert, tt.want)
+		}
+	}
+}
+
+// TestControlWriteTimeoutDefault 测试未配置 control_write_timeout 时使用默认值，显式配置为 0 时不设超时
+func TestControlWriteTimeoutDefault(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		config string
+		want   int
+	}{
+		{`{}`, DefaultControlWriteTimeout},
+		{`{"control_write_timeout":0}`, 0},
+		{`{"control_write_timeout":5}`, 5},
+	} {
+		path := filepath.Join(dir, "server.json")
+		if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+			t.Fatalf("写入配置文件失败: %v", err)
+		}
+		cfg, err := LoadServerConfig(path)
+		if err != nil {
+			t.Fatalf("加载配置 %s 失败: %v", tt.config, err)
+		}
+		if cfg.ControlWriteTimeout != tt.want {
+			t.Errorf("配置 %s 的 control_write_timeout = %d, 期望 %d", tt.config, cfg.ControlWriteTimeout, tt.want)
+		}
+	}
+}
+
+// TestExpandLocalTemplate 测试本地地址模板的展开和加载阶段校验
+func TestExpandLocalTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		port    int
+		want    string
+		wantErr bool
+	}{
+		{"127.0.0.1:80", 0, "127.0.0.1:80", false},
+		{"127.0.0.1:{remote_port}", 8080, "127.0.0.1:8080", false},
+		{"[::1]:{remote_port}", 8080, "[::1]:8080", false},
+		{"127.0.0.1:{remote_port}", 0, "", true},
+		{"127.0.0.1:{tunnel}", 8080, "", true},
+		{"{remote_port}", 8080, "", true},
+		{"builtin:echo", 0, "builtin:echo", false},
+		{"builtin:http", 8080, "builtin:http", false},
+		{"builtin:ssh", 0, "", true},
+	}
+	for _, tt := range tests {
+		got, err := ExpandLocalTemplate(tt.tmpl, tt.port)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ExpandLocalTemplate(%q, %d) = %q, %v", tt.tmpl, tt.port, got, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "client.json")
+	os.WriteFile(path, []byte(`{"server":"127.0.0.1:7000","local":"127.0.0.1:{remote_port}"}`), 0644)
+	if _, err := LoadClientConfig(path); err == nil {
+		t.Error("未指定 remote_port 时使用 {remote_port} 模板应在加载时失败")
+	}
+}
+
+// TestServerRestartRequired 测试只有不可热加载的配置项被报告为需要重启
+func TestServerRestartRequired(t *testing.T) {
+	old := &ServerConfig{ControlListen: ":7000", Limits: ServerLimits{MaxFrameRate: 100}}
+	old.TLS.Cert = "a.crt"
+
+	next := *old
+	next.Limits.MaxFrameRate = 200
+	next.ControlWriteTimeout = 5
+	next.PolicyRevokeConnected = true
+	if got := ServerRestartRequired(old, &next); len(got) != 0 {
+		t.Errorf("只修改了可热加载的配置项，不应需要重启: %v", got)
+	}
+
+	next.ControlListen = ":7001"
+	next.TLS.Cert = "b.crt"
+	got := ServerRestartRequired(old, &next)
+	if strings.Join(got, ",") != "control_listen,tls.cert" {
+		t.Errorf("期望 [control_listen tls.cert], 得到 %v", got)
+	}
+}
+
+// TestStrictConfig 测试加载配置时拒绝未知的配置项、类型错误和超出范围的取值，错误信息指出出错的配置项
+func TestStrictConfig(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("写入配置文件失败: %v", err)
+		}
+		return path
+	}
+
+	serverTests := []struct {
+		content string
+		want    string
+	}{
+		{`{"control_listn": ":7001"}`, `未知的配置项 "control_listn"（是否为 control_listen？）`},
+		{`{"tls": {"enabled": true, "cret": "a.crt"}}`, `未知的配置项 "cret"（是否为 tls.cert？）`},
+		{`{"completely_unrelated": 1}`, `未知的配置项 "completely_unrelated"`},
+		{`{"shutdown_timeout": "10"}`, "配置项 shutdown_timeout 的类型错误"},
+		{`{"shutdown_timeout": -1}`, "配置项 shutdown_timeout 的取值无效: -1（不能为负数）"},
+		{`{"tls": {"min_security_level": 6}}`, "配置项 tls.min_security_level 的取值无效: 6（不能大于 5）"},
+		{`{"client_port_bind_addr": "10.0.0.1:8080"}`, "client_port_bind_addr 必须是 IP 地址"},
+		{"{\n  \"control_listen\": \":7000\",\n}", "第 3 行"},
+		{`{"control_listen": ":7000"} {}`, "多余的内容"},
+	}
+	for _, tt := range serverTests {
+		_, err := LoadServerConfig(write(tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: 期望错误包含 %q，得到 %v", tt.content, tt.want, err)
+		}
+	}
+
+	_, err := LoadClientConfig(write(`{"server": "127.0.0.1:7000", "local": "127.0.0.1:80", "remote_port": 70000}`))
+	if err == nil || !strings.Contains(err.Error(), "配置项 remote_port 的取值无效") {
+		t.Errorf("超出范围的 remote_port 应被拒绝，得到 %v", err)
+	}
+	_, err = LoadClientConfig(write(`{"server": "127.0.0.1:7000", "local": "127.0.0.1:80", "local_routes": [{"cidr": "10.0.0.0/8", "locla": "127.0.0.1:81"}]}`))
+	if err == nil || !strings.Contains(err.Error(), `未知的配置项 "locla"`) {
+		t.Errorf("嵌套数组中的未知配置项应被拒绝，得到 %v", err)
+	}
+
+	// 仓库中的示例配置必须能通过严格校验
+	for _, example := range []string{"server.json", "server.json.example"} {
+		if _, err := LoadServerConfig(filepath.Join("..", "..", "config", example)); err != nil {
+			t.Errorf("示例配置 %s 加载失败: %v", example, err)
+		}
+	}
+	if _, err := LoadClientConfig(filepath.Join("..", "..", "config", "client.json.example")); err != nil {
+		t.Errorf("示例配置 client.json.example 加载失败: %v", err)
+	}
+}
+
+// TestAllowUnknownFields 测试默认拒绝带有多余配置项的配置并在错误中指出配置项名称，启用 AllowUnknownFields 时忽略并正常加载
+func TestAllowUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.json")
+	content := `{"server": "127.0.0.1:7000", "local": "127.0.0.1:80", "bogus_option": true, "tls": {"future_option": 1}}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+
+	if _, err := LoadClientConfig(path); err == nil || !strings.Contains(err.Error(), "bogus_option") {
+		t.Errorf("多余的配置项应导致加载失败并指出配置项名称，得到 %v", err)
+	}
+
+	AllowUnknownFields = true
+	defer func() { AllowUnknownFields = false }()
+	cfg, err := LoadClientConfig(path)
+	if err != nil {
+		t.Fatalf("启用 AllowUnknownFields 后应忽略未知配置项: %v", err)
+	}
+	if cfg.Server != "127.0.0.1:7000" {
+		t.Errorf("配置内容不匹配: %+v", cfg)
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal([]byte(content), &raw); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	got := strings.Join(unknownFields(raw, reflect.TypeOf(ClientConfig{}), ""), ",")
+	if got != "bogus_option,tls.future_option" {
+		t.Errorf("未知配置项为 %q", got)
+	}
+}
+
+// TestLegacyLimits 测试旧版本写在顶层的限制配置项合并到 limits 块，两处取值不同时加载失败
+func TestLegacyLimits(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("写入配置文件失败: %v", err)
+		}
+		return path
+	}
+
+	cfg, err := LoadServerConfig(write(`{"max_frame_rate": 100, "conn_idle_timeout": 30, "limits": {"conn_idle_timeout": 30, "max_bytes_per_conn": 1024}}`))
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	if cfg.Limits.MaxFrameRate != 100 || cfg.Limits.ConnIdleTimeout != 30 || cfg.Limits.MaxBytesPerConn != 1024 {
+		t.Errorf("合并后的 limits 不匹配: %+v", cfg.Limits)
+	}
+	if cfg.MaxFrameRate != 0 || cfg.ConnIdleTimeout != 0 {
+		t.Errorf("合并后顶层配置项应被清零: max_frame_rate=%d, conn_idle_timeout=%d", cfg.MaxFrameRate, cfg.ConnIdleTimeout)
+	}
+
+	_, err = LoadServerConfig(write(`{"init_timeout": 10, "limits": {"init_timeout": 20}}`))
+	if err == nil || !strings.Contains(err.Error(), "limits.init_timeout (20) 与顶层的 init_timeout (10) 冲突") {
+		t.Errorf("取值冲突应被拒绝，得到 %v", err)
+	}
+	_, err = LoadServerConfig(write(`{"limits": {"max_forwarders": -1}}`))
+	if err == nil || !strings.Contains(err.Error(), "配置项 limits.max_forwarders 的取值无效") {
+		t.Errorf("负数的 limits.max_forwarders 应被拒绝，得到 %v", err)
+	}
+
+	client, err := LoadClientConfig(write(`{"server": "127.0.0.1:7000", "local": "127.0.0.1:80", "conn_max_lifetime": 60}`))
+	if err != nil {
+		t.Fatalf("加载客户端配置失败: %v", err)
+	}
+	if client.Limits.ConnMaxLifetime != 60 || client.ConnMaxLifetime != 0 {
+		t.Errorf("客户端的 conn_max_lifetime 应合并到 limits: %+v", client.Limits)
+	}
+}
+
+// TestValidateControlExposure 测试控制端口只有在回环地址上才能不启用 mTLS
+func TestValidateControlExposure(t *testing.T) {
+	tests := []struct {
+		addr             string
+		tls, requireCert bool
+		wantErr          string
+	}{
+		{"127.0.0.1:7000", false, false, ""},
+		{"[::1]:7000", false, false, ""},
+		{"localhost:7000", true, false, ""},
+		{":7000", true, true, ""},
+		{"0.0.0.0:7000", true, true, ""},
+		{":7000", false, false, "未启用 PQC mTLS"},
+		{"[::]:7000", false, false, "未启用 PQC mTLS"},
+		{"10.0.0.5:7000", false, false, "不是回环地址"},
+		{"tunnel.example.com:7000", false, false, "不是回环地址"},
+		{"0.0.0.0:7000", true, false, "未要求客户端证书"},
+	}
+	for _, tt := range tests {
+		err := ValidateControlExposure(tt.addr, tt.tls, tt.requireCert)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s (tls=%v, require_client_cert=%v) 应被允许, 得到 %v", tt.addr, tt.tls, tt.requireCert, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s (tls=%v, require_client_cert=%v) 应报错 %q, 得到 %v", tt.addr, tt.tls, tt.requireCert, tt.wantErr, err)
+		}
+	}
+}
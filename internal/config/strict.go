@@ -0,0 +1,209 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// AllowUnknownFields 为 true 时加载配置忽略未知的配置项（记录警告）而不是报错
+// 用于向前兼容：旧版本程序读取为新版本编写的配置文件。默认严格校验，由命令行参数 -config-allow-unknown 设置
+var AllowUnknownFields bool
+
+// decodeConfig 严格解析 JSON 配置：拒绝未知的配置项（拼写错误的配置项不再被静默忽略）和多余的内容，
+// 错误信息指出出错的配置项或位置。AllowUnknownFields 为 true 时只对未知的配置项记录警告
+func decodeConfig(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if AllowUnknownFields {
+		var raw interface{}
+		if err := json.Unmarshal(data, &raw); err == nil {
+			for _, name := range unknownFields(raw, reflect.TypeOf(v).Elem(), "") {
+				log.Printf("警告: 忽略未知的配置项 %s", name)
+			}
+		}
+	} else {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return describeDecodeError(data, v, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("配置内容在第一个 JSON 对象之后还有多余的内容")
+	}
+	return nil
+}
+
+// describeDecodeError 将 encoding/json 的错误转换为指出配置项或行列位置的错误
+func describeDecodeError(data []byte, v interface{}, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, col := lineColumn(data, syntaxErr.Offset)
+		return fmt.Errorf("JSON 格式错误 (第 %d 行第 %d 列): %w", line, col, syntaxErr)
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "(顶层)"
+		}
+		return fmt.Errorf("配置项 %s 的类型错误: 期望 %s，实际为 JSON %s", field, typeErr.Type, typeErr.Value)
+	}
+
+	// DisallowUnknownFields 的错误没有单独的类型，格式为 json: unknown field "name"
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		name = strings.Trim(name, `"`)
+		if suggestion := closestField(name, configFieldNames(reflect.TypeOf(v).Elem(), "")); suggestion != "" {
+			return fmt.Errorf("未知的配置项 %q（是否为 %s？）", name, suggestion)
+		}
+		return fmt.Errorf("未知的配置项 %q", name)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("配置内容为空或不完整")
+	}
+	return err
+}
+
+// unknownFields 返回 raw（解析为 interface{} 的 JSON）中类型 t 没有对应字段的配置项路径（例如 tls.cret），按名称排序
+// 字段名与 encoding/json 相同，按不区分大小写匹配
+func unknownFields(raw interface{}, t reflect.Type, prefix string) []string {
+	var names []string
+	switch t.Kind() {
+	case reflect.Pointer:
+		return unknownFields(raw, t.Elem(), prefix)
+	case reflect.Slice:
+		items, _ := raw.([]interface{})
+		for i, item := range items {
+			names = append(names, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", strings.TrimSuffix(prefix, "."), i)+".")...)
+		}
+	case reflect.Map:
+		m, _ := raw.(map[string]interface{})
+		for key, value := range m {
+			names = append(names, unknownFields(value, t.Elem(), prefix+key+".")...)
+		}
+	case reflect.Struct:
+		m, _ := raw.(map[string]interface{})
+		for key, value := range m {
+			field, ok := fieldByJSONName(t, key)
+			if !ok {
+				names = append(names, prefix+key)
+				continue
+			}
+			names = append(names, unknownFields(value, field.Type, prefix+key+".")...)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fieldByJSONName 按 JSON 字段名（不区分大小写）查找结构体字段
+func fieldByJSONName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "-" && strings.EqualFold(tag, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// lineColumn 返回 data 中字节偏移 offset 所在的行号和列号（从 1 开始）
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// configFieldNames 返回配置结构体的全部配置项（嵌套结构体的配置项以 tls.cert 的形式给出）
+func configFieldNames(t reflect.Type, prefix string) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		names = append(names, prefix+name)
+		if ft.Kind() == reflect.Struct {
+			names = append(names, configFieldNames(ft, prefix+name+".")...)
+		}
+	}
+	return names
+}
+
+// closestField 返回与 name 最接近的配置项（编辑距离不超过 2），没有时返回空
+// 未知字段的错误不包含其所在的嵌套对象，因此与每个配置项路径的最后一段比较
+func closestField(name string, fields []string) string {
+	best, bestDist := "", 3
+	for _, field := range fields {
+		last := field[strings.LastIndex(field, ".")+1:]
+		if d := editDistance(strings.ToLower(name), last); d < bestDist {
+			best, bestDist = field, d
+		}
+	}
+	return best
+}
+
+// editDistance 计算两个字符串的编辑距离（Levenshtein）
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// validateRanges 校验配置中数值配置项的取值范围：时长、大小、数量等整数配置项都不能为负数，
+// 另外 limits 给出有上限的配置项（JSON 字段路径 → 最大值）
+func validateRanges(v interface{}, limits map[string]int64) error {
+	return checkRanges(reflect.ValueOf(v).Elem(), "", limits)
+}
+
+// checkRanges 递归检查结构体 rv 的整数配置项
+func checkRanges(rv reflect.Value, prefix string, limits map[string]int64) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := prefix + strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		field := rv.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			if err := checkRanges(field, name+".", limits); err != nil {
+				return err
+			}
+		case reflect.Int, reflect.Int64:
+			n := field.Int()
+			if n < 0 {
+				return fmt.Errorf("配置项 %s 的取值无效: %d（不能为负数）", name, n)
+			}
+			if max, ok := limits[name]; ok && n > max {
+				return fmt.Errorf("配置项 %s 的取值无效: %d（不能大于 %d）", name, n, max)
+			}
+		}
+	}
+	return nil
+}
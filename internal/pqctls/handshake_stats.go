@@ -0,0 +1,101 @@
+package pqctls
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 握手角色（HandshakeStat.Role）
+const (
+	RoleServer = "server" // PQCListener.Accept
+	RoleClient = "client" // PQCDialer.Client
+)
+
+// 握手结果（HandshakeStat.Outcome），失败时为拒绝原因（RejectNonPQC 等）或 OutcomeTimeout
+const (
+	OutcomeOK      = "ok"      // 完整握手成功
+	OutcomeResumed = "resumed" // 恢复会话成功（跳过证书认证和签名）
+	OutcomeTimeout = "timeout" // 客户端握手超时
+)
+
+// HandshakeBuckets 握手耗时直方图的桶上限（秒）
+var HandshakeBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HandshakeStat 按角色、结果、密钥交换组和对端签名算法统计的握手次数、耗时和字节数（HandshakeStats 返回的快照）
+type HandshakeStat struct {
+	Role    string
+	Outcome string
+	Group   string // 协商的密钥交换组（握手在协商之前失败时为空）
+	Sigalg  string // 对端在握手中使用的签名算法（恢复会话、对端未发送证书或握手在认证之前失败时为空）
+
+	Count        uint64
+	Buckets      []uint64 // 与 HandshakeBuckets 对应的累计计数（耗时不超过桶上限的握手数）
+	Seconds      float64  // 累计耗时
+	BytesRead    uint64   // 握手期间从对端读取的字节数
+	BytesWritten uint64   // 握手期间写给对端的字节数
+}
+
+// handshakeKey 统计的标签
+type handshakeKey struct {
+	role    string
+	outcome string
+	group   string
+	sigalg  string
+}
+
+// handshakeStats 进程内全部 PQC 握手的统计（客户端每次重连创建新的拨号器，因此按进程统计）
+var handshakeStats = struct {
+	mu    sync.Mutex
+	stats map[handshakeKey]*HandshakeStat
+}{stats: make(map[handshakeKey]*HandshakeStat)}
+
+// recordHandshakeStat 记录一次握手（成功或失败）
+func recordHandshakeStat(role, outcome, group, sigalg string, d time.Duration, read, written uint64) {
+	handshakeStats.mu.Lock()
+	defer handshakeStats.mu.Unlock()
+
+	key := handshakeKey{role, outcome, group, sigalg}
+	st, ok := handshakeStats.stats[key]
+	if !ok {
+		st = &HandshakeStat{Role: role, Outcome: outcome, Group: group, Sigalg: sigalg, Buckets: make([]uint64, len(HandshakeBuckets))}
+		handshakeStats.stats[key] = st
+	}
+	seconds := d.Seconds()
+	st.Count++
+	st.Seconds += seconds
+	st.BytesRead += read
+	st.BytesWritten += written
+	for i, le := range HandshakeBuckets {
+		if seconds <= le {
+			st.Buckets[i]++
+		}
+	}
+}
+
+// HandshakeStats 返回进程内 PQC 握手统计的快照（按角色、结果、密钥交换组、签名算法排序）
+func HandshakeStats() []HandshakeStat {
+	handshakeStats.mu.Lock()
+	defer handshakeStats.mu.Unlock()
+
+	stats := make([]HandshakeStat, 0, len(handshakeStats.stats))
+	for _, st := range handshakeStats.stats {
+		s := *st
+		s.Buckets = append([]uint64(nil), st.Buckets...)
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		if a.Outcome != b.Outcome {
+			return a.Outcome < b.Outcome
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Sigalg < b.Sigalg
+	})
+	return stats
+}
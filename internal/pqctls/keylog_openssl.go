@@ -0,0 +1,59 @@
+//go:build cgo
+// +build cgo
+
+package pqctls
+
+// 使用 //export 的文件的 preamble 只能包含声明，回调本身定义在 pqc_tls_openssl.go 中
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// KeyLogFileEnv 指定 TLS 密钥日志文件的环境变量（与浏览器、curl 等工具的约定相同，由调用方决定是否读取）
+const KeyLogFileEnv = "SSLKEYLOGFILE"
+
+// keyLog SetKeyLogFile 打开的密钥日志文件（nil 表示未启用）
+var keyLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// SetKeyLogFile 启用 TLS 密钥日志：此后创建的监听器和拨号器在每次握手后把 TLS 1.3 流量密钥以 NSS 密钥日志格式
+// 追加到 path（文件权限 0600），Wireshark 等工具可以据此解密抓包，用于排查与其他 PQC TLS 实现的互通问题。
+// 任何拿到该文件的人都能解密对应的流量，只应在调试时临时启用；必须在创建监听器和拨号器之前调用
+func SetKeyLogFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open key log file: %w", err)
+	}
+	keyLog.mu.Lock()
+	defer keyLog.mu.Unlock()
+	if keyLog.file != nil {
+		keyLog.file.Close()
+	}
+	keyLog.file = f
+	return nil
+}
+
+// keyLogEnabled 返回是否已通过 SetKeyLogFile 启用密钥日志
+func keyLogEnabled() bool {
+	keyLog.mu.Lock()
+	defer keyLog.mu.Unlock()
+	return keyLog.file != nil
+}
+
+//export goKeyLogLine
+func goKeyLogLine(line *C.char) {
+	keyLog.mu.Lock()
+	defer keyLog.mu.Unlock()
+	if keyLog.file != nil {
+		keyLog.file.WriteString(C.GoString(line) + "\n")
+	}
+}
@@ -1,12 +1,16 @@
 package pqctls
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
+	"strings"
 )
 
 // 如果编译时启用了 cgo，优先使用 OpenSSL 实现
@@ -26,13 +30,13 @@ func NewServerTLSConfig(cfg *PQCTLSConfig) (*tls.Config, error) {
 	// 加载服务器证书和私钥
 	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 	if err != nil {
-		return nil, fmt.Errorf("加载服务器证书失败: %v", err)
+		return nil, fmt.Errorf("加载服务器证书失败: %w", err)
 	}
 
 	// 加载 CA 证书用于验证客户端证书
 	caCert, err := ioutil.ReadFile(cfg.CAFile)
 	if err != nil {
-		return nil, fmt.Errorf("加载 CA 证书失败: %v", err)
+		return nil, fmt.Errorf("加载 CA 证书失败: %w", err)
 	}
 
 	caCertPool := x509.NewCertPool()
@@ -58,13 +62,13 @@ func NewClientTLSConfig(cfg *PQCTLSConfig) (*tls.Config, error) {
 	// 加载客户端证书和私钥
 	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 	if err != nil {
-		return nil, fmt.Errorf("加载客户端证书失败: %v", err)
+		return nil, fmt.Errorf("加载客户端证书失败: %w", err)
 	}
 
 	// 加载 CA 证书用于验证服务器证书
 	caCert, err := ioutil.ReadFile(cfg.CAFile)
 	if err != nil {
-		return nil, fmt.Errorf("加载 CA 证书失败: %v", err)
+		return nil, fmt.Errorf("加载 CA 证书失败: %w", err)
 	}
 
 	caCertPool := x509.NewCertPool()
@@ -99,3 +103,88 @@ func DialTLS(network, address string, config *tls.Config) (net.Conn, error) {
 	return tls.Dial(network, address, config)
 }
 
+// 可以用 errors.Is 判断的错误，调用方据此区分证书配置错误、PQC 策略拒绝和网络错误
+// （网络错误保留原始的 *net.OpError 等类型，可以用 errors.As 判断）
+var (
+	// ErrCertificateNotFound 证书、私钥或 CA 文件不存在
+	ErrCertificateNotFound = errors.New("file not found")
+	// ErrInvalidCertificate 证书、私钥或 CA 文件无法加载、私钥与证书不匹配，或无法用它们创建 SSL 上下文
+	ErrInvalidCertificate = errors.New("invalid certificate configuration")
+	// ErrCertificateRejected 握手因证书失败：对端证书未通过验证，或对端拒绝了本端的证书
+	ErrCertificateRejected = errors.New("certificate rejected")
+	// ErrNonPQC 握手成功但协商的算法不是 PQC（或低于要求的 NIST 安全级别），连接被拒绝
+	ErrNonPQC = errors.New("PQC requirement not met")
+	// ErrFingerprintMismatch 服务器证书与固定的指纹不符（或服务器没有发送证书）
+	ErrFingerprintMismatch = errors.New("server certificate fingerprint mismatch")
+	// ErrHandshakeTimeout 握手未在超时时间内完成
+	ErrHandshakeTimeout = errors.New("SSL handshake timed out")
+)
+
+// 服务器拒绝握手的原因分类（HandshakeError.Reason）
+const (
+	RejectNonPQC          = "non_pqc"          // 握手成功但未协商 PQC 算法（或低于要求的安全级别）
+	RejectCertificate     = "cert_rejected"    // 对端证书缺失或未通过验证
+	RejectUnknownProtocol = "unknown_protocol" // 对端不是 TLS 客户端（如明文或 HTTP 请求）或 ALPN 协议不匹配
+	RejectHandshake       = "handshake_failed" // 其他握手失败
+)
+
+// HandshakeError 表示服务器在 Accept 中拒绝的一次握手，供调用方按原因记录审计日志和计数
+// 握手前的本地错误（获取文件描述符、创建 SSL 对象等）不使用该类型
+type HandshakeError struct {
+	RemoteAddr net.Addr // 对端地址
+	Reason     string   // 拒绝原因分类（Reject* 常量之一）
+	Err        error    // 原始错误
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// classifyHandshakeFailure 根据 OpenSSL 错误描述判断握手失败的原因分类
+func classifyHandshakeFailure(msg string) string {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "certificate"):
+		return RejectCertificate
+	case strings.Contains(msg, "wrong version number"), strings.Contains(msg, "http request"),
+		strings.Contains(msg, "unknown protocol"), strings.Contains(msg, "unsupported protocol"),
+		strings.Contains(msg, "no application protocol"):
+		return RejectUnknownProtocol
+	default:
+		return RejectHandshake
+	}
+}
+
+// ParseFingerprint 解析证书的 SHA-256 指纹：64 个十六进制字符，字节之间可以用冒号分隔（如 openssl x509 -fingerprint -sha256 的输出），
+// 可带 "sha256:" 或 "SHA256 Fingerprint=" 前缀，不区分大小写
+func ParseFingerprint(s string) ([]byte, error) {
+	v := strings.TrimSpace(s)
+	for _, prefix := range []string{"sha256 fingerprint=", "sha256:"} {
+		if strings.HasPrefix(strings.ToLower(v), prefix) {
+			v = v[len(prefix):]
+			break
+		}
+	}
+	v = strings.ReplaceAll(v, ":", "")
+	fp, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate fingerprint %q: %w", s, err)
+	}
+	if len(fp) != sha256.Size {
+		return nil, fmt.Errorf("invalid certificate fingerprint %q: want %d bytes (SHA-256), got %d", s, sha256.Size, len(fp))
+	}
+	return fp, nil
+}
+
+// FormatFingerprint 以冒号分隔的大写十六进制格式化指纹（与 openssl x509 -fingerprint 的输出一致）
+func FormatFingerprint(fp []byte) string {
+	parts := make([]string, len(fp))
+	for i, b := range fp {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
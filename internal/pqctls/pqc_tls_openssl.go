@@ -14,8 +14,10 @@ package pqctls
 #include <openssl/conf.h>
 #include <openssl/tls1.h>
 #include <openssl/provider.h>
+#include <openssl/evp.h>
 #include <stdlib.h>
 #include <string.h>
+#include <ctype.h>
 #include <unistd.h>
 #include <sys/socket.h>
 #include <netinet/in.h>
@@ -31,9 +33,47 @@ package pqctls
 #define SSL_ERROR_WANT_CONNECT 7
 #define SSL_ERROR_WANT_ACCEPT 8
 
+// 根据算法名称中的参数集返回 NIST 安全级别，无法识别时返回 0
+// ML-KEM-512/768/1024 为 1/3/5 级，ML-DSA-44/65/87 为 2/3/5 级（名称不区分大小写，兼容 oqs-provider 的 mlkem768/mldsa65 等）
+static int pqc_name_level(const char* name, int is_kem) {
+    char lower[64];
+    size_t i;
+    for (i = 0; name[i] != '\0' && i < sizeof(lower) - 1; i++) {
+        lower[i] = (char)tolower((unsigned char)name[i]);
+    }
+    lower[i] = '\0';
+
+    if (is_kem) {
+        if (strstr(lower, "mlkem") == NULL && strstr(lower, "ml-kem") == NULL && strstr(lower, "kyber") == NULL) {
+            return 0;
+        }
+        if (strstr(lower, "1024") != NULL) return 5;
+        if (strstr(lower, "768") != NULL) return 3;
+        if (strstr(lower, "512") != NULL) return 1;
+        return 0;
+    }
+    if (strstr(lower, "mldsa") == NULL && strstr(lower, "ml-dsa") == NULL) {
+        return 0;
+    }
+    if (strstr(lower, "87") != NULL) return 5;
+    if (strstr(lower, "65") != NULL) return 3;
+    if (strstr(lower, "44") != NULL) return 2;
+    return 0;
+}
+
+// 设置 TLS 1.3 密钥交换组和签名算法列表，返回 1 表示成功
+static int set_pqc_algorithms(SSL_CTX* ctx, const char* groups, const char* sigalgs) {
+    if (SSL_CTX_set1_groups_list(ctx, groups) <= 0 || SSL_CTX_set1_sigalgs_list(ctx, sigalgs) <= 0) {
+        ERR_print_errors_fp(stderr);
+        return 0;
+    }
+    return 1;
+}
+
 // 验证握手后使用的算法是否为 PQC 算法
+// min_level 大于 0 时还要求密钥交换组和对端证书的签名算法达到该 NIST 安全级别
 // 返回 1 表示是 PQC 算法，0 表示不是（需要拒绝连接）
-static int verify_pqc_algorithms(SSL* ssl) {
+static int verify_pqc_algorithms(SSL* ssl, int min_level) {
     // 检查密钥交换组是否为 ML-KEM
     int group_id = SSL_get_negotiated_group(ssl);
     if (group_id <= 0) {
@@ -59,18 +99,291 @@ static int verify_pqc_algorithms(SSL* ssl) {
     // 检查签名算法（TLS 1.3 中通过证书验证）
     // 注意：在 TLS 1.3 中，签名算法主要用于证书验证
     // 我们已经通过证书使用了 ML-DSA-65，这里主要验证密钥交换
-    
+    if (min_level <= 0) {
+        return 1; // 验证通过
+    }
+
+    // 要求最低安全级别：密钥交换组和对端证书的公钥算法都必须达到
+    if (pqc_name_level(group_name, 1) < min_level) {
+        return 0;
+    }
+    X509* peer = SSL_get0_peer_certificate(ssl);
+    if (peer != NULL) {
+        EVP_PKEY* pkey = X509_get0_pubkey(peer);
+        const char* key_type = pkey != NULL ? EVP_PKEY_get0_type_name(pkey) : NULL;
+        if (key_type == NULL || pqc_name_level(key_type, 0) < min_level) {
+            return 0;
+        }
+    }
     return 1; // 验证通过
 }
 
+// 获取对端证书的 DER 编码，返回长度（没有对端证书时返回 0），*out 需由 free_der 释放
+static int get_peer_cert_der(SSL* ssl, unsigned char** out) {
+    X509* cert = SSL_get1_peer_certificate(ssl);
+    if (cert == NULL) {
+        return 0;
+    }
+    int len = i2d_X509(cert, out);
+    X509_free(cert);
+    return len;
+}
+
+// 获取对端证书链中第 idx 个证书的 DER 编码，返回长度（超出范围时返回 0），*out 需由 free_der 释放。
+// 握手验证了对端证书时使用验证后的链（叶证书到信任锚），否则使用对端发送的链（服务端不含叶证书）
+static int get_peer_chain_der(SSL* ssl, int idx, unsigned char** out) {
+    STACK_OF(X509)* chain = SSL_get0_verified_chain(ssl);
+    if (chain == NULL || sk_X509_num(chain) == 0) {
+        chain = SSL_get_peer_cert_chain(ssl);
+    }
+    if (chain == NULL || idx < 0 || idx >= sk_X509_num(chain)) {
+        return 0;
+    }
+    return i2d_X509(sk_X509_value(chain, idx), out);
+}
+
+static void free_der(unsigned char* p) {
+    OPENSSL_free(p);
+}
+
+// 服务器端会话恢复设置
+// 禁用（默认）：不发送会话票据、关闭服务器会话缓存，每次连接都进行完整的 PQC 握手
+// 启用：发送 TLS 1.3 会话票据，客户端可凭票据恢复会话（仍通过 ML-KEM 协商新的密钥，psk_dhe_ke）
+static int set_server_session_resumption(SSL_CTX* ctx, int enabled) {
+    if (!enabled) {
+        SSL_CTX_set_session_cache_mode(ctx, SSL_SESS_CACHE_OFF);
+        SSL_CTX_set_options(ctx, SSL_OP_NO_TICKET);
+        return SSL_CTX_set_num_tickets(ctx, 0);
+    }
+    // 要求客户端证书时必须设置会话 ID 上下文，否则恢复的会话会被拒绝
+    static const unsigned char sid_ctx[] = "reverse-tunnel";
+    if (SSL_CTX_set_session_id_context(ctx, sid_ctx, sizeof(sid_ctx) - 1) <= 0) {
+        return 0;
+    }
+    SSL_CTX_set_session_cache_mode(ctx, SSL_SESS_CACHE_SERVER);
+    SSL_CTX_clear_options(ctx, SSL_OP_NO_TICKET);
+    return SSL_CTX_set_num_tickets(ctx, 2);
+}
+
+// 服务器端客户端证书校验：要求（默认，mTLS）时没有客户端证书的握手失败；
+// 不要求（单向 TLS）时仍校验客户端提供的证书，未提供证书的客户端也可以完成握手
+static void set_server_verify(SSL_CTX* ctx, int require_client_cert) {
+    int mode = SSL_VERIFY_PEER;
+    if (require_client_cert) {
+        mode |= SSL_VERIFY_FAIL_IF_NO_PEER_CERT;
+    }
+    SSL_CTX_set_verify(ctx, mode, NULL);
+}
+
+static int verify_accept_any(int preverify_ok, X509_STORE_CTX* store) {
+    return 1;
+}
+
+// 客户端只校验服务器证书指纹（未配置 CA）：接受任意服务器证书链，握手完成后由 PQCDialer 比较指纹
+static void set_client_verify_pinned(SSL_CTX* ctx) {
+    SSL_CTX_set_verify(ctx, SSL_VERIFY_PEER, verify_accept_any);
+}
+
+// 由 keylog_openssl.go 导出，把一行 NSS 密钥日志写入 SetKeyLogFile 打开的文件
+extern void goKeyLogLine(char* line);
+
+static void keylog_callback(const SSL* ssl, const char* line) {
+    goKeyLogLine((char*)line);
+}
+
+// 在上下文上安装密钥日志回调，此后该上下文的每次握手都输出 TLS 1.3 流量密钥
+static void enable_keylog(SSL_CTX* ctx) {
+    SSL_CTX_set_keylog_callback(ctx, keylog_callback);
+}
+
+// 检查证书、私钥和 CA 文件可以被加载且私钥与证书匹配（NULL 跳过该项）
+// 返回 0 表示成功，否则为出错的项：1 证书、2 私钥、3 私钥与证书不匹配、4 CA，-1 表示无法创建 SSL_CTX
+static int check_cert_files(const char* cert_file, const char* key_file, const char* ca_file) {
+    SSL_CTX* ctx = SSL_CTX_new(TLS_method());
+    if (!ctx) {
+        return -1;
+    }
+    int rc = 0;
+    if (cert_file && SSL_CTX_use_certificate_file(ctx, cert_file, SSL_FILETYPE_PEM) <= 0) {
+        rc = 1;
+    } else if (key_file && SSL_CTX_use_PrivateKey_file(ctx, key_file, SSL_FILETYPE_PEM) <= 0) {
+        rc = 2;
+    } else if (cert_file && key_file && !SSL_CTX_check_private_key(ctx)) {
+        rc = 3;
+    } else if (ca_file && SSL_CTX_load_verify_locations(ctx, ca_file, NULL) <= 0) {
+        rc = 4;
+    }
+    SSL_CTX_free(ctx);
+    return rc;
+}
+
+// 获取连接当前可恢复会话的 DER 编码，返回长度（没有可恢复的会话时返回 0），*out 需由 free_der 释放
+static int get_session_der(SSL* ssl, unsigned char** out) {
+    SSL_SESSION* sess = SSL_get1_session(ssl);
+    if (sess == NULL) {
+        return 0;
+    }
+    int len = 0;
+    if (SSL_SESSION_is_resumable(sess)) {
+        len = i2d_SSL_SESSION(sess, out);
+    }
+    SSL_SESSION_free(sess);
+    return len < 0 ? 0 : len;
+}
+
+// 使用 DER 编码的会话尝试恢复（握手前调用），返回 1 表示已设置
+static int set_session_der(SSL* ssl, const unsigned char* der, long len) {
+    SSL_SESSION* sess = d2i_SSL_SESSION(NULL, &der, len);
+    if (sess == NULL) {
+        return 0;
+    }
+    int ret = SSL_set_session(ssl, sess);
+    SSL_SESSION_free(sess);
+    return ret;
+}
+
+// 服务器端 ALPN 协议列表（线路格式：每项为 1 字节长度 + 协议名），保存在 SSL_CTX 的扩展数据中，随 SSL_CTX 一起释放
+typedef struct {
+    unsigned char* data;
+    unsigned int len;
+} alpn_list;
+
+static int alpn_ex_index = -1;
+
+static void alpn_ex_free(void* parent, void* ptr, CRYPTO_EX_DATA* ad, int idx, long argl, void* argp) {
+    alpn_list* list = (alpn_list*)ptr;
+    if (list != NULL) {
+        free(list->data);
+        free(list);
+    }
+}
+
+// ALPN 选择回调：按服务器列表的优先顺序选择双方都支持的协议，没有共同协议时以 no_application_protocol 警报终止握手
+static int alpn_select_cb(SSL* ssl, const unsigned char** out, unsigned char* outlen,
+                          const unsigned char* in, unsigned int inlen, void* arg) {
+    alpn_list* list = (alpn_list*)SSL_CTX_get_ex_data(SSL_get_SSL_CTX(ssl), alpn_ex_index);
+    unsigned char* selected = NULL;
+    unsigned char selected_len = 0;
+    if (list == NULL ||
+        SSL_select_next_proto(&selected, &selected_len, list->data, list->len, in, inlen) != OPENSSL_NPN_NEGOTIATED) {
+        return SSL_TLSEXT_ERR_ALERT_FATAL;
+    }
+    *out = selected;
+    *outlen = selected_len;
+    return SSL_TLSEXT_ERR_OK;
+}
+
+// 设置服务器端接受的 ALPN 协议列表，返回 1 表示成功
+static int set_server_alpn(SSL_CTX* ctx, const unsigned char* protos, unsigned int len) {
+    if (alpn_ex_index < 0) {
+        return 0;
+    }
+    alpn_list* list = malloc(sizeof(alpn_list));
+    if (list == NULL) {
+        return 0;
+    }
+    list->data = malloc(len);
+    if (list->data == NULL) {
+        free(list);
+        return 0;
+    }
+    memcpy(list->data, protos, len);
+    list->len = len;
+    alpn_list* old = (alpn_list*)SSL_CTX_get_ex_data(ctx, alpn_ex_index);
+    if (!SSL_CTX_set_ex_data(ctx, alpn_ex_index, list)) {
+        free(list->data);
+        free(list);
+        return 0;
+    }
+    if (old != NULL) {
+        free(old->data);
+        free(old);
+    }
+    SSL_CTX_set_alpn_select_cb(ctx, alpn_select_cb, NULL);
+    return 1;
+}
+
+// 返回握手协商的 ALPN 协议（未协商时 *len 为 0）
+static const unsigned char* get_alpn_selected(SSL* ssl, unsigned int* len) {
+    const unsigned char* data = NULL;
+    SSL_get0_alpn_selected(ssl, &data, len);
+    return data;
+}
+
+// 返回当前密码套件名称
+static const char* get_cipher_name(SSL* ssl) {
+    return SSL_CIPHER_get_name(SSL_get_current_cipher(ssl));
+}
+
+// 返回协商的密钥交换组名称
+static const char* get_group_name(SSL* ssl) {
+    return SSL_get0_group_name(ssl);
+}
+
+// 返回对端在握手中使用的签名算法名称（恢复会话、对端未发送证书或无法识别时返回 NULL）
+static const char* get_peer_sigalg_name(SSL* ssl) {
+    int nid = NID_undef;
+    if (SSL_get_peer_signature_type_nid(ssl, &nid) != 1 || nid == NID_undef) {
+        return NULL;
+    }
+    return OBJ_nid2ln(nid);
+}
+
+// 返回 SSL 对象的 socket BIO 累计读写的字节数（握手结束时即为握手的字节数）
+static void get_bio_bytes(SSL* ssl, uint64_t* nread, uint64_t* nwritten) {
+    BIO* rbio = SSL_get_rbio(ssl);
+    BIO* wbio = SSL_get_wbio(ssl);
+    *nread = rbio != NULL ? BIO_number_read(rbio) : 0;
+    *nwritten = wbio != NULL ? BIO_number_written(wbio) : 0;
+}
+
 static void init_openssl() {
     OPENSSL_init_ssl(0, NULL);
     OPENSSL_init_crypto(0, NULL);
-    
-    // 加载 OpenSSL 配置文件（包含 oqs-provider）
-    // 注意：OPENSSL_config 在 OpenSSL 3.x 中已废弃，使用 CONF_modules_load_file
-    const char* conf_file = "/opt/openssl-oqs/ssl/openssl-oqs.cnf";
-    CONF_modules_load_file(conf_file, NULL, 0);
+    alpn_ex_index = SSL_CTX_get_ex_new_index(0, NULL, NULL, NULL, alpn_ex_free);
+}
+
+// 加载 OpenSSL 配置文件（包含 oqs-provider），返回 1 表示成功
+// 注意：OPENSSL_config 在 OpenSSL 3.x 中已废弃，使用 CONF_modules_load_file
+static int load_openssl_conf(const char* conf_file) {
+    ERR_clear_error();
+    return CONF_modules_load_file(conf_file, NULL, 0) > 0 ? 1 : 0;
+}
+
+// check_pqc_support 的结果
+#define PQC_OK 0
+#define PQC_NO_KEM 1      // 没有提供 ML-KEM 的 provider
+#define PQC_NO_SIG 2      // 没有提供 ML-DSA 的 provider
+#define PQC_NO_TLS 3      // 算法存在，但无法配置为 TLS 密钥交换组/签名算法
+
+// 检查 PQC 密钥交换和签名算法是否可用（由 oqs-provider 或 OpenSSL 3.5+ 内置实现提供）
+// 先通过 EVP_KEM_fetch/EVP_SIGNATURE_fetch 确认有 provider 实现了算法，再确认 TLS 能使用对应的组和签名算法
+static int check_pqc_support() {
+    ERR_clear_error();
+    EVP_KEM* kem = EVP_KEM_fetch(NULL, "MLKEM768", NULL);
+    if (!kem) {
+        return PQC_NO_KEM;
+    }
+    EVP_KEM_free(kem);
+    EVP_SIGNATURE* sig = EVP_SIGNATURE_fetch(NULL, "MLDSA65", NULL);
+    if (!sig) {
+        return PQC_NO_SIG;
+    }
+    EVP_SIGNATURE_free(sig);
+
+    SSL_CTX* ctx = SSL_CTX_new(TLS_method());
+    if (!ctx) {
+        return PQC_NO_TLS;
+    }
+    int ok = SSL_CTX_set1_groups_list(ctx, "MLKEM768:MLKEM512:MLKEM1024") > 0 &&
+             SSL_CTX_set1_sigalgs_list(ctx, "MLDSA65:MLDSA44:MLDSA87") > 0;
+    SSL_CTX_free(ctx);
+    return ok ? PQC_OK : PQC_NO_TLS;
+}
+
+// 返回 oqs-provider 是否已加载
+static int oqs_provider_loaded() {
+    return OSSL_PROVIDER_available(NULL, "oqsprovider");
 }
 
 static SSL_CTX* create_server_ctx(const char* cert_file, const char* key_file, const char* ca_file) {
@@ -130,8 +443,8 @@ static SSL_CTX* create_server_ctx(const char* cert_file, const char* key_file, c
         return NULL;
     }
 
-    // 要求客户端证书（mTLS）
-    SSL_CTX_set_verify(ctx, SSL_VERIFY_PEER | SSL_VERIFY_FAIL_IF_NO_PEER_CERT, NULL);
+    // 要求客户端证书（mTLS），可由 SetRequireClientCert 改为单向 TLS
+    set_server_verify(ctx, 1);
     
     // 设置验证深度
     SSL_CTX_set_verify_depth(ctx, 1);
@@ -208,83 +521,319 @@ static SSL_CTX* create_client_ctx(const char* cert_file, const char* key_file, c
 import "C"
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 	"unsafe"
 )
 
+// DefaultOpenSSLConf 未通过环境变量指定时加载的 OpenSSL 配置文件（包含 oqs-provider）
+const DefaultOpenSSLConf = "/opt/openssl-oqs/ssl/openssl-oqs.cnf"
+
+// OpenSSLConfEnv 指定 OpenSSL 配置文件的环境变量，优先于 OPENSSL_CONF
+const OpenSSLConfEnv = "RT_OPENSSL_CONF"
+
+var (
+	// opensslConf 初始化时使用的 OpenSSL 配置文件路径
+	opensslConf string
+	// initErr OpenSSL 初始化错误（配置文件加载失败或 PQC 算法不可用）
+	initErr error
+)
+
 func init() {
 	C.init_openssl()
+	initErr = loadOpenSSLConf()
+}
+
+// loadOpenSSLConf 按 RT_OPENSSL_CONF、OPENSSL_CONF、DefaultOpenSSLConf 的顺序选择并加载配置文件，然后检查 PQC 算法是否可用
+// CONF_modules_load_file 可能“成功”却没有加载 oqs-provider，因此以算法能否获取为准，而不是配置文件的加载结果
+// 显式指定的文件加载失败时返回错误；默认文件加载失败时继续（OpenSSL 3.5+ 内置 ML-KEM/ML-DSA，不一定需要 oqs-provider）
+func loadOpenSSLConf() error {
+	explicit := true
+	opensslConf = os.Getenv(OpenSSLConfEnv)
+	if opensslConf == "" {
+		opensslConf = os.Getenv("OPENSSL_CONF")
+	}
+	if opensslConf == "" {
+		opensslConf = DefaultOpenSSLConf
+		explicit = false
+	}
+
+	cConf := C.CString(opensslConf)
+	defer C.free(unsafe.Pointer(cConf))
+	if C.load_openssl_conf(cConf) != 1 && explicit {
+		return fmt.Errorf("failed to load OpenSSL config %s: %s", opensslConf, lastOpenSSLError())
+	}
+	var missing string
+	switch C.check_pqc_support() {
+	case C.PQC_OK:
+		return nil
+	case C.PQC_NO_KEM:
+		missing = "no provider implements ML-KEM-768"
+	case C.PQC_NO_SIG:
+		missing = "no provider implements ML-DSA-65"
+	default:
+		missing = "ML-KEM/ML-DSA cannot be used as TLS groups/signature algorithms: " + lastOpenSSLError()
+	}
+	if C.oqs_provider_loaded() != 1 {
+		return fmt.Errorf("oqs-provider not loaded; PQC algorithms unavailable (%s, OpenSSL config %s); "+
+			"install oqs-provider or set %s to an OpenSSL config that loads it", missing, opensslConf, OpenSSLConfEnv)
+	}
+	return fmt.Errorf("PQC algorithms unavailable although oqs-provider is loaded (%s, OpenSSL config %s)", missing, opensslConf)
+}
+
+// lastOpenSSLError 返回 OpenSSL 错误队列中最早的错误描述（队列为空时返回 "unknown error"）
+func lastOpenSSLError() string {
+	errNum := C.ERR_get_error()
+	if errNum == 0 {
+		return "unknown error"
+	}
+	var errBuf [512]C.char
+	C.ERR_error_string_n(errNum, &errBuf[0], 512)
+	return C.GoString(&errBuf[0])
+}
+
+// PQCAlgorithms 返回达到指定 NIST 安全级别的密钥交换组和签名算法列表（OpenSSL 格式，按优先级排列）
+// ML-KEM-512/768/1024 为 1/3/5 级，ML-DSA-44/65/87 为 2/3/5 级；level 为 0 时返回默认列表（全部算法）
+func PQCAlgorithms(level int) (groups, sigalgs string, err error) {
+	if level < 0 || level > 5 {
+		return "", "", fmt.Errorf("invalid NIST security level %d (must be 1-5, or 0 for default)", level)
+	}
+	kems := []struct {
+		name  string
+		level int
+	}{{"MLKEM768", 3}, {"MLKEM512", 1}, {"MLKEM1024", 5}}
+	sigs := []struct {
+		name  string
+		level int
+	}{{"MLDSA65", 3}, {"MLDSA44", 2}, {"MLDSA87", 5}}
+
+	var g, s []string
+	for _, k := range kems {
+		if k.level >= level {
+			g = append(g, k.name)
+		}
+	}
+	for _, a := range sigs {
+		if a.level >= level {
+			s = append(s, a.name)
+		}
+	}
+	return strings.Join(g, ":"), strings.Join(s, ":"), nil
+}
+
+// setMinSecurityLevel 按安全级别设置 SSL_CTX 的密钥交换组和签名算法列表
+func setMinSecurityLevel(ctx *C.SSL_CTX, level int) error {
+	groups, sigalgs, err := PQCAlgorithms(level)
+	if err != nil {
+		return err
+	}
+	cGroups := C.CString(groups)
+	defer C.free(unsafe.Pointer(cGroups))
+	cSigalgs := C.CString(sigalgs)
+	defer C.free(unsafe.Pointer(cSigalgs))
+	if C.set_pqc_algorithms(ctx, cGroups, cSigalgs) != 1 {
+		return fmt.Errorf("failed to set PQC algorithms for NIST security level %d (groups %s, sigalgs %s)", level, groups, sigalgs)
+	}
+	return nil
+}
+
+// Ready 返回 OpenSSL 初始化的错误（nil 表示 PQC 算法可用），用于在启动时给出明确的错误，而不是在握手时失败
+func Ready() error {
+	return initErr
+}
+
+// CheckCertificateFiles 检查证书、私钥和 CA 文件存在、可以被加载且私钥与证书匹配（路径为空时跳过该项，
+// 证书和私钥必须同时指定或同时留空），用于在启动时对配置错误给出明确的错误，而不是在每次连接时失败
+func CheckCertificateFiles(certFile, keyFile, caFile string) error {
+	if initErr != nil {
+		return initErr
+	}
+	if (certFile == "") != (keyFile == "") {
+		return errors.New("certificate and key files must be specified together")
+	}
+	files := []struct{ kind, path string }{{"certificate", certFile}, {"key", keyFile}, {"CA", caFile}}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); os.IsNotExist(err) {
+			return fmt.Errorf("%s %w: %s", f.kind, ErrCertificateNotFound, f.path)
+		} else if err != nil {
+			return fmt.Errorf("%s file not accessible: %w", f.kind, err)
+		}
+	}
+
+	cFiles := make([]*C.char, len(files))
+	for i, f := range files {
+		if f.path != "" {
+			cFiles[i] = C.CString(f.path)
+			defer C.free(unsafe.Pointer(cFiles[i]))
+		}
+	}
+	C.ERR_clear_error()
+	switch C.check_cert_files(cFiles[0], cFiles[1], cFiles[2]) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%w: invalid certificate file %s: %s", ErrInvalidCertificate, certFile, lastOpenSSLError())
+	case 2:
+		return fmt.Errorf("%w: invalid key file %s: %s", ErrInvalidCertificate, keyFile, lastOpenSSLError())
+	case 3:
+		return fmt.Errorf("%w: key file %s does not match certificate %s: %s", ErrInvalidCertificate, keyFile, certFile, lastOpenSSLError())
+	case 4:
+		return fmt.Errorf("%w: invalid CA file %s: %s", ErrInvalidCertificate, caFile, lastOpenSSLError())
+	default:
+		return errors.New("failed to create SSL context")
+	}
+}
+
+// OpenSSLConfPath 返回初始化时使用的 OpenSSL 配置文件路径
+func OpenSSLConfPath() string {
+	return opensslConf
 }
 
 // PQCConn 表示一个 PQC TLS 连接（使用 OpenSSL）
 // 注意：OpenSSL 的 SSL 对象不是线程安全的，需要互斥锁保护
+// 底层 socket 由 Go 运行时设置为非阻塞，SSL_read/SSL_write 返回 WANT_READ/WANT_WRITE 时
+// 通过 syscall.RawConn 等待 socket 可读/可写，因此读写截止时间（SetDeadline 等）同样作用于 TLS 读写
 type PQCConn struct {
 	conn net.Conn
+	raw  syscall.RawConn // 底层连接的 RawConn，用于等待 socket 就绪
 	ssl  *C.SSL
 	ctx  *C.SSL_CTX
-	mu   sync.Mutex // 保护 SSL 对象的并发访问
+	mu   sync.Mutex // 保护 SSL 对象的并发访问（等待 socket 就绪期间不持有）
+
+	sessionCache *SessionCache // 客户端会话缓存（未启用会话恢复时为 nil），关闭连接时保存可恢复的会话
+}
+
+// SessionCache 保存客户端最近一次可恢复的 TLS 会话，可在多个 PQCDialer 之间共享（每次重连创建新的拨号器）
+// 会话只保存在内存中
+type SessionCache struct {
+	mu  sync.Mutex
+	der []byte
+}
+
+// NewSessionCache 创建空的客户端会话缓存
+func NewSessionCache() *SessionCache {
+	return &SessionCache{}
+}
+
+// get 返回缓存的会话
+func (s *SessionCache) get() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.der
+}
+
+// put 保存会话（nil 表示清空）
+func (s *SessionCache) put(der []byte) {
+	s.mu.Lock()
+	s.der = der
+	s.mu.Unlock()
 }
 
 // Read 从 TLS 连接读取数据
 func (c *PQCConn) Read(b []byte) (n int, err error) {
-	if c.ssl == nil {
-		return 0, errors.New("SSL connection not established")
-	}
-
 	if len(b) == 0 {
 		return 0, nil
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// 回调返回 false 时运行时会等待 socket 可读后再次调用（受读截止时间约束）
+	rawErr := c.raw.Read(func(uintptr) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
 
-	n = int(C.SSL_read(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b))))
-	if n <= 0 {
-		errCode := C.SSL_get_error(c.ssl, C.int(n))
-		if errCode == C.SSL_ERROR_ZERO_RETURN {
-			return 0, io.EOF
+		if c.ssl == nil {
+			err = errors.New("SSL connection not established")
+			return true
 		}
-		if errCode == C.SSL_ERROR_WANT_READ || errCode == C.SSL_ERROR_WANT_WRITE {
-			// 需要重试
-			return 0, nil
+
+		ret, errno := C.SSL_read(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+		if ret > 0 {
+			n = int(ret)
+			return true
 		}
-		return 0, fmt.Errorf("SSL read error: %d", errCode)
+
+		errCode := C.SSL_get_error(c.ssl, ret)
+		switch errCode {
+		case C.SSL_ERROR_WANT_READ, C.SSL_ERROR_WANT_WRITE:
+			// 数据未就绪，等待 socket 可读后重试
+			return false
+		case C.SSL_ERROR_ZERO_RETURN:
+			err = io.EOF
+		case C.SSL_ERROR_SYSCALL:
+			// 对端未发送 close_notify 直接关闭了 TCP 连接：SSL_read 返回 0 且错误队列为空，与正常关闭一样视为 EOF
+			// 错误队列为空而 ret 为 -1 时是底层 socket 的错误（例如 ECONNRESET），原样返回 errno 供调用方识别
+			switch {
+			case C.ERR_peek_error() != 0:
+				err = fmt.Errorf("SSL read error: %d (%s)", errCode, lastOpenSSLError())
+			case ret == 0 || errno == nil:
+				err = io.EOF
+			default:
+				err = errno
+			}
+			C.ERR_clear_error()
+		default:
+			err = fmt.Errorf("SSL read error: %d", errCode)
+		}
+		return true
+	})
+	if err == nil && rawErr != nil {
+		err = rawErr
 	}
-	return n, nil
+	return n, err
 }
 
 // Write 向 TLS 连接写入数据
 func (c *PQCConn) Write(b []byte) (n int, err error) {
-	if c.ssl == nil {
-		return 0, errors.New("SSL connection not established")
-	}
-
 	if len(b) == 0 {
 		return 0, nil
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// 回调返回 false 时运行时会等待 socket 可写后再次调用（受写截止时间约束）
+	// SSL_write 在 WANT_WRITE 后必须以相同参数重试，这里每次调用都传入完整的 b
+	rawErr := c.raw.Write(func(uintptr) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
 
-	n = int(C.SSL_write(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b))))
-	if n <= 0 {
-		errCode := C.SSL_get_error(c.ssl, C.int(n))
-		if errCode == C.SSL_ERROR_ZERO_RETURN {
-			return 0, io.EOF
+		if c.ssl == nil {
+			err = errors.New("SSL connection not established")
+			return true
 		}
-		if errCode == C.SSL_ERROR_WANT_READ || errCode == C.SSL_ERROR_WANT_WRITE {
-			// 需要重试，但返回 0 表示没有写入
-			return 0, nil
+
+		ret := C.SSL_write(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+		if ret > 0 {
+			n = int(ret)
+			return true
 		}
-		return 0, fmt.Errorf("SSL write error: %d", errCode)
+
+		errCode := C.SSL_get_error(c.ssl, ret)
+		switch errCode {
+		case C.SSL_ERROR_WANT_READ, C.SSL_ERROR_WANT_WRITE:
+			// 发送缓冲区已满，等待 socket 可写后重试
+			return false
+		case C.SSL_ERROR_ZERO_RETURN:
+			err = io.EOF
+		default:
+			err = fmt.Errorf("SSL write error: %d", errCode)
+		}
+		return true
+	})
+	if err == nil && rawErr != nil {
+		err = rawErr
 	}
-	return n, nil
+	return n, err
 }
 
 // Close 关闭 TLS 连接
@@ -293,6 +842,18 @@ func (c *PQCConn) Close() error {
 	defer c.mu.Unlock()
 
 	if c.ssl != nil {
+		// TLS 1.3 的会话票据在握手后才到达，关闭时保存最新的可恢复会话供下次连接使用
+		if c.sessionCache != nil {
+			var der *C.uchar
+			if n := C.get_session_der(c.ssl, &der); n > 0 {
+				c.sessionCache.put(C.GoBytes(unsafe.Pointer(der), n))
+				C.free_der(der)
+			}
+		}
+		// 单向关闭：标记为已收到对端的 close_notify，SSL_shutdown 只发送一次本端的 close_notify 就返回，
+		// 不会读取等待对端响应；socket 为非阻塞模式（由 Go 运行时管理），发送缓冲区满时也立即返回，
+		// 因此对端已失联或响应缓慢时 Close 不会挂起
+		C.SSL_set_shutdown(c.ssl, C.SSL_RECEIVED_SHUTDOWN)
 		C.SSL_shutdown(c.ssl)
 		C.SSL_free(c.ssl)
 		c.ssl = nil
@@ -328,25 +889,210 @@ func (c *PQCConn) SetWriteDeadline(t time.Time) error {
 	return c.conn.SetWriteDeadline(t)
 }
 
+// PeerCertificate 返回对端证书（握手已验证）
+// 对端未提供证书时返回错误；PQC 公钥算法 Go 无法识别，返回的证书中 PublicKey 为 nil，但主题、SAN 等字段可用
+func (c *PQCConn) PeerCertificate() (*x509.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ssl == nil {
+		return nil, errors.New("SSL connection not established")
+	}
+
+	var der *C.uchar
+	n := C.get_peer_cert_der(c.ssl, &der)
+	if n <= 0 {
+		return nil, errors.New("no peer certificate")
+	}
+	defer C.free_der(der)
+
+	return x509.ParseCertificate(C.GoBytes(unsafe.Pointer(der), n))
+}
+
+// PeerCertificateChain 返回对端的证书链（叶证书在前）：握手验证了对端证书时为验证后的完整链（直到信任锚），
+// 否则为对端发送的证书。对端未提供证书时返回错误；PQC 公钥算法的证书 PublicKey 为 nil，其余字段可用
+func (c *PQCConn) PeerCertificateChain() ([]*x509.Certificate, error) {
+	leaf, err := c.PeerCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ssl == nil {
+		return nil, errors.New("SSL connection not established")
+	}
+	var chain []*x509.Certificate
+	for i := 0; ; i++ {
+		var der *C.uchar
+		n := C.get_peer_chain_der(c.ssl, C.int(i), &der)
+		if n <= 0 {
+			break
+		}
+		cert, err := x509.ParseCertificate(C.GoBytes(unsafe.Pointer(der), n))
+		C.free_der(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	// 未经验证时服务端拿到的链不含叶证书
+	if len(chain) == 0 || !chain[0].Equal(leaf) {
+		chain = append([]*x509.Certificate{leaf}, chain...)
+	}
+	return chain, nil
+}
+
+// ConnectionState 描述已建立的 PQC TLS 连接的协商结果
+type ConnectionState struct {
+	Version            string // 协议版本（例如 TLSv1.3）
+	CipherSuite        string // 密码套件（例如 TLS_AES_256_GCM_SHA384）
+	Group              string // 密钥交换组（例如 MLKEM768）
+	Sigalg             string // 对端在握手中使用的签名算法（例如 ML-DSA-65，恢复会话或对端未发送证书时为空）
+	NegotiatedProtocol string // ALPN 协商的应用层协议（未协商时为空）
+	DidResume          bool   // 是否恢复了之前的会话
+}
+
+// ConnectionState 返回连接的协商结果（连接已关闭时返回零值）
+func (c *PQCConn) ConnectionState() ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ssl == nil {
+		return ConnectionState{}
+	}
+	state := ConnectionState{
+		Version:            C.GoString(C.SSL_get_version(c.ssl)),
+		NegotiatedProtocol: negotiatedProtocol(c.ssl),
+		DidResume:          C.SSL_session_reused(c.ssl) == 1,
+	}
+	if name := C.get_cipher_name(c.ssl); name != nil {
+		state.CipherSuite = C.GoString(name)
+	}
+	if name := C.get_group_name(c.ssl); name != nil {
+		state.Group = C.GoString(name)
+	}
+	state.Sigalg = peerSigalg(c.ssl)
+	return state
+}
+
+// encodeALPN 将协议列表编码为 ALPN 线路格式（每项为 1 字节长度 + 协议名）
+func encodeALPN(protos []string) ([]byte, error) {
+	if len(protos) == 0 {
+		return nil, errors.New("empty ALPN protocol list")
+	}
+	var wire []byte
+	for _, p := range protos {
+		if len(p) == 0 || len(p) > 255 {
+			return nil, fmt.Errorf("invalid ALPN protocol %q: length must be 1-255 bytes", p)
+		}
+		wire = append(wire, byte(len(p)))
+		wire = append(wire, p...)
+	}
+	return wire, nil
+}
+
+// negotiatedProtocol 返回握手协商的 ALPN 协议（未协商时为空）
+func negotiatedProtocol(ssl *C.SSL) string {
+	var n C.uint
+	data := C.get_alpn_selected(ssl, &n)
+	if data == nil || n == 0 {
+		return ""
+	}
+	return C.GoStringN((*C.char)(unsafe.Pointer(data)), C.int(n))
+}
+
+// checkALPN 在配置了 ALPN 时检查握手协商出的协议在 protos 中（未配置时不检查）
+func checkALPN(ssl *C.SSL, protos []string) error {
+	if len(protos) == 0 {
+		return nil
+	}
+	got := negotiatedProtocol(ssl)
+	for _, p := range protos {
+		if got == p {
+			return nil
+		}
+	}
+	if got == "" {
+		return fmt.Errorf("no ALPN protocol negotiated (expected one of %s), connection rejected", strings.Join(protos, ", "))
+	}
+	return fmt.Errorf("unexpected ALPN protocol %q negotiated (expected one of %s), connection rejected", got, strings.Join(protos, ", "))
+}
+
+// peerSigalg 返回对端在握手中使用的签名算法名称（恢复会话或对端未发送证书时为空）
+func peerSigalg(ssl *C.SSL) string {
+	if name := C.get_peer_sigalg_name(ssl); name != nil {
+		return C.GoString(name)
+	}
+	return ""
+}
+
+// recordHandshake 记录一次握手的耗时、字节数、协商的密钥交换组和对端签名算法（需在释放 ssl 之前调用）
+// 成功的握手按是否恢复会话记为 OutcomeOK 或 OutcomeResumed，失败时 outcome 为失败原因
+func recordHandshake(role string, ssl *C.SSL, start time.Time, outcome string) {
+	var nread, nwritten C.uint64_t
+	C.get_bio_bytes(ssl, &nread, &nwritten)
+	group := ""
+	if name := C.get_group_name(ssl); name != nil {
+		group = C.GoString(name)
+	}
+	if outcome == OutcomeOK && C.SSL_session_reused(ssl) == 1 {
+		outcome = OutcomeResumed
+	}
+	recordHandshakeStat(role, outcome, group, peerSigalg(ssl), time.Since(start), uint64(nread), uint64(nwritten))
+}
+
+// SessionReused 返回本次握手是否恢复了之前的会话（未进行完整的证书认证）
+func (c *PQCConn) SessionReused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ssl != nil && C.SSL_session_reused(c.ssl) == 1
+}
+
 // PQCListener 表示一个 PQC TLS 监听器（使用 OpenSSL）
 type PQCListener struct {
 	listener net.Listener
 	ctx      *C.SSL_CTX
+	minLevel int      // 要求的最低 NIST 安全级别（0 表示只要求 PQC 算法）
+	alpn     []string // 接受的 ALPN 协议（nil 表示不协商 ALPN）
+
+	handshakeTimeout time.Duration // 服务器握手超时（0 表示不设超时）
 }
 
-// Accept 接受一个新的 TLS 连接
+// DefaultServerHandshakeTimeout 服务器握手的默认超时，防止不发送数据的对端无限期占用握手
+const DefaultServerHandshakeTimeout = 10 * time.Second
+
+// Accept 接受一个新的 TLS 连接（AcceptTCP 后在当前 goroutine 中完成 Handshake，握手期间不接受其他连接）
 func (l *PQCListener) Accept() (net.Conn, error) {
-	conn, err := l.listener.Accept()
+	conn, err := l.AcceptTCP()
 	if err != nil {
 		return nil, err
 	}
+	return l.Handshake(conn)
+}
+
+// AcceptTCP 接受一个 TCP 连接但不进行握手，与 Handshake 配合使用可以并发握手（调用方负责限制并发数）
+func (l *PQCListener) AcceptTCP() (net.Conn, error) {
+	return l.listener.Accept()
+}
 
-	tcpConn := conn.(*net.TCPConn)
+// Handshake 在 AcceptTCP 返回的连接上完成服务器端握手和 PQC 算法校验，失败时关闭 conn 并返回 *HandshakeError
+// socket 为非阻塞，WANT_READ/WANT_WRITE 时等待 socket 就绪后重试，超过握手超时（SetHandshakeTimeout）时中止。
+// 可以在多个 goroutine 中并发调用，但必须在 Close 之前返回
+func (l *PQCListener) Handshake(conn net.Conn) (net.Conn, error) {
+	start := time.Now()
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("PQC TLS requires a TCP connection, got %T", conn)
+	}
 	// 使用 syscall 获取底层文件描述符
 	rawConn, err := tcpConn.SyscallConn()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to get raw connection: %v", err)
+		return nil, fmt.Errorf("failed to get raw connection: %w", err)
 	}
 
 	var fd int
@@ -355,7 +1101,7 @@ func (l *PQCListener) Accept() (net.Conn, error) {
 	})
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to get file descriptor: %v", err)
+		return nil, fmt.Errorf("failed to get file descriptor: %w", err)
 	}
 
 	ssl := C.SSL_new(l.ctx)
@@ -370,26 +1116,23 @@ func (l *PQCListener) Accept() (net.Conn, error) {
 		return nil, errors.New("failed to set SSL file descriptor")
 	}
 
-	// SSL_accept 握手（可能需要多次调用）
-	for {
-		ret := C.SSL_accept(ssl)
-		if ret > 0 {
-			// 握手成功，验证是否使用了 PQC 算法
-			if C.verify_pqc_algorithms(ssl) == 0 {
-				// 握手成功但未使用 PQC 算法，拒绝连接
-				C.SSL_free(ssl)
-				conn.Close()
-				return nil, fmt.Errorf("handshake succeeded but non-PQC algorithms were negotiated, connection rejected")
-			}
-			// PQC 算法验证通过
-			break
-		}
-		errCode := C.SSL_get_error(ssl, ret)
-		if errCode == C.SSL_ERROR_WANT_READ || errCode == C.SSL_ERROR_WANT_WRITE {
-			// 需要更多 I/O，继续重试
-			continue
+	// SSL_accept 握手
+	if l.handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(l.handshakeTimeout))
+	}
+	errCode, err := handshake(rawConn, ssl, func(s *C.SSL) C.int { return C.SSL_accept(s) })
+	if err != nil {
+		outcome := RejectHandshake
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			outcome = OutcomeTimeout
+			err = fmt.Errorf("%w after %v", ErrHandshakeTimeout, l.handshakeTimeout)
 		}
-		// 其他错误
+		recordHandshake(RoleServer, ssl, start, outcome)
+		C.SSL_free(ssl)
+		conn.Close()
+		return nil, &HandshakeError{RemoteAddr: conn.RemoteAddr(), Reason: RejectHandshake, Err: fmt.Errorf("SSL accept failed: %w", err)}
+	}
+	if errCode != 0 {
 		var errBuf [512]C.char
 		// 获取所有错误队列中的错误
 		var errNum C.ulong
@@ -404,19 +1147,105 @@ func (l *PQCListener) Accept() (net.Conn, error) {
 		if errMsg == "" {
 			errMsg = "unknown error"
 		}
-		
+		reason := classifyHandshakeFailure(errMsg)
+		recordHandshake(RoleServer, ssl, start, reason)
+
+		C.SSL_free(ssl)
+		conn.Close()
+		return nil, &HandshakeError{
+			RemoteAddr: conn.RemoteAddr(),
+			Reason:     reason,
+			Err:        fmt.Errorf("SSL accept failed: error code %d, %s", errCode, errMsg),
+		}
+	}
+
+	// 握手成功，验证是否使用了 PQC 算法
+	if C.verify_pqc_algorithms(ssl, C.int(l.minLevel)) == 0 {
+		// 握手成功但未使用 PQC 算法（或低于要求的安全级别），拒绝连接
+		recordHandshake(RoleServer, ssl, start, RejectNonPQC)
 		C.SSL_free(ssl)
 		conn.Close()
-		return nil, fmt.Errorf("SSL accept failed: error code %d, %s", errCode, errMsg)
+		err := fmt.Errorf("%w: handshake succeeded but non-PQC algorithms were negotiated, connection rejected", ErrNonPQC)
+		if l.minLevel > 0 {
+			err = fmt.Errorf("%w: handshake succeeded but algorithms below NIST security level %d were negotiated, connection rejected", ErrNonPQC, l.minLevel)
+		}
+		return nil, &HandshakeError{RemoteAddr: conn.RemoteAddr(), Reason: RejectNonPQC, Err: err}
 	}
+	// PQC 算法验证通过，配置了 ALPN 时还要求协商出其中的协议
+	if err := checkALPN(ssl, l.alpn); err != nil {
+		recordHandshake(RoleServer, ssl, start, RejectUnknownProtocol)
+		C.SSL_free(ssl)
+		conn.Close()
+		return nil, &HandshakeError{RemoteAddr: conn.RemoteAddr(), Reason: RejectUnknownProtocol, Err: err}
+	}
+	recordHandshake(RoleServer, ssl, start, OutcomeOK)
+	conn.SetDeadline(time.Time{})
 
 	return &PQCConn{
 		conn: conn,
+		raw:  rawConn,
 		ssl:  ssl,
 		ctx:  l.ctx,
 	}, nil
 }
 
+// SetHandshakeTimeout 设置服务器握手的超时，从 Handshake 开始计算（默认 DefaultServerHandshakeTimeout，0 表示不设超时）
+func (l *PQCListener) SetHandshakeTimeout(timeout time.Duration) {
+	l.handshakeTimeout = timeout
+}
+
+// SetSessionResumption 设置是否允许客户端恢复会话（默认禁用，需在 Accept 之前调用）
+// 启用后服务器发送 TLS 1.3 会话票据，重连的客户端可跳过证书认证和签名，显著降低 PQC 握手开销；
+// 恢复时仍通过 ML-KEM 协商新的密钥，但票据加密密钥或客户端缓存的会话泄露时，攻击者可在票据有效期内冒充该客户端，
+// 且恢复的会话不会重新校验证书
+func (l *PQCListener) SetSessionResumption(enabled bool) error {
+	flag := C.int(0)
+	if enabled {
+		flag = 1
+	}
+	if C.set_server_session_resumption(l.ctx, flag) <= 0 {
+		return errors.New("failed to configure TLS session resumption")
+	}
+	return nil
+}
+
+// SetRequireClientCert 设置是否要求客户端证书（默认要求，即 mTLS，需在 Accept 之前调用）
+// 不要求时为单向 TLS：客户端验证服务器证书，服务器只校验客户端主动提供的证书，
+// 未提供证书的客户端同样可以完成握手（PeerCertificate 返回错误），其认证需由上层负责
+func (l *PQCListener) SetRequireClientCert(require bool) {
+	flag := C.int(0)
+	if require {
+		flag = 1
+	}
+	C.set_server_verify(l.ctx, flag)
+}
+
+// SetMinSecurityLevel 要求握手使用的算法达到指定的 NIST 安全级别（1-5，0 表示使用默认算法列表）
+// 按级别重新设置密钥交换组和签名算法列表，并在握手后拒绝低于该级别的密钥交换组或对端证书
+func (l *PQCListener) SetMinSecurityLevel(level int) error {
+	if err := setMinSecurityLevel(l.ctx, level); err != nil {
+		return err
+	}
+	l.minLevel = level
+	return nil
+}
+
+// SetALPNProtocols 设置接受的 ALPN 协议（按优先顺序，需在 Accept 之前调用）
+// 设置后客户端未提供 ALPN 或没有共同协议的连接在握手中被拒绝（no_application_protocol 警报）
+func (l *PQCListener) SetALPNProtocols(protos []string) error {
+	wire, err := encodeALPN(protos)
+	if err != nil {
+		return err
+	}
+	cWire := C.CBytes(wire)
+	defer C.free(cWire)
+	if C.set_server_alpn(l.ctx, (*C.uchar)(cWire), C.uint(len(wire))) != 1 {
+		return errors.New("failed to set ALPN protocols")
+	}
+	l.alpn = protos
+	return nil
+}
+
 // Close 关闭监听器
 func (l *PQCListener) Close() error {
 	if l.ctx != nil {
@@ -433,22 +1262,105 @@ func (l *PQCListener) Addr() net.Addr {
 
 // PQCDialer 用于创建 PQC TLS 客户端连接（使用 OpenSSL）
 type PQCDialer struct {
-	ctx *C.SSL_CTX
+	ctx          *C.SSL_CTX
+	sessionCache *SessionCache // 客户端会话缓存（nil 表示不恢复会话，默认）
+	minLevel     int           // 要求的最低 NIST 安全级别（0 表示只要求 PQC 算法）
+
+	dialTimeout      time.Duration // TCP 连接超时（0 表示不设超时，由操作系统决定）
+	handshakeTimeout time.Duration // TLS 握手超时（0 表示不设超时）
+	alpn             []string      // 提供的 ALPN 协议（nil 表示不协商 ALPN）
+
+	hasCA             bool   // 是否配置了验证服务器证书的 CA
+	serverFingerprint []byte // 固定的服务器证书 SHA-256 指纹（nil 表示不校验指纹）
+
+	dialControl func(network, address string, c syscall.RawConn) error // 建立 TCP 连接前调用的 socket 控制函数（可选）
+}
+
+// SetALPNProtocols 设置握手时提供的 ALPN 协议（按优先顺序）
+// 设置后服务器未选择其中任何协议（例如服务器未启用 ALPN）的连接在握手后被拒绝
+func (d *PQCDialer) SetALPNProtocols(protos []string) error {
+	wire, err := encodeALPN(protos)
+	if err != nil {
+		return err
+	}
+	cWire := C.CBytes(wire)
+	defer C.free(cWire)
+	// SSL_CTX_set_alpn_protos 成功时返回 0
+	if C.SSL_CTX_set_alpn_protos(d.ctx, (*C.uchar)(cWire), C.uint(len(wire))) != 0 {
+		return errors.New("failed to set ALPN protocols")
+	}
+	d.alpn = protos
+	return nil
+}
+
+// SetDialTimeout 设置建立 TCP 连接的超时（0 表示不设超时）
+func (d *PQCDialer) SetDialTimeout(timeout time.Duration) {
+	d.dialTimeout = timeout
+}
+
+// SetDialControl 设置建立 TCP 连接前调用的 socket 控制函数（例如设置缓冲区大小），在 TLS 握手之前生效
+func (d *PQCDialer) SetDialControl(control func(network, address string, c syscall.RawConn) error) {
+	d.dialControl = control
+}
+
+// SetHandshakeTimeout 设置 TLS 握手的超时，从 TCP 连接建立后开始计算（0 表示不设超时）
+func (d *PQCDialer) SetHandshakeTimeout(timeout time.Duration) {
+	d.handshakeTimeout = timeout
+}
+
+// SetMinSecurityLevel 要求握手使用的算法达到指定的 NIST 安全级别（见 PQCListener.SetMinSecurityLevel）
+func (d *PQCDialer) SetMinSecurityLevel(level int) error {
+	if err := setMinSecurityLevel(d.ctx, level); err != nil {
+		return err
+	}
+	d.minLevel = level
+	return nil
+}
+
+// SetServerFingerprint 固定服务器证书的 SHA-256 指纹（见 ParseFingerprint），握手完成后指纹不匹配的连接被拒绝
+// 拨号器未配置 CA 时不再通过 CA 验证服务器证书，只校验指纹（用于自签名证书）；同时配置了 CA 时两者都必须通过。
+// nil 表示不校验指纹（默认）
+func (d *PQCDialer) SetServerFingerprint(fp []byte) {
+	d.serverFingerprint = fp
+	if fp != nil && !d.hasCA {
+		C.set_client_verify_pinned(d.ctx)
+	}
+}
+
+// SetSessionCache 启用会话恢复：Dial 时尝试恢复缓存中的会话，连接关闭时保存新的会话
+// nil 表示每次都进行完整握手（默认）
+func (d *PQCDialer) SetSessionCache(cache *SessionCache) {
+	d.sessionCache = cache
 }
 
 // Dial 连接到服务器并建立 TLS 连接
 func (d *PQCDialer) Dial(network, address string) (net.Conn, error) {
-	conn, err := net.Dial(network, address)
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext 连接到服务器并建立 TLS 连接，ctx 只作用于 TCP 连接阶段，握手受 SetHandshakeTimeout 约束
+func (d *PQCDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.dialTimeout, Control: d.dialControl}
+	conn, err := dialer.DialContext(ctx, network, address)
 	if err != nil {
 		return nil, err
 	}
+	return d.Client(conn)
+}
 
-	tcpConn := conn.(*net.TCPConn)
+// Client 在已建立的 TCP 连接上完成 TLS 握手（例如经 HTTP CONNECT 代理建立的隧道），握手失败时关闭 conn
+// OpenSSL 直接读写文件描述符，因此 conn 必须是 *net.TCPConn，且不能有已读取但未处理的数据
+func (d *PQCDialer) Client(conn net.Conn) (net.Conn, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("PQC TLS requires a TCP connection, got %T", conn)
+	}
 	// 使用 syscall 获取底层文件描述符
 	rawConn, err := tcpConn.SyscallConn()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to get raw connection: %v", err)
+		return nil, fmt.Errorf("failed to get raw connection: %w", err)
 	}
 
 	var fd int
@@ -457,7 +1369,7 @@ func (d *PQCDialer) Dial(network, address string) (net.Conn, error) {
 	})
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to get file descriptor: %v", err)
+		return nil, fmt.Errorf("failed to get file descriptor: %w", err)
 	}
 
 	ssl := C.SSL_new(d.ctx)
@@ -472,26 +1384,66 @@ func (d *PQCDialer) Dial(network, address string) (net.Conn, error) {
 		return nil, errors.New("failed to set SSL file descriptor")
 	}
 
-	// SSL_connect 握手（可能需要多次调用）
-	for {
-		ret := C.SSL_connect(ssl)
-		if ret > 0 {
-			// 握手成功，验证是否使用了 PQC 算法
-			if C.verify_pqc_algorithms(ssl) == 0 {
-				// 握手成功但未使用 PQC 算法，拒绝连接
+	// 尝试恢复缓存的会话（服务器不接受时自动回退到完整握手）
+	if d.sessionCache != nil {
+		if der := d.sessionCache.get(); len(der) > 0 {
+			cDer := C.CBytes(der)
+			C.set_session_der(ssl, (*C.uchar)(cDer), C.long(len(der)))
+			C.free(cDer)
+		}
+	}
+
+	// SSL_connect 握手：socket 为非阻塞，WANT_READ/WANT_WRITE 时等待 socket 就绪后重试，截止时间到达时中止
+	start := time.Now()
+	if d.handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.handshakeTimeout))
+	}
+	errCode, err := handshake(rawConn, ssl, func(s *C.SSL) C.int { return C.SSL_connect(s) })
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			recordHandshake(RoleClient, ssl, start, OutcomeTimeout)
+		} else {
+			recordHandshake(RoleClient, ssl, start, RejectHandshake)
+		}
+		C.SSL_free(ssl)
+		conn.Close()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %v", ErrHandshakeTimeout, d.handshakeTimeout)
+		}
+		return nil, fmt.Errorf("SSL connect failed: %w", err)
+	}
+	if errCode == 0 {
+		// 握手成功，验证是否使用了 PQC 算法
+		if C.verify_pqc_algorithms(ssl, C.int(d.minLevel)) == 0 {
+			// 握手成功但未使用 PQC 算法（或低于要求的安全级别），拒绝连接
+			recordHandshake(RoleClient, ssl, start, RejectNonPQC)
+			C.SSL_free(ssl)
+			conn.Close()
+			if d.minLevel > 0 {
+				return nil, fmt.Errorf("%w: handshake succeeded but algorithms below NIST security level %d were negotiated, connection rejected", ErrNonPQC, d.minLevel)
+			}
+			return nil, fmt.Errorf("%w: handshake succeeded but non-PQC algorithms were negotiated, connection rejected", ErrNonPQC)
+		}
+		// 固定了服务器证书指纹时比较实际的证书
+		if d.serverFingerprint != nil {
+			if err := checkPeerFingerprint(ssl, d.serverFingerprint); err != nil {
+				recordHandshake(RoleClient, ssl, start, RejectCertificate)
 				C.SSL_free(ssl)
 				conn.Close()
-				return nil, fmt.Errorf("handshake succeeded but non-PQC algorithms were negotiated, connection rejected")
+				return nil, err
 			}
-			// PQC 算法验证通过
-			break
 		}
-		errCode := C.SSL_get_error(ssl, ret)
-		if errCode == C.SSL_ERROR_WANT_READ || errCode == C.SSL_ERROR_WANT_WRITE {
-			// 需要更多 I/O，继续重试
-			continue
+		// PQC 算法验证通过，配置了 ALPN 时还要求服务器选择了其中的协议
+		if err := checkALPN(ssl, d.alpn); err != nil {
+			recordHandshake(RoleClient, ssl, start, RejectUnknownProtocol)
+			C.SSL_free(ssl)
+			conn.Close()
+			return nil, err
 		}
-		// 其他错误
+		recordHandshake(RoleClient, ssl, start, OutcomeOK)
+		conn.SetDeadline(time.Time{})
+	} else {
+		// 握手失败
 		var errBuf [512]C.char
 		// 获取所有错误队列中的错误
 		var errNum C.ulong
@@ -506,16 +1458,23 @@ func (d *PQCDialer) Dial(network, address string) (net.Conn, error) {
 		if errMsg == "" {
 			errMsg = "unknown error"
 		}
-		
+		reason := classifyHandshakeFailure(errMsg)
+		recordHandshake(RoleClient, ssl, start, reason)
+
 		C.SSL_free(ssl)
 		conn.Close()
+		if reason == RejectCertificate {
+			return nil, fmt.Errorf("%w: SSL connect failed: error code %d, %s", ErrCertificateRejected, errCode, errMsg)
+		}
 		return nil, fmt.Errorf("SSL connect failed: error code %d, %s", errCode, errMsg)
 	}
 
 	return &PQCConn{
-		conn: conn,
-		ssl:  ssl,
-		ctx:  d.ctx,
+		conn:         conn,
+		raw:          rawConn,
+		ssl:          ssl,
+		ctx:          d.ctx,
+		sessionCache: d.sessionCache,
 	}, nil
 }
 
@@ -528,18 +1487,56 @@ func (d *PQCDialer) Close() error {
 	return nil
 }
 
+// handshake 在非阻塞 socket 上驱动握手，step 为 SSL_connect 或 SSL_accept
+// step 在 RawConn 回调中调用（与 PQCConn.Read/Write 相同），WANT_READ/WANT_WRITE 时等待 socket 可读/可写后重试，
+// 等待受底层连接的截止时间约束。返回 0 表示握手成功，否则返回 SSL_get_error 的错误码；等待失败（超时、连接关闭）时返回 err
+func handshake(raw syscall.RawConn, ssl *C.SSL, step func(*C.SSL) C.int) (C.int, error) {
+	want := C.int(C.SSL_ERROR_WANT_READ)
+	for {
+		var errCode C.int
+		cb := func(uintptr) bool {
+			ret := step(ssl)
+			if ret > 0 {
+				errCode = 0
+				return true
+			}
+			errCode = C.SSL_get_error(ssl, ret)
+			// 仍在等待同一方向时由运行时等待 socket 就绪后再次调用
+			return errCode != want
+		}
+		var err error
+		if want == C.SSL_ERROR_WANT_WRITE {
+			err = raw.Write(cb)
+		} else {
+			err = raw.Read(cb)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if errCode == C.SSL_ERROR_WANT_READ || errCode == C.SSL_ERROR_WANT_WRITE {
+			// 等待方向改变，换用另一种等待方式
+			want = errCode
+			continue
+		}
+		return errCode, nil
+	}
+}
+
 // NewPQCListenerOpenSSL 创建一个新的 PQC TLS 监听器（使用 OpenSSL）
 func NewPQCListenerOpenSSL(listener net.Listener, certFile, keyFile, caFile string) (*PQCListener, error) {
+	if initErr != nil {
+		return nil, initErr
+	}
 	// 检查文件是否存在
 	if _, err := os.Stat(certFile); os.IsNotExist(err) {
-		return nil, fmt.Errorf("certificate file not found: %s", certFile)
+		return nil, fmt.Errorf("certificate %w: %s", ErrCertificateNotFound, certFile)
 	}
 	if _, err := os.Stat(keyFile); os.IsNotExist(err) {
-		return nil, fmt.Errorf("key file not found: %s", keyFile)
+		return nil, fmt.Errorf("key %w: %s", ErrCertificateNotFound, keyFile)
 	}
 	if caFile != "" {
 		if _, err := os.Stat(caFile); os.IsNotExist(err) {
-			return nil, fmt.Errorf("CA file not found: %s", caFile)
+			return nil, fmt.Errorf("CA %w: %s", ErrCertificateNotFound, caFile)
 		}
 	}
 
@@ -557,22 +1554,34 @@ func NewPQCListenerOpenSSL(listener net.Listener, certFile, keyFile, caFile stri
 
 	ctx := C.create_server_ctx(cCertFile, cKeyFile, cCaFile)
 	if ctx == nil {
-		return nil, errors.New("failed to create SSL context for server")
+		return nil, fmt.Errorf("%w: failed to create SSL context for server", ErrInvalidCertificate)
+	}
+	// 默认禁用会话恢复，每次连接都进行完整的证书认证（见 SetSessionResumption）
+	if C.set_server_session_resumption(ctx, 0) <= 0 {
+		C.SSL_CTX_free(ctx)
+		return nil, errors.New("failed to disable TLS session resumption")
+	}
+	if keyLogEnabled() {
+		C.enable_keylog(ctx)
 	}
 
 	return &PQCListener{
-		listener: listener,
-		ctx:      ctx,
+		listener:         listener,
+		ctx:              ctx,
+		handshakeTimeout: DefaultServerHandshakeTimeout,
 	}, nil
 }
 
 // NewPQCDialerOpenSSL 创建一个新的 PQC TLS 拨号器（使用 OpenSSL）
 func NewPQCDialerOpenSSL(certFile, keyFile, caFile string) (*PQCDialer, error) {
+	if initErr != nil {
+		return nil, initErr
+	}
 	var cCertFile, cKeyFile, cCaFile *C.char
 
 	if certFile != "" {
 		if _, err := os.Stat(certFile); os.IsNotExist(err) {
-			return nil, fmt.Errorf("certificate file not found: %s", certFile)
+			return nil, fmt.Errorf("certificate %w: %s", ErrCertificateNotFound, certFile)
 		}
 		cCertFile = C.CString(certFile)
 		defer C.free(unsafe.Pointer(cCertFile))
@@ -580,7 +1589,7 @@ func NewPQCDialerOpenSSL(certFile, keyFile, caFile string) (*PQCDialer, error) {
 
 	if keyFile != "" {
 		if _, err := os.Stat(keyFile); os.IsNotExist(err) {
-			return nil, fmt.Errorf("key file not found: %s", keyFile)
+			return nil, fmt.Errorf("key %w: %s", ErrCertificateNotFound, keyFile)
 		}
 		cKeyFile = C.CString(keyFile)
 		defer C.free(unsafe.Pointer(cKeyFile))
@@ -588,7 +1597,7 @@ func NewPQCDialerOpenSSL(certFile, keyFile, caFile string) (*PQCDialer, error) {
 
 	if caFile != "" {
 		if _, err := os.Stat(caFile); os.IsNotExist(err) {
-			return nil, fmt.Errorf("CA file not found: %s", caFile)
+			return nil, fmt.Errorf("CA %w: %s", ErrCertificateNotFound, caFile)
 		}
 		cCaFile = C.CString(caFile)
 		defer C.free(unsafe.Pointer(cCaFile))
@@ -596,11 +1605,30 @@ func NewPQCDialerOpenSSL(certFile, keyFile, caFile string) (*PQCDialer, error) {
 
 	ctx := C.create_client_ctx(cCertFile, cKeyFile, cCaFile)
 	if ctx == nil {
-		return nil, errors.New("failed to create SSL context for client")
+		return nil, fmt.Errorf("%w: failed to create SSL context for client", ErrInvalidCertificate)
+	}
+	if keyLogEnabled() {
+		C.enable_keylog(ctx)
 	}
 
 	return &PQCDialer{
-		ctx: ctx,
+		ctx:   ctx,
+		hasCA: caFile != "",
 	}, nil
 }
 
+// checkPeerFingerprint 比较对端证书的 SHA-256 指纹与 want
+func checkPeerFingerprint(ssl *C.SSL, want []byte) error {
+	var der *C.uchar
+	n := C.get_peer_cert_der(ssl, &der)
+	if n <= 0 {
+		return fmt.Errorf("%w: server sent no certificate, fingerprint cannot be verified", ErrFingerprintMismatch)
+	}
+	defer C.free_der(der)
+	got := sha256.Sum256(C.GoBytes(unsafe.Pointer(der), n))
+	if subtle.ConstantTimeCompare(got[:], want) != 1 {
+		return fmt.Errorf("%w: got %s, want %s", ErrFingerprintMismatch, FormatFingerprint(got[:]), FormatFingerprint(want))
+	}
+	return nil
+}
+
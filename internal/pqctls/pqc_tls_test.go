@@ -0,0 +1,70 @@
+package pqctls
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseFingerprint 测试解析带或不带冒号、前缀的 SHA-256 指纹，以及拒绝长度或字符不正确的指纹
+func TestParseFingerprint(t *testing.T) {
+	const plain = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	colons := FormatFingerprint(mustParse(t, plain))
+	if want := "01:23:45:67:89:AB:CD:EF"; !strings.HasPrefix(colons, want) {
+		t.Fatalf("格式化的指纹应以 %s 开头: %s", want, colons)
+	}
+	for _, s := range []string{plain, strings.ToUpper(plain), colons, "sha256:" + plain, "SHA256 Fingerprint=" + colons, " " + plain + "\n"} {
+		fp, err := ParseFingerprint(s)
+		if err != nil {
+			t.Errorf("解析 %q 失败: %v", s, err)
+			continue
+		}
+		if got := FormatFingerprint(fp); got != colons {
+			t.Errorf("解析 %q 得到 %s, 期望 %s", s, got, colons)
+		}
+	}
+	for _, s := range []string{"", plain[:62], plain + "00", "zz" + plain[2:], "sha1:" + plain} {
+		if _, err := ParseFingerprint(s); err == nil {
+			t.Errorf("应拒绝指纹 %q", s)
+		}
+	}
+	// 十六进制解码错误被包装，调用方可以取出原始错误
+	var byteErr hex.InvalidByteError
+	if _, err := ParseFingerprint("zz" + plain[2:]); !errors.As(err, &byteErr) {
+		t.Errorf("错误应包装 hex.InvalidByteError: %v", err)
+	}
+}
+
+// TestCertificateFileErrors 测试证书文件不存在和无法加载的错误可以分别用 ErrCertificateNotFound 和 ErrInvalidCertificate 判断
+func TestCertificateFileErrors(t *testing.T) {
+	if err := Ready(); err != nil {
+		t.Skipf("PQC 算法不可用: %v", err)
+	}
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.crt")
+	if err := CheckCertificateFiles("", "", missing); !errors.Is(err, ErrCertificateNotFound) || errors.Is(err, ErrInvalidCertificate) {
+		t.Errorf("文件不存在时应返回 ErrCertificateNotFound: %v", err)
+	}
+	if _, err := NewPQCDialerOpenSSL(missing, missing, ""); !errors.Is(err, ErrCertificateNotFound) {
+		t.Errorf("创建拨号器时文件不存在应返回 ErrCertificateNotFound: %v", err)
+	}
+	garbage := filepath.Join(dir, "garbage.crt")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCertificateFiles("", "", garbage); !errors.Is(err, ErrInvalidCertificate) || errors.Is(err, ErrCertificateNotFound) {
+		t.Errorf("文件无法加载时应返回 ErrInvalidCertificate: %v", err)
+	}
+}
+
+func mustParse(t *testing.T, s string) []byte {
+	t.Helper()
+	fp, err := ParseFingerprint(s)
+	if err != nil {
+		t.Fatalf("解析 %q 失败: %v", s, err)
+	}
+	return fp
+}
@@ -0,0 +1,105 @@
+//go:build cgo
+// +build cgo
+
+package pqctls
+
+/*
+#cgo CFLAGS: -I/opt/openssl-oqs/include
+#cgo LDFLAGS: -L/opt/openssl-oqs/lib -lssl -lcrypto -ldl -lpthread
+#cgo LDFLAGS: -Wl,-rpath,/opt/openssl-oqs/lib
+
+#include <stdlib.h>
+#include <openssl/err.h>
+#include <openssl/evp.h>
+#include <openssl/pem.h>
+#include <openssl/x509.h>
+#include <openssl/x509v3.h>
+
+// 生成 ML-DSA-65 密钥和以其自签名的 CA 证书，PEM 编码后分别写入 cert_out 和 key_out
+// 证书有效期从现在前 60 秒（容忍时钟偏差）到 seconds 秒之后，serial 为证书序列号
+static int generate_self_signed(const char* cn, long seconds, long serial, BIO* cert_out, BIO* key_out) {
+    int ok = 0;
+    X509* x = NULL;
+    X509_EXTENSION* ext = NULL;
+
+    ERR_clear_error();
+    EVP_PKEY* pkey = EVP_PKEY_Q_keygen(NULL, NULL, "MLDSA65");
+    if (!pkey) {
+        return 0;
+    }
+    x = X509_new();
+    if (!x) {
+        goto done;
+    }
+    X509_NAME* name = X509_get_subject_name(x);
+    if (X509_set_version(x, X509_VERSION_3) != 1 ||
+        ASN1_INTEGER_set(X509_get_serialNumber(x), serial) != 1 ||
+        !X509_gmtime_adj(X509_getm_notBefore(x), -60) ||
+        !X509_gmtime_adj(X509_getm_notAfter(x), seconds) ||
+        X509_set_pubkey(x, pkey) != 1 ||
+        X509_NAME_add_entry_by_txt(name, "CN", MBSTRING_UTF8, (const unsigned char*)cn, -1, -1, 0) != 1 ||
+        X509_set_issuer_name(x, name) != 1) {
+        goto done;
+    }
+    // 同一证书同时作为对端证书和 CA：CA:TRUE，不限制用途（没有 keyUsage/extendedKeyUsage），服务器和客户端都可以使用
+    ext = X509V3_EXT_conf_nid(NULL, NULL, NID_basic_constraints, "critical,CA:TRUE");
+    if (!ext || X509_add_ext(x, ext, -1) != 1) {
+        goto done;
+    }
+    // ML-DSA 不使用单独的摘要算法
+    if (X509_sign(x, pkey, NULL) <= 0) {
+        goto done;
+    }
+    ok = PEM_write_bio_X509(cert_out, x) == 1 &&
+         PEM_write_bio_PrivateKey(key_out, pkey, NULL, NULL, 0, NULL, NULL) == 1;
+
+done:
+    X509_EXTENSION_free(ext);
+    X509_free(x);
+    EVP_PKEY_free(pkey);
+    return ok;
+}
+
+static long bio_mem_data(BIO* b, char** data) {
+    return BIO_get_mem_data(b, data);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// GenerateSelfSignedCert 生成 ML-DSA-65 密钥和以其自签名的证书，返回 PEM 编码的证书和私钥
+// 证书同时作为 CA（basicConstraints CA:TRUE，不限制用途），可以同时用作服务器和客户端的证书、私钥和 CA 文件，
+// 用于自检等不需要真实 PKI 的场景。需要支持 ML-DSA 的 provider（见 Ready）
+func GenerateSelfSignedCert(commonName string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	if initErr != nil {
+		return nil, nil, initErr
+	}
+
+	certBIO := C.BIO_new(C.BIO_s_mem())
+	keyBIO := C.BIO_new(C.BIO_s_mem())
+	defer C.BIO_free(certBIO)
+	defer C.BIO_free(keyBIO)
+	if certBIO == nil || keyBIO == nil {
+		return nil, nil, fmt.Errorf("failed to allocate BIO: %s", lastOpenSSLError())
+	}
+
+	cCN := C.CString(commonName)
+	defer C.free(unsafe.Pointer(cCN))
+	serial := C.long(time.Now().UnixNano() & 0x7fffffff)
+	if C.generate_self_signed(cCN, C.long(validFor/time.Second), serial, certBIO, keyBIO) != 1 {
+		return nil, nil, fmt.Errorf("failed to generate ML-DSA-65 certificate: %s", lastOpenSSLError())
+	}
+	return bioBytes(certBIO), bioBytes(keyBIO), nil
+}
+
+// bioBytes 复制内存 BIO 中的数据
+func bioBytes(b *C.BIO) []byte {
+	var data *C.char
+	n := C.bio_mem_data(b, &data)
+	return C.GoBytes(unsafe.Pointer(data), C.int(n))
+}
@@ -2,8 +2,10 @@ package proto
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -20,8 +22,85 @@ const (
 	FrameTypeCLOSE FrameType = 0x03
 	// FrameTypeINIT 表示初始化配置（client → server）
 	FrameTypeINIT FrameType = 0x04
+	// FrameTypeREDIRECT 表示要求客户端改连其他服务器（server → client）
+	// 负载为空时表示要求客户端重新连接当前服务器（例如控制连接达到最大存活时间）
+	FrameTypeREDIRECT FrameType = 0x05
+	// FrameTypeASSIGNED 表示 INIT 配置已生效（server → client），负载为实际的公开监听地址
+	FrameTypeASSIGNED FrameType = 0x06
+	// FrameTypeERROR 表示 INIT 配置失败（server → client），负载为错误原因
+	FrameTypeERROR FrameType = 0x07
+	// FrameTypeHELLO 表示协议特性协商（client → server 声明支持和必需的特性，server → client 回复双方的交集）
+	FrameTypeHELLO FrameType = 0x08
+	// FrameTypeHEALTH_CHECK 表示要求客户端检查本地服务（server → client，需协商 FeatureHealthCheck），connID 为探测序号
+	FrameTypeHEALTH_CHECK FrameType = 0x09
+	// FrameTypeHEALTH_REPORT 表示本地服务检查结果（client → server），connID 为所回复探测的序号
+	FrameTypeHEALTH_REPORT FrameType = 0x0a
+	// FrameTypeBYE 表示客户端正常退出（client → server，负载为空），服务器立即注销客户端，不等待控制连接断开
+	FrameTypeBYE FrameType = 0x0b
+	// FrameTypeNEW_CONN_ACK 表示客户端已建立 NEW_CONN 对应的本地连接（client → server，需协商 FeatureConnAck，负载为空）
+	FrameTypeNEW_CONN_ACK FrameType = 0x0c
+	// FrameTypeOBSERVE 表示请求以观察者身份接收隧道事件（client → server，负载为空），观察者连接不分配端口、不参与转发
+	FrameTypeOBSERVE FrameType = 0x0d
+	// FrameTypeEVENT 表示推送给观察者连接的隧道事件（server → client），负载为 Event
+	FrameTypeEVENT FrameType = 0x0e
+	// FrameTypeWINDOW_UPDATE 表示接收方已把一个连接的数据写入本地连接，授予发送方更多发送额度
+	// （client → server，需协商 FeatureFlowControl），负载为 4 字节大端的增量字节数
+	FrameTypeWINDOW_UPDATE FrameType = 0x0f
 )
 
+// String 返回帧类型的名称（用于日志和指标标签），未知类型返回 "unknown"
+func (t FrameType) String() string {
+	switch t {
+	case FrameTypeNEW_CONN:
+		return "new_conn"
+	case FrameTypeDATA:
+		return "data"
+	case FrameTypeCLOSE:
+		return "close"
+	case FrameTypeINIT:
+		return "init"
+	case FrameTypeREDIRECT:
+		return "redirect"
+	case FrameTypeASSIGNED:
+		return "assigned"
+	case FrameTypeERROR:
+		return "error"
+	case FrameTypeHELLO:
+		return "hello"
+	case FrameTypeHEALTH_CHECK:
+		return "health_check"
+	case FrameTypeHEALTH_REPORT:
+		return "health_report"
+	case FrameTypeBYE:
+		return "bye"
+	case FrameTypeNEW_CONN_ACK:
+		return "new_conn_ack"
+	case FrameTypeOBSERVE:
+		return "observe"
+	case FrameTypeEVENT:
+		return "event"
+	case FrameTypeWINDOW_UPDATE:
+		return "window_update"
+	default:
+		return "unknown"
+	}
+}
+
+// FrameHeaderSize 帧头的长度：frame_type(1) + conn_id(4) + payload_len(4)
+const FrameHeaderSize = 9
+
+// MaxPayloadSize 单个帧负载的最大长度，超过时 DecodeFrame 返回错误而不分配缓冲区
+// 防止对端通过伪造的 payload_len 让接收方分配大量内存
+const MaxPayloadSize = 16 << 20
+
+// MaxDataPayloadSize DATA 帧负载的最大长度
+// 发送方转发数据时按不超过该大小的分块发送，WriteFrame 和 EncodeFrame 拒绝更大的 DATA 帧；
+// 接收方的 DecodeFrame 在分配缓冲区之前拒绝超过它的 DATA 帧，每个连接的数据路径在内存中最多持有一个分块，与传输的总数据量无关
+const MaxDataPayloadSize = 32 << 10
+
+// MaxInitPayloadSize INIT 帧负载的最大长度
+const MaxInitPayloadSize = 4096
+
 // Frame 表示一个协议帧
 // 帧格式：1 byte frame_type | 4 bytes conn_id | 4 bytes payload_len | payload...
 type Frame struct {
@@ -36,6 +115,9 @@ func EncodeFrame(f *Frame) ([]byte, error) {
 	if f == nil {
 		return nil, io.ErrUnexpectedEOF
 	}
+	if err := checkDataPayload(f.Type, len(f.Payload)); err != nil {
+		return nil, err
+	}
 
 	// 计算总长度：1 + 4 + 4 + payload_len
 	payloadLen := len(f.Payload)
@@ -65,12 +147,84 @@ func EncodeFrame(f *Frame) ([]byte, error) {
 	return buf, nil
 }
 
+// AppendFrame 将 Frame 编码后追加到 buf 末尾，返回追加后的切片
+// 用于把多个小帧编码到同一个缓冲区后一次写出
+func AppendFrame(buf []byte, f *Frame) ([]byte, error) {
+	if f == nil {
+		return buf, io.ErrUnexpectedEOF
+	}
+	if err := checkDataPayload(f.Type, len(f.Payload)); err != nil {
+		return buf, err
+	}
+
+	var header [9]byte
+	header[0] = byte(f.Type)
+	binary.BigEndian.PutUint32(header[1:5], f.ConnID)
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(f.Payload)))
+	buf = append(buf, header[:]...)
+	return append(buf, f.Payload...), nil
+}
+
+// WriteFrame 将 Frame 直接写入 w，不把负载复制到中间缓冲区
+// 帧头和负载通过 net.Buffers 写出：w 为 TCP 连接时合并为一次 writev 系统调用，
+// 其他 Writer 上依次调用 Write，调用方需要自行保证并发写入者不会交错
+func WriteFrame(w io.Writer, f *Frame) error {
+	if f == nil {
+		return io.ErrUnexpectedEOF
+	}
+	if err := checkDataPayload(f.Type, len(f.Payload)); err != nil {
+		return err
+	}
+
+	var header [9]byte
+	header[0] = byte(f.Type)
+	binary.BigEndian.PutUint32(header[1:5], f.ConnID)
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(f.Payload)))
+
+	if len(f.Payload) == 0 {
+		_, err := w.Write(header[:])
+		return err
+	}
+	bufs := net.Buffers{header[:], f.Payload}
+	_, err := bufs.WriteTo(w)
+	return err
+}
+
+// 解码帧的错误，可用 errors.Is 判断；两种截断错误同时匹配 io.ErrUnexpectedEOF
+var (
+	ErrTruncatedHeader  = fmt.Errorf("truncated frame header: %w", io.ErrUnexpectedEOF)
+	ErrTruncatedPayload = fmt.Errorf("truncated frame payload: %w", io.ErrUnexpectedEOF)
+	ErrPayloadTooLarge  = errors.New("frame payload too large")
+	// ErrDataPayloadTooLarge DATA 帧负载超过上限（MaxDataPayloadSize 或 DecodeFrameLimits 的 maxData），同时匹配 ErrPayloadTooLarge
+	ErrDataPayloadTooLarge = fmt.Errorf("data %w", ErrPayloadTooLarge)
+)
+
 // DecodeFrame 从 io.Reader 读取并解码一个完整的帧
-// 该函数会阻塞直到读取到完整的帧数据
+// 该函数会阻塞直到读取到完整的帧数据；在帧边界处遇到 EOF 时返回 io.EOF，
+// 帧头读取到一半时返回 ErrTruncatedHeader，帧头完整但负载缺失时返回 ErrTruncatedPayload，
+// 负载长度超过上限时返回包装了 ErrPayloadTooLarge 的错误；读取底层连接的其他错误原样返回
 func DecodeFrame(r io.Reader) (*Frame, error) {
+	return DecodeFrameLimit(r, MaxPayloadSize)
+}
+
+// DecodeFrameLimit 与 DecodeFrame 相同，但负载长度的上限为 maxPayload（不超过 MaxPayloadSize），
+// 用于在分配缓冲区之前以更小的上限拒绝帧（例如尚未完成握手的对端）
+func DecodeFrameLimit(r io.Reader, maxPayload uint32) (*Frame, error) {
+	return DecodeFrameLimits(r, maxPayload, 0)
+}
+
+// DecodeFrameLimits 与 DecodeFrameLimit 相同，但 DATA 帧负载长度的上限为 maxData（0 或超过 MaxDataPayloadSize 时为 MaxDataPayloadSize），
+// 用于在分配缓冲区之前拒绝超过协商上限的 DATA 帧，此时返回包装了 ErrDataPayloadTooLarge 的错误
+func DecodeFrameLimits(r io.Reader, maxPayload, maxData uint32) (*Frame, error) {
+	if maxPayload > MaxPayloadSize {
+		maxPayload = MaxPayloadSize
+	}
 	// 读取帧头：frame_type(1) + conn_id(4) + payload_len(4) = 9 bytes
-	header := make([]byte, 9)
+	header := make([]byte, FrameHeaderSize)
 	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncatedHeader
+		}
 		return nil, err
 	}
 
@@ -89,11 +243,24 @@ func DecodeFrame(r io.Reader) (*Frame, error) {
 		ConnID: connID,
 	}
 
+	if payloadLen > maxPayload {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrPayloadTooLarge, payloadLen, maxPayload)
+	}
+	if frameType == FrameTypeDATA && maxData > 0 && maxData < MaxDataPayloadSize && payloadLen > maxData {
+		return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrDataPayloadTooLarge, payloadLen, maxData)
+	}
+	if err := checkDataPayload(frameType, int(payloadLen)); err != nil {
+		return nil, err
+	}
+
 	// 如果 payload_len > 0，读取 payload
 	if payloadLen > 0 {
 		// 分配 payload 缓冲区
 		payload := make([]byte, payloadLen)
 		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = ErrTruncatedPayload
+			}
 			return nil, err
 		}
 		frame.Payload = payload
@@ -105,20 +272,104 @@ func DecodeFrame(r io.Reader) (*Frame, error) {
 	return frame, nil
 }
 
+// checkDataPayload 检查 DATA 帧的负载长度不超过 MaxDataPayloadSize（其他类型的帧不检查）
+func checkDataPayload(t FrameType, n int) error {
+	if t == FrameTypeDATA && n > MaxDataPayloadSize {
+		return fmt.Errorf("%w: %d bytes (max %d)", ErrDataPayloadTooLarge, n, MaxDataPayloadSize)
+	}
+	return nil
+}
+
 // InitConfig 表示初始化配置信息
 type InitConfig struct {
-	RemotePort int    // 远程端口（服务器要监听的端口）
-	LocalAddr  string // 本地地址（客户端要映射的本地服务地址）
+	RemotePort int      // 远程端口（服务器要监听的端口）
+	LocalAddr  string   // 本地地址（客户端要映射的本地服务地址）
+	Hostnames  []string // 主机名路由键（可选，支持 *.example.com 通配符，用于全局公开端口）
+	Weight     int      // 负载均衡权重（可选，0 表示默认权重）：多个客户端匹配同一路由键时按权重分配公开连接
+	Framing    Framing  // 隧道转发连接的 DATA 分帧方式（零值为字节流）
+}
+
+// Framing 隧道的 DATA 帧分帧方式，由客户端在 INIT 中声明，该隧道的所有转发连接使用同一方式
+type Framing byte
+
+const (
+	// FramingStream 字节流（TCP）：DATA 帧只是字节流的分块，发送方可以任意拆分，接收方只保证字节顺序
+	FramingStream Framing = iota
+	// FramingDatagram 数据报（UDP）：一个 DATA 帧恰好承载一个数据报，发送方不拆分也不合并，接收方每个帧交付一次。
+	// 零长度的 DATA 帧是保活帧，因此零长度的数据报无法转发
+	FramingDatagram
+)
+
+// String 返回分帧方式的名称（INIT 字段和日志使用）
+func (f Framing) String() string {
+	switch f {
+	case FramingStream:
+		return "stream"
+	case FramingDatagram:
+		return "datagram"
+	}
+	return fmt.Sprintf("framing(%d)", byte(f))
+}
+
+// ErrDatagramTooLarge 数据报超过 DATA 负载上限（数据报不能拆分为多个帧）
+var ErrDatagramTooLarge = errors.New("datagram too large for a single DATA frame")
+
+// DataFrames 按分帧方式把从连接读取的数据转为 DATA 帧：字节流按 max 拆分为多个帧；
+// 数据报必须放入一个帧，超过 max 时返回包装了 ErrDatagramTooLarge 的错误（调用方丢弃该数据报），零长度的数据报不产生帧。
+// max 为 0 或超过 MaxDataPayloadSize 时使用 MaxDataPayloadSize；返回的帧引用 data，不复制
+func DataFrames(framing Framing, connID uint32, data []byte, max int) ([]*Frame, error) {
+	if max <= 0 || max > MaxDataPayloadSize {
+		max = MaxDataPayloadSize
+	}
+	if framing == FramingDatagram {
+		if len(data) > max {
+			return nil, fmt.Errorf("%w: %d bytes (max %d)", ErrDatagramTooLarge, len(data), max)
+		}
+		if len(data) == 0 {
+			return nil, nil
+		}
+		return []*Frame{{Type: FrameTypeDATA, ConnID: connID, Payload: data}}, nil
+	}
+	var frames []*Frame
+	for len(data) > 0 {
+		n := min(len(data), max)
+		frames = append(frames, &Frame{Type: FrameTypeDATA, ConnID: connID, Payload: data[:n]})
+		data = data[n:]
+	}
+	return frames, nil
 }
 
 // EncodeInitConfig 将 InitConfig 编码为字符串（简单格式：remotePort:localAddr）
+// 可选字段以 ;key=value 追加在后面，每个主机名一个 hostname 字段（例如 0:127.0.0.1:80;hostname=a.example.com;hostname=b.example.com），
+// 权重大于 0 时追加 weight 字段，数据报隧道追加 framing=datagram（字节流不追加，与旧版本兼容）
 func EncodeInitConfig(config *InitConfig) []byte {
-	return []byte(fmt.Sprintf("%d:%s", config.RemotePort, config.LocalAddr))
+	s := fmt.Sprintf("%d:%s", config.RemotePort, config.LocalAddr)
+	for _, hostname := range config.Hostnames {
+		s += ";hostname=" + hostname
+	}
+	if config.Weight > 0 {
+		s += ";weight=" + strconv.Itoa(config.Weight)
+	}
+	if config.Framing != FramingStream {
+		s += ";framing=" + config.Framing.String()
+	}
+	return []byte(s)
 }
 
 // DecodeInitConfig 从字节数组解码 InitConfig
+// 负载超过 MaxInitPayloadSize、包含控制字符或端口不是数字时返回错误
 func DecodeInitConfig(data []byte) (*InitConfig, error) {
-	parts := strings.SplitN(string(data), ":", 2)
+	if len(data) > MaxInitPayloadSize {
+		return nil, fmt.Errorf("init config too large: %d bytes (max %d)", len(data), MaxInitPayloadSize)
+	}
+	for _, b := range data {
+		if b < 0x20 || b == 0x7f {
+			return nil, fmt.Errorf("init config contains control character 0x%02x", b)
+		}
+	}
+
+	base, extra, _ := strings.Cut(string(data), ";")
+	parts := strings.SplitN(base, ":", 2)
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("invalid init config format")
 	}
@@ -128,8 +379,456 @@ func DecodeInitConfig(data []byte) (*InitConfig, error) {
 		return nil, fmt.Errorf("invalid remote port: %v", err)
 	}
 
-	return &InitConfig{
+	config := &InitConfig{
 		RemotePort: remotePort,
 		LocalAddr:  parts[1],
-	}, nil
+	}
+	if extra != "" {
+		for _, field := range strings.Split(extra, ";") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid init config field: %q", field)
+			}
+			switch kv[0] {
+			case "hostname":
+				config.Hostnames = append(config.Hostnames, kv[1])
+			case "weight":
+				weight, err := strconv.Atoi(kv[1])
+				if err != nil || weight < 0 {
+					return nil, fmt.Errorf("invalid weight: %q", kv[1])
+				}
+				config.Weight = weight
+			case "framing":
+				// 未知的分帧方式不能按字节流处理（会破坏数据报边界），直接拒绝
+				switch kv[1] {
+				case FramingStream.String():
+					config.Framing = FramingStream
+				case FramingDatagram.String():
+					config.Framing = FramingDatagram
+				default:
+					return nil, fmt.Errorf("invalid framing: %q", kv[1])
+				}
+			}
+		}
+	}
+	return config, nil
+}
+
+// NewConnInfo 表示 NEW_CONN 帧携带的连接元信息
+type NewConnInfo struct {
+	TraceID    string // 连接追踪 ID（服务器生成，两端日志使用同一标识）
+	SourceAddr string // 公开连接的来源地址（ip:port），用于客户端按来源路由
+	Host       string // 服务器路由该连接使用的主机名（TLS SNI 或 HTTP Host），用于客户端按主机名选择本地服务
+}
+
+// EncodeNewConnInfo 将 NewConnInfo 编码为 NEW_CONN 帧负载
+// 格式为以分号分隔的 key=value 列表（例如 trace=1a2b3c4d;src=1.2.3.4:5678;host=app.example.com），接收方忽略未知的 key。
+// 值中包含分隔符（; =）或控制字符的字段被省略，防止来自公开连接的值（例如 Host）注入或覆盖其他字段
+func EncodeNewConnInfo(info *NewConnInfo) []byte {
+	var fields []string
+	for _, field := range []struct{ key, value string }{
+		{"trace", info.TraceID},
+		{"src", info.SourceAddr},
+		{"host", info.Host},
+	} {
+		if field.value != "" && safeInfoValue(field.value) {
+			fields = append(fields, field.key+"="+field.value)
+		}
+	}
+	return []byte(strings.Join(fields, ";"))
+}
+
+// safeInfoValue 判断值能否原样放入 key=value 列表：不包含分隔符和控制字符
+func safeInfoValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == ';' || c == '=' || c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeNewConnInfo 从 NEW_CONN 帧负载解码 NewConnInfo
+// 空负载（旧版本服务器）返回空的 NewConnInfo
+func DecodeNewConnInfo(data []byte) (*NewConnInfo, error) {
+	info := &NewConnInfo{}
+	if len(data) == 0 {
+		return info, nil
+	}
+
+	for _, field := range strings.Split(string(data), ";") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid new conn info field: %q", field)
+		}
+		switch kv[0] {
+		case "trace":
+			info.TraceID = kv[1]
+		case "src":
+			info.SourceAddr = kv[1]
+		case "host":
+			info.Host = kv[1]
+		}
+	}
+
+	return info, nil
+}
+
+// Assignment 表示 ASSIGNED 帧携带的隧道配置结果
+type Assignment struct {
+	Addr        string // 实际的公开监听地址（空表示客户端当前没有公开端口）
+	IgnoredPort int    // 服务器忽略的远程端口（服务器使用全局公开端口时客户端请求的端口，0 表示未忽略）
+}
+
+// EncodeAssignment 将 Assignment 编码为 ASSIGNED 帧负载
+// 格式为公开地址，可选字段以 ;key=value 追加（例如 :8080;ignored_port=9000），旧版本客户端把整个负载当作地址显示
+func EncodeAssignment(a *Assignment) []byte {
+	s := a.Addr
+	if a.IgnoredPort > 0 {
+		s += ";ignored_port=" + strconv.Itoa(a.IgnoredPort)
+	}
+	return []byte(s)
+}
+
+// DecodeAssignment 从 ASSIGNED 帧负载解码 Assignment
+// 旧版本服务器的负载只有地址；接收方忽略未知的 key
+func DecodeAssignment(data []byte) (*Assignment, error) {
+	addr, extra, _ := strings.Cut(string(data), ";")
+	a := &Assignment{Addr: addr}
+	if extra == "" {
+		return a, nil
+	}
+	for _, field := range strings.Split(extra, ";") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid assignment field: %q", field)
+		}
+		switch kv[0] {
+		case "ignored_port":
+			port, err := strconv.Atoi(kv[1])
+			if err != nil || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("invalid ignored port: %q", kv[1])
+			}
+			a.IgnoredPort = port
+		}
+	}
+	return a, nil
+}
+
+// Features 表示可协商的可选协议特性（位掩码）
+// 只有双方都支持（HELLO 协商的交集中包含）的特性才会启用，新增的可选行为各占一位，可以逐步发布
+type Features uint32
+
+const (
+	// FeatureDataKeepalive 客户端在空闲的数据连接上发送零长度 DATA 帧保活
+	FeatureDataKeepalive Features = 1 << iota
+	// FeatureAssignmentInfo ASSIGNED 负载可以在地址后追加 ;key=value 字段（例如 ignored_port）
+	FeatureAssignmentInfo
+	// FeatureHealthCheck 客户端回复服务器的 HEALTH_CHECK 探测（服务器只探测协商了该特性的客户端）
+	FeatureHealthCheck
+	// FeatureConnAck 客户端建立本地连接后回复 NEW_CONN_ACK
+	FeatureConnAck
+	// FeatureFlowControl 每个连接的服务器 → 客户端方向按发送窗口转发，客户端以 WINDOW_UPDATE 归还额度
+	FeatureFlowControl
+)
+
+// SupportedFeatures 本实现支持的全部特性
+const SupportedFeatures = FeatureDataKeepalive | FeatureAssignmentInfo | FeatureHealthCheck | FeatureConnAck | FeatureFlowControl
+
+// featureNames 特性名称（用于配置和日志），按位的顺序排列
+var featureNames = []struct {
+	feature Features
+	name    string
+}{
+	{FeatureDataKeepalive, "data_keepalive"},
+	{FeatureAssignmentInfo, "assignment_info"},
+	{FeatureHealthCheck, "health_check"},
+	{FeatureConnAck, "conn_ack"},
+	{FeatureFlowControl, "flow_control"},
+}
+
+// String 返回以逗号分隔的特性名称，未知的位以十六进制表示，空集合返回 "none"
+func (f Features) String() string {
+	var names []string
+	for _, fn := range featureNames {
+		if f&fn.feature != 0 {
+			names = append(names, fn.name)
+			f &^= fn.feature
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(f)))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseFeatures 按名称解析特性集合（例如 ["data_keepalive"]），未知名称返回错误
+func ParseFeatures(names []string) (Features, error) {
+	var f Features
+	for _, name := range names {
+		found := false
+		for _, fn := range featureNames {
+			if fn.name == name {
+				f |= fn.feature
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown protocol feature: %q", name)
+		}
+	}
+	return f, nil
+}
+
+// Hello 表示 HELLO 帧携带的特性协商信息
+type Hello struct {
+	Features Features // 客户端：支持的特性；服务器：双方都支持的特性（协商结果）
+	Required Features // 发送方要求必须启用的特性，不在协商结果中时拒绝连接
+	MaxData  int      // DATA 帧负载上限（字节，0 表示未声明）。客户端：自身能接收的上限；服务器：双方上限的较小值（协商结果）
+}
+
+// EncodeHello 将 Hello 编码为 HELLO 帧负载（例如 features=3;required=1，十六进制），
+// MaxData 大于 0 时追加 max_data 字段（十进制，例如 features=3;required=0;max_data=4096）
+func EncodeHello(h *Hello) []byte {
+	s := fmt.Sprintf("features=%x;required=%x", uint32(h.Features), uint32(h.Required))
+	if h.MaxData > 0 {
+		s += ";max_data=" + strconv.Itoa(h.MaxData)
+	}
+	return []byte(s)
+}
+
+// DecodeHello 从 HELLO 帧负载解码 Hello，忽略未知的 key
+// max_data 必须在 1 到 MaxDataPayloadSize 之间
+func DecodeHello(data []byte) (*Hello, error) {
+	h := &Hello{}
+	for _, field := range strings.Split(string(data), ";") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid hello field: %q", field)
+		}
+		var target *Features
+		switch kv[0] {
+		case "features":
+			target = &h.Features
+		case "required":
+			target = &h.Required
+		case "max_data":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n <= 0 || n > MaxDataPayloadSize {
+				return nil, fmt.Errorf("invalid hello max_data: %q (must be 1-%d)", kv[1], MaxDataPayloadSize)
+			}
+			h.MaxData = n
+			continue
+		default:
+			continue
+		}
+		v, err := strconv.ParseUint(kv[1], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hello %s: %q", kv[0], kv[1])
+		}
+		*target = Features(v)
+	}
+	return h, nil
+}
+
+// EncodeRedirect 将重定向目标地址编码为 REDIRECT 帧负载
+func EncodeRedirect(addr string) []byte {
+	return []byte(addr)
+}
+
+// DecodeRedirect 从 REDIRECT 帧负载解码并校验目标地址（必须是 host:port）
+func DecodeRedirect(data []byte) (string, error) {
+	addr := string(data)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid redirect address %q: %v", addr, err)
+	}
+	if host == "" {
+		return "", fmt.Errorf("invalid redirect address %q: missing host", addr)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return "", fmt.Errorf("invalid redirect address %q: bad port", addr)
+	}
+	return addr, nil
+}
+
+// HealthReport 表示 HEALTH_REPORT 帧携带的本地服务检查结果
+type HealthReport struct {
+	Healthy bool   // 本地服务是否可达
+	Detail  string // 不可达的原因（可选）
+}
+
+// EncodeHealthReport 将 HealthReport 编码为 HEALTH_REPORT 帧负载（healthy，或 unhealthy 后跟 ;detail=原因）
+func EncodeHealthReport(r *HealthReport) []byte {
+	if r.Healthy {
+		return []byte("healthy")
+	}
+	if r.Detail == "" {
+		return []byte("unhealthy")
+	}
+	return []byte("unhealthy;detail=" + r.Detail)
+}
+
+// DecodeHealthReport 从 HEALTH_REPORT 帧负载解码 HealthReport，忽略未知的 key
+func DecodeHealthReport(data []byte) (*HealthReport, error) {
+	status, extra, _ := strings.Cut(string(data), ";")
+	r := &HealthReport{}
+	switch status {
+	case "healthy":
+		r.Healthy = true
+	case "unhealthy":
+	default:
+		return nil, fmt.Errorf("invalid health status: %q", status)
+	}
+	if detail, ok := strings.CutPrefix(extra, "detail="); ok {
+		r.Detail = detail
+	}
+	return r, nil
+}
+
+// 隧道事件的类型（Event.Kind），接收方应忽略未知的类型
+const (
+	EventConnected    = "connected"    // 客户端的控制连接已注册
+	EventReady        = "ready"        // 客户端的 INIT 已生效（Addr 为公开监听地址，可为空）
+	EventDisconnected = "disconnected" // 客户端已注销
+	EventError        = "error"        // 控制连接被拒绝或 INIT 失败（Detail 为原因）
+)
+
+// Event 表示 EVENT 帧携带的隧道事件
+type Event struct {
+	Kind     string // 事件类型（EventConnected 等）
+	ClientID string // 客户端 ID（控制连接在注册前被拒绝时为空）
+	Identity string // 客户端身份（证书 CN，非 TLS 连接为空）
+	Addr     string // connected/error：控制连接的来源地址；ready：公开监听地址
+	Detail   string // error：原因
+}
+
+// EncodeEvent 将 Event 编码为 EVENT 帧负载：事件类型后以 ;key=value 追加非空字段（例如 ready;client_id=client-1;addr=:8080），
+// detail 可包含任意字符，总是放在最后
+func EncodeEvent(e *Event) []byte {
+	s := e.Kind
+	for _, field := range []struct{ key, value string }{
+		{"client_id", e.ClientID},
+		{"identity", e.Identity},
+		{"addr", e.Addr},
+		{"detail", e.Detail},
+	} {
+		if field.value != "" {
+			s += ";" + field.key + "=" + field.value
+		}
+	}
+	return []byte(s)
+}
+
+// DecodeEvent 从 EVENT 帧负载解码 Event，忽略未知的 key
+func DecodeEvent(data []byte) (*Event, error) {
+	kind, rest, _ := strings.Cut(string(data), ";")
+	if kind == "" {
+		return nil, errors.New("invalid event: missing kind")
+	}
+	e := &Event{Kind: kind}
+	for rest != "" {
+		if detail, ok := strings.CutPrefix(rest, "detail="); ok {
+			e.Detail = detail
+			break
+		}
+		var field string
+		field, rest, _ = strings.Cut(rest, ";")
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid event field: %q", field)
+		}
+		switch key {
+		case "client_id":
+			e.ClientID = value
+		case "identity":
+			e.Identity = value
+		case "addr":
+			e.Addr = value
+		}
+	}
+	return e, nil
+}
+
+// CloseReason 表示 CLOSE_CONN 帧携带的关闭原因（负载为 1 字节）
+type CloseReason byte
+
+const (
+	// CloseGraceful 表示对端正常关闭（读到 EOF），空负载（旧版本）同样视为正常关闭
+	CloseGraceful CloseReason = 0x00
+	// CloseError 表示读写错误或连接本地服务失败
+	CloseError CloseReason = 0x01
+	// CloseReset 表示连接被对端重置（RST），接收方同样以 RST 关闭对应的连接
+	CloseReset CloseReason = 0x02
+	// CloseIdle 表示连接空闲超时
+	CloseIdle CloseReason = 0x03
+	// CloseShutdown 表示发送方正在关闭
+	CloseShutdown CloseReason = 0x04
+	// CloseQuota 表示连接的累计传输字节数超出配额或超过最长存活时间，被强制关闭
+	CloseQuota CloseReason = 0x05
+	// CloseUnavailable 表示本地服务暂不可用（客户端的熔断器打开），没有尝试连接，由客户端发送
+	CloseUnavailable CloseReason = 0x06
+)
+
+// String 返回关闭原因的名称（用于日志和访问日志）
+func (r CloseReason) String() string {
+	switch r {
+	case CloseGraceful:
+		return "graceful"
+	case CloseError:
+		return "error"
+	case CloseReset:
+		return "reset"
+	case CloseIdle:
+		return "idle"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseQuota:
+		return "quota"
+	case CloseUnavailable:
+		return "unavailable"
+	default:
+		return fmt.Sprintf("unknown(0x%02x)", byte(r))
+	}
+}
+
+// InitialWindowSize 协商了 FeatureFlowControl 时每个连接的初始发送窗口（字节）：
+// 服务器最多向客户端发送这么多尚未被 WINDOW_UPDATE 确认的数据，之后停止读取对应的公开连接
+const InitialWindowSize = 256 << 10
+
+// EncodeWindowUpdate 将窗口增量编码为 WINDOW_UPDATE 帧负载
+func EncodeWindowUpdate(increment uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, increment)
+	return buf
+}
+
+// DecodeWindowUpdate 从 WINDOW_UPDATE 帧负载解码窗口增量，负载不是 4 字节或增量为 0 时返回错误
+func DecodeWindowUpdate(data []byte) (uint32, error) {
+	if len(data) != 4 {
+		return 0, fmt.Errorf("invalid window update length: %d", len(data))
+	}
+	increment := binary.BigEndian.Uint32(data)
+	if increment == 0 {
+		return 0, fmt.Errorf("window update increment must be positive")
+	}
+	return increment, nil
+}
+
+// EncodeCloseReason 将关闭原因编码为 CLOSE_CONN 帧负载
+func EncodeCloseReason(r CloseReason) []byte {
+	return []byte{byte(r)}
+}
+
+// DecodeCloseReason 从 CLOSE_CONN 帧负载解码关闭原因
+// 空负载（旧版本）视为 CloseGraceful；未知的取值原样返回，接收方按 CloseError 处理
+func DecodeCloseReason(data []byte) CloseReason {
+	if len(data) == 0 {
+		return CloseGraceful
+	}
+	return CloseReason(data[0])
 }
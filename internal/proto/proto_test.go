@@ -0,0 +1,468 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// TestWriteFrame 测试 WriteFrame、AppendFrame 与 EncodeFrame 输出相同的字节流，且可被 DecodeFrame 解码
+func TestWriteFrame(t *testing.T) {
+	prefix := []byte("prefix")
+	for _, f := range []*Frame{
+		{Type: FrameTypeDATA, ConnID: 42, Payload: []byte("hello")},
+		{Type: FrameTypeCLOSE, ConnID: 7},
+	} {
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, f); err != nil {
+			t.Fatalf("WriteFrame 失败: %v", err)
+		}
+		want, _ := EncodeFrame(f)
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s 帧: WriteFrame 输出 %x, EncodeFrame 输出 %x", f.Type, buf.Bytes(), want)
+		}
+		appended, err := AppendFrame(append([]byte(nil), prefix...), f)
+		if err != nil || !bytes.Equal(appended, append(append([]byte(nil), prefix...), want...)) {
+			t.Errorf("%s 帧: AppendFrame 输出 %x, %v", f.Type, appended, err)
+		}
+		got, err := DecodeFrame(&buf)
+		if err != nil {
+			t.Fatalf("DecodeFrame 失败: %v", err)
+		}
+		if got.Type != f.Type || got.ConnID != f.ConnID || !bytes.Equal(got.Payload, f.Payload) {
+			t.Errorf("解码结果不一致: %+v", got)
+		}
+	}
+}
+
+// TestAssignment 测试 ASSIGNED 负载的编解码，以及旧版本服务器只包含地址的负载
+func TestAssignment(t *testing.T) {
+	for _, a := range []*Assignment{
+		{Addr: ":8080"},
+		{Addr: "0.0.0.0:8080", IgnoredPort: 9000},
+		{},
+	} {
+		got, err := DecodeAssignment(EncodeAssignment(a))
+		if err != nil || *got != *a {
+			t.Errorf("编解码 %+v 得到 %+v, %v", a, got, err)
+		}
+	}
+
+	got, err := DecodeAssignment([]byte("[::]:8080;future=1"))
+	if err != nil || got.Addr != "[::]:8080" || got.IgnoredPort != 0 {
+		t.Errorf("应忽略未知字段，得到 %+v, %v", got, err)
+	}
+	for _, bad := range []string{":8080;ignored_port=x", ":8080;ignored_port=0", ":8080;oops"} {
+		if _, err := DecodeAssignment([]byte(bad)); err == nil {
+			t.Errorf("无效负载 %q 应返回错误", bad)
+		}
+	}
+}
+
+func TestNewConnInfo(t *testing.T) {
+	info := &NewConnInfo{TraceID: "1a2b3c4d", SourceAddr: "1.2.3.4:5678", Host: "app.example.com"}
+	got, err := DecodeNewConnInfo(EncodeNewConnInfo(info))
+	if err != nil || *got != *info {
+		t.Errorf("编解码 %+v 得到 %+v, %v", info, got, err)
+	}
+	got, err = DecodeNewConnInfo([]byte("trace=1a2b3c4d;future=1"))
+	if err != nil || got.TraceID != "1a2b3c4d" || got.Host != "" {
+		t.Errorf("应忽略未知字段，得到 %+v, %v", got, err)
+	}
+
+	// 包含分隔符的值被省略，不能注入或覆盖其他字段
+	info = &NewConnInfo{TraceID: "1a2b3c4d", SourceAddr: "1.2.3.4:5678", Host: "x;src=10.0.0.1:1.example.com"}
+	got, err = DecodeNewConnInfo(EncodeNewConnInfo(info))
+	if err != nil || got.SourceAddr != "1.2.3.4:5678" || got.Host != "" || got.TraceID != "1a2b3c4d" {
+		t.Errorf("注入的 src 应被忽略，得到 %+v, %v", got, err)
+	}
+	if got, _ := DecodeNewConnInfo(EncodeNewConnInfo(&NewConnInfo{Host: "a=b", TraceID: "t\r\n"})); got.Host != "" || got.TraceID != "" {
+		t.Errorf("包含 = 或控制字符的值应被省略，得到 %+v", got)
+	}
+}
+
+func TestInitConfigWeight(t *testing.T) {
+	config := &InitConfig{LocalAddr: "127.0.0.1:80", Hostnames: []string{"app.example.com"}, Weight: 3}
+	got, err := DecodeInitConfig(EncodeInitConfig(config))
+	if err != nil || got.Weight != 3 || len(got.Hostnames) != 1 {
+		t.Errorf("编解码 %+v 得到 %+v, %v", config, got, err)
+	}
+	// 未设置权重时不编码 weight 字段（兼容不识别该字段的旧版本服务器）
+	if s := string(EncodeInitConfig(&InitConfig{LocalAddr: "127.0.0.1:80"})); s != "0:127.0.0.1:80" {
+		t.Errorf("未设置权重时编码为 %q", s)
+	}
+	for _, bad := range []string{"0:127.0.0.1:80;weight=-1", "0:127.0.0.1:80;weight=x"} {
+		if _, err := DecodeInitConfig([]byte(bad)); err == nil {
+			t.Errorf("DecodeInitConfig(%q) 应返回错误", bad)
+		}
+	}
+}
+
+// TestInitConfigFraming 测试 INIT 中分帧方式的编解码：字节流不编码（兼容旧版本），未知的分帧方式被拒绝
+func TestInitConfigFraming(t *testing.T) {
+	config := &InitConfig{RemotePort: 5353, LocalAddr: "127.0.0.1:53", Framing: FramingDatagram}
+	encoded := EncodeInitConfig(config)
+	if string(encoded) != "5353:127.0.0.1:53;framing=datagram" {
+		t.Errorf("数据报隧道编码为 %q", encoded)
+	}
+	got, err := DecodeInitConfig(encoded)
+	if err != nil || got.Framing != FramingDatagram {
+		t.Errorf("编解码 %+v 得到 %+v, %v", config, got, err)
+	}
+	if got, err := DecodeInitConfig([]byte("0:127.0.0.1:80;framing=stream")); err != nil || got.Framing != FramingStream {
+		t.Errorf("framing=stream 解码得到 %+v, %v", got, err)
+	}
+	if _, err := DecodeInitConfig([]byte("0:127.0.0.1:80;framing=seqpacket")); err == nil {
+		t.Error("未知的分帧方式应返回错误")
+	}
+}
+
+// TestDataFramesDatagram 测试数据报隧道一个数据报恰好对应一个 DATA 帧：经过任意分片的字节流传输后，
+// 接收方解码出的每个帧与发送的数据报一一对应，超过上限的数据报被拒绝而不是拆分
+func TestDataFramesDatagram(t *testing.T) {
+	datagrams := [][]byte{
+		[]byte("a"),
+		bytes.Repeat([]byte{1}, 1200),
+		bytes.Repeat([]byte{2}, 4096),
+		[]byte("query"),
+	}
+	var stream bytes.Buffer
+	for _, d := range datagrams {
+		frames, err := DataFrames(FramingDatagram, 9, d, 4096)
+		if err != nil || len(frames) != 1 {
+			t.Fatalf("%d 字节的数据报应恰好产生一个帧, 得到 %d 个, %v", len(d), len(frames), err)
+		}
+		if err := WriteFrame(&stream, frames[0]); err != nil {
+			t.Fatalf("WriteFrame 失败: %v", err)
+		}
+	}
+	// 控制连接是字节流，接收方每次只读到一个字节也必须还原出相同的数据报边界
+	r := iotest.OneByteReader(&stream)
+	for i, want := range datagrams {
+		frame, err := DecodeFrame(r)
+		if err != nil {
+			t.Fatalf("解码第 %d 个数据报失败: %v", i, err)
+		}
+		if !bytes.Equal(frame.Payload, want) {
+			t.Errorf("第 %d 个数据报边界被破坏: 期望 %d 字节, 得到 %d 字节", i, len(want), len(frame.Payload))
+		}
+	}
+
+	if _, err := DataFrames(FramingDatagram, 9, make([]byte, 4097), 4096); !errors.Is(err, ErrDatagramTooLarge) {
+		t.Errorf("超过上限的数据报应返回 ErrDatagramTooLarge, 得到 %v", err)
+	}
+	if frames, err := DataFrames(FramingDatagram, 9, nil, 4096); err != nil || len(frames) != 0 {
+		t.Errorf("零长度的数据报不应产生帧（会被当作保活帧）, 得到 %d 个, %v", len(frames), err)
+	}
+}
+
+// TestDataFramesStream 测试字节流隧道按上限任意拆分数据，拼接后与原数据相同
+func TestDataFramesStream(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	frames, err := DataFrames(FramingStream, 3, data, 4096)
+	if err != nil || len(frames) != 3 {
+		t.Fatalf("10000 字节应拆分为 3 个帧, 得到 %d 个, %v", len(frames), err)
+	}
+	var got []byte
+	for _, f := range frames {
+		if f.Type != FrameTypeDATA || f.ConnID != 3 || len(f.Payload) > 4096 {
+			t.Errorf("无效的帧: %s connID=%d %d 字节", f.Type, f.ConnID, len(f.Payload))
+		}
+		got = append(got, f.Payload...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("拼接后的数据与原数据不同")
+	}
+	if frames, _ := DataFrames(FramingStream, 3, make([]byte, MaxDataPayloadSize+1), 0); len(frames) != 2 {
+		t.Errorf("max 为 0 时应按 MaxDataPayloadSize 拆分, 得到 %d 个帧", len(frames))
+	}
+}
+
+func TestHealthReport(t *testing.T) {
+	for _, r := range []*HealthReport{{Healthy: true}, {}, {Detail: "dial tcp 127.0.0.1:80: connection refused"}} {
+		got, err := DecodeHealthReport(EncodeHealthReport(r))
+		if err != nil || *got != *r {
+			t.Errorf("编解码 %+v 得到 %+v, %v", r, got, err)
+		}
+	}
+	if _, err := DecodeHealthReport([]byte("maybe")); err == nil {
+		t.Errorf("未知的状态应返回错误")
+	}
+}
+
+func TestEvent(t *testing.T) {
+	for _, e := range []*Event{
+		{Kind: EventConnected, ClientID: "client-1", Identity: "edge-1", Addr: "10.0.0.1:40000"},
+		{Kind: EventReady, ClientID: "client-1", Addr: "[::]:8080"},
+		{Kind: EventDisconnected, ClientID: "client-1"},
+		{Kind: EventError, ClientID: "client-2", Detail: "无效的远程端口: 0; addr=x"},
+	} {
+		got, err := DecodeEvent(EncodeEvent(e))
+		if err != nil || *got != *e {
+			t.Errorf("编解码 %+v 得到 %+v, %v", e, got, err)
+		}
+	}
+
+	got, err := DecodeEvent([]byte("paused;client_id=client-3;future=1"))
+	if err != nil || got.Kind != "paused" || got.ClientID != "client-3" {
+		t.Errorf("应保留未知的事件类型并忽略未知字段，得到 %+v, %v", got, err)
+	}
+	for _, bad := range []string{"", ";client_id=client-1", "ready;client_id"} {
+		if _, err := DecodeEvent([]byte(bad)); err == nil {
+			t.Errorf("无效负载 %q 应返回错误", bad)
+		}
+	}
+}
+
+func TestHello(t *testing.T) {
+	for _, h := range []*Hello{
+		{},
+		{Features: SupportedFeatures, Required: FeatureDataKeepalive},
+		{Features: 1 << 31},
+		{Features: SupportedFeatures, MaxData: MaxDataPayloadSize},
+	} {
+		got, err := DecodeHello(EncodeHello(h))
+		if err != nil || *got != *h {
+			t.Errorf("编解码 %+v 得到 %+v, %v", h, got, err)
+		}
+	}
+
+	got, err := DecodeHello([]byte("features=3;future=1"))
+	if err != nil || got.Features != 3 || got.Required != 0 {
+		t.Errorf("应忽略未知字段，得到 %+v, %v", got, err)
+	}
+	for _, bad := range []string{"features=zz", "features", "required=100000000", "max_data=0", "max_data=32769", "max_data=x"} {
+		if _, err := DecodeHello([]byte(bad)); err == nil {
+			t.Errorf("无效负载 %q 应返回错误", bad)
+		}
+	}
+}
+
+func TestParseFeatures(t *testing.T) {
+	f, err := ParseFeatures([]string{"assignment_info", "data_keepalive"})
+	if err != nil || f != FeatureDataKeepalive|FeatureAssignmentInfo {
+		t.Errorf("解析得到 %v, %v", f, err)
+	}
+	if s := f.String(); s != "data_keepalive,assignment_info" {
+		t.Errorf("String() = %q", s)
+	}
+	if s := (Features(0)).String(); s != "none" {
+		t.Errorf("空集合 String() = %q", s)
+	}
+	if s := (FeatureDataKeepalive | 1<<8).String(); s != "data_keepalive,0x100" {
+		t.Errorf("未知位 String() = %q", s)
+	}
+	if _, err := ParseFeatures([]string{"compression"}); err == nil {
+		t.Errorf("未知特性名称应返回错误")
+	}
+}
+
+// chunkReader 每次 Read 最多返回 chunk 字节，模拟分片到达的网络数据和慢速读取
+type chunkReader struct {
+	data  []byte
+	chunk int
+	reads int // Read 调用次数
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	r.reads++
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := r.chunk
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// TestDecodeFrameChunked 测试帧头和负载被拆分到任意多次读取时仍能正确解码
+func TestDecodeFrameChunked(t *testing.T) {
+	frames := []*Frame{
+		{Type: FrameTypeDATA, ConnID: 1, Payload: bytes.Repeat([]byte("abcdefg"), 100)},
+		{Type: FrameTypeCLOSE, ConnID: 0xdeadbeef},
+		{Type: FrameTypeINIT, ConnID: 0, Payload: []byte("8080:127.0.0.1:80")},
+		{Type: FrameTypeDATA, ConnID: 3, Payload: []byte{0}},
+	}
+	var stream []byte
+	for _, f := range frames {
+		data, err := EncodeFrame(f)
+		if err != nil {
+			t.Fatalf("EncodeFrame 失败: %v", err)
+		}
+		stream = append(stream, data...)
+	}
+
+	// 分片大小覆盖 1 字节、小于帧头、跨越帧头与负载边界以及大于单帧的情况
+	for _, chunk := range []int{1, 2, 3, 5, 8, 9, 10, 13, 64, 701, 4096} {
+		r := &chunkReader{data: append([]byte(nil), stream...), chunk: chunk}
+		for i, want := range frames {
+			got, err := DecodeFrame(r)
+			if err != nil {
+				t.Fatalf("分片大小 %d: 第 %d 帧 DecodeFrame 失败: %v", chunk, i, err)
+			}
+			if got.Type != want.Type || got.ConnID != want.ConnID || !bytes.Equal(got.Payload, want.Payload) {
+				t.Fatalf("分片大小 %d: 第 %d 帧解码结果不一致: %+v", chunk, i, got)
+			}
+		}
+		if _, err := DecodeFrame(r); err != io.EOF {
+			t.Errorf("分片大小 %d: 读完所有帧后期望 io.EOF, 实际 %v", chunk, err)
+		}
+		if chunk == 1 && r.reads < len(stream) {
+			t.Errorf("逐字节读取时 Read 只调用了 %d 次, 期望至少 %d 次", r.reads, len(stream))
+		}
+	}
+}
+
+// TestDataPayloadLimit 测试 DATA 帧的负载不能超过 MaxDataPayloadSize：发送方编码时拒绝，
+// 接收方只读取帧头即返回错误；其他类型的帧不受该限制
+func TestDataPayloadLimit(t *testing.T) {
+	max := &Frame{Type: FrameTypeDATA, ConnID: 1, Payload: make([]byte, MaxDataPayloadSize)}
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, max); err != nil {
+		t.Fatalf("最大长度的 DATA 帧应可写入: %v", err)
+	}
+	if got, err := DecodeFrame(&buf); err != nil || len(got.Payload) != MaxDataPayloadSize {
+		t.Fatalf("最大长度的 DATA 帧应可解码: %v", err)
+	}
+
+	oversized := &Frame{Type: FrameTypeDATA, ConnID: 1, Payload: make([]byte, MaxDataPayloadSize+1)}
+	if err := WriteFrame(&buf, oversized); err == nil || buf.Len() != 0 {
+		t.Errorf("超长的 DATA 帧应被 WriteFrame 拒绝且不写出任何数据: %v, 写出 %d 字节", err, buf.Len())
+	}
+	if _, err := EncodeFrame(oversized); err == nil {
+		t.Errorf("超长的 DATA 帧应被 EncodeFrame 拒绝")
+	}
+
+	// 接收方只读取帧头：负载未发送时也能立即返回错误
+	header := []byte{byte(FrameTypeDATA), 0, 0, 0, 1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[5:], MaxDataPayloadSize+1)
+	r := &chunkReader{data: header, chunk: len(header)}
+	if _, err := DecodeFrame(r); !errors.Is(err, ErrPayloadTooLarge) || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("超长的 DATA 帧应被 DecodeFrame 拒绝, 得到 %v", err)
+	}
+
+	// 其他类型的帧只受 MaxPayloadSize 限制
+	other := &Frame{Type: FrameTypeERROR, Payload: make([]byte, MaxDataPayloadSize+1)}
+	if err := WriteFrame(&buf, other); err != nil {
+		t.Fatalf("非 DATA 帧不受 DATA 负载限制: %v", err)
+	}
+	if _, err := DecodeFrame(&buf); err != nil {
+		t.Errorf("非 DATA 帧不受 DATA 负载限制: %v", err)
+	}
+}
+
+// TestDecodeFrameLimit 测试负载超过调用方上限的帧只读取帧头即被拒绝，上限以内的帧正常解码
+func TestDecodeFrameLimit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, &Frame{Type: FrameTypeHELLO, Payload: make([]byte, 16)}); err != nil {
+		t.Fatalf("写入帧失败: %v", err)
+	}
+	if got, err := DecodeFrameLimit(bytes.NewReader(buf.Bytes()), 16); err != nil || len(got.Payload) != 16 {
+		t.Fatalf("上限以内的帧应可解码: %v", err)
+	}
+	r := &chunkReader{data: buf.Bytes()[:FrameHeaderSize], chunk: FrameHeaderSize}
+	if _, err := DecodeFrameLimit(r, 15); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("超过上限的帧应返回 ErrPayloadTooLarge, 得到 %v", err)
+	}
+}
+
+// TestDecodeFrameLimits 测试超过 maxData 的 DATA 帧只读取帧头即被拒绝，其他类型的帧不受 maxData 限制
+func TestDecodeFrameLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, &Frame{Type: FrameTypeDATA, ConnID: 1, Payload: make([]byte, 16)}); err != nil {
+		t.Fatalf("写入帧失败: %v", err)
+	}
+	if got, err := DecodeFrameLimits(bytes.NewReader(buf.Bytes()), MaxPayloadSize, 16); err != nil || len(got.Payload) != 16 {
+		t.Fatalf("上限以内的 DATA 帧应可解码: %v", err)
+	}
+	r := &chunkReader{data: buf.Bytes()[:FrameHeaderSize], chunk: FrameHeaderSize}
+	if _, err := DecodeFrameLimits(r, MaxPayloadSize, 15); !errors.Is(err, ErrDataPayloadTooLarge) || !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("超过 maxData 的 DATA 帧应返回 ErrDataPayloadTooLarge, 得到 %v", err)
+	}
+
+	buf.Reset()
+	if err := WriteFrame(&buf, &Frame{Type: FrameTypeERROR, Payload: make([]byte, 16)}); err != nil {
+		t.Fatalf("写入帧失败: %v", err)
+	}
+	if _, err := DecodeFrameLimits(&buf, MaxPayloadSize, 15); err != nil {
+		t.Errorf("非 DATA 帧不受 maxData 限制: %v", err)
+	}
+}
+
+// TestDecodeFrameTruncated 测试数据在帧中途结束时返回区分帧头/负载的截断错误（均匹配 io.ErrUnexpectedEOF），
+// 帧边界处结束时返回 io.EOF
+func TestDecodeFrameTruncated(t *testing.T) {
+	data, err := EncodeFrame(&Frame{Type: FrameTypeDATA, ConnID: 9, Payload: []byte("0123456789")})
+	if err != nil {
+		t.Fatalf("EncodeFrame 失败: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		n    int // 在 EOF 前交付的字节数
+		want error
+	}{
+		{"空输入", 0, io.EOF},
+		{"帧头中途", 4, ErrTruncatedHeader},
+		{"只有帧头", 9, ErrTruncatedPayload},
+		{"负载中途", 9 + 3, ErrTruncatedPayload},
+		{"缺少最后一个字节", len(data) - 1, ErrTruncatedPayload},
+	}
+	for _, tc := range cases {
+		for _, chunk := range []int{1, 3, len(data)} {
+			r := &chunkReader{data: data[:tc.n], chunk: chunk}
+			frame, err := DecodeFrame(r)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("%s (分片大小 %d): 期望错误 %v, 实际 %v", tc.name, chunk, tc.want, err)
+			}
+			if tc.want != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("%s (分片大小 %d): 截断错误应匹配 io.ErrUnexpectedEOF, 实际 %v", tc.name, chunk, err)
+			}
+			if frame != nil {
+				t.Errorf("%s (分片大小 %d): 出错时不应返回帧, 实际 %+v", tc.name, chunk, frame)
+			}
+		}
+	}
+}
+
+// benchmarkFrame 基准测试使用的 DATA 帧（负载为 DATA 帧允许的最大长度）
+var benchmarkFrame = &Frame{Type: FrameTypeDATA, ConnID: 1, Payload: make([]byte, MaxDataPayloadSize)}
+
+// BenchmarkEncodeFrameWrite 先编码为完整的字节切片再写入（每帧分配并复制整个负载）
+func BenchmarkEncodeFrameWrite(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkFrame.Payload)))
+	for i := 0; i < b.N; i++ {
+		data, err := EncodeFrame(benchmarkFrame)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Discard.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteFrame 直接写出帧头和负载（不复制负载）
+func BenchmarkWriteFrame(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkFrame.Payload)))
+	for i := 0; i < b.N; i++ {
+		if err := WriteFrame(io.Discard, benchmarkFrame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// accept 连续失败时的退避时间范围（与 net/http.Server 一致）
+const (
+	acceptBackoffMin = 5 * time.Millisecond
+	acceptBackoffMax = time.Second
+)
+
+// acceptBackoff 处理 accept 循环中的错误
+// 资源类临时错误（文件描述符耗尽、内存不足等）按指数退避后重试，避免刷屏日志和空转 CPU；
+// 监听器已关闭或 ctx 已取消视为致命错误，结束循环；其他错误（如单个连接 TLS 握手失败）记录后立即继续
+type acceptBackoff struct {
+	delay time.Duration
+}
+
+// handle 处理一次 accept 错误，返回 false 表示应结束 accept 循环
+func (b *acceptBackoff) handle(ctx context.Context, err error, label string) bool {
+	if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+		return false
+	}
+	if !isTemporaryAcceptError(err) {
+		log.Printf("接受%s错误: %v", label, err)
+		return true
+	}
+
+	if b.delay == 0 {
+		b.delay = acceptBackoffMin
+	} else {
+		b.delay *= 2
+	}
+	if b.delay > acceptBackoffMax {
+		b.delay = acceptBackoffMax
+	}
+	log.Printf("接受%s错误: %v，%v 后重试", label, err, b.delay)
+
+	timer := time.NewTimer(b.delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// reset 在 accept 成功后清除退避状态
+func (b *acceptBackoff) reset() {
+	b.delay = 0
+}
+
+// isTemporaryAcceptError 判断 accept 错误是否为资源类临时错误
+func isTemporaryAcceptError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
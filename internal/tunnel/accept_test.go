@@ -0,0 +1,65 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingListener 在关闭前每次 Accept 都返回文件描述符耗尽错误
+type failingListener struct {
+	calls     int64
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	atomic.AddInt64(&l.calls, 1)
+	select {
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: net.ErrClosed}
+	default:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+}
+
+func (l *failingListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *failingListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// TestAcceptBackoff 测试 accept 持续出现临时错误时退避重试，监听器关闭后循环结束
+func TestAcceptBackoff(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "")
+	listener := &failingListener{closed: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		server.acceptPublicConnections(ctx, listener, "client-1")
+		close(done)
+	}()
+
+	// 5ms 起步、翻倍退避：300ms 内最多约 7 次，而不是成千上万次
+	time.Sleep(300 * time.Millisecond)
+	if calls := atomic.LoadInt64(&listener.calls); calls > 10 {
+		t.Errorf("临时错误时应退避，300ms 内 Accept 调用了 %d 次", calls)
+	}
+
+	listener.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("监听器关闭后 accept 循环应结束")
+	}
+}
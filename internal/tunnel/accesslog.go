@@ -0,0 +1,99 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AccessRecord 表示一条公开连接访问日志（JSON Lines 格式，每个连接一行）
+type AccessRecord struct {
+	Name        string    `json:"name,omitempty"` // 服务器实例名称（未配置时省略）
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	DurationMs  int64     `json:"duration_ms"`
+	ClientID    string    `json:"client_id"`
+	ConnID      uint32    `json:"conn_id"`
+	TraceID     string    `json:"trace_id"`
+	Source      string    `json:"source"`       // 公开连接来源地址
+	BytesIn     uint64    `json:"bytes_in"`     // 从公开连接收到的字节数
+	BytesOut    uint64    `json:"bytes_out"`    // 写入公开连接的字节数
+	CloseReason string    `json:"close_reason"` // eof | error | reset | client_close | client_gone | shutdown | quota
+
+	// 关闭归类：client-eof | backend-eof | backend-dial-failed | idle-timeout | setup-timeout | rate-limited | shutdown | error
+	CloseCategory string `json:"close_category"`
+
+	ClientCloseReason string `json:"client_close_reason,omitempty"` // close_reason 为 client_close 时客户端给出的原因：graceful | error | reset | idle | shutdown
+
+	Traceparent string `json:"traceparent,omitempty"` // 注入后端的 W3C traceparent（启用 trace context 且为 HTTP 请求时）
+}
+
+// accessLogger 将访问记录写入独立的日志输出（与运行日志分离，便于单独轮转和采集）
+type accessLogger struct {
+	mu   sync.Mutex
+	w    io.Writer
+	name string // 服务器实例名称（写入每条记录）
+}
+
+// newAccessLogger 创建访问日志记录器，w 为 nil 时返回 nil（不记录）
+func newAccessLogger(w io.Writer) *accessLogger {
+	if w == nil {
+		return nil
+	}
+	return &accessLogger{w: w}
+}
+
+// write 写入一条访问记录
+func (l *accessLogger) write(rec *AccessRecord) {
+	if l == nil {
+		return
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("编码访问日志失败: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(data); err != nil {
+		log.Printf("写入访问日志失败: %v", err)
+	}
+}
+
+// logPublicConn 在公开连接清理时写入访问记录
+func (l *accessLogger) logPublicConn(clientID string, connID uint32, c *trackedConn, reason, category string) {
+	if l == nil {
+		return
+	}
+
+	end := time.Now()
+	source := ""
+	if addr := c.RemoteAddr(); addr != nil {
+		source = addr.String()
+	}
+	l.write(&AccessRecord{
+		Name:        l.name,
+		Start:       c.start,
+		End:         end,
+		DurationMs:  end.Sub(c.start).Milliseconds(),
+		ClientID:    clientID,
+		ConnID:      connID,
+		TraceID:     c.traceID,
+		Source:      source,
+		BytesIn:     atomic.LoadUint64(&c.bytesIn),
+		BytesOut:    atomic.LoadUint64(&c.bytesOut),
+		CloseReason: reason,
+
+		CloseCategory: category,
+
+		ClientCloseReason: c.peerReason(),
+
+		Traceparent: c.traceparent,
+	})
+}
@@ -0,0 +1,140 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 强制关闭连接的错误
+var (
+	errClientNotFound = errors.New("客户端不存在")
+	errConnNotFound   = errors.New("连接不存在")
+	errConnClosing    = errors.New("连接已在关闭中")
+)
+
+// ConnInfo 一个转发连接的状态（GET /clients/{id}/conns 的输出）
+type ConnInfo struct {
+	ConnID   uint32 `json:"conn_id"`
+	State    string `json:"state"`
+	TraceID  string `json:"trace_id"`
+	Source   string `json:"source"`
+	AgeMs    int64  `json:"age_ms"`
+	IdleMs   int64  `json:"idle_ms"`
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// ClientConns 返回客户端当前所有转发连接的状态（按 connID 排序），客户端不存在时返回 false
+func (s *Server) ClientConns(clientID string) ([]ConnInfo, bool) {
+	s.clientsMu.RLock()
+	info, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	conns := []ConnInfo{}
+	info.ConnMap.Range(func(key, value interface{}) bool {
+		tc, ok := value.(*trackedConn)
+		if !ok {
+			return true
+		}
+		snap := snapshotConn(key.(uint32), tc, now)
+		conns = append(conns, ConnInfo{
+			ConnID:   snap.id,
+			State:    snap.state.String(),
+			TraceID:  snap.traceID,
+			Source:   tc.RemoteAddr().String(),
+			AgeMs:    snap.age.Milliseconds(),
+			IdleMs:   snap.idle.Milliseconds(),
+			BytesIn:  snap.bytesIn,
+			BytesOut: snap.bytesOut,
+		})
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ConnID < conns[j].ConnID })
+	return conns, true
+}
+
+// CloseConn 强制关闭客户端的一个转发连接（用于清除卡住的连接），不影响该客户端的其他连接：
+// 以 RST 关闭公开连接，并以 CloseReset 通知客户端同样重置本地连接，访问日志的关闭原因为 admin_close。
+// 与其他关闭路径一样经过 closeLocal：连接已被另一方关闭时返回 errConnClosing，由其完成清理
+func (s *Server) CloseConn(clientID string, connID uint32) error {
+	s.clientsMu.RLock()
+	info, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", errClientNotFound, clientID)
+	}
+	value, ok := info.ConnMap.Load(connID)
+	if !ok {
+		return fmt.Errorf("%w: clientID=%s, connID=%d", errConnNotFound, clientID, connID)
+	}
+	tc := value.(*trackedConn)
+	if !tc.closeLocal() {
+		return fmt.Errorf("%w: clientID=%s, connID=%d", errConnClosing, clientID, connID)
+	}
+	log.Printf("管理接口强制关闭外部连接: clientID=%s, connID=%d, trace=%s", clientID, connID, tc.traceID)
+	s.sendCloseFrame(clientID, connID, tc.traceID, proto.CloseReset)
+	closeWithReason(tc, proto.CloseReset)
+	s.releasePublicConn(info, clientID, connID, tc, closeReasonAdmin)
+	return nil
+}
+
+// ClientConnsHandler 返回 GET /clients/{id}/conns 的处理器：以 JSON 输出客户端的转发连接
+func (s *Server) ClientConnsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conns, ok := s.ClientConns(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "client not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(conns); err != nil {
+			log.Printf("输出连接列表失败: %v", err)
+		}
+	})
+}
+
+// CloseConnHandler 返回 POST /clients/{id}/conns/{connID}/close 的处理器：强制关闭一个转发连接
+// 成功时回复 204；客户端或连接不存在时回复 404，连接已在关闭中时回复 409
+func (s *Server) CloseConnHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connID, err := strconv.ParseUint(r.PathValue("connID"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid connID", http.StatusBadRequest)
+			return
+		}
+		switch err := s.CloseConn(r.PathValue("id"), uint32(connID)); {
+		case err == nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, errConnClosing):
+			http.Error(w, "connection is already closing", http.StatusConflict)
+		case errors.Is(err, errClientNotFound):
+			http.Error(w, "client not found", http.StatusNotFound)
+		default:
+			http.Error(w, "connection not found", http.StatusNotFound)
+		}
+	})
+}
+
+// mountConnAdmin 在 mux 上挂载连接管理接口（要求携带管理令牌），未设置令牌时不挂载
+func mountConnAdmin(mux *http.ServeMux, s *Server) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	mux.Handle("GET /clients/{id}/conns", requireToken(s.adminToken, s.ClientConnsHandler()))
+	mux.Handle("POST /clients/{id}/conns/{connID}/close", requireToken(s.adminToken, s.CloseConnHandler()))
+	mux.Handle("POST /clients/{id}/pause", requireToken(s.adminToken, s.PauseClientHandler()))
+	mux.Handle("POST /clients/{id}/resume", requireToken(s.adminToken, s.ResumeClientHandler()))
+	log.Printf("连接管理接口已挂载: /clients/{id}/conns、/clients/{id}/pause、/clients/{id}/resume（需要管理令牌）")
+	return true
+}
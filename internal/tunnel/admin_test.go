@@ -0,0 +1,88 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestCloseConnAdmin 测试连接管理接口：列出客户端的连接，强制关闭其中一个后该连接被重置、其他连接不受影响，
+// 重复关闭和未携带令牌的请求被拒绝
+func TestCloseConnAdmin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerAdminToken("secret"))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	var conns [2]io.ReadWriteCloser
+	for i := range conns {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		go conn.Write([]byte("ping"))
+		if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+			t.Fatalf("回显失败: %v", err)
+		}
+		conns[i] = conn
+	}
+
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.auxHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("GET", "/clients/client-1/conns", "secret")
+	var listed []ConnInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("列出连接失败: %d %q, %v", rec.Code, rec.Body.String(), err)
+	}
+	if len(listed) != 2 || listed[0].ConnID != 1 || listed[0].State != "open" || listed[0].BytesIn != 4 {
+		t.Fatalf("连接列表不正确: %+v", listed)
+	}
+
+	if rec := serve("POST", "/clients/client-1/conns/1/close", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("未携带令牌应回复 401, 得到 %d", rec.Code)
+	}
+	if rec := serve("POST", "/clients/client-1/conns/1/close", "secret"); rec.Code != http.StatusNoContent {
+		t.Fatalf("关闭连接应回复 204, 得到 %d %q", rec.Code, rec.Body.String())
+	}
+	if _, err := conns[0].Read(make([]byte, 1)); err == nil {
+		t.Error("被关闭的连接应读取失败")
+	}
+	if rec := serve("POST", "/clients/client-1/conns/1/close", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("已关闭的连接应回复 404, 得到 %d", rec.Code)
+	}
+	if rec := serve("POST", "/clients/client-9/conns/2/close", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("不存在的客户端应回复 404, 得到 %d", rec.Code)
+	}
+	if rec := serve("POST", "/clients/client-1/conns/abc/close", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("无效的 connID 应回复 400, 得到 %d", rec.Code)
+	}
+
+	// 其他连接不受影响
+	go conns[1].Write([]byte("pong"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conns[1], buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("其他连接应不受影响: %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,127 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// auxHandler 返回辅助 HTTP 服务的路由（/metrics 和 /status，启用时包括 /debug/pprof/ 和 /ui/，
+// 设置管理令牌时包括 /identity/{cn}/port、/metering 和连接管理接口 /clients/{id}/conns）
+func (s *Server) auxHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	mux.Handle("/status", s.StatusHandler())
+	if s.adminToken != "" {
+		// 可以逐个身份探测租户名单及其公开端口，需要管理令牌
+		mux.Handle("GET /identity/{cn}/port", requireToken(s.adminToken, s.IdentityPortHandler()))
+		// 计量快照列出全部身份及其计费用量
+		mux.Handle("GET /metering", requireToken(s.adminToken, s.MeteringHandler()))
+	}
+	if s.enablePprof {
+		mountPprof(mux, s.adminToken)
+	}
+	if s.enableStatusUI {
+		mountStatusUI(mux, s)
+	}
+	mountConnAdmin(mux, s)
+	return mux
+}
+
+// mountPprof 在 mux 上挂载 /debug/pprof/，要求携带管理令牌
+// 未设置令牌时不挂载，避免在无保护的情况下暴露运行时信息
+func mountPprof(mux *http.ServeMux, token string) bool {
+	if token == "" {
+		log.Printf("警告: 已启用 pprof 但未设置管理令牌，pprof 不会挂载")
+		return false
+	}
+	mux.Handle("/debug/pprof/", requireToken(token, http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", requireToken(token, http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", requireToken(token, http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", requireToken(token, http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", requireToken(token, http.HandlerFunc(pprof.Trace)))
+	log.Printf("pprof 已挂载: /debug/pprof/（需要管理令牌）")
+	return true
+}
+
+// requireToken 要求请求携带 "Authorization: Bearer <token>"
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startAuxListeners 启动辅助监听器（指标/状态），其生命周期与控制/公开监听器分离
+// 绑定失败时记录醒目警告并继续提供隧道服务；严格模式下返回错误使 Run 失败
+func (s *Server) startAuxListeners(ctx context.Context) error {
+	if s.metricsListenAddr == "" {
+		return nil
+	}
+
+	listener, err := net.Listen(s.listenNetwork(), s.metricsListenAddr)
+	if err != nil {
+		if s.strictAuxListeners {
+			return fmt.Errorf("启动指标监听器失败: %w", err)
+		}
+		log.Printf("==================================================")
+		log.Printf("警告: 指标监听器启动失败 (%s): %v", s.metricsListenAddr, err)
+		log.Printf("警告: 服务器将继续提供隧道服务，但指标和状态接口不可用")
+		log.Printf("==================================================")
+		return nil
+	}
+
+	httpServer := &http.Server{Handler: s.auxHandler()}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("指标服务错误: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+
+	log.Printf("指标服务已启动: http://%s/metrics", listener.Addr())
+	return nil
+}
+
+// startPprofListener 启动客户端的 pprof 调试监听器（需要管理令牌）
+func (c *Client) startPprofListener(ctx context.Context) {
+	if c.pprofListenAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	if !mountPprof(mux, c.adminToken) {
+		return
+	}
+	mux.Handle("/metrics", requireToken(c.adminToken, c.MetricsHandler()))
+	listener, err := net.Listen("tcp", c.pprofListenAddr)
+	if err != nil {
+		log.Printf("警告: pprof 监听器启动失败 (%s): %v", c.pprofListenAddr, err)
+		return
+	}
+
+	httpServer := &http.Server{Handler: mux}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("pprof 服务错误: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+	log.Printf("pprof 服务已启动: http://%s/debug/pprof/", listener.Addr())
+}
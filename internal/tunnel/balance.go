@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 本地后端负载均衡策略
+const (
+	BalanceRoundRobin = "round_robin" // 轮询（默认）
+	BalanceRandom     = "random"      // 随机
+)
+
+// ParseLocalBackends 将以逗号分隔的本地地址列表拆分为后端地址
+func ParseLocalBackends(local string) []string {
+	var backends []string
+	for _, addr := range strings.Split(local, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			backends = append(backends, addr)
+		}
+	}
+	return backends
+}
+
+// backendPool 表示一组本地后端，每个新连接按策略选择一个
+// 启用被动健康检查（unhealthyFor > 0）时，拨号失败的后端在 unhealthyFor 内被跳过，并改用下一个后端重试
+type backendPool struct {
+	addrs        []string
+	strategy     string
+	unhealthyFor time.Duration
+
+	next uint32 // 轮询计数（原子操作）
+
+	mu        sync.Mutex
+	downUntil map[string]time.Time // 后端标记为不健康的截止时间
+}
+
+// newBackendPool 创建本地后端池，strategy 为空时使用轮询
+func newBackendPool(addrs []string, strategy string, unhealthyFor time.Duration) *backendPool {
+	if strategy == "" {
+		strategy = BalanceRoundRobin
+	}
+	return &backendPool{
+		addrs:        addrs,
+		strategy:     strategy,
+		unhealthyFor: unhealthyFor,
+		downUntil:    make(map[string]time.Time),
+	}
+}
+
+// candidates 返回本次连接的候选后端顺序：按策略选出起点，健康的后端在前，不健康的在后
+// 所有后端都不健康时仍会依次尝试，避免整个池因短暂故障完全不可用
+func (p *backendPool) candidates() []string {
+	n := len(p.addrs)
+	var start int
+	if p.strategy == BalanceRandom {
+		start = rand.Intn(n)
+	} else {
+		start = int((atomic.AddUint32(&p.next, 1) - 1) % uint32(n))
+	}
+
+	now := time.Now()
+	healthy := make([]string, 0, n)
+	var unhealthy []string
+	p.mu.Lock()
+	for i := 0; i < n; i++ {
+		addr := p.addrs[(start+i)%n]
+		if until, ok := p.downUntil[addr]; ok && now.Before(until) {
+			unhealthy = append(unhealthy, addr)
+			continue
+		}
+		healthy = append(healthy, addr)
+	}
+	p.mu.Unlock()
+	return append(healthy, unhealthy...)
+}
+
+// markDown 将后端标记为不健康（未启用被动健康检查时不做任何事）
+func (p *backendPool) markDown(addr string) {
+	if p.unhealthyFor <= 0 {
+		return
+	}
+	p.mu.Lock()
+	p.downUntil[addr] = time.Now().Add(p.unhealthyFor)
+	p.mu.Unlock()
+}
+
+// markUp 清除后端的不健康标记
+func (p *backendPool) markUp(addr string) {
+	p.mu.Lock()
+	delete(p.downUntil, addr)
+	p.mu.Unlock()
+}
+
+// dial 按策略选择后端并拨号，返回连接和实际使用的后端地址
+// 未启用被动健康检查时只尝试选出的后端；启用时拨号失败会标记该后端并依次尝试其他后端
+func (p *backendPool) dial(dial func(addr string) (net.Conn, error)) (net.Conn, string, error) {
+	candidates := p.candidates()
+	if p.unhealthyFor <= 0 {
+		candidates = candidates[:1]
+	}
+
+	var lastErr error
+	for _, addr := range candidates {
+		conn, err := dial(addr)
+		if err == nil {
+			p.markUp(addr)
+			return conn, addr, nil
+		}
+		lastErr = err
+		if errors.Is(err, errCircuitOpen) {
+			// 熔断中的后端没有拨号，由熔断器决定何时恢复
+			continue
+		}
+		if p.unhealthyFor > 0 {
+			log.Printf("本地后端 %s 连接失败，标记为不健康 %v: %v", addr, p.unhealthyFor, err)
+		}
+		p.markDown(addr)
+	}
+	return nil, "", fmt.Errorf("所有本地后端均不可用: %w", lastErr)
+}
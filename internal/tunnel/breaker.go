@@ -0,0 +1,110 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultBreakerCooldown 未设置冷却时间时熔断器打开的时长
+const defaultBreakerCooldown = 30 * time.Second
+
+// errCircuitOpen 本地服务的熔断器处于打开状态，没有拨号
+var errCircuitOpen = errors.New("本地服务熔断中")
+
+// breakerState 一个本地服务地址的熔断状态
+type breakerState struct {
+	failures  int       // 连续拨号失败次数
+	openUntil time.Time // 熔断器打开的截止时间（零值表示关闭）
+	probing   bool      // 半开状态下的试探拨号正在进行
+}
+
+// circuitBreaker 按本地服务地址统计连续拨号失败：连续失败 threshold 次后打开熔断器，
+// cooldown 内该地址的新连接不再拨号，直接失败；冷却结束后进入半开状态，只放行一次试探拨号，
+// 成功则关闭熔断器，失败则重新打开 cooldown。避免本地服务故障时每个 NEW_CONN 都去拨号已失效的后端
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu     sync.Mutex
+	states map[string]*breakerState
+}
+
+// newCircuitBreaker 创建熔断器，cooldown 为 0 时使用 defaultBreakerCooldown
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		states:    make(map[string]*breakerState),
+	}
+}
+
+// allow 判断是否允许拨号 addr：熔断器关闭时允许；打开且未冷却结束时拒绝；
+// 冷却结束后只允许一次试探拨号（其结果由 record 记录），试探进行期间其余拨号仍被拒绝
+func (b *circuitBreaker) allow(addr string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st, ok := b.states[addr]
+	if !ok || st.openUntil.IsZero() {
+		return true
+	}
+	if st.probing || now.Before(st.openUntil) {
+		return false
+	}
+	st.probing = true
+	log.Printf("本地服务 %s 熔断冷却结束，进入半开状态，试探连接", addr)
+	return true
+}
+
+// record 记录一次拨号 addr 的结果：成功时关闭熔断器并清零失败次数，
+// 失败达到阈值或半开状态的试探失败时（重新）打开熔断器
+func (b *circuitBreaker) record(addr string, err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st, ok := b.states[addr]
+	if err == nil {
+		if ok {
+			if !st.openUntil.IsZero() {
+				log.Printf("本地服务 %s 已恢复，关闭熔断", addr)
+			}
+			delete(b.states, addr)
+		}
+		return
+	}
+	if !ok {
+		st = &breakerState{}
+		b.states[addr] = st
+	}
+	st.failures++
+	if st.probing || (st.openUntil.IsZero() && st.failures >= b.threshold) {
+		st.probing = false
+		st.openUntil = now.Add(b.cooldown)
+		log.Printf("本地服务 %s 连续 %d 次连接失败，熔断 %v: %v", addr, st.failures, b.cooldown, err)
+	}
+}
+
+// dialBackend 为 NEW_CONN 连接本地服务：启用熔断器时，熔断中的地址直接返回 errCircuitOpen，拨号结果计入熔断统计
+func (c *Client) dialBackend(addr string) (net.Conn, error) {
+	if c.breaker == nil {
+		return c.dialLocal(addr)
+	}
+	if !c.breaker.allow(addr, time.Now()) {
+		return nil, fmt.Errorf("%w: %s", errCircuitOpen, addr)
+	}
+	conn, err := c.dialLocal(addr)
+	c.breaker.record(addr, err, time.Now())
+	return conn, err
+}
+
+// initBreaker 设置了熔断阈值时创建熔断器（在创建后端池和连接池之前调用）
+func (c *Client) initBreaker() {
+	if c.breakerFailures > 0 {
+		c.breaker = newCircuitBreaker(c.breakerFailures, c.breakerCooldown)
+	}
+}
@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCircuitBreaker 测试熔断器的状态转换：连续失败达到阈值后打开，冷却结束后只放行一次试探，
+// 试探失败重新打开，试探成功关闭并清零失败次数
+func TestCircuitBreaker(t *testing.T) {
+	const addr = "127.0.0.1:8080"
+	b := newCircuitBreaker(2, time.Second)
+	now := time.Unix(1000, 0)
+	fail := errors.New("connection refused")
+
+	b.record(addr, fail, now)
+	if !b.allow(addr, now) {
+		t.Fatal("未达到阈值时应允许拨号")
+	}
+	b.record(addr, fail, now)
+	if b.allow(addr, now.Add(999*time.Millisecond)) {
+		t.Fatal("达到阈值后冷却期内应拒绝拨号")
+	}
+	if !b.allow("127.0.0.1:8081", now) {
+		t.Error("熔断应只影响失败的地址")
+	}
+
+	// 冷却结束：只放行一次试探，试探失败后重新熔断
+	now = now.Add(time.Second)
+	if !b.allow(addr, now) {
+		t.Fatal("冷却结束后应放行试探拨号")
+	}
+	if b.allow(addr, now) {
+		t.Error("试探进行期间应拒绝其他拨号")
+	}
+	b.record(addr, fail, now)
+	if b.allow(addr, now.Add(500*time.Millisecond)) {
+		t.Error("试探失败后应重新熔断")
+	}
+
+	// 试探成功：关闭熔断器，之后的一次失败不再立即熔断
+	now = now.Add(time.Second)
+	if !b.allow(addr, now) {
+		t.Fatal("冷却结束后应放行试探拨号")
+	}
+	b.record(addr, nil, now)
+	b.record(addr, fail, now)
+	if !b.allow(addr, now) {
+		t.Error("试探成功后应清零失败次数")
+	}
+}
+
+// toggleDialer 在 down 为 true 时拨号失败（模拟拨号耗时后返回），否则转交给 next，并统计拨号次数
+type toggleDialer struct {
+	down  atomic.Bool
+	dials atomic.Int32
+	next  Dialer
+}
+
+func (d *toggleDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.dials.Add(1)
+	if d.down.Load() {
+		time.Sleep(20 * time.Millisecond)
+		return nil, errors.New("connection refused")
+	}
+	return d.next.DialContext(ctx, network, address)
+}
+
+// TestCircuitBreakerUnavailable 测试本地服务熔断期间客户端不再拨号、服务器向公开连接回复 503，
+// 冷却结束后本地服务恢复时连接恢复正常
+func TestCircuitBreakerUnavailable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	const cooldown = 300 * time.Millisecond
+	dialer := &toggleDialer{next: local}
+	dialer.down.Store(true)
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerPublicErrorResponse(PublicErrorResponseHTTP))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(dialer), WithCircuitBreaker(2, cooldown)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	status := func() string {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		resp, _ := io.ReadAll(conn)
+		line, _, _ := strings.Cut(string(resp), "\r\n")
+		return line
+	}
+	for i := 0; i < 2; i++ {
+		if got := status(); got != "HTTP/1.1 502 Bad Gateway" {
+			t.Fatalf("连接本地服务失败时应回复 502, 得到 %q", got)
+		}
+	}
+	if got := status(); got != "HTTP/1.1 503 Service Unavailable" {
+		t.Fatalf("熔断期间应回复 503, 得到 %q", got)
+	}
+	if n := dialer.dials.Load(); n != 2 {
+		t.Errorf("熔断期间不应拨号本地服务, 拨号 %d 次", n)
+	}
+
+	// 本地服务恢复，冷却结束后的试探连接成功
+	dialer.down.Store(false)
+	time.Sleep(cooldown)
+	if err := echoRoundTrip(ctx, public, []byte("ping")); err != nil {
+		t.Fatalf("冷却结束后连接应恢复: %v", err)
+	}
+	if err := echoRoundTrip(ctx, public, []byte("pong")); err != nil {
+		t.Fatalf("熔断关闭后连接应正常: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 内置本地服务：local（包括后端列表、主机名路由和本地路由规则的地址）配置为以下值时，客户端不拨号，
+// 而是在进程内提供该服务，无需真实后端即可端到端验证部署（公开端口 -> 服务器 -> 隧道 -> 客户端）
+const (
+	BuiltinEcho = "builtin:echo" // 原样回显收到的数据
+	BuiltinHTTP = "builtin:http" // 对每个 HTTP 请求回复 200 和请求摘要（纯文本，支持 keep-alive）
+)
+
+// builtinPrefix 内置本地服务地址的前缀
+const builtinPrefix = "builtin:"
+
+// isBuiltinLocal 判断本地服务地址是否为内置本地服务
+func isBuiltinLocal(addr string) bool {
+	return strings.HasPrefix(addr, builtinPrefix)
+}
+
+// dialBuiltin 返回与内置本地服务相连的连接：net.Pipe 的一端作为本地连接，另一端由内置服务在独立的 goroutine 中处理，
+// 本地连接关闭后该 goroutine 随之退出
+func dialBuiltin(addr string) (net.Conn, error) {
+	var serve func(net.Conn)
+	switch addr {
+	case BuiltinEcho:
+		serve = serveBuiltinEcho
+	case BuiltinHTTP:
+		serve = serveBuiltinHTTP
+	default:
+		return nil, fmt.Errorf("未知的内置本地服务 %q（支持 %s、%s）", addr, BuiltinEcho, BuiltinHTTP)
+	}
+	local, remote := net.Pipe()
+	go serve(remote)
+	return local, nil
+}
+
+// serveBuiltinEcho 原样回显收到的数据，直到连接关闭
+func serveBuiltinEcho(conn net.Conn) {
+	defer conn.Close()
+	io.Copy(conn, conn)
+}
+
+// serveBuiltinHTTP 逐个读取 HTTP 请求并回复 200，响应体包含请求行和 Host，便于确认请求经过隧道到达；
+// 请求要求关闭连接或无法解析时关闭连接
+func serveBuiltinHTTP(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+
+		body := fmt.Sprintf("reverse-tunnel %s OK\n%s %s %s\nHost: %s\n", BuiltinHTTP, req.Method, req.RequestURI, req.Proto, req.Host)
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Request:       req,
+			Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			ContentLength: int64(len(body)),
+			Body:          io.NopCloser(strings.NewReader(body)),
+			Close:         req.Close,
+		}
+		if err := resp.Write(conn); err != nil || req.Close {
+			return
+		}
+	}
+}
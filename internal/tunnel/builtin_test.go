@@ -0,0 +1,56 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuiltinLocal 测试 local 为 builtin:echo / builtin:http 时客户端在进程内提供本地服务，公开连接经隧道得到回显和 HTTP 响应
+func TestBuiltinLocal(t *testing.T) {
+	for _, local := range []string{BuiltinEcho, BuiltinHTTP} {
+		t.Run(local, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			control := newMemListener("control")
+			public := newMemListener("public")
+			server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+			go server.Run(ctx)
+			go NewClient("control", local, 0, WithControlDialer(control)).Run(ctx)
+			waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+			conn, err := public.DialContext(ctx, "mem", "public")
+			if err != nil {
+				t.Fatalf("连接公开监听器失败: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+			if local == BuiltinEcho {
+				go conn.Write([]byte("ping"))
+				buf := make([]byte, 4)
+				if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+					t.Fatalf("回显失败: %q, %v", buf, err)
+				}
+				return
+			}
+			r := bufio.NewReader(conn)
+			for _, path := range []string{"/first", "/second"} {
+				go conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: demo.example.com\r\n\r\n"))
+				resp, err := http.ReadResponse(r, nil)
+				if err != nil {
+					t.Fatalf("读取 HTTP 响应失败: %v", err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "GET "+path) || !strings.Contains(string(body), "Host: demo.example.com") {
+					t.Errorf("响应不正确: %d %q", resp.StatusCode, body)
+				}
+			}
+		})
+	}
+}
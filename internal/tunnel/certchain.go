@@ -0,0 +1,81 @@
+package tunnel
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"reverse-tunnel/internal/pqctls"
+)
+
+// CertificateInfo 审计记录中证书链的一个证书
+type CertificateInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"` // 十六进制序列号
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	SHA256    string    `json:"sha256"` // 证书 DER 的 SHA-256 指纹
+}
+
+// peerChainConn 能提供对端证书链的连接（PQC TLS 连接）
+type peerChainConn interface {
+	PeerCertificateChain() ([]*x509.Certificate, error)
+}
+
+// peerCertificateChain 返回控制连接对端的证书链（叶证书在前，验证过时直到信任锚），非 TLS 连接或没有证书时返回 nil
+func peerCertificateChain(conn net.Conn) []*x509.Certificate {
+	if mc, ok := conn.(*macConn); ok {
+		conn = mc.Conn
+	}
+	switch c := conn.(type) {
+	case peerChainConn:
+		chain, err := c.PeerCertificateChain()
+		if err != nil {
+			return nil
+		}
+		return chain
+	case *tls.Conn:
+		state := c.ConnectionState()
+		if len(state.VerifiedChains) > 0 {
+			return state.VerifiedChains[0]
+		}
+		return state.PeerCertificates
+	}
+	return nil
+}
+
+// certificateInfos 提取证书链中每个证书的审计字段
+func certificateInfos(chain []*x509.Certificate) []CertificateInfo {
+	infos := make([]CertificateInfo, 0, len(chain))
+	for _, cert := range chain {
+		sum := sha256.Sum256(cert.Raw)
+		infos = append(infos, CertificateInfo{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			Serial:    fmt.Sprintf("%x", cert.SerialNumber),
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			SHA256:    pqctls.FormatFingerprint(sum[:]),
+		})
+	}
+	return infos
+}
+
+// recordPeerCertChain 在客户端注册成功后把其控制连接的证书链写入运行日志和安全日志（WithServerLogPeerCertChain）
+func (s *Server) recordPeerCertChain(clientID string, conn net.Conn) {
+	chain := certificateInfos(peerCertificateChain(conn))
+	if len(chain) == 0 {
+		return
+	}
+	for i, cert := range chain {
+		log.Printf("客户端证书链 (clientID=%s) [%d] subject=%q issuer=%q serial=%s 有效期=%s~%s",
+			clientID, i, cert.Subject, cert.Issuer, cert.Serial,
+			cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	s.securityLog.accepted(conn.RemoteAddr(), clientID, peerIdentity(conn), chain)
+}
@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLogPeerCertChain 测试启用后客户端注册成功时安全日志写入包含验证后证书链（叶证书、CA）的 handshake_accepted 记录
+func TestLogPeerCertChain(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成 CA 私钥失败: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(0x1a),
+		Subject:               pkix.Name{CommonName: "audit-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("生成 CA 证书失败: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成客户端私钥失败: %v", err)
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(0x2b),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("生成客户端证书失败: %v", err)
+	}
+
+	certPEM, keyPEM := generateTestCert(t)
+	serverCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("加载服务器证书失败: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听控制端口失败: %v", err)
+	}
+	controlListener := handshakeListener{tls.NewListener(inner, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})}
+
+	var securityLog syncBuffer
+	server := NewServer("", "127.0.0.1:0",
+		WithServerControlListener(controlListener),
+		WithServerSecurityLog(&securityLog),
+		WithServerLogPeerCertChain(true))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+
+	dialer := &tls.Dialer{Config: &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}},
+		InsecureSkipVerify: true,
+	}}
+	go NewClient(inner.Addr().String(), "127.0.0.1:1", 0, WithControlDialer(dialer)).Run(ctx)
+	waitStat(t, "安全日志", func() string {
+		if strings.Contains(securityLog.String(), "handshake_accepted") {
+			return "ok"
+		}
+		return ""
+	}, "ok")
+
+	var rec SecurityRecord
+	if err := json.Unmarshal([]byte(strings.TrimSpace(securityLog.String())), &rec); err != nil {
+		t.Fatalf("解析安全日志失败: %v", err)
+	}
+	if rec.Identity != "alice" || rec.ClientID != "client-1" || rec.Reason != "" || len(rec.Chain) != 2 {
+		t.Fatalf("记录应包含 alice 的身份、客户端 ID 和两级证书链: %+v", rec)
+	}
+	leaf, ca := rec.Chain[0], rec.Chain[1]
+	if leaf.Subject != "CN=alice" || leaf.Issuer != "CN=audit-ca" || leaf.Serial != "2b" || leaf.NotAfter.IsZero() || leaf.SHA256 == "" {
+		t.Errorf("叶证书字段不正确: %+v", leaf)
+	}
+	if ca.Subject != "CN=audit-ca" || ca.Serial != "1a" {
+		t.Errorf("CA 证书字段不正确: %+v", ca)
+	}
+}
@@ -2,22 +2,65 @@ package tunnel
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"reverse-tunnel/internal/proto"
 	"reverse-tunnel/internal/pqctls"
 )
 
+// maxRedirectHops 连续重定向的最大次数（防止服务器之间互相重定向形成循环）
+const maxRedirectHops = 3
+
+// errRedirected 表示服务器要求客户端改连其他服务器
+var errRedirected = errors.New("服务器要求重定向")
+
+// errControlRecycled 表示控制连接已达最大存活时间，需要重建
+var errControlRecycled = errors.New("控制连接已达最大存活时间")
+
+// defaultFrameBuffer 读取控制连接的 goroutine 与处理帧的主循环之间默认缓冲的帧数
+const defaultFrameBuffer = 10
+
+// reconnectDelay 连接服务器失败或断开后重试前的等待时间
+var reconnectDelay = 5 * time.Second
+
+// initAckTimeout 发送 INIT 后等待服务器 ASSIGNED/ERROR 响应的超时时间
+var initAckTimeout = 10 * time.Second
+
+// controlRecycleGrace 控制连接到期后等待活跃连接结束的最长时间，超时后强制重建
+// controlRecycleCheckInterval 等待期间检查活跃连接的间隔
+var (
+	controlRecycleGrace         = 30 * time.Second
+	controlRecycleCheckInterval = 250 * time.Millisecond
+)
+
 // Client 表示反向隧道客户端
 type Client struct {
-	serverAddr string // 服务器地址（例如 1.2.3.4:7000）
+	serverAddr string // 服务器地址（例如 1.2.3.4:7000，多个服务器以逗号分隔）
 	localAddr  string // 本地服务地址（例如 127.0.0.1:80）
+	name       string // 实例名称（可选，作为 name 标签附加到所有指标）
 	remotePort int    // 远程端口（服务器要监听的端口，0 表示由服务器指定）
+	// 保护 remotePort、pendingPorts 和 retryPort（运行期间可由 SetRemotePort 修改）
+	remotePortMu sync.Mutex
+	// 已发送、尚未收到 ASSIGNED/ERROR 的 INIT 中的远程端口（按发送顺序），控制连接关闭时清空
+	pendingPorts []int
+	// 重连后被服务器拒绝、正在重试的远程端口（0 表示没有），控制连接关闭时清空，见 portretry.go
+	retryPort int
+	// 隧道至少建立过一次（之后重连时远程端口被拒绝不再使重连失败），见 portretry.go
+	tunnelAssigned atomic.Bool
+	// 串行化 AddTunnel/RemoveTunnel（检查隧道是否存在与修改远程端口之间不被打断），见 tunnelapi.go
+	tunnelMu sync.Mutex
 
 	// PQC mTLS 配置（可选）
 	useTLS     bool
@@ -25,27 +68,155 @@ type Client struct {
 	tlsKeyFile  string
 	tlsCAFile   string
 	serverName  string
+	// TLS 会话缓存（启用会话恢复时非 nil），跨重连保存最近一次可恢复的会话
+	tlsSessionCache *pqctls.SessionCache
+	// PQC mTLS 传输（首次使用时创建），跨重连复用其拨号器（SSL_CTX），Run 结束时释放，只在 Run 的 goroutine 中访问
+	tlsTransport *PQCTLSTransport
+	// 要求的最低 NIST 安全级别（0 表示接受全部 ML-KEM/ML-DSA 参数集）
+	tlsMinSecurityLevel int
+	// 固定的服务器证书 SHA-256 指纹（nil 表示不校验指纹）
+	tlsServerFingerprint []byte
+	// TCP 连接超时和握手超时（0 表示使用默认值）
+	tlsDialTimeout      time.Duration
+	tlsHandshakeTimeout time.Duration
+
+	// 上游 HTTP 代理（可选，nil 表示直接连接服务器）
+	httpProxy *url.URL
+
+	// 控制连接的传输（可选，nil 表示按是否启用 TLS 选择 PQC mTLS 或纯 TCP）
+	transport Transport
 
-	controlConn net.Conn // 控制连接（与 server 的连接）
-	controlMu   sync.RWMutex
+	// 外部提供的拨号器（可选，nil 表示使用 TCP / PQC mTLS），用于替换传输（例如测试中的内存管道）
+	controlDialer Dialer
+	localDial     Dialer
+
+	// 本地服务就绪预检超时（0 表示不预检）
+	localReadyTimeout time.Duration
+	// 拨号本地服务时是否启用 TCP Fast Open
+	localTCPFastOpen bool
+	// 拨号本地服务使用的源地址（nil 表示由系统选择）
+	localDialSource *net.TCPAddr
+	// 连接本地服务使用的 TLS 配置（nil 表示纯 TCP）
+	localTLS *tls.Config
+	// 控制连接单帧写入超时（0 表示不设超时）
+	controlWriteTimeout time.Duration
+	// 连接服务器使用的网络类型（tcp / tcp4 / tcp6，空表示 tcp）
+	network string
+	// 控制连接最大存活时间（0 表示不限制）
+	maxControlConnLifetime time.Duration
+	// 数据连接保活间隔（0 表示不启用）：连接空闲超过该时间后发送零长度 DATA 帧（需协商 FeatureDataKeepalive）
+	dataKeepalive time.Duration
+	// 已读取、等待主循环处理的帧数上限（0 表示 defaultFrameBuffer），见 handleConnection
+	frameBuffer int
+	// 服务器必须支持的协议特性（0 表示不要求）及当前控制连接的特性协商结果（proto.Features，每次连接时重置）
+	requiredFeatures proto.Features
+	features         atomic.Uint32
+	// 当前控制连接已发送 HELLO、已收到服务器的特性协商响应（每次连接时重置），见 fatal.go
+	helloSent, helloReceived atomic.Bool
+	// 遇到致命错误（见 IsFatal）时 Run 返回该错误而不是重连
+	exitOnFatal bool
+	// 本地连接的空闲超时和最长存活时间（0 表示不限制），见 deadlines.go
+	connIdleTimeout time.Duration
+	connMaxLifetime time.Duration
+	// 本端能接收的 DATA 帧负载上限（字节，0 表示 dataChunkSize）及当前控制连接协商的上限（0 表示未协商，每次连接时重置），见 maxdata.go
+	maxDataPayload int
+	maxData        atomic.Int32
+	// 控制连接和本地连接 socket 的缓冲区大小（零值表示系统默认）
+	socketBuffers SocketBuffers
+	// 控制连接帧跟踪（可选，nil 表示不记录），见 frametrace.go
+	frameTracer FrameTracer
+	// 控制连接帧完整性校验的共享密钥（未启用 TLS 时可选，nil 表示不启用，见 framemac.go）
+	frameMACKey []byte
+
+	// pprof 调试监听地址（空表示不启用）及访问所需的管理令牌
+	pprofListenAddr string
+	adminToken      string
+	// 主机名路由键（可选，服务器在全局公开端口上按 SNI/Host 路由到该客户端）
+	hostnames []string
+	// 负载均衡权重（可选，0 表示默认权重）：多个客户端匹配同一路由键时服务器按权重分配公开连接
+	weight int
+	// 远程端口为 0 时仍发送 INIT，从服务器的端口池租用一个远程端口
+	leaseRemotePort bool
+	// 按公开连接来源 IP 选择本地服务的路由规则（按顺序匹配，未命中使用 localAddr）
+	localRoutes []LocalRoute
+	// 按公开连接主机名选择本地服务的路由规则（优先于来源路由，主机名同时注册到服务器）
+	hostRoutes []HostRoute
+	// NEW_CONN 时解析本地地址的解析器（可选，nil 表示使用静态选择的地址），见 localresolve.go
+	localResolver LocalResolver
+	// localAddr 包含多个以逗号分隔的后端时的负载均衡策略、被动健康检查的不健康时长（0 表示不启用）及后端池
+	localBalance      string
+	localUnhealthyFor time.Duration
+	backends          *backendPool
+	// 本地服务熔断：连续拨号失败次数阈值（0 表示不启用）、熔断冷却时间及熔断器，见 breaker.go
+	breakerFailures int
+	breakerCooldown time.Duration
+	breaker         *circuitBreaker
+	// 同时拨号本地服务的连接数上限（0 表示在帧读取循环中逐个同步拨号）、排队等待拨号的连接数上限及拨号池，见 dialpool.go
+	dialWorkers int
+	dialQueue   int
+	dialPool    *dialPool
+	// 本地连接池大小（0 表示不启用）、CLOSE_CONN 后是否将本地连接放回池中复用，及连接池
+	localPoolSize  int
+	localPoolReuse bool
+	localPool      *localConnPool
+	// 是否与本地服务保持一条持久连接、把公开连接作为其上的流（仅适用于实现了 localMux 约定的后端）及多路复用，见 localmux.go
+	localMultiplex bool
+	localMux       *localMux
+
+	controlConn    net.Conn // 控制连接（与 server 的连接）
+	controlMu      sync.RWMutex
+	controlWriteMu controlWriter // 串行化控制连接上的帧写入并统计写入阻塞（见 writeFrame）
+
+	// 服务器下发的重定向目标（为空时连接 servers）及连续重定向次数
+	redirectAddr string
+	redirectHops int
+	// 配置的服务器列表（serverAddr 按逗号拆分）及优先连接的服务器下标（最近一次连接成功的），只在 Run 的 goroutine 中访问
+	servers   []string
+	serverIdx int
+
+	// 未知帧类型日志（按客户端限频）
+	unknownFrameLog rateLimitedLog
+	// 按帧类型和处理结果的计数（pprof 监听器的 /metrics 输出）
+	frameStats frameStats
+	// DATA 帧负载大小直方图（nil 表示未启用），见 payloadsize.go
+	payloadSizes *payloadHistogram
 
 	// connMap 管理 connID 到本地连接的映射
-	connMap sync.Map // map[uint32]net.Conn
+	connMap sync.Map // map[uint32]*trackedConn
+
+	// OpenTelemetry 指标推送配置及推送器（可选，nil 表示不推送），见 otlp.go
+	otlpConfig OTLPConfig
+	otlp       *otlpExporter
+
+	// Run 的运行状态（用于 Shutdown）
+	run runState
 }
 
 // NewClient 创建一个新的客户端实例
-func NewClient(serverAddr, localAddr string, remotePort int) *Client {
-	return &Client{
+func NewClient(serverAddr, localAddr string, remotePort int, opts ...ClientOption) *Client {
+	c := &Client{
 		serverAddr: serverAddr,
 		localAddr:  localAddr,
 		remotePort: remotePort,
 		useTLS:     false,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.initServers()
+	c.initBreaker()
+	c.initDialPool()
+	c.initBackends()
+	c.initLocalMux()
+	c.initLocalPool()
+	c.initHostRoutes()
+	c.otlp = newOTLPExporter(c.otlpConfig, "reverse-tunnel-client", c.name, c.writeMetrics)
+	return c
 }
 
 // NewClientWithTLS 创建一个启用 PQC mTLS 的客户端实例
-func NewClientWithTLS(serverAddr, localAddr string, remotePort int, certFile, keyFile, caFile, serverName string) *Client {
-	return &Client{
+func NewClientWithTLS(serverAddr, localAddr string, remotePort int, certFile, keyFile, caFile, serverName string, opts ...ClientOption) *Client {
+	c := &Client{
 		serverAddr:  serverAddr,
 		localAddr:   localAddr,
 		remotePort:  remotePort,
@@ -55,41 +226,139 @@ func NewClientWithTLS(serverAddr, localAddr string, remotePort int, certFile, ke
 		tlsCAFile:   caFile,
 		serverName:  serverName,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.initServers()
+	c.initBreaker()
+	c.initDialPool()
+	c.initBackends()
+	c.initLocalMux()
+	c.initLocalPool()
+	c.initHostRoutes()
+	c.otlp = newOTLPExporter(c.otlpConfig, "reverse-tunnel-client", c.name, c.writeMetrics)
+	return c
 }
 
-// Run 启动客户端，连接服务器并保持连接
+// Run 启动客户端，连接服务器并保持连接（断开后自动重连），直到 ctx 取消或调用 Shutdown
 func (c *Client) Run(ctx context.Context) error {
+	ctx, stop := c.run.start(ctx)
+	defer stop()
+	defer c.cleanup()
+	// 复用的 PQC TLS 拨号器在 Run 结束时释放（只释放一次，再次 Run 时重新创建）
+	defer func() {
+		if c.tlsTransport != nil {
+			c.tlsTransport.Close()
+			c.tlsTransport = nil
+		}
+	}()
+
+	// 证书缺失或无效时直接返回，不进入重连循环（每次重连都以同样的错误失败，掩盖了配置问题）
+	if t, ok := c.controlTransport().(*PQCTLSTransport); ok {
+		if err := t.CheckFiles(); err != nil {
+			return err
+		}
+	}
+
+	c.startPprofListener(ctx)
+	go c.otlp.run(ctx)
+	if c.localPool != nil {
+		c.localPool.refill()
+		defer c.localPool.close()
+	}
+	if c.localMux != nil {
+		defer c.localMux.close()
+	}
+
 	// 重连循环
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
+			// 本地服务未就绪时不连接服务器，避免公开连接在启动初期全部失败
+			if c.localReadyTimeout > 0 {
+				if err := c.waitLocalReady(ctx); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					log.Printf("本地服务未就绪: %v，5秒后重试...", err)
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(reconnectDelay):
+						continue
+					}
+				}
+			}
+
 			// 尝试连接服务器
 			if err := c.connectToServer(ctx); err != nil {
+				// 重定向目标不可达时立即回退到配置的服务器
+				if c.redirectAddr != "" {
+					log.Printf("连接重定向目标 %s 失败: %v，回退到配置的服务器 %s", c.redirectAddr, err, c.serverAddr)
+					c.redirectAddr = ""
+					continue
+				}
+				if c.exitOn(err) {
+					return err
+				}
 				log.Printf("连接服务器失败: %v，5秒后重试...", err)
 				select {
 				case <-ctx.Done():
 					return ctx.Err()
-				case <-time.After(5 * time.Second):
+				case <-time.After(reconnectDelay):
 					continue
 				}
 			}
 
-			// 连接成功，发送初始化配置（如果指定了远程端口）
-			log.Printf("已连接到服务器: %s", c.serverAddr)
-			if c.remotePort > 0 {
-				if err := c.sendInitConfig(); err != nil {
-					log.Printf("发送初始化配置失败: %v", err)
-					c.closeControlConn()
+			// 连接成功，先进行特性协商，再发送初始化配置（如果指定了远程端口）
+			log.Printf("已连接到服务器: %s", c.currentServerAddr())
+			if err := c.negotiateFeatures(ctx); err != nil {
+				c.closeControlConn()
+				if c.exitOn(err) {
+					return err
+				}
+				log.Printf("特性协商失败: %v，5秒后重试...", err)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(reconnectDelay):
 					continue
 				}
 			}
+			if c.currentRemotePort() > 0 || len(c.hostnames) > 0 || c.weight > 0 {
+				if err := c.setupTunnel(ctx); err != nil {
+					c.closeControlConn()
+					if c.exitOn(err) {
+						return err
+					}
+					log.Printf("建立隧道失败: %v，5秒后重试...", err)
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case <-time.After(reconnectDelay):
+						continue
+					}
+				}
+			}
 			
 			// 处理连接
 			if err := c.handleConnection(ctx); err != nil {
-				log.Printf("处理连接错误: %v", err)
+				if ctx.Err() != nil {
+					// 客户端停止：cleanup 通知服务器正常退出（BYE）后关闭控制连接
+					return ctx.Err()
+				}
+				if errors.Is(err, errRedirected) || errors.Is(err, errControlRecycled) {
+					// 立即连接新的服务器，不等待
+					c.closeControlConn()
+					continue
+				}
 				c.closeControlConn()
+				if c.exitOn(err) {
+					return err
+				}
+				log.Printf("处理连接错误: %v", err)
 			}
 
 			// 连接断开，等待后重连
@@ -97,46 +366,170 @@ func (c *Client) Run(ctx context.Context) error {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-time.After(5 * time.Second):
+			case <-time.After(reconnectDelay):
 				continue
 			}
 		}
 	}
 }
 
-// connectToServer 连接到服务器
-func (c *Client) connectToServer(ctx context.Context) error {
-	var conn net.Conn
-	var err error
+// initServers 按逗号拆分服务器地址（格式与 localAddr 的多个后端相同）
+func (c *Client) initServers() {
+	c.servers = ParseLocalBackends(c.serverAddr)
+	if len(c.servers) == 0 {
+		c.servers = []string{c.serverAddr}
+	}
+}
+
+// initBackends localAddr 包含多个后端时创建后端池
+func (c *Client) initBackends() {
+	if backends := ParseLocalBackends(c.localAddr); len(backends) > 1 {
+		c.backends = newBackendPool(backends, c.localBalance, c.localUnhealthyFor)
+	}
+}
+
+// initLocalPool 启用本地连接池时创建连接池（多个后端或启用本地多路复用时不启用，连接池只针对单一本地地址）
+func (c *Client) initLocalPool() {
+	if c.localPoolSize > 0 && c.backends == nil && c.localMux == nil && !isBuiltinLocal(c.localAddr) {
+		c.localPool = newLocalConnPool(c.localAddr, c.localPoolSize, c.localPoolReuse, c.dialBackend)
+	}
+}
+
+// waitLocalReady 轮询本地服务直到可连接或超过 localReadyTimeout（多个后端时任意一个可连接即视为就绪）
+func (c *Client) waitLocalReady(ctx context.Context) error {
+	deadline := time.Now().Add(c.localReadyTimeout)
+	for {
+		var err error
+		for _, addr := range ParseLocalBackends(c.localAddr) {
+			var conn net.Conn
+			conn, err = c.localTransport(time.Second).DialContext(ctx, "tcp", addr)
+			if err == nil {
+				conn.Close()
+				log.Printf("本地服务已就绪: %s", addr)
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("等待本地服务 %s 超时 (%v): %w", c.localAddr, c.localReadyTimeout, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// currentServerAddr 返回当前应连接的服务器地址（优先使用重定向目标，否则为最近一次连接成功的服务器）
+func (c *Client) currentServerAddr() string {
+	if c.redirectAddr != "" {
+		return c.redirectAddr
+	}
+	return c.servers[c.serverIdx]
+}
+
+// dialNetwork 返回连接服务器使用的网络类型
+func (c *Client) dialNetwork() string {
+	if c.network == "" {
+		return "tcp"
+	}
+	return c.network
+}
 
+// controlTransport 返回建立控制连接使用的拨号器
+// 外部提供的拨号器优先，其次是显式设置的传输，否则按是否启用 TLS 选择 PQC mTLS 或纯 TCP
+func (c *Client) controlTransport() Dialer {
+	if c.controlDialer != nil {
+		return c.controlDialer
+	}
+	if c.transport != nil {
+		return c.transport
+	}
 	if c.useTLS {
-		// 使用 PQC mTLS（通过 OpenSSL）
-		dialer, err := pqctls.NewPQCDialerOpenSSL(c.tlsCertFile, c.tlsKeyFile, c.tlsCAFile)
-		if err != nil {
-			return fmt.Errorf("创建 PQC TLS 拨号器失败: %v", err)
+		if c.tlsTransport == nil {
+			c.tlsTransport = &PQCTLSTransport{
+				CertFile:          c.tlsCertFile,
+				KeyFile:           c.tlsKeyFile,
+				CAFile:            c.tlsCAFile,
+				SessionCache:      c.tlsSessionCache,
+				MinSecurityLevel:  c.tlsMinSecurityLevel,
+				ServerFingerprint: c.tlsServerFingerprint,
+				DialTimeout:       c.tlsDialTimeout,
+				HandshakeTimeout:  c.tlsHandshakeTimeout,
+				Proxy:             c.httpProxy,
+				SocketBuffers:     c.socketBuffers,
+			}
 		}
-		defer dialer.Close()
+		return c.tlsTransport
+	}
+	return TCPTransport{Proxy: c.httpProxy, SocketBuffers: c.socketBuffers}
+}
 
-		conn, err = dialer.Dial("tcp", c.serverAddr)
-		if err != nil {
-			return fmt.Errorf("PQC TLS 连接失败: %v", err)
+// connectToServer 连接到服务器（有重定向目标时只连接重定向目标）
+// 配置了多个服务器时从最近一次连接成功的服务器开始依次尝试，不可达时立即切换到下一个；
+// 全部不可达时返回错误，由 Run 等待重连间隔后再开始下一轮，因此每个服务器在一个重连间隔内最多被连接一次
+func (c *Client) connectToServer(ctx context.Context) error {
+	if c.redirectAddr != "" {
+		return c.dialServer(ctx, c.redirectAddr)
+	}
+
+	// 每个服务器的错误都被包装（%w），调用方可以用 errors.Is/errors.As 判断其中任意一个的原因
+	var failures []string
+	var causes []interface{}
+	fatal := true
+	for i := range c.servers {
+		idx := (c.serverIdx + i) % len(c.servers)
+		serverAddr := c.servers[idx]
+		err := c.dialServer(ctx, serverAddr)
+		if err == nil {
+			if idx != c.serverIdx {
+				log.Printf("已切换到服务器 %s", serverAddr)
+				c.serverIdx = idx
+			}
+			return nil
 		}
-		log.Printf("已建立 PQC mTLS 连接 (via OpenSSL): %s", c.serverAddr)
-	} else {
-		// 使用纯 TCP
-		dialer := &net.Dialer{
-			Timeout: 10 * time.Second,
+		if len(c.servers) == 1 || ctx.Err() != nil {
+			return err
 		}
+		log.Printf("连接服务器 %s 失败: %v", serverAddr, err)
+		failures = append(failures, "%s: %w")
+		causes = append(causes, serverAddr, err)
+		fatal = fatal && IsFatal(err)
+	}
+	return &unreachableError{err: fmt.Errorf("所有服务器均不可达 ("+strings.Join(failures, "; ")+")", causes...), fatal: fatal}
+}
 
-		conn, err = dialer.DialContext(ctx, "tcp", c.serverAddr)
+// dialServer 建立到 serverAddr 的控制连接（启用帧完整性校验时完成 MAC 协商）
+func (c *Client) dialServer(ctx context.Context, serverAddr string) error {
+	conn, err := c.controlTransport().DialContext(ctx, c.dialNetwork(), serverAddr)
+	if err != nil {
+		return err
+	}
+	if pqcConn, ok := conn.(*pqctls.PQCConn); ok {
+		state := pqcConn.ConnectionState()
+		if state.DidResume {
+			log.Printf("已建立 PQC mTLS 连接 (via OpenSSL，恢复会话): %s (%s, %s, ALPN=%s)", serverAddr, state.Version, state.Group, state.NegotiatedProtocol)
+		} else {
+			log.Printf("已建立 PQC mTLS 连接 (via OpenSSL): %s (%s, %s, %s, ALPN=%s)", serverAddr, state.Version, state.Group, state.Sigalg, state.NegotiatedProtocol)
+		}
+	}
+	if c.frameMACKey != nil {
+		macConn, err := clientFrameMAC(conn, c.frameMACKey)
 		if err != nil {
+			conn.Close()
 			return err
 		}
+		conn = macConn
 	}
 
 	c.controlMu.Lock()
 	c.controlConn = conn
 	c.controlMu.Unlock()
+	c.features.Store(0)
+	c.helloSent.Store(false)
+	c.helloReceived.Store(false)
+	c.maxData.Store(0)
 
 	return nil
 }
@@ -149,13 +542,27 @@ func (c *Client) closeControlConn() {
 		c.controlConn = nil
 	}
 	c.controlMu.Unlock()
+
+	// 未确认的 INIT 随控制连接失效，重连后按当前生效的远程端口重新发送
+	c.remotePortMu.Lock()
+	c.pendingPorts = nil
+	c.retryPort = 0
+	c.remotePortMu.Unlock()
 }
 
 // handleConnection 处理与服务器的连接
 func (c *Client) handleConnection(ctx context.Context) error {
 	// 启动从服务器读取帧的 goroutine
-	frameChan := make(chan *proto.Frame, 10)
+	// 缓冲区满（主循环处理帧过慢，例如写入本地连接阻塞）时读取 goroutine 阻塞，不再读取控制连接，
+	// 由 TCP 流量控制将反压传递给服务器，而不是无限缓冲
+	frameBuffer := c.frameBuffer
+	if frameBuffer <= 0 {
+		frameBuffer = defaultFrameBuffer
+	}
+	frameChan := make(chan *proto.Frame, frameBuffer)
 	errChan := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
 
 	go func() {
 		for {
@@ -172,95 +579,388 @@ func (c *Client) handleConnection(ctx context.Context) error {
 					return
 				}
 
-				frame, err := proto.DecodeFrame(conn)
+				frame, err := readFrame(conn, c.frameTracer)
 				if err != nil {
 					errChan <- err
 					return
 				}
-				frameChan <- frame
+				select {
+				case frameChan <- frame:
+				case <-done:
+					// 主循环已退出，丢弃剩余的帧
+					return
+				}
 			}
 		}
 	}()
 
+	// 控制连接到期后进入回收状态：等待活跃连接结束（最多 controlRecycleGrace）再重建
+	var lifetimeC <-chan time.Time
+	if c.maxControlConnLifetime > 0 {
+		lifetimeTimer := time.NewTimer(c.maxControlConnLifetime)
+		defer lifetimeTimer.Stop()
+		lifetimeC = lifetimeTimer.C
+	}
+	var recycleTicker *time.Ticker
+	var recycleC <-chan time.Time
+	var recycleDeadline time.Time
+	defer func() {
+		if recycleTicker != nil {
+			recycleTicker.Stop()
+		}
+	}()
+	startRecycle := func(reason string) {
+		if recycleTicker != nil {
+			return
+		}
+		log.Printf("%s，等待活跃连接结束后重建控制连接（最多 %v）", reason, controlRecycleGrace)
+		recycleDeadline = time.Now().Add(controlRecycleGrace)
+		recycleTicker = time.NewTicker(controlRecycleCheckInterval)
+		recycleC = recycleTicker.C
+	}
+
+	// 数据连接保活：定期为空闲的连接发送零长度 DATA 帧
+	var keepaliveC <-chan time.Time
+	if c.dataKeepalive > 0 {
+		keepaliveTicker := time.NewTicker(c.dataKeepalive)
+		defer keepaliveTicker.Stop()
+		keepaliveC = keepaliveTicker.C
+	}
+
 	// 主循环：处理来自服务器的帧
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-keepaliveC:
+			c.sendDataKeepalives()
+		case <-lifetimeC:
+			startRecycle(fmt.Sprintf("控制连接已达最大存活时间 (%v)", c.maxControlConnLifetime))
+		case <-recycleC:
+			if c.activeConnCount() == 0 || time.Now().After(recycleDeadline) {
+				return errControlRecycled
+			}
 		case err := <-errChan:
 			if err != io.EOF {
 				log.Printf("读取帧错误: %v", err)
 			}
 			return err
 		case frame := <-frameChan:
+			if frame.Type == proto.FrameTypeREDIRECT {
+				if len(frame.Payload) == 0 {
+					startRecycle("服务器要求重建控制连接")
+					continue
+				}
+				if c.handleRedirect(frame) {
+					return errRedirected
+				}
+				continue
+			}
 			if err := c.handleFrame(ctx, frame); err != nil {
+				if c.exitOnFatal && IsFatal(err) {
+					// 服务器拒绝了客户端或协议不兼容：由 Run 返回该错误，不再重连（未启用时照常记录日志）
+					return err
+				}
 				log.Printf("处理帧错误 (connID=%d): %v", frame.ConnID, err)
 			}
 		}
 	}
 }
 
+// sendDataKeepalives 为空闲超过保活间隔的数据连接发送零长度 DATA 帧
+// 接收方丢弃零长度 DATA 帧（不写入连接），帧只用于保持控制连接经过的 NAT/中间设备上的流状态，
+// 不改变转发的字节流；公开连接和本地连接两侧由 TCP keepalive 维持
+func (c *Client) sendDataKeepalives() {
+	c.controlMu.RLock()
+	controlConn := c.controlConn
+	c.controlMu.RUnlock()
+	// 只在特性协商结果包含保活时发送（服务器不支持时不发送未约定的零长度帧）
+	if controlConn == nil || !c.hasFeature(proto.FeatureDataKeepalive) {
+		return
+	}
+
+	now := time.Now()
+	c.connMap.Range(func(key, value interface{}) bool {
+		if value.(*trackedConn).idleSince(now) < c.dataKeepalive {
+			return true
+		}
+		frame := &proto.Frame{Type: proto.FrameTypeDATA, ConnID: key.(uint32)}
+		if err := writeFrame(controlConn, &c.controlWriteMu, frame, c.controlWriteTimeout); err != nil {
+			log.Printf("发送数据连接保活帧错误 (connID=%d): %v", key.(uint32), err)
+			return false
+		}
+		return true
+	})
+}
+
 // handleFrame 处理来自服务器的帧
 func (c *Client) handleFrame(ctx context.Context, frame *proto.Frame) error {
 	switch frame.Type {
 	case proto.FrameTypeNEW_CONN:
-		return c.handleNewConn(ctx, frame)
+		if c.dialPool != nil {
+			// 拨号在拨号池中进行，完成后计数；服务器已开始转发流量，重置连续重定向计数
+			c.redirectHops = 0
+			if !c.dispatchNewConn(ctx, frame) {
+				c.frameStats.inc(frame.Type, frameRejected)
+			}
+			return nil
+		}
+		err := c.handleNewConn(ctx, frame)
+		if err != nil {
+			c.frameStats.inc(frame.Type, frameDialError)
+		} else {
+			c.frameStats.inc(frame.Type, frameOK)
+		}
+		return err
 	case proto.FrameTypeDATA:
-		return c.handleDataFrame(frame)
+		if c.rejectOversizedData(frame) {
+			return nil
+		}
+		c.payloadSizes.observe(payloadReceived, len(frame.Payload))
+		c.frameStats.inc(frame.Type, c.handleDataFrame(frame))
+		return nil
 	case proto.FrameTypeCLOSE:
-		return c.handleCloseFrame(frame)
+		c.frameStats.inc(frame.Type, c.handleCloseFrame(frame))
+		return nil
+	case proto.FrameTypeASSIGNED:
+		// SetRemotePort 重新发送 INIT 后服务器的确认
+		c.frameStats.inc(frame.Type, frameOK)
+		c.ackInit(true)
+		if len(frame.Payload) == 0 {
+			log.Printf("隧道已更新: 已取消远程端口")
+		} else {
+			c.logAssigned("隧道已更新", frame.Payload)
+		}
+		return nil
+	case proto.FrameTypeHEALTH_CHECK:
+		c.frameStats.inc(frame.Type, frameOK)
+		c.handleHealthCheck(frame)
+		return nil
+	case proto.FrameTypeHELLO:
+		// 未要求特性时不等待的 HELLO 响应
+		c.frameStats.inc(frame.Type, frameOK)
+		return c.handleHello(frame.Payload)
+	case proto.FrameTypeERROR:
+		c.frameStats.inc(frame.Type, frameOK)
+		if port, ok := c.ackInit(false); ok {
+			if port == c.currentRetryPort() {
+				log.Printf("错误: 服务器仍拒绝远程端口 %d: %s，%v 后重试", port, string(frame.Payload), remotePortRetryInterval)
+				return nil
+			}
+			log.Printf("服务器拒绝远程端口 %d，保持原端口 %d: %s", port, c.currentRemotePort(), string(frame.Payload))
+			return nil
+		}
+		if err := c.serverRejection(frame.Payload); err != nil {
+			return err
+		}
+		log.Printf("服务器返回错误: %s", string(frame.Payload))
+		return nil
 	default:
-		log.Printf("未知帧类型: %d, connID=%d", frame.Type, frame.ConnID)
+		c.frameStats.inc(frame.Type, frameIgnored)
+		c.unknownFrameLog.printf("未知帧类型: %d, connID=%d", frame.Type, frame.ConnID)
 		return nil
 	}
 }
 
+// activeConnCount 返回当前活跃的本地连接数
+func (c *Client) activeConnCount() int {
+	count := 0
+	c.connMap.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// handleRedirect 处理 REDIRECT 帧，返回 true 表示需要断开并改连新服务器
+// 连续重定向超过 maxRedirectHops 次（未在中途转发过任何连接）时忽略，避免循环
+func (c *Client) handleRedirect(frame *proto.Frame) bool {
+	addr, err := proto.DecodeRedirect(frame.Payload)
+	if err != nil {
+		log.Printf("忽略无效的 REDIRECT 帧: %v", err)
+		return false
+	}
+	if c.redirectHops >= maxRedirectHops {
+		log.Printf("连续重定向已达 %d 次，忽略重定向到 %s", maxRedirectHops, addr)
+		return false
+	}
+
+	c.redirectHops++
+	c.redirectAddr = addr
+	log.Printf("服务器要求重定向到 %s (第 %d 次)，正在重连...", addr, c.redirectHops)
+	return true
+}
+
 // handleNewConn 处理 NEW_CONN 帧，创建到本地服务的连接
 func (c *Client) handleNewConn(ctx context.Context, frame *proto.Frame) error {
-	log.Printf("收到 NEW_CONN 帧，connID=%d，正在连接本地服务: %s", frame.ConnID, c.localAddr)
+	info, err := proto.DecodeNewConnInfo(frame.Payload)
+	if err != nil {
+		log.Printf("解析 NEW_CONN 负载错误 (connID=%d): %v", frame.ConnID, err)
+		info = &proto.NewConnInfo{}
+	}
+	traceID := info.TraceID
+	if traceID == "" {
+		traceID = "-"
+	}
+	localAddr, err := c.resolveLocalAddr(ctx, info.SourceAddr, info.Host, traceID)
+	if err != nil {
+		log.Printf("解析本地服务地址失败 (connID=%d, trace=%s, host=%s): %v", frame.ConnID, traceID, info.Host, err)
+		c.sendCloseFrame(frame.ConnID, traceID, proto.CloseError)
+		return err
+	}
+	log.Printf("收到 NEW_CONN 帧，connID=%d, trace=%s, src=%s, host=%s，正在连接本地服务: %s", frame.ConnID, traceID, info.SourceAddr, info.Host, localAddr)
 
-	// 连接到本地服务
-	localConn, err := net.DialTimeout("tcp", c.localAddr, 5*time.Second)
+	// 连接到本地服务（未命中主机名路由和来源路由、解析器未改写地址且配置了多个后端时按负载均衡策略选择）
+	var localConn net.Conn
+	fromPool := false
+	if c.backends != nil && localAddr == c.localAddr {
+		localConn, localAddr, err = c.backends.dial(c.dialBackend)
+	} else if c.localMux != nil && localAddr == c.localAddr {
+		localConn, err = c.localMux.open(&proto.NewConnInfo{TraceID: info.TraceID, SourceAddr: info.SourceAddr, Host: info.Host})
+	} else if c.localPool != nil && localAddr == c.localAddr {
+		localConn, err = c.localPool.get()
+		fromPool = true
+	} else {
+		localConn, err = c.dialBackend(localAddr)
+	}
+	if errors.Is(err, errCircuitOpen) {
+		// 熔断中不拨号，以 CloseUnavailable 通知服务器（服务器可以据此回复 HTTP 503）
+		log.Printf("本地服务熔断中，直接关闭连接 (connID=%d, trace=%s): %v", frame.ConnID, traceID, err)
+		c.sendCloseFrame(frame.ConnID, traceID, proto.CloseUnavailable)
+		return err
+	}
 	if err != nil {
-		log.Printf("连接本地服务失败 (connID=%d): %v", frame.ConnID, err)
+		log.Printf("连接本地服务失败 (connID=%d, trace=%s): %v", frame.ConnID, traceID, err)
 		// 发送 CLOSE_CONN 帧通知服务器
-		c.sendCloseFrame(frame.ConnID)
+		c.sendCloseFrame(frame.ConnID, traceID, proto.CloseError)
 		return err
 	}
 
-	// 将连接存入 map
-	c.connMap.Store(frame.ConnID, localConn)
-	log.Printf("已建立本地连接: connID=%d, local=%s", frame.ConnID, c.localAddr)
+	// 当前服务器已开始转发流量，重置连续重定向计数（redirectHops 只在帧读取循环中访问，拨号池中的拨号由 handleFrame 重置）
+	if c.dialPool == nil {
+		c.redirectHops = 0
+	}
+
+	// 将连接存入 map（来自连接池且启用复用时，CLOSE_CONN 后放回池中）
+	tc := newTrackedConn(localConn, traceID)
+	tc.deadlines.set(c.connIdleTimeout, 0, c.connMaxLifetime)
+	if fromPool && c.localPool.reuse {
+		tc.returnTo = c.localPool
+	}
+	if ok, err := c.settleDial(frame.ConnID, tc); !ok {
+		// 服务器在拨号期间关闭了连接（已回发 CLOSE_CONN），或写入缓存的数据失败
+		localConn.Close()
+		if err != nil {
+			log.Printf("写入本地连接错误 (connID=%d, trace=%s): %v", frame.ConnID, traceID, err)
+			c.sendCloseFrame(frame.ConnID, traceID, proto.CloseError)
+			return err
+		}
+		log.Printf("连接在本地连接建立之前已被关闭: connID=%d, trace=%s", frame.ConnID, traceID)
+		return nil
+	}
+	log.Printf("已建立本地连接: connID=%d, trace=%s, local=%s", frame.ConnID, traceID, localAddr)
+	c.sendNewConnAck(frame.ConnID, traceID)
 
 	// 启动从本地连接读取数据并转发给服务器的 goroutine
-	go c.forwardLocalToServer(ctx, frame.ConnID, localConn)
+	go c.forwardLocalToServer(ctx, frame.ConnID, traceID, tc)
 
 	return nil
 }
 
+// localDialer 返回拨号本地服务使用的 Dialer（应用源地址和 TCP Fast Open 设置）
+func (c *Client) localDialer(timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+	}
+	if c.localDialSource != nil {
+		dialer.LocalAddr = c.localDialSource
+	}
+	var tfo func(network, address string, c syscall.RawConn) error
+	if c.localTCPFastOpen {
+		tfo = setTCPFastOpenConnect
+	}
+	dialer.Control = chainControl(tfo, c.socketBuffers.control())
+	return dialer
+}
+
+// localTransport 返回拨号本地服务使用的拨号器（外部提供的拨号器优先）
+func (c *Client) localTransport(timeout time.Duration) Dialer {
+	if c.localDial != nil {
+		return c.localDial
+	}
+	return c.localDialer(timeout)
+}
+
+// dialLocal 连接本地服务
+func (c *Client) dialLocal(localAddr string) (net.Conn, error) {
+	if isBuiltinLocal(localAddr) {
+		return dialBuiltin(localAddr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := c.localTransport(5*time.Second).DialContext(ctx, "tcp", localAddr)
+	if err != nil || c.localTLS == nil {
+		return conn, err
+	}
+	return localTLSClient(ctx, conn, localAddr, c.localTLS)
+}
+
 // forwardLocalToServer 从本地连接读取数据并转发给服务器
-func (c *Client) forwardLocalToServer(ctx context.Context, connID uint32, localConn net.Conn) {
+// 本地连接被 handleCloseFrame 标记为放回连接池时，读取被截止时间唤醒，此时将连接交还连接池而不是关闭
+func (c *Client) forwardLocalToServer(ctx context.Context, connID uint32, traceID string, localConn *trackedConn) {
 	defer func() {
-		localConn.Close()
 		c.connMap.Delete(connID)
-		log.Printf("本地连接已关闭: connID=%d", connID)
+		if localConn.returning() {
+			localConn.SetWriteDeadline(time.Time{}) // 清除转发期间设置的写截止时间（读截止时间由连接池校验时重置）
+			localConn.returnTo.put(localConn.Conn)
+			log.Printf("本地连接已放回连接池: connID=%d, trace=%s", connID, traceID)
+			return
+		}
+		localConn.Close()
+		log.Printf("本地连接已关闭: connID=%d, trace=%s", connID, traceID)
 	}()
 
-	buf := make([]byte, 4096)
+	buf := make([]byte, dataChunk(int(c.maxData.Load())))
 	for {
 		select {
 		case <-ctx.Done():
 			// 发送 CLOSE_CONN 帧
-			c.sendCloseFrame(connID)
+			if localConn.closeLocal() {
+				c.sendCloseFrame(connID, traceID, proto.CloseShutdown)
+			}
 			return
 		default:
+			localConn.deadlines.armRead(localConn.Conn)
+			if localConn.returning() {
+				// 在设置读截止时间之前已被标记为放回连接池（唤醒用的截止时间可能已被覆盖）
+				return
+			}
 			n, err := localConn.Read(buf)
+			if err != nil && isDeadlineErr(err) && !localConn.returning() {
+				// 截止时间已被另一个方向的数据推迟时继续读取，真正到期时通知服务器并关闭本地连接
+				kind := localConn.deadlines.expired(time.Now())
+				if kind == "" {
+					continue
+				}
+				c.expireLocalConn(connID, localConn, kind)
+				return
+			}
 			if err != nil {
+				if localConn.returning() {
+					// 服务器已关闭该连接，本地连接放回连接池
+					return
+				}
+				if !localConn.closeLocal() {
+					// 服务器已关闭该连接：handleCloseFrame 已关闭本地连接并回发 CLOSE_CONN，不再重复发送
+					return
+				}
+				// 状态已离开 connOpen：之后到达的服务器 CLOSE_CONN（双方同时关闭）被忽略，不会再回发
 				if err != io.EOF {
-					log.Printf("读取本地连接数据错误 (connID=%d): %v", connID, err)
+					log.Printf("读取本地连接数据错误 (connID=%d, trace=%s): %v", connID, traceID, err)
 				}
-				// 发送 CLOSE_CONN 帧通知服务器
-				c.sendCloseFrame(connID)
+				// 发送 CLOSE_CONN 帧通知服务器（本地服务重置连接时服务器同样以 RST 关闭公开连接）
+				c.sendCloseFrame(connID, traceID, closeCodeForErr(err))
 				return
 			}
 
@@ -272,12 +972,6 @@ func (c *Client) forwardLocalToServer(ctx context.Context, connID uint32, localC
 					Payload: buf[:n],
 				}
 
-				frameData, err := proto.EncodeFrame(dataFrame)
-				if err != nil {
-					log.Printf("编码 DATA 帧错误 (connID=%d): %v", connID, err)
-					return
-				}
-
 				c.controlMu.RLock()
 				controlConn := c.controlConn
 				c.controlMu.RUnlock()
@@ -286,68 +980,106 @@ func (c *Client) forwardLocalToServer(ctx context.Context, connID uint32, localC
 					return
 				}
 
-				if _, err := controlConn.Write(frameData); err != nil {
-					log.Printf("发送 DATA 帧错误 (connID=%d): %v", connID, err)
+				if err := writeFrame(controlConn, &c.controlWriteMu, dataFrame, c.controlWriteTimeout); err != nil {
+					log.Printf("发送 DATA 帧错误 (connID=%d, trace=%s): %v", connID, traceID, err)
+					// 控制连接已不可写，不再发送 CLOSE_CONN
+					localConn.closeLocal()
 					return
 				}
+				c.payloadSizes.observe(payloadSent, n)
 			}
 		}
 	}
 }
 
-// handleDataFrame 处理来自服务器的 DATA 帧，写入本地连接
-func (c *Client) handleDataFrame(frame *proto.Frame) error {
-	conn, ok := c.connMap.Load(frame.ConnID)
-	if !ok {
-		log.Printf("警告: 未找到 connID=%d 对应的本地连接", frame.ConnID)
-		return nil
+// handleDataFrame 处理来自服务器的 DATA 帧，写入本地连接，返回处理结果（用于帧计数）
+func (c *Client) handleDataFrame(frame *proto.Frame) string {
+	value, ok := c.connMap.Load(frame.ConnID)
+	if !ok && c.dialPool != nil {
+		// 本地连接仍在排队或拨号，先缓存数据
+		if outcome, buffered := c.pendingData(frame); buffered {
+			return outcome
+		}
+		value, ok = c.connMap.Load(frame.ConnID)
 	}
-
-	localConn, ok := conn.(net.Conn)
 	if !ok {
-		log.Printf("错误: connID=%d 对应的连接类型错误", frame.ConnID)
-		return nil
+		log.Printf("警告: 未找到 connID=%d 对应的本地连接", frame.ConnID)
+		return frameUnknownConn
 	}
+	localConn := value.(*trackedConn)
 
 	// 将数据写入本地连接
 	if len(frame.Payload) > 0 {
+		kind := localConn.deadlines.armWrite(localConn.Conn)
 		if _, err := localConn.Write(frame.Payload); err != nil {
-			log.Printf("写入本地连接错误 (connID=%d): %v", frame.ConnID, err)
-			// 连接可能已关闭，清理并发送 CLOSE_CONN
+			if isDeadlineErr(err) && kind != "" {
+				// 本地服务在截止时间内没有接收数据
+				if !c.expireLocalConn(frame.ConnID, localConn, kind) {
+					return frameUnknownConn
+				}
+				localConn.Close()
+				c.connMap.Delete(frame.ConnID)
+				return frameWriteError
+			}
+			// 连接已被关闭（本端或服务器先关闭）时由对应的一方完成清理
+			if !localConn.closeLocal() {
+				return frameUnknownConn
+			}
+			log.Printf("写入本地连接错误 (connID=%d, trace=%s): %v", frame.ConnID, localConn.traceID, err)
 			localConn.Close()
 			c.connMap.Delete(frame.ConnID)
-			c.sendCloseFrame(frame.ConnID)
-			return err
+			c.sendCloseFrame(frame.ConnID, localConn.traceID, proto.CloseError)
+			return frameWriteError
 		}
+		c.consumeWindow(frame.ConnID, localConn, len(frame.Payload))
 	}
 
-	return nil
+	return frameOK
 }
 
-// handleCloseFrame 处理来自服务器的 CLOSE_CONN 帧
-func (c *Client) handleCloseFrame(frame *proto.Frame) error {
-	conn, ok := c.connMap.LoadAndDelete(frame.ConnID)
+// handleCloseFrame 处理来自服务器的 CLOSE_CONN 帧，返回处理结果（用于帧计数）
+func (c *Client) handleCloseFrame(frame *proto.Frame) string {
+	value, ok := c.connMap.Load(frame.ConnID)
+	if !ok && c.dialPool != nil {
+		if c.cancelPendingDial(frame) {
+			return frameOK
+		}
+		value, ok = c.connMap.Load(frame.ConnID)
+	}
 	if !ok {
 		// 连接可能已经关闭
-		return nil
+		return frameUnknownConn
 	}
-
-	localConn, ok := conn.(net.Conn)
-	if !ok {
-		return nil
+	localConn := value.(*trackedConn)
+	if !localConn.closeRemote() {
+		// 本端已先关闭并发送了 CLOSE_CONN（双方同时关闭），不再回发
+		return frameUnknownConn
 	}
+	c.connMap.Delete(frame.ConnID)
 
-	localConn.Close()
-	log.Printf("收到 CLOSE_CONN 帧，已关闭本地连接: connID=%d", frame.ConnID)
+	traceID := localConn.traceID
+	reason := proto.DecodeCloseReason(frame.Payload)
+	if localConn.returnTo != nil && reason == proto.CloseGraceful {
+		// 唤醒转发 goroutine，由其将连接放回连接池
+		localConn.markReturning()
+		localConn.SetReadDeadline(time.Now())
+		log.Printf("收到 CLOSE_CONN 帧，本地连接将放回连接池: connID=%d, trace=%s", frame.ConnID, traceID)
+	} else {
+		// 按服务器给出的原因关闭本地连接（公开连接被重置或出错时以 RST 关闭）
+		closeWithReason(localConn, reason)
+		log.Printf("收到 CLOSE_CONN 帧 (原因=%s)，已关闭本地连接: connID=%d, trace=%s", reason, frame.ConnID, traceID)
+	}
 
 	// 回发 CLOSE_CONN 帧（防止半开连接）
-	c.sendCloseFrame(frame.ConnID)
+	// 每个 connID 最多回发一次：只有状态从 connOpen 转为 connRemoteClosed 的那一次走到这里；
+	// 服务器收到 CLOSE_CONN 从不回发，因此关闭交换不会形成循环
+	c.sendCloseFrame(frame.ConnID, traceID, reason)
 
-	return nil
+	return frameOK
 }
 
-// sendCloseFrame 发送 CLOSE_CONN 帧给服务器
-func (c *Client) sendCloseFrame(connID uint32) {
+// sendCloseFrame 发送携带关闭原因的 CLOSE_CONN 帧给服务器
+func (c *Client) sendCloseFrame(connID uint32, traceID string, reason proto.CloseReason) {
 	c.controlMu.RLock()
 	controlConn := c.controlConn
 	c.controlMu.RUnlock()
@@ -359,26 +1091,192 @@ func (c *Client) sendCloseFrame(connID uint32) {
 	frame := &proto.Frame{
 		Type:    proto.FrameTypeCLOSE,
 		ConnID:  connID,
-		Payload: nil,
+		Payload: proto.EncodeCloseReason(reason),
+	}
+
+	if err := writeFrame(controlConn, &c.controlWriteMu, frame, c.controlWriteTimeout); err != nil {
+		log.Printf("发送 CLOSE_CONN 帧错误 (connID=%d, trace=%s): %v", connID, traceID, err)
 	}
+}
 
-	frameData, err := proto.EncodeFrame(frame)
+// sendNewConnAck 本地连接建立后回复 NEW_CONN_ACK（只在特性协商结果包含 conn_ack 时发送），
+// 在启动转发 goroutine 之前调用，因此服务器总是先收到 ACK 再收到该连接的 DATA
+func (c *Client) sendNewConnAck(connID uint32, traceID string) {
+	c.controlMu.RLock()
+	controlConn := c.controlConn
+	c.controlMu.RUnlock()
+
+	if controlConn == nil || !c.hasFeature(proto.FeatureConnAck) {
+		return
+	}
+
+	frame := &proto.Frame{Type: proto.FrameTypeNEW_CONN_ACK, ConnID: connID}
+	if err := writeFrame(controlConn, &c.controlWriteMu, frame, c.controlWriteTimeout); err != nil {
+		log.Printf("发送 NEW_CONN_ACK 帧错误 (connID=%d, trace=%s): %v", connID, traceID, err)
+	}
+}
+
+// sendBye 发送 BYE 帧，通知服务器客户端正常退出（服务器立即注销，不必等待控制连接断开）
+func (c *Client) sendBye() {
+	c.controlMu.RLock()
+	controlConn := c.controlConn
+	c.controlMu.RUnlock()
+
+	if controlConn == nil {
+		return
+	}
+
+	frame := &proto.Frame{Type: proto.FrameTypeBYE}
+	if err := writeFrame(controlConn, &c.controlWriteMu, frame, c.controlWriteTimeout); err != nil {
+		log.Printf("发送 BYE 帧错误: %v", err)
+	}
+}
+
+// setupTunnel 发送 INIT 并等待服务器的 ASSIGNED 或 ERROR 响应
+// 等待期间收到的其他帧（例如全局监听器模式下的 NEW_CONN）照常处理。
+// 旧版本服务器不回复 INIT：等待超时时记录警告并视为隧道已建立（无法确认端口是否绑定成功），不断开控制连接
+func (c *Client) setupTunnel(ctx context.Context) error {
+	if err := c.sendInitConfig(); err != nil {
+		return err
+	}
+
+	c.controlMu.RLock()
+	controlConn := c.controlConn
+	c.controlMu.RUnlock()
+	if controlConn == nil {
+		return fmt.Errorf("控制连接不存在")
+	}
+
+	controlConn.SetReadDeadline(time.Now().Add(initAckTimeout))
+	defer controlConn.SetReadDeadline(time.Time{})
+
+	counted := &readCountConn{Conn: controlConn}
+	for {
+		counted.n = 0
+		frame, err := readFrame(counted, c.frameTracer)
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("等待服务器确认隧道配置失败: %w", err)
+			}
+			// 帧读到一半时超时，剩余字节仍在连接中，控制连接不能继续使用
+			if counted.n > 0 {
+				return fmt.Errorf("%v 内未收到服务器对隧道配置的确认（超时时帧只读取了 %d 字节）", initAckTimeout, counted.n)
+			}
+			// 完成了特性协商的服务器一定会回复 INIT，只有未进行特性协商的旧版本服务器不回复
+			if c.hasFeature(proto.FeatureAssignmentInfo) {
+				return fmt.Errorf("%v 内未收到服务器对隧道配置的确认", initAckTimeout)
+			}
+			log.Printf("警告: %v 内未收到服务器对隧道配置的确认（服务器可能是不回复 INIT 的旧版本），继续使用该控制连接", initAckTimeout)
+			return nil
+		}
+
+		switch frame.Type {
+		case proto.FrameTypeASSIGNED:
+			c.ackInit(true)
+			c.tunnelAssigned.Store(true)
+			c.logAssigned("隧道已建立", frame.Payload)
+			return nil
+		case proto.FrameTypeERROR:
+			port, _ := c.ackInit(false)
+			if err := c.serverRejection(frame.Payload); err != nil {
+				return err
+			}
+			// 重连时只是远程端口被拒绝：记录该端口的错误并重试，不使整个重连失败
+			if c.rejectedOnReconnect(ctx, port, string(frame.Payload)) {
+				return nil
+			}
+			return fmt.Errorf("服务器拒绝隧道配置: %s", string(frame.Payload))
+		default:
+			if err := c.handleFrame(ctx, frame); err != nil {
+				log.Printf("处理帧错误 (connID=%d): %v", frame.ConnID, err)
+			}
+		}
+	}
+}
+
+// readCountConn 记录读取的字节数，用于判断读取超时是否发生在帧中间
+type readCountConn struct {
+	net.Conn
+	n int
+}
+
+func (c *readCountConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.n += n
+	return n, err
+}
+
+// logAssigned 按服务器 ASSIGNED 的负载记录实际生效的映射关系
+// 服务器使用全局公开端口时请求的远程端口被忽略，记录警告，映射关系以服务器给出的公开地址为准
+func (c *Client) logAssigned(event string, payload []byte) {
+	assignment, err := proto.DecodeAssignment(payload)
 	if err != nil {
-		log.Printf("编码 CLOSE_CONN 帧错误 (connID=%d): %v", connID, err)
+		log.Printf("%s: 服务器公开地址=%s -> 本地地址=%s", event, string(payload), c.localAddr)
 		return
 	}
+	if assignment.IgnoredPort > 0 {
+		log.Printf("警告: 服务器使用全局公开端口，忽略了请求的远程端口 %d", assignment.IgnoredPort)
+	}
+	log.Printf("%s: 服务器公开地址=%s -> 本地地址=%s", event, assignment.Addr, c.localAddr)
+}
+
+// currentRemotePort 返回当前的远程端口
+func (c *Client) currentRemotePort() int {
+	c.remotePortMu.Lock()
+	defer c.remotePortMu.Unlock()
+	return c.remotePort
+}
 
-	if _, err := controlConn.Write(frameData); err != nil {
-		log.Printf("发送 CLOSE_CONN 帧错误 (connID=%d): %v", connID, err)
+// SetRemotePort 修改远程端口（例如重新加载配置后），0 表示取消远程端口。
+// 已连接时立即在当前控制连接上重新发送 INIT，控制连接不中断：服务器先在新端口上监听，再关闭原端口的监听器
+// （端口为 0 时只关闭原端口），原端口上已建立的连接继续转发直到结束。
+// 新端口在服务器回复 ASSIGNED 后才生效；服务器拒绝（ERROR）时客户端与服务器都保持原端口。未连接时在下次连接时生效
+func (c *Client) SetRemotePort(port int) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("无效的远程端口: %d", port)
 	}
+
+	c.controlMu.RLock()
+	connected := c.controlConn != nil
+	c.controlMu.RUnlock()
+	if !connected {
+		c.remotePortMu.Lock()
+		c.remotePort = port
+		c.remotePortMu.Unlock()
+		return nil
+	}
+	if port == c.targetRemotePort() {
+		return nil
+	}
+	// 远程端口被修改，不再重试重连时被拒绝的端口
+	c.remotePortMu.Lock()
+	c.retryPort = 0
+	c.remotePortMu.Unlock()
+	return c.writeInit(port)
 }
 
-// sendInitConfig 发送初始化配置帧
+// targetRemotePort 返回最后请求的远程端口：有未确认的 INIT 时为最后发送的 INIT 中的端口，否则为当前生效的端口
+func (c *Client) targetRemotePort() int {
+	c.remotePortMu.Lock()
+	defer c.remotePortMu.Unlock()
+	if n := len(c.pendingPorts); n > 0 {
+		return c.pendingPorts[n-1]
+	}
+	return c.remotePort
+}
+
+// sendInitConfig 发送初始化配置帧（未指定远程端口、主机名和权重且不租用端口时不发送）
 func (c *Client) sendInitConfig() error {
-	if c.remotePort <= 0 {
+	remotePort := c.currentRemotePort()
+	if remotePort <= 0 && len(c.hostnames) == 0 && c.weight == 0 && !c.leaseRemotePort {
 		return nil
 	}
+	return c.writeInit(remotePort)
+}
 
+// writeInit 发送远程端口为 remotePort 的 INIT 帧，并记录为等待服务器确认
+// 持有 remotePortMu 写入，保证 pendingPorts 的顺序与帧在控制连接上的顺序一致
+func (c *Client) writeInit(remotePort int) error {
 	c.controlMu.RLock()
 	controlConn := c.controlConn
 	c.controlMu.RUnlock()
@@ -388,8 +1286,10 @@ func (c *Client) sendInitConfig() error {
 	}
 
 	config := &proto.InitConfig{
-		RemotePort: c.remotePort,
+		RemotePort: remotePort,
 		LocalAddr:  c.localAddr,
+		Hostnames:  c.hostnames,
+		Weight:     c.weight,
 	}
 
 	configData := proto.EncodeInitConfig(config)
@@ -399,29 +1299,55 @@ func (c *Client) sendInitConfig() error {
 		Payload: configData,
 	}
 
-	frameData, err := proto.EncodeFrame(frame)
-	if err != nil {
-		return fmt.Errorf("编码 INIT 帧失败: %v", err)
+	c.remotePortMu.Lock()
+	defer c.remotePortMu.Unlock()
+	if err := writeFrame(controlConn, &c.controlWriteMu, frame, c.controlWriteTimeout); err != nil {
+		return fmt.Errorf("发送 INIT 帧失败: %w", err)
 	}
+	c.pendingPorts = append(c.pendingPorts, remotePort)
 
-	if _, err := controlConn.Write(frameData); err != nil {
-		return fmt.Errorf("发送 INIT 帧失败: %v", err)
+	log.Printf("已发送初始化配置: 远程端口=%d, 本地地址=%s, 主机名=%s", remotePort, c.localAddr, strings.Join(c.hostnames, ","))
+	return nil
+}
+
+// ackInit 处理服务器对最早一个未确认 INIT 的回复：accepted 为 true（ASSIGNED）时该 INIT 的远程端口生效
+// 返回该 INIT 的远程端口，没有未确认的 INIT 时 ok 为 false
+func (c *Client) ackInit(accepted bool) (port int, ok bool) {
+	c.remotePortMu.Lock()
+	defer c.remotePortMu.Unlock()
+	if len(c.pendingPorts) == 0 {
+		return 0, false
+	}
+	port = c.pendingPorts[0]
+	c.pendingPorts = c.pendingPorts[1:]
+	if accepted {
+		c.remotePort = port
+		if port == c.retryPort {
+			c.retryPort = 0
+		}
 	}
+	return port, true
+}
 
-	log.Printf("已发送初始化配置: 远程端口=%d, 本地地址=%s", c.remotePort, c.localAddr)
-	return nil
+// currentRetryPort 返回正在重试的远程端口（0 表示没有），见 portretry.go
+func (c *Client) currentRetryPort() int {
+	c.remotePortMu.Lock()
+	defer c.remotePortMu.Unlock()
+	return c.retryPort
 }
 
 // cleanup 清理所有资源
 func (c *Client) cleanup() {
-	// 关闭控制连接
+	// 通知服务器正常退出后关闭控制连接
+	c.sendBye()
 	c.closeControlConn()
 
 	// 关闭所有本地连接
 	c.connMap.Range(func(key, value interface{}) bool {
-		if conn, ok := value.(net.Conn); ok {
-			conn.Close()
-		}
+		tc := value.(*trackedConn)
+		// 控制连接已关闭，转发 goroutine 随后读到的错误不再触发 CLOSE_CONN
+		tc.markClosed()
+		tc.Close()
 		c.connMap.Delete(key)
 		return true
 	})
@@ -0,0 +1,627 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/pqctls"
+	"reverse-tunnel/internal/proto"
+)
+
+// TestDialLocalWithTCPFastOpen 测试启用 TCP Fast Open 后本地拨号仍然正常
+func TestDialLocalWithTCPFastOpen(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	for _, tfo := range []bool{false, true} {
+		client := NewClient("127.0.0.1:0", localAddr, 0, WithLocalTCPFastOpen(tfo))
+		conn, err := client.dialLocal(localAddr)
+		if err != nil {
+			t.Fatalf("拨号本地服务失败 (tfo=%v): %v", tfo, err)
+		}
+
+		msg := "fast open"
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			conn.Close()
+			t.Fatalf("写入失败 (tfo=%v): %v", tfo, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, response); err != nil {
+			conn.Close()
+			t.Fatalf("读取响应失败 (tfo=%v): %v", tfo, err)
+		}
+		conn.Close()
+
+		if string(response) != msg {
+			t.Errorf("响应不匹配 (tfo=%v): 期望 %q, 得到 %q", tfo, msg, string(response))
+		}
+	}
+}
+
+// TestSelectLocalAddr 测试按来源 IP 选择本地服务地址
+func TestSelectLocalAddr(t *testing.T) {
+	var routes []LocalRoute
+	for _, r := range [][2]string{
+		{"10.1.0.0/16", "127.0.0.1:8001"},
+		{"10.0.0.0/8", "127.0.0.1:8002"},
+		{"2001:db8::/32", "127.0.0.1:8003"},
+	} {
+		route, err := ParseLocalRoute(r[0], r[1])
+		if err != nil {
+			t.Fatalf("解析路由失败: %v", err)
+		}
+		routes = append(routes, route)
+	}
+	client := NewClient("127.0.0.1:0", "127.0.0.1:8000", 0, WithLocalRoutes(routes))
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"10.1.2.3:5555", "127.0.0.1:8001"},
+		{"10.2.2.3:5555", "127.0.0.1:8002"},
+		{"[::ffff:10.2.2.3]:5555", "127.0.0.1:8002"},
+		{"[2001:db8::1]:5555", "127.0.0.1:8003"},
+		{"192.168.1.1:5555", "127.0.0.1:8000"},
+		{"", "127.0.0.1:8000"},
+	}
+	for _, tt := range tests {
+		if got := client.selectLocalAddr(tt.src, ""); got != tt.want {
+			t.Errorf("selectLocalAddr(%q) = %q, 期望 %q", tt.src, got, tt.want)
+		}
+	}
+
+	if _, err := ParseLocalRoute("not-a-cidr", "127.0.0.1:80"); err == nil {
+		t.Error("无效 CIDR 应返回错误")
+	}
+}
+
+// TestLocalResolver 测试每个 NEW_CONN 都交给解析器解析本地地址：解析结果变化后新连接使用新地址，解析失败时连接被关闭
+func TestLocalResolver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	router := &memRouter{listeners: make(map[string]*memListener)}
+	for _, name := range []string{"backend-a", "backend-b"} {
+		l := newMemListener(name)
+		defer l.Close()
+		go serveMemEcho(l)
+		router.set(name, l)
+	}
+	var target atomic.Value
+	target.Store("backend-a")
+	requests := make(chan LocalResolveRequest, 8)
+	resolver := LocalResolverFunc(func(ctx context.Context, req LocalResolveRequest) (string, error) {
+		requests <- req
+		addr := target.Load().(string)
+		if addr == "unavailable" {
+			return "", fmt.Errorf("服务 %s 没有可用的实例", req.Local)
+		}
+		return addr, nil
+	})
+	public, server, _ := startFaultTunnel(ctx, t, nil, WithLocalDialer(router), WithLocalResolver(resolver))
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	if err := echoRoundTrip(ctx, public, []byte("ping")); err != nil {
+		t.Fatalf("解析到 backend-a 后转发失败: %v", err)
+	}
+	if req := <-requests; req.Local != "local" || req.Service != "" || req.TraceID == "" || req.TraceID == "-" {
+		t.Errorf("解析请求应包含静态地址和追踪 ID: %+v", req)
+	}
+
+	// 服务发现的结果变化：新连接连接新地址，无需重新配置隧道
+	target.Store("backend-b")
+	router.mu.Lock()
+	delete(router.listeners, "backend-a")
+	router.mu.Unlock()
+	if err := echoRoundTrip(ctx, public, []byte("pong")); err != nil {
+		t.Fatalf("解析到 backend-b 后转发失败: %v", err)
+	}
+	<-requests
+
+	target.Store("unavailable")
+	if err := echoRoundTrip(ctx, public, []byte("ping")); err == nil {
+		t.Errorf("解析失败时公开连接应被关闭")
+	}
+	<-requests
+}
+
+// TestCheckLocal 测试本地服务检查覆盖全部后端和路由地址（重复的只检查一次），并报告不可达的地址
+func TestCheckLocal(t *testing.T) {
+	upAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	up := startEchoServer(t, upAddr)
+	defer up.Close()
+	downAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	route, err := ParseLocalRoute("10.0.0.0/8", downAddr+","+upAddr)
+	if err != nil {
+		t.Fatalf("解析路由失败: %v", err)
+	}
+	client := NewClient("127.0.0.1:0", upAddr+", "+downAddr, 0, WithLocalRoutes([]LocalRoute{route}))
+
+	results := client.CheckLocal()
+	if len(results) != 2 {
+		t.Fatalf("期望检查 2 个地址，得到 %+v", results)
+	}
+	if results[0].Addr != upAddr || results[0].Err != nil || results[0].Latency <= 0 {
+		t.Errorf("%s 应可达，得到 %+v", upAddr, results[0])
+	}
+	if results[1].Addr != downAddr || results[1].Err == nil {
+		t.Errorf("%s 应不可达，得到 %+v", downAddr, results[1])
+	}
+}
+
+// TestDialLocalWithTLS 测试启用本地 TLS 后客户端以 TLS 连接本地服务
+func TestDialLocalWithTLS(t *testing.T) {
+	certPEM, keyPEM := generateTestCert(t)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("加载测试证书失败: %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("启动 TLS 本地服务失败: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatalf("写入 CA 文件失败: %v", err)
+	}
+	tlsConfig, err := NewLocalTLSConfig("", "", caFile, "")
+	if err != nil {
+		t.Fatalf("创建本地 TLS 配置失败: %v", err)
+	}
+
+	localAddr := listener.Addr().String()
+	client := NewClient("127.0.0.1:0", localAddr, 0, WithLocalTLS(tlsConfig))
+	conn, err := client.dialLocal(localAddr)
+	if err != nil {
+		t.Fatalf("TLS 拨号本地服务失败: %v", err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("本地连接应为 *tls.Conn，得到 %T", conn)
+	}
+	msg := "local tls"
+	conn.Write([]byte(msg))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	if string(response) != msg {
+		t.Errorf("响应不匹配: 期望 %q, 得到 %q", msg, string(response))
+	}
+
+	if _, err := NewLocalTLSConfig(caFile, "", "", ""); err == nil {
+		t.Error("只指定证书不指定私钥应返回错误")
+	}
+}
+
+// generateTestCert 生成 127.0.0.1 的自签名测试证书（PEM 格式）
+func generateTestCert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("编码私钥失败: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+// startCountingEchoServer 启动一个记录已接受连接数的 echo 服务器
+func startCountingEchoServer(t *testing.T, accepted *int64) (net.Listener, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动 echo 服务器失败: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(accepted, 1)
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+	return listener, listener.Addr().String()
+}
+
+// TestLocalBackendPool 测试多个本地后端的轮询分发和被动健康检查
+func TestLocalBackendPool(t *testing.T) {
+	var countA, countB int64
+	backendA, addrA := startCountingEchoServer(t, &countA)
+	defer backendA.Close()
+	backendB, addrB := startCountingEchoServer(t, &countB)
+	defer backendB.Close()
+	deadAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, publicAddr)
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// 一个后端不可达：启用被动健康检查后所有连接仍然成功，且流量分布到两个可用后端
+	local := addrA + "," + deadAddr + "," + addrB
+	client := NewClient(controlAddr, local, 0, WithLocalBalance(BalanceRoundRobin, time.Minute))
+	go client.Run(ctx)
+	time.Sleep(300 * time.Millisecond)
+
+	const total = 6
+	for i := 0; i < total; i++ {
+		conn, err := net.Dial("tcp", publicAddr)
+		if err != nil {
+			t.Fatalf("连接公开端口失败: %v", err)
+		}
+		msg := fmt.Sprintf("request-%d", i)
+		conn.Write([]byte(msg))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response := make([]byte, len(msg))
+		_, err = io.ReadFull(conn, response)
+		conn.Close()
+		if err != nil || string(response) != msg {
+			t.Fatalf("第 %d 个连接转发失败: %v", i, err)
+		}
+	}
+
+	a, b := atomic.LoadInt64(&countA), atomic.LoadInt64(&countB)
+	if a+b != total || a == 0 || b == 0 {
+		t.Errorf("连接应分布到两个可用后端，得到 A=%d B=%d", a, b)
+	}
+}
+
+// TestLocalDialSource 测试本地拨号使用指定的源 IP
+func TestLocalDialSource(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+
+	remoteIP := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		remoteIP <- conn.RemoteAddr().(*net.TCPAddr).IP.String()
+		conn.Close()
+	}()
+
+	client := NewClient("127.0.0.1:0", listener.Addr().String(), 0, WithLocalDialSource(net.ParseIP("127.0.0.2")))
+	conn, err := client.dialLocal(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("拨号本地服务失败: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case ip := <-remoteIP:
+		if ip != "127.0.0.2" {
+			t.Errorf("本地连接源地址应为 127.0.0.2，得到 %s", ip)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("本地服务未收到连接")
+	}
+}
+
+// TestLocalConnPoolReuse 测试启用复用后公开连接关闭时本地连接放回池中，后续连接不再新建本地连接
+func TestLocalConnPoolReuse(t *testing.T) {
+	var accepted int64
+	backend, localAddr := startCountingEchoServer(t, &accepted)
+	defer backend.Close()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, publicAddr)
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, 0, WithLocalPool(1, true))
+	go client.Run(ctx)
+	time.Sleep(300 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", publicAddr)
+		if err != nil {
+			t.Fatalf("连接公开端口失败: %v", err)
+		}
+		msg := fmt.Sprintf("pooled-%d", i)
+		conn.Write([]byte(msg))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response := make([]byte, len(msg))
+		_, err = io.ReadFull(conn, response)
+		conn.Close()
+		if err != nil || string(response) != msg {
+			t.Fatalf("第 %d 个连接转发失败: %v", i, err)
+		}
+		// 等待 CLOSE_CONN 到达客户端并放回连接池
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	if n := atomic.LoadInt64(&accepted); n != 1 {
+		t.Errorf("复用连接池时本地服务应只收到 1 个连接，得到 %d", n)
+	}
+}
+
+// TestDataKeepalive 测试协商了 FeatureDataKeepalive 时空闲的数据连接定期发送零长度 DATA 帧，有数据往来的连接不发送
+func TestDataKeepalive(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	// 模拟服务器：接受控制连接后请求建立一个连接，然后统计收到的帧
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const interval = 100 * time.Millisecond
+	go NewClient(listener.Addr().String(), localAddr, 0, WithDataKeepalive(interval)).Run(ctx)
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("接受控制连接失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if frame, err := proto.DecodeFrame(conn); err != nil || frame.Type != proto.FrameTypeHELLO {
+		t.Fatalf("期望 HELLO 帧，得到 %+v, %v", frame, err)
+	}
+	hello := &proto.Hello{Features: proto.FeatureDataKeepalive}
+	if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(hello)}, time.Second); err != nil {
+		t.Fatalf("发送 HELLO 失败: %v", err)
+	}
+	if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeNEW_CONN, ConnID: 1}, time.Second); err != nil {
+		t.Fatalf("发送 NEW_CONN 失败: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * interval))
+	keepalives := 0
+	for keepalives < 3 {
+		frame, err := proto.DecodeFrame(conn)
+		if err != nil {
+			t.Fatalf("空闲连接在 %v 内只收到 %d 个保活帧: %v", 10*interval, keepalives, err)
+		}
+		if frame.Type != proto.FrameTypeDATA || frame.ConnID != 1 || len(frame.Payload) != 0 {
+			t.Fatalf("收到意外的帧: type=%v connID=%d len=%d", frame.Type, frame.ConnID, len(frame.Payload))
+		}
+		keepalives++
+	}
+
+	// 持续有数据往来时不发送保活帧：每个帧都是回显的数据
+	msg := []byte("ping")
+	for i := 0; i < 5; i++ {
+		if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeDATA, ConnID: 1, Payload: msg}, time.Second); err != nil {
+			t.Fatalf("发送 DATA 失败: %v", err)
+		}
+		for {
+			frame, err := proto.DecodeFrame(conn)
+			if err != nil {
+				t.Fatalf("读取回显失败: %v", err)
+			}
+			if len(frame.Payload) > 0 {
+				break
+			}
+			// 数据到达前已发出的保活帧
+			if i > 0 {
+				t.Fatalf("有数据往来的连接不应发送保活帧")
+			}
+		}
+		time.Sleep(interval / 4)
+	}
+}
+
+// memRouter 按地址把拨号转发到对应的内存监听器，未登记的地址拨号失败
+type memRouter struct {
+	mu        sync.Mutex
+	listeners map[string]*memListener
+}
+
+func (r *memRouter) set(addr string, l *memListener) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners[addr] = l
+}
+
+func (r *memRouter) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	r.mu.Lock()
+	l, ok := r.listeners[address]
+	r.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dial %s: connection refused", address)
+	}
+	return l.DialContext(ctx, network, address)
+}
+
+// TestClientServerFailover 测试配置多个服务器时跳过不可达的服务器，并在重建控制连接时优先使用最近一次连接成功的服务器
+func TestClientServerFailover(t *testing.T) {
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// secondary 的控制连接很快达到最大存活时间，客户端随即重建控制连接
+	secondaryControl := newMemListener("secondary")
+	secondary := NewServer("", "", WithServerControlListener(secondaryControl), WithServerPublicListener(newMemListener("public2")),
+		WithServerMaxControlConnLifetime(300*time.Millisecond))
+	go secondary.Run(ctx)
+
+	router := &memRouter{listeners: map[string]*memListener{"secondary": secondaryControl}}
+	client := NewClient("primary, secondary", "local", 0, WithControlDialer(router), WithLocalDialer(local))
+	go client.Run(ctx)
+
+	waitClients := func(s *Server, what string) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for len(s.ClientStatus()) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("客户端未能注册到 %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitClients(secondary, "secondary")
+
+	// primary 恢复后，客户端重建控制连接时仍连接最近一次连接成功的 secondary
+	primaryControl := newMemListener("primary")
+	primary := NewServer("", "", WithServerControlListener(primaryControl), WithServerPublicListener(newMemListener("public1")))
+	go primary.Run(ctx)
+	router.set("primary", primaryControl)
+
+	firstConnected := secondary.ClientStatus()[0].ConnectedAt
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		status := secondary.ClientStatus()
+		if len(status) > 0 && status[0].ConnectedAt.After(firstConnected) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未重建控制连接")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(primary.ClientStatus()); n != 0 {
+		t.Errorf("重建控制连接时应优先连接最近一次连接成功的服务器, primary 上有 %d 个客户端", n)
+	}
+}
+
+// TestFrameBufferBackpressure 测试主循环处理帧阻塞时，客户端只读取 frame_buffer 个等待处理的帧，之后停止读取控制连接
+func TestFrameBufferBackpressure(t *testing.T) {
+	control := newMemListener("control")
+	local := newMemListener("local")
+	defer local.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const buffer = 3
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local), WithFrameBuffer(buffer)).Run(ctx)
+
+	conn, err := control.Accept()
+	if err != nil {
+		t.Fatalf("接受控制连接失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if frame, err := proto.DecodeFrame(conn); err != nil || frame.Type != proto.FrameTypeHELLO {
+		t.Fatalf("期望 HELLO 帧，得到 %+v, %v", frame, err)
+	}
+	if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(&proto.Hello{})}, time.Second); err != nil {
+		t.Fatalf("发送 HELLO 失败: %v", err)
+	}
+	if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeNEW_CONN, ConnID: 1}, time.Second); err != nil {
+		t.Fatalf("发送 NEW_CONN 失败: %v", err)
+	}
+	// 本地连接不读取数据：主循环写入第一个 DATA 帧时阻塞
+	localConn, err := local.Accept()
+	if err != nil {
+		t.Fatalf("接受本地连接失败: %v", err)
+	}
+	defer localConn.Close()
+
+	// 主循环处理中的 1 个帧 + 缓冲的 buffer 个帧 + 读取 goroutine 已读出、等待放入缓冲的 1 个帧
+	sent := 0
+	for ; sent < buffer+10; sent++ {
+		frame := &proto.Frame{Type: proto.FrameTypeDATA, ConnID: 1, Payload: []byte("data")}
+		if err := writeFrame(conn, nil, frame, 200*time.Millisecond); err != nil {
+			break
+		}
+	}
+	if sent != buffer+2 {
+		t.Errorf("处理阻塞时客户端读取了 %d 个帧，期望 %d", sent, buffer+2)
+	}
+}
+
+// TestClientCertCheckFailsFast 测试证书文件缺失时 Run 立即返回错误，而不是进入重连循环
+func TestClientCertCheckFailsFast(t *testing.T) {
+	dir := t.TempDir()
+	client := NewClientWithTLS("127.0.0.1:1", "127.0.0.1:2", 0, filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.crt"), "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- client.Run(ctx) }()
+	select {
+	case err := <-done:
+		if err == nil || ctx.Err() != nil {
+			t.Fatalf("证书缺失时 Run 应立即返回错误, 得到 %v", err)
+		}
+		if pqctls.Ready() == nil && !strings.Contains(err.Error(), "not found") {
+			t.Errorf("错误应指出证书文件不存在: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("证书缺失时 Run 不应进入重连循环")
+	}
+}
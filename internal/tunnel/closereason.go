@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 公开连接关闭的归类（访问日志的 close_category 和指标标签 reason）：
+// close_reason 记录由哪一方、哪条路径关闭，归类结合客户端 CLOSE_CONN 的原因给出排查问题时关心的结论
+const (
+	closeCategoryClientEOF   = "client-eof"          // 外部访问者正常关闭连接
+	closeCategoryBackendEOF  = "backend-eof"         // 本地服务正常关闭连接
+	closeCategoryDialFailed  = "backend-dial-failed" // 客户端连接本地服务失败（或本地服务熔断中），没有转发任何数据
+	closeCategoryIdle        = "idle-timeout"        // 两个方向都没有数据超过空闲超时
+	closeCategorySetup       = "setup-timeout"       // 客户端未在建立超时内建立本地连接
+	closeCategoryRateLimited = "rate-limited"        // 超出传输字节配额或连接最长存活时间
+	closeCategoryShutdown    = "shutdown"            // 服务器或客户端正常关闭、管理接口强制关闭
+	closeCategoryError       = "error"               // 读写错误、连接被重置、客户端控制连接断开
+)
+
+// closeCategories 指标中始终输出的归类（保证时间序列稳定）
+var closeCategories = []string{
+	closeCategoryClientEOF,
+	closeCategoryBackendEOF,
+	closeCategoryDialFailed,
+	closeCategoryIdle,
+	closeCategorySetup,
+	closeCategoryRateLimited,
+	closeCategoryShutdown,
+	closeCategoryError,
+}
+
+// closeCategory 在连接的唯一清理点按关闭原因 reason（closeReason*）归类；
+// 客户端发送 CLOSE_CONN 关闭的连接按其携带的原因归类，以错误关闭且没有向外部连接写出任何数据时视为连接本地服务失败
+func closeCategory(reason string, c *trackedConn) string {
+	switch reason {
+	case closeReasonEOF:
+		return closeCategoryClientEOF
+	case closeReasonClientClose:
+		switch c.peerReason() {
+		case proto.CloseGraceful.String():
+			return closeCategoryBackendEOF
+		case proto.CloseIdle.String():
+			return closeCategoryIdle
+		case proto.CloseShutdown.String():
+			return closeCategoryShutdown
+		case proto.CloseQuota.String():
+			return closeCategoryRateLimited
+		case proto.CloseUnavailable.String():
+			return closeCategoryDialFailed
+		case proto.CloseError.String():
+			if c.closedBeforeData.Load() {
+				return closeCategoryDialFailed
+			}
+		}
+		return closeCategoryError
+	case closeReasonIdle:
+		return closeCategoryIdle
+	case closeReasonSetup:
+		return closeCategorySetup
+	case closeReasonQuota, closeReasonLifetime:
+		return closeCategoryRateLimited
+	case closeReasonShutdown, closeReasonClientExit, closeReasonAdmin:
+		return closeCategoryShutdown
+	}
+	return closeCategoryError
+}
+
+// closeCategoryStats 按归类统计关闭的公开连接数
+type closeCategoryStats struct {
+	counts [8]atomic.Uint64 // 与 closeCategories 的顺序一致
+}
+
+// inc 计数一次关闭
+func (s *closeCategoryStats) inc(category string) {
+	for i, c := range closeCategories {
+		if c == category {
+			s.counts[i].Add(1)
+			return
+		}
+	}
+}
+
+// writeMetrics 以 Prometheus 文本格式写入按归类的关闭连接数
+func (s *closeCategoryStats) writeMetrics(buf *bytes.Buffer) {
+	buf.WriteString("# HELP reverse_tunnel_public_conns_closed_total Public connections closed, by categorized close reason.\n")
+	buf.WriteString("# TYPE reverse_tunnel_public_conns_closed_total counter\n")
+	for i, category := range closeCategories {
+		fmt.Fprintf(buf, "reverse_tunnel_public_conns_closed_total{reason=%q} %d\n", category, s.counts[i].Load())
+	}
+}
@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestCloseCategory 测试按关闭原因和客户端 CLOSE_CONN 携带的原因归类，客户端在转发任何数据之前以错误关闭视为连接本地服务失败
+func TestCloseCategory(t *testing.T) {
+	peerClosed := func(reason proto.CloseReason, beforeData bool) *trackedConn {
+		c := newTrackedConn(nil, "-")
+		c.peerCloseReason.Store(reason.String())
+		c.closedBeforeData.Store(beforeData)
+		return c
+	}
+	cases := []struct {
+		reason string
+		conn   *trackedConn
+		want   string
+	}{
+		{closeReasonEOF, newTrackedConn(nil, "-"), closeCategoryClientEOF},
+		{closeReasonClientClose, peerClosed(proto.CloseGraceful, false), closeCategoryBackendEOF},
+		{closeReasonClientClose, peerClosed(proto.CloseError, true), closeCategoryDialFailed},
+		{closeReasonClientClose, peerClosed(proto.CloseError, false), closeCategoryError},
+		{closeReasonClientClose, peerClosed(proto.CloseUnavailable, true), closeCategoryDialFailed},
+		{closeReasonClientClose, peerClosed(proto.CloseIdle, false), closeCategoryIdle},
+		{closeReasonClientClose, peerClosed(proto.CloseReset, false), closeCategoryError},
+		{closeReasonIdle, newTrackedConn(nil, "-"), closeCategoryIdle},
+		{closeReasonSetup, newTrackedConn(nil, "-"), closeCategorySetup},
+		{closeReasonLifetime, newTrackedConn(nil, "-"), closeCategoryRateLimited},
+		{closeReasonShutdown, newTrackedConn(nil, "-"), closeCategoryShutdown},
+		{closeReasonClientGone, newTrackedConn(nil, "-"), closeCategoryError},
+		{closeReasonReset, newTrackedConn(nil, "-"), closeCategoryError},
+	}
+	for _, tc := range cases {
+		if got := closeCategory(tc.reason, tc.conn); got != tc.want {
+			t.Errorf("%s (客户端原因 %q): 期望归类 %q, 得到 %q", tc.reason, tc.conn.peerReason(), tc.want, got)
+		}
+	}
+
+	var stats closeCategoryStats
+	stats.inc(closeCategoryDialFailed)
+	stats.inc(closeCategoryDialFailed)
+	var buf bytes.Buffer
+	stats.writeMetrics(&buf)
+	for _, line := range []string{
+		`reverse_tunnel_public_conns_closed_total{reason="backend-dial-failed"} 2`,
+		`reverse_tunnel_public_conns_closed_total{reason="client-eof"} 0`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("指标缺少 %q:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// compressMinLength 声明了 Content-Length 的响应至少达到该长度才压缩（更短的响应压缩后几乎不会变小）
+const compressMinLength = 256
+
+// compressibleTypes 压缩的响应 Content-Type（媒体类型，不含参数）；以 / 结尾的表示该大类下的所有子类型，
+// 另外 +json、+xml 结尾的结构化语法后缀同样压缩。图片、音视频、压缩包等已压缩的格式不在其中
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/ecmascript",
+	"application/xml",
+	"application/wasm",
+	"application/x-ndjson",
+	"application/graphql-response+json",
+	"image/svg+xml",
+	"image/x-icon",
+	"font/ttf",
+	"font/otf",
+}
+
+// compressibleType 判断 Content-Type 是否属于可压缩的媒体类型
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	for _, t := range compressibleTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip 判断 Accept-Encoding 是否接受 gzip（gzip、x-gzip 或 *，q 不为 0）
+// 显式以 q=0 拒绝 gzip 时即使列出了 * 也不接受
+func acceptsGzip(values []string) bool {
+	accepted, star := false, false
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			ok := true
+			if name, q, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.EqualFold(strings.TrimSpace(name), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(q), 64); err == nil && f == 0 {
+					ok = false
+				}
+			}
+			switch coding {
+			case "gzip", "x-gzip":
+				if !ok {
+					return false
+				}
+				accepted = true
+			case "*":
+				star = ok
+			}
+		}
+	}
+	return accepted || star
+}
+
+// httpHead 解析后的 HTTP/1.x 消息头
+type httpHead struct {
+	first string   // 请求行或状态行
+	lines []string // 头部字段行（不含结尾的空行）
+}
+
+// parseHTTPHead 解析以 \r\n\r\n 结尾的消息头
+func parseHTTPHead(raw []byte) httpHead {
+	lines := strings.Split(string(raw[:len(raw)-4]), "\r\n")
+	return httpHead{first: lines[0], lines: lines[1:]}
+}
+
+// values 返回名为 name 的头部字段的所有值（名称不区分大小写）
+func (h httpHead) values(name string) []string {
+	var vs []string
+	for _, line := range h.lines {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			vs = append(vs, strings.TrimSpace(v))
+		}
+	}
+	return vs
+}
+
+// has 判断名为 name 的头部字段是否包含 token（逗号分隔的列表，不区分大小写）
+func (h httpHead) has(name, token string) bool {
+	for _, v := range h.values(name) {
+		for _, item := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// framing 返回消息体的分帧方式：分块编码、Content-Length（-1 表示未声明），Content-Length 不合法时 ok 为 false
+func (h httpHead) framing() (chunked bool, length int64, ok bool) {
+	if h.has("Transfer-Encoding", "chunked") {
+		return true, -1, true
+	}
+	vs := h.values("Content-Length")
+	if len(vs) == 0 {
+		return false, -1, true
+	}
+	n, err := strconv.ParseInt(vs[0], 10, 64)
+	return false, n, err == nil && n >= 0
+}
+
+// 消息分帧器的状态
+const (
+	framerHead      = iota // 读取消息头
+	framerLength           // 读取 Content-Length 分帧的消息体
+	framerChunkSize        // 读取分块大小行
+	framerChunkData        // 读取分块数据
+	framerChunkCRLF        // 读取分块数据结尾的 CRLF
+	framerTrailer          // 读取尾部字段，直到空行
+	framerClose            // 消息体持续到连接关闭
+	framerEnd              // 消息已结束，下一次 next 返回 httpEnd
+	framerPass             // 无法解析或协议已切换，之后的数据原样透传
+)
+
+// httpFramer 产生的事件
+const (
+	httpNeedMore  = iota // 数据已全部缓存，等待更多数据
+	httpHeadDone         // data 为完整的消息头（调用方随后调用 body 设置消息体的分帧方式）
+	httpBody             // data 为消息体数据（已去掉分块编码）
+	httpFraming          // data 为分块编码的原始字节（分块大小行、分块结尾的 CRLF、尾部字段）
+	httpLastChunk        // data 为最后一个分块（大小为 0）的大小行的原始字节
+	httpEnd              // 消息结束，data 为结尾空行的原始字节（可能为空）
+	httpRaw              // data 为透传的原始字节
+)
+
+// maxChunkLine 分块大小行和尾部字段行的长度上限
+const maxChunkLine = 4096
+
+// httpFramer 按 HTTP/1.x 的消息分帧增量解析字节流：调用方反复以剩余数据调用 next，
+// 直到数据用完且没有待返回的 httpEnd（pending 为 false）。消息头最多缓存 hostPeekMaxSize 字节，
+// 超过或格式不合法时进入透传状态，之后的数据都以 httpRaw 返回
+type httpFramer struct {
+	state     int
+	remaining int64
+	buf       []byte // 未完成的消息头
+	line      []byte // 未完成的分块大小行或尾部字段行
+}
+
+// pending 判断是否还有待返回的消息结束事件
+func (f *httpFramer) pending() bool {
+	return f.state == framerEnd
+}
+
+// pass 进入透传状态
+func (f *httpFramer) pass() {
+	f.state = framerPass
+}
+
+// body 在 httpHeadDone 之后设置消息体的分帧方式：none 表示没有消息体，否则按分块编码、Content-Length 或持续到连接关闭
+func (f *httpFramer) body(none, chunked bool, length int64) {
+	switch {
+	case none || length == 0:
+		f.state = framerEnd
+	case chunked:
+		f.state = framerChunkSize
+	case length > 0:
+		f.state, f.remaining = framerLength, length
+	default:
+		f.state = framerClose
+	}
+}
+
+// next 解析 b 开头的一段数据，返回事件、该事件的数据和剩余数据
+func (f *httpFramer) next(b []byte) (int, []byte, []byte) {
+	switch f.state {
+	case framerPass:
+		return httpRaw, b, nil
+	case framerEnd:
+		f.state = framerHead
+		return httpEnd, nil, b
+	case framerClose:
+		return httpBody, b, nil
+	case framerHead:
+		f.buf = append(f.buf, b...)
+		end := bytes.Index(f.buf, []byte("\r\n\r\n"))
+		if end < 0 {
+			if len(f.buf) > hostPeekMaxSize {
+				data := f.buf
+				f.buf = nil
+				f.pass()
+				return httpRaw, data, nil
+			}
+			return httpNeedMore, nil, nil
+		}
+		head, rest := f.buf[:end+4], f.buf[end+4:]
+		f.buf = nil
+		return httpHeadDone, head, rest
+	case framerLength, framerChunkData, framerChunkCRLF:
+		n := int64(len(b))
+		if n > f.remaining {
+			n = f.remaining
+		}
+		f.remaining -= n
+		ev := httpBody
+		if f.state == framerChunkCRLF {
+			ev = httpFraming
+		}
+		if f.remaining == 0 {
+			switch f.state {
+			case framerLength:
+				f.state = framerEnd
+			case framerChunkData:
+				f.state, f.remaining = framerChunkCRLF, 2
+			default:
+				f.state = framerChunkSize
+			}
+		}
+		return ev, b[:n], b[n:]
+	}
+
+	// 分块大小行或尾部字段行：完整读到行尾之前原始字节以 httpFraming 返回
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		f.line = append(f.line, b...)
+		if len(f.line) > maxChunkLine {
+			f.pass()
+		}
+		return httpFraming, b, nil
+	}
+	line := string(append(f.line, b[:i+1]...))
+	f.line = f.line[:0]
+	data, rest := b[:i+1], b[i+1:]
+	if f.state == framerTrailer {
+		if strings.TrimRight(line, "\r\n") == "" {
+			f.state = framerHead
+			return httpEnd, data, rest
+		}
+		return httpFraming, data, rest
+	}
+	sizeField, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	switch {
+	case err != nil || size < 0:
+		f.pass()
+		return httpRaw, data, rest
+	case size == 0:
+		f.state = framerTrailer
+		return httpLastChunk, data, rest
+	}
+	f.state, f.remaining = framerChunkData, size
+	return httpFraming, data, rest
+}
+
+// compressRequest 公开连接上一个等待响应的请求
+type compressRequest struct {
+	gzip    bool // Accept-Encoding 接受 gzip
+	head    bool // HEAD 请求（响应没有消息体）
+	connect bool // CONNECT 请求（2xx 响应之后隧道化）
+}
+
+// compressConn 为 HTTP/1.x 公开连接按内容类型压缩响应：读取方向解析请求（不修改数据），按顺序记录每个请求是否接受 gzip；
+// 写入方向解析后端的响应，请求接受 gzip、Content-Type 可压缩、没有 Content-Encoding 的 HTTP/1.1 响应被改写为
+// Content-Encoding: gzip 的分块编码响应，其余响应原样写入。协议切换（101、CONNECT）、无法解析或请求与响应无法对应时
+// 之后的数据原样透传。每次 Write 之后刷新压缩器，流式响应（SSE 等）不会因此滞留在压缩缓冲中
+type compressConn struct {
+	net.Conn
+	compressed *atomic.Uint64 // 压缩的响应数（服务器的计数器）
+
+	mu      sync.Mutex
+	pending []compressRequest // 已读到、尚未写出响应的请求（按顺序）
+
+	req httpFramer // 读取方向，只由读取数据的 goroutine 访问
+
+	resp httpFramer // 写入方向，只由写入数据的 goroutine 访问
+	gz   *gzip.Writer
+	on   bool         // 当前响应正在压缩
+	out  bytes.Buffer // 一次 Write 的输出
+}
+
+// newCompressConn 创建按内容类型压缩响应的连接，compressed 累计压缩的响应数
+func newCompressConn(conn net.Conn, compressed *atomic.Uint64) *compressConn {
+	return &compressConn{Conn: conn, compressed: compressed}
+}
+
+// Read 读取公开连接的数据，并从中解析请求头
+func (c *compressConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	data := b[:n]
+	for len(data) > 0 || c.req.pending() {
+		var ev int
+		var chunk []byte
+		ev, chunk, data = c.req.next(data)
+		if ev == httpHeadDone {
+			c.startRequest(parseHTTPHead(chunk))
+		}
+	}
+	return n, err
+}
+
+// startRequest 记录一个请求并设置其消息体的分帧方式
+func (c *compressConn) startRequest(h httpHead) {
+	method, _, _ := strings.Cut(h.first, " ")
+	req := compressRequest{
+		gzip:    acceptsGzip(h.values("Accept-Encoding")),
+		head:    method == "HEAD",
+		connect: method == "CONNECT",
+	}
+	c.mu.Lock()
+	c.pending = append(c.pending, req)
+	c.mu.Unlock()
+
+	chunked, length, ok := h.framing()
+	if !ok || req.connect || h.has("Connection", "upgrade") {
+		// 隧道化或协议切换之后的数据不再是 HTTP/1.x 请求
+		c.req.pass()
+		return
+	}
+	// 请求没有声明长度时没有消息体
+	c.req.body(!chunked && length < 0, chunked, length)
+}
+
+// popRequest 取出最早的等待响应的请求
+func (c *compressConn) popRequest() (compressRequest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return compressRequest{}, false
+	}
+	req := c.pending[0]
+	c.pending = c.pending[1:]
+	return req, true
+}
+
+// Write 写入后端的响应数据，需要压缩的响应改写后写入；成功时返回 len(b)
+func (c *compressConn) Write(b []byte) (int, error) {
+	c.out.Reset()
+	data := b
+	for len(data) > 0 || c.resp.pending() {
+		var ev int
+		var chunk []byte
+		ev, chunk, data = c.resp.next(data)
+		c.writeEvent(ev, chunk)
+	}
+	if c.on {
+		c.gz.Flush()
+	}
+	if c.out.Len() == 0 {
+		return len(b), nil
+	}
+	if _, err := c.Conn.Write(c.out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeEvent 按响应分帧事件写出数据：压缩时消息体写入压缩器、原分块编码丢弃，否则原样写出
+func (c *compressConn) writeEvent(ev int, data []byte) {
+	switch ev {
+	case httpHeadDone:
+		c.startResponse(data)
+	case httpBody:
+		if c.on {
+			c.gz.Write(data)
+			return
+		}
+		c.out.Write(data)
+	case httpFraming:
+		// 尾部字段在压缩时同样原样写出（位于重新编码的最后一个分块之后）
+		if !c.on || c.resp.state == framerTrailer {
+			c.out.Write(data)
+		}
+	case httpLastChunk:
+		if c.on {
+			c.gz.Close()
+			c.out.WriteString("0\r\n")
+			return
+		}
+		c.out.Write(data)
+	case httpEnd:
+		if c.on {
+			c.on = false
+			if data == nil {
+				// Content-Length 分帧的响应：结束压缩并写出最后一个分块和结尾空行
+				c.gz.Close()
+				c.out.WriteString("0\r\n\r\n")
+				return
+			}
+		}
+		c.out.Write(data)
+	case httpRaw:
+		c.out.Write(data)
+	}
+}
+
+// startResponse 解析响应头，决定是否压缩该响应并设置其消息体的分帧方式
+func (c *compressConn) startResponse(raw []byte) {
+	h := parseHTTPHead(raw)
+	version, rest, _ := strings.Cut(h.first, " ")
+	code, err := strconv.Atoi(strings.SplitN(rest, " ", 2)[0])
+	if !strings.HasPrefix(version, "HTTP/1.") || err != nil {
+		c.out.Write(raw)
+		c.resp.pass()
+		return
+	}
+	if code >= 100 && code < 200 && code != 101 {
+		// 中间响应（100 Continue 等）之后还有该请求的最终响应
+		c.out.Write(raw)
+		c.resp.body(true, false, 0)
+		return
+	}
+	req, ok := c.popRequest()
+	chunked, length, valid := h.framing()
+	if !ok || !valid || code == 101 || (req.connect && code >= 200 && code < 300) {
+		c.out.Write(raw)
+		c.resp.pass()
+		return
+	}
+	none := req.head || code == 204 || code == 304
+	if none || !c.shouldCompress(req, h, version, code, length) {
+		c.out.Write(raw)
+		c.resp.body(none, chunked, length)
+		return
+	}
+
+	c.writeCompressedHead(h)
+	if c.gz == nil {
+		c.gz = gzip.NewWriter(chunkWriter{&c.out})
+	} else {
+		c.gz.Reset(chunkWriter{&c.out})
+	}
+	c.on = true
+	c.compressed.Add(1)
+	c.resp.body(false, chunked, length)
+}
+
+// shouldCompress 判断是否压缩响应：请求接受 gzip，HTTP/1.1（改写为分块编码），以 Content-Length 或分块编码分帧，
+// Content-Type 可压缩，没有 Content-Encoding（或为 identity）、Content-Range 和 Cache-Control: no-transform，
+// 声明的长度不小于 compressMinLength
+func (c *compressConn) shouldCompress(req compressRequest, h httpHead, version string, code int, length int64) bool {
+	if !req.gzip || version != "HTTP/1.1" || code == 206 {
+		return false
+	}
+	if !h.has("Transfer-Encoding", "chunked") && (length < 0 || length < compressMinLength) {
+		return false
+	}
+	if types := h.values("Content-Type"); len(types) == 0 || !compressibleType(types[0]) {
+		return false
+	}
+	for _, enc := range h.values("Content-Encoding") {
+		if !strings.EqualFold(enc, "identity") {
+			return false
+		}
+	}
+	return len(h.values("Content-Range")) == 0 && !h.has("Cache-Control", "no-transform")
+}
+
+// writeCompressedHead 写出压缩后的响应头：去掉 Content-Length、Transfer-Encoding 和 Content-Encoding，
+// 加上 Content-Encoding: gzip 和分块编码，Vary 中加入 Accept-Encoding，强 ETag 改为弱 ETag（压缩后的内容不再逐字节相同）
+func (c *compressConn) writeCompressedHead(h httpHead) {
+	c.out.WriteString(h.first + "\r\n")
+	vary := false
+	for _, line := range h.lines {
+		name, value, _ := strings.Cut(line, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(name, "Content-Length"), strings.EqualFold(name, "Transfer-Encoding"), strings.EqualFold(name, "Content-Encoding"):
+			continue
+		case strings.EqualFold(name, "ETag") && strings.HasPrefix(value, `"`):
+			line = name + ": W/" + value
+		case strings.EqualFold(name, "Vary"):
+			vary = true
+			if !h.has("Vary", "Accept-Encoding") && value != "*" {
+				line = name + ": " + value + ", Accept-Encoding"
+			}
+		}
+		c.out.WriteString(line + "\r\n")
+	}
+	if !vary {
+		c.out.WriteString("Vary: Accept-Encoding\r\n")
+	}
+	c.out.WriteString("Content-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n")
+}
+
+// CloseWrite 转发到底层连接（closeWithReason 依赖，底层不支持时返回错误）
+func (c *compressConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("连接不支持 CloseWrite")
+}
+
+// SetLinger 转发到底层连接（closeWithReason 依赖，底层不支持时返回错误）
+func (c *compressConn) SetLinger(sec int) error {
+	if l, ok := c.Conn.(interface{ SetLinger(sec int) error }); ok {
+		return l.SetLinger(sec)
+	}
+	return errors.New("连接不支持 SetLinger")
+}
+
+// chunkWriter 把每次写入编码为一个 HTTP 分块
+type chunkWriter struct {
+	buf *bytes.Buffer
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	fmt.Fprintf(w.buf, "%x\r\n", len(p))
+	w.buf.Write(p)
+	w.buf.WriteString("\r\n")
+	return len(p), nil
+}
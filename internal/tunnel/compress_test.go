@@ -0,0 +1,186 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// bufConn 从 in 读取、写入 out 的内存连接（用于测试连接包装器）
+type bufConn struct {
+	net.Conn
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func (c *bufConn) Read(b []byte) (int, error)  { return c.in.Read(b) }
+func (c *bufConn) Write(b []byte) (int, error) { return c.out.Write(b) }
+
+// TestCompressibleType 测试按媒体类型判断是否压缩
+func TestCompressibleType(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"text/html; charset=utf-8":  true,
+		"application/json":          true,
+		"application/problem+json":  true,
+		"application/atom+xml":      true,
+		"image/svg+xml":             true,
+		"Application/JavaScript":    true,
+		"image/png":                 false,
+		"video/mp4":                 false,
+		"application/zip":           false,
+		"application/octet-stream":  false,
+		"":                          false,
+		"text":                      false,
+		"application/gzip; level=9": false,
+	} {
+		if got := compressibleType(contentType); got != want {
+			t.Errorf("compressibleType(%q) = %v, 期望 %v", contentType, got, want)
+		}
+	}
+}
+
+// TestAcceptsGzip 测试 Accept-Encoding 的解析
+func TestAcceptsGzip(t *testing.T) {
+	for value, want := range map[string]bool{
+		"gzip":            true,
+		"br, gzip;q=0.5":  true,
+		"deflate, X-GZIP": true,
+		"*":               true,
+		"br":              false,
+		"gzip;q=0":        false,
+		"*, gzip; q=0":    false,
+		"identity, *;q=0": false,
+		"":                false,
+	} {
+		if got := acceptsGzip([]string{value}); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, 期望 %v", value, got, want)
+		}
+	}
+}
+
+// TestCompressConn 测试 keep-alive 连接上的多个请求：可压缩的响应（包括逐字节写入的分块编码响应）被压缩，
+// HEAD 请求、不可压缩的类型、不接受 gzip 的请求原样转发，响应之间的边界保持正确
+func TestCompressConn(t *testing.T) {
+	html := strings.Repeat("<p>hello tunnel</p>\n", 100)
+	json := `{"items":[` + strings.Repeat(`"abcdefgh",`, 100) + `"end"]}`
+	png := strings.Repeat("\x89PNG", 100)
+
+	requests := "GET /page HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip, br\r\n\r\n" +
+		"HEAD /page HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n" +
+		"POST /api HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\nContent-Length: 11\r\n\r\nGET / HTTP/" +
+		"GET /logo.png HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\n\r\n" +
+		"GET /plain HTTP/1.1\r\nHost: a\r\n\r\n"
+	raw := &bufConn{in: strings.NewReader(requests)}
+	var compressed atomic.Uint64
+	conn := newCompressConn(raw, &compressed)
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		t.Fatalf("读取请求失败: %v", err)
+	}
+
+	chunked := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nTransfer-Encoding: chunked\r\nETag: \"v1\"\r\n\r\n" +
+		"200\r\n" + html[:512] + "\r\n" + "10\r\n" + html[512:528] + "\r\n" + strconv.FormatInt(int64(len(html[528:])), 16) + "\r\n" + html[528:] + "\r\n0\r\nX-Trailer: done\r\n\r\n"
+	responses := []string{
+		chunked,
+		"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 2000\r\n\r\n",
+		"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 201 Created\r\nContent-Type: application/json\r\nVary: Origin\r\nContent-Length: " + strconv.Itoa(len(json)) + "\r\n\r\n" + json,
+		"HTTP/1.1 200 OK\r\nContent-Type: image/png\r\nContent-Length: " + strconv.Itoa(len(png)) + "\r\n\r\n" + png,
+		"HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: " + strconv.Itoa(len(html)) + "\r\n\r\n" + html,
+	}
+	// 第一个响应逐字节写入，其余一次写入
+	for i := 0; i < len(responses[0]); i++ {
+		if n, err := conn.Write([]byte{responses[0][i]}); n != 1 || err != nil {
+			t.Fatalf("写入响应失败: %d, %v", n, err)
+		}
+	}
+	for _, resp := range responses[1:] {
+		if _, err := conn.Write([]byte(resp)); err != nil {
+			t.Fatalf("写入响应失败: %v", err)
+		}
+	}
+
+	r := bufio.NewReader(&raw.out)
+	for i, want := range []struct {
+		method, body, encoding string
+	}{
+		{"GET", html, "gzip"},
+		{"HEAD", "", ""},
+		{"POST", json, "gzip"},
+		{"GET", png, ""},
+		{"GET", html, ""},
+	} {
+		resp, err := http.ReadResponse(r, &http.Request{Method: want.method})
+		if err == nil && resp.StatusCode == http.StatusContinue {
+			resp, err = http.ReadResponse(r, &http.Request{Method: want.method})
+		}
+		if err != nil {
+			t.Fatalf("响应 %d 解析失败: %v", i, err)
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != want.encoding {
+			t.Errorf("响应 %d 的 Content-Encoding = %q, 期望 %q", i, got, want.encoding)
+		}
+		var body []byte
+		if want.encoding == "gzip" {
+			zr, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				t.Fatalf("响应 %d 不是合法的 gzip 数据: %v", i, err)
+			}
+			body, err = io.ReadAll(zr)
+		} else {
+			body, err = io.ReadAll(resp.Body)
+		}
+		if err != nil {
+			t.Fatalf("响应 %d 读取消息体失败: %v", i, err)
+		}
+		io.Copy(io.Discard, resp.Body) // 读完分块编码的结尾和尾部字段
+		resp.Body.Close()
+		if string(body) != want.body {
+			t.Errorf("响应 %d 的消息体不一致 (%d 字节, 期望 %d 字节)", i, len(body), len(want.body))
+		}
+		if i == 0 {
+			if got := resp.Header.Get("Etag"); got != `W/"v1"` {
+				t.Errorf("压缩的响应应使用弱 ETag, 得到 %q", got)
+			}
+			if got := resp.Trailer.Get("X-Trailer"); got != "done" {
+				t.Errorf("压缩的响应应保留尾部字段, 得到 %q", got)
+			}
+		}
+		if i == 2 && resp.Header.Get("Vary") != "Origin, Accept-Encoding" {
+			t.Errorf("Vary 应加入 Accept-Encoding, 得到 %q", resp.Header.Get("Vary"))
+		}
+		if i == 1 && resp.Header.Get("Content-Length") != "2000" {
+			t.Errorf("HEAD 响应应原样转发")
+		}
+	}
+	if rest, _ := io.ReadAll(r); len(rest) != 0 {
+		t.Errorf("响应之后有多余的数据: %q", rest)
+	}
+	if n := compressed.Load(); n != 2 {
+		t.Errorf("压缩的响应数应为 2, 得到 %d", n)
+	}
+}
+
+// TestCompressConnPassthrough 测试协议切换之后的数据原样透传
+func TestCompressConnPassthrough(t *testing.T) {
+	requests := "GET /ws HTTP/1.1\r\nHost: a\r\nAccept-Encoding: gzip\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n\x81\x05hello"
+	raw := &bufConn{in: strings.NewReader(requests)}
+	var compressed atomic.Uint64
+	conn := newCompressConn(raw, &compressed)
+	io.Copy(io.Discard, conn)
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n\x81\x05world" +
+		"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 300\r\n\r\n" + strings.Repeat("x", 300)
+	conn.Write([]byte(resp))
+	if got := raw.out.String(); got != resp {
+		t.Errorf("协议切换之后应原样透传, 得到 %q", got)
+	}
+	if n := compressed.Load(); n != 0 {
+		t.Errorf("不应压缩任何响应, 得到 %d", n)
+	}
+}
@@ -0,0 +1,217 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 连接关闭原因（用于访问日志）
+const (
+	closeReasonEOF         = "eof"           // 对端正常关闭
+	closeReasonError       = "error"         // 读写错误
+	closeReasonReset       = "reset"         // 对端重置连接（RST）
+	closeReasonClientClose = "client_close"  // 客户端发送 CLOSE_CONN（本地连接关闭或连接本地服务失败）
+	closeReasonClientGone  = "client_gone"   // 客户端控制连接断开
+	closeReasonClientExit  = "client_exit"   // 客户端正常退出（发送了 BYE）
+	closeReasonShutdown    = "shutdown"      // 服务器关闭
+	closeReasonQuota       = "quota"         // 累计传输字节数超出 MaxBytesPerConn
+	closeReasonSetup       = "setup_timeout" // 客户端未在 connSetupTimeout 内建立本地连接
+	closeReasonIdle        = "idle_timeout"  // 两个方向都没有数据超过空闲超时
+	closeReasonLifetime    = "max_lifetime"  // 超过连接最长存活时间
+	closeReasonAdmin       = "admin_close"   // 通过管理接口强制关闭
+)
+
+// connState 表示一个 connID 的关闭状态（见 trackedConn.closeLocal / closeRemote）
+type connState int
+
+const (
+	connOpen         connState = iota // 两端都未关闭
+	connLocalClosed                   // 本端连接先结束，本端负责清理并向对端发送 CLOSE_CONN
+	connRemoteClosed                  // 先收到对端的 CLOSE_CONN，由 CLOSE_CONN 的处理者负责清理
+	connClosed                        // 两端都已关闭（或所属控制连接已断开），不再产生任何帧
+)
+
+// trackedConn 为映射中的连接附加追踪 ID、统计信息和关闭状态，同时仍满足 net.Conn 接口
+// 映射中保存的总是 *trackedConn；关闭时通过状态转换决定由哪一个 goroutine 负责清理和发送 CLOSE_CONN，
+// 每个 connID 只有状态从 connOpen 离开的那一次转换返回 true
+type trackedConn struct {
+	net.Conn
+	traceID string
+	start   time.Time
+	// 服务器：注入后端的 W3C traceparent（未启用 trace context 或不是 HTTP 请求时为空，用于访问日志）
+	traceparent string
+
+	bytesIn  uint64 // 从该连接读取的字节数（原子操作）
+	bytesOut uint64 // 向该连接写入的字节数（原子操作）
+
+	// 活动时钟（读写数据时推进）及空闲/建立/存活截止时间，见 deadlines.go
+	deadlines connDeadlines
+
+	tenant *tenant          // 所属身份的配额状态（结束时释放连接数配额，可能为 nil）
+	out    *throttledWriter // 服务器：身份限速时写入该连接的队列（nil 表示在帧分发循环中直接写入）
+
+	window        *sendWindow // 服务器：协商了 flow_control 时向客户端发送数据的窗口（nil 表示不限制），见 flowcontrol.go
+	windowUnacked int         // 客户端：已写入本地连接、尚未以 WINDOW_UPDATE 归还的字节数
+
+	peerCloseReason  atomic.Value // 对端 CLOSE_CONN 帧携带的关闭原因（string，用于访问日志）
+	closedBeforeData atomic.Bool  // 服务器：按对端 CLOSE_CONN 关闭时还没有向该连接写出任何数据（用于关闭归类）
+
+	returnTo  *localConnPool // 客户端：关闭时放回的本地连接池（nil 表示直接关闭）
+	returnSet int32          // 客户端：已标记为放回连接池（原子操作）
+
+	setupSlot *setupLimiter // 服务器：连接占用的客户端建立名额（nil 表示不占用），见 setuplimit.go
+	setupHeld atomic.Bool   // 服务器：尚未归还建立名额
+
+	finishOnce sync.Once
+
+	stateMu sync.Mutex
+	state   connState
+}
+
+// newTrackedConn 创建一个带追踪 ID 的连接
+func newTrackedConn(conn net.Conn, traceID string) *trackedConn {
+	c := &trackedConn{
+		Conn:    conn,
+		traceID: traceID,
+		start:   time.Now(),
+	}
+	c.deadlines.init(c.start)
+	return c
+}
+
+// Read 读取数据并累计读取字节数（包括出错前读到的部分）
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+	if n > 0 {
+		c.deadlines.touch(time.Now())
+	}
+	return n, err
+}
+
+// Write 写入数据并累计写入字节数（包括出错前写出的部分）
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+	if n > 0 {
+		c.deadlines.touch(time.Now())
+	}
+	return n, err
+}
+
+// Close 关闭连接，并唤醒等待发送窗口的转发 goroutine
+func (c *trackedConn) Close() error {
+	if c.window != nil {
+		c.window.close()
+	}
+	return c.Conn.Close()
+}
+
+// idleSince 返回连接自 now 起已空闲（没有读写数据）的时长
+func (c *trackedConn) idleSince(now time.Time) time.Duration {
+	return c.deadlines.idleSince(now)
+}
+
+// markReturning 标记连接在转发结束后放回连接池
+func (c *trackedConn) markReturning() {
+	atomic.StoreInt32(&c.returnSet, 1)
+}
+
+// returning 判断连接是否已标记为放回连接池
+func (c *trackedConn) returning() bool {
+	return c.returnTo != nil && atomic.LoadInt32(&c.returnSet) == 1
+}
+
+// peerReason 返回对端 CLOSE_CONN 帧携带的关闭原因（未收到时为空）
+func (c *trackedConn) peerReason() string {
+	reason, _ := c.peerCloseReason.Load().(string)
+	return reason
+}
+
+// closeCodeForErr 将读写错误映射为 CLOSE_CONN 帧携带的关闭原因
+func closeCodeForErr(err error) proto.CloseReason {
+	switch {
+	case err == nil || errors.Is(err, io.EOF):
+		return proto.CloseGraceful
+	case errors.Is(err, syscall.ECONNRESET):
+		return proto.CloseReset
+	default:
+		return proto.CloseError
+	}
+}
+
+// closeWithReason 按对端给出的关闭原因关闭连接
+// 对端被重置或出错时以 RST 关闭（SetLinger(0)），让这一侧的应用同样感知到异常中断，而不是把截断的数据当作完整响应；
+// 其余情况先关闭写方向（发送 FIN，TLS 连接发送 close_notify）再关闭连接
+func closeWithReason(conn net.Conn, reason proto.CloseReason) error {
+	raw := conn
+	if tc, ok := conn.(*trackedConn); ok {
+		raw = tc.Conn
+	}
+	switch reason {
+	case proto.CloseGraceful, proto.CloseIdle, proto.CloseShutdown, proto.CloseQuota:
+		if cw, ok := raw.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	default:
+		if l, ok := raw.(interface{ SetLinger(sec int) error }); ok {
+			l.SetLinger(0)
+		}
+	}
+	return conn.Close()
+}
+
+// closeLocal 在本端连接结束（读到 EOF、读写出错、进程退出）时调用
+// 返回 true 表示本次调用从 connOpen 转换为 connLocalClosed，调用方负责清理并向对端发送 CLOSE_CONN；
+// 返回 false 表示已收到对端的 CLOSE_CONN 或已清理（连接是被对方关闭的），调用方直接退出，不发送任何帧
+func (c *trackedConn) closeLocal() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	switch c.state {
+	case connOpen:
+		c.state = connLocalClosed
+		return true
+	case connRemoteClosed:
+		c.state = connClosed
+	}
+	return false
+}
+
+// closeRemote 在收到对端的 CLOSE_CONN 时调用
+// 返回 true 表示本次调用从 connOpen 转换为 connRemoteClosed，调用方负责关闭连接并清理；
+// 返回 false 表示本端已先关闭并发送过 CLOSE_CONN（双方同时关闭或对端的回发），该帧直接忽略
+func (c *trackedConn) closeRemote() bool {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	switch c.state {
+	case connOpen:
+		c.state = connRemoteClosed
+		return true
+	case connLocalClosed:
+		c.state = connClosed
+	}
+	return false
+}
+
+// markClosed 所属控制连接断开或进程退出时直接进入 connClosed，之后的 closeLocal/closeRemote 都返回 false
+func (c *trackedConn) markClosed() {
+	c.stateMu.Lock()
+	c.state = connClosed
+	c.stateMu.Unlock()
+}
+
+// finish 在连接清理时调用且只生效一次，fn 接收首个调用者给出的关闭原因
+func (c *trackedConn) finish(reason string, fn func(c *trackedConn, reason string)) {
+	c.finishOnce.Do(func() {
+		if fn != nil {
+			fn(c, reason)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConnCloseState 测试连接关闭状态机的转换：每个 connID 只有一方负责清理和发送 CLOSE_CONN
+func TestConnCloseState(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []func(*trackedConn) bool
+		want  []bool
+		final connState
+	}{
+		{"本端先关闭", []func(*trackedConn) bool{(*trackedConn).closeLocal, (*trackedConn).closeRemote}, []bool{true, false}, connClosed},
+		{"对端先关闭", []func(*trackedConn) bool{(*trackedConn).closeRemote, (*trackedConn).closeLocal}, []bool{true, false}, connClosed},
+		{"重复本端关闭", []func(*trackedConn) bool{(*trackedConn).closeLocal, (*trackedConn).closeLocal}, []bool{true, false}, connLocalClosed},
+		{"重复对端关闭", []func(*trackedConn) bool{(*trackedConn).closeRemote, (*trackedConn).closeRemote}, []bool{true, false}, connRemoteClosed},
+		{"控制连接断开", []func(*trackedConn) bool{
+			func(tc *trackedConn) bool { tc.markClosed(); return false },
+			(*trackedConn).closeLocal,
+			(*trackedConn).closeRemote,
+		}, []bool{false, false, false}, connClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTrackedConn(nil, "test")
+			for i, step := range tt.steps {
+				if got := step(tc); got != tt.want[i] {
+					t.Errorf("第 %d 步: 期望 %v, 得到 %v", i+1, tt.want[i], got)
+				}
+			}
+			if tc.state != tt.final {
+				t.Errorf("期望最终状态 %d, 得到 %d", tt.final, tc.state)
+			}
+		})
+	}
+
+	// 本端读取出错与对端 CLOSE_CONN 同时发生：恰好一方获得清理权
+	for i := 0; i < 1000; i++ {
+		tc := newTrackedConn(nil, "test")
+		var wg sync.WaitGroup
+		results := make([]bool, 2)
+		wg.Add(2)
+		go func() { defer wg.Done(); results[0] = tc.closeLocal() }()
+		go func() { defer wg.Done(); results[1] = tc.closeRemote() }()
+		wg.Wait()
+		if results[0] == results[1] {
+			t.Fatalf("第 %d 次: 期望恰好一方负责清理, 得到 local=%v remote=%v", i, results[0], results[1])
+		}
+		if tc.state != connClosed {
+			t.Fatalf("第 %d 次: 期望最终状态为 connClosed, 得到 %d", i, tc.state)
+		}
+	}
+}
+
+// TestSimultaneousClose 测试外部连接与本地服务同时关闭时两端都完成清理（使用内存传输）
+func TestSimultaneousClose(t *testing.T) {
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+
+	// 本地服务读到 1 字节后立即关闭，与外部连接的关闭交叉
+	go func() {
+		for {
+			conn, err := local.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.Read(make([]byte, 1))
+				conn.Close()
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+	go server.Run(ctx)
+	client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local))
+	go client.Run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(server.ClientStatus()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能通过内存传输注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			conn.Write([]byte("x"))
+			conn.Close()
+		}(conn)
+	}
+	wg.Wait()
+
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		status := server.ClientStatus()
+		if len(status) == 1 && status[0].ActiveConns == 0 && client.activeConnCount() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("连接未被清理: 服务器 %+v, 客户端活跃连接 %d", status, client.activeConnCount())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
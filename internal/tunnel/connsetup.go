@@ -0,0 +1,45 @@
+package tunnel
+
+import (
+	"log"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// connSetupExpired 关闭在 connSetupTimeout 内未建立的公开连接，并以 CloseError 通知客户端放弃该 connID
+// 建立超时是公开连接的截止时间之一（见 deadlines.go）：客户端在超时前没有回复 NEW_CONN_ACK
+// （未协商 conn_ack 的客户端：没有发来该连接的 DATA）时由 forwardPublicConn 的读截止时间触发，
+// 避免控制连接仍然存活、但不再处理 NEW_CONN 的客户端让公开连接无限期地挂起。
+// 连接已被另一方关闭（closeLocal 返回 false）时由其完成清理
+func (s *Server) connSetupExpired(clientInfo *ClientInfo, connID uint32, tc *trackedConn) {
+	if !tc.closeLocal() {
+		return
+	}
+	clientID := clientInfo.ID
+	s.connSetupTimeouts.Add(1)
+	log.Printf("客户端未在 %v 内建立本地连接，关闭外部连接: clientID=%s, connID=%d, trace=%s", s.connSetupTimeout, clientID, connID, tc.traceID)
+	s.sendCloseFrame(clientID, connID, tc.traceID, proto.CloseError)
+	s.releasePublicConn(clientInfo, clientID, connID, tc, closeReasonSetup)
+}
+
+// handleNewConnAck 处理客户端的 NEW_CONN_ACK 帧（本地连接已建立），停止连接的建立超时，返回处理结果（用于帧计数）
+func (s *Server) handleNewConnAck(clientID string, frame *proto.Frame) string {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return frameUnknownConn
+	}
+	value, ok := clientInfo.ConnMap.Load(frame.ConnID)
+	if !ok {
+		return frameUnknownConn
+	}
+	value.(*trackedConn).setupDone()
+	return frameOK
+}
+
+// setupDone 标记连接已建立，此后不再检查建立超时，并归还占用的建立名额（未设置建立超时或已确认时不做任何事）
+func (c *trackedConn) setupDone() {
+	c.deadlines.setupDone()
+	c.releaseSetup()
+}
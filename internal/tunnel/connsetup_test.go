@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingDialer 在 release 关闭之前阻塞拨号（模拟卡住、不再处理 NEW_CONN 的客户端），之后转交给 next
+type blockingDialer struct {
+	release chan struct{}
+	next    Dialer
+}
+
+func (d *blockingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	select {
+	case <-d.release:
+		return d.next.DialContext(ctx, network, address)
+	case <-ctx.Done():
+		return nil, errors.New("拨号被取消")
+	}
+}
+
+// TestConnSetupTimeout 测试客户端未在 connSetupTimeout 内建立本地连接时公开连接被关闭并计数，
+// 回复了 NEW_CONN_ACK 的连接在超时之后即使没有数据流动也保持打开
+func TestConnSetupTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	const timeout = 200 * time.Millisecond
+	dialer := &blockingDialer{release: make(chan struct{}), next: local}
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerConnSetupTimeout(timeout))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(dialer)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	// 客户端卡在连接本地服务：超时后公开连接被关闭
+	hung, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	hung.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := hung.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("客户端未建立本地连接时公开连接应在超时后关闭, 得到 %v", err)
+	}
+	hung.Close()
+	waitStat(t, "建立超时的连接数", func() uint64 { return server.connSetupTimeouts.Load() }, 1)
+	close(dialer.release)
+
+	// 客户端回复 NEW_CONN_ACK：超过建立超时后连接仍可用
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(3 * timeout)
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	go conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("已确认的连接不应被建立超时关闭: %q, %v", buf, err)
+	}
+	if n := server.connSetupTimeouts.Load(); n != 1 {
+		t.Errorf("已确认的连接不应计入建立超时, 得到 %d", n)
+	}
+
+	var metrics bytes.Buffer
+	server.writeMetrics(&metrics)
+	if !strings.Contains(metrics.String(), "reverse_tunnel_conn_setup_timeouts_total 1\n") {
+		t.Errorf("指标缺少 reverse_tunnel_conn_setup_timeouts_total 1")
+	}
+}
@@ -0,0 +1,195 @@
+package tunnel
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 转发连接的截止时间种类（connDeadlines.expired 的返回值）
+const (
+	deadlineIdle     = "idle"     // 两个方向都没有数据超过空闲超时
+	deadlineSetup    = "setup"    // 对端未在建立超时内确认连接已建立
+	deadlineLifetime = "lifetime" // 超过连接最长存活时间
+)
+
+// connDeadlines 一个转发连接的活动时钟和截止时间，由两端的转发循环共用：
+// 读写数据时 touch 推进活动时钟；转发循环在每次读取/写入之前调用 armRead/armWrite，
+// 把最近的截止时间（空闲：最近活动 + idle；建立：开始 + setup，确认建立之前；存活：开始 + lifetime）设置到连接上；
+// 读取因截止时间失败后调用 expired 区分真正的超时（返回种类）与活动时钟已被另一个方向推进、只需重新设置截止时间后继续读取的情况；
+// 写入因截止时间失败时不再重试（TLS 连接写入超时后不可再写），按 armWrite 返回的种类关闭连接。
+// 三个时长都为 0 时 armRead/armWrite 不修改连接的截止时间（没有截止时间后清除此前设置的截止时间）。
+// 截止时间只需在一端配置：到期的一端经 closeLocal 发送 CLOSE_CONN 后退出转发，另一端收到 CLOSE_CONN 时关闭其连接，
+// 该端的转发循环随之读到错误退出；即使两端从未传输任何数据，一个 connID 两端的转发 goroutine 也总是一起结束
+type connDeadlines struct {
+	start      time.Time
+	lastActive atomic.Int64 // 最近一次读到或写出数据的时间（UnixNano），也用于数据连接保活
+
+	mu       sync.Mutex
+	idle     time.Duration // 空闲超时（0 表示不限制）
+	setup    time.Duration // 建立超时（0 表示不限制，确认建立后清零）
+	lifetime time.Duration // 最长存活时间（0 表示不限制）
+
+	readArmed  atomic.Bool // 连接上设置了读截止时间（建立超时确认后可能不再有截止时间，需要清除）
+	writeArmed atomic.Bool // 连接上设置了写截止时间
+}
+
+// init 以 start 作为开始时间和初始活动时间
+func (d *connDeadlines) init(start time.Time) {
+	d.start = start
+	d.lastActive.Store(start.UnixNano())
+}
+
+// set 设置空闲超时、建立超时和最长存活时间（0 表示不限制），在开始转发之前调用
+func (d *connDeadlines) set(idle, setup, lifetime time.Duration) {
+	d.mu.Lock()
+	d.idle, d.setup, d.lifetime = idle, setup, lifetime
+	d.mu.Unlock()
+}
+
+// touch 记录一次数据活动，推迟空闲截止时间
+func (d *connDeadlines) touch(now time.Time) {
+	d.lastActive.Store(now.UnixNano())
+}
+
+// idleSince 返回自 now 起已空闲（没有读写数据）的时长
+func (d *connDeadlines) idleSince(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, d.lastActive.Load()))
+}
+
+// setupDone 确认连接已建立，此后不再检查建立超时，返回此前是否仍在等待确认
+func (d *connDeadlines) setupDone() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	waiting := d.setup > 0
+	d.setup = 0
+	return waiting
+}
+
+// next 返回最近的截止时间及其种类，没有设置任何截止时间时返回零值
+func (d *connDeadlines) next() (time.Time, string) {
+	d.mu.Lock()
+	idle, setup, lifetime := d.idle, d.setup, d.lifetime
+	d.mu.Unlock()
+
+	var at time.Time
+	var kind string
+	consider := func(t time.Time, k string) {
+		if at.IsZero() || t.Before(at) {
+			at, kind = t, k
+		}
+	}
+	if setup > 0 {
+		consider(d.start.Add(setup), deadlineSetup)
+	}
+	if lifetime > 0 {
+		consider(d.start.Add(lifetime), deadlineLifetime)
+	}
+	if idle > 0 {
+		consider(time.Unix(0, d.lastActive.Load()).Add(idle), deadlineIdle)
+	}
+	return at, kind
+}
+
+// expired 返回在 now 时已到期的截止时间种类，都未到期时返回空字符串
+func (d *connDeadlines) expired(now time.Time) string {
+	at, kind := d.next()
+	if at.IsZero() || now.Before(at) {
+		return ""
+	}
+	return kind
+}
+
+// armRead 将最近的截止时间设置为 conn 的读截止时间
+// 没有截止时间时清除此前设置的读截止时间（从未设置过时不做任何事）
+func (d *connDeadlines) armRead(conn net.Conn) {
+	at, _ := d.next()
+	if !at.IsZero() || d.readArmed.Load() {
+		d.readArmed.Store(!at.IsZero())
+		conn.SetReadDeadline(at)
+	}
+}
+
+// armWrite 将最近的截止时间设置为 conn 的写截止时间并返回其种类（没有设置截止时间时不做任何事，返回空字符串）
+// 写入阻塞（对端停止读取）到截止时间说明对端在整个空闲超时内没有接收数据，按该种类关闭连接
+func (d *connDeadlines) armWrite(conn net.Conn) string {
+	at, kind := d.next()
+	if !at.IsZero() || d.writeArmed.Load() {
+		d.writeArmed.Store(!at.IsZero())
+		conn.SetWriteDeadline(at)
+	}
+	return kind
+}
+
+// limit 返回种类对应的时长（用于日志）
+func (d *connDeadlines) limit(kind string) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch kind {
+	case deadlineIdle:
+		return d.idle
+	case deadlineLifetime:
+		return d.lifetime
+	}
+	return 0
+}
+
+// deadlineCloseReason 返回到期种类对应的 CLOSE_CONN 原因和访问日志的关闭原因
+func deadlineCloseReason(kind string) (proto.CloseReason, string) {
+	switch kind {
+	case deadlineIdle:
+		return proto.CloseIdle, closeReasonIdle
+	case deadlineLifetime:
+		return proto.CloseQuota, closeReasonLifetime
+	default:
+		return proto.CloseError, closeReasonSetup
+	}
+}
+
+// expirePublicConn 关闭截止时间已到期的公开连接，并以对应的原因通知客户端关闭本地连接
+// 连接已被另一方关闭（closeLocal 返回 false）时由其完成清理
+func (s *Server) expirePublicConn(clientInfo *ClientInfo, connID uint32, tc *trackedConn, kind string) {
+	if kind == deadlineSetup {
+		s.connSetupExpired(clientInfo, connID, tc)
+		return
+	}
+	if !tc.closeLocal() {
+		return
+	}
+	clientID := clientInfo.ID
+	code, reason := deadlineCloseReason(kind)
+	if kind == deadlineIdle {
+		log.Printf("外部连接空闲超过 %v，关闭: clientID=%s, connID=%d, trace=%s", tc.deadlines.limit(kind), clientID, connID, tc.traceID)
+	} else {
+		log.Printf("外部连接已达最长存活时间 %v，关闭: clientID=%s, connID=%d, trace=%s", tc.deadlines.limit(kind), clientID, connID, tc.traceID)
+	}
+	s.sendCloseFrame(clientID, connID, tc.traceID, code)
+	s.releasePublicConn(clientInfo, clientID, connID, tc, reason)
+}
+
+// expireLocalConn 在本地连接的截止时间到期时通知服务器关闭公开连接，返回 false 表示连接已被服务器关闭（由其完成清理）
+// 调用方负责关闭本地连接并从映射中删除
+func (c *Client) expireLocalConn(connID uint32, localConn *trackedConn, kind string) bool {
+	if !localConn.closeLocal() {
+		return false
+	}
+	code, _ := deadlineCloseReason(kind)
+	if kind == deadlineIdle {
+		log.Printf("本地连接空闲超过 %v，关闭: connID=%d, trace=%s", localConn.deadlines.limit(kind), connID, localConn.traceID)
+	} else {
+		log.Printf("本地连接已达最长存活时间 %v，关闭: connID=%d, trace=%s", localConn.deadlines.limit(kind), connID, localConn.traceID)
+	}
+	c.sendCloseFrame(connID, localConn.traceID, code)
+	return true
+}
+
+// isDeadlineErr 判断读写错误是否由截止时间触发
+func isDeadlineErr(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,209 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConnDeadlines 测试截止时间的计算：取最近的截止时间，活动推迟空闲截止时间，确认建立后不再检查建立超时
+func TestConnDeadlines(t *testing.T) {
+	start := time.Unix(1000, 0)
+	var d connDeadlines
+	d.init(start)
+	if at, kind := d.next(); !at.IsZero() || kind != "" {
+		t.Fatalf("未设置截止时间时 next 应返回零值, 得到 %v %q", at, kind)
+	}
+	if kind := d.expired(start.Add(time.Hour)); kind != "" {
+		t.Fatalf("未设置截止时间时不应到期, 得到 %q", kind)
+	}
+
+	d.set(10*time.Second, 5*time.Second, time.Minute)
+	if at, kind := d.next(); !at.Equal(start.Add(5*time.Second)) || kind != deadlineSetup {
+		t.Errorf("最近的截止时间应为建立超时, 得到 %v %q", at, kind)
+	}
+	if kind := d.expired(start.Add(4 * time.Second)); kind != "" {
+		t.Errorf("建立超时之前不应到期, 得到 %q", kind)
+	}
+	if kind := d.expired(start.Add(5 * time.Second)); kind != deadlineSetup {
+		t.Errorf("应在建立超时时到期, 得到 %q", kind)
+	}
+
+	if !d.setupDone() {
+		t.Error("首次确认建立应返回 true")
+	}
+	if d.setupDone() {
+		t.Error("重复确认建立应返回 false")
+	}
+	if at, kind := d.next(); !at.Equal(start.Add(10*time.Second)) || kind != deadlineIdle {
+		t.Errorf("确认建立后最近的截止时间应为空闲超时, 得到 %v %q", at, kind)
+	}
+
+	// 活动推迟空闲截止时间，但不推迟最长存活时间
+	d.touch(start.Add(55 * time.Second))
+	if got := d.idleSince(start.Add(58 * time.Second)); got != 3*time.Second {
+		t.Errorf("idleSince 应为 3s, 得到 %v", got)
+	}
+	if kind := d.expired(start.Add(59 * time.Second)); kind != "" {
+		t.Errorf("活动之后不应空闲到期, 得到 %q", kind)
+	}
+	if kind := d.expired(start.Add(time.Minute)); kind != deadlineLifetime {
+		t.Errorf("应在最长存活时间时到期, 得到 %q", kind)
+	}
+	if got := d.limit(deadlineLifetime); got != time.Minute {
+		t.Errorf("limit(lifetime) 应为 1m, 得到 %v", got)
+	}
+}
+
+// TestConnIdleAndLifetime 测试公开连接的空闲超时和最长存活时间：
+// 空闲连接在超时后被关闭，持续有数据的连接不受空闲超时影响、但在最长存活时间到期时被关闭
+func TestConnIdleAndLifetime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	const idle, lifetime = 200 * time.Millisecond, 800 * time.Millisecond
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public),
+		WithServerConnIdleTimeout(idle), WithServerConnMaxLifetime(lifetime))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	// 回显一次后保持空闲：空闲超时后被关闭
+	quiet, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer quiet.Close()
+	quiet.SetDeadline(time.Now().Add(3 * time.Second))
+	go quiet.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(quiet, buf); err != nil {
+		t.Fatalf("回显失败: %v", err)
+	}
+	began := time.Now()
+	if _, err := quiet.Read(buf); err != io.EOF {
+		t.Fatalf("空闲连接应在超时后关闭, 得到 %v", err)
+	}
+	if elapsed := time.Since(began); elapsed < idle/2 {
+		t.Errorf("空闲连接过早关闭: %v", elapsed)
+	}
+
+	// 持续有数据的连接：不受空闲超时影响，在最长存活时间到期时关闭
+	busy, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer busy.Close()
+	busy.SetDeadline(time.Now().Add(3 * time.Second))
+	began = time.Now()
+	for {
+		if _, err := busy.Write([]byte("ping")); err != nil {
+			break
+		}
+		if _, err := io.ReadFull(busy, buf); err != nil {
+			break
+		}
+		time.Sleep(idle / 4)
+	}
+	if elapsed := time.Since(began); elapsed < lifetime-idle {
+		t.Errorf("持续有数据的连接应存活到最长存活时间, 只存活了 %v", elapsed)
+	}
+	waitStat(t, "客户端的转发连接数", func() int {
+		n := 0
+		server.clientsMu.RLock()
+		for _, info := range server.clients {
+			info.ConnMap.Range(func(_, _ interface{}) bool { n++; return true })
+		}
+		server.clientsMu.RUnlock()
+		return n
+	}, 0)
+}
+
+// forwarderGoroutines 返回服务器公开连接转发和客户端本地连接转发的 goroutine 数
+func forwarderGoroutines() (public, local int) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, ").forwardPublicConn(") {
+			public++
+		}
+		if strings.Contains(g, ").forwardLocalToServer(") {
+			local++
+		}
+	}
+	return public, local
+}
+
+// TestZombieConnTeardown 测试公开连接和本地连接都已建立、但双方都从不发送数据时，
+// 无论空闲超时配置在服务器还是客户端，到期后两端的转发 goroutine 都退出，连接从两端的映射中移除
+func TestZombieConnTeardown(t *testing.T) {
+	const idle = 200 * time.Millisecond
+	for _, side := range []string{"server", "client"} {
+		t.Run(side, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			control := newMemListener("control")
+			public := newMemListener("public")
+			local := newMemListener("local")
+			defer local.Close()
+			go serveMemEcho(local)
+
+			var serverOpts []ServerOption
+			var clientOpts []ClientOption
+			if side == "server" {
+				serverOpts = append(serverOpts, WithServerConnIdleTimeout(idle))
+			} else {
+				clientOpts = append(clientOpts, WithConnIdleTimeout(idle))
+			}
+			server := NewServer("", "", append(serverOpts, WithServerControlListener(control), WithServerPublicListener(public))...)
+			client := NewClient("control", "local", 0, append(clientOpts, WithControlDialer(control), WithLocalDialer(local))...)
+			go server.Run(ctx)
+			go client.Run(ctx)
+			waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+			basePublic, baseLocal := forwarderGoroutines()
+
+			conn, err := public.DialContext(ctx, "mem", "public")
+			if err != nil {
+				t.Fatalf("连接公开监听器失败: %v", err)
+			}
+			defer conn.Close()
+			began := time.Now()
+			waitStat(t, "客户端的本地连接数", client.activeConnCount, 1)
+			// 两端的转发 goroutine 在建立连接之后才启动，轮询等待各增加一个
+			waitStat(t, "两端新增的转发 goroutine", func() string {
+				p, l := forwarderGoroutines()
+				return fmt.Sprintf("%d/%d", p-basePublic, l-baseLocal)
+			}, "1/1")
+
+			conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("空闲连接应在超时后关闭, 得到 %v", err)
+			}
+			if elapsed := time.Since(began); elapsed < idle/2 {
+				t.Errorf("空闲连接过早关闭: %v", elapsed)
+			}
+			waitStat(t, "转发 goroutine", func() bool {
+				p, l := forwarderGoroutines()
+				return p <= basePublic && l <= baseLocal
+			}, true)
+			waitStat(t, "客户端的本地连接数", client.activeConnCount, 0)
+			waitStat(t, "服务器的转发 goroutine 数", server.activeForwarders.Load, 0)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 控制帧解码错误的类别（指标标签 kind）
+const (
+	decodeTruncatedHeader  = "truncated_header"  // 帧头读取到一半时连接结束
+	decodeTruncatedPayload = "truncated_payload" // 负载读取到一半时连接结束
+	decodeOversized        = "oversized_payload" // 负载长度超过协议上限或协商的 DATA 上限
+	decodeUnknownType      = "unknown_type"      // 未知帧类型（忽略该帧，不断开）
+	decodeFrameMAC         = "frame_mac"         // 帧完整性校验失败
+)
+
+// decodeErrorKinds 指标中始终输出的解码错误类别（保证时间序列稳定）
+var decodeErrorKinds = []string{
+	decodeFrameMAC,
+	decodeOversized,
+	decodeTruncatedHeader,
+	decodeTruncatedPayload,
+	decodeUnknownType,
+}
+
+// errDecodeBlocked 新的控制连接来自因反复解码错误而被暂时拒绝重连的来源
+var errDecodeBlocked = errors.New("反复发送无法解码的帧，暂时拒绝重连")
+
+// defaultDecodeErrorBackoff 未设置时间时反复出现解码错误的客户端被拒绝重连的时长
+const defaultDecodeErrorBackoff = time.Minute
+
+// decodeErrorKind 返回读取控制帧错误的类别；连接正常关闭、被重置、超时等网络错误返回空字符串（不是解码错误）
+func decodeErrorKind(err error) string {
+	switch {
+	case errors.Is(err, proto.ErrTruncatedHeader):
+		return decodeTruncatedHeader
+	case errors.Is(err, proto.ErrTruncatedPayload):
+		return decodeTruncatedPayload
+	case errors.Is(err, proto.ErrPayloadTooLarge):
+		return decodeOversized
+	case errors.Is(err, errFrameMAC):
+		return decodeFrameMAC
+	}
+	return ""
+}
+
+// decodeOffender 一个来源（客户端身份或 IP）的解码错误记录
+type decodeOffender struct {
+	count       int       // 本轮累计的解码错误次数
+	last        time.Time // 最近一次解码错误的时间
+	bannedUntil time.Time // 拒绝重连的截止时间（零值表示未被拒绝）
+}
+
+// decodeErrorTracker 按类别统计控制帧解码错误，用于区分网络抖动（偶发的截断）和协议不兼容或恶意的客户端（持续的超长负载、未知帧）。
+// 设置了 limit 时，同一来源的断开连接的解码错误（未知帧类型除外）在 backoff 内累计达到 limit 次后，
+// 该来源的控制连接在 backoff 内被拒绝（回复 ERROR）；距上次错误超过 backoff 时重新计数。零值只计数
+type decodeErrorTracker struct {
+	limit   int
+	backoff time.Duration
+
+	mu        sync.Mutex
+	counts    map[string]uint64
+	offenders map[string]*decodeOffender
+}
+
+// record 记录一次来自 source 的解码错误，返回 source 是否因此被拒绝重连
+func (t *decodeErrorTracker) record(source, kind string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts == nil {
+		t.counts = make(map[string]uint64)
+	}
+	t.counts[kind]++
+	if t.limit <= 0 || kind == decodeUnknownType || source == "" {
+		return false
+	}
+	if t.offenders == nil {
+		t.offenders = make(map[string]*decodeOffender)
+	}
+	backoff := t.backoffDuration()
+	// 清除已过期的记录（解码错误会断开连接，出现频率低，遍历的开销可以忽略）
+	for key, o := range t.offenders {
+		if now.Sub(o.last) > backoff && !now.Before(o.bannedUntil) {
+			delete(t.offenders, key)
+		}
+	}
+	o, ok := t.offenders[source]
+	if !ok || now.Sub(o.last) > backoff {
+		o = &decodeOffender{}
+		t.offenders[source] = o
+	}
+	o.count++
+	o.last = now
+	if o.count < t.limit {
+		return false
+	}
+	o.count = 0
+	o.bannedUntil = now.Add(backoff)
+	return true
+}
+
+// blocked 判断 source 是否处于拒绝重连期间（过期的记录随之清除）
+func (t *decodeErrorTracker) blocked(source string, now time.Time) bool {
+	if t.limit <= 0 || source == "" {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, ok := t.offenders[source]
+	if !ok {
+		return false
+	}
+	if now.Before(o.bannedUntil) {
+		return true
+	}
+	if now.Sub(o.last) > t.backoffDuration() {
+		delete(t.offenders, source)
+	}
+	return false
+}
+
+// backoffDuration 返回拒绝重连的时长
+func (t *decodeErrorTracker) backoffDuration() time.Duration {
+	if t.backoff <= 0 {
+		return defaultDecodeErrorBackoff
+	}
+	return t.backoff
+}
+
+// writeMetrics 以 Prometheus 文本格式写入按类别的解码错误计数
+func (t *decodeErrorTracker) writeMetrics(buf *bytes.Buffer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	buf.WriteString("# HELP reverse_tunnel_frame_decode_errors_total Control frames from clients that could not be decoded, by error kind.\n")
+	buf.WriteString("# TYPE reverse_tunnel_frame_decode_errors_total counter\n")
+	for _, kind := range decodeErrorKinds {
+		fmt.Fprintf(buf, "reverse_tunnel_frame_decode_errors_total{kind=%q} %d\n", kind, t.counts[kind])
+	}
+}
+
+// decodeErrorSource 返回按来源统计解码错误的键：有客户端身份时为身份，否则为对端 IP
+func decodeErrorSource(identity string, addr net.Addr) string {
+	if identity != "" {
+		return "identity:" + identity
+	}
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "addr:" + addr.String()
+	}
+	return "ip:" + host
+}
+
+// recordDecodeError 记录客户端控制连接上的一次解码错误，达到阈值时记录日志（之后的重连在 backoff 内被拒绝）
+func (s *Server) recordDecodeError(clientID string, conn net.Conn, kind string) {
+	s.clientsMu.RLock()
+	identity := ""
+	if info, ok := s.clients[clientID]; ok {
+		identity = info.Identity
+	}
+	s.clientsMu.RUnlock()
+	source := decodeErrorSource(identity, conn.RemoteAddr())
+	if s.decodeErrors.record(source, kind, time.Now()) {
+		log.Printf("客户端反复发送无法解码的帧 (%d 次)，%v 内拒绝其重连: clientID=%s, 来源=%s, 最近的错误=%s",
+			s.decodeErrors.limit, s.decodeErrors.backoffDuration(), clientID, source, kind)
+	}
+}
+
+// decodeErrorBlocked 判断新的控制连接是否来自因反复解码错误而被拒绝重连的来源
+func (s *Server) decodeErrorBlocked(conn net.Conn) bool {
+	return s.decodeErrors.blocked(decodeErrorSource(peerIdentity(conn), conn.RemoteAddr()), time.Now())
+}
@@ -0,0 +1,134 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestDecodeErrorKind 测试读取控制帧的错误按类别区分，网络错误和正常关闭不计为解码错误
+func TestDecodeErrorKind(t *testing.T) {
+	oversized := make([]byte, 9)
+	oversized[0] = byte(proto.FrameTypeDATA)
+	binary.BigEndian.PutUint32(oversized[5:], proto.MaxPayloadSize+1)
+	valid, _ := proto.EncodeFrame(&proto.Frame{Type: proto.FrameTypeDATA, ConnID: 1, Payload: []byte("data")})
+
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"帧头中途", valid[:4], decodeTruncatedHeader},
+		{"负载中途", valid[:11], decodeTruncatedPayload},
+		{"超长负载", oversized, decodeOversized},
+		{"帧边界处结束", nil, ""},
+	}
+	for _, tc := range cases {
+		_, err := proto.DecodeFrame(bytes.NewReader(tc.data))
+		if got := decodeErrorKind(err); got != tc.want {
+			t.Errorf("%s: 期望类别 %q, 得到 %q (%v)", tc.name, tc.want, got, err)
+		}
+	}
+	if got := decodeErrorKind(fmt.Errorf("读取记录失败: %w", errFrameMAC)); got != decodeFrameMAC {
+		t.Errorf("帧完整性校验失败: 期望类别 %q, 得到 %q", decodeFrameMAC, got)
+	}
+	if got := decodeErrorKind(&net.OpError{Op: "read", Err: io.ErrClosedPipe}); got != "" {
+		t.Errorf("网络错误不应计为解码错误, 得到 %q", got)
+	}
+}
+
+// TestDecodeErrorTracker 测试同一来源在窗口内累计达到阈值后被拒绝重连，窗口过后恢复；未知帧类型只计数
+func TestDecodeErrorTracker(t *testing.T) {
+	tr := &decodeErrorTracker{limit: 2, backoff: time.Minute}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 5; i++ {
+		if tr.record("ip:10.0.0.1", decodeUnknownType, now) {
+			t.Fatal("未知帧类型不应导致拒绝重连")
+		}
+	}
+	if tr.record("ip:10.0.0.1", decodeOversized, now) {
+		t.Fatal("未达到阈值时不应拒绝重连")
+	}
+	// 超过窗口后重新计数
+	now = now.Add(2 * time.Minute)
+	if tr.record("ip:10.0.0.1", decodeOversized, now) {
+		t.Fatal("窗口之外的错误应重新计数")
+	}
+	if !tr.record("ip:10.0.0.1", decodeTruncatedHeader, now) {
+		t.Fatal("达到阈值时应拒绝重连")
+	}
+	if !tr.blocked("ip:10.0.0.1", now.Add(59*time.Second)) {
+		t.Error("拒绝期间的重连应被拒绝")
+	}
+	if tr.blocked("ip:10.0.0.2", now) {
+		t.Error("其他来源不应受影响")
+	}
+	if tr.blocked("ip:10.0.0.1", now.Add(time.Minute)) {
+		t.Error("拒绝期结束后应允许重连")
+	}
+
+	var buf bytes.Buffer
+	tr.writeMetrics(&buf)
+	for _, line := range []string{
+		`reverse_tunnel_frame_decode_errors_total{kind="unknown_type"} 5`,
+		`reverse_tunnel_frame_decode_errors_total{kind="oversized_payload"} 2`,
+		`reverse_tunnel_frame_decode_errors_total{kind="frame_mac"} 0`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("指标缺少 %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+// TestDecodeErrorBackoff 测试客户端反复发送超长负载的帧后，服务器拒绝其之后的控制连接
+func TestDecodeErrorBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(newMemListener("public")), WithServerDecodeErrorLimit(2, time.Minute))
+	go server.Run(ctx)
+
+	header := make([]byte, 9)
+	header[0] = byte(proto.FrameTypeDATA)
+	binary.BigEndian.PutUint32(header[5:], proto.MaxPayloadSize+1)
+	for i := 0; i < 2; i++ {
+		conn, err := control.DialContext(ctx, "mem", "control")
+		if err != nil {
+			t.Fatalf("连接控制监听器失败: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		if _, err := conn.Write(header); err != nil {
+			t.Fatalf("发送帧头失败: %v", err)
+		}
+		if _, err := io.Copy(io.Discard, conn); err != nil {
+			t.Fatalf("服务器应关闭控制连接: %v", err)
+		}
+		conn.Close()
+	}
+
+	conn, err := control.DialContext(ctx, "mem", "control")
+	if err != nil {
+		t.Fatalf("连接控制监听器失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	frame, err := proto.DecodeFrame(conn)
+	if err != nil || frame.Type != proto.FrameTypeERROR {
+		t.Fatalf("拒绝期间的控制连接应收到 ERROR 帧, 得到 %+v, %v", frame, err)
+	}
+	server.decodeErrors.mu.Lock()
+	got := server.decodeErrors.counts[decodeOversized]
+	server.decodeErrors.mu.Unlock()
+	if got != 2 {
+		t.Errorf("超长负载错误应计数 2 次, 得到 %d", got)
+	}
+}
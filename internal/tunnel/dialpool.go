@@ -0,0 +1,190 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// defaultDialQueue 未设置排队上限时等待拨号的 NEW_CONN 数
+const defaultDialQueue = 1024
+
+// pendingDataLimit 本地连接建立之前为每个连接缓存的 DATA 负载字节数上限，超过时关闭该连接
+const pendingDataLimit = 256 << 10
+
+// dialPool 限制同时拨号本地服务的数量：启用后 NEW_CONN 不在帧读取循环中同步拨号，而是交给最多 workers 个并发的拨号，
+// 超出的 NEW_CONN 排队等待（最多 queue 个，排队已满时直接以 CloseUnavailable 关闭新连接）。
+// 流量突增时本地服务不会同时收到大量连接请求，帧读取循环也不会被拨号阻塞。
+// 本地连接建立之前服务器发来的 DATA 帧缓存在 pendingDial 中，建立后按顺序写入本地连接；期间收到的 CLOSE_CONN 取消该连接
+type dialPool struct {
+	slots chan struct{}
+	queue int
+
+	waiting  atomic.Int32
+	rejected atomic.Uint64
+	pending  sync.Map // map[uint32]*pendingDial
+
+	rejectLog rateLimitedLog
+}
+
+// newDialPool 创建最多 workers 个并发拨号、最多 queue 个排队的拨号池（queue 为 0 时使用 defaultDialQueue）
+func newDialPool(workers, queue int) *dialPool {
+	if queue <= 0 {
+		queue = defaultDialQueue
+	}
+	return &dialPool{slots: make(chan struct{}, workers), queue: queue}
+}
+
+// pendingDial 正在排队或拨号的连接：缓存服务器发来的 DATA 负载，记录拨号完成之前收到的 CLOSE_CONN
+type pendingDial struct {
+	mu       sync.Mutex
+	data     [][]byte
+	size     int
+	canceled bool // 服务器已关闭该连接（或缓存超过上限），拨号完成后直接关闭本地连接
+	settled  bool // 本地连接已建立并存入 connMap，之后的帧直接写入本地连接
+}
+
+// initDialPool 设置了并发拨号数时创建拨号池
+func (c *Client) initDialPool() {
+	if c.dialWorkers > 0 {
+		c.dialPool = newDialPool(c.dialWorkers, c.dialQueue)
+	}
+}
+
+// dispatchNewConn 将 NEW_CONN 交给拨号池，返回 false 表示排队已满、连接已被关闭
+func (c *Client) dispatchNewConn(ctx context.Context, frame *proto.Frame) bool {
+	p := c.dialPool
+	if int(p.waiting.Add(1)) > p.queue {
+		p.waiting.Add(-1)
+		p.rejected.Add(1)
+		p.rejectLog.printf("等待拨号本地服务的连接已达上限 (%d)，关闭新连接: connID=%d", p.queue, frame.ConnID)
+		c.sendCloseFrame(frame.ConnID, "-", proto.CloseUnavailable)
+		return false
+	}
+	p.pending.Store(frame.ConnID, &pendingDial{})
+	go func() {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			p.waiting.Add(-1)
+			p.pending.Delete(frame.ConnID)
+			return
+		}
+		p.waiting.Add(-1)
+		defer func() { <-p.slots }()
+		// 拨号失败时丢弃缓存的数据（成功时 settleDial 已移除）
+		defer p.pending.Delete(frame.ConnID)
+		if err := c.handleNewConn(ctx, frame); err != nil {
+			c.frameStats.inc(frame.Type, frameDialError)
+			log.Printf("处理帧错误 (connID=%d): %v", frame.ConnID, err)
+			return
+		}
+		c.frameStats.inc(frame.Type, frameOK)
+	}()
+	return true
+}
+
+// settleDial 本地连接建立后写入缓存的 DATA 并将连接存入 connMap，返回 false 表示连接在拨号期间已被取消（调用方关闭本地连接）
+// 未启用拨号池时直接存入 connMap
+func (c *Client) settleDial(connID uint32, tc *trackedConn) (bool, error) {
+	if c.dialPool == nil {
+		c.connMap.Store(connID, tc)
+		return true, nil
+	}
+	value, ok := c.dialPool.pending.Load(connID)
+	if !ok {
+		return false, nil
+	}
+	pd := value.(*pendingDial)
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	c.dialPool.pending.Delete(connID)
+	if pd.canceled {
+		return false, nil
+	}
+	for _, data := range pd.data {
+		if _, err := tc.Write(data); err != nil {
+			return false, err
+		}
+		c.consumeWindow(connID, tc, len(data))
+	}
+	pd.data = nil
+	// 持锁期间存入 connMap：读取循环随后的 DATA/CLOSE_CONN 要么在这之前进入缓存，要么在这之后直接找到本地连接
+	c.connMap.Store(connID, tc)
+	pd.settled = true
+	return true, nil
+}
+
+// pendingData 缓存本地连接建立之前收到的 DATA 帧，返回处理结果（用于帧计数）；
+// connID 不在拨号池中或本地连接恰好已建立时 buffered 为 false（调用方重新查找 connMap）
+func (c *Client) pendingData(frame *proto.Frame) (outcome string, buffered bool) {
+	value, ok := c.dialPool.pending.Load(frame.ConnID)
+	if !ok {
+		return "", false
+	}
+	pd := value.(*pendingDial)
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	if pd.settled {
+		return "", false
+	}
+	if pd.canceled {
+		return frameUnknownConn, true
+	}
+	if pd.size+len(frame.Payload) > pendingDataLimit {
+		// 本地连接迟迟没有建立，不再缓存，关闭该连接
+		pd.canceled = true
+		pd.data = nil
+		c.dialPool.pending.Delete(frame.ConnID)
+		log.Printf("本地连接建立之前缓存的数据超过 %d 字节，关闭连接: connID=%d", pendingDataLimit, frame.ConnID)
+		c.sendCloseFrame(frame.ConnID, "-", proto.CloseError)
+		return frameWriteError, true
+	}
+	if len(frame.Payload) > 0 {
+		pd.data = append(pd.data, frame.Payload)
+		pd.size += len(frame.Payload)
+	}
+	return frameOK, true
+}
+
+// cancelPendingDial 处理拨号完成之前收到的 CLOSE_CONN：取消该连接并回发 CLOSE_CONN，connID 不在拨号池中时返回 false
+func (c *Client) cancelPendingDial(frame *proto.Frame) bool {
+	value, ok := c.dialPool.pending.Load(frame.ConnID)
+	if !ok {
+		return false
+	}
+	pd := value.(*pendingDial)
+	pd.mu.Lock()
+	if pd.settled {
+		pd.mu.Unlock()
+		return false
+	}
+	alreadyCanceled := pd.canceled
+	pd.canceled = true
+	pd.data = nil
+	c.dialPool.pending.Delete(frame.ConnID)
+	pd.mu.Unlock()
+	if !alreadyCanceled {
+		log.Printf("本地连接建立之前收到 CLOSE_CONN 帧，取消连接: connID=%d", frame.ConnID)
+		c.sendCloseFrame(frame.ConnID, "-", proto.DecodeCloseReason(frame.Payload))
+	}
+	return true
+}
+
+// writeMetrics 以 Prometheus 文本格式写入正在拨号、排队等待拨号和因排队已满被关闭的连接数
+func (p *dialPool) writeMetrics(buf *bytes.Buffer) {
+	buf.WriteString("# HELP reverse_tunnel_local_dials_active Local service dials in progress.\n")
+	buf.WriteString("# TYPE reverse_tunnel_local_dials_active gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_local_dials_active %d\n", len(p.slots))
+	buf.WriteString("# HELP reverse_tunnel_local_dials_waiting NEW_CONN requests waiting for a local dial slot.\n")
+	buf.WriteString("# TYPE reverse_tunnel_local_dials_waiting gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_local_dials_waiting %d\n", p.waiting.Load())
+	buf.WriteString("# HELP reverse_tunnel_local_dials_rejected_total NEW_CONN requests closed because the local dial queue was full.\n")
+	buf.WriteString("# TYPE reverse_tunnel_local_dials_rejected_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_local_dials_rejected_total %d\n", p.rejected.Load())
+}
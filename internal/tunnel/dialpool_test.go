@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gateDialer 拨号阻塞到 gate 关闭后再转交给 next，并统计同时进行的拨号数的最大值
+type gateDialer struct {
+	gate   chan struct{}
+	next   Dialer
+	active atomic.Int32
+	max    atomic.Int32
+}
+
+func (d *gateDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	n := d.active.Add(1)
+	defer d.active.Add(-1)
+	for {
+		max := d.max.Load()
+		if n <= max || d.max.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	<-d.gate
+	return d.next.DialContext(ctx, network, address)
+}
+
+// TestLocalDialWorkers 测试拨号池限制同时拨号本地服务的数量，超出的连接排队等待；
+// 本地连接建立之前外部连接发送的数据被缓存，建立后按顺序转发
+func TestLocalDialWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	dialer := &gateDialer{gate: make(chan struct{}), next: local}
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+	go server.Run(ctx)
+	client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(dialer), WithLocalDialWorkers(2, 0))
+	go client.Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	const conns = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, conns)
+	for i := 0; i < conns; i++ {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer conn.Close()
+		wg.Add(1)
+		go func(i int, conn net.Conn) {
+			defer wg.Done()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			msg := []byte(fmt.Sprintf("ping-%d", i))
+			// 本地连接尚未建立时发送的数据
+			if _, err := conn.Write(msg); err != nil {
+				errs <- err
+				return
+			}
+			got := make([]byte, len(msg))
+			if _, err := io.ReadFull(conn, got); err != nil || string(got) != string(msg) {
+				errs <- fmt.Errorf("连接 %d 回显 %q: %v", i, got, err)
+			}
+		}(i, conn)
+	}
+
+	waitStat(t, "排队等待拨号的连接数", func() string { return strconv.Itoa(int(client.dialPool.waiting.Load())) }, strconv.Itoa(conns-2))
+	if n := dialer.active.Load(); n != 2 {
+		t.Errorf("应有 2 个拨号同时进行, 得到 %d", n)
+	}
+	close(dialer.gate)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if max := dialer.max.Load(); max > 2 {
+		t.Errorf("同时进行的拨号数不应超过 2, 得到 %d", max)
+	}
+}
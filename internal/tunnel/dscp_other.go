@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package tunnel
+
+import "syscall"
+
+// setSocketDSCP 当前平台不支持通过 setsockopt 设置 DSCP（例如 Windows 需要使用 QoS 策略），不做任何处理
+func setSocketDSCP(network string, c syscall.RawConn, dscp int) error {
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package tunnel
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setSocketDSCP 在连接/监听前设置 socket 的 DSCP 标记（0 表示不标记）：IPv4 socket 设置 IP_TOS，
+// IPv6 socket 设置 IPV6_TCLASS，并尽量同时设置 IP_TOS（双栈 socket 上的 IPv4 映射地址按 IP_TOS 标记，内核不支持时忽略）
+func setSocketDSCP(network string, c syscall.RawConn, dscp int) error {
+	if dscp <= 0 {
+		return nil
+	}
+	tos := dscp << 2 // DSCP 占 TOS/Traffic Class 字节的高 6 位，低 2 位为 ECN
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if network == "tcp6" || network == "udp6" {
+			if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos); err != nil {
+				sockErr = fmt.Errorf("设置 socket 的 IPV6_TCLASS 失败: %w", err)
+				return
+			}
+			_ = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+			return
+		}
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos); err != nil {
+			sockErr = fmt.Errorf("设置 socket 的 IP_TOS 失败: %w", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// String 返回连接状态的名称（用于状态快照）
+func (s connState) String() string {
+	switch s {
+	case connOpen:
+		return "open"
+	case connLocalClosed:
+		return "local_closed"
+	case connRemoteClosed:
+		return "remote_closed"
+	case connClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// connSnapshot 一个 connID 的状态快照
+type connSnapshot struct {
+	id       uint32
+	state    connState
+	traceID  string
+	age      time.Duration
+	idle     time.Duration
+	bytesIn  uint64
+	bytesOut uint64
+}
+
+// snapshotConn 读取连接的当前状态（持有 stateMu 读取关闭状态，字节数为原子读取）
+func snapshotConn(id uint32, tc *trackedConn, now time.Time) connSnapshot {
+	tc.stateMu.Lock()
+	state := tc.state
+	tc.stateMu.Unlock()
+	return connSnapshot{
+		id:       id,
+		state:    state,
+		traceID:  tc.traceID,
+		age:      now.Sub(tc.start).Truncate(time.Millisecond),
+		idle:     tc.idleSince(now).Truncate(time.Millisecond),
+		bytesIn:  atomic.LoadUint64(&tc.bytesIn),
+		bytesOut: atomic.LoadUint64(&tc.bytesOut),
+	}
+}
+
+// DumpState 将所有客户端的状态快照以文本形式写入 w，用于在线排查问题（cmd/server 收到 SIGUSR1 时写入日志）：
+// 每个客户端的 ID、身份、控制连接对端地址、公开端口、主机名、特性协商结果和控制连接写入队列，
+// 以及其每个 connID 的关闭状态、追踪 ID、存活/空闲时间和两个方向的字节数（in 为从公开连接读取，out 为写入公开连接）。
+// 快照在持有 clientsMu 读锁时生成，写入 w 在释放锁之后进行，缓慢的 w 不会阻塞客户端注册和路由
+func (s *Server) DumpState(w io.Writer) error {
+	now := time.Now()
+	s.publicListenerMu.Lock()
+	globalPort := 0
+	if s.publicListener != nil {
+		globalPort = listenerPort(s.publicListener)
+	}
+	s.publicListenerMu.Unlock()
+
+	var buf bytes.Buffer
+	s.clientsMu.RLock()
+	infos := make([]*ClientInfo, 0, len(s.clients))
+	for _, info := range s.clients {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	fmt.Fprintf(&buf, "状态快照: 时间=%s, goroutine=%d, 客户端=%d\n", now.Format(time.RFC3339), runtime.NumGoroutine(), len(infos))
+	for _, info := range infos {
+		s.dumpClient(&buf, info, globalPort, now)
+	}
+	s.clientsMu.RUnlock()
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// dumpClient 写入一个客户端及其连接的快照，调用方持有 clientsMu 读锁
+func (s *Server) dumpClient(buf *bytes.Buffer, info *ClientInfo, globalPort int, now time.Time) {
+	remoteAddr := "-"
+	if info.Conn != nil {
+		remoteAddr = info.Conn.RemoteAddr().String()
+	}
+	port := globalPort
+	if info.PublicListener != nil {
+		port = listenerPort(info.PublicListener)
+	} else if info.RemotePort != 0 {
+		port = 0 // 请求的远程端口尚未绑定
+	}
+	identity, hostnames := info.Identity, strings.Join(info.Hostnames, ",")
+	if identity == "" {
+		identity = "-"
+	}
+	if hostnames == "" {
+		hostnames = "-"
+	}
+	blocked, queue, queueMax := info.writeMu.writeStats()
+
+	var conns []connSnapshot
+	info.ConnMap.Range(func(key, value interface{}) bool {
+		if tc, ok := value.(*trackedConn); ok {
+			conns = append(conns, snapshotConn(key.(uint32), tc, now))
+		}
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+
+	fmt.Fprintf(buf, "客户端 %s: 身份=%s, 对端=%s, 远程端口=%d, 公开端口=%d, 主机名=%s, 已连接=%v, 特性=%s, 最近 connID=%d, 健康=%v, 写入队列=%d/%d (累计阻塞 %.3fs), 连接=%d\n",
+		info.ID, identity, remoteAddr, info.RemotePort, port, hostnames, now.Sub(info.ConnectedAt).Truncate(time.Second),
+		info.Features, atomic.LoadUint32(&info.NextConnID), !info.unhealthy.Load(), queue, queueMax, blocked, len(conns))
+	for _, c := range conns {
+		fmt.Fprintf(buf, "  connID=%d 状态=%s trace=%s 存活=%v 空闲=%v in=%d out=%d\n",
+			c.id, c.state, c.traceID, c.age, c.idle, c.bytesIn, c.bytesOut)
+	}
+}
@@ -0,0 +1,48 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDumpState 测试状态快照包含每个客户端及其连接的状态和字节数
+func TestDumpState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	public, server, _ := startFaultTunnel(ctx, t, nil)
+	waitStat(t, "已注册的客户端", func() int { return len(server.ClientStatus()) }, 1)
+
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	conn.Write([]byte("hello"))
+	if _, err := conn.Read(make([]byte, 5)); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+
+	// 写入公开连接的字节数在 Write 返回后累计，等待回显计入快照
+	connLine := regexp.MustCompile(`connID=1 状态=open trace=[0-9a-f]{32} .* in=5 out=5`)
+	var dump string
+	waitStat(t, "快照中的连接状态和字节数", func() bool {
+		var buf bytes.Buffer
+		if err := server.DumpState(&buf); err != nil {
+			t.Fatalf("DumpState 失败: %v", err)
+		}
+		dump = buf.String()
+		return connLine.MatchString(dump)
+	}, true)
+	clientID := server.ClientStatus()[0].ID
+	for _, want := range []string{"客户端=1", "客户端 " + clientID + ": ", "连接=1"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("快照应包含 %q:\n%s", want, dump)
+		}
+	}
+}
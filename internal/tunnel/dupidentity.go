@@ -0,0 +1,50 @@
+package tunnel
+
+import (
+	"errors"
+	"log"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 同一身份（客户端证书 CN）的客户端重复连接时的策略
+const (
+	DuplicateIdentityAllow      = "allow"       // 允许同时在线（默认）
+	DuplicateIdentityRejectNew  = "reject-new"  // 拒绝新连接的客户端
+	DuplicateIdentityReplaceOld = "replace-old" // 断开已在线的客户端，由新客户端取代
+)
+
+// errDuplicateIdentity 身份已有客户端在线，按 DuplicateIdentityRejectNew 拒绝新客户端
+var errDuplicateIdentity = errors.New("客户端身份已在线")
+
+// duplicatesLocked 返回与 identity 相同的在线客户端 ID（调用方需持有 clientsMu）
+// 空身份（未启用 mTLS 或没有证书）不视为重复
+func (s *Server) duplicatesLocked(identity string) []string {
+	if identity == "" {
+		return nil
+	}
+	var ids []string
+	for id, info := range s.clients {
+		if info.Identity == identity {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// replaceDuplicates 按 DuplicateIdentityReplaceOld 断开被新客户端取代的在线客户端：
+// 先发送 ERROR 告知原因，再注销（释放其公开端口和主机名，新客户端随后的 INIT 即可绑定）
+func (s *Server) replaceDuplicates(identity, newClientID string, old []string) {
+	for _, clientID := range old {
+		s.clientsMu.RLock()
+		info, ok := s.clients[clientID]
+		s.clientsMu.RUnlock()
+		if !ok {
+			continue
+		}
+		log.Printf("客户端身份 %q 重复，按策略 %s 断开原客户端: %s (新客户端 %s)", identity, DuplicateIdentityReplaceOld, clientID, newClientID)
+		frame := &proto.Frame{Type: proto.FrameTypeERROR, Payload: []byte("相同身份的客户端已连接，本连接被取代")}
+		writeFrame(info.Conn, &info.writeMu, frame, s.writeTimeout())
+		s.unregisterClient(clientID)
+	}
+}
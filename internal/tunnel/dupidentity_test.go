@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// certConn 带有客户端证书身份的控制连接（模拟 mTLS）
+type certConn struct {
+	net.Conn
+	cn string
+}
+
+func (c *certConn) PeerCertificate() (*x509.Certificate, error) {
+	return &x509.Certificate{Subject: pkix.Name{CommonName: c.cn}}, nil
+}
+
+// TestDuplicateIdentityPolicy 测试相同身份的客户端重复连接时三种策略的行为，以及不同身份和没有身份的客户端不受影响
+func TestDuplicateIdentityPolicy(t *testing.T) {
+	register := func(t *testing.T, server *Server, cn string) (string, net.Conn, error) {
+		serverSide, clientSide := net.Pipe()
+		t.Cleanup(func() { clientSide.Close() })
+		var conn net.Conn = serverSide
+		if cn != "" {
+			conn = &certConn{Conn: serverSide, cn: cn}
+		}
+		clientID, err := server.registerClient(conn)
+		return clientID, clientSide, err
+	}
+	registered := func(server *Server) int {
+		server.clientsMu.RLock()
+		defer server.clientsMu.RUnlock()
+		return len(server.clients)
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		server := NewServer("127.0.0.1:0", "")
+		for i := 0; i < 2; i++ {
+			if _, _, err := register(t, server, "alice"); err != nil {
+				t.Fatalf("默认策略应允许重复身份: %v", err)
+			}
+		}
+		if n := registered(server); n != 2 {
+			t.Errorf("应有 2 个客户端在线, 得到 %d", n)
+		}
+	})
+
+	t.Run("reject-new", func(t *testing.T) {
+		server := NewServer("127.0.0.1:0", "", WithServerDuplicateIdentityPolicy(DuplicateIdentityRejectNew))
+		first, _, err := register(t, server, "alice")
+		if err != nil {
+			t.Fatalf("注册客户端失败: %v", err)
+		}
+		if _, _, err := register(t, server, "alice"); !errors.Is(err, errDuplicateIdentity) {
+			t.Fatalf("重复身份应被拒绝, 得到 %v", err)
+		}
+		for _, cn := range []string{"bob", "", ""} {
+			if _, _, err := register(t, server, cn); err != nil {
+				t.Fatalf("身份 %q 不应被拒绝: %v", cn, err)
+			}
+		}
+		if n := registered(server); n != 4 {
+			t.Errorf("应有 4 个客户端在线, 得到 %d", n)
+		}
+
+		// 原客户端断开后相同身份可以重新连接
+		server.unregisterClient(first)
+		if _, _, err := register(t, server, "alice"); err != nil {
+			t.Fatalf("原客户端断开后应允许重新连接: %v", err)
+		}
+	})
+
+	t.Run("replace-old", func(t *testing.T) {
+		server := NewServer("127.0.0.1:0", "", WithServerDuplicateIdentityPolicy(DuplicateIdentityReplaceOld))
+		first, firstConn, err := register(t, server, "alice")
+		if err != nil {
+			t.Fatalf("注册客户端失败: %v", err)
+		}
+		errFrame := make(chan *proto.Frame, 1)
+		go func() {
+			frame, _ := proto.DecodeFrame(firstConn)
+			errFrame <- frame
+			io.Copy(io.Discard, firstConn)
+		}()
+		second, _, err := register(t, server, "alice")
+		if err != nil {
+			t.Fatalf("新客户端应取代原客户端: %v", err)
+		}
+
+		server.clientsMu.RLock()
+		_, firstOK := server.clients[first]
+		_, secondOK := server.clients[second]
+		server.clientsMu.RUnlock()
+		if firstOK || !secondOK {
+			t.Fatalf("原客户端应被注销、新客户端保留: 原=%v 新=%v", firstOK, secondOK)
+		}
+		select {
+		case frame := <-errFrame:
+			if frame == nil || frame.Type != proto.FrameTypeERROR {
+				t.Errorf("原客户端应收到 ERROR 帧, 得到 %+v", frame)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("原客户端未收到 ERROR 帧")
+		}
+	})
+}
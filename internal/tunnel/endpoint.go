@@ -0,0 +1,51 @@
+package tunnel
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// defaultPublicEndpointGrace 服务器未配置全局公开端口时，控制连接建立后默认等待客户端请求远程端口的时间，
+// 超过后仍没有公开端口的客户端被视为不可达（记录警告，启用 requirePublicEndpoint 时断开）
+const defaultPublicEndpointGrace = 10 * time.Second
+
+// errNoPublicEndpoint 拒绝没有公开入口的客户端时回复的 ERROR 负载
+const errNoPublicEndpoint = "服务器未启用全局公开端口，客户端必须指定远程端口"
+
+// checkPublicEndpoint 服务器未配置全局公开端口时，检查客户端在 publicEndpointGrace 内是否请求了远程端口
+// （只请求主机名路由同样无法到达）。没有公开入口的客户端不会收到任何公开连接，记录警告，
+// 启用 requirePublicEndpoint 时回复 ERROR 并断开控制连接
+func (s *Server) checkPublicEndpoint(clientID string, conn net.Conn, done <-chan struct{}) {
+	publicEndpointGrace := s.publicEndpointGrace
+	if publicEndpointGrace <= 0 {
+		publicEndpointGrace = defaultPublicEndpointGrace
+	}
+	timer := time.NewTimer(publicEndpointGrace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	reachable := ok && clientInfo.PublicListener != nil
+	s.clientsMu.RUnlock()
+	if !ok || reachable {
+		return
+	}
+	if !s.requirePublicEndpoint {
+		log.Printf("警告: 客户端 %s 没有可达的公开入口（服务器未配置全局公开端口，客户端 %v 内未请求远程端口），不会收到任何公开连接", clientID, publicEndpointGrace)
+		return
+	}
+	log.Printf("客户端 %s 在 %v 内未请求远程端口且服务器未配置全局公开端口，断开控制连接", clientID, publicEndpointGrace)
+	frame := &proto.Frame{Type: proto.FrameTypeERROR, Payload: []byte(errNoPublicEndpoint)}
+	if err := writeFrame(conn, &clientInfo.writeMu, frame, s.writeTimeout()); err != nil {
+		log.Printf("发送错误响应失败 (clientID=%s): %v", clientID, err)
+	}
+	conn.Close()
+}
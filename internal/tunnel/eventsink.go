@@ -0,0 +1,206 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 生命周期事件导出的队列容量、每批事件数、攒批的最长等待时间和 webhook 请求超时
+const (
+	eventQueueSize      = 1024
+	eventBatchSize      = 100
+	eventFlushInterval  = time.Second
+	eventWebhookTimeout = 5 * time.Second
+)
+
+// 公开连接的生命周期事件类型（LifecycleEvent.Kind）；客户端的事件沿用 EVENT 帧的类型（connected、ready、disconnected、error）
+const (
+	EventConnOpen  = "conn_open"  // 已向客户端发送 NEW_CONN
+	EventConnClose = "conn_close" // 公开连接已结束（携带字节数、时长和关闭原因）
+)
+
+// LifecycleEvent 导出到 EventSink 的隧道生命周期事件（webhook 请求体中 JSON 数组的元素）
+type LifecycleEvent struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	ClientID string    `json:"client_id,omitempty"`
+	Identity string    `json:"identity,omitempty"` // 客户端身份（证书 CN，非 TLS 连接为空）
+	Addr     string    `json:"addr,omitempty"`     // connected 为控制连接的远程地址，ready 为公开监听地址
+	Detail   string    `json:"detail,omitempty"`   // error 的原因
+
+	// 公开连接事件（conn_open、conn_close）
+	ConnID      uint32 `json:"conn_id,omitempty"`
+	TraceID     string `json:"trace_id,omitempty"`
+	Source      string `json:"source,omitempty"`
+	BytesIn     uint64 `json:"bytes_in,omitempty"`
+	BytesOut    uint64 `json:"bytes_out,omitempty"`
+	DurationMs  int64  `json:"duration_ms,omitempty"`
+	CloseReason string `json:"close_reason,omitempty"`
+}
+
+// EventSink 接收一批生命周期事件，例如 POST 到 webhook（WebhookEventSink）或发布到消息队列
+// Publish 在单独的 goroutine 中按事件顺序调用，不持有服务器的锁；返回错误时这批事件被丢弃（记录日志和计数），不重试
+type EventSink interface {
+	Publish(ctx context.Context, events []LifecycleEvent) error
+}
+
+// WebhookEventSink 把一批事件以 JSON 数组 POST 到 URL 的 EventSink，非 2xx 响应视为失败
+type WebhookEventSink struct {
+	URL    string
+	Client *http.Client // nil 表示使用超时 5 秒的客户端
+}
+
+// NewWebhookEventSink 创建 POST 到 url 的 EventSink
+func NewWebhookEventSink(url string) *WebhookEventSink {
+	return &WebhookEventSink{URL: url, Client: &http.Client{Timeout: eventWebhookTimeout}}
+}
+
+// Publish 发送一批事件
+func (w *WebhookEventSink) Publish(ctx context.Context, events []LifecycleEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: eventWebhookTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook 返回 HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// eventExporter 把生命周期事件放入队列，由 run 攒批后交给 EventSink
+// 队列已满时丢弃新事件、Publish 失败时丢弃整批事件，都只计数并限频记录日志，从不阻塞控制连接和转发路径
+type eventExporter struct {
+	sink  EventSink
+	queue chan LifecycleEvent
+
+	published atomic.Uint64 // 已成功发布的事件数
+	dropped   atomic.Uint64 // 队列已满而丢弃的事件数
+	failed    atomic.Uint64 // Publish 失败而丢弃的事件数
+	dropLog   rateLimitedLog
+	failLog   rateLimitedLog
+}
+
+// newEventExporter 创建事件导出器，sink 为 nil 时返回 nil（不导出）
+func newEventExporter(sink EventSink) *eventExporter {
+	if sink == nil {
+		return nil
+	}
+	return &eventExporter{sink: sink, queue: make(chan LifecycleEvent, eventQueueSize)}
+}
+
+// emit 将事件放入队列（不阻塞调用方），未设置时间时使用当前时间
+func (e *eventExporter) emit(ev LifecycleEvent) {
+	if e == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case e.queue <- ev:
+	default:
+		e.dropped.Add(1)
+		e.dropLog.printf("生命周期事件队列已满，丢弃事件 (%s, clientID=%s)", ev.Kind, ev.ClientID)
+	}
+}
+
+// emitProto 导出推送给观察者的客户端事件
+func (e *eventExporter) emitProto(ev *proto.Event) {
+	if e == nil {
+		return
+	}
+	e.emit(LifecycleEvent{Kind: ev.Kind, ClientID: ev.ClientID, Identity: ev.Identity, Addr: ev.Addr, Detail: ev.Detail})
+}
+
+// run 攒批发布队列中的事件：攒满 eventBatchSize 个或距第一个事件超过 eventFlushInterval 时发布一批，
+// 直到 ctx 结束；结束时在 eventWebhookTimeout 内发布已排队的事件
+func (e *eventExporter) run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	batch := make([]LifecycleEvent, 0, eventBatchSize)
+	timer := time.NewTimer(eventFlushInterval)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			for drained := false; !drained; {
+				select {
+				case ev := <-e.queue:
+					batch = append(batch, ev)
+				default:
+					drained = true
+				}
+			}
+			flushCtx, cancel := context.WithTimeout(context.Background(), eventWebhookTimeout)
+			for len(batch) > 0 {
+				n := min(len(batch), eventBatchSize)
+				e.publish(flushCtx, batch[:n])
+				batch = batch[n:]
+			}
+			cancel()
+			return
+		case ev := <-e.queue:
+			if len(batch) == 0 {
+				timer.Reset(eventFlushInterval)
+			}
+			batch = append(batch, ev)
+			if len(batch) < eventBatchSize {
+				continue
+			}
+			timer.Stop()
+		case <-timer.C:
+		}
+		e.publish(ctx, batch)
+		batch = make([]LifecycleEvent, 0, eventBatchSize)
+	}
+}
+
+// publish 发布一批事件，失败时丢弃并计数
+func (e *eventExporter) publish(ctx context.Context, batch []LifecycleEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := e.sink.Publish(ctx, batch); err != nil {
+		e.failed.Add(uint64(len(batch)))
+		e.failLog.printf("发布生命周期事件失败，丢弃 %d 个事件: %v", len(batch), err)
+		return
+	}
+	e.published.Add(uint64(len(batch)))
+}
+
+// writeMetrics 以 Prometheus 文本格式写入已发布和丢弃的事件数（未启用导出时不输出）
+func (e *eventExporter) writeMetrics(buf *bytes.Buffer) {
+	if e == nil {
+		return
+	}
+	buf.WriteString("# HELP reverse_tunnel_lifecycle_events_published_total Lifecycle events delivered to the event sink.\n")
+	buf.WriteString("# TYPE reverse_tunnel_lifecycle_events_published_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_lifecycle_events_published_total %d\n", e.published.Load())
+	buf.WriteString("# HELP reverse_tunnel_lifecycle_events_dropped_total Lifecycle events dropped, by reason (queue_full, publish_failed).\n")
+	buf.WriteString("# TYPE reverse_tunnel_lifecycle_events_dropped_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_lifecycle_events_dropped_total{reason=\"queue_full\"} %d\n", e.dropped.Load())
+	fmt.Fprintf(buf, "reverse_tunnel_lifecycle_events_dropped_total{reason=\"publish_failed\"} %d\n", e.failed.Load())
+}
@@ -0,0 +1,121 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestEventWebhook 测试客户端注册和公开连接的建立/结束以批量事件 POST 到 webhook
+func TestEventWebhook(t *testing.T) {
+	events := make(chan LifecycleEvent, 100)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []LifecycleEvent
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("解析 webhook 事件失败: %v", err)
+		}
+		for _, ev := range batch {
+			events <- ev
+		}
+	}))
+	defer hook.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerEventSink(NewWebhookEventSink(hook.URL)))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	go conn.Write([]byte("ping"))
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("回显失败: %v", err)
+	}
+	conn.Close()
+
+	want := []string{"connected", EventConnOpen, EventConnClose}
+	var got []LifecycleEvent
+	timeout := time.After(5 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case ev := <-events:
+			got = append(got, ev)
+		case <-timeout:
+			t.Fatalf("只收到 %d 个事件: %+v", len(got), got)
+		}
+	}
+	for i, kind := range want {
+		if got[i].Kind != kind || got[i].ClientID != "client-1" || got[i].Time.IsZero() {
+			t.Errorf("第 %d 个事件应为 client-1 的 %s, 得到 %+v", i, kind, got[i])
+		}
+	}
+	if ev := got[2]; ev.ConnID != got[1].ConnID || ev.BytesIn != 4 || ev.BytesOut != 4 || ev.CloseReason == "" {
+		t.Errorf("conn_close 事件应携带连接的字节数和关闭原因: %+v", ev)
+	}
+}
+
+// failingSink 总是发布失败的 EventSink
+type failingSink struct{ calls chan int }
+
+func (s failingSink) Publish(ctx context.Context, events []LifecycleEvent) error {
+	s.calls <- len(events)
+	return errors.New("unavailable")
+}
+
+// TestEventExporterDrop 测试队列已满时丢弃新事件而不阻塞，发布失败时丢弃整批事件
+func TestEventExporterDrop(t *testing.T) {
+	sink := failingSink{calls: make(chan int, 100)}
+	e := newEventExporter(sink)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < eventQueueSize+10; i++ {
+			e.emit(LifecycleEvent{Kind: EventConnOpen})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("队列已满时 emit 不应阻塞")
+	}
+	if got := e.dropped.Load(); got != 10 {
+		t.Errorf("应丢弃 10 个事件, 得到 %d", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		e.run(ctx)
+		close(stopped)
+	}()
+	waitStat(t, "发布失败的事件数", func() string {
+		if e.failed.Load() >= eventBatchSize {
+			return "ok"
+		}
+		return ""
+	}, "ok")
+	cancel()
+	<-stopped
+	if got := e.failed.Load(); got != eventQueueSize {
+		t.Errorf("发布失败应丢弃全部 %d 个事件, 得到 %d", eventQueueSize, got)
+	}
+	if got := e.published.Load(); got != 0 {
+		t.Errorf("已发布的事件数应为 0, 得到 %d", got)
+	}
+}
@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// publicFallbackReadTimeout 回复静态页面之前读取外部连接 HTTP 请求头的最长时间
+const publicFallbackReadTimeout = 5 * time.Second
+
+// publicFallbackMaxHeaderBytes 回复静态页面之前最多读取的请求头字节数，超过时直接关闭连接
+const publicFallbackMaxHeaderBytes = 16 << 10
+
+// PublicFallback 全局公开端口没有可用客户端时回复给外部连接的静态 HTTP 页面（例如维护页），由 NewPublicFallback 创建
+type PublicFallback struct {
+	response []byte // 完整的 HTTP 响应
+	head     []byte // HEAD 请求的响应（不含响应体）
+}
+
+// NewPublicFallback 读取 bodyFile 作为响应体创建静态页面，status 为 0 时使用 503，contentType 为空时使用 text/html; charset=utf-8
+func NewPublicFallback(status int, contentType, bodyFile string) (*PublicFallback, error) {
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	if status < 200 || status > 599 {
+		return nil, fmt.Errorf("无效的静态页面状态码: %d（必须在 200-599 之间）", status)
+	}
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	body, err := os.ReadFile(bodyFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取静态页面失败: %w", err)
+	}
+	head := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Type: %s\r\nContent-Length: %d\r\nCache-Control: no-store\r\nConnection: close\r\n\r\n",
+		status, http.StatusText(status), contentType, len(body))
+	return &PublicFallback{
+		response: append([]byte(head), body...),
+		head:     []byte(head),
+	}, nil
+}
+
+// servePublicFallback 读取外部连接的 HTTP 请求头后回复静态页面并关闭连接；不是 HTTP 请求（或超时、超过 publicFallbackMaxHeaderBytes
+// 仍未发送完整请求头）时直接关闭。先读取请求再回复，对端不会因为未读的请求数据收到 RST 而丢失响应，HEAD 请求也能得到不含响应体的响应。
+// 读取请求头最长需要 publicFallbackReadTimeout，调用方应在单独的 goroutine 中调用，避免占用处理公开连接的 worker
+func (s *Server) servePublicFallback(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(publicFallbackReadTimeout))
+	req, err := http.ReadRequest(bufio.NewReader(io.LimitReader(conn, publicFallbackMaxHeaderBytes)))
+	if err != nil {
+		return
+	}
+	resp := s.publicFallback.response
+	if req.Method == http.MethodHead {
+		resp = s.publicFallback.head
+	}
+	conn.SetWriteDeadline(time.Now().Add(publicErrorWriteTimeout))
+	if _, err := conn.Write(resp); err != nil {
+		log.Printf("向外部连接回复静态页面失败: %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	s.publicFallbackServed.Add(1)
+}
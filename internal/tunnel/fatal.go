@@ -0,0 +1,81 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"reverse-tunnel/internal/pqctls"
+)
+
+// 客户端遇到的致命错误：重连也会以同样的原因失败（身份被拒绝、证书无效、协议不兼容），见 IsFatal
+var (
+	// ErrServerRejected 服务器在注册阶段拒绝了客户端（身份未被配额策略允许等），收到的 ERROR 早于特性协商响应
+	ErrServerRejected = errors.New("服务器拒绝了客户端")
+	// ErrProtocolIncompatible 与服务器的协议不兼容（缺少必需的协议特性，或服务器不支持特性协商）
+	ErrProtocolIncompatible = errors.New("与服务器的协议不兼容")
+)
+
+// transientRejections 服务器在注册阶段拒绝、但稍后重连可能成功的原因（ERROR 负载的前缀）：
+// 同一身份的旧连接尚未注销，或来源因反复发送无法解码的帧被暂时拒绝
+var transientRejections = []error{errDuplicateIdentity, errDecodeBlocked}
+
+// unreachableError 配置了多个服务器且全部连接失败时 connectToServer 返回的错误，包装每个服务器的错误。
+// 只有每个服务器都因致命错误失败时才是致命错误：其中一个服务器证书指纹不符而其他服务器只是暂时不可达时仍应重连
+type unreachableError struct {
+	err   error
+	fatal bool
+}
+
+func (e *unreachableError) Error() string { return e.err.Error() }
+func (e *unreachableError) Unwrap() error { return e.err }
+
+// IsFatal 判断 err 是否为不会因重连而恢复的错误：服务器拒绝了客户端、协议不兼容，
+// 或 PQC TLS 的证书文件缺失或无效、对端证书未通过验证、未协商 PQC 算法、服务器证书指纹不符。
+// 网络错误、超时和其他错误都视为暂时的；多个服务器全部连接失败时，只有每个服务器的错误都是致命错误才返回 true
+func IsFatal(err error) bool {
+	var unreachable *unreachableError
+	if errors.As(err, &unreachable) {
+		return unreachable.fatal
+	}
+	for _, target := range []error{
+		ErrServerRejected,
+		ErrProtocolIncompatible,
+		pqctls.ErrCertificateNotFound,
+		pqctls.ErrInvalidCertificate,
+		pqctls.ErrCertificateRejected,
+		pqctls.ErrNonPQC,
+		pqctls.ErrFingerprintMismatch,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverRejection 返回服务器回复的 ERROR 对应的错误：已发送 HELLO 但尚未收到特性协商响应时 ERROR 来自注册阶段
+// （服务器总是先回复 HELLO 再处理 INIT），除暂时的原因外包装为 ErrServerRejected；否则返回 nil，
+// 由调用方按原来的含义（如拒绝隧道配置）处理。不回复 HELLO 的旧版本服务器的所有 ERROR 都按注册阶段的拒绝处理
+func (c *Client) serverRejection(payload []byte) error {
+	if !c.helloSent.Load() || c.helloReceived.Load() {
+		return nil
+	}
+	reason := string(payload)
+	for _, transient := range transientRejections {
+		if strings.HasPrefix(reason, transient.Error()) {
+			return fmt.Errorf("服务器暂时拒绝了客户端: %s", reason)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrServerRejected, reason)
+}
+
+// exitOn 启用 exitOnFatal 且 err 为致命错误时记录日志并返回 true，Run 随即返回 err 而不再重连
+func (c *Client) exitOn(err error) bool {
+	if !c.exitOnFatal || !IsFatal(err) {
+		return false
+	}
+	log.Printf("致命错误，不再重连: %v", err)
+	return true
+}
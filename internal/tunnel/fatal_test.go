@@ -0,0 +1,86 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/pqctls"
+)
+
+// TestExitOnFatal 测试启用 WithExitOnFatal 后服务器在注册阶段拒绝客户端时 Run 返回 ErrServerRejected 而不再重连
+func TestExitOnFatal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerPolicy(&PolicyStore{Clients: map[string]ClientQuota{"tenant-a": {}}}))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewClient(controlAddr, "127.0.0.1:1", 0, WithControlDialer(&net.Dialer{}), WithExitOnFatal(true)).Run(ctx)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrServerRejected) || !IsFatal(err) {
+			t.Fatalf("Run 应返回服务器拒绝的致命错误, 得到 %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("服务器拒绝客户端后 Run 应返回")
+	}
+}
+
+// TestIsFatal 测试致命错误与暂时错误的区分（包装后仍可识别）
+func TestIsFatal(t *testing.T) {
+	for _, c := range []struct {
+		err   error
+		fatal bool
+	}{
+		{fmt.Errorf("%w: 策略不允许", ErrServerRejected), true},
+		{fmt.Errorf("协商失败: %w", ErrProtocolIncompatible), true},
+		{fmt.Errorf("连接失败: %w", pqctls.ErrFingerprintMismatch), true},
+		{fmt.Errorf("加载证书: %w", pqctls.ErrCertificateNotFound), true},
+		{pqctls.ErrHandshakeTimeout, false},
+		{io.EOF, false},
+		{context.DeadlineExceeded, false},
+		{nil, false},
+		// 多个服务器中只有一个因致命错误失败时仍然重连
+		{&unreachableError{err: fmt.Errorf("a: %w; b: %w", pqctls.ErrFingerprintMismatch, io.EOF)}, false},
+		{&unreachableError{err: fmt.Errorf("a: %w; b: %w", pqctls.ErrFingerprintMismatch, ErrServerRejected), fatal: true}, true},
+	} {
+		if got := IsFatal(c.err); got != c.fatal {
+			t.Errorf("IsFatal(%v) = %v, 应为 %v", c.err, got, c.fatal)
+		}
+	}
+}
+
+// addrErrDialer 按地址返回固定错误的拨号器
+type addrErrDialer map[string]error
+
+func (d addrErrDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, d[address]
+}
+
+// TestConnectToServerFatal 测试配置了多个服务器时，只有每个服务器都因致命错误失败时连接错误才是致命错误，
+// 各服务器的原因仍可以用 errors.Is 判断
+func TestConnectToServerFatal(t *testing.T) {
+	refused := errors.New("connection refused")
+	for _, c := range []struct {
+		second error
+		fatal  bool
+	}{
+		{refused, false},
+		{fmt.Errorf("验证失败: %w", pqctls.ErrCertificateRejected), true},
+	} {
+		dialer := addrErrDialer{"a:1": fmt.Errorf("验证失败: %w", pqctls.ErrFingerprintMismatch), "b:1": c.second}
+		err := NewClient("a:1,b:1", "127.0.0.1:1", 0, WithControlDialer(dialer)).connectToServer(context.Background())
+		if IsFatal(err) != c.fatal || !errors.Is(err, pqctls.ErrFingerprintMismatch) || !errors.Is(err, c.second) {
+			t.Errorf("第二个服务器的错误为 %v 时应得到 fatal=%v, 得到 %v (%v)", c.second, c.fatal, IsFatal(err), err)
+		}
+	}
+}
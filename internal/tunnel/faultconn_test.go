@@ -0,0 +1,246 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// faultPlan 一个连接上注入的故障
+type faultPlan struct {
+	latency    time.Duration // 每次读写前的延迟（模拟慢速对端或高延迟链路）
+	resetAfter int64         // 本端累计写入达到该字节数时重置连接（0 表示不重置），超出部分不发送
+	drop       bool          // 本端累计写入达到 dropAfter 字节后丢弃之后写入的数据（写入仍返回成功，模拟单向丢包）
+	dropAfter  int64
+}
+
+// faultConn 按 faultPlan 注入故障的 net.Conn
+type faultConn struct {
+	net.Conn
+	plan faultPlan
+
+	mu      sync.Mutex
+	written int64
+}
+
+func (c *faultConn) Read(b []byte) (int, error) {
+	if c.plan.latency > 0 {
+		time.Sleep(c.plan.latency)
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	if c.plan.latency > 0 {
+		time.Sleep(c.plan.latency)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.plan.resetAfter > 0 && c.written+int64(len(b)) >= c.plan.resetAfter {
+		// 只发送到达阈值之前的部分，对端读到不完整的数据后连接被关闭
+		n, _ := c.Conn.Write(b[:c.plan.resetAfter-c.written])
+		c.written += int64(n)
+		c.Conn.Close()
+		return n, &net.OpError{Op: "write", Net: "mem", Err: syscall.ECONNRESET}
+	}
+	if c.plan.drop && c.written+int64(len(b)) > c.plan.dropAfter {
+		keep := max(c.plan.dropAfter-c.written, 0)
+		if keep > 0 {
+			if _, err := c.Conn.Write(b[:keep]); err != nil {
+				return 0, err
+			}
+		}
+		c.written += int64(len(b))
+		return len(b), nil
+	}
+	n, err := c.Conn.Write(b)
+	c.written += int64(n)
+	return n, err
+}
+
+// faultDialer 通过内存监听器拨号，第 i 次拨号的连接注入 plans[i]（之后的连接不注入故障）
+type faultDialer struct {
+	inner *memListener
+
+	mu    sync.Mutex
+	plans []faultPlan
+	dials int
+}
+
+func (d *faultDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.inner.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var plan faultPlan
+	if d.dials < len(d.plans) {
+		plan = d.plans[d.dials]
+	}
+	d.dials++
+	return &faultConn{Conn: conn, plan: plan}, nil
+}
+
+// dialCount 返回拨号次数
+func (d *faultDialer) dialCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+// startFaultTunnel 启动服务器和通过 faultDialer 连接控制端口的客户端（本地服务为回显），返回公开监听器、服务器和拨号器
+// 客户端重连间隔在测试期间缩短为 50 毫秒
+func startFaultTunnel(ctx context.Context, t *testing.T, plans []faultPlan, clientOpts ...ClientOption) (*memListener, *Server, *faultDialer) {
+	t.Helper()
+	oldDelay := reconnectDelay
+	reconnectDelay = 50 * time.Millisecond
+	t.Cleanup(func() { reconnectDelay = oldDelay })
+
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	t.Cleanup(func() { local.Close() })
+	go serveMemEcho(local)
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+	go server.Run(ctx)
+
+	dialer := &faultDialer{inner: control, plans: plans}
+	opts := append([]ClientOption{WithControlDialer(dialer), WithLocalDialer(local)}, clientOpts...)
+	go NewClient("control", "local", 0, opts...).Run(ctx)
+	return public, server, dialer
+}
+
+// echoRoundTrip 通过公开监听器发送 payload 并校验回显
+func echoRoundTrip(ctx context.Context, public *memListener, payload []byte) error {
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		return fmt.Errorf("连接公开监听器失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	go conn.Write(payload)
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return fmt.Errorf("读取回显失败: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		return fmt.Errorf("回显数据与发送的数据不一致")
+	}
+	return nil
+}
+
+// registeredClients 返回服务器上已注册的客户端 ID（以逗号分隔，按 ID 排序）
+func registeredClients(s *Server) string {
+	var ids []string
+	for _, st := range s.ClientStatus() {
+		ids = append(ids, st.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// randomPayload 返回 n 字节的随机数据
+func randomPayload(t *testing.T, n int) []byte {
+	t.Helper()
+	payload := make([]byte, n)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatalf("生成测试数据失败: %v", err)
+	}
+	return payload
+}
+
+// TestFaultResetMidTransfer 测试传输中途控制连接被重置时公开连接随之关闭（不挂起），客户端重连后隧道恢复
+func TestFaultResetMidTransfer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	public, server, dialer := startFaultTunnel(ctx, t, []faultPlan{{resetAfter: 32 * 1024}})
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	// 回显经客户端写回服务器，写入 32KB 时控制连接被重置（位于某个 DATA 帧中间）
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	go conn.Write(randomPayload(t, 64*1024))
+	n, err := io.Copy(io.Discard, conn)
+	if err != nil {
+		t.Fatalf("控制连接重置后公开连接应被关闭, 得到 %v (已读取 %d 字节)", err, n)
+	}
+	if n >= 64*1024 {
+		t.Errorf("控制连接重置后不应收到完整的回显, 收到 %d 字节", n)
+	}
+
+	// 重置的控制连接已注销，只剩重连后的客户端
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-2")
+	if n := dialer.dialCount(); n != 2 {
+		t.Errorf("控制连接拨号 %d 次，期望 2", n)
+	}
+	if err := echoRoundTrip(ctx, public, randomPayload(t, 64*1024)); err != nil {
+		t.Fatalf("重连后隧道应恢复: %v", err)
+	}
+}
+
+// TestFaultSlowPeer 测试控制连接每次读写都有延迟时数据完整转发，且不触发断开重连
+func TestFaultSlowPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	public, server, dialer := startFaultTunnel(ctx, t, []faultPlan{{latency: 2 * time.Millisecond}})
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	for i := 0; i < 3; i++ {
+		if err := echoRoundTrip(ctx, public, randomPayload(t, 32*1024)); err != nil {
+			t.Fatalf("慢速控制连接上的第 %d 次往返失败: %v", i+1, err)
+		}
+	}
+	if n := dialer.dialCount(); n != 1 {
+		t.Errorf("慢速但可用的控制连接不应重连, 拨号 %d 次", n)
+	}
+}
+
+// TestFaultHandshakeInterruption 测试特性协商期间控制连接被重置或 HELLO 丢失时，客户端重连后完成注册，服务器不残留半注册的客户端
+func TestFaultHandshakeInterruption(t *testing.T) {
+	tests := []struct {
+		name string
+		plan faultPlan
+		opts []ClientOption
+	}{
+		// HELLO 帧头写到一半时重置
+		{name: "reset", plan: faultPlan{resetAfter: 4}},
+		// HELLO 丢失：要求特性协商的客户端等待响应超时后重连
+		{name: "drop", plan: faultPlan{drop: true}, opts: []ClientOption{WithRequiredFeatures(proto.FeatureDataKeepalive)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldTimeout := initAckTimeout
+			initAckTimeout = 200 * time.Millisecond
+			defer func() { initAckTimeout = oldTimeout }()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			public, server, dialer := startFaultTunnel(ctx, t, []faultPlan{tt.plan}, tt.opts...)
+
+			// 中断的控制连接已注销，只剩重连后的客户端
+			waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-2")
+			if n := dialer.dialCount(); n != 2 {
+				t.Errorf("控制连接拨号 %d 次，期望 2", n)
+			}
+			if err := echoRoundTrip(ctx, public, []byte("after interrupted handshake")); err != nil {
+				t.Fatalf("重连后隧道应可用: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// helloTimeout 服务器要求协议特性时，控制连接建立后等待客户端 HELLO 的超时时间
+var helloTimeout = 10 * time.Second
+
+// handleHelloFrame 处理客户端的 HELLO 帧：协商结果为双方都支持的特性，
+// 服务器或客户端要求的特性不在其中时回复 ERROR，返回 false 表示应断开控制连接；否则记录协商结果并回复 HELLO
+func (s *Server) handleHelloFrame(clientID string, frame *proto.Frame) bool {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	hello, err := proto.DecodeHello(frame.Payload)
+	if err != nil {
+		s.frameStats.inc(frame.Type, frameParseError)
+		log.Printf("协议错误，断开控制连接 (clientID=%s): %v", clientID, err)
+		return false
+	}
+	agreed := proto.SupportedFeatures & hello.Features
+	if missing := (s.requiredFeatures | hello.Required) &^ agreed; missing != 0 {
+		s.frameStats.inc(frame.Type, frameRejected)
+		log.Printf("特性协商失败，断开控制连接 (clientID=%s): 缺少必需的协议特性 %s (双方都支持: %s)", clientID, missing, agreed)
+		s.sendHelloError(clientID, clientInfo.Conn, fmt.Sprintf("缺少必需的协议特性: %s", missing))
+		return false
+	}
+
+	maxData := agreeMaxData(s.maxDataPayload, hello.MaxData)
+
+	s.clientsMu.Lock()
+	clientInfo.Features = agreed
+	clientInfo.maxData.Store(uint32(maxData))
+	clientInfo.negotiated = true
+	s.clientsMu.Unlock()
+	s.frameStats.inc(frame.Type, frameOK)
+	log.Printf("特性协商完成 (clientID=%s): %s%s", clientID, agreed, maxDataLog(maxData))
+
+	reply := &proto.Frame{
+		Type:    proto.FrameTypeHELLO,
+		Payload: proto.EncodeHello(&proto.Hello{Features: agreed, Required: s.requiredFeatures, MaxData: maxData}),
+	}
+	if err := writeFrame(clientInfo.Conn, &clientInfo.writeMu, reply, s.writeTimeout()); err != nil {
+		log.Printf("发送 HELLO 响应失败 (clientID=%s): %v", clientID, err)
+		return false
+	}
+	return true
+}
+
+// sendHelloError 在断开控制连接前向客户端回复特性协商失败的原因
+// 调用方随后关闭控制连接，不再有其他写入者，不需要写锁
+func (s *Server) sendHelloError(clientID string, conn net.Conn, message string) {
+	frame := &proto.Frame{Type: proto.FrameTypeERROR, Payload: []byte(message)}
+	if err := writeFrame(conn, nil, frame, s.writeTimeout()); err != nil {
+		log.Printf("发送特性协商错误失败 (clientID=%s): %v", clientID, err)
+	}
+}
+
+// enforceHello 服务器要求协议特性时，客户端在 helloTimeout（或更长的 initTimeout）内未完成特性协商（例如不发送任何帧的旧版本客户端）则断开控制连接
+func (s *Server) enforceHello(clientID string, conn net.Conn, done <-chan struct{}) {
+	grace := s.helloGrace()
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	negotiated := ok && clientInfo.negotiated
+	s.clientsMu.RUnlock()
+	if !ok || negotiated {
+		return
+	}
+	log.Printf("客户端 %v 内未进行特性协商，断开控制连接: clientID=%s", grace, clientID)
+	conn.Close()
+}
+
+// hasFeature 返回当前控制连接的特性协商结果是否包含 feature（未协商或服务器不支持特性协商时为 false）
+func (c *Client) hasFeature(feature proto.Features) bool {
+	return proto.Features(c.features.Load())&feature != 0
+}
+
+// negotiateFeatures 在新建立的控制连接上发送 HELLO
+// 未要求特性时不等待响应（旧版本服务器不回复 HELLO，只是不启用任何可选特性），响应在 handleFrame 中处理；
+// 要求特性时等待服务器的 HELLO 或 ERROR，期间收到的其他帧照常处理，超时视为服务器不支持特性协商
+func (c *Client) negotiateFeatures(ctx context.Context) error {
+	c.controlMu.RLock()
+	controlConn := c.controlConn
+	c.controlMu.RUnlock()
+	if controlConn == nil {
+		return fmt.Errorf("控制连接不存在")
+	}
+
+	frame := &proto.Frame{
+		Type:    proto.FrameTypeHELLO,
+		Payload: proto.EncodeHello(&proto.Hello{Features: proto.SupportedFeatures, Required: c.requiredFeatures, MaxData: localMaxData(c.maxDataPayload)}),
+	}
+	if err := writeFrame(controlConn, &c.controlWriteMu, frame, c.controlWriteTimeout); err != nil {
+		return fmt.Errorf("发送 HELLO 失败: %w", err)
+	}
+	c.helloSent.Store(true)
+	if c.requiredFeatures == 0 {
+		return nil
+	}
+
+	controlConn.SetReadDeadline(time.Now().Add(initAckTimeout))
+	defer controlConn.SetReadDeadline(time.Time{})
+
+	for {
+		frame, err := readFrame(controlConn, c.frameTracer)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("%w: %v 内未收到服务器的特性协商响应（服务器可能是不支持特性协商的旧版本），必需的协议特性: %s", ErrProtocolIncompatible, initAckTimeout, c.requiredFeatures)
+			}
+			return fmt.Errorf("等待服务器的特性协商响应失败: %w", err)
+		}
+
+		switch frame.Type {
+		case proto.FrameTypeHELLO:
+			c.frameStats.inc(frame.Type, frameOK)
+			return c.handleHello(frame.Payload)
+		case proto.FrameTypeERROR:
+			// 服务器在注册阶段拒绝了客户端，或拒绝了特性协商（客户端不支持服务器要求的特性）
+			c.frameStats.inc(frame.Type, frameOK)
+			return c.serverRejection(frame.Payload)
+		default:
+			if err := c.handleFrame(ctx, frame); err != nil {
+				log.Printf("处理帧错误 (connID=%d): %v", frame.ConnID, err)
+			}
+		}
+	}
+}
+
+// handleHello 处理服务器的 HELLO 响应：记录协商结果，客户端或服务器要求的特性不在其中时返回错误
+func (c *Client) handleHello(payload []byte) error {
+	c.helloReceived.Store(true)
+	hello, err := proto.DecodeHello(payload)
+	if err != nil {
+		return fmt.Errorf("无效的 HELLO 响应: %w", err)
+	}
+	agreed := proto.SupportedFeatures & hello.Features
+	if missing := (c.requiredFeatures | hello.Required) &^ agreed; missing != 0 {
+		return fmt.Errorf("%w: 缺少必需的协议特性: %s (双方都支持: %s)", ErrProtocolIncompatible, missing, agreed)
+	}
+	maxData := agreeMaxData(c.maxDataPayload, hello.MaxData)
+	c.features.Store(uint32(agreed))
+	c.maxData.Store(int32(maxData))
+	log.Printf("特性协商完成: %s%s", agreed, maxDataLog(maxData))
+	return nil
+}
@@ -0,0 +1,184 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestFeatureNegotiation 测试特性协商：双方都支持时记录协商结果，缺少必需的特性或要求协商时未发送 HELLO 则回复 ERROR 并断开
+func TestFeatureNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerRequiredFeatures(proto.FeatureAssignmentInfo))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// exchange 发送一个帧，返回服务器的响应及之后控制连接是否被关闭
+	exchange := func(frame *proto.Frame) (*proto.Frame, bool) {
+		conn, err := net.Dial("tcp", controlAddr)
+		if err != nil {
+			t.Fatalf("连接服务器失败: %v", err)
+		}
+		defer conn.Close()
+		writeFrame(conn, nil, frame, time.Second)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reply, err := proto.DecodeFrame(conn)
+		if err != nil {
+			t.Fatalf("读取响应失败: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = proto.DecodeFrame(conn)
+		return reply, err != nil && !strings.Contains(err.Error(), "timeout")
+	}
+	hello := func(h *proto.Hello) *proto.Frame {
+		return &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(h)}
+	}
+
+	// 双方都支持：回复协商结果，控制连接保持
+	reply, closed := exchange(hello(&proto.Hello{Features: proto.SupportedFeatures | 1<<20}))
+	agreed, err := proto.DecodeHello(reply.Payload)
+	if reply.Type != proto.FrameTypeHELLO || err != nil || closed {
+		t.Fatalf("期望 HELLO 响应且连接保持，得到 %v %q (closed=%v)", reply.Type, reply.Payload, closed)
+	}
+	if agreed.Features != proto.SupportedFeatures || agreed.Required != proto.FeatureAssignmentInfo {
+		t.Errorf("协商结果应为双方都支持的特性，得到 %+v", agreed)
+	}
+
+	for name, frame := range map[string]*proto.Frame{
+		"服务器要求的特性客户端不支持": hello(&proto.Hello{Features: proto.FeatureDataKeepalive}),
+		"客户端要求的特性服务器不支持": hello(&proto.Hello{Features: proto.SupportedFeatures, Required: 1 << 20}),
+		"未进行特性协商":        {Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&proto.InitConfig{LocalAddr: "127.0.0.1:80"})},
+	} {
+		reply, closed := exchange(frame)
+		if reply.Type != proto.FrameTypeERROR || !closed {
+			t.Errorf("%s: 期望 ERROR 响应并断开，得到 %v %q (closed=%v)", name, reply.Type, reply.Payload, closed)
+		}
+	}
+}
+
+// TestClientRequiredFeatures 测试客户端要求的特性：服务器支持时协商结果记录在双方，
+// 服务器不响应特性协商（旧版本）时客户端断开控制连接，不发送 INIT
+func TestClientRequiredFeatures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "")
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, "127.0.0.1:80", 0, WithRequiredFeatures(proto.FeatureDataKeepalive))
+	go client.Run(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for !client.hasFeature(proto.FeatureDataKeepalive) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !client.hasFeature(proto.FeatureDataKeepalive) {
+		t.Fatalf("客户端未记录协商结果")
+	}
+	status := server.ClientStatus()
+	if len(status) != 1 || status[0].Features != proto.SupportedFeatures.String() {
+		t.Errorf("服务器应记录协商结果，得到 %+v", status)
+	}
+
+	// 旧版本服务器：接受连接但不回复 HELLO
+	oldTimeout := initAckTimeout
+	initAckTimeout = 200 * time.Millisecond
+	defer func() { initAckTimeout = oldTimeout }()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+	go NewClient(listener.Addr().String(), "127.0.0.1:80", 9000, WithRequiredFeatures(proto.FeatureDataKeepalive)).Run(ctx)
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("接受控制连接失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if frame, err := proto.DecodeFrame(conn); err != nil || frame.Type != proto.FrameTypeHELLO {
+		t.Fatalf("期望 HELLO 帧，得到 %+v, %v", frame, err)
+	}
+	if frame, err := proto.DecodeFrame(conn); err == nil {
+		t.Errorf("服务器未响应特性协商时客户端应断开，而不是发送 %v", frame.Type)
+	}
+}
+
+// TestMaxDataNegotiation 测试 DATA 帧负载上限协商：服务器回复双方上限的较小值，超过协商上限的 DATA 帧收到 ERROR 并断开；
+// 客户端未声明上限时不协商；客户端和服务器按协商结果分块转发数据
+func TestMaxDataNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerMaxDataPayload(1024))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// negotiate 发送 HELLO 后发送一个 2048 字节的 DATA 帧的帧头（不发送负载：超过上限时应在读取负载之前被拒绝），返回 HELLO 响应、DATA 帧之后的响应（没有时为 nil）及控制连接是否被关闭
+	negotiate := func(h *proto.Hello) (*proto.Hello, *proto.Frame, bool) {
+		conn, err := net.Dial("tcp", controlAddr)
+		if err != nil {
+			t.Fatalf("连接服务器失败: %v", err)
+		}
+		defer conn.Close()
+		writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(h)}, time.Second)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reply, err := proto.DecodeFrame(conn)
+		if err != nil || reply.Type != proto.FrameTypeHELLO {
+			t.Fatalf("期望 HELLO 响应，得到 %v, %v", reply, err)
+		}
+		agreed, err := proto.DecodeHello(reply.Payload)
+		if err != nil {
+			t.Fatalf("无效的 HELLO 响应 %q: %v", reply.Payload, err)
+		}
+
+		header := []byte{byte(proto.FrameTypeDATA), 0, 0, 0, 1, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(header[5:], 2048)
+		conn.Write(header)
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		next, err := proto.DecodeFrame(conn)
+		if err != nil {
+			return agreed, nil, !strings.Contains(err.Error(), "timeout")
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = proto.DecodeFrame(conn)
+		return agreed, next, err != nil && !strings.Contains(err.Error(), "timeout")
+	}
+
+	agreed, next, closed := negotiate(&proto.Hello{Features: proto.SupportedFeatures, MaxData: 8192})
+	if agreed.MaxData != 1024 {
+		t.Errorf("协商结果应为双方上限的较小值 1024，得到 %d", agreed.MaxData)
+	}
+	if next == nil || next.Type != proto.FrameTypeERROR || !closed {
+		t.Errorf("超过协商上限的 DATA 帧应收到 ERROR 并断开，得到 %v (closed=%v)", next, closed)
+	}
+
+	agreed, next, closed = negotiate(&proto.Hello{Features: proto.SupportedFeatures})
+	if agreed.MaxData != 0 || next != nil || closed {
+		t.Errorf("客户端未声明上限时不应协商也不应断开，得到 max_data=%d, %v (closed=%v)", agreed.MaxData, next, closed)
+	}
+
+	// 端到端：客户端的上限更小，双方都按 512 字节分块转发
+	public, tunnelServer, _ := startFaultTunnel(ctx, t, nil, WithMaxDataPayload(512))
+	waitStat(t, "协商的 DATA 负载上限", func() int {
+		for _, st := range tunnelServer.ClientStatus() {
+			return st.MaxData
+		}
+		return 0
+	}, 512)
+	if err := echoRoundTrip(ctx, public, randomPayload(t, 10000)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,158 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// windowUpdateThreshold 客户端累计写入本地连接这么多字节后才发送一个 WINDOW_UPDATE，避免每个 DATA 帧都回复一帧；
+// 小于初始窗口，服务器用完窗口之前总能收到足够的额度，不会互相等待
+const windowUpdateThreshold = proto.InitialWindowSize / 4
+
+// sendWindow 服务器向客户端发送一个连接的数据时的发送窗口（协商了 flow_control 时使用）：
+// 每发送一个 DATA 帧扣除负载长度，客户端把数据写入本地连接后以 WINDOW_UPDATE 归还。
+// 窗口用完时转发 goroutine 停止读取公开连接，本地服务缓慢时由 TCP 流量控制把反压传递给公开端的对端，
+// 而不是让数据堆积在控制连接和客户端的缓冲区中
+type sendWindow struct {
+	mu     sync.Mutex
+	avail  int
+	closed bool
+	wake   chan struct{} // 有新额度或窗口关闭（容量 1）
+}
+
+// newSendWindow 创建初始额度为 size 的发送窗口
+func newSendWindow(size int) *sendWindow {
+	return &sendWindow{avail: size, wake: make(chan struct{}, 1)}
+}
+
+// available 返回当前额度，窗口已关闭时 ok 为 false
+func (w *sendWindow) available() (n int, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.avail, !w.closed
+}
+
+// consume 扣除已发送的字节数
+func (w *sendWindow) consume(n int) {
+	w.mu.Lock()
+	w.avail -= n
+	w.mu.Unlock()
+}
+
+// grant 增加额度（最多到 math.MaxInt32，防止对端的重复授予溢出）
+func (w *sendWindow) grant(n uint32) {
+	w.mu.Lock()
+	w.avail = int(min(int64(w.avail)+int64(n), math.MaxInt32))
+	w.mu.Unlock()
+	notify(w.wake)
+}
+
+// close 关闭窗口，唤醒等待额度的转发 goroutine（连接被关闭时调用）
+func (w *sendWindow) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	notify(w.wake)
+}
+
+// waitSendWindow 等待公开连接的发送窗口有额度，返回可读取的字节数上限；未启用流量控制时返回 max。
+// 等待期间连接的截止时间照常生效；返回 false 表示连接已结束（清理已由这里或另一方完成），调用方直接退出
+func (s *Server) waitSendWindow(ctx context.Context, clientInfo *ClientInfo, connID uint32, tc *trackedConn, max int) (int, bool) {
+	w := tc.window
+	if w == nil {
+		return max, true
+	}
+	stalled := false
+	for {
+		n, ok := w.available()
+		if !ok {
+			// 连接已被关闭（客户端 CLOSE_CONN、控制连接断开等），与读取出错时相同，由先关闭的一方清理
+			if tc.closeLocal() {
+				s.releasePublicConn(clientInfo, clientInfo.ID, connID, tc, closeReasonError)
+			}
+			return 0, false
+		}
+		if n > 0 {
+			return min(n, max), true
+		}
+		if !stalled {
+			stalled = true
+			s.flowStalls.Add(1)
+		}
+
+		var timer *time.Timer
+		var expire <-chan time.Time
+		if at, _ := tc.deadlines.next(); !at.IsZero() {
+			timer = time.NewTimer(time.Until(at))
+			expire = timer.C
+		}
+		select {
+		case <-w.wake:
+		case <-ctx.Done():
+			if tc.closeLocal() {
+				s.releasePublicConn(clientInfo, clientInfo.ID, connID, tc, closeReasonShutdown)
+			}
+			return 0, false
+		case <-expire:
+			if kind := tc.deadlines.expired(time.Now()); kind != "" {
+				s.expirePublicConn(clientInfo, connID, tc, kind)
+				return 0, false
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// handleWindowUpdate 处理客户端的 WINDOW_UPDATE 帧，增加对应连接的发送窗口，返回处理结果（用于帧计数）
+func (s *Server) handleWindowUpdate(clientID string, frame *proto.Frame) string {
+	increment, err := proto.DecodeWindowUpdate(frame.Payload)
+	if err != nil {
+		log.Printf("无效的 WINDOW_UPDATE 帧 (clientID=%s, connID=%d): %v", clientID, frame.ConnID, err)
+		return frameParseError
+	}
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return frameUnknownConn
+	}
+	value, ok := clientInfo.ConnMap.Load(frame.ConnID)
+	if !ok || value.(*trackedConn).window == nil {
+		// 连接已关闭：额度随连接一起丢弃
+		return frameUnknownConn
+	}
+	value.(*trackedConn).window.grant(increment)
+	return frameOK
+}
+
+// consumeWindow 客户端把 n 字节写入本地连接后记录，累计达到 windowUpdateThreshold 时以 WINDOW_UPDATE 归还给服务器
+// （只在协商了 flow_control 时发送）；只在帧处理循环中或连接存入 connMap 之前调用
+func (c *Client) consumeWindow(connID uint32, tc *trackedConn, n int) {
+	if n <= 0 || !c.hasFeature(proto.FeatureFlowControl) {
+		return
+	}
+	tc.windowUnacked += n
+	if tc.windowUnacked < windowUpdateThreshold {
+		return
+	}
+	increment := tc.windowUnacked
+	tc.windowUnacked = 0
+
+	c.controlMu.RLock()
+	controlConn := c.controlConn
+	c.controlMu.RUnlock()
+	if controlConn == nil {
+		return
+	}
+	frame := &proto.Frame{Type: proto.FrameTypeWINDOW_UPDATE, ConnID: connID, Payload: proto.EncodeWindowUpdate(uint32(increment))}
+	if err := writeFrame(controlConn, &c.controlWriteMu, frame, c.controlWriteTimeout); err != nil {
+		log.Printf("发送 WINDOW_UPDATE 帧错误 (connID=%d, trace=%s): %v", connID, tc.traceID, err)
+	}
+}
@@ -0,0 +1,116 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestFlowControlWindow 测试协商了 flow_control 时服务器对每个连接最多发送一个窗口的未确认数据，
+// 之后停止读取公开连接，收到 WINDOW_UPDATE 后按归还的额度继续发送
+func TestFlowControlWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+	go server.Run(ctx)
+
+	conn := dialControl(t, ctx, control)
+	defer conn.Close()
+	frames := make(chan *proto.Frame, 1024)
+	go func() {
+		for {
+			frame, err := proto.DecodeFrame(conn)
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+	hello := &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(&proto.Hello{Features: proto.FeatureFlowControl})}
+	init := &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&proto.InitConfig{LocalAddr: "127.0.0.1:80"})}
+	for _, frame := range []*proto.Frame{hello, init} {
+		if err := writeFrame(conn, nil, frame, time.Second); err != nil {
+			t.Fatalf("发送 %s 失败: %v", frame.Type, err)
+		}
+	}
+
+	publicConn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer publicConn.Close()
+	go publicConn.Write(bytes.Repeat([]byte("x"), 4*proto.InitialWindowSize))
+
+	// 读取 DATA 帧直到服务器停止发送，返回收到的负载字节数
+	var connID uint32
+	receive := func() int {
+		total := 0
+		for {
+			select {
+			case frame := <-frames:
+				switch frame.Type {
+				case proto.FrameTypeNEW_CONN:
+					connID = frame.ConnID
+				case proto.FrameTypeDATA:
+					total += len(frame.Payload)
+				}
+			case <-time.After(300 * time.Millisecond):
+				return total
+			}
+		}
+	}
+	if got := receive(); got != proto.InitialWindowSize {
+		t.Fatalf("未归还额度时应只收到一个窗口 (%d 字节), 得到 %d", proto.InitialWindowSize, got)
+	}
+	if got := server.flowStalls.Load(); got != 1 {
+		t.Errorf("暂停读取的次数应为 1, 得到 %d", got)
+	}
+
+	update := &proto.Frame{Type: proto.FrameTypeWINDOW_UPDATE, ConnID: connID, Payload: proto.EncodeWindowUpdate(windowUpdateThreshold)}
+	if err := writeFrame(conn, nil, update, time.Second); err != nil {
+		t.Fatalf("发送 WINDOW_UPDATE 失败: %v", err)
+	}
+	if got := receive(); got != windowUpdateThreshold {
+		t.Errorf("归还 %d 字节后应再收到同样多的数据, 得到 %d", windowUpdateThreshold, got)
+	}
+}
+
+// TestFlowControlTransfer 测试客户端和服务器协商 flow_control 后，超过多个窗口的数据能经由本地服务完整往返
+func TestFlowControlTransfer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+	go server.Run(ctx)
+	client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local))
+	go client.Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+	waitStat(t, "flow_control 协商结果", func() bool { return client.hasFeature(proto.FeatureFlowControl) }, true)
+
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	data := bytes.Repeat([]byte("0123456789abcdef"), proto.InitialWindowSize/4)
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("回显失败: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("回显的数据与发送的不一致")
+	}
+}
@@ -0,0 +1,178 @@
+package tunnel
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// dataChunkSize 转发数据时每次从连接读取的字节数，即发出的 DATA 帧负载的最大长度（不能超过 proto.MaxDataPayloadSize）
+const dataChunkSize = 4096
+
+// smallControlFrameSize 负载不超过该长度的非 DATA 帧视为小控制帧（NEW_CONN、CLOSE、PING、窗口更新等），
+// 同时排队的小控制帧合并为一次写入
+const smallControlFrameSize = 512
+
+// batchControlFrames 是否合并同时排队的小控制帧（基准测试中关闭以对比写入次数）
+var batchControlFrames = true
+
+// writeFrame 向控制连接写入一个帧
+// timeout 大于 0 时为本次写入设置写截止时间，从请求写入时开始计算，包括在 w 上等待其他帧写完的时间：
+// 对端停止读取或读取过慢时，正在写入的帧和排队的帧都在 timeout 内失败，而不是逐个等待各自的超时。写入超时说明对端已卡死。
+// 任何写入错误（超时、对端已断开）都可能留下半个帧（TLS 下为半条记录），控制连接上的帧边界已不可信，
+// 因此直接关闭控制连接，由读循环立即触发注销/重连，该控制连接上的所有转发连接随之关闭，不会处于半开状态
+//
+// 多个 goroutine 会并发写同一控制连接，w 串行化该控制连接上的帧写入（服务器每个客户端一个，客户端每个实例一个）：
+// 持锁期间由 proto.WriteFrame 直接写出帧头和负载，不把负载复制到中间缓冲区，纯 TCP 连接上合并为一次 writev，
+// TLS/PQC/WebSocket 连接上依次写出帧头和负载也不会与其他帧交错。w 为 nil 表示调用方保证该连接只有一个写入者
+// w 设置了帧跟踪时，写入成功的帧交给 w.tracer 记录。小控制帧见 writeBatchedFrame
+func writeFrame(conn net.Conn, w *controlWriter, frame *proto.Frame, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if w != nil && batchControlFrames && frame != nil && frame.Type != proto.FrameTypeDATA && len(frame.Payload) <= smallControlFrameSize {
+		return writeBatchedFrame(conn, w, &batchedFrame{frame: frame, deadline: deadline, timeout: timeout})
+	}
+	if w != nil {
+		defer w.begin()()
+	}
+	// 每次写入前都重新设置截止时间（不清除），避免沿用上一次写入的截止时间
+	if timeout > 0 {
+		if !time.Now().Before(deadline) {
+			log.Printf("控制连接写入排队超过 %v（对端读取缓慢或已停止读取），关闭控制连接: %s", timeout, conn.RemoteAddr())
+			conn.Close()
+			return os.ErrDeadlineExceeded
+		}
+		conn.SetWriteDeadline(deadline)
+	}
+
+	if err := proto.WriteFrame(conn, frame); err != nil {
+		closeOnWriteError(conn, err, timeout)
+		return err
+	}
+	if w != nil {
+		traceFrame(w.tracer, FrameTraceOut, conn, frame)
+	}
+	return nil
+}
+
+// closeOnWriteError 记录控制连接的写入错误并关闭控制连接
+func closeOnWriteError(conn net.Conn, err error, timeout time.Duration) {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		log.Printf("控制连接写入超时 (%v)，关闭控制连接: %s", timeout, conn.RemoteAddr())
+	} else if !errors.Is(err, net.ErrClosed) {
+		log.Printf("控制连接写入失败，关闭控制连接: %s: %v", conn.RemoteAddr(), err)
+	}
+	conn.Close()
+}
+
+// batchedFrame 等待合并写入的小控制帧
+type batchedFrame struct {
+	frame    *proto.Frame
+	deadline time.Time     // 写截止时间（零值表示不设置）
+	timeout  time.Duration // 用于日志
+	done     bool          // 已被写出（或写入失败），由持有写锁的写入者设置
+	err      error
+}
+
+// writeBatchedFrame 写入小控制帧：先加入 w 的待写队列再获取写锁。拿到写锁时该帧若已被之前的写入者一并写出，直接返回其结果；
+// 否则把队列中的全部小控制帧编码到同一个缓冲区一次写出（组提交）。短连接密集的隧道上 NEW_CONN、CLOSE 等小帧频繁且并发，
+// 合并后写入的系统调用次数（TLS/MAC 连接上为记录数）随之减少；DATA 帧和较大的帧仍由 writeFrame 逐个直接写出。
+// 每个帧在其 writeFrame 返回前写完，同一 goroutine 依次写入的帧的顺序不变。
+// 一批帧使用其中最早的截止时间，写入失败时这批帧都返回同一错误（控制连接已关闭）
+func writeBatchedFrame(conn net.Conn, w *controlWriter, entry *batchedFrame) error {
+	w.batchMu.Lock()
+	w.batch = append(w.batch, entry)
+	w.batchMu.Unlock()
+
+	defer w.begin()()
+	if entry.done {
+		return entry.err
+	}
+	w.batchMu.Lock()
+	batch := w.batch
+	w.batch = nil
+	w.batchMu.Unlock()
+
+	err := flushBatch(conn, batch)
+	for _, e := range batch {
+		e.done, e.err = true, err
+		if err == nil {
+			traceFrame(w.tracer, FrameTraceOut, conn, e.frame)
+		}
+	}
+	return entry.err
+}
+
+// flushBatch 把一批小控制帧编码到同一个缓冲区，一次写出
+func flushBatch(conn net.Conn, batch []*batchedFrame) error {
+	var buf []byte
+	earliest := batch[0]
+	for _, e := range batch {
+		var err error
+		if buf, err = proto.AppendFrame(buf, e.frame); err != nil {
+			return err
+		}
+		if !e.deadline.IsZero() && (earliest.deadline.IsZero() || e.deadline.Before(earliest.deadline)) {
+			earliest = e
+		}
+	}
+	if !earliest.deadline.IsZero() {
+		if !time.Now().Before(earliest.deadline) {
+			log.Printf("控制连接写入排队超过 %v（对端读取缓慢或已停止读取），关闭控制连接: %s", earliest.timeout, conn.RemoteAddr())
+			conn.Close()
+			return os.ErrDeadlineExceeded
+		}
+		conn.SetWriteDeadline(earliest.deadline)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		closeOnWriteError(conn, err, earliest.timeout)
+		return err
+	}
+	return nil
+}
+
+// controlWriter 串行化一个控制连接上的帧写入，并统计写入阻塞，用于观察队头阻塞：
+// 一个转发连接的大量数据或对端接收窗口耗尽时，同一控制连接上其他连接的帧只能排队等待
+type controlWriter struct {
+	mu sync.Mutex
+
+	pending    atomic.Int32 // 正在等待或执行写入的帧数
+	maxPending atomic.Int32 // pending 的最大值
+	blocked    atomic.Int64 // 写入的累计耗时（纳秒）：从请求写入到写完，包括等待其他写入者和等待对端接收
+
+	tracer FrameTracer // 帧跟踪（可选，nil 表示不记录），在开始写入前设置
+
+	batchMu sync.Mutex
+	batch   []*batchedFrame // 等待合并写入的小控制帧，由先拿到写锁的写入者一并写出
+}
+
+// begin 登记一次写入并获取写锁，返回写完后调用的函数（释放写锁并累计耗时）
+func (w *controlWriter) begin() (end func()) {
+	start := time.Now()
+	n := w.pending.Add(1)
+	for {
+		max := w.maxPending.Load()
+		if n <= max || w.maxPending.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	w.mu.Lock()
+	return func() {
+		w.mu.Unlock()
+		w.blocked.Add(int64(time.Since(start)))
+		w.pending.Add(-1)
+	}
+}
+
+// writeStats 返回写入的累计耗时（秒）、当前排队的帧数和排队帧数的最大值
+func (w *controlWriter) writeStats() (blockedSeconds float64, depth, maxDepth int) {
+	return time.Duration(w.blocked.Load()).Seconds(), int(w.pending.Load()), int(w.maxPending.Load())
+}
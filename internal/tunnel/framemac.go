@@ -0,0 +1,197 @@
+package tunnel
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// 帧完整性校验（未启用 TLS 时可选）：控制连接建立后先进行 MAC 协商，之后连接上的全部数据按记录传输，
+// 每条记录附加 HMAC-SHA256，防止有问题的中间设备损坏帧而不被发现（不加密，只保证完整性）
+//
+// 协商（双方使用相同的共享密钥）：
+//
+//	client → server: frameMACMagic(8) + client_nonce(16)
+//	server → client: server_nonce(16) + HMAC(secret, "server" | client_nonce | server_nonce)
+//	client → server: HMAC(secret, "client" | client_nonce | server_nonce)
+//
+// 两个方向分别使用从共享密钥和双方随机数派生的会话密钥（HMAC(secret, "c2s"/"s2c" | nonces)）
+// 记录格式：length(4) + data + HMAC(会话密钥, seq(8) | length(4) | data)，seq 为该方向的记录序号，
+// 因此记录被篡改、重放、删除或重排都会导致校验失败，接收方断开控制连接
+const (
+	frameMACMagic     = "RTMAC/1\n"
+	frameMACNonceSize = 16
+	frameMACSize      = sha256.Size
+	frameMACRecordMax = 64 << 10 // 单条记录最多携带的数据字节数
+)
+
+// frameMACHandshakeTimeout MAC 协商的超时时间
+var frameMACHandshakeTimeout = 10 * time.Second
+
+// errFrameMAC 记录的 MAC 校验失败
+var errFrameMAC = errors.New("帧完整性校验失败（数据被篡改或损坏），断开控制连接")
+
+// macConn 在控制连接上按记录传输数据并校验每条记录的 HMAC
+type macConn struct {
+	net.Conn
+	sendKey []byte
+	recvKey []byte
+
+	writeMu sync.Mutex
+	sendSeq uint64
+
+	recvSeq uint64
+	pending []byte // 当前记录中尚未被读取的数据（只有帧读取循环一个读取者）
+}
+
+// macSum 计算 HMAC(key, parts...)
+func macSum(key []byte, parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// newMACConn 按双方随机数派生两个方向的会话密钥
+func newMACConn(conn net.Conn, secret, clientNonce, serverNonce []byte, isClient bool) *macConn {
+	c2s := macSum(secret, []byte("c2s"), clientNonce, serverNonce)
+	s2c := macSum(secret, []byte("s2c"), clientNonce, serverNonce)
+	if isClient {
+		return &macConn{Conn: conn, sendKey: c2s, recvKey: s2c}
+	}
+	return &macConn{Conn: conn, sendKey: s2c, recvKey: c2s}
+}
+
+// clientFrameMAC 在新建立的控制连接上作为客户端进行 MAC 协商，返回按记录校验的连接
+func clientFrameMAC(conn net.Conn, secret []byte) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(frameMACHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	clientNonce := make([]byte, frameMACNonceSize)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append([]byte(frameMACMagic), clientNonce...)); err != nil {
+		return nil, fmt.Errorf("发送帧完整性校验协商失败: %w", err)
+	}
+
+	reply := make([]byte, frameMACNonceSize+frameMACSize)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, fmt.Errorf("未收到服务器的帧完整性校验协商响应（服务器可能未启用帧完整性校验）: %w", err)
+	}
+	serverNonce, proof := reply[:frameMACNonceSize], reply[frameMACNonceSize:]
+	if !hmac.Equal(proof, macSum(secret, []byte("server"), clientNonce, serverNonce)) {
+		return nil, fmt.Errorf("服务器的帧完整性校验密钥不匹配")
+	}
+	if _, err := conn.Write(macSum(secret, []byte("client"), clientNonce, serverNonce)); err != nil {
+		return nil, fmt.Errorf("发送帧完整性校验协商失败: %w", err)
+	}
+	return newMACConn(conn, secret, clientNonce, serverNonce, true), nil
+}
+
+// serverFrameMAC 在接受的控制连接上作为服务器进行 MAC 协商，返回按记录校验的连接
+func serverFrameMAC(conn net.Conn, secret []byte) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(frameMACHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := make([]byte, len(frameMACMagic)+frameMACNonceSize)
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		return nil, fmt.Errorf("读取帧完整性校验协商失败: %w", err)
+	}
+	if string(hello[:len(frameMACMagic)]) != frameMACMagic {
+		return nil, fmt.Errorf("客户端未启用帧完整性校验")
+	}
+	clientNonce := hello[len(frameMACMagic):]
+
+	serverNonce := make([]byte, frameMACNonceSize)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(serverNonce, macSum(secret, []byte("server"), clientNonce, serverNonce)...)); err != nil {
+		return nil, fmt.Errorf("发送帧完整性校验协商响应失败: %w", err)
+	}
+
+	proof := make([]byte, frameMACSize)
+	if _, err := io.ReadFull(conn, proof); err != nil {
+		return nil, fmt.Errorf("读取客户端的帧完整性校验证明失败（客户端的密钥可能不匹配）: %w", err)
+	}
+	if !hmac.Equal(proof, macSum(secret, []byte("client"), clientNonce, serverNonce)) {
+		return nil, fmt.Errorf("客户端的帧完整性校验密钥不匹配")
+	}
+	return newMACConn(conn, secret, clientNonce, serverNonce, false), nil
+}
+
+// Write 将 b 按记录写出（超过 frameMACRecordMax 时拆分为多条记录）
+func (c *macConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), frameMACRecordMax)
+		var seq [8]byte
+		binary.BigEndian.PutUint64(seq[:], c.sendSeq)
+		record := make([]byte, 4, 4+n+frameMACSize)
+		binary.BigEndian.PutUint32(record, uint32(n))
+		record = append(record, b[:n]...)
+		record = append(record, macSum(c.sendKey, seq[:], record)...)
+		if _, err := c.Conn.Write(record); err != nil {
+			return written, err
+		}
+		c.sendSeq++
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Read 返回已校验记录中的数据，当前记录读完后读取并校验下一条记录
+func (c *macConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readRecord 读取一条记录并校验其 MAC，校验失败时关闭连接
+func (c *macConn) readRecord() error {
+	var header [4]byte
+	if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > frameMACRecordMax {
+		c.Conn.Close()
+		return errFrameMAC
+	}
+	body := make([]byte, int(n)+frameMACSize)
+	if _, err := io.ReadFull(c.Conn, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	data, mac := body[:n], body[n:]
+
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], c.recvSeq)
+	if !hmac.Equal(mac, macSum(c.recvKey, seq[:], header[:], data)) {
+		c.Conn.Close()
+		return errFrameMAC
+	}
+	c.recvSeq++
+	c.pending = data
+	return nil
+}
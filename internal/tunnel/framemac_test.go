@@ -0,0 +1,130 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestFrameMAC 测试启用帧完整性校验时的完整转发流程，以及密钥不匹配或一方未启用时拒绝控制连接
+func TestFrameMAC(t *testing.T) {
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	secret := []byte("0123456789abcdef")
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerFrameMAC(secret))
+	go server.Run(ctx)
+
+	// 密钥不匹配和未启用帧完整性校验的客户端都不能注册
+	for _, opts := range [][]ClientOption{
+		{WithFrameMAC([]byte("fedcba9876543210"))},
+		{},
+	} {
+		clientCtx, clientCancel := context.WithTimeout(ctx, 300*time.Millisecond)
+		client := NewClient("control", "local", 0, append(opts, WithControlDialer(control), WithLocalDialer(local))...)
+		client.Run(clientCtx)
+		clientCancel()
+		if n := len(server.ClientStatus()); n != 0 {
+			t.Fatalf("未通过帧完整性校验协商的客户端不应注册，得到 %d 个客户端", n)
+		}
+	}
+	if _, counts := server.securityLog.rejectCounts(); counts[rejectFrameMAC] == 0 {
+		t.Errorf("协商失败应计入 %s 拒绝原因", rejectFrameMAC)
+	}
+
+	client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local), WithFrameMAC(secret))
+	go client.Run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(server.ClientStatus()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("启用帧完整性校验的客户端未能注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer conn.Close()
+	// 超过单条记录上限的数据被拆分为多条记录
+	msg := make([]byte, 3*frameMACRecordMax/2)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	go conn.Write(msg)
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if string(got) != string(msg) {
+		t.Errorf("回显数据不匹配")
+	}
+}
+
+// TestFrameMACTampered 测试被篡改的记录无法通过校验，接收方关闭连接
+func TestFrameMACTampered(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	for name, tamper := range map[string]func(record []byte){
+		"data":   func(record []byte) { record[6] ^= 0x01 },
+		"mac":    func(record []byte) { record[len(record)-1] ^= 0x80 },
+		"length": func(record []byte) { record[3]-- },
+	} {
+		t.Run(name, func(t *testing.T) {
+			clientSide, serverSide := net.Pipe()
+			defer clientSide.Close()
+
+			type result struct {
+				conn net.Conn
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				conn, err := serverFrameMAC(serverSide, secret)
+				done <- result{conn, err}
+			}()
+			sender, err := clientFrameMAC(clientSide, secret)
+			if err != nil {
+				t.Fatalf("客户端协商失败: %v", err)
+			}
+			res := <-done
+			if res.err != nil {
+				t.Fatalf("服务器协商失败: %v", res.err)
+			}
+			receiver := res.conn
+
+			// 在发送方和底层连接之间篡改记录：拦截写入，修改后再转发
+			intercept, forward := net.Pipe()
+			sender.(*macConn).Conn = intercept
+			go func() {
+				buf := make([]byte, 4+len("hello frame")+frameMACSize)
+				if _, err := io.ReadFull(forward, buf); err != nil {
+					return
+				}
+				tamper(buf)
+				clientSide.Write(buf)
+			}()
+			go sender.Write([]byte("hello frame"))
+
+			receiver.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, err = receiver.Read(make([]byte, 64))
+			if !errors.Is(err, errFrameMAC) {
+				t.Fatalf("被篡改的记录应校验失败，得到 %v", err)
+			}
+			if _, err := serverSide.Write([]byte{0}); err == nil {
+				t.Errorf("校验失败后应关闭连接")
+			}
+		})
+	}
+}
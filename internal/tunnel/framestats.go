@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 帧处理结果（指标标签 outcome）
+const (
+	frameOK          = "ok"           // 处理成功
+	frameWriteError  = "write_error"  // 写入公开/本地连接失败
+	frameUnknownConn = "unknown_conn" // connID 没有对应的连接（可能已关闭）
+	frameDialError   = "dial_error"   // 连接本地服务失败
+	frameParseError  = "parse_error"  // 负载无法解析
+	frameRejected    = "rejected"     // 配置被拒绝（例如端口被占用、超出配额）
+	frameIgnored     = "ignored"      // 未知帧类型或无需处理的帧
+)
+
+// frameStatKey 计数器的标签
+type frameStatKey struct {
+	frame   string
+	outcome string
+}
+
+// frameStats 按帧类型和处理结果统计的计数器，用于定位故障（例如 dial_error 激增说明本地后端不可用）
+// 零值可用
+type frameStats struct {
+	mu     sync.Mutex
+	counts map[frameStatKey]uint64
+}
+
+// inc 记录一次帧处理结果
+func (st *frameStats) inc(t proto.FrameType, outcome string) {
+	st.mu.Lock()
+	if st.counts == nil {
+		st.counts = make(map[frameStatKey]uint64)
+	}
+	st.counts[frameStatKey{t.String(), outcome}]++
+	st.mu.Unlock()
+}
+
+// get 返回指定帧类型和结果的计数
+func (st *frameStats) get(t proto.FrameType, outcome string) uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.counts[frameStatKey{t.String(), outcome}]
+}
+
+// writeMetrics 以 Prometheus 文本格式写入计数器（按标签排序，输出稳定）
+func (st *frameStats) writeMetrics(buf *bytes.Buffer) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	keys := make([]frameStatKey, 0, len(st.counts))
+	for k := range st.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].frame != keys[j].frame {
+			return keys[i].frame < keys[j].frame
+		}
+		return keys[i].outcome < keys[j].outcome
+	})
+
+	buf.WriteString("# HELP reverse_tunnel_frames_total Received control frames by type and handling outcome.\n")
+	buf.WriteString("# TYPE reverse_tunnel_frames_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(buf, "reverse_tunnel_frames_total{frame=%q,outcome=%q} %d\n", k.frame, k.outcome, st.counts[k])
+	}
+}
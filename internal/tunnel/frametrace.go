@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 帧方向
+const (
+	FrameTraceIn  = "in"  // 从控制连接读取的帧
+	FrameTraceOut = "out" // 写入控制连接的帧
+)
+
+// FrameTraceEvent 表示控制连接上的一个帧（只包含帧头信息，不包含负载内容）
+type FrameTraceEvent struct {
+	Time      time.Time
+	Direction string // FrameTraceIn / FrameTraceOut
+	Peer      string // 控制连接对端地址
+	Type      proto.FrameType
+	ConnID    uint32
+	Length    int // 负载长度
+}
+
+// FrameTracer 接收控制连接上读写的每个帧，用于排查帧错位或损坏（调用发生在读写路径上，实现应尽快返回）
+type FrameTracer interface {
+	TraceFrame(ev FrameTraceEvent)
+}
+
+// frameTraceWriter 将帧以紧凑的文本行写入 w：时间 方向 对端 类型 conn=ID len=负载长度（未知类型附带原始字节）
+type frameTraceWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewFrameTraceWriter 返回将每个帧写为一行文本的 FrameTracer（并发安全），例如：
+//
+//	2026-01-02T15:04:05.123456Z out 10.0.0.2:51000 data conn=7 len=4096
+func NewFrameTraceWriter(w io.Writer) FrameTracer {
+	return &frameTraceWriter{w: w}
+}
+
+func (t *frameTraceWriter) TraceFrame(ev FrameTraceEvent) {
+	typ := ev.Type.String()
+	if typ == "unknown" {
+		typ = fmt.Sprintf("unknown(0x%02x)", byte(ev.Type))
+	}
+	line := fmt.Sprintf("%s %s %s %s conn=%d len=%d\n",
+		ev.Time.UTC().Format("2006-01-02T15:04:05.000000Z"), ev.Direction, ev.Peer, typ, ev.ConnID, ev.Length)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, line)
+}
+
+// traceFrame 在 tracer 不为 nil 时记录一个帧
+func traceFrame(tracer FrameTracer, direction string, conn net.Conn, frame *proto.Frame) {
+	if tracer == nil {
+		return
+	}
+	tracer.TraceFrame(FrameTraceEvent{
+		Time:      time.Now(),
+		Direction: direction,
+		Peer:      conn.RemoteAddr().String(),
+		Type:      frame.Type,
+		ConnID:    frame.ConnID,
+		Length:    len(frame.Payload),
+	})
+}
+
+// readFrame 从控制连接读取一个帧，tracer 不为 nil 时记录该帧
+func readFrame(conn net.Conn, tracer FrameTracer) (*proto.Frame, error) {
+	return readFrameLimit(conn, tracer, proto.MaxPayloadSize, 0)
+}
+
+// readFrameLimit 与 readFrame 相同，但负载长度超过 maxPayload 的帧、负载长度超过 maxData 的 DATA 帧（0 表示不限制）
+// 在分配缓冲区之前被拒绝（见 proto.DecodeFrameLimits）
+func readFrameLimit(conn net.Conn, tracer FrameTracer, maxPayload, maxData uint32) (*proto.Frame, error) {
+	frame, err := proto.DecodeFrameLimits(conn, maxPayload, maxData)
+	if err == nil {
+		traceFrame(tracer, FrameTraceIn, conn, frame)
+	}
+	return frame, err
+}
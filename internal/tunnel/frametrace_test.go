@@ -0,0 +1,114 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// recordingTracer 记录收到的全部帧
+type recordingTracer struct {
+	mu     sync.Mutex
+	events []FrameTraceEvent
+}
+
+func (r *recordingTracer) TraceFrame(ev FrameTraceEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+// has 判断是否记录了指定方向和类型的帧
+func (r *recordingTracer) has(direction string, typ proto.FrameType) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range r.events {
+		if ev.Direction == direction && ev.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// TestFrameTrace 测试两端记录控制连接上读写的帧，以及帧跟踪文本行的格式
+func TestFrameTrace(t *testing.T) {
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverTrace, clientTrace := &recordingTracer{}, &recordingTracer{}
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerFrameTracer(serverTrace))
+	go server.Run(ctx)
+	client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local), WithFrameTracer(clientTrace))
+	go client.Run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(server.ClientStatus()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	msg := []byte("trace me")
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	go conn.Write(msg)
+	if _, err := io.ReadFull(conn, make([]byte, len(msg))); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	conn.Close()
+
+	want := []struct {
+		tracer    *recordingTracer
+		direction string
+		typ       proto.FrameType
+	}{
+		{clientTrace, FrameTraceOut, proto.FrameTypeHELLO},
+		{serverTrace, FrameTraceIn, proto.FrameTypeHELLO},
+		{serverTrace, FrameTraceOut, proto.FrameTypeNEW_CONN},
+		{clientTrace, FrameTraceIn, proto.FrameTypeNEW_CONN},
+		{serverTrace, FrameTraceOut, proto.FrameTypeDATA},
+		{clientTrace, FrameTraceOut, proto.FrameTypeDATA},
+		{serverTrace, FrameTraceIn, proto.FrameTypeDATA},
+	}
+	// 写入方在对端读取后才记录帧，等待记录完成
+	deadline = time.Now().Add(2 * time.Second)
+	for _, w := range want {
+		for !w.tracer.has(w.direction, w.typ) {
+			if time.Now().After(deadline) {
+				t.Fatalf("未记录 %s 方向的 %s 帧", w.direction, w.typ)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// 文本格式：未知类型附带原始字节，不包含负载内容
+	var buf bytes.Buffer
+	tracer := NewFrameTraceWriter(&buf)
+	when := time.Date(2026, 1, 2, 15, 4, 5, 123456000, time.UTC)
+	tracer.TraceFrame(FrameTraceEvent{Time: when, Direction: FrameTraceIn, Peer: "10.0.0.2:51000", Type: proto.FrameTypeDATA, ConnID: 7, Length: 4096})
+	tracer.TraceFrame(FrameTraceEvent{Time: when, Direction: FrameTraceOut, Peer: "10.0.0.2:51000", Type: proto.FrameType(0x7f), ConnID: 1})
+	wantLines := "2026-01-02T15:04:05.123456Z in 10.0.0.2:51000 data conn=7 len=4096\n" +
+		"2026-01-02T15:04:05.123456Z out 10.0.0.2:51000 unknown(0x7f) conn=1 len=0\n"
+	if got := buf.String(); got != wantLines {
+		t.Errorf("帧跟踪格式不符:\n期望 %q\n得到 %q", wantLines, got)
+	}
+	if strings.Contains(buf.String(), "trace me") {
+		t.Errorf("帧跟踪不应包含负载内容")
+	}
+}
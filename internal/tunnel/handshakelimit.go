@@ -0,0 +1,199 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// 并发握手达到上限时的策略
+const (
+	HandshakeLimitQueue  = "queue"  // 暂停接受新连接，等待正在进行的握手完成（默认，新连接在内核的 accept 队列中等待）
+	HandshakeLimitReject = "reject" // 直接关闭新连接
+)
+
+// splitHandshaker 可以把接受 TCP 连接和握手分开进行的监听器（*pqctls.PQCListener）
+type splitHandshaker interface {
+	net.Listener
+	AcceptTCP() (net.Conn, error)
+	Handshake(conn net.Conn) (net.Conn, error)
+}
+
+// handshakeLimitStats 并发握手限制的统计（供指标输出）
+type handshakeLimitStats struct {
+	inProgress atomic.Int64  // 正在进行的握手数
+	queued     atomic.Uint64 // 因达到上限而等待的连接数
+	rejected   atomic.Uint64 // 因达到上限而被关闭的连接数
+}
+
+// writeMetrics 写入并发握手限制的指标
+func (st *handshakeLimitStats) writeMetrics(buf *bytes.Buffer) {
+	buf.WriteString("# HELP reverse_tunnel_handshakes_in_progress Control connection TLS handshakes currently in progress.\n")
+	buf.WriteString("# TYPE reverse_tunnel_handshakes_in_progress gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_handshakes_in_progress %d\n", st.inProgress.Load())
+	buf.WriteString("# HELP reverse_tunnel_handshake_limit_queued_total Control connections that waited for a handshake slot because the concurrent handshake limit was reached.\n")
+	buf.WriteString("# TYPE reverse_tunnel_handshake_limit_queued_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_handshake_limit_queued_total %d\n", st.queued.Load())
+	buf.WriteString("# HELP reverse_tunnel_handshake_limit_rejected_total Control connections closed without a handshake because the concurrent handshake limit was reached.\n")
+	buf.WriteString("# TYPE reverse_tunnel_handshake_limit_rejected_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_handshake_limit_rejected_total %d\n", st.rejected.Load())
+}
+
+// acceptResult 后台握手的结果
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// handshakeLimitListener 在后台 goroutine 中并发进行握手，同时进行的握手不超过 max 个
+// 握手（尤其是 PQC 签名和密钥封装）消耗大量 CPU，且发生在认证之前，限制其并发数可以防止大量恶意连接耗尽 CPU；
+// 与客户端数量上限不同，它只约束握手阶段，握手完成的连接不占用名额
+type handshakeLimitListener struct {
+	inner  splitHandshaker
+	reject bool
+	stats  *handshakeLimitStats
+
+	slots   chan struct{} // 握手名额
+	results chan acceptResult
+	done    chan struct{}
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	rejectLog rateLimitedLog // 拒绝日志（受到攻击时每个连接都会被拒绝，限制日志频率）
+
+	mu       sync.Mutex
+	closed   bool
+	inFlight map[net.Conn]struct{} // 正在握手的连接（关闭监听器时一并关闭）
+	wg       sync.WaitGroup
+}
+
+// newHandshakeLimitListener 返回最多同时进行 max 个握手的监听器，policy 为 HandshakeLimitQueue 或 HandshakeLimitReject
+func newHandshakeLimitListener(inner splitHandshaker, max int, policy string, stats *handshakeLimitStats) *handshakeLimitListener {
+	if stats == nil {
+		stats = &handshakeLimitStats{}
+	}
+	return &handshakeLimitListener{
+		inner:    inner,
+		reject:   policy == HandshakeLimitReject,
+		stats:    stats,
+		slots:    make(chan struct{}, max),
+		results:  make(chan acceptResult),
+		done:     make(chan struct{}),
+		inFlight: make(map[net.Conn]struct{}),
+	}
+}
+
+// Accept 返回最先完成握手（或握手失败）的连接
+func (l *handshakeLimitListener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() { go l.acceptLoop() })
+	select {
+	case res := <-l.results:
+		return res.conn, res.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// acceptLoop 接受 TCP 连接，取得握手名额后在独立的 goroutine 中握手
+func (l *handshakeLimitListener) acceptLoop() {
+	for {
+		conn, err := l.inner.AcceptTCP()
+		if err != nil {
+			// 交给 Accept 的调用方处理（临时错误由其退避重试）
+			if !l.deliver(acceptResult{err: err}) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if l.reject {
+				l.stats.rejected.Add(1)
+				l.rejectLog.printf("并发握手数已达上限 (%d)，关闭控制连接: %s", cap(l.slots), conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
+			l.stats.queued.Add(1)
+			select {
+			case l.slots <- struct{}{}:
+			case <-l.done:
+				conn.Close()
+				return
+			}
+		}
+
+		if !l.track(conn) {
+			<-l.slots
+			conn.Close()
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+// track 登记正在握手的连接，监听器已关闭时返回 false
+func (l *handshakeLimitListener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.inFlight[conn] = struct{}{}
+	l.wg.Add(1)
+	return true
+}
+
+// handshake 完成握手后释放名额，并把结果交给 Accept
+func (l *handshakeLimitListener) handshake(conn net.Conn) {
+	defer l.wg.Done()
+
+	l.stats.inProgress.Add(1)
+	tlsConn, err := l.inner.Handshake(conn)
+	l.stats.inProgress.Add(-1)
+	<-l.slots
+
+	l.mu.Lock()
+	delete(l.inFlight, conn)
+	l.mu.Unlock()
+
+	if !l.deliver(acceptResult{conn: tlsConn, err: err}) && tlsConn != nil {
+		tlsConn.Close()
+	}
+}
+
+// deliver 把结果交给 Accept，监听器已关闭时返回 false
+func (l *handshakeLimitListener) deliver(res acceptResult) bool {
+	select {
+	case l.results <- res:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// Close 关闭监听器：中止正在进行的握手并等待其返回后再关闭内部监听器（内部监听器关闭时释放握手使用的资源）
+func (l *handshakeLimitListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.mu.Lock()
+		l.closed = true
+		for conn := range l.inFlight {
+			conn.Close()
+		}
+		l.mu.Unlock()
+		l.wg.Wait()
+		err = l.inner.Close()
+	})
+	return err
+}
+
+// Addr 返回监听地址
+func (l *handshakeLimitListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
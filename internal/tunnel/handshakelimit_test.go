@@ -0,0 +1,171 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeHandshaker 模拟分开接受和握手的监听器：conns 中的连接依次被接受，
+// 每次握手阻塞到从 release 收到值（成功）或连接被关闭（失败）
+type fakeHandshaker struct {
+	conns   chan net.Conn
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func newFakeHandshaker() *fakeHandshaker {
+	return &fakeHandshaker{conns: make(chan net.Conn, 16), release: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (f *fakeHandshaker) AcceptTCP() (net.Conn, error) {
+	select {
+	case conn := <-f.conns:
+		return conn, nil
+	case <-f.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (f *fakeHandshaker) Handshake(conn net.Conn) (net.Conn, error) {
+	aborted := make(chan struct{})
+	go func() {
+		conn.Read(make([]byte, 1))
+		close(aborted)
+	}()
+	select {
+	case <-f.release:
+		return conn, nil
+	case <-aborted:
+		return nil, errors.New("connection closed")
+	}
+}
+
+func (f *fakeHandshaker) Accept() (net.Conn, error) { return nil, errors.New("not used") }
+func (f *fakeHandshaker) Close() error              { close(f.closed); return nil }
+func (f *fakeHandshaker) Addr() net.Addr            { return &net.TCPAddr{} }
+
+// push 提交一个待接受的连接，返回对端（用于判断连接是否被关闭）
+func (f *fakeHandshaker) push() net.Conn {
+	local, remote := net.Pipe()
+	f.conns <- local
+	return remote
+}
+
+// waitStat 等待 get 返回 want
+func waitStat[T comparable](t *testing.T, what string, get func() T, want T) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for get() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: 期望 %v, 得到 %v", what, want, get())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestHandshakeLimitReject 测试达到并发握手上限时 reject 策略直接关闭新连接，握手完成后释放名额
+func TestHandshakeLimitReject(t *testing.T) {
+	inner := newFakeHandshaker()
+	stats := &handshakeLimitStats{}
+	l := newHandshakeLimitListener(inner, 2, HandshakeLimitReject, stats)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	inner.push()
+	inner.push()
+	waitStat(t, "进行中的握手", stats.inProgress.Load, 2)
+
+	rejected := inner.push()
+	waitStat(t, "被拒绝的连接", stats.rejected.Load, 1)
+	rejected.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("超出上限的连接应被关闭, 得到 %v", err)
+	}
+
+	// 一个握手完成后名额释放，新连接可以握手
+	inner.release <- struct{}{}
+	select {
+	case <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("完成握手的连接未被 Accept 返回")
+	}
+	inner.push()
+	waitStat(t, "进行中的握手", stats.inProgress.Load, 2)
+	if n := stats.rejected.Load(); n != 1 {
+		t.Errorf("名额释放后不应拒绝连接, 拒绝数 %d", n)
+	}
+}
+
+// TestHandshakeLimitQueue 测试达到并发握手上限时 queue 策略等待名额，并输出对应的指标；关闭监听器时中止进行中的握手
+func TestHandshakeLimitQueue(t *testing.T) {
+	inner := newFakeHandshaker()
+	stats := &handshakeLimitStats{}
+	l := newHandshakeLimitListener(inner, 1, HandshakeLimitQueue, stats)
+
+	results := make(chan error, 4)
+	go func() {
+		for {
+			_, err := l.Accept()
+			results <- err
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+		}
+	}()
+
+	inner.push()
+	waitStat(t, "进行中的握手", stats.inProgress.Load, 1)
+	inner.push()
+	waitStat(t, "等待名额的连接", stats.queued.Load, 1)
+	if n := stats.inProgress.Load(); n != 1 {
+		t.Fatalf("等待名额的连接不应开始握手, 进行中 %d", n)
+	}
+
+	// 第一个握手完成后，等待的连接开始握手
+	inner.release <- struct{}{}
+	if err := <-results; err != nil {
+		t.Fatalf("握手应成功: %v", err)
+	}
+	waitStat(t, "进行中的握手", stats.inProgress.Load, 1)
+
+	var buf bytes.Buffer
+	stats.writeMetrics(&buf)
+	for _, want := range []string{
+		"reverse_tunnel_handshakes_in_progress 1",
+		"reverse_tunnel_handshake_limit_queued_total 1",
+		"reverse_tunnel_handshake_limit_rejected_total 0",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("指标输出缺少 %q:\n%s", want, buf.String())
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("关闭监听器应中止进行中的握手")
+	}
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("关闭后 Accept 应返回 net.ErrClosed, 得到 %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// runHealthChecks 每隔 healthCheckInterval 向协商了 FeatureHealthCheck 的客户端发送 HEALTH_CHECK，
+// 要求其检查本地服务并回复 HEALTH_REPORT。上一次探测在下一次探测前仍未回复的客户端同样视为不健康
+func (s *Server) runHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(s.healthCheckInterval)
+	defer ticker.Stop()
+
+	var seq uint32
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.clientsMu.RLock()
+		var probed []*ClientInfo
+		for _, info := range s.clients {
+			if info.Features&proto.FeatureHealthCheck != 0 {
+				probed = append(probed, info)
+			}
+		}
+		s.clientsMu.RUnlock()
+
+		for _, info := range probed {
+			if info.healthProbe.Load() != 0 {
+				s.setClientHealth(info, false, "未在探测间隔内回复健康检查")
+			}
+			// 序号只用于匹配回复，0 表示没有未回复的探测
+			if seq++; seq == 0 {
+				seq++
+			}
+			info.healthProbe.Store(seq)
+			frame := &proto.Frame{Type: proto.FrameTypeHEALTH_CHECK, ConnID: seq}
+			// 每个客户端独立发送，写入阻塞的客户端不拖慢对其他客户端的探测
+			go func(info *ClientInfo) {
+				if err := writeFrame(info.Conn, &info.writeMu, frame, s.writeTimeout()); err != nil {
+					log.Printf("发送健康检查失败 (clientID=%s): %v", info.ID, err)
+				}
+			}(info)
+		}
+	}
+}
+
+// handleHealthReport 处理客户端的 HEALTH_REPORT 帧，返回处理结果（用于帧计数）
+// 只接受对最近一次探测的回复，过期的回复被忽略
+func (s *Server) handleHealthReport(clientID string, frame *proto.Frame) string {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return frameIgnored
+	}
+
+	report, err := proto.DecodeHealthReport(frame.Payload)
+	if err != nil {
+		log.Printf("忽略无效的健康检查结果 (clientID=%s): %v", clientID, err)
+		return frameParseError
+	}
+	if frame.ConnID == 0 || !clientInfo.healthProbe.CompareAndSwap(frame.ConnID, 0) {
+		return frameIgnored
+	}
+	s.setClientHealth(clientInfo, report.Healthy, report.Detail)
+	return frameOK
+}
+
+// setClientHealth 更新客户端的健康状态，状态变化时记录日志
+// 不健康的客户端不参与全局公开端口的路由（见 routeGlobalConn），恢复健康后重新参与
+func (s *Server) setClientHealth(clientInfo *ClientInfo, healthy bool, detail string) {
+	if clientInfo.unhealthy.Swap(!healthy) == !healthy {
+		return
+	}
+	if healthy {
+		log.Printf("客户端的本地服务已恢复，重新参与路由 (clientID=%s)", clientInfo.ID)
+		return
+	}
+	log.Printf("客户端的本地服务不可用，暂停向其路由新连接 (clientID=%s): %s", clientInfo.ID, detail)
+}
+
+// healthyClients 返回 candidates 中健康的客户端；全部不健康时原样返回，
+// 避免同一路由键的客户端因本地服务短暂故障（或探测本身出错）全部不可用
+func healthyClients(candidates []*ClientInfo) []*ClientInfo {
+	healthy := make([]*ClientInfo, 0, len(candidates))
+	for _, info := range candidates {
+		if !info.unhealthy.Load() {
+			healthy = append(healthy, info)
+		}
+	}
+	if len(healthy) == 0 {
+		return candidates
+	}
+	return healthy
+}
+
+// handleHealthCheck 处理服务器的 HEALTH_CHECK 帧：连接本地服务（localAddr 中的全部后端，任一可达即视为健康），
+// 以相同的序号回复 HEALTH_REPORT。检查在独立的 goroutine 中进行，不阻塞帧处理
+func (c *Client) handleHealthCheck(frame *proto.Frame) {
+	go func() {
+		report := &proto.HealthReport{}
+		var failures []string
+		for _, addr := range ParseLocalBackends(c.localAddr) {
+			conn, err := c.dialLocal(addr)
+			if err == nil {
+				conn.Close()
+				report.Healthy = true
+				break
+			}
+			failures = append(failures, err.Error())
+		}
+		if !report.Healthy {
+			report.Detail = strings.Join(failures, ", ")
+		}
+
+		c.controlMu.RLock()
+		controlConn := c.controlConn
+		c.controlMu.RUnlock()
+		if controlConn == nil {
+			return
+		}
+		reply := &proto.Frame{Type: proto.FrameTypeHEALTH_REPORT, ConnID: frame.ConnID, Payload: proto.EncodeHealthReport(report)}
+		if err := writeFrame(controlConn, &c.controlWriteMu, reply, c.controlWriteTimeout); err != nil {
+			log.Printf("发送健康检查结果失败: %v", err)
+		}
+	}()
+}
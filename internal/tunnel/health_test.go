@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestHealthCheckRouting 测试健康检查报告本地服务不可用的客户端不参与路由，本地服务恢复后重新参与
+func TestHealthCheckRouting(t *testing.T) {
+	control := newMemListener("control")
+	public := newMemListener("public")
+	healthyLocal := newMemListener("healthy-local")
+	defer healthyLocal.Close()
+	go serveMemEcho(healthyLocal)
+	downLocal := newMemListener("down-local")
+	downLocal.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerHealthCheck(20*time.Millisecond), WithServerGlobalRouting(GlobalRoutingWeighted))
+	go server.Run(ctx)
+
+	// 先连接本地服务不可用的客户端（ID 更小，未启用健康检查时会分到一半连接）
+	down := NewClient("control", "down-local", 0, WithControlDialer(control), WithLocalDialer(downLocal))
+	go down.Run(ctx)
+	waitFor := func(what string, cond func([]ClientStatus) bool) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for !cond(server.ClientStatus()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("等待%s超时: %+v", what, server.ClientStatus())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("客户端注册", func(st []ClientStatus) bool { return len(st) == 1 })
+	up := NewClient("control", "healthy-local", 0, WithControlDialer(control), WithLocalDialer(healthyLocal))
+	go up.Run(ctx)
+	waitFor("不可用的客户端被标记为不健康", func(st []ClientStatus) bool {
+		return len(st) == 2 && !st[0].Healthy && st[1].Healthy
+	})
+
+	for i := 0; i < 4; i++ {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		go conn.Write([]byte("ping"))
+		if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+			t.Fatalf("连接 %d 应路由到健康的客户端: %v", i, err)
+		}
+		conn.Close()
+	}
+
+	// 报告不可用的客户端恢复后重新参与路由
+	server.clientsMu.RLock()
+	var downInfo *ClientInfo
+	for _, info := range server.clients {
+		if info.unhealthy.Load() {
+			downInfo = info
+		}
+	}
+	server.clientsMu.RUnlock()
+	server.setClientHealth(downInfo, true, "")
+	if got := healthyClients([]*ClientInfo{downInfo}); len(got) != 1 {
+		t.Errorf("恢复健康的客户端应参与路由")
+	}
+}
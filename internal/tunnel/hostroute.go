@@ -0,0 +1,384 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 公开连接主机名探测参数
+const (
+	hostPeekTimeout = 3 * time.Second // 等待首包的最长时间
+	hostPeekMaxSize = 16*1024 + 5     // 最多预读的字节数（一个完整的 TLS 记录）
+)
+
+// ValidateHostnamePattern 校验主机名路由键
+// 支持精确主机名（app.example.com）和前导通配符（*.preview.example.com）
+func ValidateHostnamePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("主机名不能为空")
+	}
+	name := strings.TrimPrefix(pattern, "*.")
+	if strings.Contains(name, "*") {
+		return fmt.Errorf("主机名 %q 中的通配符只能作为第一个标签（例如 *.example.com）", pattern)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("主机名 %q 格式无效", pattern)
+		}
+	}
+	return nil
+}
+
+// certCoversHostname 判断证书的 DNS SAN 是否覆盖主机名路由键 pattern（防止客户端声明其他租户的主机名）
+// SAN 与路由键相同时覆盖；通配符 SAN 按 x509 语义只匹配最左侧的一个标签：*.example.com 覆盖 app.example.com，
+// 不覆盖 x.app.example.com 和 *.app.example.com，否则持有 *.example.com 的租户可以用精确主机名抢占 *.app.example.com 租户的流量
+func certCoversHostname(cert *x509.Certificate, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	for _, san := range cert.DNSNames {
+		san = strings.ToLower(strings.TrimSuffix(san, "."))
+		if san == pattern {
+			return true
+		}
+		if !strings.HasPrefix(san, "*.") || !strings.HasSuffix(pattern, san[1:]) {
+			continue
+		}
+		label := strings.TrimSuffix(pattern, san[1:])
+		if label != "" && label != "*" && !strings.Contains(label, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHostname 判断 host 是否匹配路由键 pattern，返回匹配的具体程度（越大越具体，-1 表示不匹配）
+// 精确匹配优先于任何通配符；通配符 *.example.com 匹配 example.com 的任意层级子域名，后缀越长越具体
+func matchHostname(pattern, host string) int {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if !strings.HasPrefix(pattern, "*.") {
+		if pattern == host {
+			return 1 << 16
+		}
+		return -1
+	}
+
+	suffix := pattern[1:] // ".example.com"
+	if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+		return len(suffix)
+	}
+	return -1
+}
+
+// 全局公开端口的路由策略（WithServerGlobalRouting）
+const (
+	GlobalRoutingHostname   = "hostname"    // 只按主机名路由：没有匹配主机名的连接不交给未声明主机名的客户端
+	GlobalRoutingRoundRobin = "round-robin" // 按主机名路由，匹配同一路由键的多个客户端轮流接收连接（忽略权重）
+	GlobalRoutingWeighted   = "weighted"    // 按主机名路由，匹配同一路由键的多个客户端按声明的权重平滑加权轮询
+)
+
+// ambiguousRouteWarnInterval 路由歧义警告的最小间隔
+const ambiguousRouteWarnInterval = 30 * time.Second
+
+// routeGlobalConn 为全局监听器上的公开连接选择客户端
+// 启用公开端口 TLS 时先终止 TLS，按握手的 SNI 路由；否则有客户端注册了主机名时预读 SNI/Host。
+// 精确匹配优先，其次是最具体的通配符，
+// 都不匹配时使用未注册主机名的客户端（路由策略为 GlobalRoutingHostname 时不使用）。
+// 多个客户端同样匹配时（同一主机名的多个副本、多个未注册主机名的客户端）按路由策略轮流或按客户端在 INIT 中声明的权重平滑加权轮询，
+// 未配置路由策略时拒绝该连接（按没有可用客户端处理），
+// 断开的客户端已被注销，不再参与选择，其份额自然转移到其余客户端；健康检查报告本地服务不可用的客户端同样被跳过
+// （同一路由键的客户端全部不健康时仍在其中选择）。启用按客户端的份额限制时同样跳过份额已用完的客户端（全部用完时由路由阶段拒绝连接）。
+// 返回的连接可能包含预读数据，应替代原连接使用；TLS 握手失败时连接已被关闭，返回的连接为 nil。
+// clientID 为空表示没有可用客户端（由调用方按 noClientPolicy 处理）。
+// host 为路由使用的主机名（未探测或无法识别时为空），随 NEW_CONN 发给客户端按主机名选择本地服务
+func (s *Server) routeGlobalConn(conn net.Conn) (_ net.Conn, clientID, host string) {
+	if s.publicTLS != nil {
+		tlsConn, err := terminatePublicTLS(conn, s.publicTLS)
+		if err != nil {
+			log.Printf("公开连接 TLS 握手失败，关闭连接: %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return nil, "", ""
+		}
+		conn, host = tlsConn, tlsConn.ConnectionState().ServerName
+	} else if s.hostRoutingActive() {
+		conn, host = peekHostname(conn)
+	}
+	if host != "" && !validRouteHost(host) {
+		// SNI/Host 来自公开连接的对端，不合法的主机名既不参与路由也不随 NEW_CONN 发给客户端
+		log.Printf("公开连接的主机名无效，按未提供主机名处理: %s: %q", conn.RemoteAddr(), host)
+		host = ""
+	}
+	return conn, s.pickGlobalClient(host), host
+}
+
+// validRouteHost 判断公开连接提供的主机名是否合法：符合 ValidateHostnamePattern 的规则（不含通配符），
+// 且只包含字母、数字、连字符、下划线和点（不含 ; = : 等分隔符、空白和控制字符）
+func validRouteHost(host string) bool {
+	if strings.Contains(host, "*") || ValidateHostnamePattern(host) != nil {
+		return false
+	}
+	for i := 0; i < len(host); i++ {
+		switch c := host[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// hostRoutingActive 判断是否有客户端注册了主机名（此时全局监听器的连接需要预读 SNI/Host）
+func (s *Server) hostRoutingActive() bool {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	for _, info := range s.clients {
+		if len(info.Hostnames) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// pickGlobalClient 按主机名 host 从当前客户端中选择一个（规则见 routeGlobalConn），没有可用客户端时返回空
+func (s *Server) pickGlobalClient(host string) string {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	var best, fallback []*ClientInfo
+	bestScore := -1
+	for _, info := range s.clients {
+		if len(info.Hostnames) == 0 {
+			fallback = append(fallback, info)
+			continue
+		}
+		if host == "" {
+			continue
+		}
+		score := -1
+		for _, hostname := range info.Hostnames {
+			score = max(score, matchHostname(hostname, host))
+		}
+		switch {
+		case score < 0 || score < bestScore:
+		case score > bestScore:
+			best, bestScore = []*ClientInfo{info}, score
+		default:
+			best = append(best, info)
+		}
+	}
+
+	var candidates []*ClientInfo
+	switch {
+	case len(best) > 0:
+		candidates = best
+	case len(fallback) > 0 && s.globalRouting != GlobalRoutingHostname:
+		candidates = fallback
+	default:
+		return ""
+	}
+	// 未配置路由策略时不在多个客户端之间分配：连接按没有可用客户端处理，而不是交给其中任意一个
+	if s.globalRouting == "" && len(candidates) > 1 {
+		s.warnAmbiguousRoute(host, candidates)
+		return ""
+	}
+	return s.pickWeighted(setupAvailable(s.clientShare.available(healthyClients(activeClients(candidates)))))
+}
+
+// warnAmbiguousRoute 记录未配置路由策略时多个客户端匹配同一连接的警告（每 ambiguousRouteWarnInterval 最多一次）
+func (s *Server) warnAmbiguousRoute(host string, candidates []*ClientInfo) {
+	now := time.Now().UnixNano()
+	last := s.ambiguousRouteWarnAt.Load()
+	if now-last < int64(ambiguousRouteWarnInterval) || !s.ambiguousRouteWarnAt.CompareAndSwap(last, now) {
+		return
+	}
+	ids := make([]string, 0, len(candidates))
+	for _, info := range candidates {
+		ids = append(ids, info.ID)
+	}
+	sort.Strings(ids)
+	log.Printf("警告: %d 个客户端 %v 匹配全局公开端口的同一连接 (主机名=%q)，未配置路由策略（global_routing），拒绝该连接；"+
+		"请为客户端声明不同的主机名，或配置 %s / %s / %s", len(ids), ids, host, GlobalRoutingHostname, GlobalRoutingRoundRobin, GlobalRoutingWeighted)
+}
+
+// MaxClientWeight 客户端负载均衡权重的最大值
+const MaxClientWeight = 1000
+
+// pickWeighted 按权重从匹配同一路由键的客户端中选择一个（平滑加权轮询，同 nginx upstream）：
+// 每次选择时每个候选的当前值加上其权重，选出当前值最大的（相同时取 ID 最小的），再从其当前值中减去权重总和。
+// 权重为 3:1 时 4 次选择依次为 A A B A 这样交错分布，而不是连续选中同一个客户端。
+// 路由策略为 GlobalRoutingRoundRobin 时忽略客户端声明的权重，按相同权重轮流选择。调用方持有 clientsMu 读锁
+func (s *Server) pickWeighted(candidates []*ClientInfo) string {
+	if len(candidates) == 1 {
+		return candidates[0].ID
+	}
+	s.balanceMu.Lock()
+	defer s.balanceMu.Unlock()
+	var chosen *ClientInfo
+	total := 0
+	for _, info := range candidates {
+		weight := max(info.Weight, 1)
+		if s.globalRouting == GlobalRoutingRoundRobin {
+			weight = 1
+		}
+		total += weight
+		info.balance += weight
+		if chosen == nil || info.balance > chosen.balance || (info.balance == chosen.balance && info.ID < chosen.ID) {
+			chosen = info
+		}
+	}
+	chosen.balance -= total
+	return chosen.ID
+}
+
+// peekedConn 在预读首包后仍能完整读出原始数据的连接
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read 先返回预读缓冲中的数据，再从底层连接读取
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// peekHostname 预读公开连接的首包，提取 TLS SNI 或 HTTP Host
+// 返回的连接包含已预读的数据；无法识别时主机名为空
+func peekHostname(conn net.Conn) (net.Conn, string) {
+	r := bufio.NewReaderSize(conn, hostPeekMaxSize)
+	pc := &peekedConn{Conn: conn, r: r}
+
+	conn.SetReadDeadline(time.Now().Add(hostPeekTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	first, err := r.Peek(1)
+	if err != nil {
+		return pc, ""
+	}
+
+	if first[0] == 0x16 {
+		// TLS 握手记录：5 字节记录头 + ClientHello
+		header, err := r.Peek(5)
+		if err != nil {
+			return pc, ""
+		}
+		recordLen := int(binary.BigEndian.Uint16(header[3:5]))
+		record, err := r.Peek(5 + recordLen)
+		if err != nil {
+			return pc, ""
+		}
+		return pc, parseClientHelloSNI(record[5:])
+	}
+
+	// 按 HTTP 请求解析，读到请求头结束或缓冲区满为止
+	if header := peekHTTPHeader(r); header != nil {
+		return pc, parseHTTPHost(header[:len(header)-4])
+	}
+	return pc, ""
+}
+
+// peekHTTPHeader 预读到 HTTP 请求头结束，返回包括结尾 \r\n\r\n 在内的请求头（只在下一次读取 r 之前有效）
+// 超过 hostPeekMaxSize 仍未结束或读取失败时返回 nil
+func peekHTTPHeader(r *bufio.Reader) []byte {
+	for {
+		buf, _ := r.Peek(r.Buffered())
+		if end := bytes.Index(buf, []byte("\r\n\r\n")); end >= 0 {
+			return buf[:end+4]
+		}
+		if r.Buffered() >= hostPeekMaxSize {
+			return nil
+		}
+		if _, err := r.Peek(r.Buffered() + 1); err != nil {
+			return nil
+		}
+	}
+}
+
+// parseHTTPHost 从 HTTP 请求头中提取 Host（去掉端口）
+func parseHTTPHost(header []byte) string {
+	for _, line := range strings.Split(string(header), "\r\n")[1:] {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "Host") {
+			host := strings.TrimSpace(kv[1])
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				return h
+			}
+			return host
+		}
+	}
+	return ""
+}
+
+// parseClientHelloSNI 从 TLS ClientHello 握手消息中提取 server_name 扩展
+func parseClientHelloSNI(msg []byte) string {
+	// handshake type(1) + length(3) + version(2) + random(32)
+	if len(msg) < 38 || msg[0] != 0x01 {
+		return ""
+	}
+	p := msg[38:]
+
+	// session_id
+	if len(p) < 1 || len(p) < 1+int(p[0]) {
+		return ""
+	}
+	p = p[1+int(p[0]):]
+
+	// cipher_suites
+	if len(p) < 2 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(p))
+	if len(p) < 2+n {
+		return ""
+	}
+	p = p[2+n:]
+
+	// compression_methods
+	if len(p) < 1 || len(p) < 1+int(p[0]) {
+		return ""
+	}
+	p = p[1+int(p[0]):]
+
+	// extensions
+	if len(p) < 2 {
+		return ""
+	}
+	n = int(binary.BigEndian.Uint16(p))
+	p = p[2:]
+	if len(p) < n {
+		return ""
+	}
+	p = p[:n]
+
+	for len(p) >= 4 {
+		extType := binary.BigEndian.Uint16(p)
+		extLen := int(binary.BigEndian.Uint16(p[2:]))
+		p = p[4:]
+		if len(p) < extLen {
+			return ""
+		}
+		ext := p[:extLen]
+		p = p[extLen:]
+
+		if extType != 0x0000 { // server_name
+			continue
+		}
+		// server_name_list length(2) + name_type(1) + name length(2) + name
+		if len(ext) < 5 || ext[2] != 0x00 {
+			return ""
+		}
+		nameLen := int(binary.BigEndian.Uint16(ext[3:]))
+		if len(ext) < 5+nameLen {
+			return ""
+		}
+		return string(ext[5 : 5+nameLen])
+	}
+	return ""
+}
@@ -0,0 +1,503 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestHostnameRoutingPrecedence 测试精确匹配优先于通配符、更具体的通配符优先，以及不匹配时的回退
+func TestHostnameRoutingPrecedence(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0")
+	for id, hostnames := range map[string][]string{
+		"client-1": {"*.example.com"},
+		"client-2": {"*.preview.example.com"},
+		"client-3": {"app.preview.example.com", "app.example.org"},
+		"client-4": nil,
+	} {
+		server.clients[id] = &ClientInfo{ID: id, Hostnames: hostnames}
+	}
+
+	route := func(host string) string {
+		publicConn, peer := net.Pipe()
+		defer publicConn.Close()
+		defer peer.Close()
+
+		request := "GET / HTTP/1.1\r\nHost: " + host + ":8080\r\n\r\n"
+		go peer.Write([]byte(request))
+
+		conn, clientID, _ := server.routeGlobalConn(publicConn)
+
+		// 预读的数据必须能完整读出
+		buf := make([]byte, len(request))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != request {
+			t.Errorf("预读后无法读出原始请求 (host=%s): %q, %v", host, buf, err)
+		}
+		return clientID
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"app.preview.example.com", "client-3"},
+		{"APP.preview.example.com", "client-3"},
+		{"app.example.org", "client-3"},
+		{"pr-42.preview.example.com", "client-2"},
+		{"a.b.preview.example.com", "client-2"},
+		{"www.example.com", "client-1"},
+		{"example.com", "client-4"},
+		{"other.org", "client-4"},
+	}
+	for _, tt := range tests {
+		if got := route(tt.host); got != tt.want {
+			t.Errorf("host=%s: 期望路由到 %s, 得到 %s", tt.host, tt.want, got)
+		}
+	}
+
+	// 没有回退客户端时，不匹配的主机名不应路由到任何客户端
+	delete(server.clients, "client-4")
+	if got := route("other.org"); got != "" {
+		t.Errorf("不匹配的主机名不应被路由，得到 %s", got)
+	}
+
+	for _, bad := range []string{"", "a.*.example.com", "**.example.com", "example..com"} {
+		if err := ValidateHostnamePattern(bad); err == nil {
+			t.Errorf("ValidateHostnamePattern(%q) 应返回错误", bad)
+		}
+	}
+}
+
+// TestPeekTLSServerName 测试从 TLS ClientHello 中提取 SNI
+func TestPeekTLSServerName(t *testing.T) {
+	publicConn, peer := net.Pipe()
+	defer publicConn.Close()
+	defer peer.Close()
+
+	go tls.Client(peer, &tls.Config{ServerName: "sni.preview.example.com", InsecureSkipVerify: true}).Handshake()
+
+	_, host := peekHostname(publicConn)
+	if host != "sni.preview.example.com" {
+		t.Errorf("期望 SNI 为 sni.preview.example.com, 得到 %q", host)
+	}
+}
+
+// TestHostnameSANValidation 测试使用证书认证的客户端只能声明证书 SAN 覆盖的主机名
+func TestHostnameSANValidation(t *testing.T) {
+	certPEM, keyPEM := generateTestCert(t)
+	serverCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("加载服务器证书失败: %v", err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听控制端口失败: %v", err)
+	}
+	controlListener := tls.NewListener(inner, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer("", publicAddr, WithServerControlListener(controlListener))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	clientCert := generateSANCert(t, "tenant-a", "*.tenant-a.example.com", "tenant-a.example.org")
+	init := func(hostnames ...string) proto.FrameType {
+		conn, err := tls.Dial("tcp", inner.Addr().String(), &tls.Config{
+			Certificates:       []tls.Certificate{clientCert},
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("连接服务器失败: %v", err)
+		}
+		defer conn.Close()
+
+		payload := proto.EncodeInitConfig(&proto.InitConfig{Hostnames: hostnames})
+		if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeINIT, Payload: payload}, time.Second); err != nil {
+			t.Fatalf("发送 INIT 失败: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		frame, err := proto.DecodeFrame(conn)
+		if err != nil {
+			t.Fatalf("读取 INIT 结果失败: %v", err)
+		}
+		return frame.Type
+	}
+
+	tests := []struct {
+		hostnames []string
+		want      proto.FrameType
+	}{
+		{[]string{"app.tenant-a.example.com"}, proto.FrameTypeASSIGNED},
+		{[]string{"*.tenant-a.example.com", "tenant-a.example.org"}, proto.FrameTypeASSIGNED},
+		// 通配符 SAN 只覆盖一个标签
+		{[]string{"x.pr.tenant-a.example.com"}, proto.FrameTypeERROR},
+		{[]string{"*.pr.tenant-a.example.com"}, proto.FrameTypeERROR},
+		{[]string{"app.tenant-b.example.com"}, proto.FrameTypeERROR},
+		{[]string{"tenant-a.example.com"}, proto.FrameTypeERROR},
+		{[]string{"app.tenant-a.example.com", "www.tenant-a.example.org"}, proto.FrameTypeERROR},
+	}
+	for _, tt := range tests {
+		if got := init(tt.hostnames...); got != tt.want {
+			t.Errorf("hostnames=%v: 期望 %s 帧, 得到 %s", tt.hostnames, tt.want, got)
+		}
+	}
+}
+
+// generateSANCert 生成带有指定 CN 和 DNS SAN 的自签名客户端证书
+func generateSANCert(t *testing.T, cn string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshakeListener 在 Accept 时完成 TLS 握手，使注册客户端时即可读取证书身份
+type handshakeListener struct {
+	net.Listener
+}
+
+func (l handshakeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return l.Accept()
+		}
+	}
+	return conn, nil
+}
+
+// TestWildcardSubdomainRouting 测试策略按身份分配子域名，服务器用通配符证书终止公开 TLS 后按 SNI 路由到对应客户端
+func TestWildcardSubdomainRouting(t *testing.T) {
+	certPEM, keyPEM := generateTestCert(t)
+	serverCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("加载服务器证书失败: %v", err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听控制端口失败: %v", err)
+	}
+	controlListener := handshakeListener{tls.NewListener(inner, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})}
+
+	policy := &PolicyStore{
+		Default: &ClientQuota{},
+		Hostnames: map[string][]string{
+			"alice": {"dev-alice.tunnel.example.com"},
+			"bob":   {"dev-bob.tunnel.example.com"},
+		},
+	}
+	wildcardCert := generateSANCert(t, "tunnel.example.com", "*.tunnel.example.com")
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer("", publicAddr,
+		WithServerControlListener(controlListener),
+		WithServerPolicy(policy),
+		WithServerPublicTLS(&tls.Config{Certificates: []tls.Certificate{wildcardCert}}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+
+	for _, name := range []string{"alice", "bob"} {
+		local, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("监听本地服务失败: %v", err)
+		}
+		defer local.Close()
+		go func(name string) {
+			for {
+				conn, err := local.Accept()
+				if err != nil {
+					return
+				}
+				io.WriteString(conn, name)
+				conn.Close()
+			}
+		}(name)
+
+		dialer := &tls.Dialer{Config: &tls.Config{
+			Certificates:       []tls.Certificate{generateSANCert(t, name)},
+			InsecureSkipVerify: true,
+		}}
+		client := NewClient(inner.Addr().String(), local.Addr().String(), 0, WithControlDialer(dialer))
+		go client.Run(ctx)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(server.ClientStatus()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for _, name := range []string{"alice", "bob", "alice"} {
+		conn, err := tls.Dial("tcp", publicAddr, &tls.Config{
+			ServerName:         "dev-" + name + ".tunnel.example.com",
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("连接公开端口失败: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		got, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("读取响应失败: %v", err)
+		}
+		if string(got) != name {
+			t.Errorf("SNI dev-%s: 期望路由到 %q, 得到 %q", name, name, got)
+		}
+	}
+}
+
+// TestSNIPassthroughHostRoutes 测试服务器不终止 TLS 时按 SNI 路由，客户端按主机名路由规则把原始 TLS 流量（包括预读的 ClientHello）
+// 转发到各自持有证书的本地服务，外部访问者看到的是本地服务的证书
+func TestSNIPassthroughHostRoutes(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, publicAddr)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	var routes []HostRoute
+	for _, hostname := range []string{"a.example.com", "b.example.com"} {
+		local, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{generateSANCert(t, hostname)}})
+		if err != nil {
+			t.Fatalf("监听本地 TLS 服务失败: %v", err)
+		}
+		defer local.Close()
+		go func() {
+			for {
+				conn, err := local.Accept()
+				if err != nil {
+					return
+				}
+				tlsConn := conn.(*tls.Conn)
+				if tlsConn.Handshake() == nil {
+					io.WriteString(tlsConn, tlsConn.ConnectionState().ServerName)
+				}
+				conn.Close()
+			}
+		}()
+		route, err := ParseHostRoute(hostname, local.Addr().String())
+		if err != nil {
+			t.Fatalf("解析主机名路由失败: %v", err)
+		}
+		routes = append(routes, route)
+	}
+	if _, err := ParseHostRoute("a.*.example.com", "127.0.0.1:1"); err == nil {
+		t.Error("无效主机名应返回错误")
+	}
+
+	client := NewClient(controlAddr, "127.0.0.1:1", 0, WithHostRoutes(routes))
+	go client.Run(ctx)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		server.clientsMu.RLock()
+		registered := 0
+		for _, info := range server.clients {
+			registered = len(info.Hostnames)
+		}
+		server.clientsMu.RUnlock()
+		if registered == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能注册主机名路由")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, hostname := range []string{"a.example.com", "b.example.com", "a.example.com"} {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 3 * time.Second}, "tcp", publicAddr, &tls.Config{
+			ServerName:         hostname,
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("连接公开端口失败 (%s): %v", hostname, err)
+		}
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		if certs := conn.ConnectionState().PeerCertificates; len(certs) == 0 || certs[0].Subject.CommonName != hostname {
+			t.Errorf("%s: 应看到本地服务自己的证书", hostname)
+		}
+		got, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil || string(got) != hostname {
+			t.Errorf("%s: 应转发到对应的本地服务，得到 %q, %v", hostname, got, err)
+		}
+	}
+}
+
+// TestWeightedHostnameRouting 测试多个客户端注册同一主机名时按权重分配连接，客户端断开后其份额转移到其余客户端
+func TestWeightedHostnameRouting(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", WithServerGlobalRouting(GlobalRoutingWeighted))
+	for id, weight := range map[string]int{"client-1": 3, "client-2": 1, "client-3": 0} {
+		server.clients[id] = &ClientInfo{ID: id, Hostnames: []string{"app.example.com"}, Weight: weight}
+	}
+	server.clients["client-4"] = &ClientInfo{ID: "client-4", Hostnames: []string{"*.example.com"}}
+
+	route := func(n int) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			publicConn, peer := net.Pipe()
+			go peer.Write([]byte("GET / HTTP/1.1\r\nHost: app.example.com\r\n\r\n"))
+			_, clientID, _ := server.routeGlobalConn(publicConn)
+			counts[clientID]++
+			publicConn.Close()
+			peer.Close()
+		}
+		return counts
+	}
+
+	// 权重 3:1:1（未声明权重按 1 计），不那么具体的通配符客户端不参与
+	got := route(50)
+	if got["client-1"] != 30 || got["client-2"] != 10 || got["client-3"] != 10 || got["client-4"] != 0 {
+		t.Errorf("按权重 3:1:1 分配 50 个连接，得到 %v", got)
+	}
+
+	// 客户端断开（注销）后其份额按权重转移到其余客户端
+	delete(server.clients, "client-1")
+	got = route(10)
+	if got["client-2"] != 5 || got["client-3"] != 5 {
+		t.Errorf("client-1 断开后应在其余客户端间平分，得到 %v", got)
+	}
+	delete(server.clients, "client-2")
+	delete(server.clients, "client-3")
+	if got = route(3); got["client-4"] != 3 {
+		t.Errorf("精确匹配的客户端全部断开后应回退到通配符客户端，得到 %v", got)
+	}
+}
+
+// TestGlobalRoutingPolicy 测试全局公开端口的路由策略：未配置时多个客户端匹配同一连接则拒绝，
+// hostname 不使用未声明主机名的客户端，round-robin 忽略权重轮流选择
+func TestGlobalRoutingPolicy(t *testing.T) {
+	newServer := func(policy string) *Server {
+		server := NewServer("127.0.0.1:0", "127.0.0.1:0", WithServerGlobalRouting(policy))
+		server.clients["client-1"] = &ClientInfo{ID: "client-1", Hostnames: []string{"app.example.com"}}
+		server.clients["client-2"] = &ClientInfo{ID: "client-2", Weight: 3}
+		return server
+	}
+
+	// 未配置：每个连接只匹配一个客户端时正常路由
+	server := newServer("")
+	if got := server.pickGlobalClient("app.example.com"); got != "client-1" {
+		t.Errorf("主机名匹配的连接应路由到 client-1，得到 %q", got)
+	}
+	if got := server.pickGlobalClient("other.example.com"); got != "client-2" {
+		t.Errorf("未匹配主机名的连接应路由到唯一未声明主机名的 client-2，得到 %q", got)
+	}
+	// 第二个未声明主机名的客户端注册后，未匹配主机名的连接有歧义，被拒绝；主机名匹配的连接不受影响
+	server.clients["client-3"] = &ClientInfo{ID: "client-3"}
+	if got := server.pickGlobalClient("other.example.com"); got != "" {
+		t.Errorf("未配置路由策略时多个客户端匹配的连接应被拒绝，得到 %q", got)
+	}
+	if got := server.pickGlobalClient("app.example.com"); got != "client-1" {
+		t.Errorf("主机名匹配的连接应继续路由到 client-1，得到 %q", got)
+	}
+
+	// hostname：不使用未声明主机名的客户端
+	server = newServer(GlobalRoutingHostname)
+	if got := server.pickGlobalClient("other.example.com"); got != "" {
+		t.Errorf("hostname 策略下未匹配主机名的连接不应路由到未声明主机名的客户端，得到 %q", got)
+	}
+	if got := server.pickGlobalClient("app.example.com"); got != "client-1" {
+		t.Errorf("hostname 策略下主机名匹配的连接应路由到 client-1，得到 %q", got)
+	}
+
+	// round-robin：忽略权重，两个未声明主机名的客户端交替接收连接
+	server = newServer(GlobalRoutingRoundRobin)
+	server.clients["client-3"] = &ClientInfo{ID: "client-3"}
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		counts[server.pickGlobalClient("")]++
+	}
+	if counts["client-2"] != 5 || counts["client-3"] != 5 {
+		t.Errorf("round-robin 应忽略权重平分连接，得到 %v", counts)
+	}
+}
+
+// TestHostHeaderInjection 测试公开连接的 Host 中注入的 NEW_CONN 字段（;src=）被忽略：
+// 无效的主机名不参与路由，客户端收到的来源地址是真实的对端地址
+func TestHostHeaderInjection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	var accepted atomic.Int32
+	opened := make(chan *proto.NewConnInfo, 10)
+	go serveMuxEcho(local, &accepted, opened, make(chan uint32, 10), 0)
+
+	// 控制连接使用 TCP：INIT 与服务器的 HELLO 响应并发写入，同步的内存管道会互相阻塞
+	server := NewServer(controlAddr, "", WithServerPublicListener(public), WithServerGlobalRouting(GlobalRoutingRoundRobin))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+	go NewClient(controlAddr, "127.0.0.1:1", 0, WithHostnames("*.example.com")).Run(ctx)
+	waitStat(t, "主机名路由", func() string { return fmt.Sprint(server.hostRoutingActive()) }, "true")
+	go NewClient(controlAddr, "local", 0, WithLocalDialer(local), WithLocalMultiplex(true)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1,client-2")
+
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer conn.Close()
+	go conn.Write([]byte("GET / HTTP/1.1\r\nHost: x;src=10.0.0.1:1.example.com\r\n\r\n"))
+
+	select {
+	case info := <-opened:
+		if info.SourceAddr == "" || strings.HasPrefix(info.SourceAddr, "10.0.0.1") || info.Host != "" {
+			t.Errorf("注入的 src 和无效的主机名应被忽略: %+v", info)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("无效主机名的连接应交给未声明主机名的客户端")
+	}
+	if !validRouteHost("app.example.com") || validRouteHost("a:b.example.com") || validRouteHost("*.example.com") || validRouteHost("a b.com") {
+		t.Error("validRouteHost 的判断不正确")
+	}
+}
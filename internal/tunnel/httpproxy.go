@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ParseHTTPProxy 解析上游 HTTP 代理地址，格式为 http://[用户名:密码@]主机[:端口]（端口默认 80）
+// 带用户名时使用 Basic 认证
+func ParseHTTPProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP 代理地址无效: %w", err)
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("HTTP 代理地址无效: 只支持 http:// 代理, 得到 %q", s)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("HTTP 代理地址无效: 缺少主机名 (%q)", s)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("HTTP 代理地址无效: 不应包含路径 (%q)", s)
+	}
+	return u, nil
+}
+
+// proxyAddr 返回代理的 host:port（未指定端口时为 80）
+func proxyAddr(proxy *url.URL) string {
+	port := proxy.Port()
+	if port == "" {
+		port = "80"
+	}
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
+
+// dialHTTPProxy 连接 HTTP 代理并通过 CONNECT 建立到 address 的隧道，返回的连接上可直接进行 TLS 握手或传输隧道帧
+// timeout 同时约束连接代理和 CONNECT 交换（0 表示不设超时）；到代理的 TCP 连接按 bufs 设置缓冲区大小。
+// 代理返回非 200 时返回包含状态行的错误
+func dialHTTPProxy(ctx context.Context, proxy *url.URL, network, address string, timeout time.Duration, bufs SocketBuffers) (net.Conn, error) {
+	dialer := bufs.dialer(timeout)
+	conn, err := dialer.DialContext(ctx, network, proxyAddr(proxy))
+	if err != nil {
+		return nil, fmt.Errorf("连接 HTTP 代理 %s 失败: %w", proxy.Host, err)
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("发送 CONNECT 请求到 HTTP 代理 %s 失败: %w", proxy.Host, err)
+	}
+
+	// 不读取 200 响应的正文：CONNECT 成功后的字节属于隧道
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("读取 HTTP 代理 %s 的 CONNECT 响应失败: %w", proxy.Host, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			if proxy.User == nil {
+				return nil, fmt.Errorf("HTTP 代理 %s 要求认证 (%s)，请在代理地址中提供用户名和密码", proxy.Host, resp.Status)
+			}
+			return nil, fmt.Errorf("HTTP 代理 %s 认证失败 (%s)", proxy.Host, resp.Status)
+		}
+		return nil, fmt.Errorf("HTTP 代理 %s 拒绝 CONNECT %s: %s", proxy.Host, address, resp.Status)
+	}
+	// 服务器在收到客户端数据前不会发送任何内容，缓冲中有数据说明代理行为异常
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, errors.New("HTTP 代理在 CONNECT 响应后发送了多余的数据")
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,153 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startConnectProxy 启动一个只支持 CONNECT 的 HTTP 代理，auth 非空时要求 Proxy-Authorization 与之相同
+func startConnectProxy(t *testing.T, auth string) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动代理失败: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Method != http.MethodConnect {
+					io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+					return
+				}
+				if auth != "" && req.Header.Get("Proxy-Authorization") != auth {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return listener
+}
+
+// TestHTTPProxy 测试客户端经上游 HTTP CONNECT 代理（Basic 认证）连接服务器并完成转发
+func TestHTTPProxy(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	proxyListener := startConnectProxy(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:secret")))
+	defer proxyListener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, publicAddr)
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	proxy, err := ParseHTTPProxy("http://alice:secret@" + proxyListener.Addr().String())
+	if err != nil {
+		t.Fatalf("解析代理地址失败: %v", err)
+	}
+	client := NewClient(controlAddr, localAddr, 0, WithHTTPProxy(proxy))
+	go client.Run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(server.ClientStatus()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能经代理注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	msg := []byte("hello via proxy")
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if string(got) != string(msg) {
+		t.Errorf("回显不匹配: 期望 %q, 得到 %q", msg, got)
+	}
+}
+
+// TestHTTPProxyErrors 测试代理地址校验和 CONNECT 失败时的错误信息
+func TestHTTPProxyErrors(t *testing.T) {
+	for _, s := range []string{"socks5://127.0.0.1:1080", "http://", "http://proxy:3128/path", "://bad"} {
+		if _, err := ParseHTTPProxy(s); err == nil {
+			t.Errorf("期望代理地址 %q 无效", s)
+		}
+	}
+	if u, err := ParseHTTPProxy("http://proxy"); err != nil || proxyAddr(u) != "proxy:80" {
+		t.Errorf("期望默认端口 80, 得到 %v (err=%v)", u, err)
+	}
+
+	proxyListener := startConnectProxy(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:secret")))
+	defer proxyListener.Close()
+
+	tests := []struct {
+		name  string
+		proxy string
+		want  string
+	}{
+		{"未提供认证", "http://" + proxyListener.Addr().String(), "要求认证"},
+		{"认证失败", "http://alice:wrong@" + proxyListener.Addr().String(), "认证失败"},
+		{"目标不可达", "http://alice:secret@" + proxyListener.Addr().String(), "502"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, err := ParseHTTPProxy(tt.proxy)
+			if err != nil {
+				t.Fatalf("解析代理地址失败: %v", err)
+			}
+			_, err = TCPTransport{Proxy: proxy}.DialContext(context.Background(), "tcp", fmt.Sprintf("127.0.0.1:%d", getFreePort(t)))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("期望错误包含 %q, 得到: %v", tt.want, err)
+			}
+		})
+	}
+
+	// 代理不可达
+	u := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", getFreePort(t))}
+	if _, err := (TCPTransport{Proxy: u}).DialContext(context.Background(), "tcp", "example.com:443"); err == nil || !strings.Contains(err.Error(), "连接 HTTP 代理") {
+		t.Errorf("期望连接代理失败的错误, 得到: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// errInitBudget 控制连接在完成 INIT 之前超出了字节或时间预算，或 INIT 负载超过上限
+var errInitBudget = errors.New("超出 INIT 之前的握手预算")
+
+// initBudget 一个控制连接在完成应用层握手（HELLO、INIT）之前剩余的读取字节数：
+// 未经握手的对端在此期间能让服务器读取和分配的数据有上限，完成 INIT（或转为观察者）后不再限制
+type initBudget struct {
+	remaining int  // 剩余字节数（limited 为 false 时不使用）
+	limited   bool // 是否仍受预算限制
+}
+
+// newInitBudget 返回新控制连接的字节预算（initBudgetBytes 为 0 时不限制）
+func (s *Server) newInitBudget() *initBudget {
+	return &initBudget{remaining: s.initBudgetBytes, limited: s.initBudgetBytes > 0}
+}
+
+// frameLimit 返回下一个帧负载长度的上限：完成握手之前为剩余预算扣除帧头，超出时在分配缓冲区之前拒绝该帧
+// 剩余预算不足一个帧头时返回错误
+func (b *initBudget) frameLimit() (uint32, error) {
+	if !b.limited {
+		return proto.MaxPayloadSize, nil
+	}
+	if b.remaining < proto.FrameHeaderSize {
+		return 0, fmt.Errorf("%w: 已读取的字节数达到上限", errInitBudget)
+	}
+	return uint32(b.remaining - proto.FrameHeaderSize), nil
+}
+
+// consume 扣除已读取的帧
+func (b *initBudget) consume(frame *proto.Frame) {
+	if b.limited {
+		b.remaining -= proto.FrameHeaderSize + len(frame.Payload)
+	}
+}
+
+// complete 标记应用层握手完成，之后不再限制
+func (b *initBudget) complete() {
+	b.limited = false
+}
+
+// exceeded 把读取帧时因超出剩余预算而被拒绝的错误转换为 errInitBudget（其他错误返回 nil）
+func (b *initBudget) exceeded(err error) error {
+	if b.limited && errors.Is(err, proto.ErrPayloadTooLarge) {
+		return fmt.Errorf("%w: %v（剩余 %d 字节）", errInitBudget, err, b.remaining)
+	}
+	return nil
+}
+
+// maxInitPayload 返回 INIT 帧负载的上限（未设置时为 proto.MaxInitPayloadSize）
+func (s *Server) maxInitPayload() int {
+	if s.initMaxPayload > 0 && s.initMaxPayload < proto.MaxInitPayloadSize {
+		return s.initMaxPayload
+	}
+	return proto.MaxInitPayloadSize
+}
+
+// rejectOversizedInit INIT 负载超过 initMaxPayload 时回复 ERROR 并以 init_budget 原因记录，返回 true 表示应断开控制连接；
+// 未设置 initMaxPayload 时超过协议上限的 INIT 仍由 handleInitFrame 作为格式错误处理
+func (s *Server) rejectOversizedInit(clientID string, conn net.Conn, frame *proto.Frame) bool {
+	maxSize := s.maxInitPayload()
+	if maxSize >= proto.MaxInitPayloadSize || len(frame.Payload) <= maxSize {
+		return false
+	}
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if ok {
+		s.sendInitResult(clientID, clientInfo.Conn, &clientInfo.writeMu, proto.FrameTypeERROR, fmt.Sprintf("INIT 负载过大: %d 字节（上限 %d）", len(frame.Payload), maxSize))
+	}
+	s.rejectInitBudgetConn(clientID, conn, fmt.Errorf("%w: INIT 负载 %d 字节（上限 %d）", errInitBudget, len(frame.Payload), maxSize))
+	return true
+}
+
+// markInitDone 记录客户端已完成 INIT，之后不再受 initBudgetTimeout 限制
+func (s *Server) markInitDone(clientID string) {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if ok {
+		clientInfo.initDone.Store(true)
+	}
+}
+
+// rejectInitBudgetConn 以 init_budget 原因记录被中止的控制连接（运行日志、安全日志和拒绝计数），由调用方关闭连接
+func (s *Server) rejectInitBudgetConn(clientID string, conn net.Conn, err error) {
+	s.clientsMu.RLock()
+	identity := ""
+	if info, ok := s.clients[clientID]; ok {
+		identity = info.Identity
+	}
+	s.clientsMu.RUnlock()
+	s.securityLog.rejected(conn.RemoteAddr(), rejectInitBudget, identity, fmt.Errorf("clientID=%s: %w", clientID, err))
+}
+
+// enforceInitBudgetTimeout 控制连接建立后 initBudgetTimeout 内未完成 INIT（或转为观察者）则中止连接。
+// 与 initTimeout 只约束首个帧不同，该时限覆盖整个应用层握手，持续缓慢发送字节的对端同样会被断开
+func (s *Server) enforceInitBudgetTimeout(clientID string, conn net.Conn, done <-chan struct{}) {
+	timer := time.NewTimer(s.initBudgetTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok || clientInfo.initDone.Load() {
+		return
+	}
+	s.rejectInitBudgetConn(clientID, conn, fmt.Errorf("%w: %v 内未完成 INIT", errInitBudget, s.initBudgetTimeout))
+	conn.Close()
+}
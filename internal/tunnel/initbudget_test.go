@@ -0,0 +1,124 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// dialControl 建立一个不经过客户端逻辑的原始控制连接
+func dialControl(t *testing.T, ctx context.Context, control *memListener) net.Conn {
+	t.Helper()
+	conn, err := control.DialContext(ctx, "mem", "control")
+	if err != nil {
+		t.Fatalf("连接控制监听器失败: %v", err)
+	}
+	return conn
+}
+
+// expectClosed 断言服务器关闭了控制连接（读取返回非超时错误）
+func expectClosed(t *testing.T, conn net.Conn, what string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, err := proto.DecodeFrame(conn)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("%s: 控制连接应被断开", what)
+		}
+		return
+	}
+}
+
+// TestInitBudget 测试 INIT 负载超过上限、完成 INIT 之前读取的字节数超出预算（包括声明了过大负载的帧）
+// 和超过握手时限的控制连接被断开并以 init_budget 原因计数，完成 INIT 的客户端之后不再受限
+func TestInitBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	const timeout = 300 * time.Millisecond
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerInitBudget(64, 256, timeout))
+	go server.Run(ctx)
+	hello := &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(&proto.Hello{})}
+
+	// INIT 负载超过上限
+	big := dialControl(t, ctx, control)
+	defer big.Close()
+	init := &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&proto.InitConfig{LocalAddr: string(bytes.Repeat([]byte("a"), 100)) + ":80"})}
+	if err := writeFrame(big, nil, init, time.Second); err != nil {
+		t.Fatalf("发送 INIT 失败: %v", err)
+	}
+	expectClosed(t, big, "INIT 负载超过上限")
+
+	// 反复发送 HELLO 直到超出字节预算
+	chatty := dialControl(t, ctx, control)
+	defer chatty.Close()
+	go func() {
+		for i := 0; i < 100; i++ {
+			if writeFrame(chatty, nil, hello, time.Second) != nil {
+				return
+			}
+		}
+	}()
+	expectClosed(t, chatty, "超出字节预算")
+
+	// 声明的负载长度超出剩余预算，不等负载到达即被拒绝
+	header := make([]byte, proto.FrameHeaderSize)
+	header[0] = byte(proto.FrameTypeHELLO)
+	header[7] = 0x10 // 负载长度 4096
+	huge := dialControl(t, ctx, control)
+	defer huge.Close()
+	if _, err := huge.Write(header); err != nil {
+		t.Fatalf("发送帧头失败: %v", err)
+	}
+	expectClosed(t, huge, "声明的负载超出预算")
+
+	// 只发送一个 HELLO、之后不再发送帧的连接在握手时限后被断开
+	slow := dialControl(t, ctx, control)
+	defer slow.Close()
+	if err := writeFrame(slow, nil, hello, time.Second); err != nil {
+		t.Fatalf("发送 HELLO 失败: %v", err)
+	}
+	expectClosed(t, slow, "超过握手时限")
+
+	waitStat(t, "init_budget 拒绝次数", func() uint64 {
+		_, counts := server.securityLog.rejectCounts()
+		return counts[rejectInitBudget]
+	}, 4)
+
+	// 完成 INIT 之后不再受预算和时限限制
+	ok := dialControl(t, ctx, control)
+	defer ok.Close()
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := proto.DecodeFrame(ok); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+	init = &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&proto.InitConfig{LocalAddr: "127.0.0.1:80"})}
+	if err := writeFrame(ok, nil, init, time.Second); err != nil {
+		t.Fatalf("发送 INIT 失败: %v", err)
+	}
+	for i := 0; i < 30; i++ {
+		if err := writeFrame(ok, nil, hello, time.Second); err != nil {
+			t.Fatalf("完成 INIT 之后发送 HELLO 失败: %v", err)
+		}
+	}
+	select {
+	case err := <-closed:
+		t.Fatalf("完成 INIT 的控制连接不应被断开，得到 %v", err)
+	case <-time.After(2 * timeout):
+	}
+}
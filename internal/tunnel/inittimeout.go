@@ -0,0 +1,54 @@
+package tunnel
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// greetingFrame 报告 frame 是否为客户端在控制连接上的首个有效帧（HELLO 或 INIT），收到后不再受 initTimeout 限制
+func greetingFrame(frame *proto.Frame) bool {
+	return frame.Type == proto.FrameTypeHELLO || frame.Type == proto.FrameTypeINIT
+}
+
+// markGreeted 记录客户端已发送 HELLO 或 INIT
+func (s *Server) markGreeted(clientID string) {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if ok {
+		clientInfo.greeted.Store(true)
+	}
+}
+
+// enforceInitTimeout 控制连接建立后 initTimeout 内客户端未发送 HELLO 或 INIT（例如只建立连接、不发送任何帧的对端）则断开控制连接；
+// 宽限期只约束首个有效帧，之后的帧不受该超时限制
+func (s *Server) enforceInitTimeout(clientID string, conn net.Conn, done <-chan struct{}) {
+	timer := time.NewTimer(s.initTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok || clientInfo.greeted.Load() {
+		return
+	}
+	s.initTimeouts.Add(1)
+	log.Printf("客户端 %v 内未发送 HELLO 或 INIT，断开控制连接: clientID=%s", s.initTimeout, clientID)
+	conn.Close()
+}
+
+// helloGrace 服务器要求协议特性时等待客户端 HELLO 的时间：设置了 initTimeout 时取两者中较长的，避免较慢的客户端先被特性协商超时断开
+func (s *Server) helloGrace() time.Duration {
+	if s.initTimeout > helloTimeout {
+		return s.initTimeout
+	}
+	return helloTimeout
+}
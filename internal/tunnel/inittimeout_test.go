@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestInitTimeout 测试宽限期内未发送 HELLO 或 INIT 的控制连接被断开，
+// 较慢但在宽限期内发送 HELLO 的客户端不受影响，之后长时间不发送帧也不会被断开
+func TestInitTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	const grace = 200 * time.Millisecond
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerInitTimeout(grace))
+	go server.Run(ctx)
+
+	silent, err := control.DialContext(ctx, "mem", "control")
+	if err != nil {
+		t.Fatalf("连接控制监听器失败: %v", err)
+	}
+	defer silent.Close()
+	slow, err := control.DialContext(ctx, "mem", "control")
+	if err != nil {
+		t.Fatalf("连接控制监听器失败: %v", err)
+	}
+	defer slow.Close()
+	waitStat(t, "已注册的客户端", func() int { return len(server.ClientStatus()) }, 2)
+
+	// 较慢的客户端在宽限期过半时才发送 HELLO
+	time.Sleep(grace / 2)
+	if err := writeFrame(slow, nil, &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(&proto.Hello{})}, time.Second); err != nil {
+		t.Fatalf("发送 HELLO 失败: %v", err)
+	}
+	slow.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if frame, err := proto.DecodeFrame(slow); err != nil || frame.Type != proto.FrameTypeHELLO {
+		t.Fatalf("期望 HELLO 帧，得到 %+v, %v", frame, err)
+	}
+
+	silent.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := proto.DecodeFrame(silent); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("宽限期内未发送帧的控制连接应被断开，得到 %v", err)
+	}
+	waitStat(t, "已注册的客户端", func() int { return len(server.ClientStatus()) }, 1)
+	if n := server.initTimeouts.Load(); n != 1 {
+		t.Errorf("宽限期超时断开的控制连接数为 %d，期望 1", n)
+	}
+
+	// 发送 HELLO 之后不再受宽限期限制
+	slow.SetReadDeadline(time.Now().Add(2 * grace))
+	if _, err := proto.DecodeFrame(slow); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("已发送 HELLO 的控制连接不应被断开，得到 %v", err)
+	}
+	if n := len(server.ClientStatus()); n != 1 {
+		t.Errorf("已注册的客户端数为 %d，期望 1", n)
+	}
+}
@@ -0,0 +1,39 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+)
+
+// defaultLazyNewConnTimeout 延迟 NEW_CONN 时未设置超时的情况下等待首字节的最长时间
+const defaultLazyNewConnTimeout = 10 * time.Second
+
+// awaitFirstByte 延迟 NEW_CONN 模式下等待公开连接发送第一个字节，收到后返回仍包含该字节的连接；
+// 超时、对端直接断开或服务器关闭时关闭连接并返回 false（客户端不会为其建立本地连接）。
+// 已由主机名路由预读过首包的连接直接返回
+func (s *Server) awaitFirstByte(ctx context.Context, conn net.Conn) (net.Conn, bool) {
+	if pc, ok := conn.(*peekedConn); ok && pc.r.Buffered() > 0 {
+		return conn, true
+	}
+	timeout := s.lazyNewConnTimeout
+	if timeout <= 0 {
+		timeout = defaultLazyNewConnTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	// 服务器关闭时立即结束等待
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+
+	r := bufio.NewReader(conn)
+	_, err := r.Peek(1)
+	stop()
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		s.lazyConnsDropped.Add(1)
+		s.lazyDropLog.printf("公开连接未发送数据即断开或超时 (%v)，不转发给客户端: %s: %v", timeout, conn.RemoteAddr(), err)
+		conn.Close()
+		return nil, false
+	}
+	return &peekedConn{Conn: conn, r: r}, true
+}
@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestLazyNewConn 测试延迟 NEW_CONN：未发送数据就断开或等待超时的公开连接不会让客户端连接本地服务，
+// 发送数据的连接在首字节到达后正常转发（首字节不丢失）
+func TestLazyNewConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	dialer := &toggleDialer{next: local}
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerLazyNewConn(true, 200*time.Millisecond))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(dialer)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	// 只连接不发送数据就断开
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	conn.Close()
+	waitStat(t, "未发送数据的连接数", server.lazyConnsDropped.Load, 1)
+
+	// 连接后一直不发送数据，超时后被关闭
+	idle, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	defer idle.Close()
+	idle.SetDeadline(time.Now().Add(3 * time.Second))
+	start := time.Now()
+	if _, err := idle.Read(make([]byte, 1)); err == nil || isDeadlineErr(err) {
+		t.Fatalf("等待首字节超时后连接应被关闭, 得到 %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("连接在等待超时之前被关闭: %v", elapsed)
+	}
+	waitStat(t, "未发送数据的连接数", server.lazyConnsDropped.Load, 2)
+	if n := dialer.dials.Load(); n != 0 {
+		t.Fatalf("未发送数据的连接不应连接本地服务, 拨号 %d 次", n)
+	}
+
+	if err := echoRoundTrip(ctx, public, []byte("ping")); err != nil {
+		t.Fatalf("发送数据的连接应正常转发: %v", err)
+	}
+	if n := dialer.dials.Load(); n != 1 {
+		t.Errorf("应只为发送数据的连接拨号一次, 拨号 %d 次", n)
+	}
+}
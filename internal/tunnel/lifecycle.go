@@ -0,0 +1,55 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+)
+
+// runState 记录正在执行的 Run，供 Shutdown 取消并等待其返回
+type runState struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start 派生 Run 使用的 context，返回的 stop 在 Run 返回前调用
+func (r *runState) start(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	r.mu.Lock()
+	r.cancel = cancel
+	r.done = done
+	r.mu.Unlock()
+	return ctx, func() {
+		cancel()
+		close(done)
+	}
+}
+
+// shutdown 取消正在执行的 Run 并等待其返回，ctx 结束时不再等待并返回 ctx 的错误；Run 未执行时直接返回
+func (r *runState) shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown 停止服务器：取消 Run 并等待其完成清理（注销客户端、关闭连接）后返回，
+// ctx 先结束时返回 ctx 的错误（Run 仍在后台继续清理）。效果与取消传给 Run 的 ctx 相同，Run 返回 context.Canceled
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.run.shutdown(ctx)
+}
+
+// Shutdown 停止客户端：取消 Run 并等待其关闭控制连接和本地连接后返回，ctx 先结束时返回 ctx 的错误
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.run.shutdown(ctx)
+}
@@ -0,0 +1,40 @@
+package tunnel
+
+import "time"
+
+// LocalCheckResult 表示一次本地服务可达性检查的结果
+type LocalCheckResult struct {
+	Addr    string        // 本地服务地址
+	Latency time.Duration // 建立连接（启用本地 TLS 时包括握手）的耗时
+	Err     error         // 连接失败的原因（nil 表示可达）
+}
+
+// CheckLocal 依次连接每个配置的本地服务一次（localAddr 中的全部后端及主机名路由、本地路由规则的地址，重复的地址只检查一次），
+// 使用与转发连接相同的拨号设置（源地址、TCP Fast Open、本地 TLS），返回每个地址的结果。不连接服务器
+func (c *Client) CheckLocal() []LocalCheckResult {
+	addrs := ParseLocalBackends(c.localAddr)
+	for _, route := range c.hostRoutes {
+		addrs = append(addrs, ParseLocalBackends(route.Local)...)
+	}
+	for _, route := range c.localRoutes {
+		addrs = append(addrs, ParseLocalBackends(route.Local)...)
+	}
+
+	seen := make(map[string]bool, len(addrs))
+	var results []LocalCheckResult
+	for _, addr := range addrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		start := time.Now()
+		conn, err := c.dialLocal(addr)
+		result := LocalCheckResult{Addr: addr, Latency: time.Since(start), Err: err}
+		if err == nil {
+			conn.Close()
+		}
+		results = append(results, result)
+	}
+	return results
+}
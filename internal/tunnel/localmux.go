@@ -0,0 +1,207 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// errLocalMuxClosed 本地多路复用连接已断开
+var errLocalMuxClosed = errors.New("本地多路复用连接已断开")
+
+// localMux 本地多路复用（WithLocalMultiplex）：与本地服务保持一条持久连接，每个公开连接作为其上的一个流，
+// 不再为每个公开连接单独拨号本地服务。本地服务必须实现以下约定：
+//   - 流的数据以隧道帧格式（proto.Frame：frame_type(1) + conn_id(4) + payload_len(4) + payload）收发，conn_id 为流 ID；
+//   - 客户端为每个公开连接分配新的流 ID（在同一条本地连接上不重复使用），先发送 NEW_CONN（负载为 NEW_CONN 信息：
+//     trace、src、host），随后以 DATA 帧发送公开连接的数据（负载不超过 proto.MaxDataPayloadSize），
+//     公开连接结束时发送 CLOSE_CONN（负载为关闭原因）；
+//   - 本地服务以同一流 ID 的 DATA 帧回复数据，以 CLOSE_CONN 关闭流，收到客户端的 CLOSE_CONN 后不再发送该流的帧；其他帧类型被忽略；
+//   - 本地连接断开时其上的全部流被关闭，下一个公开连接重新拨号。
+//
+// 某个流的公开连接接收缓慢时会暂停读取本地连接（同一连接上的其他流随之等待），只适用于自身支持多路复用、响应及时的后端
+type localMux struct {
+	addr string
+	dial func(addr string) (net.Conn, error)
+
+	mu   sync.Mutex
+	conn *muxConn // 当前的持久连接（nil 表示尚未拨号或已断开）
+}
+
+// newLocalMux 创建到 addr 的本地多路复用，持久连接在第一个公开连接到达时拨号
+func newLocalMux(addr string, dial func(addr string) (net.Conn, error)) *localMux {
+	return &localMux{addr: addr, dial: dial}
+}
+
+// muxConn 一条本地多路复用连接及其上的流
+type muxConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]net.Conn // 流 ID -> 管道的本端（另一端作为本地连接交给转发）
+	nextID  uint32
+	closed  bool
+}
+
+// initLocalMux 启用本地多路复用时创建（多个后端或内置本地服务时不启用，多路复用只针对单一的真实本地地址）
+func (c *Client) initLocalMux() {
+	if c.localMultiplex && c.backends == nil && !isBuiltinLocal(c.localAddr) {
+		c.localMux = newLocalMux(c.localAddr, c.dialBackend)
+	}
+}
+
+// open 在持久连接上打开一个新的流，返回作为本地连接使用的一端；持久连接不存在或已断开时先拨号
+func (m *localMux) open(info *proto.NewConnInfo) (net.Conn, error) {
+	m.mu.Lock()
+	mc := m.conn
+	if mc == nil || mc.isClosed() {
+		conn, err := m.dial(m.addr)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		mc = &muxConn{conn: conn, streams: make(map[uint32]net.Conn)}
+		m.conn = mc
+		log.Printf("已建立本地多路复用连接: %s", m.addr)
+		go mc.readLoop(m.addr)
+	}
+	m.mu.Unlock()
+	return mc.openStream(info)
+}
+
+// close 关闭持久连接及其上的全部流
+func (m *localMux) close() {
+	m.mu.Lock()
+	mc := m.conn
+	m.conn = nil
+	m.mu.Unlock()
+	if mc != nil {
+		mc.fail()
+	}
+}
+
+// isClosed 返回持久连接是否已断开
+func (mc *muxConn) isClosed() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.closed
+}
+
+// openStream 分配流 ID、发送 NEW_CONN 并启动把流的数据发往本地服务的 goroutine
+func (mc *muxConn) openStream(info *proto.NewConnInfo) (net.Conn, error) {
+	local, remote := net.Pipe()
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		return nil, errLocalMuxClosed
+	}
+	mc.nextID++
+	id := mc.nextID
+	mc.streams[id] = remote
+	mc.mu.Unlock()
+
+	if err := mc.write(&proto.Frame{Type: proto.FrameTypeNEW_CONN, ConnID: id, Payload: proto.EncodeNewConnInfo(info)}); err != nil {
+		mc.fail()
+		return nil, err
+	}
+	go mc.pump(id, remote)
+	return local, nil
+}
+
+// write 在持久连接上写入一个帧，写入失败时由调用方关闭持久连接
+func (mc *muxConn) write(frame *proto.Frame) error {
+	mc.writeMu.Lock()
+	defer mc.writeMu.Unlock()
+	return proto.WriteFrame(mc.conn, frame)
+}
+
+// pump 把流的数据（转发写入本地连接的数据）以 DATA 帧发往本地服务，流结束时发送 CLOSE_CONN（本地服务已先关闭该流时不发送）
+func (mc *muxConn) pump(id uint32, remote net.Conn) {
+	defer remote.Close()
+	buf := make([]byte, dataChunkSize)
+	for {
+		n, err := remote.Read(buf)
+		if n > 0 {
+			if werr := mc.write(&proto.Frame{Type: proto.FrameTypeDATA, ConnID: id, Payload: buf[:n]}); werr != nil {
+				log.Printf("写入本地多路复用连接错误 (流=%d): %v", id, werr)
+				mc.fail()
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	mc.mu.Lock()
+	_, open := mc.streams[id]
+	delete(mc.streams, id)
+	closed := mc.closed
+	mc.mu.Unlock()
+	if !open || closed {
+		return
+	}
+	if err := mc.write(&proto.Frame{Type: proto.FrameTypeCLOSE, ConnID: id, Payload: proto.EncodeCloseReason(proto.CloseGraceful)}); err != nil {
+		log.Printf("写入本地多路复用连接错误 (流=%d): %v", id, err)
+		mc.fail()
+	}
+}
+
+// readLoop 读取本地服务发来的帧并交给对应的流，直到持久连接断开
+func (mc *muxConn) readLoop(addr string) {
+	for {
+		frame, err := proto.DecodeFrame(mc.conn)
+		if err != nil {
+			if !mc.isClosed() {
+				if err == io.EOF {
+					log.Printf("本地多路复用连接已被本地服务关闭: %s", addr)
+				} else {
+					log.Printf("读取本地多路复用连接错误 (%s): %v", addr, err)
+				}
+			}
+			mc.fail()
+			return
+		}
+
+		mc.mu.Lock()
+		stream, ok := mc.streams[frame.ConnID]
+		if ok && frame.Type == proto.FrameTypeCLOSE {
+			delete(mc.streams, frame.ConnID)
+		}
+		mc.mu.Unlock()
+		if !ok {
+			continue
+		}
+		switch frame.Type {
+		case proto.FrameTypeDATA:
+			if len(frame.Payload) > 0 {
+				// 转发已关闭该流时写入失败，丢弃数据（pump 随后发送 CLOSE_CONN）
+				stream.Write(frame.Payload)
+			}
+		case proto.FrameTypeCLOSE:
+			stream.Close()
+		}
+	}
+}
+
+// fail 关闭持久连接和其上的全部流（转发读到 EOF 后向服务器发送 CLOSE_CONN）
+func (mc *muxConn) fail() {
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		return
+	}
+	mc.closed = true
+	streams := mc.streams
+	mc.streams = nil
+	mc.mu.Unlock()
+
+	mc.conn.Close()
+	for _, stream := range streams {
+		stream.Close()
+	}
+}
@@ -0,0 +1,114 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// serveMuxEcho 按本地多路复用约定回显每个流的数据，流 ID 为 closeID 的 NEW_CONN 到达后立即由本地服务关闭该流；
+// 每接受一条连接 accepted 加一，收到的 NEW_CONN 信息和客户端关闭的流 ID 分别发送到 opened、closed
+func serveMuxEcho(l *memListener, accepted *atomic.Int32, opened chan<- *proto.NewConnInfo, closed chan<- uint32, closeID uint32) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		accepted.Add(1)
+		go func() {
+			defer conn.Close()
+			for {
+				frame, err := proto.DecodeFrame(conn)
+				if err != nil {
+					return
+				}
+				switch frame.Type {
+				case proto.FrameTypeNEW_CONN:
+					info, _ := proto.DecodeNewConnInfo(frame.Payload)
+					opened <- info
+					if frame.ConnID == closeID {
+						proto.WriteFrame(conn, &proto.Frame{Type: proto.FrameTypeCLOSE, ConnID: frame.ConnID})
+					}
+				case proto.FrameTypeDATA:
+					proto.WriteFrame(conn, frame)
+				case proto.FrameTypeCLOSE:
+					closed <- frame.ConnID
+				}
+			}
+		}()
+	}
+}
+
+// TestLocalMultiplex 测试启用本地多路复用后多个公开连接共用一条本地连接，按流回显数据，
+// 公开连接关闭时向本地服务发送 CLOSE_CONN，本地服务关闭流时公开连接随之关闭
+func TestLocalMultiplex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	var accepted atomic.Int32
+	opened := make(chan *proto.NewConnInfo, 10)
+	closed := make(chan uint32, 10)
+	go serveMuxEcho(local, &accepted, opened, closed, 3)
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local), WithLocalMultiplex(true)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	dial := func() net.Conn {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		return conn
+	}
+	first, second := dial(), dial()
+	defer second.Close()
+	for _, c := range []struct {
+		conn net.Conn
+		msg  string
+	}{{first, "first"}, {second, "second"}, {first, "again"}} {
+		go c.conn.Write([]byte(c.msg))
+		buf := make([]byte, len(c.msg))
+		if _, err := io.ReadFull(c.conn, buf); err != nil || string(buf) != c.msg {
+			t.Fatalf("回显 %q 失败: %q, %v", c.msg, buf, err)
+		}
+	}
+	if got := accepted.Load(); got != 1 {
+		t.Errorf("两个公开连接应共用 1 条本地连接, 得到 %d", got)
+	}
+	for i := 0; i < 2; i++ {
+		if info := <-opened; info.TraceID == "" || info.SourceAddr == "" {
+			t.Errorf("NEW_CONN 应携带跟踪 ID 和来源地址: %+v", info)
+		}
+	}
+
+	first.Close()
+	select {
+	case id := <-closed:
+		if id != 1 && id != 2 {
+			t.Errorf("应关闭第一个公开连接的流, 得到 %d", id)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("公开连接关闭后本地服务应收到 CLOSE_CONN")
+	}
+
+	// 本地服务关闭流 3，对应的公开连接被关闭
+	third := dial()
+	defer third.Close()
+	if _, err := third.Read(make([]byte, 1)); err == nil {
+		t.Fatal("本地服务关闭流后公开连接应被关闭")
+	}
+	if got := accepted.Load(); got != 1 {
+		t.Errorf("后续公开连接应继续使用同一条本地连接, 得到 %d 条", got)
+	}
+}
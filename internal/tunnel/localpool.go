@@ -0,0 +1,148 @@
+package tunnel
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// localPoolValidateTimeout 复用连接前探测其是否仍然可用的读取等待时间
+const localPoolValidateTimeout = time.Millisecond
+
+// localConnPool 维护到本地服务的预热连接
+// NEW_CONN 优先从池中取出连接，取出后在后台补充到 size 个；
+// 启用 reuse 时，收到 CLOSE_CONN 后本地连接经校验放回池中而不是关闭（只适用于每个请求后连接状态可复用的无状态协议），
+// 此时只在池中没有空闲连接时才补充，避免放回的连接因池已满被关闭
+type localConnPool struct {
+	addr  string
+	size  int
+	reuse bool
+	dial  func(addr string) (net.Conn, error)
+
+	mu        sync.Mutex
+	idle      []net.Conn
+	refilling bool
+	closed    bool
+}
+
+// newLocalConnPool 创建本地连接池
+func newLocalConnPool(addr string, size int, reuse bool, dial func(addr string) (net.Conn, error)) *localConnPool {
+	return &localConnPool{
+		addr:  addr,
+		size:  size,
+		reuse: reuse,
+		dial:  dial,
+	}
+}
+
+// get 取出一个可用的连接（池中没有可用连接时直接拨号），并在后台补充池
+func (p *localConnPool) get() (net.Conn, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			p.refill()
+			return p.dial(p.addr)
+		}
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if validatePooledConn(conn) {
+			if !p.reuse {
+				p.refill()
+			}
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// put 将用完的连接放回池中，连接不可用、池已满或已关闭时关闭连接
+func (p *localConnPool) put(conn net.Conn) {
+	if !validatePooledConn(conn) {
+		conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed || len(p.idle) >= p.size {
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+// refill 在后台将池补充到 size 个连接（同一时间只有一个补充任务）
+func (p *localConnPool) refill() {
+	p.mu.Lock()
+	if p.closed || p.refilling || len(p.idle) >= p.size {
+		p.mu.Unlock()
+		return
+	}
+	p.refilling = true
+	p.mu.Unlock()
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.refilling = false
+			p.mu.Unlock()
+		}()
+
+		for {
+			p.mu.Lock()
+			full := p.closed || len(p.idle) >= p.size
+			p.mu.Unlock()
+			if full {
+				return
+			}
+
+			conn, err := p.dial(p.addr)
+			if err != nil {
+				log.Printf("预热本地连接失败 (%s): %v", p.addr, err)
+				return
+			}
+
+			p.mu.Lock()
+			if p.closed || len(p.idle) >= p.size {
+				p.mu.Unlock()
+				conn.Close()
+				return
+			}
+			p.idle = append(p.idle, conn)
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// close 关闭池中所有空闲连接，之后放回的连接会被直接关闭
+func (p *localConnPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
+
+// validatePooledConn 探测空闲连接是否可复用：短暂读取应超时
+// 读到数据（上一个请求的残留响应）或 EOF/错误（本地服务已关闭连接）都视为不可复用
+func validatePooledConn(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(localPoolValidateTimeout)); err != nil {
+		return false
+	}
+	var b [1]byte
+	n, err := conn.Read(b[:])
+	conn.SetReadDeadline(time.Time{})
+
+	var ne net.Error
+	return n == 0 && errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,53 @@
+package tunnel
+
+import (
+	"context"
+	"time"
+)
+
+// localResolveTimeout 解析单个公开连接的本地地址的超时
+// NEW_CONN 在控制连接的主循环中处理，解析阻塞期间该控制连接上的其他帧同样等待
+const localResolveTimeout = 5 * time.Second
+
+// LocalResolveRequest 表示一次本地地址解析请求（每个 NEW_CONN 一次）
+type LocalResolveRequest struct {
+	Service string // 服务名：服务器路由该连接使用的主机名（NEW_CONN 的 host，未按主机名路由时为空）
+	Source  string // 公开连接来源地址（旧版本服务器不携带时为空）
+	TraceID string // 连接追踪 ID
+	// Local 按静态配置（主机名路由、来源路由、默认本地地址）选择的本地地址
+	// 可以是逻辑服务名（例如主机名路由的本地地址写为 orders），由解析器映射为实际地址
+	Local string
+}
+
+// LocalResolver 在 NEW_CONN 时把公开连接映射为要连接的本地地址（host:port），用于对接服务发现（DNS SRV、环境变量、注册中心等）
+// 返回空字符串表示使用 req.Local；返回错误时该连接失败，客户端向服务器发送 CLOSE_CONN。
+// 解析在控制连接的主循环中进行，实现应尽快返回并遵守 ctx 的超时
+type LocalResolver interface {
+	ResolveLocal(ctx context.Context, req LocalResolveRequest) (string, error)
+}
+
+// LocalResolverFunc 将普通函数适配为 LocalResolver
+type LocalResolverFunc func(ctx context.Context, req LocalResolveRequest) (string, error)
+
+// ResolveLocal 调用 f(ctx, req)
+func (f LocalResolverFunc) ResolveLocal(ctx context.Context, req LocalResolveRequest) (string, error) {
+	return f(ctx, req)
+}
+
+// resolveLocalAddr 返回公开连接要连接的本地地址：先按静态配置选择，设置了解析器时再交给解析器（默认解析器即静态地址）
+func (c *Client) resolveLocalAddr(ctx context.Context, sourceAddr, host, traceID string) (string, error) {
+	local := c.selectLocalAddr(sourceAddr, host)
+	if c.localResolver == nil {
+		return local, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, localResolveTimeout)
+	defer cancel()
+	addr, err := c.localResolver.ResolveLocal(ctx, LocalResolveRequest{Service: host, Source: sourceAddr, TraceID: traceID, Local: local})
+	if err != nil {
+		return "", err
+	}
+	if addr == "" {
+		return local, nil
+	}
+	return addr, nil
+}
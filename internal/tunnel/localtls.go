@@ -0,0 +1,64 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// NewLocalTLSConfig 创建客户端连接本地服务使用的 TLS 配置（标准 TLS，非 PQC）
+// certFile/keyFile 同时指定时向本地服务出示客户端证书（mTLS）；
+// caFile 为空时使用系统根证书；serverName 为空时使用本地地址的主机名
+func NewLocalTLSConfig(certFile, keyFile, caFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("本地 TLS 的证书和私钥必须同时指定")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载本地 TLS 证书失败: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取本地 TLS CA 证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("解析本地 TLS CA 证书失败: %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// localTLSClient 在已建立的本地连接上完成 TLS 握手，握手失败时关闭连接
+// 配置未指定 ServerName 时使用本地地址的主机名
+func localTLSClient(ctx context.Context, conn net.Conn, localAddr string, cfg *tls.Config) (net.Conn, error) {
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(localAddr)
+		if err != nil {
+			host = localAddr
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
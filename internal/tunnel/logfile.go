@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"os"
+	"sync"
+)
+
+// LogFile 以追加方式写入的日志文件（访问日志、安全日志、帧跟踪），可以按原路径重新打开：
+// logrotate 等外部工具重命名（或删除）文件后通知进程调用 Reopen，之后的写入进入新创建的文件，不需要重启。
+// 写入和重新打开持有同一把锁，重新打开期间的写入等待其完成，不会写到已关闭的文件上
+type LogFile struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenLogFile 以追加方式打开（不存在时创建）path 处的日志文件
+func OpenLogFile(path string) (*LogFile, error) {
+	f, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &LogFile{path: path, f: f}, nil
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// Path 返回日志文件的路径
+func (l *LogFile) Path() string {
+	return l.path
+}
+
+// Write 向当前打开的文件追加 p
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Write(p)
+}
+
+// Reopen 按原路径重新打开日志文件并关闭原文件。打开失败时继续写入原文件并返回错误，不会丢失之后的记录
+func (l *LogFile) Reopen() error {
+	f, err := openLogFile(l.path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.f
+	l.f = f
+	l.mu.Unlock()
+	return old.Close()
+}
+
+// Close 关闭日志文件
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
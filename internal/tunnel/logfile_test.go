@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLogFileReopen 测试日志文件被重命名（轮转）后 Reopen 按原路径创建新文件，之后的写入进入新文件
+func TestLogFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	rotated := filepath.Join(dir, "access.log.1")
+	f, err := OpenLogFile(path)
+	if err != nil {
+		t.Fatalf("打开日志文件失败: %v", err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("before\n"))
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("重命名日志文件失败: %v", err)
+	}
+	// 重新打开之前的写入仍然进入已重命名的文件
+	f.Write([]byte("pending\n"))
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("重新打开日志文件失败: %v", err)
+	}
+	f.Write([]byte("after\n"))
+
+	if data, _ := os.ReadFile(rotated); string(data) != "before\npending\n" {
+		t.Errorf("轮转后的文件内容不正确: %q", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("重新打开后的写入应进入新文件: %q", data)
+	}
+
+	// 目录不可写等原因打开失败时继续写入原文件
+	os.Remove(path)
+	f.path = filepath.Join(dir, "missing", "access.log")
+	if err := f.Reopen(); err == nil {
+		t.Fatal("路径不存在的目录时重新打开应失败")
+	}
+	if _, err := f.Write([]byte("kept\n")); err != nil {
+		t.Errorf("重新打开失败后应继续写入原文件: %v", err)
+	}
+}
+
+// TestAccessLogReopen 测试访问日志写入 LogFile 时，轮转并重新打开后的记录进入新文件
+func TestAccessLogReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	f, err := OpenLogFile(path)
+	if err != nil {
+		t.Fatalf("打开日志文件失败: %v", err)
+	}
+	defer f.Close()
+	logger := newAccessLogger(f)
+
+	logger.write(&AccessRecord{ClientID: "client-1"})
+	os.Rename(path, path+".1")
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("重新打开日志文件失败: %v", err)
+	}
+	logger.write(&AccessRecord{ClientID: "client-2"})
+
+	old, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	if !strings.Contains(string(old), `"client-1"`) || strings.Contains(string(old), `"client-2"`) {
+		t.Errorf("轮转前的记录应只在旧文件中: %q", old)
+	}
+	if !strings.Contains(string(current), `"client-2"`) || strings.Contains(string(current), `"client-1"`) {
+		t.Errorf("轮转后的记录应只在新文件中: %q", current)
+	}
+}
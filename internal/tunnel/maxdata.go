@@ -0,0 +1,91 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// DATA 帧负载上限协商：双方在 HELLO 中声明各自能接收的 DATA 负载上限（max_data），使用较小值。
+// 协商后该值同时是发送方每次读取并发出的分块大小和接收方接受的上限，超过上限的 DATA 帧视为协议错误，
+// 接收方回复 ERROR 并断开控制连接。任一方未声明（旧版本）时不协商：按 dataChunkSize 分块发送，
+// 接收方只检查 proto.MaxDataPayloadSize
+
+// localMaxData 返回本端声明的 DATA 负载上限（configured 为 0 时为 dataChunkSize）
+func localMaxData(configured int) int {
+	if configured > 0 {
+		return configured
+	}
+	return dataChunkSize
+}
+
+// agreeMaxData 返回本端上限与对端声明的上限中的较小值，对端未声明（peer 为 0）时返回 0（不协商）
+func agreeMaxData(configured, peer int) int {
+	if peer <= 0 {
+		return 0
+	}
+	return min(localMaxData(configured), peer)
+}
+
+// maxDataLog 返回特性协商日志中的 DATA 负载上限部分（未协商时为空）
+func maxDataLog(agreed int) string {
+	if agreed <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(", DATA 负载上限 %d 字节", agreed)
+}
+
+// dataChunk 返回按协商结果发送 DATA 帧时的分块大小（未协商时为 dataChunkSize）
+func dataChunk(agreed int) int {
+	if agreed > 0 {
+		return agreed
+	}
+	return dataChunkSize
+}
+
+// oversizedData 返回 DATA 帧负载超过协商上限时的错误（未协商时返回 nil）
+func oversizedData(frame *proto.Frame, agreed int) error {
+	if agreed > 0 && len(frame.Payload) > agreed {
+		return fmt.Errorf("DATA 帧负载超过协商的上限: %d 字节 (上限 %d, connID=%d)", len(frame.Payload), agreed, frame.ConnID)
+	}
+	return nil
+}
+
+// clientMaxData 返回该客户端控制连接协商的 DATA 负载上限（0 表示未协商）
+func (s *Server) clientMaxData(clientInfo *ClientInfo) int {
+	return int(clientInfo.maxData.Load())
+}
+
+// rejectOversizedData 向客户端回复超过协商上限的 DATA 帧的 ERROR（帧头已在分配负载之前被 readFrameLimit 拒绝），
+// 调用方随后按解码错误断开控制连接
+func (s *Server) rejectOversizedData(clientInfo *ClientInfo, err error) {
+	s.frameStats.inc(proto.FrameTypeDATA, frameRejected)
+	reply := &proto.Frame{Type: proto.FrameTypeERROR, Payload: []byte(fmt.Sprintf("DATA 帧负载超过协商的上限: %v", err))}
+	if err := writeFrame(clientInfo.Conn, &clientInfo.writeMu, reply, s.writeTimeout()); err != nil {
+		log.Printf("发送 ERROR 帧失败 (clientID=%s): %v", clientInfo.ID, err)
+	}
+}
+
+// rejectOversizedData 检查服务器的 DATA 帧是否超过协商的上限，超过时回复 ERROR 并关闭控制连接（随后重连），返回 true
+func (c *Client) rejectOversizedData(frame *proto.Frame) bool {
+	err := oversizedData(frame, int(c.maxData.Load()))
+	if err == nil {
+		return false
+	}
+	c.frameStats.inc(frame.Type, frameRejected)
+	log.Printf("协议错误，断开控制连接: %v", err)
+
+	c.controlMu.RLock()
+	controlConn := c.controlConn
+	c.controlMu.RUnlock()
+	if controlConn == nil {
+		return true
+	}
+	reply := &proto.Frame{Type: proto.FrameTypeERROR, Payload: []byte(err.Error())}
+	if err := writeFrame(controlConn, &c.controlWriteMu, reply, c.controlWriteTimeout); err != nil {
+		log.Printf("发送 ERROR 帧失败: %v", err)
+	}
+	controlConn.Close()
+	return true
+}
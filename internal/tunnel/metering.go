@@ -0,0 +1,231 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 计量记录的默认间隔和 webhook 请求超时
+const (
+	defaultMeteringInterval = time.Minute
+	meteringWebhookTimeout  = 10 * time.Second
+)
+
+// IdentityUsage 表示一个客户端身份的累计用量
+type IdentityUsage struct {
+	Identity string `json:"identity"`  // 客户端身份（证书 CN，非 TLS 连接或没有证书的客户端为空）
+	BytesIn  uint64 `json:"bytes_in"`  // 从公开连接读取、发给客户端的字节数
+	BytesOut uint64 `json:"bytes_out"` // 从客户端收到、写入公开连接的字节数
+	Sessions uint64 `json:"sessions"`  // 注册过的控制连接数
+}
+
+// MeteringRecord 表示一次计量快照：从 Since 起按身份累计的用量（GET /metering 的响应、计量文件和 webhook 的内容）
+type MeteringRecord struct {
+	Time       time.Time       `json:"time"`  // 快照时间
+	Since      time.Time       `json:"since"` // 开始计量的时间（从计量文件恢复时为文件中的时间）
+	Identities []IdentityUsage `json:"identities"`
+}
+
+// identityCounters 一个身份的累计计数，同一身份的所有客户端共享（跨重连累计）
+type identityCounters struct {
+	in       atomic.Uint64
+	out      atomic.Uint64
+	sessions atomic.Uint64
+}
+
+// meter 按客户端身份累计公开连接的字节数，与 clientID（每次重连都会变化）无关，用于按用量计费
+// 配置了计量文件时启动时从文件恢复累计值（进程重启后继续累计），并周期性地写入快照；
+// 配置了 webhook 时周期性地 POST 快照。写文件和推送失败只记录日志，不影响隧道
+type meter struct {
+	file     string        // 计量文件路径（留空则不写，也不恢复）
+	webhook  string        // webhook URL（留空则不推送）
+	interval time.Duration // 写文件和推送的间隔
+
+	mu         sync.Mutex
+	since      time.Time
+	identities map[string]*identityCounters
+
+	client *http.Client
+}
+
+// newMeter 创建计量器，file 非空时从该文件恢复累计值（文件不存在时从零开始），interval 为 0 时使用 defaultMeteringInterval
+func newMeter(file, webhook string, interval time.Duration) *meter {
+	if interval <= 0 {
+		interval = defaultMeteringInterval
+	}
+	m := &meter{
+		file:       file,
+		webhook:    webhook,
+		interval:   interval,
+		since:      time.Now(),
+		identities: make(map[string]*identityCounters),
+		client:     &http.Client{Timeout: meteringWebhookTimeout},
+	}
+	if file != "" {
+		if err := m.restore(); err != nil {
+			log.Printf("恢复计量文件失败，从零开始计量: %v", err)
+		}
+	}
+	return m
+}
+
+// restore 从计量文件恢复累计值
+func (m *meter) restore() error {
+	data, err := os.ReadFile(m.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var rec MeteringRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", m.file, err)
+	}
+	if !rec.Since.IsZero() {
+		m.since = rec.Since
+	}
+	for _, u := range rec.Identities {
+		c := m.counters(u.Identity)
+		c.in.Store(u.BytesIn)
+		c.out.Store(u.BytesOut)
+		c.sessions.Store(u.Sessions)
+	}
+	log.Printf("已从计量文件恢复 %d 个身份的累计用量 (自 %s): %s", len(rec.Identities), m.since.Format(time.RFC3339), m.file)
+	return nil
+}
+
+// counters 返回身份的计数（不存在时创建）
+func (m *meter) counters(identity string) *identityCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.identities[identity]
+	if !ok {
+		c = &identityCounters{}
+		m.identities[identity] = c
+	}
+	return c
+}
+
+// session 登记身份的一次注册，返回该身份的计数（保存在 ClientInfo 中，转发时直接累加）
+func (m *meter) session(identity string) *identityCounters {
+	c := m.counters(identity)
+	c.sessions.Add(1)
+	return c
+}
+
+// snapshot 返回当前的计量快照（按身份排序）
+func (m *meter) snapshot() MeteringRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec := MeteringRecord{Time: time.Now(), Since: m.since, Identities: make([]IdentityUsage, 0, len(m.identities))}
+	for identity, c := range m.identities {
+		rec.Identities = append(rec.Identities, IdentityUsage{
+			Identity: identity,
+			BytesIn:  c.in.Load(),
+			BytesOut: c.out.Load(),
+			Sessions: c.sessions.Load(),
+		})
+	}
+	sort.Slice(rec.Identities, func(i, j int) bool { return rec.Identities[i].Identity < rec.Identities[j].Identity })
+	return rec
+}
+
+// run 每隔 interval 写入计量文件并推送 webhook，直到 ctx 结束；结束时再写一次文件，保存最后的累计值
+func (m *meter) run(ctx context.Context) {
+	if m.file == "" && m.webhook == "" {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.writeFile(m.snapshot())
+			return
+		case <-ticker.C:
+			rec := m.snapshot()
+			m.writeFile(rec)
+			if m.webhook != "" {
+				if err := m.post(ctx, rec); err != nil {
+					log.Printf("计量 webhook 失败: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// writeFile 写入计量文件，先写临时文件再重命名，读取方不会看到写了一半的内容
+func (m *meter) writeFile(rec MeteringRecord) {
+	if m.file == "" {
+		return
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		log.Printf("编码计量记录失败: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.file), filepath.Base(m.file)+".tmp*")
+	if err != nil {
+		log.Printf("写入计量文件失败: %v", err)
+		return
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("写入计量文件失败: %v", err)
+	}
+}
+
+// post 向 webhook 发送计量快照，非 2xx 响应视为失败
+func (m *meter) post(ctx context.Context, rec MeteringRecord) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook 返回 HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Metering 返回按客户端身份累计的用量快照（跨重连累计，配置了计量文件时跨进程重启累计）
+func (s *Server) Metering() MeteringRecord {
+	return s.meter.snapshot()
+}
+
+// MeteringHandler 返回以 JSON 输出 Metering 的处理器（路由 GET /metering）
+func (s *Server) MeteringHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.Metering()); err != nil {
+			log.Printf("输出计量记录失败: %v", err)
+		}
+	})
+}
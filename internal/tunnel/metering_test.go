@@ -0,0 +1,97 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMeteringAcrossReconnects 测试同一身份的用量跨重连累计（与 clientID 无关），并通过 GET /metering（需要管理令牌）输出
+func TestMeteringAcrossReconnects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// 第一个控制连接在回显写回 100 字节后被重置，客户端重连
+	public, server, _ := startFaultTunnel(ctx, t, []faultPlan{{resetAfter: 9 + 100}})
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	conn.Write(make([]byte, 1000))
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-2")
+	conn.Close()
+
+	if err := echoRoundTrip(ctx, public, make([]byte, 500)); err != nil {
+		t.Fatalf("重连后隧道应恢复: %v", err)
+	}
+	waitStat(t, "累计写入公开连接的字节数", func() uint64 { return server.Metering().Identities[0].BytesOut }, 500)
+
+	rec := server.Metering()
+	if len(rec.Identities) != 1 {
+		t.Fatalf("期望 1 个身份, 得到 %+v", rec.Identities)
+	}
+	got := rec.Identities[0]
+	if got.Identity != "" || got.Sessions != 2 || got.BytesIn != 1500 {
+		t.Errorf("身份用量应跨重连累计 (2 次注册, 读取 1500 字节): %+v", got)
+	}
+
+	// /metering 需要管理令牌（只在 auxHandler 中读取，直接设置）
+	server.adminToken = "secret"
+	unauthorized := httptest.NewRecorder()
+	server.auxHandler().ServeHTTP(unauthorized, httptest.NewRequest(http.MethodGet, "/metering", nil))
+	if unauthorized.Code != http.StatusUnauthorized {
+		t.Errorf("未携带管理令牌访问 /metering 应返回 401，得到 %d", unauthorized.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/metering", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp := httptest.NewRecorder()
+	server.auxHandler().ServeHTTP(resp, req)
+	var body MeteringRecord
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("解析 /metering 响应失败: %v", err)
+	}
+	if len(body.Identities) != 1 || body.Identities[0] != got || !body.Since.Equal(rec.Since) {
+		t.Errorf("/metering 应返回当前快照: %+v", body)
+	}
+}
+
+// TestMeterRestore 测试计量文件在重启后恢复累计值和开始计量的时间
+func TestMeterRestore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metering.json")
+	m := newMeter(file, "", 0)
+	c := m.session("alice")
+	c.in.Add(10)
+	c.out.Add(20)
+	m.session("bob").in.Add(5)
+	m.writeFile(m.snapshot())
+
+	restored := newMeter(file, "", 0)
+	if !restored.since.Equal(m.since) {
+		t.Errorf("开始计量的时间应从文件恢复: %v, 期望 %v", restored.since, m.since)
+	}
+	restored.session("alice").out.Add(1)
+	rec := restored.snapshot()
+	want := []IdentityUsage{
+		{Identity: "alice", BytesIn: 10, BytesOut: 21, Sessions: 2},
+		{Identity: "bob", BytesIn: 5, Sessions: 1},
+	}
+	if len(rec.Identities) != len(want) {
+		t.Fatalf("期望 %d 个身份, 得到 %+v", len(want), rec.Identities)
+	}
+	for i := range want {
+		if rec.Identities[i] != want[i] {
+			t.Errorf("身份 %d: 得到 %+v, 期望 %+v", i, rec.Identities[i], want[i])
+		}
+	}
+
+	// 文件不存在时从零开始
+	empty := newMeter(filepath.Join(t.TempDir(), "missing.json"), "", 0)
+	if n := len(empty.snapshot().Identities); n != 0 || time.Since(empty.since) > time.Minute {
+		t.Errorf("文件不存在时应从零开始计量: %d 个身份, since=%v", n, empty.since)
+	}
+}
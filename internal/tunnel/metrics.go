@@ -0,0 +1,303 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+
+	"reverse-tunnel/internal/pqctls"
+)
+
+// MetricsHandler 返回 Prometheus 文本格式的指标处理器
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		s.writeMetrics(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(labelMetrics(buf.Bytes(), s.name))
+	})
+}
+
+// StatusHandler 返回以 JSON 输出 ClientStatus 的处理器
+func (s *Server) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.ClientStatus()); err != nil {
+			log.Printf("输出客户端状态失败: %v", err)
+		}
+	})
+}
+
+// IdentityPortHandler 返回查询身份当前公开端口的处理器（路由 GET /identity/{cn}/port）
+// 以 JSON 输出 {"identity": ..., "port": ...}，该身份没有在线客户端或尚未分配端口时返回 404
+func (s *Server) IdentityPortHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn := r.PathValue("cn")
+		port, ok := s.PortForIdentity(cn)
+		if !ok {
+			http.Error(w, "identity not connected", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			Identity string `json:"identity"`
+			Port     int    `json:"port"`
+		}{cn, port}); err != nil {
+			log.Printf("输出身份端口失败: %v", err)
+		}
+	})
+}
+
+// writeMetrics 写入所有指标
+func (s *Server) writeMetrics(buf *bytes.Buffer) {
+	statuses := s.ClientStatus()
+
+	buf.WriteString("# HELP reverse_tunnel_clients Number of connected clients.\n")
+	buf.WriteString("# TYPE reverse_tunnel_clients gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_clients %d\n", len(statuses))
+
+	buf.WriteString("# HELP reverse_tunnel_bytes_total Bytes forwarded through public connections.\n")
+	buf.WriteString("# TYPE reverse_tunnel_bytes_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_bytes_total{direction=\"in\"} %d\n", s.inRate.Total())
+	fmt.Fprintf(buf, "reverse_tunnel_bytes_total{direction=\"out\"} %d\n", s.outRate.Total())
+
+	in, out := s.Throughput()
+	buf.WriteString("# HELP reverse_tunnel_throughput_bytes_per_second Aggregate throughput (EWMA).\n")
+	buf.WriteString("# TYPE reverse_tunnel_throughput_bytes_per_second gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_throughput_bytes_per_second{direction=\"in\"} %g\n", in)
+	fmt.Fprintf(buf, "reverse_tunnel_throughput_bytes_per_second{direction=\"out\"} %g\n", out)
+
+	buf.WriteString("# HELP reverse_tunnel_public_queue_depth Public connections waiting for a worker.\n")
+	buf.WriteString("# TYPE reverse_tunnel_public_queue_depth gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_public_queue_depth %d\n", len(s.publicConnQueue))
+
+	buf.WriteString("# HELP reverse_tunnel_public_queue_rejected_total Public connections rejected because the queue was full.\n")
+	buf.WriteString("# TYPE reverse_tunnel_public_queue_rejected_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_public_queue_rejected_total %d\n", atomic.LoadUint64(&s.publicQueueRejected))
+
+	s.sourceLimit.writeMetrics(buf)
+	s.clientShare.writeMetrics(buf)
+
+	if s.portPool != nil {
+		size, leased := s.portPool.stats()
+		buf.WriteString("# HELP reverse_tunnel_port_pool_size Remote ports in the port pool.\n")
+		buf.WriteString("# TYPE reverse_tunnel_port_pool_size gauge\n")
+		fmt.Fprintf(buf, "reverse_tunnel_port_pool_size %d\n", size)
+		buf.WriteString("# HELP reverse_tunnel_port_pool_leased Remote ports from the port pool currently leased to clients.\n")
+		buf.WriteString("# TYPE reverse_tunnel_port_pool_leased gauge\n")
+		fmt.Fprintf(buf, "reverse_tunnel_port_pool_leased %d\n", leased)
+	}
+
+	buf.WriteString("# HELP reverse_tunnel_active_forwarders Goroutines forwarding public connection data to clients (one per public connection).\n")
+	buf.WriteString("# TYPE reverse_tunnel_active_forwarders gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_active_forwarders %d\n", s.activeForwarders.Load())
+	buf.WriteString("# HELP reverse_tunnel_forwarders_rejected_total Public connections rejected because the forwarder limit was reached.\n")
+	buf.WriteString("# TYPE reverse_tunnel_forwarders_rejected_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_forwarders_rejected_total %d\n", s.forwardersRejected.Load())
+	buf.WriteString("# HELP reverse_tunnel_conn_setup_timeouts_total Public connections closed because the client did not set up the local connection in time.\n")
+	buf.WriteString("# TYPE reverse_tunnel_conn_setup_timeouts_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_conn_setup_timeouts_total %d\n", s.connSetupTimeouts.Load())
+	buf.WriteString("# HELP reverse_tunnel_new_conn_backpressure_total Times a public connection or a client's accept loop waited because the client had too many NEW_CONN setups in flight.\n")
+	buf.WriteString("# TYPE reverse_tunnel_new_conn_backpressure_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_new_conn_backpressure_total %d\n", s.setupBackpressure.Load())
+	buf.WriteString("# HELP reverse_tunnel_new_conn_backpressure_rejected_total Public connections closed because no NEW_CONN setup slot became free in time.\n")
+	buf.WriteString("# TYPE reverse_tunnel_new_conn_backpressure_rejected_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_new_conn_backpressure_rejected_total %d\n", s.setupRejected.Load())
+	buf.WriteString("# HELP reverse_tunnel_init_timeouts_total Control connections closed because the client sent neither HELLO nor INIT within the init timeout.\n")
+	buf.WriteString("# TYPE reverse_tunnel_init_timeouts_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_init_timeouts_total %d\n", s.initTimeouts.Load())
+	buf.WriteString("# HELP reverse_tunnel_flow_control_stalls_total Times a public connection stopped reading because its flow-control window to the client was exhausted.\n")
+	buf.WriteString("# TYPE reverse_tunnel_flow_control_stalls_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_flow_control_stalls_total %d\n", s.flowStalls.Load())
+	s.closeCategories.writeMetrics(buf)
+	s.events.writeMetrics(buf)
+	s.otlp.writeMetrics(buf)
+	if s.maxObservers > 0 {
+		s.observers.writeMetrics(buf)
+	}
+	if s.httpCompression {
+		buf.WriteString("# HELP reverse_tunnel_http_compressed_responses_total HTTP responses on public connections compressed with gzip.\n")
+		buf.WriteString("# TYPE reverse_tunnel_http_compressed_responses_total counter\n")
+		fmt.Fprintf(buf, "reverse_tunnel_http_compressed_responses_total %d\n", s.httpCompressed.Load())
+	}
+	if s.publicFallback != nil {
+		buf.WriteString("# HELP reverse_tunnel_public_fallback_responses_total Public connections answered with the static fallback page because no client was available.\n")
+		buf.WriteString("# TYPE reverse_tunnel_public_fallback_responses_total counter\n")
+		fmt.Fprintf(buf, "reverse_tunnel_public_fallback_responses_total %d\n", s.publicFallbackServed.Load())
+	}
+	if s.noClientPolicy == NoClientPolicyHold {
+		buf.WriteString("# HELP reverse_tunnel_no_client_holds Public connections currently waiting for a client to connect.\n")
+		buf.WriteString("# TYPE reverse_tunnel_no_client_holds gauge\n")
+		fmt.Fprintf(buf, "reverse_tunnel_no_client_holds %d\n", s.noClientHolds.Load())
+		buf.WriteString("# HELP reverse_tunnel_no_client_hold_rejected_total Public connections closed because too many connections were already waiting for a client.\n")
+		buf.WriteString("# TYPE reverse_tunnel_no_client_hold_rejected_total counter\n")
+		fmt.Fprintf(buf, "reverse_tunnel_no_client_hold_rejected_total %d\n", s.noClientHoldRejected.Load())
+	}
+	buf.WriteString("# HELP reverse_tunnel_paused_rejected_total Public connections closed because the routed client was paused by the admin API.\n")
+	buf.WriteString("# TYPE reverse_tunnel_paused_rejected_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_paused_rejected_total %d\n", s.pausedRejected.Load())
+	if s.lazyNewConn {
+		buf.WriteString("# HELP reverse_tunnel_lazy_conns_dropped_total Public connections closed before sending any data while waiting to send NEW_CONN.\n")
+		buf.WriteString("# TYPE reverse_tunnel_lazy_conns_dropped_total counter\n")
+		fmt.Fprintf(buf, "reverse_tunnel_lazy_conns_dropped_total %d\n", s.lazyConnsDropped.Load())
+	}
+	buf.WriteString("# HELP reverse_tunnel_goroutines Goroutines in the server process.\n")
+	buf.WriteString("# TYPE reverse_tunnel_goroutines gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_goroutines %d\n", runtime.NumGoroutine())
+
+	if s.publicFairQueue != nil {
+		stats := s.publicFairQueue.stats()
+		buf.WriteString("# HELP reverse_tunnel_client_queue_depth Routed public connections waiting in a client's fair queue.\n")
+		buf.WriteString("# TYPE reverse_tunnel_client_queue_depth gauge\n")
+		for _, st := range stats {
+			fmt.Fprintf(buf, "reverse_tunnel_client_queue_depth{client_id=%q} %d\n", st.clientID, st.depth)
+		}
+		buf.WriteString("# HELP reverse_tunnel_client_queue_rejected_total Public connections rejected because a client's fair queue was full.\n")
+		buf.WriteString("# TYPE reverse_tunnel_client_queue_rejected_total counter\n")
+		for _, st := range stats {
+			fmt.Fprintf(buf, "reverse_tunnel_client_queue_rejected_total{client_id=%q} %d\n", st.clientID, st.rejected)
+		}
+	}
+
+	buf.WriteString("# HELP reverse_tunnel_client_active_connections Active public connections per client.\n")
+	buf.WriteString("# TYPE reverse_tunnel_client_active_connections gauge\n")
+	for _, st := range statuses {
+		fmt.Fprintf(buf, "reverse_tunnel_client_active_connections{client_id=%q} %d\n", st.ID, st.ActiveConns)
+	}
+	buf.WriteString("# HELP reverse_tunnel_client_new_conn_in_flight Public connections per client whose NEW_CONN has been sent but not yet acknowledged.\n")
+	buf.WriteString("# TYPE reverse_tunnel_client_new_conn_in_flight gauge\n")
+	for _, st := range statuses {
+		fmt.Fprintf(buf, "reverse_tunnel_client_new_conn_in_flight{client_id=%q} %d\n", st.ID, st.NewConnInFlight)
+	}
+
+	buf.WriteString("# HELP reverse_tunnel_client_throughput_bytes_per_second Per-client throughput (EWMA).\n")
+	buf.WriteString("# TYPE reverse_tunnel_client_throughput_bytes_per_second gauge\n")
+	for _, st := range statuses {
+		fmt.Fprintf(buf, "reverse_tunnel_client_throughput_bytes_per_second{client_id=%q,direction=\"in\"} %g\n", st.ID, st.InRate)
+		fmt.Fprintf(buf, "reverse_tunnel_client_throughput_bytes_per_second{client_id=%q,direction=\"out\"} %g\n", st.ID, st.OutRate)
+	}
+
+	buf.WriteString("# HELP reverse_tunnel_control_write_blocked_seconds_total Time spent writing frames to a client's control connection, including waiting for other writers (head-of-line blocking).\n")
+	buf.WriteString("# TYPE reverse_tunnel_control_write_blocked_seconds_total counter\n")
+	for _, st := range statuses {
+		fmt.Fprintf(buf, "reverse_tunnel_control_write_blocked_seconds_total{client_id=%q} %g\n", st.ID, st.ControlWriteBlocked)
+	}
+	buf.WriteString("# HELP reverse_tunnel_control_write_queue_depth Frames waiting for or being written to a client's control connection.\n")
+	buf.WriteString("# TYPE reverse_tunnel_control_write_queue_depth gauge\n")
+	for _, st := range statuses {
+		fmt.Fprintf(buf, "reverse_tunnel_control_write_queue_depth{client_id=%q} %d\n", st.ID, st.ControlWriteQueue)
+	}
+	buf.WriteString("# HELP reverse_tunnel_control_write_queue_max Maximum number of frames queued on a client's control connection.\n")
+	buf.WriteString("# TYPE reverse_tunnel_control_write_queue_max gauge\n")
+	for _, st := range statuses {
+		fmt.Fprintf(buf, "reverse_tunnel_control_write_queue_max{client_id=%q} %d\n", st.ID, st.ControlWriteQueueMax)
+	}
+
+	buf.WriteString("# HELP reverse_tunnel_handshake_rejected_total Control connections rejected during the handshake or identity check.\n")
+	buf.WriteString("# TYPE reverse_tunnel_handshake_rejected_total counter\n")
+	reasons, counts := s.securityLog.rejectCounts()
+	for _, reason := range reasons {
+		fmt.Fprintf(buf, "reverse_tunnel_handshake_rejected_total{reason=%q} %d\n", reason, counts[reason])
+	}
+	writeHandshakeMetrics(buf, pqctls.HandshakeStats())
+	s.handshakeStats.writeMetrics(buf)
+
+	s.frameStats.writeMetrics(buf)
+	s.payloadSizes.writeMetrics(buf)
+	s.decodeErrors.writeMetrics(buf)
+}
+
+// writeHandshakeMetrics 写入 PQC 握手的耗时直方图、次数和字节数（按角色、结果、协商的密钥交换组和对端签名算法），
+// 用于评估握手开销（例如对比 ok 与 resumed 的耗时以决定是否启用会话恢复）和容量规划
+func writeHandshakeMetrics(buf *bytes.Buffer, stats []pqctls.HandshakeStat) {
+	buf.WriteString("# HELP reverse_tunnel_pqc_handshake_duration_seconds Duration of PQC TLS handshakes, including failed ones.\n")
+	buf.WriteString("# TYPE reverse_tunnel_pqc_handshake_duration_seconds histogram\n")
+	for _, st := range stats {
+		labels := handshakeLabels(st)
+		for i, le := range pqctls.HandshakeBuckets {
+			fmt.Fprintf(buf, "reverse_tunnel_pqc_handshake_duration_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(le, 'g', -1, 64), st.Buckets[i])
+		}
+		fmt.Fprintf(buf, "reverse_tunnel_pqc_handshake_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, st.Count)
+		fmt.Fprintf(buf, "reverse_tunnel_pqc_handshake_duration_seconds_sum{%s} %g\n", labels, st.Seconds)
+		fmt.Fprintf(buf, "reverse_tunnel_pqc_handshake_duration_seconds_count{%s} %d\n", labels, st.Count)
+	}
+	buf.WriteString("# HELP reverse_tunnel_pqc_handshakes_total PQC TLS handshakes by role, outcome, negotiated key exchange group and peer signature algorithm.\n")
+	buf.WriteString("# TYPE reverse_tunnel_pqc_handshakes_total counter\n")
+	for _, st := range stats {
+		fmt.Fprintf(buf, "reverse_tunnel_pqc_handshakes_total{%s} %d\n", handshakeLabels(st), st.Count)
+	}
+	buf.WriteString("# HELP reverse_tunnel_pqc_handshake_bytes_total Bytes exchanged during PQC TLS handshakes.\n")
+	buf.WriteString("# TYPE reverse_tunnel_pqc_handshake_bytes_total counter\n")
+	for _, st := range stats {
+		fmt.Fprintf(buf, "reverse_tunnel_pqc_handshake_bytes_total{%s,direction=\"in\"} %d\n", handshakeLabels(st), st.BytesRead)
+		fmt.Fprintf(buf, "reverse_tunnel_pqc_handshake_bytes_total{%s,direction=\"out\"} %d\n", handshakeLabels(st), st.BytesWritten)
+	}
+}
+
+// handshakeLabels 返回握手统计的标签：角色、结果、协商的密钥交换组和对端签名算法，
+// 按算法分布可以规划算法的淘汰、发现仍停留在较弱参数上的客户端
+func handshakeLabels(st pqctls.HandshakeStat) string {
+	return fmt.Sprintf("role=%q,outcome=%q,group=%q,sigalg=%q", st.Role, st.Outcome, st.Group, st.Sigalg)
+}
+
+// MetricsHandler 返回客户端的 Prometheus 文本格式指标处理器（帧处理计数）
+func (c *Client) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		c.writeMetrics(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(labelMetrics(buf.Bytes(), c.name))
+	})
+}
+
+// writeMetrics 写入客户端的所有指标（/metrics 和 OTLP 推送共用）
+func (c *Client) writeMetrics(buf *bytes.Buffer) {
+	c.frameStats.writeMetrics(buf)
+	c.payloadSizes.writeMetrics(buf)
+	blocked, depth, maxDepth := c.controlWriteMu.writeStats()
+	buf.WriteString("# HELP reverse_tunnel_control_write_blocked_seconds_total Time spent writing frames to the control connection, including waiting for other writers (head-of-line blocking).\n")
+	buf.WriteString("# TYPE reverse_tunnel_control_write_blocked_seconds_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_control_write_blocked_seconds_total %g\n", blocked)
+	buf.WriteString("# HELP reverse_tunnel_control_write_queue_depth Frames waiting for or being written to the control connection.\n")
+	buf.WriteString("# TYPE reverse_tunnel_control_write_queue_depth gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_control_write_queue_depth %d\n", depth)
+	buf.WriteString("# HELP reverse_tunnel_control_write_queue_max Maximum number of frames queued on the control connection.\n")
+	buf.WriteString("# TYPE reverse_tunnel_control_write_queue_max gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_control_write_queue_max %d\n", maxDepth)
+	if c.dialPool != nil {
+		c.dialPool.writeMetrics(buf)
+	}
+	writeHandshakeMetrics(buf, pqctls.HandshakeStats())
+	c.otlp.writeMetrics(buf)
+}
+
+// labelMetrics 为 Prometheus 文本中的每个样本附加 name 标签（name 为空时原样返回），
+// 汇总多个实例的指标时区分来源
+func labelMetrics(data []byte, name string) []byte {
+	if name == "" {
+		return data
+	}
+	label := fmt.Sprintf("name=%q", name)
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		i := bytes.IndexAny(line, "{ ")
+		if len(line) == 0 || line[0] == '#' || i < 0 {
+			out.Write(line)
+			continue
+		}
+		out.Write(line[:i])
+		if line[i] == '{' {
+			out.WriteString("{" + label + ",")
+			out.Write(line[i+1:])
+		} else {
+			out.WriteString("{" + label + "}")
+			out.Write(line[i:])
+		}
+	}
+	return out.Bytes()
+}
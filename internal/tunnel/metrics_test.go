@@ -0,0 +1,372 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/pqctls"
+	"reverse-tunnel/internal/proto"
+)
+
+// TestRateMeterTracksSustainedLoad 测试 EWMA 吞吐在持续负载下收敛到实际速率
+func TestRateMeterTracksSustainedLoad(t *testing.T) {
+	m := newRateMeter()
+
+	// 先空闲一段时间，再以 1 MB/s 持续 60 秒
+	for i := 0; i < 5; i++ {
+		m.tick(time.Second)
+	}
+	const rate = 1 << 20
+	for i := 0; i < 60; i++ {
+		m.add(rate)
+		m.tick(time.Second)
+	}
+
+	if got := m.Rate(); math.Abs(got-rate)/rate > 0.01 {
+		t.Errorf("持续负载下速率偏差过大: 期望约 %d, 得到 %.0f", rate, got)
+	}
+	if m.Total() != 60*rate {
+		t.Errorf("累计字节数不匹配: 期望 %d, 得到 %d", 60*rate, m.Total())
+	}
+
+	// 停止负载后速率应衰减
+	for i := 0; i < 30; i++ {
+		m.tick(time.Second)
+	}
+	if got := m.Rate(); got > rate*0.1 {
+		t.Errorf("负载停止 30 秒后速率应显著下降，得到 %.0f", got)
+	}
+}
+
+// TestMetricsHandler 测试指标输出包含吞吐 gauge
+func TestMetricsHandler(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "")
+	server.inRate.add(2048)
+	server.inRate.tick(time.Second)
+
+	rec := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"reverse_tunnel_clients 0",
+		`reverse_tunnel_throughput_bytes_per_second{direction="in"} 2048`,
+		`reverse_tunnel_bytes_total{direction="in"} 2048`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("指标输出缺少 %q:\n%s", want, body)
+		}
+	}
+}
+
+// TestHandshakeMetrics 测试 PQC 握手统计输出为直方图（累计桶、+Inf、sum、count）、按密钥交换组和签名算法的次数和字节数
+func TestHandshakeMetrics(t *testing.T) {
+	buckets := make([]uint64, len(pqctls.HandshakeBuckets))
+	for i, le := range pqctls.HandshakeBuckets {
+		if le >= 0.05 {
+			buckets[i] = 2
+		} else if le >= 0.01 {
+			buckets[i] = 1
+		}
+	}
+	var buf bytes.Buffer
+	writeHandshakeMetrics(&buf, []pqctls.HandshakeStat{{
+		Role: pqctls.RoleServer, Outcome: pqctls.OutcomeOK, Group: "X25519MLKEM768", Sigalg: "ML-DSA-65",
+		Count: 3, Buckets: buckets, Seconds: 1.5, BytesRead: 9000, BytesWritten: 21000,
+	}})
+
+	body := buf.String()
+	labels := `role="server",outcome="ok",group="X25519MLKEM768",sigalg="ML-DSA-65"`
+	for _, want := range []string{
+		"# TYPE reverse_tunnel_pqc_handshake_duration_seconds histogram\n",
+		`reverse_tunnel_pqc_handshake_duration_seconds_bucket{` + labels + `,le="0.005"} 0`,
+		`reverse_tunnel_pqc_handshake_duration_seconds_bucket{` + labels + `,le="0.01"} 1`,
+		`reverse_tunnel_pqc_handshake_duration_seconds_bucket{` + labels + `,le="0.05"} 2`,
+		`reverse_tunnel_pqc_handshake_duration_seconds_bucket{` + labels + `,le="+Inf"} 3`,
+		`reverse_tunnel_pqc_handshake_duration_seconds_sum{` + labels + `} 1.5`,
+		`reverse_tunnel_pqc_handshake_duration_seconds_count{` + labels + `} 3`,
+		`reverse_tunnel_pqc_handshakes_total{` + labels + `} 3`,
+		`reverse_tunnel_pqc_handshake_bytes_total{` + labels + `,direction="in"} 9000`,
+		`reverse_tunnel_pqc_handshake_bytes_total{` + labels + `,direction="out"} 21000`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("指标输出缺少 %q:\n%s", want, body)
+		}
+	}
+}
+
+// TestMetricsName 测试配置实例名称后每个样本都带有 name 标签，注释行保持不变
+func TestMetricsName(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "", WithServerName("edge-1"))
+	server.inRate.add(2048)
+
+	rec := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE reverse_tunnel_clients gauge\n",
+		`reverse_tunnel_clients{name="edge-1"} 0`,
+		`reverse_tunnel_bytes_total{name="edge-1",direction="in"} 2048`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("指标输出缺少 %q:\n%s", want, body)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "#") && !strings.Contains(line, `{name="edge-1"`) {
+			t.Errorf("样本缺少 name 标签: %q", line)
+		}
+	}
+}
+
+// TestMetricsListenerBindFailure 测试指标监听器绑定失败时的降级和严格模式
+func TestMetricsListenerBindFailure(t *testing.T) {
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("占用端口失败: %v", err)
+	}
+	defer occupied.Close()
+
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	// 默认模式：隧道照常工作
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, publicAddr, WithServerMetricsListen(occupied.Addr().String()))
+	runErr := make(chan error, 1)
+	go func() { runErr <- server.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, 0)
+	go client.Run(ctx)
+	time.Sleep(500 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("指标监听器失败后隧道不可用: %v", err)
+	}
+	conn.Close()
+
+	select {
+	case err := <-runErr:
+		t.Fatalf("非严格模式下 Run 不应返回: %v", err)
+	default:
+	}
+
+	// 严格模式：Run 返回错误
+	strict := NewServer(fmt.Sprintf("127.0.0.1:%d", getFreePort(t)), "",
+		WithServerMetricsListen(occupied.Addr().String()), WithServerStrictAuxListeners(true))
+	strictCtx, strictCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer strictCancel()
+	if err := strict.Run(strictCtx); err == nil || err == context.DeadlineExceeded {
+		t.Errorf("严格模式下指标监听器失败应使 Run 返回错误，得到: %v", err)
+	}
+}
+
+// TestPprofRequiresToken 测试 pprof 仅在启用且携带令牌时可访问
+func TestPprofRequiresToken(t *testing.T) {
+	cases := []struct {
+		opts   []ServerOption
+		header string
+		want   int
+	}{
+		{nil, "", http.StatusNotFound},
+		{[]ServerOption{WithServerPprof(true)}, "", http.StatusNotFound},
+		{[]ServerOption{WithServerPprof(true), WithServerAdminToken("secret")}, "", http.StatusUnauthorized},
+		{[]ServerOption{WithServerPprof(true), WithServerAdminToken("secret")}, "Bearer wrong", http.StatusUnauthorized},
+		{[]ServerOption{WithServerPprof(true), WithServerAdminToken("secret")}, "Bearer secret", http.StatusOK},
+	}
+	for i, tc := range cases {
+		server := NewServer("127.0.0.1:0", "", tc.opts...)
+		req := httptest.NewRequest("GET", "/debug/pprof/", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		server.auxHandler().ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("用例 %d: 期望状态码 %d, 得到 %d", i, tc.want, rec.Code)
+		}
+	}
+}
+
+// TestPortForIdentity 测试按身份查询当前公开端口（纯 TCP 连接的身份为空字符串），HTTP 查询需要管理令牌
+func TestPortForIdentity(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	remotePort := getFreePort(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, "", WithServerAdminToken("secret"))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := server.PortForIdentity(""); ok {
+		t.Errorf("没有客户端时不应查到端口")
+	}
+
+	client := NewClient(controlAddr, localAddr, remotePort)
+	if err := client.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	if err := client.setupTunnel(ctx); err != nil {
+		t.Fatalf("建立隧道失败: %v", err)
+	}
+
+	if port, ok := server.PortForIdentity(""); !ok || port != remotePort {
+		t.Errorf("期望端口 %d, 得到 %d (ok=%v)", remotePort, port, ok)
+	}
+
+	query := func(server *Server, token string) int {
+		req := httptest.NewRequest("GET", "/identity/tenant-a/port", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.auxHandler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := query(server, "secret"); code != http.StatusNotFound {
+		t.Errorf("未连接的身份应返回 404，得到 %d", code)
+	}
+	if code := query(server, ""); code != http.StatusUnauthorized {
+		t.Errorf("未携带管理令牌应返回 401，得到 %d", code)
+	}
+	if code := query(server, "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("管理令牌错误应返回 401，得到 %d", code)
+	}
+	if code := query(NewServer("127.0.0.1:0", ""), ""); code != http.StatusNotFound {
+		t.Errorf("未设置管理令牌时不应挂载查询接口，得到 %d", code)
+	}
+
+	// 客户端断开后映射随之失效
+	client.closeControlConn()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := server.PortForIdentity(""); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端断开后仍能查到端口")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestStatusUI 测试内置状态页需要启用且携带令牌（Bearer 或 Basic 密码），并列出客户端
+func TestStatusUI(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "", WithServerStatusUI(true), WithServerAdminToken("secret"))
+	server.clients["client-1"] = &ClientInfo{ID: "client-1", Identity: "tenant-a", RemotePort: 9000,
+		ConnectedAt: time.Now(), inRate: newRateMeter(), outRate: newRateMeter()}
+
+	cases := []struct {
+		setAuth func(*http.Request)
+		want    int
+	}{
+		{func(*http.Request) {}, http.StatusUnauthorized},
+		{func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, http.StatusUnauthorized},
+		{func(r *http.Request) { r.SetBasicAuth("admin", "secret") }, http.StatusOK},
+		{func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+	}
+	for i, tc := range cases {
+		req := httptest.NewRequest("GET", "/ui/", nil)
+		tc.setAuth(req)
+		rec := httptest.NewRecorder()
+		server.auxHandler().ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("用例 %d: 期望状态码 %d, 得到 %d", i, tc.want, rec.Code)
+		}
+		if rec.Code == http.StatusOK {
+			body := rec.Body.String()
+			for _, want := range []string{"client-1", "tenant-a", "9000", `http-equiv="refresh"`} {
+				if !strings.Contains(body, want) {
+					t.Errorf("状态页缺少 %q", want)
+				}
+			}
+		}
+	}
+
+	// 未设置令牌时不挂载
+	noToken := NewServer("127.0.0.1:0", "", WithServerStatusUI(true))
+	rec := httptest.NewRecorder()
+	noToken.auxHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/ui/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("未设置令牌时状态页不应挂载，得到 %d", rec.Code)
+	}
+}
+
+// TestFrameMetrics 测试按帧类型和处理结果计数：未知 connID 的 DATA/CLOSE 帧、客户端连接本地服务失败
+func TestFrameMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, publicAddr)
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// 服务器：未知 connID 的 DATA 和 CLOSE 帧
+	conn, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeDATA, ConnID: 99, Payload: []byte("x")}, time.Second)
+	writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeCLOSE, ConnID: 99}, time.Second)
+	time.Sleep(100 * time.Millisecond)
+	conn.Close() // 断开后公开连接只会路由到下面的客户端
+
+	// 客户端：本地服务不可达，NEW_CONN 记为 dial_error
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	client := NewClient(controlAddr, localAddr, 0)
+	go client.Run(ctx)
+	time.Sleep(300 * time.Millisecond)
+
+	publicConn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer publicConn.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for client.frameStats.get(proto.FrameTypeNEW_CONN, frameDialError) == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := client.frameStats.get(proto.FrameTypeNEW_CONN, frameDialError); n != 1 {
+		t.Errorf("客户端 new_conn/dial_error 计数: 期望 1, 得到 %d", n)
+	}
+
+	rec := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`reverse_tunnel_frames_total{frame="data",outcome="unknown_conn"} 1`,
+		`reverse_tunnel_frames_total{frame="close",outcome="unknown_conn"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("服务器指标缺少 %q:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	client.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `reverse_tunnel_frames_total{frame="new_conn",outcome="dial_error"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("客户端指标缺少 %q:\n%s", want, rec.Body.String())
+	}
+}
@@ -0,0 +1,134 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// 全局公开端口没有可用客户端时的策略
+const (
+	NoClientPolicyClose = "close" // 直接关闭连接（默认）
+	NoClientPolicyHold  = "hold"  // 在超时时间内等待客户端（重新）连接，超时后关闭
+	NoClientPolicyError = "error" // 回复 HTTP 503 后关闭
+)
+
+// defaultNoClientHoldTimeout NoClientPolicyHold 默认的最长等待时间
+const defaultNoClientHoldTimeout = 5 * time.Second
+
+// defaultNoClientMaxHolds NoClientPolicyHold 默认同时等待客户端的连接数上限
+const defaultNoClientMaxHolds = 1024
+
+// httpServiceUnavailableResponse 没有可用客户端时回复给外部连接的最小 HTTP 响应
+var httpServiceUnavailableResponse = func() string {
+	body := "503 Service Unavailable: no tunnel client is connected\n"
+	return fmt.Sprintf("HTTP/1.1 503 Service Unavailable\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+}()
+
+// notifyClientsChanged 唤醒等待客户端的公开连接（客户端注册或更新路由键后调用），调用方持有 clientsMu 写锁
+func (s *Server) notifyClientsChanged() {
+	if s.clientsChanged != nil {
+		close(s.clientsChanged)
+	}
+	s.clientsChanged = make(chan struct{})
+}
+
+// clientsChangedChan 返回下一次客户端变化时关闭的 channel
+func (s *Server) clientsChangedChan() <-chan struct{} {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	if s.clientsChanged == nil {
+		s.clientsChanged = make(chan struct{})
+	}
+	return s.clientsChanged
+}
+
+// startNoClientHold 按 NoClientPolicyHold 在单独的 goroutine 中等待客户端，选中客户端后照常分发连接，等待不占用 worker；
+// 同时等待的连接数达到上限（noClientMaxHolds）时直接关闭新连接
+func (s *Server) startNoClientHold(ctx context.Context, conn net.Conn, host string) {
+	limit := s.noClientMaxHolds
+	if limit <= 0 {
+		limit = defaultNoClientMaxHolds
+	}
+	if s.noClientHolds.Add(1) > int64(limit) {
+		s.noClientHolds.Add(-1)
+		s.noClientHoldRejected.Add(1)
+		s.noClientHoldRejectLog.printf("等待客户端的公开连接数已达上限 (%d)，关闭公开连接: %s", limit, conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	go func() {
+		defer s.noClientHolds.Add(-1)
+		conn, clientID := s.holdForClient(ctx, conn, host)
+		if clientID == "" {
+			return
+		}
+		conn, ok := s.clientShare.admit(conn, clientID)
+		if !ok {
+			return
+		}
+		s.dispatchPublicConn(ctx, publicConnJob{conn: conn, clientID: clientID, host: host}, true)
+	}()
+}
+
+// holdForClient 按 noClientPolicy 处理全局监听器上没有可用客户端的连接
+// NoClientPolicyHold 时等待客户端注册或更新路由键后重新选择（由 startNoClientHold 在单独的 goroutine 中调用），
+// 返回选中的客户端（连接可能包含预读数据，应替代原连接使用），最长等待时间有上限。
+// 其余情况（包括等待超时）由 rejectNoClient 关闭连接，返回的 clientID 为空
+func (s *Server) holdForClient(ctx context.Context, conn net.Conn, host string) (net.Conn, string) {
+	if s.noClientPolicy == NoClientPolicyHold {
+		timeout := s.noClientHoldTimeout
+		if timeout <= 0 {
+			timeout = defaultNoClientHoldTimeout
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for waiting := true; waiting; {
+			// 先取得 channel 再选择，选择之后注册的客户端同样会唤醒等待
+			changed := s.clientsChangedChan()
+			if clientID := s.pickGlobalClient(host); clientID != "" {
+				return conn, clientID
+			}
+			select {
+			case <-changed:
+				// 新注册的客户端声明了主机名，而连接到达时没有预读主机名
+				if _, peeked := conn.(*peekedConn); !peeked && s.publicTLS == nil && s.hostRoutingActive() {
+					conn, host = peekHostname(conn)
+				}
+			case <-timer.C:
+				waiting = false
+			case <-ctx.Done():
+				conn.Close()
+				return nil, ""
+			}
+		}
+		log.Printf("警告: %v 内没有可用的客户端 (主机名=%q)，关闭公开连接: %s", timeout, host, conn.RemoteAddr())
+		s.rejectNoClient(conn)
+		return nil, ""
+	}
+
+	if host != "" {
+		log.Printf("警告: 没有匹配主机名 %q 的客户端，关闭公开连接: %s", host, conn.RemoteAddr())
+	} else {
+		log.Printf("警告: 没有可用的客户端，关闭公开连接: %s", conn.RemoteAddr())
+	}
+	s.rejectNoClient(conn)
+	return nil, ""
+}
+
+// rejectNoClient 关闭没有可用客户端的公开连接：配置了静态页面时回复该页面，否则 NoClientPolicyError 时先回复 HTTP 503
+func (s *Server) rejectNoClient(conn net.Conn) {
+	if s.publicFallback != nil {
+		// 读取请求头最长需要数秒，不在 worker 中进行
+		go s.servePublicFallback(conn)
+		return
+	}
+	if s.noClientPolicy == NoClientPolicyError {
+		conn.SetWriteDeadline(time.Now().Add(publicErrorWriteTimeout))
+		io.WriteString(conn, httpServiceUnavailableResponse)
+	}
+	conn.Close()
+}
@@ -0,0 +1,187 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNoClientPolicy 测试全局公开端口没有可用客户端时 hold 策略等待客户端连接后转发，error 策略回复 HTTP 503，
+// 配置了静态页面时回复该页面
+func TestNoClientPolicy(t *testing.T) {
+	t.Run("hold", func(t *testing.T) {
+		control := newMemListener("control")
+		public := newMemListener("public")
+		local := newMemListener("local")
+		defer local.Close()
+		go serveMemEcho(local)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public),
+			WithServerNoClientPolicy(NoClientPolicyHold, 3*time.Second))
+		go server.Run(ctx)
+
+		// 连接在客户端连接之前到达
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		msg := []byte("held until a client connects")
+		go conn.Write(msg)
+
+		time.Sleep(100 * time.Millisecond)
+		client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local))
+		go client.Run(ctx)
+
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != string(msg) {
+			t.Fatalf("等待客户端连接的公开连接应被转发: %q, %v", buf, err)
+		}
+	})
+
+	t.Run("hold-limit", func(t *testing.T) {
+		control := newMemListener("control")
+		public := newMemListener("public")
+		local := newMemListener("local")
+		defer local.Close()
+		go serveMemEcho(local)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// 只有一个 worker：等待客户端的连接不占用 worker，超过上限的连接直接关闭
+		server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public),
+			WithServerPublicQueue(0, "", 1), WithServerNoClientPolicy(NoClientPolicyHold, 3*time.Second), WithServerNoClientMaxHolds(2))
+		go server.Run(ctx)
+
+		var held []net.Conn
+		for i := 0; i < 2; i++ {
+			conn, err := public.DialContext(ctx, "mem", "public")
+			if err != nil {
+				t.Fatalf("连接公开监听器失败: %v", err)
+			}
+			defer conn.Close()
+			held = append(held, conn)
+		}
+		waitStat(t, "等待客户端的连接数", func() int64 { return server.noClientHolds.Load() }, 2)
+
+		rejected, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer rejected.Close()
+		rejected.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := rejected.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("等待的连接数达到上限后新连接应被关闭: %v", err)
+		}
+		if got := server.noClientHoldRejected.Load(); got != 1 {
+			t.Errorf("因达到上限被关闭的连接数 = %d, want 1", got)
+		}
+
+		go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local)).Run(ctx)
+		for i, conn := range held {
+			conn.SetDeadline(time.Now().Add(3 * time.Second))
+			msg := []byte(fmt.Sprintf("held-%d", i))
+			go conn.Write(msg)
+			buf := make([]byte, len(msg))
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != string(msg) {
+				t.Fatalf("等待客户端连接的公开连接应被转发: %q, %v", buf, err)
+			}
+		}
+		waitStat(t, "等待客户端的连接数", func() int64 { return server.noClientHolds.Load() }, 0)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		public := newMemListener("public")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server := NewServer("", "", WithServerControlListener(newMemListener("control")), WithServerPublicListener(public),
+			WithServerNoClientPolicy(NoClientPolicyError, 0))
+		go server.Run(ctx)
+
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		resp, err := io.ReadAll(conn)
+		if err != nil || !strings.HasPrefix(string(resp), "HTTP/1.1 503 ") {
+			t.Fatalf("没有可用客户端时应回复 HTTP 503: %q, %v", resp, err)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		page := filepath.Join(t.TempDir(), "maintenance.html")
+		if err := os.WriteFile(page, []byte("<h1>maintenance</h1>"), 0644); err != nil {
+			t.Fatalf("写入静态页面失败: %v", err)
+		}
+		fallback, err := NewPublicFallback(502, "", page)
+		if err != nil {
+			t.Fatalf("创建静态页面失败: %v", err)
+		}
+
+		public := newMemListener("public")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// 只有一个 worker：读取请求头不占用 worker，不发送请求的连接不影响其他连接
+		server := NewServer("", "", WithServerControlListener(newMemListener("control")), WithServerPublicListener(public),
+			WithServerPublicQueue(0, "", 1), WithServerPublicFallback(fallback))
+		go server.Run(ctx)
+		idle, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer idle.Close()
+
+		request := func(req string) string {
+			conn, err := public.DialContext(ctx, "mem", "public")
+			if err != nil {
+				t.Fatalf("连接公开监听器失败: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			io.WriteString(conn, req)
+			resp, _ := io.ReadAll(conn)
+			return string(resp)
+		}
+
+		resp := request("GET / HTTP/1.1\r\nHost: app.example.com\r\n\r\n")
+		if !strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n") || !strings.HasSuffix(resp, "\r\n\r\n<h1>maintenance</h1>") ||
+			!strings.Contains(resp, "Content-Type: text/html; charset=utf-8\r\n") {
+			t.Errorf("没有可用客户端时应回复静态页面: %q", resp)
+		}
+		if resp := request("HEAD / HTTP/1.1\r\nHost: app.example.com\r\n\r\n"); !strings.HasSuffix(resp, "Content-Length: 20\r\nCache-Control: no-store\r\nConnection: close\r\n\r\n") {
+			t.Errorf("HEAD 请求的响应不应包含响应体: %q", resp)
+		}
+		if resp := request("SSH-2.0-OpenSSH_9.6\r\n"); resp != "" {
+			t.Errorf("非 HTTP 连接应直接关闭: %q", resp)
+		}
+
+		// 请求头超过上限时不等待读取超时，直接关闭
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		go io.WriteString(conn, "GET / HTTP/1.1\r\nHost: app.example.com\r\nX-Padding: "+strings.Repeat("a", publicFallbackMaxHeaderBytes))
+		if resp, err := io.ReadAll(conn); err != nil || len(resp) != 0 {
+			t.Errorf("请求头过大的连接应直接关闭: %q, %v", resp, err)
+		}
+		waitStat(t, "回复了静态页面的连接数", func() string { return strconv.FormatUint(server.publicFallbackServed.Load(), 10) }, "2")
+	})
+}
@@ -0,0 +1,204 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// observerQueueSize 每个观察者连接排队等待发送的事件数上限，观察者读取过慢时丢弃新事件（不阻塞服务器）
+const observerQueueSize = 256
+
+// errObserverAfterInit 已发送 INIT 的控制连接请求成为观察者
+var errObserverAfterInit = errors.New("OBSERVE 必须在 INIT 之前发送")
+
+// observer 以观察者身份接收隧道事件的控制连接：通过与普通客户端相同的握手和身份检查后发送 OBSERVE，
+// 随即从客户端列表中移除，不分配端口、不参与路由和转发，只接收 EVENT 帧
+type observer struct {
+	id      string
+	conn    net.Conn
+	writeMu *controlWriter
+	events  chan *proto.Event
+}
+
+// observerSet 当前的观察者连接（maxObservers 为 0 时不接受观察者）
+type observerSet struct {
+	mu        sync.RWMutex
+	observers map[string]*observer
+
+	dropped atomic.Uint64 // 因观察者的事件队列已满而丢弃的事件数
+	dropLog rateLimitedLog
+}
+
+// publishEvent 向所有观察者推送事件，并导出到事件接收端（不阻塞调用方，某个观察者的队列已满时只丢弃它的这条事件）
+func (s *Server) publishEvent(ev *proto.Event) {
+	s.events.emitProto(ev)
+	set := &s.observers
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	for _, o := range set.observers {
+		select {
+		case o.events <- ev:
+		default:
+			set.dropped.Add(1)
+			set.dropLog.printf("观察者的事件队列已满，丢弃事件 (%s, clientID=%s): observer=%s", ev.Kind, ev.ClientID, o.id)
+		}
+	}
+}
+
+// observerLimitLocked 返回不能再接受观察者的原因（可以接受时为 nil），调用方需持有 observers.mu
+func (s *Server) observerLimitLocked() error {
+	if s.maxObservers <= 0 {
+		return errors.New("服务器未启用观察者连接")
+	}
+	if len(s.observers.observers) >= s.maxObservers {
+		return fmt.Errorf("观察者连接数已达上限 (%d)", s.maxObservers)
+	}
+	return nil
+}
+
+// addObserver 注册观察者，观察者数已达上限（或未启用观察者）时返回错误
+func (s *Server) addObserver(o *observer) error {
+	set := &s.observers
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if err := s.observerLimitLocked(); err != nil {
+		return err
+	}
+	if set.observers == nil {
+		set.observers = make(map[string]*observer)
+	}
+	set.observers[o.id] = o
+	return nil
+}
+
+// removeObserver 注销观察者
+func (s *Server) removeObserver(id string) {
+	s.observers.mu.Lock()
+	delete(s.observers.observers, id)
+	s.observers.mu.Unlock()
+}
+
+// closeObservers 服务器关闭时关闭所有观察者连接
+func (s *Server) closeObservers() {
+	s.observers.mu.RLock()
+	defer s.observers.mu.RUnlock()
+	for _, o := range s.observers.observers {
+		o.conn.Close()
+	}
+}
+
+// detachObserver 将控制连接从客户端列表中移除但不关闭它，客户端已注销时返回 false；
+// 移除前已路由到该连接的公开连接（例如身份由策略绑定了主机名）被关闭
+func (s *Server) detachObserver(clientID string) bool {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	clientInfo, ok := s.clients[clientID]
+	if !ok {
+		return false
+	}
+	s.dropClientConnsLocked(clientID, clientInfo, closeReasonClientGone)
+	if s.publicFairQueue != nil {
+		s.publicFairQueue.forget(clientID)
+	}
+	delete(s.clients, clientID)
+	return true
+}
+
+// serveObserver 处理 OBSERVE 帧：控制连接转为观察者，此后只推送 EVENT 帧，直到连接断开或收到 BYE；
+// 观察者发送的其他帧被忽略。返回后调用方关闭控制连接
+func (s *Server) serveObserver(ctx context.Context, clientID string, conn net.Conn, initSent bool) {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return
+	}
+	// 在移出客户端列表之前检查，被拒绝的连接随后照常注销
+	err := errObserverAfterInit
+	if !initSent {
+		s.observers.mu.RLock()
+		err = s.observerLimitLocked()
+		s.observers.mu.RUnlock()
+	}
+	if err == nil {
+		if !s.detachObserver(clientID) {
+			return
+		}
+		s.publishEvent(&proto.Event{Kind: proto.EventDisconnected, ClientID: clientID, Identity: clientInfo.Identity})
+	}
+	o := &observer{id: clientID, conn: conn, writeMu: &clientInfo.writeMu, events: make(chan *proto.Event, observerQueueSize)}
+	if err == nil {
+		err = s.addObserver(o)
+	}
+	if err != nil {
+		s.frameStats.inc(proto.FrameTypeOBSERVE, frameRejected)
+		log.Printf("拒绝观察者连接 (clientID=%s): %v", clientID, err)
+		frame := &proto.Frame{Type: proto.FrameTypeERROR, Payload: []byte(err.Error())}
+		if err := writeFrame(conn, &clientInfo.writeMu, frame, s.writeTimeout()); err != nil {
+			log.Printf("发送错误响应失败 (clientID=%s): %v", clientID, err)
+		}
+		return
+	}
+	defer s.removeObserver(clientID)
+	s.frameStats.inc(proto.FrameTypeOBSERVE, frameOK)
+	log.Printf("控制连接已转为观察者: clientID=%s, 身份=%q", clientID, clientInfo.Identity)
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.writeObserverEvents(o, done)
+
+	for {
+		frame, err := readFrame(conn, s.frameTracer)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				log.Printf("观察者连接读取错误 (clientID=%s): %v", clientID, err)
+			}
+			break
+		}
+		if frame.Type == proto.FrameTypeBYE {
+			s.frameStats.inc(frame.Type, frameOK)
+			break
+		}
+		s.frameStats.inc(frame.Type, frameIgnored)
+	}
+	log.Printf("观察者连接已关闭: clientID=%s", clientID)
+}
+
+// writeObserverEvents 按顺序把事件写入观察者连接，写入失败时关闭连接（读取循环随之结束）
+func (s *Server) writeObserverEvents(o *observer, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case ev := <-o.events:
+			frame := &proto.Frame{Type: proto.FrameTypeEVENT, Payload: proto.EncodeEvent(ev)}
+			if err := writeFrame(o.conn, o.writeMu, frame, s.writeTimeout()); err != nil {
+				log.Printf("向观察者发送事件失败 (clientID=%s): %v", o.id, err)
+				o.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// writeMetrics 以 Prometheus 文本格式写入观察者连接数和丢弃的事件数
+func (set *observerSet) writeMetrics(buf *bytes.Buffer) {
+	set.mu.RLock()
+	n := len(set.observers)
+	set.mu.RUnlock()
+	buf.WriteString("# HELP reverse_tunnel_observers Control connections registered as event observers.\n")
+	buf.WriteString("# TYPE reverse_tunnel_observers gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_observers %d\n", n)
+	buf.WriteString("# HELP reverse_tunnel_observer_events_dropped_total Tunnel events dropped because an observer's queue was full.\n")
+	buf.WriteString("# TYPE reverse_tunnel_observer_events_dropped_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_observer_events_dropped_total %d\n", set.dropped.Load())
+}
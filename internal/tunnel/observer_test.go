@@ -0,0 +1,110 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// observe 在新的控制连接上发送 OBSERVE
+func observe(t *testing.T, ctx context.Context, control *memListener) net.Conn {
+	t.Helper()
+	conn, err := control.DialContext(ctx, "mem", "control")
+	if err != nil {
+		t.Fatalf("连接控制监听器失败: %v", err)
+	}
+	if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeOBSERVE}, time.Second); err != nil {
+		t.Fatalf("发送 OBSERVE 失败: %v", err)
+	}
+	return conn
+}
+
+// TestObserver 测试观察者连接不参与路由，按顺序收到其他客户端的连接、就绪、错误和断开事件；
+// 超出观察者上限的连接收到 ERROR 并被断开
+func TestObserver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerMaxObservers(1))
+	go server.Run(ctx)
+
+	watcher := observe(t, ctx, control)
+	defer watcher.Close()
+	observers := func() int {
+		server.observers.mu.RLock()
+		defer server.observers.mu.RUnlock()
+		return len(server.observers.observers)
+	}
+	waitStat(t, "观察者连接数", observers, 1)
+	if n := len(server.ClientStatus()); n != 0 {
+		t.Fatalf("观察者不应出现在客户端列表中，得到 %d 个客户端", n)
+	}
+
+	extra := observe(t, ctx, control)
+	defer extra.Close()
+	extra.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if frame, err := proto.DecodeFrame(extra); err != nil || frame.Type != proto.FrameTypeERROR || !strings.Contains(string(frame.Payload), "上限") {
+		t.Fatalf("超出观察者上限时应回复 ERROR，得到 %+v, %v", frame, err)
+	}
+	waitStat(t, "已注册的客户端", func() int { return len(server.ClientStatus()) }, 0)
+
+	// 普通客户端：一次失败的 INIT、一次成功的 INIT，随后断开
+	conn, err := control.DialContext(ctx, "mem", "control")
+	if err != nil {
+		t.Fatalf("连接控制监听器失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, init := range []proto.InitConfig{
+		{LocalAddr: "127.0.0.1:80", Framing: proto.FramingDatagram},
+		{LocalAddr: "127.0.0.1:80", Hostnames: []string{"app.example.com"}},
+	} {
+		writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&init)}, time.Second)
+		if _, err := proto.DecodeFrame(conn); err != nil {
+			t.Fatalf("读取 INIT 响应失败: %v", err)
+		}
+	}
+	conn.Close()
+
+	// 先后收到被拒绝的观察者和普通客户端的事件，按 clientID 分组
+	var order []string
+	kinds := make(map[string][]string)
+	watcher.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for disconnected := 0; disconnected < 2; {
+		frame, err := proto.DecodeFrame(watcher)
+		if err != nil || frame.Type != proto.FrameTypeEVENT {
+			t.Fatalf("期望 EVENT 帧，得到 %+v, %v (已收到 %v)", frame, err, kinds)
+		}
+		ev, err := proto.DecodeEvent(frame.Payload)
+		if err != nil {
+			t.Fatalf("解码事件失败: %v", err)
+		}
+		if _, ok := kinds[ev.ClientID]; !ok {
+			order = append(order, ev.ClientID)
+		}
+		kinds[ev.ClientID] = append(kinds[ev.ClientID], ev.Kind)
+		switch ev.Kind {
+		case proto.EventDisconnected:
+			disconnected++
+		case proto.EventError:
+			if !strings.Contains(ev.Detail, "datagram") {
+				t.Errorf("error 事件应携带 INIT 失败的原因，得到 %q", ev.Detail)
+			}
+		}
+	}
+	if len(order) != 2 {
+		t.Fatalf("应收到两个客户端的事件，得到 %v", kinds)
+	}
+	for i, want := range [][]string{
+		{proto.EventConnected, proto.EventDisconnected},
+		{proto.EventConnected, proto.EventError, proto.EventReady, proto.EventDisconnected},
+	} {
+		if got := kinds[order[i]]; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s 的事件为 %v，期望 %v", order[i], got, want)
+		}
+	}
+}
@@ -0,0 +1,976 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"reverse-tunnel/internal/pqctls"
+	"reverse-tunnel/internal/proto"
+)
+
+// ServerOption 服务器可选配置项
+type ServerOption func(*Server)
+
+// WithServerAccessLog 设置公开连接访问日志输出（JSON Lines，每个连接结束时写入一行）
+// 访问日志与运行日志分离，便于单独轮转和采集。nil 表示不记录（默认）
+func WithServerAccessLog(w io.Writer) ServerOption {
+	return func(s *Server) {
+		s.accessLog = newAccessLogger(w)
+	}
+}
+
+// WithServerSecurityLog 设置安全日志输出（JSON Lines，每次拒绝控制连接握手或客户端身份时写入一行）
+// 用于 IDS/SIEM 采集；无论是否设置，拒绝次数都按原因计入指标。nil 表示不写日志（默认）
+func WithServerSecurityLog(w io.Writer) ServerOption {
+	return func(s *Server) {
+		s.securityLogWriter = w
+	}
+}
+
+// WithServerLogPeerCertChain 设置是否在客户端注册成功时记录其控制连接的完整证书链（验证后的链，叶证书在前）：
+// 每个证书的主题、颁发者、序列号、有效期和 SHA-256 指纹写入运行日志，并作为 handshake_accepted 记录写入安全日志，
+// 用于合规审计。每个连接写入多行日志，默认关闭
+func WithServerLogPeerCertChain(enabled bool) ServerOption {
+	return func(s *Server) {
+		s.logPeerCertChain = enabled
+	}
+}
+
+// WithServerSocketBuffers 设置控制端口和公开端口监听 socket 的接收/发送缓冲区大小（字节，0 表示系统默认）
+// 缓冲区在监听前设置，接受的控制连接和公开连接继承该设置（TLS/WebSocket 握手之前即生效），用于高带宽时延积链路。
+// 使用 WithServerTransport 显式设置传输时，控制端口的缓冲区由传输的 SocketBuffers 字段决定
+func WithServerSocketBuffers(read, write int) ServerOption {
+	return func(s *Server) {
+		s.socketBuffers.Read, s.socketBuffers.Write = read, write
+	}
+}
+
+// WithServerDSCP 设置控制端口和公开端口 socket 的 DSCP 标记（0-63，0 表示不标记），供配置了 QoS 策略的网络区分隧道流量。
+// 与缓冲区大小一样在监听前设置并由接受的连接继承；只在 Linux 和 macOS 上生效，其他平台忽略。
+// 使用 WithServerTransport 显式设置传输时，控制端口的标记由传输的 SocketBuffers 字段决定
+func WithServerDSCP(dscp int) ServerOption {
+	return func(s *Server) {
+		s.socketBuffers.DSCP = dscp
+	}
+}
+
+// WithServerName 设置服务器实例名称，作为 name 标签附加到所有指标，并写入访问日志和安全日志的每条记录
+// （运行日志通过标准库 log 输出，命令行程序以 log 前缀 name=<名称> 标记每一行）
+func WithServerName(name string) ServerOption {
+	return func(s *Server) {
+		s.name = name
+	}
+}
+
+// WithServerPublicErrorResponse 设置客户端连接本地服务失败（NEW_CONN 之后、任何数据之前收到 CLOSE_CONN）时
+// 向外部连接回复的错误：PublicErrorResponseHTTP 回复最小的 HTTP 502 响应（客户端的本地服务熔断中时回复 503），空字符串（默认）直接关闭连接
+func WithServerPublicErrorResponse(mode string) ServerOption {
+	return func(s *Server) {
+		s.publicErrorResponse = mode
+	}
+}
+
+// WithServerNoClientPolicy 设置全局公开端口没有可用客户端（没有客户端在线或没有匹配主机名的客户端）时的策略：
+// NoClientPolicyClose（默认）直接关闭连接；NoClientPolicyHold 最多等待 holdTimeout（0 表示默认 5 秒）让客户端（重新）连接，
+// 客户端短暂重连期间到达的请求不会失败；NoClientPolicyError 回复 HTTP 503 后关闭（只适用于 HTTP 服务）
+func WithServerNoClientPolicy(policy string, holdTimeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.noClientPolicy = policy
+		s.noClientHoldTimeout = holdTimeout
+	}
+}
+
+// WithServerNoClientMaxHolds 设置 NoClientPolicyHold 同时等待客户端的公开连接数上限（0 表示默认 1024），
+// 达到上限后新的没有可用客户端的连接直接关闭
+func WithServerNoClientMaxHolds(n int) ServerOption {
+	return func(s *Server) {
+		s.noClientMaxHolds = n
+	}
+}
+
+// WithServerPausePolicy 设置客户端被暂停（Server.PauseClient）期间路由到它的新公开连接的策略：
+// PausePolicyReject（默认）与没有可用客户端时一样关闭连接；PausePolicyHold 最多等待 holdTimeout（0 表示默认 30 秒）
+// 让客户端恢复，恢复后照常转发，适合后端短暂发布期间不丢弃请求
+func WithServerPausePolicy(policy string, holdTimeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.pausePolicy = policy
+		s.pauseHoldTimeout = holdTimeout
+	}
+}
+
+// WithServerPublicFallback 设置全局公开端口没有可用客户端时回复的静态 HTTP 页面（由 NewPublicFallback 创建，nil 表示不启用）
+// 启用后无论 noClientPolicy 如何，被关闭（包括 NoClientPolicyHold 等待超时）的 HTTP 连接都收到该页面，非 HTTP 连接直接关闭
+func WithServerPublicFallback(fb *PublicFallback) ServerOption {
+	return func(s *Server) {
+		s.publicFallback = fb
+	}
+}
+
+// WithServerMaxBytesPerConn 设置单个公开连接最多传输的字节数（两个方向合计，0 表示不限制）
+// 超出后服务器关闭公开连接，并以 CloseQuota 原因通知客户端关闭对应的本地连接
+func WithServerMaxBytesPerConn(n int64) ServerOption {
+	return func(s *Server) {
+		s.maxBytesPerConn = n
+	}
+}
+
+// WithServerConnSetupTimeout 设置发送 NEW_CONN 后等待客户端建立本地连接的最长时间（0 表示不限制，默认）
+// 协商了 conn_ack 特性的客户端以 NEW_CONN_ACK 确认，旧版本客户端以该连接的第一个 DATA 帧确认；
+// 超时未确认时服务器关闭公开连接，并以 CloseError 原因通知客户端放弃该 connID
+func WithServerConnSetupTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.connSetupTimeout = d
+	}
+}
+
+// WithServerMaxPendingNewConns 设置每个客户端建立中（已发送 NEW_CONN、尚未以 NEW_CONN_ACK 或首个 DATA 确认）的连接数上限（0 表示不限制，默认）
+// 达到上限时服务器不再向该客户端发送 NEW_CONN（反压）：专用公开端口暂停接受新连接，已接受的公开连接等待名额，
+// 直到有连接确认建立或超时关闭；等待超过建立超时（未设置时 10 秒）的公开连接被关闭。只约束协商了 conn_ack 的客户端
+func WithServerMaxPendingNewConns(n int) ServerOption {
+	return func(s *Server) {
+		s.maxPendingNewConns = n
+	}
+}
+
+// WithServerMaxObservers 设置观察者连接数上限（0 表示不接受观察者，默认）：
+// 控制连接通过与普通客户端相同的握手和身份检查后发送 OBSERVE 即转为观察者，不分配端口、不参与转发，
+// 只接收客户端连接、就绪、断开和错误的 EVENT 帧，供仪表盘实时获取隧道事件而不必轮询管理接口
+func WithServerMaxObservers(n int) ServerOption {
+	return func(s *Server) {
+		s.maxObservers = n
+	}
+}
+
+// WithServerInitBudget 限制控制连接在完成 INIT 之前（未经应用层握手的对端）能消耗的资源：
+// maxInitPayload 为 INIT 帧负载的上限（0 表示 4096 字节，只能调小）；maxBytes 为完成 INIT 之前读取的字节数上限
+// （所有帧的帧头和负载合计，0 表示不限制，默认），声明的负载超出剩余预算的帧在分配缓冲区之前被拒绝；
+// timeout 为建立控制连接到完成 INIT 的时限（0 表示不限制，默认）。超出任一限制时中止控制连接，
+// 以 init_budget 原因记录安全日志。转为观察者的控制连接在 OBSERVE 之后不再受限；
+// 不发送 INIT 的旧版本客户端会因 maxBytes 或 timeout 被断开，此时不要启用这两项
+func WithServerInitBudget(maxInitPayload, maxBytes int, timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.initMaxPayload = maxInitPayload
+		s.initBudgetBytes = maxBytes
+		s.initBudgetTimeout = timeout
+	}
+}
+
+// WithServerInitTimeout 设置控制连接建立后等待客户端首个 HELLO 或 INIT 的宽限期（0 表示不限制，默认）：
+// 宽限期内未发送任何有效帧的控制连接被断开；收到首个有效帧后不再受该超时限制。
+// 服务器要求协议特性时，等待 HELLO 的时间取 helloTimeout 与该宽限期中较长的
+func WithServerInitTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.initTimeout = d
+	}
+}
+
+// WithServerConnIdleTimeout 设置公开连接的空闲超时（0 表示不限制，默认）：两个方向都没有数据超过该时间时，
+// 服务器关闭公开连接，并以 CloseIdle 原因通知客户端关闭本地连接
+func WithServerConnIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.connIdleTimeout = d
+	}
+}
+
+// WithServerConnMaxLifetime 设置公开连接的最长存活时间（0 表示不限制，默认）：到期后无论是否有数据，
+// 服务器关闭公开连接，并以 CloseQuota 原因通知客户端关闭本地连接
+func WithServerConnMaxLifetime(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.connMaxLifetime = d
+	}
+}
+
+// WithServerTraceContext 设置是否为 HTTP/1.x 公开连接的第一个请求注入 W3C traceparent 请求头（默认不注入）
+// 请求已携带合法的 traceparent 时沿用其 trace-id，否则生成新的；连接的追踪 ID（两端日志的 trace= 和访问日志的 trace_id）
+// 即为该 trace-id，公开请求的追踪可以延续到后端。启用后每个公开连接在转发前最多等待 3 秒读取请求头，
+// 由服务端先发送数据的协议（SMTP、MySQL 等）会因此延迟，只应在 HTTP 隧道上启用
+func WithServerTraceContext(enable bool) ServerOption {
+	return func(s *Server) {
+		s.traceContext = enable
+	}
+}
+
+// WithServerLazyNewConn 设置是否在公开连接发送第一个字节后才发送 NEW_CONN（默认接受连接后立即发送）
+// 适用于公开端由客户端先发送数据的协议（如 HTTP）：只连接不发送数据就断开的连接（端口扫描、TCP 健康检查）
+// 不再让客户端建立本地连接。等待超过 timeout（0 表示 defaultLazyNewConnTimeout）仍没有数据时关闭连接。
+// 不能用于服务端先发送数据的协议（如 SSH、SMTP），这类连接会一直等待直到超时
+func WithServerLazyNewConn(enable bool, timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.lazyNewConn = enable
+		s.lazyNewConnTimeout = timeout
+	}
+}
+
+// WithServerHTTPCompression 设置是否按内容类型 gzip 压缩 HTTP/1.1 公开连接的响应（默认不压缩）
+// 只压缩请求接受 gzip、Content-Type 可压缩（文本、JSON、JavaScript、XML、SVG 等）且后端没有压缩的响应，
+// 图片、视频等已压缩的内容和非 HTTP 流量原样转发
+func WithServerHTTPCompression(enable bool) ServerOption {
+	return func(s *Server) {
+		s.httpCompression = enable
+	}
+}
+
+// WithServerRandomConnIDBase 设置每个控制连接的 connID 是否从随机起点开始（默认从 1 开始）
+// 启用后起点为 [0, 2^31) 内的随机值，同一控制连接上的 connID 仍然严格递增、从不复用
+func WithServerRandomConnIDBase(enable bool) ServerOption {
+	return func(s *Server) {
+		s.randomConnIDBase = enable
+	}
+}
+
+// WithServerHealthCheck 设置向客户端发送健康检查的间隔（0 表示不检查，默认）：
+// 协商了 health_check 特性的客户端收到 HEALTH_CHECK 后连接本地服务并回复结果，报告不可用或在下一次检查前未回复的客户端
+// 不参与全局公开端口的路由，直到报告恢复；不支持该特性的旧版本客户端始终视为健康
+func WithServerHealthCheck(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.healthCheckInterval = interval
+	}
+}
+
+// WithServerFrameTracer 设置控制连接的帧跟踪：每个客户端控制连接上读写的帧（类型、connID、负载长度、方向、时间，不含负载内容）
+// 交给 t 记录，用于排查帧错位或损坏。nil 表示不记录（默认），NewFrameTraceWriter 将帧写为文本行
+func WithServerFrameTracer(t FrameTracer) ServerOption {
+	return func(s *Server) {
+		s.frameTracer = t
+	}
+}
+
+// WithServerFrameMAC 设置控制连接帧完整性校验的共享密钥（仅用于未启用 TLS 的明文模式，nil 表示不启用）
+// 启用后客户端须使用相同的密钥完成 MAC 协商，之后每条记录附加 HMAC-SHA256，校验失败时断开控制连接
+func WithServerFrameMAC(secret []byte) ServerOption {
+	return func(s *Server) {
+		s.frameMACKey = secret
+	}
+}
+
+// WithServerRequiredFeatures 设置客户端必须支持的协议特性：客户端须先发送 HELLO 完成特性协商，
+// 协商结果缺少其中任一特性、在 HELLO 之前发送其他帧或 helloTimeout 内未发送 HELLO 时断开控制连接。
+// 0 表示不要求（默认），不进行特性协商的旧版本客户端照常使用，只是不启用任何可选特性
+func WithServerRequiredFeatures(features proto.Features) ServerOption {
+	return func(s *Server) {
+		s.requiredFeatures = features
+	}
+}
+
+// WithServerMaxDataPayload 设置服务器能接收的 DATA 帧负载上限（字节，0 表示默认 dataChunkSize，不能超过 proto.MaxDataPayloadSize）
+// 在 HELLO 中与客户端声明的上限协商，使用较小值：双方按协商结果分块发送，超过协商上限的 DATA 帧视为协议错误。
+// 客户端未声明上限（旧版本）时不协商，见 maxdata.go
+func WithServerMaxDataPayload(n int) ServerOption {
+	return func(s *Server) {
+		s.maxDataPayload = n
+	}
+}
+
+// WithServerRequirePublicEndpoint 设置服务器未配置全局公开端口时是否拒绝没有公开入口的客户端：
+// 控制连接建立后 publicEndpointGrace 内未请求远程端口的客户端收到 ERROR 并被断开，取消远程端口的 INIT 收到 ERROR。
+// 默认（false）只记录警告。配置了全局公开端口时不生效
+func WithServerRequirePublicEndpoint(require bool) ServerOption {
+	return func(s *Server) {
+		s.requirePublicEndpoint = require
+	}
+}
+
+// WithServerPublicEndpointGrace 设置未配置全局公开端口时，控制连接建立后等待客户端请求远程端口的时间（0 表示默认 10 秒），
+// 超过后仍没有公开入口的客户端记录警告（启用 WithServerRequirePublicEndpoint 时断开）
+func WithServerPublicEndpointGrace(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.publicEndpointGrace = d
+	}
+}
+
+// WithServerClientPortBindAddr 设置客户端指定的远程端口绑定的 IP 地址（IPv6 地址可以带方括号）
+// 服务器有公网和管理网等多个接口时，只在指定接口上开放客户端的隧道端口。留空表示绑定所有接口（默认）。不影响全局公开端口
+func WithServerClientPortBindAddr(addr string) ServerOption {
+	return func(s *Server) {
+		s.clientPortBindAddr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	}
+}
+
+// WithServerPortPool 设置租给客户端的固定远程端口池（ports 为空表示不启用）
+// 请求远程端口 0 的客户端按先到先得从池中租用一个空闲端口（在 ASSIGNED 中返回），池中没有空闲端口时回复 ERROR；
+// 显式请求池内端口的客户端租用该端口。端口在客户端断开、更换或取消端口时归还。只在未设置全局公开端口时生效
+func WithServerPortPool(ports []int) ServerOption {
+	return func(s *Server) {
+		s.portPool = newPortPool(ports)
+	}
+}
+
+// WithServerNetwork 设置监听使用的网络类型：tcp（默认，通配地址在支持的平台上为双栈）、tcp4 或 tcp6
+// 对控制端口、全局公开端口和客户端指定的公开端口均生效
+func WithServerNetwork(network string) ServerOption {
+	return func(s *Server) {
+		s.network = network
+	}
+}
+
+// WithServerMaxControlConnLifetime 设置控制连接最大存活时间
+// 到期后服务器发送空 REDIRECT 帧要求客户端重建控制连接，30 秒后仍未断开则强制关闭。0 表示不限制（默认）
+func WithServerMaxControlConnLifetime(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.maxControlConnLifetime = d
+	}
+}
+
+// WithServerMetricsListen 设置指标/状态 HTTP 监听地址（提供 /metrics 和 /status）
+// 空字符串表示不启用（默认）
+func WithServerMetricsListen(addr string) ServerOption {
+	return func(s *Server) {
+		s.metricsListenAddr = addr
+	}
+}
+
+// WithServerFramePayloadHistogram 设置是否在 /metrics 中输出 DATA 帧负载大小直方图（按发送/接收方向，默认不输出），
+// 用于判断 DATA 帧的分块大小是否适合实际流量。每个 DATA 帧只增加一次桶查找和两次原子加法
+func WithServerFramePayloadHistogram(enable bool) ServerOption {
+	return func(s *Server) {
+		s.payloadSizes = newPayloadHistogram(enable)
+	}
+}
+
+// WithServerStrictAuxListeners 设置辅助监听器（指标/状态）绑定失败时是否使 Run 返回错误
+// 默认 false：记录警告并继续提供隧道服务
+func WithServerStrictAuxListeners(strict bool) ServerOption {
+	return func(s *Server) {
+		s.strictAuxListeners = strict
+	}
+}
+
+// WithServerPprof 设置是否在指标/状态监听器上挂载 /debug/pprof/
+// 需要同时通过 WithServerAdminToken 设置管理令牌，否则不会挂载
+func WithServerPprof(enabled bool) ServerOption {
+	return func(s *Server) {
+		s.enablePprof = enabled
+	}
+}
+
+// WithServerStatusUI 设置是否在指标/状态监听器上挂载内置 HTML 状态页 /ui/
+// 需要同时通过 WithServerAdminToken 设置管理令牌，否则不会挂载
+func WithServerStatusUI(enabled bool) ServerOption {
+	return func(s *Server) {
+		s.enableStatusUI = enabled
+	}
+}
+
+// WithServerAdminToken 设置访问管理接口（如 pprof）所需的令牌（Authorization: Bearer <token>）
+func WithServerAdminToken(token string) ServerOption {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// WithServerDuplicateIdentityPolicy 设置相同身份（证书 CN）的客户端重复连接时的策略：
+// DuplicateIdentityAllow（默认）允许同时在线；DuplicateIdentityRejectNew 拒绝新客户端，回复 ERROR 并记录安全日志；
+// DuplicateIdentityReplaceOld 断开已在线的客户端，由新客户端取代（适合客户端重启后旧控制连接尚未超时的场景）。
+// 没有身份的客户端（未启用 mTLS）不受影响
+func WithServerDuplicateIdentityPolicy(policy string) ServerOption {
+	return func(s *Server) {
+		s.duplicateIdentityPolicy = policy
+	}
+}
+
+// WithServerGlobalRouting 设置全局公开端口的路由策略：GlobalRoutingHostname 只按主机名路由（不使用未声明主机名的客户端），
+// GlobalRoutingRoundRobin 和 GlobalRoutingWeighted 按主机名路由并在匹配同一路由键的多个客户端之间轮流或按权重分配。
+// 未设置（默认）时同一个连接只能匹配一个客户端：多个客户端匹配（例如两个都未声明主机名）时拒绝该连接并记录警告，
+// 而不是把连接交给其中任意一个。每个客户端独占的公开端口不受影响
+func WithServerGlobalRouting(policy string) ServerOption {
+	return func(s *Server) {
+		s.globalRouting = policy
+	}
+}
+
+// WithServerPublicClientQueue 为全局公开端口启用按客户端划分的公平队列，每个客户端最多排队 size 个连接（0 表示不启用）
+// 启用后连接路由到客户端后先进入该客户端的队列，worker 轮流处理各客户端的连接，一个客户端的突发连接不会让其他客户端等待；
+// 某个客户端的队列已满时关闭其新连接。每个客户端独占的公开端口不受影响
+func WithServerPublicClientQueue(size int) ServerOption {
+	return func(s *Server) {
+		s.publicClientQueueSize = size
+	}
+}
+
+// WithServerPublicSourceLimit 按来源 IP 限制公开连接（全局公开端口和每个客户端独占的公开端口）：
+// 每个来源同时打开的连接数不超过 maxConns，新建连接的速率不超过每秒 rate 个（漏桶，允许 burst 个突发连接，0 表示等于 rate），
+// 超出时在接受后立即关闭连接，不进入公开连接队列。maxConns 或 rate 为 0 表示不限制该项
+func WithServerPublicSourceLimit(maxConns, rate, burst int) ServerOption {
+	return func(s *Server) {
+		s.publicSourceMaxConns = maxConns
+		s.publicSourceRate = rate
+		s.publicSourceBurst = burst
+	}
+}
+
+// WithServerClientShareLimit 限制每个客户端在全局公开端口上的份额：路由到同一客户端的连接同时打开的不超过 maxConns 个，
+// 新建连接的速率不超过每秒 rate 个（允许 rate 个突发连接）。同一路由键的多个客户端中优先选择仍有剩余份额的，
+// 全部用完时关闭连接。maxConns 或 rate 为 0 表示不限制该项；每个客户端独占的公开端口不受影响
+func WithServerClientShareLimit(maxConns, rate int) ServerOption {
+	return func(s *Server) {
+		s.clientShareMaxConns = maxConns
+		s.clientShareRate = rate
+	}
+}
+
+// WithServerMaxForwarders 设置转发公开连接数据的 goroutine 数上限（每个公开连接一个，0 表示不限制）
+// 达到上限时新的公开连接在交给客户端之前被关闭（不发送 NEW_CONN），在线连接结束后恢复接受，
+// 防止大量公开连接使 goroutine 无限增长。当前数量见 /metrics 的 reverse_tunnel_active_forwarders
+func WithServerMaxForwarders(n int) ServerOption {
+	return func(s *Server) {
+		s.maxForwarders = n
+	}
+}
+
+// WithServerPublicQueue 设置公开连接队列容量、队列满时的策略（QueuePolicyBlock / QueuePolicyReject）和 worker 数量
+// size 或 workers 为 0 时使用默认值（100 / 8），policy 为空时使用 QueuePolicyBlock
+func WithServerPublicQueue(size int, policy string, workers int) ServerOption {
+	return func(s *Server) {
+		s.publicQueueSize = size
+		s.publicQueuePolicy = policy
+		s.publicWorkers = workers
+	}
+}
+
+// WithServerPolicy 设置按客户端身份（证书 CN）的配额策略
+// 注册时查找身份的配额（策略不允许的身份被拒绝），请求端口和建立公开连接时按配额检查，超出时回复 ERROR 或关闭连接。nil 表示不限制（默认）
+func WithServerPolicy(policy *PolicyStore) ServerOption {
+	return func(s *Server) {
+		s.policy = policy
+	}
+}
+
+// WithServerPublicTLS 在全局公开端口上终止 TLS（cfg 通常由 NewPublicTLSConfig 创建），按握手的 SNI 路由到对应主机名的客户端
+// 配合策略文件的 hostnames 和通配符证书，可为每个客户端身份提供稳定的子域名；客户端收到的是解密后的数据
+func WithServerPublicTLS(cfg *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.publicTLS = cfg
+	}
+}
+
+// WithServerPublicCertStore 在全局公开端口上终止 TLS，按握手的 SNI 从 store 中选择每个主机名的证书（多租户各自的证书），
+// 并按 SNI 路由到对应主机名的客户端；证书可在运行时通过 Server.ReloadPublicCerts 重新加载
+func WithServerPublicCertStore(store *PublicCertStore) ServerOption {
+	return func(s *Server) {
+		s.publicCerts = store
+		s.publicTLS = store.TLSConfig()
+	}
+}
+
+// WithServerPolicyReload 设置重新加载配额策略使用的文件
+// 服务器定期检查文件的修改时间，变化时重新加载（也可调用 Server.ReloadPolicy，例如在收到 SIGHUP 时）。
+// 新策略只影响之后注册的客户端；revoke 为 true 时同时断开身份已不被允许的在线客户端
+func WithServerPolicyReload(path string, revoke bool) ServerOption {
+	return func(s *Server) {
+		s.policyFile = path
+		s.policyRevoke = revoke
+	}
+}
+
+// WithServerDecodeErrorLimit 设置反复发送无法解码的控制帧（截断、超长负载、帧完整性校验失败）的客户端的重连限制：
+// 同一来源（客户端身份，没有身份时为 IP）在 backoff 内累计 limit 次解码错误后，其控制连接在 backoff 内被拒绝。
+// limit 为 0 表示不限制（默认，解码错误仍计入 /metrics），backoff 为 0 表示 defaultDecodeErrorBackoff
+func WithServerDecodeErrorLimit(limit int, backoff time.Duration) ServerOption {
+	return func(s *Server) {
+		s.decodeErrors.limit = limit
+		s.decodeErrors.backoff = backoff
+	}
+}
+
+// WithServerMaxFrameRate 设置每个控制连接每秒最多处理的帧数及超出时的策略
+// FrameRatePolicyThrottle（默认）延迟处理超出的帧，FrameRatePolicyDrop 直接断开控制连接。rate 为 0 表示不限制（默认）
+func WithServerMaxFrameRate(rate int, policy string) ServerOption {
+	return func(s *Server) {
+		s.maxFrameRate = rate
+		s.frameRatePolicy = policy
+	}
+}
+
+// WithServerPortNotify 设置隧道就绪/断开时的端口分配通知，用于外部服务发现（DNS、反向代理等）
+// file 非空时以 JSON 数组写入当前所有分配（client_id、identity、port、addr）；webhook 非空时 POST assigned/released 事件。
+// 失败只记录日志，不影响隧道。都为空表示不通知（默认）
+func WithServerPortNotify(file, webhook string) ServerOption {
+	return func(s *Server) {
+		s.portNotifyFile = file
+		s.portNotifyWebhook = webhook
+	}
+}
+
+// WithServerMetering 设置按客户端身份累计用量（GET /metering）的计量记录，用于按用量计费
+// file 非空时启动时从该文件恢复累计值（进程重启后继续累计），并每隔 interval 以 JSON 写入快照（身份、bytes_in、bytes_out、sessions）；
+// webhook 非空时每隔 interval POST 同样的快照。interval 为 0 时使用默认的 1 分钟。失败只记录日志，不影响隧道
+func WithServerMetering(file, webhook string, interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.meteringFile = file
+		s.meteringWebhook = webhook
+		s.meteringInterval = interval
+	}
+}
+
+// WithServerEventSink 把隧道生命周期事件（客户端连接/就绪/注销/被拒绝，公开连接的建立和结束）导出到 sink，
+// 例如 NewWebhookEventSink。事件在内存队列中攒批后在单独的 goroutine 中发布；队列已满或发布失败时丢弃事件并计数，
+// 不阻塞控制连接和转发。nil 表示不导出（默认）
+func WithServerEventSink(sink EventSink) ServerOption {
+	return func(s *Server) {
+		s.eventSink = sink
+	}
+}
+
+// WithServerOTLPMetrics 把与 /metrics 相同的指标周期性地以 OTLP/HTTP（JSON 编码）推送到 OpenTelemetry collector，
+// 可以与 WithServerMetricsListen 同时使用。cfg.Endpoint 为空表示不推送（默认），推送失败只计数并记录日志
+func WithServerOTLPMetrics(cfg OTLPConfig) ServerOption {
+	return func(s *Server) {
+		s.otlpConfig = cfg
+	}
+}
+
+// WithServerPortCallback 设置隧道就绪/断开时的进程内回调（嵌入服务器的程序用于服务发现），事件与 webhook 相同
+// 回调在单独的 goroutine 中按事件顺序调用，不持有服务器的锁；回调阻塞时后续事件排队，队列满时丢弃并记录日志
+func WithServerPortCallback(fn func(PortEvent)) ServerOption {
+	return func(s *Server) {
+		s.portCallback = fn
+	}
+}
+
+// WithServerTLSMinSecurityLevel 要求 PQC mTLS 握手达到指定的 NIST 安全级别（1-5，仅 PQC mTLS 生效）
+// 密钥交换组和签名算法列表按级别生成（例如 3 级只允许 ML-KEM-768/1024 和 ML-DSA-65/87），
+// 握手后协商的组或客户端证书低于该级别时拒绝连接。0 表示接受全部参数集（默认）
+func WithServerTLSMinSecurityLevel(level int) ServerOption {
+	return func(s *Server) {
+		s.tlsMinSecurityLevel = level
+	}
+}
+
+// WithServerMaxHandshakes 设置同时进行的 PQC mTLS 握手数上限（仅 PQC mTLS 生效）：
+// 握手在后台并发进行，达到上限时 HandshakeLimitQueue（默认）暂停接受新连接直到有握手完成，HandshakeLimitReject 直接关闭新连接。
+// 握手发生在认证之前且消耗大量 CPU，上限用于防止大量恶意连接耗尽 CPU；握手完成的连接不占用名额。
+// 0 表示不并发握手，逐个完成（默认）
+func WithServerMaxHandshakes(n int, policy string) ServerOption {
+	return func(s *Server) {
+		s.maxHandshakes = n
+		s.handshakeLimitPolicy = policy
+	}
+}
+
+// WithServerTLSSessionResumption 设置是否允许客户端恢复 TLS 会话（仅 PQC mTLS 生效）
+// 启用后服务器发送 TLS 1.3 会话票据，频繁重连的客户端可跳过证书认证，降低 PQC 握手开销；
+// 代价是票据有效期内恢复的会话不会重新校验客户端证书。默认禁用
+func WithServerTLSSessionResumption(enabled bool) ServerOption {
+	return func(s *Server) {
+		s.tlsSessionResumption = enabled
+	}
+}
+
+// WithServerTLSRequireClientCert 设置是否要求客户端证书（仅 PQC mTLS 生效，默认要求）
+// 不要求时为单向 TLS：客户端仍验证服务器证书，客户端提供的证书仍会校验，没有证书的客户端身份为空。
+// 服务器没有其他客户端认证手段，此时必须配置拒绝空身份的配额策略（WithServerPolicy），否则 Run 返回错误；
+// 没有证书的客户端只能声明策略路由规则明确允许的主机名
+func WithServerTLSRequireClientCert(require bool) ServerOption {
+	return func(s *Server) {
+		s.tlsClientCertOptional = !require
+	}
+}
+
+// WithServerTransport 设置控制连接的传输（例如 WebSocketTransport）
+// nil 表示按是否启用 TLS 选择 PQC mTLS 或纯 TCP（默认）
+func WithServerTransport(t Transport) ServerOption {
+	return func(s *Server) {
+		s.transport = t
+	}
+}
+
+// WithServerControlListener 使用外部提供的控制连接监听器替代按地址监听（例如测试中的内存管道）
+// 监听器原样使用，不做 TLS 封装；Run 结束时关闭
+func WithServerControlListener(l net.Listener) ServerOption {
+	return func(s *Server) {
+		s.injectedControlListener = l
+	}
+}
+
+// WithServerPublicListener 使用外部提供的全局公开端口监听器替代按地址监听
+// 服务器进入全局监听器模式（等同于指定了公开端口地址）；Run 结束时关闭
+func WithServerPublicListener(l net.Listener) ServerOption {
+	return func(s *Server) {
+		s.injectedPublicListener = l
+		s.publicListenAddr = l.Addr().String()
+	}
+}
+
+// ClientOption 客户端可选配置项
+type ClientOption func(*Client)
+
+// WithServerControlWriteTimeout 设置服务器向控制连接写入单个帧的超时时间
+// 写入超时的客户端视为已卡死，其控制连接会被关闭并注销。0 表示不设超时（默认）
+func WithServerControlWriteTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.controlWriteTimeout = d
+	}
+}
+
+// WithServerShutdownTimeout 设置关闭时清理资源（注销客户端、关闭连接）的最长时间
+// 超时后放弃剩余的关闭操作，Run 直接返回。0 表示默认 10 秒
+func WithServerShutdownTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.shutdownTimeout = d
+	}
+}
+
+// WithExitOnFatal 设置遇到致命错误时 Run 是否直接返回该错误而不是重连（默认关闭，始终重连）
+// 致命错误是重连也会以同样原因失败的错误（见 IsFatal）：服务器拒绝了客户端、协议不兼容、证书缺失或无效、
+// 证书未通过验证、未协商 PQC 算法、服务器证书指纹不符。由 systemd、Kubernetes 等监管进程运行时启用，
+// 进程以非零状态退出后由监管方重启或告警，而不是无限重试注定失败的连接
+func WithExitOnFatal(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.exitOnFatal = enabled
+	}
+}
+
+// WithLocalReadyTimeout 设置连接服务器前等待本地服务就绪的超时时间
+// 大于 0 时，客户端会先探测本地服务，确认可连接后才连接服务器并发布隧道；
+// 超时未就绪则记录日志并在稍后重试。0 表示不做预检（默认）
+func WithLocalReadyTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.localReadyTimeout = d
+	}
+}
+
+// WithLocalTCPFastOpen 设置拨号本地服务时是否启用 TCP Fast Open
+// 仅 Linux 生效，其他平台或内核不支持时自动退化为普通连接
+func WithLocalTCPFastOpen(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.localTCPFastOpen = enabled
+	}
+}
+
+// WithLocalTLS 设置连接本地服务时使用 TLS（本地服务为 HTTPS/mTLS 时使用）
+// nil 表示使用纯 TCP（默认）
+func WithLocalTLS(cfg *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.localTLS = cfg
+	}
+}
+
+// WithHostname 设置主机名路由键（支持 *.example.com 通配符）
+// 服务器使用全局公开端口时，按公开连接的 TLS SNI 或 HTTP Host 将匹配的连接路由到该客户端
+func WithHostname(hostname string) ClientOption {
+	return WithHostnames(hostname)
+}
+
+// WithHostnames 设置多个主机名路由键，匹配其中任意一个的公开连接都路由到该客户端
+// 启用 mTLS 时服务器只接受客户端证书 SAN 覆盖的主机名
+func WithHostnames(hostnames ...string) ClientOption {
+	return func(c *Client) {
+		c.hostnames = hostnames
+	}
+}
+
+// WithWeight 设置负载均衡权重（1-MaxClientWeight，0 表示默认权重 1）
+// 多个客户端注册了同一主机名（或都未注册主机名）时，服务器按权重比例把全局公开端口上的新连接分配给它们
+func WithWeight(weight int) ClientOption {
+	return func(c *Client) {
+		c.weight = weight
+	}
+}
+
+// WithLeaseRemotePort 设置远程端口为 0 时是否从服务器的端口池租用一个远程端口（服务器需启用 WithServerPortPool）
+// 租用的端口在 ASSIGNED 中返回，每次重连重新租用，不保证与上次相同
+func WithLeaseRemotePort(lease bool) ClientOption {
+	return func(c *Client) {
+		c.leaseRemotePort = lease
+	}
+}
+
+// WithLocalRoutes 设置按公开连接来源 IP 选择本地服务的路由规则
+// 规则按顺序匹配，第一个包含来源 IP 的规则生效；未命中或来源未知时使用默认本地地址
+func WithLocalRoutes(routes []LocalRoute) ClientOption {
+	return func(c *Client) {
+		c.localRoutes = routes
+	}
+}
+
+// WithHostRoutes 设置按公开连接的主机名（TLS SNI 或 HTTP Host）选择本地服务的路由规则，规则的主机名同时注册为主机名路由键
+// 服务器未启用公开端口 TLS 时按 ClientHello 的 SNI 路由且不终止 TLS，多个主机名可以分别透传到各自持有证书的本地服务；
+// 规则优先于来源路由，都未命中时使用默认本地地址
+func WithHostRoutes(routes []HostRoute) ClientOption {
+	return func(c *Client) {
+		c.hostRoutes = routes
+	}
+}
+
+// WithLocalResolver 设置 NEW_CONN 时解析本地地址的解析器，用于后端地址在配置时未知的环境（Docker/K8s 服务发现）
+// 解析器收到连接的服务名（路由主机名）和按静态配置选择的地址，返回实际连接的地址；nil 表示直接使用静态地址（默认）。
+// 解析器返回的地址与默认本地地址不同时不使用本地连接池和多后端负载均衡
+func WithLocalResolver(r LocalResolver) ClientOption {
+	return func(c *Client) {
+		c.localResolver = r
+	}
+}
+
+// WithLocalDialSource 设置拨号本地服务使用的源 IP（多网卡主机上配合策略路由或防火墙规则使用）
+// ip 为 nil 时由系统选择（默认）
+func WithLocalDialSource(ip net.IP) ClientOption {
+	return func(c *Client) {
+		if ip != nil {
+			c.localDialSource = &net.TCPAddr{IP: ip}
+		}
+	}
+}
+
+// WithLocalPool 启用本地连接池：保持 size 个预热的本地连接，NEW_CONN 优先使用池中连接
+// reuse 为 true 时收到 CLOSE_CONN 后本地连接经校验放回池中复用，只适用于连接状态可跨请求复用的无状态协议；
+// 有状态协议应保持 reuse 为 false（仍可使用预热连接）。size 为 0 表示不启用（默认），local 包含多个后端时不生效
+func WithLocalPool(size int, reuse bool) ClientOption {
+	return func(c *Client) {
+		c.localPoolSize = size
+		c.localPoolReuse = reuse
+	}
+}
+
+// WithLocalMultiplex 启用本地多路复用：客户端与本地服务保持一条持久连接，每个公开连接作为其上的一个流，
+// 流的数据以隧道帧格式收发（NEW_CONN 打开、DATA 传输、CLOSE_CONN 关闭，完整约定见 localMux），
+// 用于建立连接开销大、连接频繁且自身支持按该约定多路复用的后端。只作用于 local 地址（主机名路由和来源路由命中的其他地址仍逐个拨号），
+// local 包含多个后端时不生效，启用时不使用本地连接池。默认关闭：普通 TCP 服务不理解该帧格式
+func WithLocalMultiplex(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.localMultiplex = enabled
+	}
+}
+
+// WithLocalBalance 设置本地地址包含多个以逗号分隔的后端时的负载均衡策略（BalanceRoundRobin / BalanceRandom）
+// unhealthyFor > 0 时启用被动健康检查：拨号失败的后端在 unhealthyFor 内被跳过，并改用其他后端重试；0 表示不启用（默认）
+func WithLocalBalance(strategy string, unhealthyFor time.Duration) ClientOption {
+	return func(c *Client) {
+		c.localBalance = strategy
+		c.localUnhealthyFor = unhealthyFor
+	}
+}
+
+// WithCircuitBreaker 设置本地服务熔断：同一本地服务地址连续 failures 次拨号失败后，cooldown 内的新连接不再拨号，
+// 直接以 CloseUnavailable 关闭（服务器启用 PublicErrorResponseHTTP 时回复 HTTP 503）；冷却结束后放行一次试探拨号，
+// 成功则恢复，失败则继续熔断。failures 为 0 表示不启用（默认），cooldown 为 0 表示默认 30 秒
+func WithCircuitBreaker(failures int, cooldown time.Duration) ClientOption {
+	return func(c *Client) {
+		c.breakerFailures = failures
+		c.breakerCooldown = cooldown
+	}
+}
+
+// WithLocalDialWorkers 限制同时拨号本地服务的连接数：NEW_CONN 交给最多 workers 个并发的拨号，超出的排队等待，
+// 排队超过 queue 个时新连接直接以 CloseUnavailable 关闭（queue 为 0 表示默认 1024）。流量突增时保护本地服务不被大量并发连接请求冲击，
+// 帧读取循环也不再被拨号阻塞。workers 为 0 表示不启用（默认，在帧读取循环中逐个同步拨号）
+func WithLocalDialWorkers(workers, queue int) ClientOption {
+	return func(c *Client) {
+		c.dialWorkers = workers
+		c.dialQueue = queue
+	}
+}
+
+// WithNetwork 设置连接服务器使用的网络类型：tcp（默认）、tcp4 或 tcp6
+func WithNetwork(network string) ClientOption {
+	return func(c *Client) {
+		c.network = network
+	}
+}
+
+// WithMaxControlConnLifetime 设置控制连接最大存活时间
+// 到期后客户端等待活跃连接结束（最多 30 秒）再重建控制连接，以定期重新握手并使用轮换后的证书。0 表示不限制（默认）
+func WithMaxControlConnLifetime(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxControlConnLifetime = d
+	}
+}
+
+// WithName 设置客户端实例名称，作为 name 标签附加到所有指标
+// （运行日志通过标准库 log 输出，命令行程序以 log 前缀 name=<名称> 标记每一行）
+func WithName(name string) ClientOption {
+	return func(c *Client) {
+		c.name = name
+	}
+}
+
+// WithSocketBuffers 设置控制连接和本地连接 socket 的接收/发送缓冲区大小（字节，0 表示系统默认）
+// 缓冲区在连接建立前设置（TLS/WebSocket 握手之前即生效），用于高带宽时延积链路。
+// 使用 WithTransport 显式设置传输时，控制连接的缓冲区由传输的 SocketBuffers 字段决定
+func WithSocketBuffers(read, write int) ClientOption {
+	return func(c *Client) {
+		c.socketBuffers.Read, c.socketBuffers.Write = read, write
+	}
+}
+
+// WithDSCP 设置控制连接和本地连接 socket 的 DSCP 标记（0-63，0 表示不标记），供配置了 QoS 策略的网络区分隧道流量。
+// 在连接建立前设置；只在 Linux 和 macOS 上生效，其他平台忽略。
+// 使用 WithTransport 显式设置传输时，控制连接的标记由传输的 SocketBuffers 字段决定
+func WithDSCP(dscp int) ClientOption {
+	return func(c *Client) {
+		c.socketBuffers.DSCP = dscp
+	}
+}
+
+// WithDataKeepalive 设置数据连接保活间隔：连接空闲（没有收发数据）超过该时间后，每个间隔发送一个零长度 DATA 帧，
+// 接收方丢弃该帧，用于防止中间设备回收长时间安静的会话（如空闲的 SSH 会话）经过的流。0 表示不启用（默认）
+func WithDataKeepalive(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.dataKeepalive = interval
+	}
+}
+
+// WithConnIdleTimeout 设置本地连接的空闲超时（0 表示不限制，默认）：两个方向都没有数据超过该时间时，
+// 客户端关闭本地连接，并以 CloseIdle 原因通知服务器关闭公开连接（数据连接保活帧不推迟空闲超时）
+func WithConnIdleTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.connIdleTimeout = d
+	}
+}
+
+// WithConnMaxLifetime 设置本地连接的最长存活时间（0 表示不限制，默认）：到期后无论是否有数据，
+// 客户端关闭本地连接，并以 CloseQuota 原因通知服务器关闭公开连接
+func WithConnMaxLifetime(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.connMaxLifetime = d
+	}
+}
+
+// WithFrameBuffer 设置已从控制连接读取、等待处理的帧数上限（0 表示默认 10）
+// 达到上限时停止读取控制连接，通过 TCP 流量控制对服务器施加反压；较大的值可以吸收处理速度的短暂波动，但占用更多内存
+func WithFrameBuffer(n int) ClientOption {
+	return func(c *Client) {
+		c.frameBuffer = n
+	}
+}
+
+// WithRequiredFeatures 设置服务器必须支持的协议特性：连接后等待服务器的 HELLO 响应，
+// 协商结果缺少其中任一特性或服务器未响应（不支持特性协商的旧版本）时断开并重连。0 表示不要求（默认）
+func WithRequiredFeatures(features proto.Features) ClientOption {
+	return func(c *Client) {
+		c.requiredFeatures = features
+	}
+}
+
+// WithMaxDataPayload 设置客户端能接收的 DATA 帧负载上限（字节，0 表示默认 dataChunkSize，不能超过 proto.MaxDataPayloadSize）
+// 每次连接时在 HELLO 中声明，与服务器的上限协商后使用较小值，内存受限的客户端可以借此要求服务器发送更小的分块
+func WithMaxDataPayload(n int) ClientOption {
+	return func(c *Client) {
+		c.maxDataPayload = n
+	}
+}
+
+// WithPprofListen 设置客户端 pprof 调试监听地址（建议仅监听本机）
+// 需要同时通过 WithAdminToken 设置管理令牌，否则不会启动。空字符串表示不启用（默认）
+func WithPprofListen(addr string) ClientOption {
+	return func(c *Client) {
+		c.pprofListenAddr = addr
+	}
+}
+
+// WithOTLPMetrics 把与 pprof 监听器 /metrics 相同的指标周期性地以 OTLP/HTTP（JSON 编码）推送到 OpenTelemetry collector
+// （不需要启用 pprof 监听器）。cfg.Endpoint 为空表示不推送（默认）
+func WithOTLPMetrics(cfg OTLPConfig) ClientOption {
+	return func(c *Client) {
+		c.otlpConfig = cfg
+	}
+}
+
+// WithFramePayloadHistogram 设置是否在 pprof 监听器的 /metrics 中输出 DATA 帧负载大小直方图（按发送/接收方向，默认不输出）
+func WithFramePayloadHistogram(enable bool) ClientOption {
+	return func(c *Client) {
+		c.payloadSizes = newPayloadHistogram(enable)
+	}
+}
+
+// WithAdminToken 设置访问客户端调试接口所需的令牌（Authorization: Bearer <token>）
+func WithAdminToken(token string) ClientOption {
+	return func(c *Client) {
+		c.adminToken = token
+	}
+}
+
+// WithControlWriteTimeout 设置客户端向控制连接写入单个帧的超时时间
+// 写入超时后控制连接会被关闭并触发重连。0 表示不设超时（默认）
+func WithControlWriteTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.controlWriteTimeout = d
+	}
+}
+
+// WithTLSMinSecurityLevel 要求 PQC mTLS 握手达到指定的 NIST 安全级别（见 WithServerTLSMinSecurityLevel）
+// 协商的组或服务器证书低于该级别时拒绝连接
+func WithTLSMinSecurityLevel(level int) ClientOption {
+	return func(c *Client) {
+		c.tlsMinSecurityLevel = level
+	}
+}
+
+// WithTLSServerFingerprint 固定服务器证书的 SHA-256 指纹（见 pqctls.ParseFingerprint），握手后证书指纹不匹配时拒绝连接
+// CA 文件为空时只校验指纹（不通过 CA 验证服务器证书，适用于自签名证书），同时指定 CA 时两者都必须通过。nil 表示不校验（默认）
+func WithTLSServerFingerprint(fp []byte) ClientOption {
+	return func(c *Client) {
+		c.tlsServerFingerprint = fp
+	}
+}
+
+// WithHTTPProxy 通过上游 HTTP 代理连接服务器：先连接代理并发送 CONNECT，再在隧道上进行握手（PQC mTLS 或纯 TCP）
+// 代理地址中带用户名和密码时使用 Basic 认证（见 ParseHTTPProxy）。显式设置的传输（WithTransport）不受影响，需自行配置代理
+func WithHTTPProxy(proxy *url.URL) ClientOption {
+	return func(c *Client) {
+		c.httpProxy = proxy
+	}
+}
+
+// WithTLSTimeouts 设置 PQC mTLS 连接服务器的 TCP 连接超时和握手超时（0 表示使用默认值，均为 10 秒）
+// 服务器不可达时拨号在超时后失败，重连循环按重连间隔重试，而不是等待操作系统的 TCP 超时（可达数分钟）
+func WithTLSTimeouts(dial, handshake time.Duration) ClientOption {
+	return func(c *Client) {
+		c.tlsDialTimeout = dial
+		c.tlsHandshakeTimeout = handshake
+	}
+}
+
+// WithTLSSessionResumption 设置是否在重连时恢复 TLS 会话（仅 PQC mTLS 生效，需要服务器同时启用）
+// 会话只保存在内存中；服务器不接受时自动回退到完整握手。默认禁用
+func WithTLSSessionResumption(enabled bool) ClientOption {
+	return func(c *Client) {
+		if enabled {
+			c.tlsSessionCache = pqctls.NewSessionCache()
+		} else {
+			c.tlsSessionCache = nil
+		}
+	}
+}
+
+// WithFrameTracer 设置控制连接的帧跟踪（读写的每个帧交给 t 记录，不含负载内容，nil 表示不记录）
+func WithFrameTracer(t FrameTracer) ClientOption {
+	return func(c *Client) {
+		c.frameTracer = t
+		c.controlWriteMu.tracer = t
+	}
+}
+
+// WithFrameMAC 设置控制连接帧完整性校验的共享密钥（仅用于未启用 TLS 的明文模式，必须与服务器一致，nil 表示不启用）
+func WithFrameMAC(secret []byte) ClientOption {
+	return func(c *Client) {
+		c.frameMACKey = secret
+	}
+}
+
+// WithTransport 设置连接服务器使用的传输（必须与服务器一致，例如 WebSocketTransport）
+// nil 表示按是否启用 TLS 选择 PQC mTLS 或纯 TCP（默认）
+func WithTransport(t Transport) ClientOption {
+	return func(c *Client) {
+		c.transport = t
+	}
+}
+
+// WithControlDialer 使用外部提供的拨号器建立控制连接（例如测试中的内存管道）
+// 拨号器返回的连接原样使用，不做 TLS 封装
+func WithControlDialer(d Dialer) ClientOption {
+	return func(c *Client) {
+		c.controlDialer = d
+	}
+}
+
+// WithLocalDialer 使用外部提供的拨号器连接本地服务（启用本地 TLS 时仍在其返回的连接上握手）
+// 设置后本地拨号源地址和 TCP Fast Open 不再生效
+func WithLocalDialer(d Dialer) ClientOption {
+	return func(c *Client) {
+		c.localDial = d
+	}
+}
@@ -0,0 +1,494 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// OTLP 推送的默认间隔和请求超时
+const (
+	defaultOTLPInterval = time.Minute
+	otlpRequestTimeout  = 10 * time.Second
+)
+
+// OTLPConfig OpenTelemetry 指标推送配置（OTLP/HTTP，JSON 编码）
+type OTLPConfig struct {
+	Endpoint string            // 指标接收地址（例如 http://otel-collector:4318/v1/metrics，路径为空时使用 /v1/metrics；留空则不推送）
+	Interval time.Duration     // 推送间隔（0 表示 defaultOTLPInterval）
+	Headers  map[string]string // 附加的请求头（例如后端要求的认证令牌）
+}
+
+// otlpExporter 周期性地把与 /metrics 相同的指标以 OTLP/HTTP（JSON 编码）推送到 OpenTelemetry collector。
+// 指标只有一份实现：collect 写出的 Prometheus 文本在推送时转换为 OTLP 数据点
+// （counter 为单调累计的 Sum，gauge 为 Gauge，histogram 为显式边界的 Histogram），两种导出方式看到的数值始终一致。
+// 推送失败只计数并限频记录日志，不重试，下一次推送携带最新的累计值
+type otlpExporter struct {
+	endpoint string
+	interval time.Duration
+	headers  map[string]string
+	resource []otlpKeyValue
+	collect  func(buf *bytes.Buffer)
+	client   *http.Client
+	start    time.Time
+
+	exported atomic.Uint64 // 推送成功的次数
+	failed   atomic.Uint64 // 推送失败的次数
+	failLog  rateLimitedLog
+}
+
+// newOTLPExporter 创建 OTLP 指标推送器，cfg.Endpoint 为空时返回 nil（不推送）
+// service 和 instance 作为资源属性 service.name 和 service.instance.id（instance 为空时省略）
+func newOTLPExporter(cfg OTLPConfig, service, instance string, collect func(buf *bytes.Buffer)) *otlpExporter {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultOTLPInterval
+	}
+	resource := []otlpKeyValue{otlpAttr("service.name", service)}
+	if instance != "" {
+		resource = append(resource, otlpAttr("service.instance.id", instance))
+	}
+	return &otlpExporter{
+		endpoint: otlpMetricsURL(cfg.Endpoint),
+		interval: interval,
+		headers:  cfg.Headers,
+		resource: resource,
+		collect:  collect,
+		client:   &http.Client{Timeout: otlpRequestTimeout},
+		start:    time.Now(),
+	}
+}
+
+// otlpMetricsURL 路径为空时补全 OTLP/HTTP 指标的默认路径 /v1/metrics
+func otlpMetricsURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Path != "" && u.Path != "/") {
+		return endpoint
+	}
+	u.Path = "/v1/metrics"
+	return u.String()
+}
+
+// run 每隔 interval 推送一次指标，直到 ctx 结束；结束时再推送一次最终的累计值
+func (e *otlpExporter) run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	log.Printf("OTLP 指标推送: %s (间隔 %v)", e.endpoint, e.interval)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), otlpRequestTimeout)
+			e.push(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			e.push(ctx)
+		}
+	}
+}
+
+// push 采集并推送一次指标
+func (e *otlpExporter) push(ctx context.Context) {
+	var buf bytes.Buffer
+	e.collect(&buf)
+	body, err := json.Marshal(otlpRequest(parsePromText(buf.Bytes()), e.resource, e.start, time.Now()))
+	if err == nil {
+		err = e.post(ctx, body)
+	}
+	if err != nil {
+		e.failed.Add(1)
+		e.failLog.printf("推送 OTLP 指标失败 (%s): %v", e.endpoint, err)
+		return
+	}
+	e.exported.Add(1)
+}
+
+// post 发送一次 OTLP/HTTP 请求，非 2xx 响应视为失败
+func (e *otlpExporter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector 返回 HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// writeMetrics 以 Prometheus 文本格式写入推送成功和失败的次数（未启用推送时不输出）
+func (e *otlpExporter) writeMetrics(buf *bytes.Buffer) {
+	if e == nil {
+		return
+	}
+	buf.WriteString("# HELP reverse_tunnel_otlp_exports_total OTLP metric exports, by outcome (ok, failed).\n")
+	buf.WriteString("# TYPE reverse_tunnel_otlp_exports_total counter\n")
+	fmt.Fprintf(buf, "reverse_tunnel_otlp_exports_total{outcome=\"ok\"} %d\n", e.exported.Load())
+	fmt.Fprintf(buf, "reverse_tunnel_otlp_exports_total{outcome=\"failed\"} %d\n", e.failed.Load())
+}
+
+// promSample Prometheus 文本中的一个样本
+type promSample struct {
+	name   string
+	labels []otlpKeyValue
+	value  float64
+}
+
+// promFamily 一个指标（HELP/TYPE 声明及其样本）
+type promFamily struct {
+	name    string
+	help    string
+	typ     string // counter | gauge | histogram（未声明 TYPE 时按 gauge 处理）
+	samples []promSample
+}
+
+// parsePromText 解析 writeMetrics 输出的 Prometheus 文本格式，无法解析的行被跳过
+func parsePromText(data []byte) []*promFamily {
+	var families []*promFamily
+	byName := make(map[string]*promFamily)
+	family := func(name string) *promFamily {
+		f, ok := byName[name]
+		if !ok {
+			f = &promFamily{name: name, typ: "gauge"}
+			byName[name] = f
+			families = append(families, f)
+		}
+		return f
+	}
+	var current *promFamily
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 4 {
+				continue
+			}
+			switch fields[1] {
+			case "HELP":
+				current = family(fields[2])
+				current.help = fields[3]
+			case "TYPE":
+				current = family(fields[2])
+				current.typ = fields[3]
+			}
+			continue
+		}
+		sample, ok := parsePromSample(line)
+		if !ok {
+			continue
+		}
+		if current == nil || !belongsTo(current, sample.name) {
+			current = family(sample.name)
+		}
+		current.samples = append(current.samples, sample)
+	}
+	return families
+}
+
+// belongsTo 判断样本名是否属于指标 f（直方图的样本带 _bucket、_sum、_count 后缀）
+func belongsTo(f *promFamily, name string) bool {
+	if name == f.name {
+		return true
+	}
+	if f.typ != "histogram" {
+		return false
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if name == f.name+suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePromSample 解析一行样本：name{k="v",...} value
+func parsePromSample(line string) (promSample, bool) {
+	var s promSample
+	i := strings.IndexAny(line, "{ ")
+	if i <= 0 {
+		return s, false
+	}
+	s.name = line[:i]
+	rest := line[i:]
+	if rest[0] == '{' {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, ", ")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			eq := strings.Index(rest, "=\"")
+			if eq <= 0 {
+				return s, false
+			}
+			key := rest[:eq]
+			value, n, ok := unquoteLabel(rest[eq+2:])
+			if !ok {
+				return s, false
+			}
+			s.labels = append(s.labels, otlpAttr(key, value))
+			rest = rest[eq+2+n:]
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, false
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, false
+	}
+	s.value = v
+	return s, true
+}
+
+// unquoteLabel 读取标签值直到未转义的引号，返回值和消耗的字节数（包括结尾的引号）
+func unquoteLabel(s string) (string, int, bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, true
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, false
+			}
+			i++
+			if s[i] == 'n' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, false
+}
+
+// OTLP/HTTP JSON 编码的请求体（opentelemetry-proto 的 ExportMetricsServiceRequest，64 位整数按 proto3 JSON 映射编码为字符串）
+type otlpExportRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+}
+
+// otlpCumulative AGGREGATION_TEMPORALITY_CUMULATIVE：数值为从 startTimeUnixNano 起的累计值（与 Prometheus counter 相同）
+const otlpCumulative = 2
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	Count             string         `json:"count"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      []string       `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+// otlpAttr 创建字符串属性
+func otlpAttr(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: value}}
+}
+
+// otlpNanos 以 proto3 JSON 映射的 fixed64 字符串表示时间
+func otlpNanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpRequest 把解析出的指标转换为 OTLP 请求：counter 的起始时间为 start（推送器创建时间），非有限值的样本被跳过
+func otlpRequest(families []*promFamily, resource []otlpKeyValue, start, now time.Time) *otlpExportRequest {
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, f := range families {
+		m := otlpMetric{Name: f.name, Description: f.help}
+		switch f.typ {
+		case "counter":
+			m.Sum = &otlpSum{DataPoints: numberPoints(f.samples, start, now), AggregationTemporality: otlpCumulative, IsMonotonic: true}
+		case "histogram":
+			m.Histogram = &otlpHistogram{DataPoints: histogramPoints(f, start, now), AggregationTemporality: otlpCumulative}
+		default:
+			m.Gauge = &otlpGauge{DataPoints: numberPoints(f.samples, time.Time{}, now)}
+		}
+		metrics = append(metrics, m)
+	}
+	return &otlpExportRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: resource},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "reverse-tunnel"}, Metrics: metrics}},
+	}}}
+}
+
+// numberPoints 每个样本一个数据点（start 为零值时省略起始时间）
+func numberPoints(samples []promSample, start, now time.Time) []otlpNumberPoint {
+	points := make([]otlpNumberPoint, 0, len(samples))
+	for _, s := range samples {
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		p := otlpNumberPoint{Attributes: s.labels, TimeUnixNano: otlpNanos(now), AsDouble: s.value}
+		if !start.IsZero() {
+			p.StartTimeUnixNano = otlpNanos(start)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// histogramPoints 按 le 以外的标签分组，把 Prometheus 的累计桶（_bucket{le}）转换为 OTLP 的显式边界和逐桶计数
+func histogramPoints(f *promFamily, start, now time.Time) []otlpHistogramPoint {
+	type bucket struct {
+		bound float64
+		count float64
+	}
+	type series struct {
+		labels  []otlpKeyValue
+		buckets []bucket
+		sum     float64
+		count   float64
+	}
+	var order []string
+	groups := make(map[string]*series)
+	for _, s := range f.samples {
+		var le string
+		labels := make([]otlpKeyValue, 0, len(s.labels))
+		for _, l := range s.labels {
+			if l.Key == "le" && s.name == f.name+"_bucket" {
+				le = l.Value.StringValue
+				continue
+			}
+			labels = append(labels, l)
+		}
+		key := fmt.Sprint(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &series{labels: labels}
+			groups[key] = g
+			order = append(order, key)
+		}
+		switch s.name {
+		case f.name + "_bucket":
+			bound, err := strconv.ParseFloat(le, 64)
+			if err != nil {
+				continue
+			}
+			g.buckets = append(g.buckets, bucket{bound, s.value})
+		case f.name + "_sum":
+			g.sum = s.value
+		case f.name + "_count":
+			g.count = s.value
+		}
+	}
+
+	points := make([]otlpHistogramPoint, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		sort.Slice(g.buckets, func(i, j int) bool { return g.buckets[i].bound < g.buckets[j].bound })
+		p := otlpHistogramPoint{
+			Attributes:        g.labels,
+			StartTimeUnixNano: otlpNanos(start),
+			TimeUnixNano:      otlpNanos(now),
+			Count:             strconv.FormatUint(uint64(g.count), 10),
+			Sum:               g.sum,
+			ExplicitBounds:    []float64{},
+		}
+		prev := 0.0
+		for _, b := range g.buckets {
+			if !math.IsInf(b.bound, 1) {
+				p.ExplicitBounds = append(p.ExplicitBounds, b.bound)
+			}
+			p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(uint64(b.count-prev), 10))
+			prev = b.count
+		}
+		// 没有 +Inf 桶时补上溢出桶（桶数 = 边界数 + 1）
+		if len(p.BucketCounts) == len(p.ExplicitBounds) {
+			p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(uint64(g.count-prev), 10))
+		}
+		if math.IsNaN(p.Sum) || math.IsInf(p.Sum, 0) {
+			p.Sum = 0
+		}
+		points = append(points, p)
+	}
+	return points
+}
@@ -0,0 +1,101 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestOTLPExport 测试服务器周期性地把 /metrics 中的指标以 OTLP/HTTP JSON 推送到 collector（路径为空时使用 /v1/metrics）
+func TestOTLPExport(t *testing.T) {
+	requests := make(chan *otlpExportRequest, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("X-Token") != "secret" {
+			t.Errorf("请求路径或请求头不正确: %s %v", r.URL.Path, r.Header)
+		}
+		var req otlpExportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("解析 OTLP 请求失败: %v", err)
+		}
+		select {
+		case requests <- &req:
+		default:
+		}
+	}))
+	defer collector.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := NewServer("", "", WithServerControlListener(newMemListener("control")), WithServerPublicListener(newMemListener("public")),
+		WithServerName("edge-1"),
+		WithServerOTLPMetrics(OTLPConfig{Endpoint: collector.URL, Interval: 20 * time.Millisecond, Headers: map[string]string{"X-Token": "secret"}}))
+	go server.Run(ctx)
+
+	var req *otlpExportRequest
+	select {
+	case req = <-requests:
+	case <-time.After(3 * time.Second):
+		t.Fatal("collector 未收到推送")
+	}
+	if len(req.ResourceMetrics) != 1 || len(req.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("请求结构不正确: %+v", req)
+	}
+	rm := req.ResourceMetrics[0]
+	wantResource := []otlpKeyValue{otlpAttr("service.name", "reverse-tunnel-server"), otlpAttr("service.instance.id", "edge-1")}
+	if !reflect.DeepEqual(rm.Resource.Attributes, wantResource) {
+		t.Errorf("资源属性应为 %v, 得到 %v", wantResource, rm.Resource.Attributes)
+	}
+	metrics := make(map[string]otlpMetric)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	if m := metrics["reverse_tunnel_clients"]; m.Gauge == nil || len(m.Gauge.DataPoints) != 1 || m.Gauge.DataPoints[0].AsDouble != 0 {
+		t.Errorf("reverse_tunnel_clients 应为值为 0 的 gauge: %+v", m)
+	}
+	m := metrics["reverse_tunnel_bytes_total"]
+	if m.Sum == nil || !m.Sum.IsMonotonic || m.Sum.AggregationTemporality != otlpCumulative || len(m.Sum.DataPoints) != 2 {
+		t.Fatalf("reverse_tunnel_bytes_total 应为单调累计的 sum: %+v", m)
+	}
+	if p := m.Sum.DataPoints[0]; !reflect.DeepEqual(p.Attributes, []otlpKeyValue{otlpAttr("direction", "in")}) || p.StartTimeUnixNano == "" {
+		t.Errorf("数据点应携带 direction 属性和起始时间: %+v", p)
+	}
+	if m.Description == "" {
+		t.Error("指标应携带 HELP 描述")
+	}
+}
+
+// TestOTLPHistogram 测试 Prometheus 的累计桶转换为 OTLP 的显式边界和逐桶计数，并按 le 以外的标签分组
+func TestOTLPHistogram(t *testing.T) {
+	text := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="a",le="0.1"} 2
+latency_seconds_bucket{op="a",le="1"} 5
+latency_seconds_bucket{op="a",le="+Inf"} 6
+latency_seconds_sum{op="a"} 3.5
+latency_seconds_count{op="a"} 6
+latency_seconds_bucket{op="b \"x\"",le="0.1"} 0
+latency_seconds_bucket{op="b \"x\"",le="1"} 0
+latency_seconds_bucket{op="b \"x\"",le="+Inf"} 1
+latency_seconds_sum{op="b \"x\""} 9
+latency_seconds_count{op="b \"x\""} 1
+`
+	families := parsePromText([]byte(text))
+	if len(families) != 1 {
+		t.Fatalf("应解析出 1 个指标, 得到 %d", len(families))
+	}
+	points := histogramPoints(families[0], time.Unix(1, 0), time.Unix(2, 0))
+	if len(points) != 2 {
+		t.Fatalf("应按标签分为 2 个数据点, 得到 %d", len(points))
+	}
+	a := points[0]
+	if !reflect.DeepEqual(a.ExplicitBounds, []float64{0.1, 1}) || !reflect.DeepEqual(a.BucketCounts, []string{"2", "3", "1"}) || a.Count != "6" || a.Sum != 3.5 {
+		t.Errorf("op=a 的数据点不正确: %+v", a)
+	}
+	if b := points[1]; !reflect.DeepEqual(b.Attributes, []otlpKeyValue{otlpAttr("op", `b "x"`)}) || !reflect.DeepEqual(b.BucketCounts, []string{"0", "0", "1"}) {
+		t.Errorf("op=b 的数据点不正确: %+v", b)
+	}
+}
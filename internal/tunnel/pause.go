@@ -0,0 +1,163 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 客户端暂停期间新公开连接的处理策略
+const (
+	PausePolicyReject = "reject" // 关闭连接（默认，与没有可用客户端时的处理相同）
+	PausePolicyHold   = "hold"   // 等待客户端恢复，超时后关闭
+)
+
+// defaultPauseHoldTimeout PausePolicyHold 默认的最长等待时间
+const defaultPauseHoldTimeout = 30 * time.Second
+
+// errClientPaused 客户端已暂停（重复暂停）
+var errClientPaused = errors.New("客户端已暂停")
+
+// errClientNotPaused 客户端未暂停（重复恢复）
+var errClientNotPaused = errors.New("客户端未暂停")
+
+// PauseClient 暂停客户端的转发（用于维护，例如在后端发布前排空客户端）：不再向其发送 NEW_CONN，
+// 控制连接保持不变。全局公开端口的新连接优先路由到同一路由键的其他未暂停客户端，
+// 没有其他客户端时和客户端专用公开端口的新连接一样按暂停策略处理（见 WithServerPausePolicy）。
+// closeExisting 为 true 时同时强制关闭客户端现有的转发连接，否则现有连接继续转发直到自然结束
+func (s *Server) PauseClient(clientID string, closeExisting bool) error {
+	s.clientsMu.RLock()
+	info, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", errClientNotFound, clientID)
+	}
+	if info.paused.Swap(true) {
+		return fmt.Errorf("%w: %s", errClientPaused, clientID)
+	}
+
+	log.Printf("客户端已暂停转发: %s (关闭现有连接=%v)", clientID, closeExisting)
+	if closeExisting {
+		info.ConnMap.Range(func(key, _ interface{}) bool {
+			// 连接已在关闭中时由关闭方完成清理
+			s.CloseConn(clientID, key.(uint32))
+			return true
+		})
+	}
+	return nil
+}
+
+// ResumeClient 恢复被暂停的客户端的转发，等待中（PausePolicyHold）的公开连接随即转发给它
+func (s *Server) ResumeClient(clientID string) error {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	info, ok := s.clients[clientID]
+	if !ok {
+		return fmt.Errorf("%w: %s", errClientNotFound, clientID)
+	}
+	if !info.paused.Swap(false) {
+		return fmt.Errorf("%w: %s", errClientNotPaused, clientID)
+	}
+	s.notifyClientsChanged()
+	log.Printf("客户端已恢复转发: %s", clientID)
+	return nil
+}
+
+// activeClients 返回 candidates 中未暂停的客户端；全部暂停时原样返回，由 awaitResume 按暂停策略处理
+func activeClients(candidates []*ClientInfo) []*ClientInfo {
+	active := make([]*ClientInfo, 0, len(candidates))
+	for _, info := range candidates {
+		if !info.paused.Load() {
+			active = append(active, info)
+		}
+	}
+	if len(active) == 0 {
+		return candidates
+	}
+	return active
+}
+
+// awaitResume 处理路由到暂停中的客户端的公开连接：PausePolicyHold 时在当前 goroutine 中等待客户端恢复
+// （在该连接单独的 goroutine 中等待，占用转发名额但不占用 worker，最长等待时间有上限），其余情况（包括等待超时、客户端注销）由 rejectNoClient 关闭连接。
+// 客户端未暂停或已恢复时返回 true
+func (s *Server) awaitResume(ctx context.Context, clientInfo *ClientInfo, conn net.Conn) bool {
+	if !clientInfo.paused.Load() {
+		return true
+	}
+	if s.pausePolicy == PausePolicyHold {
+		timeout := s.pauseHoldTimeout
+		if timeout <= 0 {
+			timeout = defaultPauseHoldTimeout
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for waiting := true; waiting; {
+			// 先取得 channel 再检查，检查之后的恢复同样会唤醒等待
+			changed := s.clientsChangedChan()
+			if !clientInfo.paused.Load() {
+				return true
+			}
+			s.clientsMu.RLock()
+			_, registered := s.clients[clientInfo.ID]
+			s.clientsMu.RUnlock()
+			if !registered {
+				break
+			}
+			select {
+			case <-changed:
+			case <-timer.C:
+				waiting = false
+			case <-ctx.Done():
+				conn.Close()
+				return false
+			}
+		}
+	}
+	s.pausedRejected.Add(1)
+	s.pauseRejectLog.printf("客户端已暂停转发，关闭公开连接: %s, clientID=%s", conn.RemoteAddr(), clientInfo.ID)
+	s.rejectNoClient(conn)
+	return false
+}
+
+// PauseClientHandler 返回 POST /clients/{id}/pause 的处理器：暂停客户端的转发，查询参数 close_conns=true 时同时关闭现有连接
+// 成功时回复 204；客户端不存在时回复 404，已暂停时回复 409
+func (s *Server) PauseClientHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		closeExisting := false
+		if v := r.URL.Query().Get("close_conns"); v != "" {
+			var err error
+			if closeExisting, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, "invalid close_conns", http.StatusBadRequest)
+				return
+			}
+		}
+		writePauseResult(w, s.PauseClient(r.PathValue("id"), closeExisting))
+	})
+}
+
+// ResumeClientHandler 返回 POST /clients/{id}/resume 的处理器：恢复客户端的转发
+// 成功时回复 204；客户端不存在时回复 404，未暂停时回复 409
+func (s *Server) ResumeClientHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writePauseResult(w, s.ResumeClient(r.PathValue("id")))
+	})
+}
+
+// writePauseResult 按暂停/恢复的结果回复状态码
+func writePauseResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, errClientNotFound):
+		http.Error(w, "client not found", http.StatusNotFound)
+	case errors.Is(err, errClientPaused):
+		http.Error(w, "client is already paused", http.StatusConflict)
+	default:
+		http.Error(w, "client is not paused", http.StatusConflict)
+	}
+}
@@ -0,0 +1,153 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestPauseClient 测试暂停客户端后现有连接继续转发、新连接被关闭，hold 策略下新连接等待到恢复后转发，
+// 以及管理接口对重复暂停/恢复和不存在的客户端的回复
+func TestPauseClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerAdminToken("secret"))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	echo := func(conn io.ReadWriter, msg string) error {
+		go conn.Write([]byte(msg))
+		buf := make([]byte, len(msg))
+		_, err := io.ReadFull(conn, buf)
+		return err
+	}
+	dial := func() io.ReadWriteCloser {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		return conn
+	}
+	serve := func(path string) int {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		server.auxHandler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	existing := dial()
+	defer existing.Close()
+	if err := echo(existing, "before"); err != nil {
+		t.Fatalf("暂停前回显失败: %v", err)
+	}
+
+	if code := serve("/clients/client-1/pause"); code != http.StatusNoContent {
+		t.Fatalf("暂停应回复 204, 得到 %d", code)
+	}
+	if code := serve("/clients/client-1/pause"); code != http.StatusConflict {
+		t.Errorf("重复暂停应回复 409, 得到 %d", code)
+	}
+	if code := serve("/clients/client-9/pause"); code != http.StatusNotFound {
+		t.Errorf("不存在的客户端应回复 404, 得到 %d", code)
+	}
+	if status := server.ClientStatus(); len(status) != 1 || !status[0].Paused {
+		t.Errorf("状态应显示客户端已暂停: %+v", status)
+	}
+
+	if err := echo(existing, "during"); err != nil {
+		t.Fatalf("暂停期间现有连接应继续转发: %v", err)
+	}
+	rejected := dial()
+	defer rejected.Close()
+	if _, err := rejected.Read(make([]byte, 1)); err == nil {
+		t.Fatal("暂停期间的新连接应被关闭")
+	}
+	if got := server.pausedRejected.Load(); got != 1 {
+		t.Errorf("因暂停关闭的连接数应为 1, 得到 %d", got)
+	}
+
+	// hold 策略：新连接等待客户端恢复后转发
+	server.pausePolicy = PausePolicyHold
+	held := dial()
+	defer held.Close()
+	go held.Write([]byte("held"))
+	time.Sleep(100 * time.Millisecond)
+	if code := serve("/clients/client-1/resume"); code != http.StatusNoContent {
+		t.Fatalf("恢复应回复 204, 得到 %d", code)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(held, buf); err != nil || string(buf) != "held" {
+		t.Fatalf("等待恢复的连接应被转发: %q, %v", buf, err)
+	}
+	if code := serve("/clients/client-1/resume"); code != http.StatusConflict {
+		t.Errorf("重复恢复应回复 409, 得到 %d", code)
+	}
+}
+
+// TestPauseHoldDoesNotBlockOtherClients 测试 hold 策略下等待暂停客户端恢复的连接不占用 worker：
+// 只有一个 worker 时，另一个客户端的公开端口仍能照常转发
+func TestPauseHoldDoesNotBlockOtherClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	echo := startEchoServer(t, localAddr)
+	defer echo.Close()
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	server := NewServer(controlAddr, "", WithServerPublicQueue(0, "", 1), WithServerPausePolicy(PausePolicyHold, 0))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+	pausedPort, activePort := getFreePort(t), getFreePort(t)
+	listening := func(port int) func() bool {
+		return func() bool {
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+			if err == nil {
+				conn.Close()
+			}
+			return err == nil
+		}
+	}
+	go NewClient(controlAddr, localAddr, pausedPort).Run(ctx)
+	waitStat(t, "client-1 的公开端口", listening(pausedPort), true)
+	go NewClient(controlAddr, localAddr, activePort).Run(ctx)
+	waitStat(t, "client-2 的公开端口", listening(activePort), true)
+	if err := server.PauseClient("client-1", false); err != nil {
+		t.Fatalf("暂停客户端失败: %v", err)
+	}
+
+	// 两个连接等待 client-1 恢复
+	for i := 0; i < 2; i++ {
+		held, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", pausedPort))
+		if err != nil {
+			t.Fatalf("连接暂停客户端的公开端口失败: %v", err)
+		}
+		defer held.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", activePort))
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	go conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("client-1 暂停期间 client-2 应照常转发: %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+// DATA 帧负载大小直方图的方向（指标标签 direction）
+const (
+	payloadSent     = 0 // 本端发送的 DATA 帧
+	payloadReceived = 1 // 本端收到的 DATA 帧
+)
+
+// payloadSizeBuckets DATA 帧负载大小直方图的桶上限（字节），覆盖默认的分块大小 dataChunkSize 两侧
+var payloadSizeBuckets = [...]int{64, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536, 262144, 1048576}
+
+// payloadHistogram 按方向统计的 DATA 帧负载大小直方图，用于判断分块大小（dataChunkSize 或协商的 DATA 负载上限）是否合适：
+// 大部分帧远小于分块大小说明流量以小包为主，大量帧等于分块大小说明数据被拆成了很多帧。
+// 每次记录只做一次桶查找和两次原子加法，nil 表示未启用（记录时直接返回）
+type payloadHistogram struct {
+	dirs [2]payloadSizeCounts
+}
+
+// payloadSizeCounts 一个方向的计数（buckets 不是累计值，输出时再累加）
+type payloadSizeCounts struct {
+	buckets [len(payloadSizeBuckets) + 1]atomic.Uint64 // 最后一个为超过所有桶上限的帧
+	sum     atomic.Uint64
+}
+
+// newPayloadHistogram 创建 DATA 帧负载大小直方图，enable 为 false 时返回 nil
+func newPayloadHistogram(enable bool) *payloadHistogram {
+	if !enable {
+		return nil
+	}
+	return &payloadHistogram{}
+}
+
+// observe 记录一个 DATA 帧的负载大小
+func (h *payloadHistogram) observe(direction, size int) {
+	if h == nil {
+		return
+	}
+	d := &h.dirs[direction]
+	i := 0
+	for i < len(payloadSizeBuckets) && size > payloadSizeBuckets[i] {
+		i++
+	}
+	d.buckets[i].Add(1)
+	d.sum.Add(uint64(size))
+}
+
+// writeMetrics 写入 DATA 帧负载大小直方图（未启用时不输出）
+func (h *payloadHistogram) writeMetrics(buf *bytes.Buffer) {
+	if h == nil {
+		return
+	}
+	buf.WriteString("# HELP reverse_tunnel_frame_payload_bytes Payload sizes of DATA frames on the control connection, by direction.\n")
+	buf.WriteString("# TYPE reverse_tunnel_frame_payload_bytes histogram\n")
+	for dir, name := range []string{"sent", "received"} {
+		d := &h.dirs[dir]
+		var cumulative uint64
+		for i, le := range payloadSizeBuckets {
+			cumulative += d.buckets[i].Load()
+			fmt.Fprintf(buf, "reverse_tunnel_frame_payload_bytes_bucket{direction=%q,le=\"%d\"} %d\n", name, le, cumulative)
+		}
+		cumulative += d.buckets[len(payloadSizeBuckets)].Load()
+		fmt.Fprintf(buf, "reverse_tunnel_frame_payload_bytes_bucket{direction=%q,le=\"+Inf\"} %d\n", name, cumulative)
+		fmt.Fprintf(buf, "reverse_tunnel_frame_payload_bytes_sum{direction=%q} %d\n", name, d.sum.Load())
+		fmt.Fprintf(buf, "reverse_tunnel_frame_payload_bytes_count{direction=%q} %d\n", name, cumulative)
+	}
+}
@@ -0,0 +1,42 @@
+package tunnel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestPayloadHistogram 测试 DATA 帧负载大小按桶上限（含边界值）累计计数，未启用时不输出
+func TestPayloadHistogram(t *testing.T) {
+	h := newPayloadHistogram(true)
+	for _, size := range []int{10, 64, 65, 4096, 4096, 2 << 20} {
+		h.observe(payloadSent, size)
+	}
+	h.observe(payloadReceived, 512)
+
+	var buf bytes.Buffer
+	h.writeMetrics(&buf)
+	for _, want := range []string{
+		`reverse_tunnel_frame_payload_bytes_bucket{direction="sent",le="64"} 2`,
+		`reverse_tunnel_frame_payload_bytes_bucket{direction="sent",le="256"} 3`,
+		`reverse_tunnel_frame_payload_bytes_bucket{direction="sent",le="4096"} 5`,
+		`reverse_tunnel_frame_payload_bytes_bucket{direction="sent",le="1048576"} 5`,
+		`reverse_tunnel_frame_payload_bytes_bucket{direction="sent",le="+Inf"} 6`,
+		`reverse_tunnel_frame_payload_bytes_sum{direction="sent"} 2105483`,
+		`reverse_tunnel_frame_payload_bytes_count{direction="sent"} 6`,
+		`reverse_tunnel_frame_payload_bytes_bucket{direction="received",le="256"} 0`,
+		`reverse_tunnel_frame_payload_bytes_bucket{direction="received",le="512"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("指标输出缺少 %q:\n%s", want, buf.String())
+		}
+	}
+
+	var disabled *payloadHistogram
+	disabled.observe(payloadSent, 100)
+	buf.Reset()
+	disabled.writeMetrics(&buf)
+	if newPayloadHistogram(false) != nil || buf.Len() != 0 {
+		t.Errorf("未启用时不应创建直方图或输出指标")
+	}
+}
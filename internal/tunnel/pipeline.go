@@ -0,0 +1,337 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 公开连接的处理分为三个阶段：
+//   - 接受（acceptPublicConnections）：从公开监听器接受连接，经过按来源的限制后放入队列
+//   - 路由（routePublicConn）：在 worker 中为全局监听器的连接选择客户端（SNI/Host 路由、负载均衡、无客户端策略）
+//   - 转发（handlePublicConnection）：分配 connID、发送 NEW_CONN，并在连接的生命周期内转发数据
+//     （启用 lazyNewConn 时先在转发 goroutine 中等待公开连接的第一个字节，见 awaitFirstByte；
+//     启用 trace context 时同样在转发 goroutine 中预读第一个请求并注入 traceparent，见 injectTraceparent）
+// 每个阶段只依赖上一阶段的输出，可以单独测试。转发阶段为每个公开连接启动一个转发 goroutine，
+// 其数量受 maxForwarders 限制，达到上限时新连接在转发阶段被关闭，不再启动新的 goroutine
+
+// acceptPublicConnections 接受阶段：从公开监听器接受连接并放入队列
+// clientID 为空表示全局监听器（连接在路由阶段选择客户端），否则为该客户端的监听器，监听器在客户端注销时关闭，此时循环结束
+func (s *Server) acceptPublicConnections(ctx context.Context, listener net.Listener, clientID string) {
+	var backoff acceptBackoff
+	label := "公开连接"
+	if clientID != "" {
+		label = fmt.Sprintf("公开连接 (clientID=%s) ", clientID)
+	}
+	for {
+		if clientID != "" {
+			// 客户端建立中的连接数已达上限时暂停 accept（反压），见 setuplimit.go
+			s.waitSetupCapacity(ctx, clientID)
+		}
+		conn, err := listener.Accept()
+		if err != nil {
+			if !backoff.handle(ctx, err, label) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+		conn, ok := s.sourceLimit.admit(conn)
+		if !ok {
+			continue
+		}
+		// 路由（可能需要预读 SNI/Host）在 worker 中完成，避免阻塞 accept 循环
+		s.enqueuePublicConn(ctx, conn, clientID)
+	}
+}
+
+// routePublicConn 路由阶段：为连接选择客户端，返回已确定 clientID 的连接
+// 客户端监听器的连接直接返回；全局监听器的连接按 SNI/Host 或负载均衡选择客户端，没有可用客户端时按无客户端策略处理，
+// 选中的客户端的份额已用完时关闭连接（见 sharelimit.go）。连接已被关闭（探测失败、被拒绝或等待超时）时返回 false
+func (s *Server) routePublicConn(ctx context.Context, job publicConnJob) (publicConnJob, bool) {
+	if job.clientID != "" {
+		return job, true
+	}
+	conn, clientID, host := s.routeGlobalConn(job.conn)
+	if conn == nil {
+		return publicConnJob{}, false
+	}
+	if clientID == "" {
+		if s.noClientPolicy == NoClientPolicyHold {
+			// 等待客户端在单独的 goroutine 中进行，不占用 worker
+			s.startNoClientHold(ctx, conn, host)
+		} else {
+			s.holdForClient(ctx, conn, host)
+		}
+		return publicConnJob{}, false
+	}
+	conn, ok := s.clientShare.admit(conn, clientID)
+	if !ok {
+		return publicConnJob{}, false
+	}
+	return publicConnJob{conn: conn, clientID: clientID, host: host}, true
+}
+
+// handlePublicConnection 转发阶段：把已路由到 clientID 的公开连接交给该客户端
+// host 为全局监听器按 SNI/Host 路由时的主机名（随 NEW_CONN 发给客户端，为空表示未探测）
+func (s *Server) handlePublicConnection(ctx context.Context, publicConn net.Conn, clientID, host string) {
+	if !s.acquireForwarder() {
+		s.forwardersRejected.Add(1)
+		s.forwarderRejectLog.printf("转发 goroutine 数已达上限 (%d)，关闭外部连接: %s, clientID=%s", s.maxForwarders, publicConn.RemoteAddr(), clientID)
+		publicConn.Close()
+		return
+	}
+	if s.lazyNewConn || s.traceContext {
+		// 等待首字节、预读第一个请求（注入 traceparent，最长 hostPeekTimeout）在转发 goroutine 中进行，
+		// 不发送数据的连接不会占住 worker；等待期间占用转发名额
+		go func() {
+			if s.lazyNewConn {
+				var ok bool
+				if publicConn, ok = s.awaitFirstByte(ctx, publicConn); !ok {
+					s.activeForwarders.Add(-1)
+					return
+				}
+			}
+			s.startForwarding(ctx, publicConn, clientID, host, false)
+		}()
+		return
+	}
+	s.startForwarding(ctx, publicConn, clientID, host, true)
+}
+
+// startForwarding 向客户端发送 NEW_CONN 并启动转发 goroutine（调用方已占用转发名额，失败时释放）
+// inWorker 为 true 表示在 worker 中调用：连接需要等待（客户端暂停中、客户端的建立名额已用完）时转到单独的 goroutine 中处理，
+// 只让该客户端的连接等待，worker 继续处理其他客户端的连接
+func (s *Server) startForwarding(ctx context.Context, publicConn net.Conn, clientID, host string, inWorker bool) {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		log.Printf("错误: 客户端不存在 (clientID=%s)，关闭外部连接", clientID)
+		s.activeForwarders.Add(-1)
+		s.rejectNoClient(publicConn)
+		return
+	}
+	var slot *setupLimiter
+	if inWorker {
+		if clientInfo.paused.Load() {
+			go s.startForwarding(ctx, publicConn, clientID, host, false)
+			return
+		}
+		if slot, ok = s.trySetupSlot(clientInfo); !ok {
+			go s.startForwarding(ctx, publicConn, clientID, host, false)
+			return
+		}
+	} else {
+		// 暂停中的客户端不发送 NEW_CONN，按暂停策略等待恢复或关闭连接
+		if !s.awaitResume(ctx, clientInfo, publicConn) {
+			s.activeForwarders.Add(-1)
+			return
+		}
+		// 客户端建立中的连接数已达上限时等待名额（反压），见 setuplimit.go
+		if slot, ok = s.acquireSetupSlot(ctx, clientInfo, publicConn); !ok {
+			s.activeForwarders.Add(-1)
+			return
+		}
+	}
+
+	connID, tc, ok := s.openPublicConn(ctx, clientInfo, publicConn, host, slot)
+	if !ok {
+		s.activeForwarders.Add(-1)
+		return
+	}
+	go func() {
+		defer s.activeForwarders.Add(-1)
+		s.forwardPublicConn(ctx, clientInfo, connID, tc)
+	}()
+}
+
+// acquireForwarder 为新的公开连接占用一个转发名额，已达到 maxForwarders 时返回 false
+// 名额在转发 goroutine 退出（或连接未能交给客户端）时释放
+func (s *Server) acquireForwarder() bool {
+	for {
+		n := s.activeForwarders.Load()
+		if s.maxForwarders > 0 && n >= int64(s.maxForwarders) {
+			return false
+		}
+		if s.activeForwarders.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// openPublicConn 为公开连接分配 connID、发送 NEW_CONN 并登记到客户端的连接映射，失败时关闭公开连接并返回 false
+// slot 为连接占用的建立名额（nil 表示不占用），连接确认建立或结束时归还，失败时立即归还
+func (s *Server) openPublicConn(ctx context.Context, clientInfo *ClientInfo, publicConn net.Conn, host string, slot *setupLimiter) (uint32, *trackedConn, bool) {
+	clientID := clientInfo.ID
+	// 身份的连接数配额
+	if !clientInfo.tenant.acquireConn() {
+		log.Printf("客户端身份 %q 的公开连接数已达配额 (%d)，关闭外部连接: %s, clientID=%s", clientInfo.Identity, clientInfo.tenant.currentQuota().MaxConns, publicConn.RemoteAddr(), clientID)
+		slot.release()
+		publicConn.Close()
+		return 0, nil, false
+	}
+
+	// 为该客户端生成新的 connID 和追踪 ID（追踪 ID 随 NEW_CONN 发给客户端，两端日志共用）
+	connID, ok := s.allocConnID(clientInfo)
+	if !ok {
+		clientInfo.tenant.releaseConn()
+		slot.release()
+		publicConn.Close()
+		return 0, nil, false
+	}
+	traceID, traceparent := newTraceID(), ""
+	if s.traceContext {
+		publicConn, traceID, traceparent = injectTraceparent(publicConn)
+	}
+	if s.httpCompression {
+		publicConn = newCompressConn(publicConn, &s.httpCompressed)
+	}
+	if traceparent != "" {
+		log.Printf("新外部连接: %s, clientID=%s, connID=%d, trace=%s, traceparent=%s", publicConn.RemoteAddr(), clientID, connID, traceID, traceparent)
+	} else {
+		log.Printf("新外部连接: %s, clientID=%s, connID=%d, trace=%s", publicConn.RemoteAddr(), clientID, connID, traceID)
+	}
+
+	// 发送 NEW_CONN 帧，由客户端建立本地连接
+	frame := &proto.Frame{
+		Type:   proto.FrameTypeNEW_CONN,
+		ConnID: connID,
+		Payload: proto.EncodeNewConnInfo(&proto.NewConnInfo{
+			TraceID:    traceID,
+			SourceAddr: publicConn.RemoteAddr().String(),
+			Host:       host,
+		}),
+	}
+
+	// 在发送 NEW_CONN 之前将连接存入该客户端的 map：客户端可能立即回复 CLOSE_CONN
+	// （连接本地服务失败、本地服务熔断中），此时帧分发循环必须已能找到该连接
+	// 连接数配额在连接结束时（finishPublicConn）释放
+	tc := newTrackedConn(publicConn, traceID)
+	tc.traceparent = traceparent
+	tc.tenant = clientInfo.tenant
+	if slot != nil {
+		tc.setupSlot = slot
+		tc.setupHeld.Store(true)
+	}
+	if clientInfo.tenant.limitsOut() {
+		tc.out = newThrottledWriter(ctx)
+	}
+	s.clientsMu.RLock()
+	flowControl := clientInfo.Features&proto.FeatureFlowControl != 0
+	s.clientsMu.RUnlock()
+	if flowControl {
+		tc.window = newSendWindow(proto.InitialWindowSize)
+	}
+	tc.deadlines.set(s.connIdleTimeout, s.connSetupTimeout, s.connMaxLifetime)
+	clientInfo.ConnMap.Store(connID, tc)
+
+	if err := writeFrame(clientInfo.Conn, &clientInfo.writeMu, frame, s.writeTimeout()); err != nil {
+		log.Printf("发送 NEW_CONN 帧错误 (clientID=%s, connID=%d, trace=%s): %v", clientID, connID, traceID, err)
+		clientInfo.ConnMap.Delete(connID)
+		if tc.out != nil {
+			tc.out.stop()
+		}
+		clientInfo.tenant.releaseConn()
+		tc.releaseSetup()
+		publicConn.Close()
+		return 0, nil, false
+	}
+	if tc.out != nil {
+		go s.runThrottledWriter(clientInfo, clientID, connID, tc)
+	}
+	s.events.emit(LifecycleEvent{
+		Kind:     EventConnOpen,
+		ClientID: clientID,
+		Identity: clientInfo.Identity,
+		ConnID:   connID,
+		TraceID:  traceID,
+		Source:   publicConn.RemoteAddr().String(),
+	})
+	return connID, tc, true
+}
+
+// forwardPublicConn 从公开连接读取数据，以 DATA 帧发给客户端，直到连接结束（另一个方向在 handleFramesFromClient 中处理）
+// 客户端协商了 flow_control 时按连接的发送窗口读取（见 flowcontrol.go）。
+// 连接被 handleCloseFrame 关闭（客户端发送了 CLOSE_CONN）或随控制连接清理时，读取出错，
+// 此时 closeLocal 返回 false，清理已由对方完成，直接退出
+func (s *Server) forwardPublicConn(ctx context.Context, clientInfo *ClientInfo, connID uint32, tc *trackedConn) {
+	clientID, traceID := clientInfo.ID, tc.traceID
+	buf := make([]byte, dataChunk(s.clientMaxData(clientInfo)))
+	for {
+		select {
+		case <-ctx.Done():
+			if tc.closeLocal() {
+				s.releasePublicConn(clientInfo, clientID, connID, tc, closeReasonShutdown)
+			}
+			return
+		default:
+			// 协商了流量控制时只读取发送窗口允许的字节数，窗口用完时停止读取，直到客户端归还额度
+			limit, ok := s.waitSendWindow(ctx, clientInfo, connID, tc, len(buf))
+			if !ok {
+				return
+			}
+			tc.deadlines.armRead(tc.Conn)
+			n, err := tc.Read(buf[:limit])
+			s.recordBytesIn(clientInfo, n)
+			clientInfo.tenant.waitIn(ctx, n)
+			if err != nil && isDeadlineErr(err) {
+				// 截止时间已被另一个方向的数据推迟时继续读取，真正到期时关闭连接
+				if kind := tc.deadlines.expired(time.Now()); kind != "" {
+					s.expirePublicConn(clientInfo, connID, tc, kind)
+					return
+				}
+				continue
+			}
+			if err != nil {
+				if !tc.closeLocal() {
+					return
+				}
+				closeReason, code := closeReasonEOF, proto.CloseGraceful
+				if err != io.EOF {
+					code = closeCodeForErr(err)
+					closeReason = closeReasonError
+					if code == proto.CloseReset {
+						closeReason = closeReasonReset
+					}
+					log.Printf("读取公开连接数据错误 (clientID=%s, connID=%d, trace=%s): %v", clientID, connID, traceID, err)
+				}
+				// 发送 CLOSE_CONN 帧通知客户端（重置时客户端同样以 RST 关闭本地连接）
+				s.sendCloseFrame(clientID, connID, traceID, code)
+				s.releasePublicConn(clientInfo, clientID, connID, tc, closeReason)
+				return
+			}
+
+			if n > 0 {
+				// 发送 DATA 帧给 client
+				dataFrame := &proto.Frame{
+					Type:    proto.FrameTypeDATA,
+					ConnID:  connID,
+					Payload: buf[:n],
+				}
+
+				if err := writeFrame(clientInfo.Conn, &clientInfo.writeMu, dataFrame, s.writeTimeout()); err != nil {
+					log.Printf("发送 DATA 帧错误 (clientID=%s, connID=%d, trace=%s): %v", clientID, connID, traceID, err)
+					// writeFrame 已关闭控制连接，不再发送 CLOSE_CONN；其余连接随客户端注销一并关闭
+					if tc.closeLocal() {
+						s.releasePublicConn(clientInfo, clientID, connID, tc, closeReasonClientGone)
+					}
+					return
+				}
+				s.payloadSizes.observe(payloadSent, n)
+				if tc.window != nil {
+					tc.window.consume(n)
+				}
+				if s.connQuotaExceeded(tc) {
+					s.closeOverQuota(clientInfo, clientID, connID, tc)
+					return
+				}
+			}
+		}
+	}
+}
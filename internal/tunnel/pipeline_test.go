@@ -0,0 +1,148 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestRoutePublicConn 测试路由阶段：客户端监听器的连接直接通过，全局监听器的连接选择客户端，没有客户端时关闭连接
+func TestRoutePublicConn(t *testing.T) {
+	ctx := context.Background()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0")
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	job, ok := server.routePublicConn(ctx, publicConnJob{conn: conn, clientID: "client-9"})
+	if !ok || job.conn != conn || job.clientID != "client-9" {
+		t.Errorf("客户端监听器的连接应直接通过: %+v, %v", job, ok)
+	}
+
+	// 没有客户端时按默认的 close 策略关闭连接
+	conn, peer = net.Pipe()
+	if _, ok := server.routePublicConn(ctx, publicConnJob{conn: conn}); ok {
+		t.Errorf("没有客户端时路由应失败")
+	}
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("路由失败的连接应被关闭, 得到 %v", err)
+	}
+
+	server.clients["client-1"] = &ClientInfo{ID: "client-1"}
+	conn, peer = net.Pipe()
+	defer peer.Close()
+	job, ok = server.routePublicConn(ctx, publicConnJob{conn: conn})
+	if !ok || job.clientID != "client-1" {
+		t.Errorf("全局监听器的连接应路由到唯一的客户端: %+v, %v", job, ok)
+	}
+}
+
+// TestOpenPublicConn 测试转发阶段：分配 connID、向客户端发送 NEW_CONN 并登记连接，转发公开连接的数据
+func TestOpenPublicConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0")
+
+	control, clientSide := net.Pipe()
+	defer control.Close()
+	defer clientSide.Close()
+	clientInfo := &ClientInfo{ID: "client-1", Conn: control}
+
+	publicConn, peer := net.Pipe()
+	defer peer.Close()
+	clientSide.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	type result struct {
+		connID uint32
+		tc     *trackedConn
+		ok     bool
+	}
+	opened := make(chan result, 1)
+	go func() {
+		connID, tc, ok := server.openPublicConn(ctx, clientInfo, publicConn, "app.example.com", nil)
+		opened <- result{connID, tc, ok}
+	}()
+
+	frame, err := proto.DecodeFrame(clientSide)
+	if err != nil || frame.Type != proto.FrameTypeNEW_CONN {
+		t.Fatalf("期望 NEW_CONN 帧: %+v, %v", frame, err)
+	}
+	info, err := proto.DecodeNewConnInfo(frame.Payload)
+	if err != nil || info.Host != "app.example.com" || info.TraceID == "" {
+		t.Errorf("NEW_CONN 的连接信息不正确: %+v, %v", info, err)
+	}
+	res := <-opened
+	if !res.ok || res.connID != frame.ConnID {
+		t.Fatalf("openPublicConn 应返回 NEW_CONN 中的 connID: %+v", res)
+	}
+	if v, ok := clientInfo.ConnMap.Load(res.connID); !ok || v.(*trackedConn) != res.tc {
+		t.Errorf("连接应登记到客户端的连接映射")
+	}
+
+	go server.forwardPublicConn(ctx, clientInfo, res.connID, res.tc)
+	go peer.Write([]byte("hello"))
+	frame, err = proto.DecodeFrame(clientSide)
+	if err != nil || frame.Type != proto.FrameTypeDATA || frame.ConnID != res.connID || string(frame.Payload) != "hello" {
+		t.Errorf("公开连接的数据应以 DATA 帧转发: %+v, %v", frame, err)
+	}
+}
+
+// TestMaxForwarders 测试转发 goroutine 数达到上限时新的公开连接被关闭，已有连接结束后恢复接受
+func TestMaxForwarders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerMaxForwarders(1))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	first, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	first.SetDeadline(time.Now().Add(3 * time.Second))
+	go first.Write([]byte("ping"))
+	if _, err := io.ReadFull(first, make([]byte, 4)); err != nil {
+		t.Fatalf("第一个连接应正常转发: %v", err)
+	}
+	waitStat(t, "转发 goroutine 数", func() int64 { return server.activeForwarders.Load() }, 1)
+
+	// 达到上限：第二个连接被关闭
+	second, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开监听器失败: %v", err)
+	}
+	second.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("达到上限时新连接应被关闭, 得到 %v", err)
+	}
+	second.Close()
+	waitStat(t, "被拒绝的连接数", func() uint64 { return server.forwardersRejected.Load() }, 1)
+
+	var buf bytes.Buffer
+	server.writeMetrics(&buf)
+	for _, want := range []string{"reverse_tunnel_active_forwarders 1\n", "reverse_tunnel_forwarders_rejected_total 1\n", "reverse_tunnel_goroutines "} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("指标缺少 %q", want)
+		}
+	}
+
+	// 第一个连接结束后名额释放
+	first.Close()
+	waitStat(t, "转发 goroutine 数", func() int64 { return server.activeForwarders.Load() }, 0)
+	if err := echoRoundTrip(ctx, public, []byte("after release")); err != nil {
+		t.Fatalf("名额释放后应恢复接受连接: %v", err)
+	}
+}
@@ -0,0 +1,520 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// ClientQuota 表示一个客户端身份的资源配额（0 表示不限制）
+type ClientQuota struct {
+	MaxPorts     int   `json:"max_ports"`     // 同一身份最多同时占用的专用公开端口数
+	MaxConns     int   `json:"max_conns"`     // 同一身份最多同时活跃的公开连接数
+	MaxBandwidth int64 `json:"max_bandwidth"` // 同一身份的带宽上限（字节/秒，入/出方向分别计算）
+}
+
+// PolicyStore 按客户端身份（证书 CN）保存配额
+// 未列出的身份使用 Default；Default 也未设置时拒绝该身份注册
+type PolicyStore struct {
+	Default *ClientQuota           `json:"default"`
+	Clients map[string]ClientQuota `json:"clients"`
+
+	// Hostnames 按身份分配的主机名路由键（例如 {"alice": ["dev-alice.tunnel.example.com"]}）
+	// 服务器在该身份的客户端注册时直接分配，客户端无需声明，用于为每个身份提供稳定的子域名
+	Hostnames map[string][]string `json:"hostnames"`
+
+	// Routes 按身份限制客户端可以请求的远程端口和声明的主机名，防止共享部署中抢占端口或其他租户的路由
+	// 未列出的身份使用 DefaultRoutes；两者都未设置时不限制
+	Routes        map[string]RouteRule `json:"routes"`
+	DefaultRoutes *RouteRule           `json:"default_routes"`
+}
+
+// RouteRule 表示一个身份允许请求的远程端口和主机名（列表为空表示该项不限制）
+type RouteRule struct {
+	Ports     []string `json:"ports"`     // 端口或端口范围（例如 "8080"、"20000-20999"）
+	Hostnames []string `json:"hostnames"` // 主机名模式（*.example.com 允许其任意层级的子域名及子域名通配符）
+}
+
+// LoadPolicyFile 从 JSON 文件加载配额策略
+func LoadPolicyFile(path string) (*PolicyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取策略文件失败: %w", err)
+	}
+
+	var policy PolicyStore
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("解析策略文件失败: %w", err)
+	}
+	for identity, quota := range policy.Clients {
+		if err := quota.validate(); err != nil {
+			return nil, fmt.Errorf("客户端 %q 的配额无效: %w", identity, err)
+		}
+	}
+	if policy.Default != nil {
+		if err := policy.Default.validate(); err != nil {
+			return nil, fmt.Errorf("默认配额无效: %w", err)
+		}
+	}
+	for identity, rule := range policy.Routes {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("身份 %q 的路由规则无效: %w", identity, err)
+		}
+	}
+	if policy.DefaultRoutes != nil {
+		if err := policy.DefaultRoutes.validate(); err != nil {
+			return nil, fmt.Errorf("默认路由规则无效: %w", err)
+		}
+	}
+	owners := make(map[string]string)
+	for identity, hostnames := range policy.Hostnames {
+		for _, hostname := range hostnames {
+			if err := ValidateHostnamePattern(hostname); err != nil {
+				return nil, fmt.Errorf("身份 %q 的主机名无效: %w", identity, err)
+			}
+			key := strings.ToLower(hostname)
+			if owner, ok := owners[key]; ok && owner != identity {
+				return nil, fmt.Errorf("主机名 %q 同时分配给了身份 %q 和 %q", hostname, owner, identity)
+			}
+			owners[key] = identity
+		}
+	}
+	return &policy, nil
+}
+
+// HostnamesFor 返回策略为身份分配的主机名路由键（p 为 nil 时返回 nil）
+func (p *PolicyStore) HostnamesFor(identity string) []string {
+	if p == nil {
+		return nil
+	}
+	return p.Hostnames[identity]
+}
+
+// RoutesFor 返回身份的路由规则，ok 为 false 表示不限制（p 为 nil 或未配置规则）
+func (p *PolicyStore) RoutesFor(identity string) (rule RouteRule, ok bool) {
+	if p == nil {
+		return RouteRule{}, false
+	}
+	if r, found := p.Routes[identity]; found {
+		return r, true
+	}
+	if p.DefaultRoutes != nil {
+		return *p.DefaultRoutes, true
+	}
+	return RouteRule{}, false
+}
+
+// validate 校验路由规则中的端口范围和主机名模式
+func (r RouteRule) validate() error {
+	for _, spec := range r.Ports {
+		if _, _, err := parsePortRange(spec); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range r.Hostnames {
+		if err := ValidateHostnamePattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowsPort 判断规则是否允许请求远程端口 port
+func (r RouteRule) AllowsPort(port int) bool {
+	if len(r.Ports) == 0 {
+		return true
+	}
+	for _, spec := range r.Ports {
+		if lo, hi, err := parsePortRange(spec); err == nil && port >= lo && port <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsHostname 判断规则是否允许声明主机名路由键 hostname（可以是 *.app.example.com 这样的通配符）
+func (r RouteRule) AllowsHostname(hostname string) bool {
+	if len(r.Hostnames) == 0 {
+		return true
+	}
+	for _, pattern := range r.Hostnames {
+		if strings.EqualFold(pattern, hostname) || matchHostname(pattern, hostname) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePortRange 解析端口（"8080"）或端口范围（"20000-20999"）
+func parsePortRange(spec string) (lo, hi int, err error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(spec), "-")
+	lo, err = strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return 0, 0, fmt.Errorf("端口 %q 格式无效", spec)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+			return 0, 0, fmt.Errorf("端口范围 %q 格式无效", spec)
+		}
+	}
+	if lo < 1 || hi > 65535 || lo > hi {
+		return 0, 0, fmt.Errorf("端口范围 %q 无效（需在 1-65535 之间且起始不大于结束）", spec)
+	}
+	return lo, hi, nil
+}
+
+// validate 校验配额取值
+func (q ClientQuota) validate() error {
+	if q.MaxPorts < 0 || q.MaxConns < 0 || q.MaxBandwidth < 0 {
+		return fmt.Errorf("配额不能为负数")
+	}
+	return nil
+}
+
+// Quota 返回身份对应的配额，ok 为 false 表示策略不允许该身份
+func (p *PolicyStore) Quota(identity string) (quota ClientQuota, ok bool) {
+	if q, found := p.Clients[identity]; found {
+		return q, true
+	}
+	if p.Default != nil {
+		return *p.Default, true
+	}
+	return ClientQuota{}, false
+}
+
+// AllowsAnonymous 返回策略是否允许空身份（没有客户端证书的客户端）注册，p 为 nil（不限制）时返回 true
+func (p *PolicyStore) AllowsAnonymous() bool {
+	if p == nil {
+		return true
+	}
+	_, ok := p.Quota("")
+	return ok
+}
+
+// peerCertificateConn 能提供对端证书的连接（PQC TLS 连接）
+type peerCertificateConn interface {
+	PeerCertificate() (*x509.Certificate, error)
+}
+
+// peerCertificate 返回控制连接对端的证书，非 TLS 连接或没有证书时返回 nil
+func peerCertificate(conn net.Conn) *x509.Certificate {
+	switch c := conn.(type) {
+	case peerCertificateConn:
+		cert, err := c.PeerCertificate()
+		if err != nil {
+			return nil
+		}
+		return cert
+	case *tls.Conn:
+		certs := c.ConnectionState().PeerCertificates
+		if len(certs) == 0 {
+			return nil
+		}
+		return certs[0]
+	}
+	return nil
+}
+
+// peerIdentity 返回控制连接对端的身份（客户端证书的 CN），非 TLS 连接或没有证书时返回空字符串
+func peerIdentity(conn net.Conn) string {
+	if cert := peerCertificate(conn); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// tenant 表示同一身份下所有客户端共享的配额状态
+type tenant struct {
+	identity string
+	quota    ClientQuota
+
+	mu          sync.Mutex
+	activeConns int
+
+	inLimiter  *bandwidthLimiter // 从公开连接读取方向
+	outLimiter *bandwidthLimiter // 写入公开连接方向
+}
+
+// newTenant 创建身份的配额状态
+func newTenant(identity string, quota ClientQuota) *tenant {
+	return &tenant{
+		identity:   identity,
+		quota:      quota,
+		inLimiter:  newBandwidthLimiter(quota.MaxBandwidth),
+		outLimiter: newBandwidthLimiter(quota.MaxBandwidth),
+	}
+}
+
+// acquireConn 占用一个公开连接名额，超出 max_conns 时返回 false
+func (t *tenant) acquireConn() bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.quota.MaxConns > 0 && t.activeConns >= t.quota.MaxConns {
+		return false
+	}
+	t.activeConns++
+	return true
+}
+
+// releaseConn 释放一个公开连接名额
+func (t *tenant) releaseConn() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.activeConns--
+	t.mu.Unlock()
+}
+
+// waitIn 按带宽配额限制从公开连接读取的速率，ctx 结束时提前返回其错误
+func (t *tenant) waitIn(ctx context.Context, n int) error {
+	if t == nil {
+		return nil
+	}
+	return t.inLimiter.waitContext(ctx, n)
+}
+
+// waitOut 按带宽配额限制写入公开连接的速率，ctx 结束时提前返回其错误
+func (t *tenant) waitOut(ctx context.Context, n int) error {
+	if t == nil {
+		return nil
+	}
+	return t.outLimiter.waitContext(ctx, n)
+}
+
+// limitsOut 判断写入公开连接的方向是否限速
+func (t *tenant) limitsOut() bool {
+	return t != nil && t.outLimiter.limited()
+}
+
+// currentQuota 返回身份当前的配额
+func (t *tenant) currentQuota() ClientQuota {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.quota
+}
+
+// setQuota 按重新加载的策略就地更新配额：在线连接继续计入同一个配额状态，新的连接数、端口数和带宽上限立即生效
+func (t *tenant) setQuota(quota ClientQuota) {
+	t.mu.Lock()
+	t.quota = quota
+	t.mu.Unlock()
+	t.inLimiter.setRate(quota.MaxBandwidth)
+	t.outLimiter.setRate(quota.MaxBandwidth)
+}
+
+// bandwidthLimiter 简单的令牌桶限速器（桶容量为 1 秒的配额），用于字节速率和帧速率
+// 允许令牌透支：单次消耗超过余额时按透支量休眠，因此任意大小的读写都不会被永久阻塞
+type bandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒令牌数（字节或帧），0 表示不限制
+	tokens float64
+	last   time.Time
+}
+
+// newBandwidthLimiter 创建限速器，rate 为 0 时不限制
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	return &bandwidthLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// limited 判断是否限速
+func (l *bandwidthLimiter) limited() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate > 0
+}
+
+// setRate 修改速率（0 表示不限制），余额不超过新的桶容量
+func (l *bandwidthLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refillLocked()
+	l.rate = float64(rate)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+}
+
+// refillLocked 按经过的时间补充令牌（调用方需持有 mu）
+func (l *bandwidthLimiter) refillLocked() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+}
+
+// allow 余额足够时消耗 n 个令牌并返回 true，否则不消耗并返回 false（不休眠）
+func (l *bandwidthLimiter) allow(n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+	l.refillLocked()
+	if l.tokens < float64(n) {
+		return false
+	}
+	l.tokens -= float64(n)
+	return true
+}
+
+// consume 消耗 n 个令牌，返回余额恢复为 0 需要等待的时长
+func (l *bandwidthLimiter) consume(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	l.refillLocked()
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait 消耗 n 个字节的令牌，令牌不足时休眠到余额恢复为 0，返回休眠时长
+func (l *bandwidthLimiter) wait(n int) time.Duration {
+	d := l.consume(n)
+	if d > 0 {
+		time.Sleep(d)
+	}
+	return d
+}
+
+// waitContext 与 wait 相同，但 ctx 结束时停止休眠并返回 ctx 的错误（已消耗的令牌不退还）
+func (l *bandwidthLimiter) waitContext(ctx context.Context, n int) error {
+	d := l.consume(n)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledWriteQueueBytes 限速连接待写入数据的上限（字节）
+const throttledWriteQueueBytes = 1 << 20
+
+// throttledWriter 身份配置了带宽配额时每个公开连接的写入队列：DATA 帧的负载由该连接自己的 goroutine
+// 按配额休眠后写入，限速不会阻塞控制连接的帧分发循环（同一身份的其他连接、CLOSE_CONN 照常处理）。
+// 排队数据超过 throttledWriteQueueBytes 时分发循环等待该连接腾出空间
+// （多路复用没有逐连接的流量控制，只能以此限制内存，见 TCP和队头阻塞分析.md）
+type throttledWriter struct {
+	ctx  context.Context // 连接结束（本端清理、控制连接断开、服务器关闭）时取消
+	stop context.CancelFunc
+
+	mu      sync.Mutex
+	queue   [][]byte
+	queued  int               // 排队的字节数
+	closed  bool              // 已收到 CLOSE_CONN，写完排队的数据后关闭连接
+	reason  proto.CloseReason // 客户端 CLOSE_CONN 的原因
+	ready   chan struct{}     // 有新数据或队列关闭（容量 1，唤醒写入 goroutine）
+	space   chan struct{}     // 有数据被取走（容量 1，唤醒等待空间的分发循环）
+	closing atomic.Bool       // 已收到 CLOSE_CONN，连接已从映射中删除，由写入 goroutine 负责结束
+}
+
+// newThrottledWriter 创建限速写入队列，ctx 结束或调用 stop 后写入 goroutine 退出
+func newThrottledWriter(ctx context.Context) *throttledWriter {
+	ctx, stop := context.WithCancel(ctx)
+	return &throttledWriter{
+		ctx:   ctx,
+		stop:  stop,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+// push 将负载加入队列，超过上限时等待空间（队列为空时总是接受），连接已结束时返回 false
+func (w *throttledWriter) push(payload []byte) bool {
+	for {
+		w.mu.Lock()
+		if w.queued == 0 || w.queued+len(payload) <= throttledWriteQueueBytes {
+			w.queue = append(w.queue, payload)
+			w.queued += len(payload)
+			w.mu.Unlock()
+			notify(w.ready)
+			return true
+		}
+		w.mu.Unlock()
+		select {
+		case <-w.space:
+		case <-w.ctx.Done():
+			return false
+		}
+	}
+}
+
+// closeQueue 收到 CLOSE_CONN：写完排队的数据后按 reason 关闭连接
+func (w *throttledWriter) closeQueue(reason proto.CloseReason) {
+	w.mu.Lock()
+	w.closed = true
+	w.reason = reason
+	w.mu.Unlock()
+	w.closing.Store(true)
+	notify(w.ready)
+}
+
+// pop 取出下一个负载；队列为空且已关闭时 closed 为 true，连接结束时 ok 为 false
+func (w *throttledWriter) pop() (payload []byte, closed, ok bool) {
+	for {
+		w.mu.Lock()
+		if len(w.queue) > 0 {
+			payload = w.queue[0]
+			w.queue[0] = nil
+			w.queue = w.queue[1:]
+			w.queued -= len(payload)
+			w.mu.Unlock()
+			notify(w.space)
+			return payload, false, true
+		}
+		closed = w.closed
+		w.mu.Unlock()
+		if closed {
+			return nil, true, true
+		}
+		select {
+		case <-w.ready:
+		case <-w.ctx.Done():
+			return nil, false, false
+		}
+	}
+}
+
+// notify 非阻塞地向容量为 1 的通道发送信号
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,506 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestClientQuotas 测试按身份的配额：未配置的身份注册被拒绝、端口数和连接数超出配额被拒绝
+func TestClientQuotas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 策略中没有该身份且没有默认配额：注册时收到 ERROR 并被断开
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	strict := NewServer(controlAddr, "", WithServerPolicy(&PolicyStore{
+		Clients: map[string]ClientQuota{"tenant-a": {}},
+	}))
+	go strict.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := proto.DecodeFrame(conn)
+	conn.Close()
+	if err != nil || frame.Type != proto.FrameTypeERROR {
+		t.Fatalf("未配置的身份应收到 ERROR 帧，得到 %+v, %v", frame, err)
+	}
+
+	// 默认配额最多 1 个端口：第二个端口请求被拒绝
+	controlAddr = fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	ports := NewServer(controlAddr, "", WithServerPolicy(&PolicyStore{
+		Default: &ClientQuota{MaxPorts: 1},
+	}))
+	go ports.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	client1 := NewClient(controlAddr, localAddr, getFreePort(t))
+	if err := client1.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer client1.closeControlConn()
+	if err := client1.setupTunnel(ctx); err != nil {
+		t.Fatalf("配额内的端口请求应成功: %v", err)
+	}
+	client2 := NewClient(controlAddr, localAddr, getFreePort(t))
+	if err := client2.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer client2.closeControlConn()
+	if err := client2.setupTunnel(ctx); err == nil || !strings.Contains(err.Error(), "超出配额") {
+		t.Errorf("超出端口配额时应收到拒绝，得到: %v", err)
+	}
+
+	// 最多 1 个活跃连接：第二个公开连接被关闭
+	echo := startEchoServer(t, localAddr)
+	defer echo.Close()
+	controlAddr = fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	conns := NewServer(controlAddr, publicAddr, WithServerPolicy(&PolicyStore{
+		Default: &ClientQuota{MaxConns: 1},
+	}))
+	go conns.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+	go NewClient(controlAddr, localAddr, 0).Run(ctx)
+	time.Sleep(300 * time.Millisecond)
+
+	first, err := net.Dial("tcp", publicAddr)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer first.Close()
+	first.Write([]byte("ping"))
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(first, make([]byte, 4)); err != nil {
+		t.Fatalf("配额内的连接应可用: %v", err)
+	}
+
+	second, err := net.Dial("tcp", publicAddr)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("超出连接数配额的连接应被关闭，读取得到: %v", err)
+	}
+}
+
+// TestRouteRules 测试路由规则：规则外的远程端口和主机名被拒绝（ERROR 帧），规则内的正常生效，未配置规则的身份不受限制
+func TestRouteRules(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	allowedPort := getFreePort(t)
+	policy := &PolicyStore{
+		Default: &ClientQuota{},
+		Routes: map[string]RouteRule{
+			"": {Ports: []string{strconv.Itoa(allowedPort)}, Hostnames: []string{"*.alice.example.com"}},
+		},
+	}
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerPolicy(policy))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	denied := NewClient(controlAddr, localAddr, getFreePort(t))
+	if err := denied.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer denied.closeControlConn()
+	if err := denied.setupTunnel(ctx); err == nil || !strings.Contains(err.Error(), "策略不允许") {
+		t.Errorf("规则外的远程端口应被拒绝，得到: %v", err)
+	}
+	if status := server.ClientStatus(); len(status) != 1 || status[0].RemotePort != 0 {
+		t.Errorf("被拒绝的端口不应分配，客户端状态: %+v", status)
+	}
+
+	allowed := NewClient(controlAddr, localAddr, allowedPort)
+	if err := allowed.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer allowed.closeControlConn()
+	if err := allowed.setupTunnel(ctx); err != nil {
+		t.Errorf("规则内的远程端口应成功: %v", err)
+	}
+
+	// 全局公开端口：只检查主机名
+	controlAddr = fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	global := NewServer(controlAddr, fmt.Sprintf("127.0.0.1:%d", getFreePort(t)), WithServerPolicy(policy))
+	go global.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	for _, tc := range []struct {
+		hostname string
+		ok       bool
+	}{
+		{"app.alice.example.com", true},
+		{"*.dev.alice.example.com", true},
+		{"alice.example.com", false},
+		{"app.bob.example.com", false},
+	} {
+		client := NewClient(controlAddr, localAddr, 0, WithHostname(tc.hostname))
+		if err := client.connectToServer(ctx); err != nil {
+			t.Fatalf("连接服务器失败: %v", err)
+		}
+		err := client.setupTunnel(ctx)
+		client.closeControlConn()
+		if tc.ok && err != nil {
+			t.Errorf("主机名 %q 应被允许: %v", tc.hostname, err)
+		}
+		if !tc.ok && (err == nil || !strings.Contains(err.Error(), "策略不允许")) {
+			t.Errorf("主机名 %q 应被拒绝，得到: %v", tc.hostname, err)
+		}
+	}
+}
+
+// TestParsePortRange 测试端口和端口范围的解析
+func TestParsePortRange(t *testing.T) {
+	for _, tc := range []struct {
+		spec   string
+		lo, hi int
+		ok     bool
+	}{
+		{"8080", 8080, 8080, true},
+		{"20000-20999", 20000, 20999, true},
+		{" 1 - 65535 ", 1, 65535, true},
+		{"0", 0, 0, false},
+		{"70000", 0, 0, false},
+		{"200-100", 0, 0, false},
+		{"http", 0, 0, false},
+		{"80-", 0, 0, false},
+	} {
+		lo, hi, err := parsePortRange(tc.spec)
+		if (err == nil) != tc.ok || (tc.ok && (lo != tc.lo || hi != tc.hi)) {
+			t.Errorf("parsePortRange(%q) = %d, %d, %v", tc.spec, lo, hi, err)
+		}
+	}
+}
+
+// TestBandwidthLimiter 测试令牌耗尽后按透支量休眠
+func TestBandwidthLimiter(t *testing.T) {
+	limiter := newBandwidthLimiter(10000)
+
+	start := time.Now()
+	limiter.wait(10000) // 消耗初始的 1 秒配额，不休眠
+	limiter.wait(5000)  // 透支 0.5 秒
+	elapsed := time.Since(start)
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("限速休眠时间应约为 500ms，得到 %v", elapsed)
+	}
+
+	unlimited := newBandwidthLimiter(0)
+	start = time.Now()
+	unlimited.wait(1 << 30)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("未设置带宽配额时不应休眠")
+	}
+}
+
+// TestBandwidthQuotaIsolation 测试带宽配额限速一个连接的大量下行数据时，同一身份的其他连接不被阻塞
+func TestBandwidthQuotaIsolation(t *testing.T) {
+	const rate = 64 << 10
+	control := newMemListener("control")
+	public := newMemListener("public")
+
+	// 本地服务：首字节为 B 时发送 8 秒配额的数据，否则回显
+	local := newMemListener("local")
+	defer local.Close()
+	go func() {
+		for {
+			conn, err := local.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				first := make([]byte, 1)
+				if _, err := io.ReadFull(conn, first); err != nil {
+					return
+				}
+				if first[0] == 'B' {
+					conn.Write(make([]byte, 8*rate))
+					io.Copy(io.Discard, conn)
+					return
+				}
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerPolicy(&PolicyStore{
+		Default: &ClientQuota{MaxBandwidth: rate},
+	}))
+	go server.Run(ctx)
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	bulk, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer bulk.Close()
+	go bulk.Write([]byte("B"))
+	// 收到首个字节说明大量下行数据已经开始按配额转发
+	bulk.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(bulk, make([]byte, 1)); err != nil {
+		t.Fatalf("读取限速连接的数据失败: %v", err)
+	}
+
+	conn, err := public.DialContext(ctx, "mem", "public")
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	go conn.Write([]byte("Eping"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatalf("读取回显失败: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("被限速的连接不应阻塞同一身份的其他连接，回显耗时 %v", elapsed)
+	}
+
+	// 限速的休眠不应延迟关闭
+	done := make(chan struct{})
+	go func() {
+		server.Shutdown(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Errorf("限速中的连接不应阻塞服务器关闭")
+	}
+}
+
+// TestPolicyReload 测试重新加载策略：无效文件保留原策略，撤销的身份不能再注册，启用撤销时在线客户端被断开
+func TestPolicyReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 纯 TCP 客户端的身份为空字符串
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyFile, []byte(`{"clients": {"": {}}}`), 0644); err != nil {
+		t.Fatalf("写入策略文件失败: %v", err)
+	}
+	policy, err := LoadPolicyFile(policyFile)
+	if err != nil {
+		t.Fatalf("加载策略失败: %v", err)
+	}
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerPolicy(policy), WithServerPolicyReload(policyFile, true))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	connected, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer connected.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// 无效文件：返回错误，原策略和在线客户端保持不变
+	os.WriteFile(policyFile, []byte(`{"clients": `), 0644)
+	if err := server.ReloadPolicy(); err == nil {
+		t.Error("无效的策略文件应返回错误")
+	}
+	if n := len(server.ClientStatus()); n != 1 {
+		t.Fatalf("加载失败后应保留在线客户端，得到 %d 个", n)
+	}
+
+	// 撤销空身份：在线客户端被断开，新连接被拒绝
+	os.WriteFile(policyFile, []byte(`{"clients": {"tenant-a": {}}}`), 0644)
+	if err := server.ReloadPolicy(); err != nil {
+		t.Fatalf("重新加载策略失败: %v", err)
+	}
+	connected.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := connected.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("被撤销身份的在线客户端应被断开，读取得到: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := proto.DecodeFrame(conn)
+	if err != nil || frame.Type != proto.FrameTypeERROR {
+		t.Errorf("被撤销的身份注册时应收到 ERROR 帧，得到 %+v, %v", frame, err)
+	}
+}
+
+// TestPolicyReloadKeepsConnCount 测试重新加载策略时配额就地更新：在线连接继续计入身份的连接数，
+// 新的连接数上限立即对在线客户端生效，不会因为重建配额状态而重复计数
+func TestPolicyReloadKeepsConnCount(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	echo := startEchoServer(t, localAddr)
+	defer echo.Close()
+
+	// 纯 TCP 客户端的身份为空字符串
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyFile, []byte(`{"clients": {"": {"max_conns": 1}}}`), 0644); err != nil {
+		t.Fatalf("写入策略文件失败: %v", err)
+	}
+	policy, err := LoadPolicyFile(policyFile)
+	if err != nil {
+		t.Fatalf("加载策略失败: %v", err)
+	}
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerPolicy(policy), WithServerPolicyReload(policyFile, false))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+	remotePort := getFreePort(t)
+	go NewClient(controlAddr, localAddr, remotePort).Run(ctx)
+
+	// dial 建立公开连接并确认转发，被配额拒绝的连接读取时返回错误
+	dial := func() (net.Conn, bool) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", remotePort))
+		if err != nil {
+			return nil, false
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		go conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			conn.Close()
+			return nil, false
+		}
+		conn.SetDeadline(time.Time{})
+		return conn, true
+	}
+	var first net.Conn
+	waitStat(t, "第一个公开连接", func() bool {
+		var ok bool
+		first, ok = dial()
+		return ok
+	}, true)
+	defer first.Close()
+	if conn, ok := dial(); ok {
+		conn.Close()
+		t.Fatal("超出 max_conns 的连接应被拒绝")
+	}
+
+	if err := os.WriteFile(policyFile, []byte(`{"clients": {"": {"max_conns": 2}}}`), 0644); err != nil {
+		t.Fatalf("写入策略文件失败: %v", err)
+	}
+	if err := server.ReloadPolicy(); err != nil {
+		t.Fatalf("重新加载策略失败: %v", err)
+	}
+	second, ok := dial()
+	if !ok {
+		t.Fatal("提高 max_conns 后在线客户端的新连接应被接受")
+	}
+	defer second.Close()
+	// 重新加载前建立的连接仍然计数
+	if conn, ok := dial(); ok {
+		conn.Close()
+		t.Fatal("重新加载前建立的连接应继续计入连接数")
+	}
+
+	first.Close()
+	second.Close()
+	waitStat(t, "身份的活跃连接数", func() int {
+		server.tenantsMu.Lock()
+		tenant := server.tenants[""]
+		server.tenantsMu.Unlock()
+		tenant.mu.Lock()
+		defer tenant.mu.Unlock()
+		return tenant.activeConns
+	}, 0)
+}
+
+// TestAnonymousClientsRequirePolicy 测试未要求客户端证书时，服务器拒绝允许空身份的策略（启动和重新加载），
+// 没有证书的客户端只能声明策略路由规则明确允许的主机名
+func TestAnonymousClientsRequirePolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for name, policy := range map[string]*PolicyStore{
+		"无策略":        nil,
+		"default 策略": {Default: &ClientQuota{}},
+		"列出空身份":      {Clients: map[string]ClientQuota{"": {}}},
+	} {
+		server := NewServerWithTLS("127.0.0.1:0", "", "server.crt", "server.key", "ca.crt",
+			WithServerTLSRequireClientCert(false), WithServerPolicy(policy))
+		if err := server.Run(ctx); err == nil || !strings.Contains(err.Error(), "空身份") {
+			t.Errorf("%s: 未要求客户端证书时应拒绝启动, 得到 %v", name, err)
+		}
+	}
+
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyFile, []byte(`{"default": {}}`), 0644); err != nil {
+		t.Fatalf("写入策略文件失败: %v", err)
+	}
+	denied := &PolicyStore{Clients: map[string]ClientQuota{"tenant-a": {}}}
+	server := NewServerWithTLS("127.0.0.1:0", "", "server.crt", "server.key", "ca.crt",
+		WithServerTLSRequireClientCert(false), WithServerPolicy(denied), WithServerPolicyReload(policyFile, false))
+	if err := server.ReloadPolicy(); err == nil || !strings.Contains(err.Error(), "空身份") {
+		t.Errorf("未要求客户端证书时重新加载允许空身份的策略应返回错误, 得到 %v", err)
+	}
+	if server.currentPolicy() != denied {
+		t.Error("重新加载失败后应保留原策略")
+	}
+
+	// 没有证书的客户端声明主机名：没有路由规则时拒绝，规则列出的主机名允许
+	initHostname := func(policy *PolicyStore) *proto.Frame {
+		server := NewServerWithTLS("127.0.0.1:0", "127.0.0.1:0", "server.crt", "server.key", "ca.crt",
+			WithServerTLSRequireClientCert(false), WithServerPolicy(policy))
+		serverSide, clientSide := net.Pipe()
+		defer clientSide.Close()
+		clientID, err := server.registerClient(serverSide)
+		if err != nil {
+			t.Fatalf("注册客户端失败: %v", err)
+		}
+		defer server.unregisterClient(clientID)
+
+		replies := make(chan *proto.Frame, 1)
+		go func() {
+			frame, _ := proto.DecodeFrame(clientSide)
+			replies <- frame
+			io.Copy(io.Discard, clientSide)
+		}()
+		config := &proto.InitConfig{LocalAddr: "127.0.0.1:80", Hostnames: []string{"app.example.com"}}
+		frame := &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(config)}
+		if err := server.handleInitFrame(ctx, clientID, frame); err != nil {
+			t.Fatalf("处理 INIT 失败: %v", err)
+		}
+		select {
+		case reply := <-replies:
+			return reply
+		case <-time.After(3 * time.Second):
+			t.Fatal("等待 INIT 回复超时")
+			return nil
+		}
+	}
+	if reply := initHostname(&PolicyStore{Default: &ClientQuota{}}); reply == nil || reply.Type != proto.FrameTypeERROR ||
+		!strings.Contains(string(reply.Payload), "客户端证书") {
+		t.Errorf("没有证书的客户端声明策略未允许的主机名应收到 ERROR, 得到 %+v", reply)
+	}
+	allowed := &PolicyStore{Default: &ClientQuota{}, DefaultRoutes: &RouteRule{Hostnames: []string{"*.example.com"}}}
+	if reply := initHostname(allowed); reply == nil || reply.Type == proto.FrameTypeERROR {
+		t.Errorf("没有证书的客户端声明策略路由规则允许的主机名应被接受, 得到 %+v", reply)
+	}
+}
@@ -0,0 +1,111 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// policyWatchInterval 检查策略文件是否变化的间隔
+const policyWatchInterval = 5 * time.Second
+
+// currentPolicy 返回当前生效的配额策略（nil 表示不限制）
+func (s *Server) currentPolicy() *PolicyStore {
+	s.policyMu.RLock()
+	defer s.policyMu.RUnlock()
+	return s.policy
+}
+
+// ReloadPolicy 从策略文件重新加载配额策略，只影响之后注册的客户端
+// 仍被允许的身份就地更新配额（在线连接继续计入该身份的连接数）；文件无效时返回错误并保留原策略；
+// 启用撤销时，断开身份已不被新策略允许的在线客户端
+func (s *Server) ReloadPolicy() error {
+	if s.policyFile == "" {
+		return fmt.Errorf("未配置策略文件")
+	}
+	policy, err := LoadPolicyFile(s.policyFile)
+	if err != nil {
+		return err
+	}
+	if err := s.checkAnonymousPolicy(policy); err != nil {
+		return err
+	}
+	s.policyMu.Lock()
+	s.policy = policy
+	s.policyMu.Unlock()
+
+	s.tenantsMu.Lock()
+	for identity, t := range s.tenants {
+		// 不删除配额状态：在线连接结束时释放到同一个状态，身份之后重新注册也不会重复计数
+		if quota, ok := policy.Quota(identity); ok {
+			t.setQuota(quota)
+		}
+	}
+	s.tenantsMu.Unlock()
+	log.Printf("配额策略已重新加载: %s (%d 个身份)", s.policyFile, len(policy.Clients))
+
+	if s.currentPolicyRevoke() {
+		s.revokeDisallowedClients(policy)
+	}
+	return nil
+}
+
+// checkAnonymousPolicy 未要求客户端证书（单向 TLS）时，服务器没有其他手段认证没有证书的客户端，
+// 要求策略拒绝空身份，否则任何能连接控制端口的人都能注册客户端、占用公开端口
+func (s *Server) checkAnonymousPolicy(policy *PolicyStore) error {
+	if !s.useTLS || !s.tlsClientCertOptional || !policy.AllowsAnonymous() {
+		return nil
+	}
+	if policy == nil {
+		return fmt.Errorf("未要求客户端证书时必须配置拒绝空身份的配额策略（策略不含 default 且不列出空身份）")
+	}
+	return fmt.Errorf("未要求客户端证书时配额策略必须拒绝空身份（策略不能包含 default 或列出空身份）")
+}
+
+// revokeDisallowedClients 断开身份不被策略允许的在线客户端
+func (s *Server) revokeDisallowedClients(policy *PolicyStore) {
+	s.clientsMu.RLock()
+	var revoked []string
+	for clientID, info := range s.clients {
+		if _, ok := policy.Quota(info.Identity); !ok {
+			log.Printf("客户端身份 %q 已被撤销，断开客户端: %s", info.Identity, clientID)
+			revoked = append(revoked, clientID)
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	for _, clientID := range revoked {
+		s.unregisterClient(clientID)
+	}
+}
+
+// watchPolicyFile 定期检查策略文件的修改时间，变化时重新加载，直到 ctx 结束
+func (s *Server) watchPolicyFile(ctx context.Context) {
+	if s.policyFile == "" {
+		return
+	}
+	var lastMod time.Time
+	if fi, err := os.Stat(s.policyFile); err == nil {
+		lastMod = fi.ModTime()
+	}
+
+	ticker := time.NewTicker(policyWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(s.policyFile)
+			if err != nil || fi.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			if err := s.ReloadPolicy(); err != nil {
+				log.Printf("重新加载配额策略失败，保留原策略: %v", err)
+			}
+		}
+	}
+}
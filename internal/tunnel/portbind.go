@@ -0,0 +1,60 @@
+package tunnel
+
+import (
+	"errors"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// portBindRetryTimeout 绑定专用公开端口遇到端口被占用时的重试时长
+// 客户端快速重连时旧会话可能仍在注销（监听器尚未关闭），短暂重试避免把瞬时冲突报告为绑定失败
+var portBindRetryTimeout = 2 * time.Second
+
+// portBindRetryInterval 绑定专用公开端口的重试间隔
+var portBindRetryInterval = 100 * time.Millisecond
+
+// acceptLoopWaitTimeout 注销客户端时等待其公开端口 accept 循环退出的最长时间
+// （accept 循环可能阻塞在已满的公开连接队列上，不无限等待）
+var acceptLoopWaitTimeout = time.Second
+
+// listenPublicPort 绑定客户端专用的公开端口，端口被占用时在 portBindRetryTimeout 内重试
+func (s *Server) listenPublicPort(clientID, addr string) (net.Listener, error) {
+	deadline := time.Now().Add(portBindRetryTimeout)
+	retried := false
+	for {
+		listener, err := s.socketBuffers.listen(s.listenNetwork(), addr)
+		if err == nil {
+			if retried {
+				log.Printf("公开端口 %s 已释放，重试绑定成功 (clientID=%s)", addr, clientID)
+			}
+			return listener, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) || time.Now().Add(portBindRetryInterval).After(deadline) {
+			return nil, err
+		}
+		if !retried {
+			log.Printf("公开端口 %s 被占用（可能是尚未注销的旧会话），%v 内重试绑定 (clientID=%s)", addr, portBindRetryTimeout, clientID)
+			retried = true
+		}
+		time.Sleep(portBindRetryInterval)
+	}
+}
+
+// waitAcceptLoops 等待客户端的公开端口 accept 循环退出（监听器已关闭），
+// 保证新会话绑定同一端口时旧监听器已不再使用
+func waitAcceptLoops(clientInfo *ClientInfo) {
+	done := make(chan struct{})
+	go func() {
+		clientInfo.acceptLoops.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(acceptLoopWaitTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("等待客户端 %s 的公开端口 accept 循环退出超时 (%v)", clientInfo.ID, acceptLoopWaitTimeout)
+	}
+}
@@ -0,0 +1,201 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 端口通知 webhook 的请求超时和事件队列容量
+const (
+	portWebhookTimeout   = 5 * time.Second
+	portWebhookQueueSize = 256
+)
+
+// PortAssignment 表示一个已就绪隧道的公开端口分配
+type PortAssignment struct {
+	ClientID string `json:"client_id"`
+	Identity string `json:"identity"` // 客户端身份（证书 CN，非 TLS 连接为空）
+	Port     int    `json:"port"`
+	Addr     string `json:"addr"` // 公开监听地址
+}
+
+// PortEvent 表示发送到 webhook 或回调的端口变更事件
+type PortEvent struct {
+	Event string `json:"event"` // assigned | released
+	PortAssignment
+}
+
+// portNotifier 将当前的端口分配写入 JSON 文件并向 webhook 和回调推送变更，用于外部服务发现（DNS、反向代理等）
+// 写文件和推送失败只记录日志，不影响隧道
+type portNotifier struct {
+	file     string          // 端口分配文件路径（留空则不写）
+	webhook  string          // webhook URL（留空则不推送）
+	callback func(PortEvent) // 进程内回调（nil 表示不回调）
+
+	mu          sync.Mutex
+	assignments map[string]PortAssignment // map[clientID]PortAssignment
+
+	events chan PortEvent
+	client *http.Client
+}
+
+// newPortNotifier 创建端口通知器，file、webhook 和 callback 都为空时返回 nil（不通知）
+func newPortNotifier(file, webhook string, callback func(PortEvent)) *portNotifier {
+	if file == "" && webhook == "" && callback == nil {
+		return nil
+	}
+	return &portNotifier{
+		file:        file,
+		webhook:     webhook,
+		callback:    callback,
+		assignments: make(map[string]PortAssignment),
+		events:      make(chan PortEvent, portWebhookQueueSize),
+		client:      &http.Client{Timeout: portWebhookTimeout},
+	}
+}
+
+// run 写入初始（空的）分配文件，并按顺序调用回调、推送 webhook 事件，直到 ctx 结束
+func (n *portNotifier) run(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.writeFileLocked()
+	n.mu.Unlock()
+
+	if n.webhook == "" && n.callback == nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-n.events:
+			if n.callback != nil {
+				n.callback(ev)
+			}
+			if n.webhook == "" {
+				continue
+			}
+			if err := n.post(ctx, ev); err != nil {
+				log.Printf("端口通知 webhook 失败 (%s, clientID=%s, 端口 %d): %v", ev.Event, ev.ClientID, ev.Port, err)
+			}
+		}
+	}
+}
+
+// assigned 记录隧道就绪后的端口分配
+func (n *portNotifier) assigned(a PortAssignment) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.assignments[a.ClientID] = a
+	n.writeFileLocked()
+	n.mu.Unlock()
+	n.enqueue(PortEvent{Event: "assigned", PortAssignment: a})
+}
+
+// released 在客户端断开时移除其端口分配（没有分配记录时忽略）
+func (n *portNotifier) released(clientID string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	a, ok := n.assignments[clientID]
+	if ok {
+		delete(n.assignments, clientID)
+		n.writeFileLocked()
+	}
+	n.mu.Unlock()
+	if ok {
+		n.enqueue(PortEvent{Event: "released", PortAssignment: a})
+	}
+}
+
+// enqueue 将事件放入推送队列，队列满时丢弃并记录日志（不阻塞调用方）
+func (n *portNotifier) enqueue(ev PortEvent) {
+	if n.webhook == "" && n.callback == nil {
+		return
+	}
+	select {
+	case n.events <- ev:
+	default:
+		log.Printf("端口通知队列已满，丢弃事件 (%s, clientID=%s, 端口 %d)", ev.Event, ev.ClientID, ev.Port)
+	}
+}
+
+// writeFileLocked 将当前分配（按 clientID 排序）写入文件（调用方需持有 mu）
+// 先写临时文件再重命名，读取方不会看到写了一半的内容
+func (n *portNotifier) writeFileLocked() {
+	if n.file == "" {
+		return
+	}
+	list := make([]PortAssignment, 0, len(n.assignments))
+	for _, a := range n.assignments {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ClientID < list[j].ClientID })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		log.Printf("编码端口分配失败: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(n.file), filepath.Base(n.file)+".tmp*")
+	if err != nil {
+		log.Printf("写入端口分配文件失败: %v", err)
+		return
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), n.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("写入端口分配文件失败: %v", err)
+	}
+}
+
+// post 向 webhook 发送一个事件，非 2xx 响应视为失败
+func (n *portNotifier) post(ctx context.Context, ev PortEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook 返回 HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// listenerPort 返回监听器的端口（非 TCP 监听器返回 0）
+func listenerPort(listener net.Listener) int {
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPortNotify 测试隧道就绪和客户端断开时更新端口分配文件并推送 webhook 事件
+func TestPortNotify(t *testing.T) {
+	events := make(chan PortEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev PortEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Errorf("解析 webhook 事件失败: %v", err)
+		}
+		events <- ev
+	}))
+	defer hook.Close()
+
+	portFile := filepath.Join(t.TempDir(), "ports.json")
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	remotePort := getFreePort(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, "", WithServerPortNotify(portFile, hook.URL))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	readPorts := func() []PortAssignment {
+		data, err := os.ReadFile(portFile)
+		if err != nil {
+			t.Fatalf("读取端口分配文件失败: %v", err)
+		}
+		var list []PortAssignment
+		if err := json.Unmarshal(data, &list); err != nil {
+			t.Fatalf("解析端口分配文件失败: %v", err)
+		}
+		return list
+	}
+	waitEvent := func(want string) PortEvent {
+		select {
+		case ev := <-events:
+			if ev.Event != want || ev.Port != remotePort {
+				t.Errorf("期望 %s 事件 (端口 %d)，得到 %+v", want, remotePort, ev)
+			}
+			return ev
+		case <-time.After(2 * time.Second):
+			t.Fatalf("未收到 %s 事件", want)
+		}
+		return PortEvent{}
+	}
+
+	if list := readPorts(); len(list) != 0 {
+		t.Errorf("启动时端口分配文件应为空，得到 %+v", list)
+	}
+
+	client := NewClient(controlAddr, localAddr, remotePort)
+	if err := client.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	if err := client.setupTunnel(ctx); err != nil {
+		t.Fatalf("建立隧道失败: %v", err)
+	}
+
+	ev := waitEvent("assigned")
+	if list := readPorts(); len(list) != 1 || list[0].Port != remotePort || list[0].ClientID != ev.ClientID {
+		t.Errorf("端口分配文件内容不符，得到 %+v", list)
+	}
+
+	client.closeControlConn()
+	waitEvent("released")
+	if list := readPorts(); len(list) != 0 {
+		t.Errorf("客户端断开后端口分配应被移除，得到 %+v", list)
+	}
+}
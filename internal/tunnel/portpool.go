@@ -0,0 +1,111 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// portPool 服务器租给客户端的固定公开端口池：请求远程端口 0 的客户端按先到先得租用一个空闲端口，
+// 显式请求池内端口的客户端租用该端口；端口在其监听器关闭（客户端断开、更换或取消端口）时归还。
+// 防火墙只需一次性开放整个端口池，端口仍是动态分配的
+type portPool struct {
+	ports []int
+
+	mu     sync.Mutex
+	leased map[int]string // 端口 -> 租用的 clientID
+	next   int            // 下次从 ports 的该下标开始查找空闲端口（轮转，绑定失败的端口不会被反复选中）
+}
+
+// newPortPool 创建端口池，ports 为空时返回 nil（不启用）
+func newPortPool(ports []int) *portPool {
+	if len(ports) == 0 {
+		return nil
+	}
+	return &portPool{
+		ports:  append([]int(nil), ports...),
+		leased: make(map[int]string),
+	}
+}
+
+// contains 判断端口是否属于端口池
+func (p *portPool) contains(port int) bool {
+	for _, candidate := range p.ports {
+		if candidate == port {
+			return true
+		}
+	}
+	return false
+}
+
+// lease 为 clientID 租用一个空闲且 allow 允许的端口，没有可用端口时返回 false
+func (p *portPool) lease(clientID string, allow func(port int) bool) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.ports {
+		idx := (p.next + i) % len(p.ports)
+		port := p.ports[idx]
+		if _, taken := p.leased[port]; taken || (allow != nil && !allow(port)) {
+			continue
+		}
+		p.leased[port] = clientID
+		p.next = idx + 1
+		return port, true
+	}
+	return 0, false
+}
+
+// leasePort 为 clientID 租用指定的池内端口，已被其他客户端租用时返回错误
+func (p *portPool) leasePort(clientID string, port int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if holder, taken := p.leased[port]; taken {
+		return fmt.Errorf("端口 %d 已被客户端 %s 租用", port, holder)
+	}
+	p.leased[port] = clientID
+	return nil
+}
+
+// release 归还端口
+func (p *portPool) release(port int) {
+	p.mu.Lock()
+	delete(p.leased, port)
+	p.mu.Unlock()
+}
+
+// stats 返回端口池的大小和已租用的端口数
+func (p *portPool) stats() (size, leased int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.ports), len(p.leased)
+}
+
+// pooledPort 返回客户端当前租用的池内端口（专用端口不是从端口池租用的返回 0）
+func (s *Server) pooledPort(clientInfo *ClientInfo) int {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	if l, ok := clientInfo.PublicListener.(*leasedListener); ok {
+		return l.port
+	}
+	return 0
+}
+
+// holdsPoolPort 判断客户端当前是否已租用池内端口 port
+func (s *Server) holdsPoolPort(clientInfo *ClientInfo, port int) bool {
+	return port != 0 && s.pooledPort(clientInfo) == port
+}
+
+// leasedListener 租用的池内端口的监听器，关闭时归还端口
+type leasedListener struct {
+	net.Listener
+	pool *portPool
+	port int
+	once sync.Once
+}
+
+// Close 关闭监听器并归还端口（重复关闭只归还一次）
+func (l *leasedListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() { l.pool.release(l.port) })
+	return err
+}
@@ -0,0 +1,102 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestPortPoolLease 测试端口池按轮转顺序租用空闲端口、跳过不允许的端口，已租用的端口不能再被租用，归还后可以重新租用
+func TestPortPoolLease(t *testing.T) {
+	pool := newPortPool([]int{20000, 20001, 20002})
+	if port, ok := pool.lease("client-1", nil); !ok || port != 20000 {
+		t.Fatalf("应租用第一个端口, 得到 %d, %v", port, ok)
+	}
+	if port, ok := pool.lease("client-2", func(port int) bool { return port != 20001 }); !ok || port != 20002 {
+		t.Fatalf("应跳过不允许的端口, 得到 %d, %v", port, ok)
+	}
+	if err := pool.leasePort("client-3", 20000); err == nil {
+		t.Fatal("已被租用的端口不应再被租用")
+	}
+	if port, ok := pool.lease("client-3", func(port int) bool { return port != 20001 }); ok {
+		t.Fatalf("没有允许的空闲端口时应租用失败, 得到 %d", port)
+	}
+
+	pool.release(20002)
+	if size, leased := pool.stats(); size != 3 || leased != 1 {
+		t.Errorf("端口池统计不正确: size=%d leased=%d", size, leased)
+	}
+	// 轮转：从上次租用的端口之后开始查找，刚归还的端口不会立即被再次选中
+	if port, ok := pool.lease("client-3", nil); !ok || port != 20001 {
+		t.Errorf("应从上次租用的端口之后开始查找, 得到 %d, %v", port, ok)
+	}
+	if port, ok := pool.lease("client-4", nil); !ok || port != 20002 {
+		t.Errorf("归还的端口应可以重新租用, 得到 %d, %v", port, ok)
+	}
+}
+
+// TestPortPoolInit 测试请求远程端口 0 的客户端从端口池租用端口，端口池耗尽时被拒绝，
+// 租用端口的客户端断开后端口归还给其他客户端
+func TestPortPoolInit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ports := []int{getFreePort(t), getFreePort(t)}
+	server := NewServer("127.0.0.1:0", "", WithServerPortPool(ports))
+
+	var clientIDs []string
+	for i := 0; i < 3; i++ {
+		serverSide, clientSide := net.Pipe()
+		defer clientSide.Close()
+		go io.Copy(io.Discard, clientSide)
+		clientID, err := server.registerClient(serverSide)
+		if err != nil {
+			t.Fatalf("注册客户端失败: %v", err)
+		}
+		clientIDs = append(clientIDs, clientID)
+	}
+	defer func() {
+		for _, clientID := range clientIDs {
+			server.unregisterClient(clientID)
+		}
+	}()
+	init := func(clientID string, port int) int {
+		frame := &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&proto.InitConfig{RemotePort: port, LocalAddr: "127.0.0.1:1"})}
+		if err := server.handleInitFrame(ctx, clientID, frame); err != nil {
+			t.Fatalf("处理 INIT 失败: %v", err)
+		}
+		for _, st := range server.ClientStatus() {
+			if st.ID == clientID {
+				return st.RemotePort
+			}
+		}
+		t.Fatalf("客户端 %s 不存在", clientID)
+		return 0
+	}
+
+	first := init(clientIDs[0], 0)
+	second := init(clientIDs[1], 0)
+	if first != ports[0] || second != ports[1] {
+		t.Fatalf("应依次租用端口池中的端口 %v, 得到 %d, %d", ports, first, second)
+	}
+	if got := init(clientIDs[0], 0); got != first {
+		t.Errorf("已租用端口的客户端重新发送 INIT 应保持原端口 %d, 得到 %d", first, got)
+	}
+	if got := init(clientIDs[2], 0); got != 0 {
+		t.Errorf("端口池耗尽时应拒绝, 得到端口 %d", got)
+	}
+	if got := init(clientIDs[2], first); got != 0 {
+		t.Errorf("已被其他客户端租用的端口应被拒绝, 得到端口 %d", got)
+	}
+
+	// 租用端口的客户端断开后端口归还
+	server.unregisterClient(clientIDs[0])
+	if got := init(clientIDs[2], 0); got != first {
+		t.Errorf("归还的端口应可以被其他客户端租用, 期望 %d, 得到 %d", first, got)
+	}
+	if _, leased := server.portPool.stats(); leased != 2 {
+		t.Errorf("应有 2 个端口被租用, 得到 %d", leased)
+	}
+}
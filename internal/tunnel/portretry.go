@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+)
+
+// 重连后的远程端口重试：隧道建立过之后，重连时服务器可能因端口已被占用等原因拒绝原来的远程端口。
+// 此时不让整个重连失败（控制连接、主机名路由和其他功能照常工作），而是记录该端口的错误，
+// 并在同一控制连接上每隔 remotePortRetryInterval 重新发送该端口的 INIT，直到服务器确认、
+// 远程端口被修改（SetRemotePort/RemoveTunnel）或控制连接关闭（新的控制连接重新开始）。
+// 首次连接时的拒绝仍按原来的方式处理（连接失败，按 reconnectDelay 重连）
+
+// remotePortRetryInterval 重连后被拒绝的远程端口重新发送 INIT 的间隔
+var remotePortRetryInterval = 5 * time.Second
+
+// rejectedOnReconnect 处理 setupTunnel 收到的端口拒绝：隧道建立过且被拒绝的是远程端口时记录错误并开始重试，返回 true
+// （调用方继续使用该控制连接），否则返回 false
+func (c *Client) rejectedOnReconnect(ctx context.Context, port int, reason string) bool {
+	if port <= 0 || !c.tunnelAssigned.Load() {
+		return false
+	}
+	c.controlMu.RLock()
+	controlConn := c.controlConn
+	c.controlMu.RUnlock()
+	if controlConn == nil {
+		return false
+	}
+
+	c.remotePortMu.Lock()
+	c.retryPort = port
+	c.remotePortMu.Unlock()
+	interval := remotePortRetryInterval
+	log.Printf("错误: 重连后服务器拒绝远程端口 %d: %s，保持控制连接，每 %v 重试该端口", port, reason, interval)
+	go c.retryRemotePort(ctx, controlConn, port, interval)
+	return true
+}
+
+// retryRemotePort 在控制连接 controlConn 上每隔 interval 重新发送远程端口 port 的 INIT（上一次重试尚未收到回复时跳过），
+// 直到不再需要重试（见 retryingPort）
+func (c *Client) retryRemotePort(ctx context.Context, controlConn net.Conn, port int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		retrying, pending := c.retryingPort(controlConn, port)
+		if !retrying {
+			return
+		}
+		if pending {
+			continue
+		}
+		if err := c.writeInit(port); err != nil {
+			log.Printf("重试远程端口 %d 失败: %v", port, err)
+			return
+		}
+	}
+}
+
+// retryingPort 返回控制连接 controlConn 上是否仍在重试远程端口 port，以及是否有尚未收到回复的 INIT
+func (c *Client) retryingPort(controlConn net.Conn, port int) (retrying, pending bool) {
+	c.controlMu.RLock()
+	current := c.controlConn
+	c.controlMu.RUnlock()
+	c.remotePortMu.Lock()
+	defer c.remotePortMu.Unlock()
+	return current == controlConn && c.retryPort == port, len(c.pendingPorts) > 0
+}
@@ -0,0 +1,142 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// NewPublicTLSConfig 创建全局公开端口终止 TLS 使用的配置（标准 TLS，非 PQC）
+// 通常使用通配符证书（例如 *.tunnel.example.com），按握手的 SNI 将连接路由到对应主机名的客户端
+func NewPublicTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := loadPublicCert(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// loadPublicCert 加载公开端口 TLS 的证书和私钥
+func loadPublicCert(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("公开端口 TLS 的证书和私钥必须同时指定")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载公开端口 TLS 证书失败: %w", err)
+	}
+	return &cert, nil
+}
+
+// PublicCertFiles 公开端口某个主机名使用的证书和私钥文件
+type PublicCertFiles struct {
+	Cert string
+	Key  string
+}
+
+// PublicCertStore 全局公开端口按握手的 SNI 选择的证书：每个主机名（支持 *.example.com 通配符）一张证书，
+// 另有可选的默认证书，用于没有匹配主机名或未发送 SNI 的连接。同一个公开端口上的多个租户因此可以各自使用自己的证书。
+// Load 整体替换证书（例如收到 SIGHUP 后增删租户或更新证书），之后的握手立即使用新证书，已建立的连接不受影响
+type PublicCertStore struct {
+	certs atomic.Pointer[publicCertSet]
+}
+
+// publicCertSet 一次加载的全部证书（加载后只读）
+type publicCertSet struct {
+	byHost   map[string]*tls.Certificate // 主机名路由键（小写）到证书
+	fallback *tls.Certificate            // 默认证书（nil 表示没有）
+}
+
+// NewPublicCertStore 加载默认证书（certFile、keyFile，可都留空）和按主机名的证书，至少需要其中一项
+func NewPublicCertStore(certFile, keyFile string, hosts map[string]PublicCertFiles) (*PublicCertStore, error) {
+	store := &PublicCertStore{}
+	if err := store.Load(certFile, keyFile, hosts); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Load 重新加载全部证书；任一证书加载失败时返回错误并保留原有证书
+func (c *PublicCertStore) Load(certFile, keyFile string, hosts map[string]PublicCertFiles) error {
+	if certFile == "" && keyFile == "" && len(hosts) == 0 {
+		return fmt.Errorf("公开端口 TLS 至少需要默认证书或一个主机名的证书")
+	}
+	set := &publicCertSet{byHost: make(map[string]*tls.Certificate, len(hosts))}
+	if certFile != "" || keyFile != "" {
+		cert, err := loadPublicCert(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		set.fallback = cert
+	}
+	for pattern, files := range hosts {
+		if err := ValidateHostnamePattern(pattern); err != nil {
+			return fmt.Errorf("公开端口 TLS 证书: %w", err)
+		}
+		cert, err := loadPublicCert(files.Cert, files.Key)
+		if err != nil {
+			return fmt.Errorf("主机名 %s: %w", pattern, err)
+		}
+		set.byHost[strings.ToLower(strings.TrimSuffix(pattern, "."))] = cert
+	}
+	c.certs.Store(set)
+	return nil
+}
+
+// Hostnames 返回配置了证书的主机名（已排序）
+func (c *PublicCertStore) Hostnames() []string {
+	set := c.certs.Load()
+	names := make([]string, 0, len(set.byHost))
+	for name := range set.byHost {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TLSConfig 返回按 SNI 选择证书的 TLS 配置，用于 WithServerPublicTLS
+func (c *PublicCertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// getCertificate 按 SNI 选择证书，规则与主机名路由相同：精确匹配优先，其次是最具体的通配符，都不匹配时使用默认证书
+func (c *PublicCertStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := c.certs.Load()
+	var best *tls.Certificate
+	bestScore := -1
+	if hello.ServerName != "" {
+		for pattern, cert := range set.byHost {
+			if score := matchHostname(pattern, hello.ServerName); score > bestScore {
+				best, bestScore = cert, score
+			}
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	if set.fallback != nil {
+		return set.fallback, nil
+	}
+	return nil, fmt.Errorf("没有主机名 %q 的证书", hello.ServerName)
+}
+
+// terminatePublicTLS 在公开连接上完成服务端 TLS 握手（最长等待 hostPeekTimeout），之后转发的是解密后的数据
+func terminatePublicTLS(conn net.Conn, cfg *tls.Config) (*tls.Conn, error) {
+	tlsConn := tls.Server(conn, cfg)
+	tlsConn.SetDeadline(time.Now().Add(hostPeekTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePublicCert 生成 CN 为 cn 的自签名证书，写入 dir 下的 cn.crt 和 cn.key
+func writePublicCert(t *testing.T, dir, cn string) PublicCertFiles {
+	t.Helper()
+	cert := generateSANCert(t, cn, cn)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("编码私钥失败: %v", err)
+	}
+	files := PublicCertFiles{Cert: filepath.Join(dir, cn+".crt"), Key: filepath.Join(dir, cn+".key")}
+	if err := os.WriteFile(files.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(files.Key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestPublicCertStore 测试按 SNI 选择证书（精确主机名优先于通配符，都不匹配时使用默认证书），
+// 以及重新加载时增删主机名、加载失败时保留原有证书
+func TestPublicCertStore(t *testing.T) {
+	dir := t.TempDir()
+	def := writePublicCert(t, dir, "default")
+	app := writePublicCert(t, dir, "app.example.com")
+	wild := writePublicCert(t, dir, "wild.example.com")
+	other := writePublicCert(t, dir, "other.example.net")
+
+	store, err := NewPublicCertStore(def.Cert, def.Key, map[string]PublicCertFiles{
+		"app.example.com": app,
+		"*.example.com":   wild,
+	})
+	if err != nil {
+		t.Fatalf("加载证书失败: %v", err)
+	}
+	selected := func(sni string) string {
+		cert, err := store.TLSConfig().GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+		if err != nil {
+			return "error"
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("解析证书失败: %v", err)
+		}
+		return leaf.Subject.CommonName
+	}
+	for sni, want := range map[string]string{
+		"app.example.com":   "app.example.com",
+		"APP.example.com.":  "app.example.com",
+		"x.example.com":     "wild.example.com",
+		"other.example.net": "default",
+		"":                  "default",
+	} {
+		if got := selected(sni); got != want {
+			t.Errorf("SNI %q 应使用证书 %s, 得到 %s", sni, want, got)
+		}
+	}
+
+	// 重新加载：删除通配符、增加新租户、去掉默认证书
+	if err := store.Load("", "", map[string]PublicCertFiles{"app.example.com": app, "other.example.net": other}); err != nil {
+		t.Fatalf("重新加载证书失败: %v", err)
+	}
+	if got := store.Hostnames(); len(got) != 2 || got[0] != "app.example.com" || got[1] != "other.example.net" {
+		t.Errorf("重新加载后的主机名: %v", got)
+	}
+	if got := selected("other.example.net"); got != "other.example.net" {
+		t.Errorf("新增的主机名应使用自己的证书, 得到 %s", got)
+	}
+	if got := selected("x.example.com"); got != "error" {
+		t.Errorf("没有匹配的证书也没有默认证书时握手应失败, 得到 %s", got)
+	}
+
+	// 加载失败时保留原有证书
+	if err := store.Load("", "", map[string]PublicCertFiles{"app.example.com": {Cert: filepath.Join(dir, "missing.crt"), Key: app.Key}}); err == nil {
+		t.Fatal("证书文件不存在时应返回错误")
+	}
+	if got := selected("other.example.net"); got != "other.example.net" {
+		t.Errorf("加载失败后应保留原有证书, 得到 %s", got)
+	}
+	if _, err := NewPublicCertStore("", "", nil); err == nil {
+		t.Error("没有任何证书时应返回错误")
+	}
+}
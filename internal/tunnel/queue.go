@@ -0,0 +1,251 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 公开连接队列满时的处理策略
+const (
+	QueuePolicyBlock  = "block"  // 阻塞 accept 循环直到队列有空位（默认）
+	QueuePolicyReject = "reject" // 立即关闭新连接
+)
+
+// 公开连接队列默认参数
+const (
+	defaultPublicQueueSize = 100
+	defaultPublicWorkers   = 8
+)
+
+// publicConnJob 表示一个待处理的公开连接
+type publicConnJob struct {
+	conn     net.Conn
+	clientID string
+	host     string // 全局监听器路由使用的主机名（SNI/Host，未探测时为空）
+}
+
+// initPublicQueue 创建公开连接队列（accept 循环 → 队列 → worker 路由 → handlePublicConnection，见 pipeline.go）
+// 设置了每客户端队列容量时，全局监听器的连接路由后先进入按客户端划分的公平队列，再由 worker 轮询各客户端处理
+func (s *Server) initPublicQueue() {
+	size := s.publicQueueSize
+	if size <= 0 {
+		size = defaultPublicQueueSize
+	}
+	s.publicConnQueue = make(chan publicConnJob, size)
+	if s.publicClientQueueSize > 0 {
+		s.publicFairQueue = newFairQueue(s.publicClientQueueSize)
+	}
+}
+
+// fairQueue 按客户端划分的有界公开连接队列，按轮询顺序出队
+// 共享全局监听器时，一个客户端的突发连接最多占用 perClient 个排队位置，其他客户端的连接不会排在其后等待
+type fairQueue struct {
+	perClient int
+	ready     chan struct{} // 有连接入队时发出通知（容量 1，worker 出队后如仍有剩余则再次通知）
+
+	mu       sync.Mutex
+	queues   map[string][]publicConnJob // map[clientID]待处理的连接
+	ring     []string                   // 有待处理连接的客户端，按轮询顺序排列
+	rejected map[string]uint64          // map[clientID]因队列满被关闭的连接数
+}
+
+// newFairQueue 创建每个客户端最多排队 perClient 个连接的公平队列
+func newFairQueue(perClient int) *fairQueue {
+	return &fairQueue{
+		perClient: perClient,
+		ready:     make(chan struct{}, 1),
+		queues:    make(map[string][]publicConnJob),
+		rejected:  make(map[string]uint64),
+	}
+}
+
+// push 将已路由的连接放入对应客户端的队列，该客户端的队列已满时返回 false（由调用方关闭连接）
+func (q *fairQueue) push(job publicConnJob) bool {
+	q.mu.Lock()
+	pending := q.queues[job.clientID]
+	if len(pending) >= q.perClient {
+		q.rejected[job.clientID]++
+		q.mu.Unlock()
+		return false
+	}
+	if len(pending) == 0 {
+		q.ring = append(q.ring, job.clientID)
+	}
+	q.queues[job.clientID] = append(pending, job)
+	q.mu.Unlock()
+	q.notify()
+	return true
+}
+
+// pop 取出轮询顺序中下一个客户端的最早连接，没有待处理的连接时返回 false
+func (q *fairQueue) pop() (publicConnJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.ring) == 0 {
+		return publicConnJob{}, false
+	}
+	clientID := q.ring[0]
+	q.ring = q.ring[1:]
+	pending := q.queues[clientID]
+	job := pending[0]
+	pending[0] = publicConnJob{}
+	if pending = pending[1:]; len(pending) > 0 {
+		// 该客户端仍有连接，排到轮询顺序末尾
+		q.queues[clientID] = pending
+		q.ring = append(q.ring, clientID)
+	} else {
+		delete(q.queues, clientID)
+	}
+	if len(q.ring) > 0 {
+		q.notify()
+	}
+	return job, true
+}
+
+// notify 唤醒一个等待的 worker（已有未处理的通知时不重复发送）
+func (q *fairQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// closeAll 关闭所有排队中的连接（服务器关闭时调用）
+func (q *fairQueue) closeAll() {
+	for {
+		job, ok := q.pop()
+		if !ok {
+			return
+		}
+		job.conn.Close()
+	}
+}
+
+// fairQueueStat 一个客户端的排队状态（用于指标）
+type fairQueueStat struct {
+	clientID string
+	depth    int
+	rejected uint64
+}
+
+// stats 返回各客户端的排队深度和拒绝数（按 clientID 排序，包含曾经拒绝过连接的客户端）
+func (q *fairQueue) stats() []fairQueueStat {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	byClient := make(map[string]*fairQueueStat)
+	for clientID, pending := range q.queues {
+		byClient[clientID] = &fairQueueStat{clientID: clientID, depth: len(pending)}
+	}
+	for clientID, n := range q.rejected {
+		st, ok := byClient[clientID]
+		if !ok {
+			st = &fairQueueStat{clientID: clientID}
+			byClient[clientID] = st
+		}
+		st.rejected = n
+	}
+	list := make([]fairQueueStat, 0, len(byClient))
+	for _, st := range byClient {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].clientID < list[j].clientID })
+	return list
+}
+
+// forget 移除已注销客户端的拒绝计数
+func (q *fairQueue) forget(clientID string) {
+	q.mu.Lock()
+	delete(q.rejected, clientID)
+	q.mu.Unlock()
+}
+
+// startPublicWorkers 启动处理公开连接的 worker
+func (s *Server) startPublicWorkers(ctx context.Context) {
+	workers := s.publicWorkers
+	if workers <= 0 {
+		workers = defaultPublicWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go s.publicWorker(ctx)
+	}
+}
+
+// publicWorker 从队列取出公开连接，经 routePublicConn 路由后交给 handlePublicConnection
+// 启用公平队列时优先处理公平队列中已路由的连接，全局监听器的连接路由后放入公平队列
+// 服务器关闭时关闭队列中剩余的连接
+func (s *Server) publicWorker(ctx context.Context) {
+	var ready chan struct{}
+	if s.publicFairQueue != nil {
+		ready = s.publicFairQueue.ready
+	}
+	for {
+		if s.publicFairQueue != nil {
+			if job, ok := s.publicFairQueue.pop(); ok {
+				s.handlePublicConnection(ctx, job.conn, job.clientID, job.host)
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case job := <-s.publicConnQueue:
+					job.conn.Close()
+				default:
+					if s.publicFairQueue != nil {
+						s.publicFairQueue.closeAll()
+					}
+					return
+				}
+			}
+		case <-ready:
+			// 公平队列有新连接，回到循环开头出队
+		case job := <-s.publicConnQueue:
+			global := job.clientID == ""
+			job, ok := s.routePublicConn(ctx, job)
+			if !ok {
+				continue
+			}
+			s.dispatchPublicConn(ctx, job, global)
+		}
+	}
+}
+
+// dispatchPublicConn 把已路由的公开连接交给转发阶段：全局监听器（global）的连接在启用公平队列时放入公平队列，
+// 其余直接由 handlePublicConnection 处理
+func (s *Server) dispatchPublicConn(ctx context.Context, job publicConnJob, global bool) {
+	if global && s.publicFairQueue != nil {
+		if !s.publicFairQueue.push(job) {
+			log.Printf("客户端的公开连接队列已满 (%d)，拒绝连接: %s (clientID=%s)", s.publicFairQueue.perClient, job.conn.RemoteAddr(), job.clientID)
+			job.conn.Close()
+		}
+		return
+	}
+	s.handlePublicConnection(ctx, job.conn, job.clientID, job.host)
+}
+
+// enqueuePublicConn 将公开连接放入队列，队列满时按策略阻塞或拒绝
+func (s *Server) enqueuePublicConn(ctx context.Context, conn net.Conn, clientID string) {
+	job := publicConnJob{conn: conn, clientID: clientID}
+
+	if s.publicQueuePolicy == QueuePolicyReject {
+		select {
+		case s.publicConnQueue <- job:
+		default:
+			atomic.AddUint64(&s.publicQueueRejected, 1)
+			log.Printf("公开连接队列已满，拒绝连接: %s (clientID=%s)", conn.RemoteAddr(), clientID)
+			conn.Close()
+		}
+		return
+	}
+
+	select {
+	case s.publicConnQueue <- job:
+	case <-ctx.Done():
+		conn.Close()
+	}
+}
@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 吞吐统计参数：每秒采样一次，指数加权移动平均的时间窗口为 10 秒
+const (
+	rateTickInterval = time.Second
+	rateWindow       = 10 * time.Second
+)
+
+// rateMeter 以指数加权移动平均（EWMA）统计字节吞吐率
+// add 在数据路径上调用（仅原子累加），tick 由定时器周期调用以更新速率
+type rateMeter struct {
+	pending uint64 // 自上次采样以来累计的字节数（原子操作）
+	total   uint64 // 累计总字节数（原子操作）
+
+	mu     sync.Mutex
+	rate   float64 // 当前速率（字节/秒）
+	primed bool    // 是否已有首个采样
+}
+
+// newRateMeter 创建吞吐统计器
+func newRateMeter() *rateMeter {
+	return &rateMeter{}
+}
+
+// add 记录 n 字节
+func (m *rateMeter) add(n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	atomic.AddUint64(&m.pending, uint64(n))
+	atomic.AddUint64(&m.total, uint64(n))
+}
+
+// tick 按采样间隔更新速率
+func (m *rateMeter) tick(interval time.Duration) {
+	if m == nil || interval <= 0 {
+		return
+	}
+	n := atomic.SwapUint64(&m.pending, 0)
+	instant := float64(n) / interval.Seconds()
+	alpha := 1 - math.Exp(-interval.Seconds()/rateWindow.Seconds())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.primed {
+		m.rate = instant
+		m.primed = true
+		return
+	}
+	m.rate += alpha * (instant - m.rate)
+}
+
+// Rate 返回当前速率（字节/秒）
+func (m *rateMeter) Rate() float64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rate
+}
+
+// Total 返回累计总字节数
+func (m *rateMeter) Total() uint64 {
+	if m == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.total)
+}
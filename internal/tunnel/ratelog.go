@@ -0,0 +1,46 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// noisyLogInterval 可由对端反复触发的日志（限速、未知帧类型等）的默认最小输出间隔
+const noisyLogInterval = 10 * time.Second
+
+// rateLimitedLog 限制同一类日志的输出频率，避免对端持续触发时刷屏
+// 间隔内被抑制的条数在下一次输出时一并报告。零值可用，间隔为 noisyLogInterval
+type rateLimitedLog struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// printf 输出日志，距上次输出不足 interval 时只计数
+func (l *rateLimitedLog) printf(format string, args ...interface{}) {
+	interval := l.interval
+	if interval == 0 {
+		interval = noisyLogInterval
+	}
+
+	l.mu.Lock()
+	if !l.last.IsZero() && time.Since(l.last) < interval {
+		l.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := l.suppressed
+	l.suppressed = 0
+	l.last = time.Now()
+	l.mu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if suppressed > 0 {
+		msg += fmt.Sprintf("（另有 %d 条相同日志被抑制）", suppressed)
+	}
+	log.Print(msg)
+}
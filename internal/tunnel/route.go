@@ -0,0 +1,102 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// LocalRoute 表示一条按来源地址选择本地服务的路由规则
+type LocalRoute struct {
+	Prefix netip.Prefix // 来源 IP 网段
+	Local  string       // 命中时连接的本地服务地址
+}
+
+// HostRoute 表示一条按公开连接的主机名（TLS SNI 或 HTTP Host）选择本地服务的路由规则
+// 服务器不终止公开连接的 TLS 时，ClientHello 原样转发到 Local，由本地服务使用自己的证书完成握手（SNI 透传）
+type HostRoute struct {
+	Hostname string // 主机名路由键（支持 *.example.com 通配符），同时注册到服务器
+	Local    string // 命中时连接的本地服务地址
+}
+
+// ParseHostRoute 解析主机名和本地地址为路由规则
+func ParseHostRoute(hostname, local string) (HostRoute, error) {
+	if err := ValidateHostnamePattern(hostname); err != nil {
+		return HostRoute{}, err
+	}
+	if local == "" {
+		return HostRoute{}, fmt.Errorf("主机名路由 %s 缺少本地地址", hostname)
+	}
+	return HostRoute{Hostname: hostname, Local: local}, nil
+}
+
+// ParseLocalRoute 解析 CIDR 和本地地址为路由规则
+func ParseLocalRoute(cidr, local string) (LocalRoute, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return LocalRoute{}, fmt.Errorf("无效的 CIDR %q: %w", cidr, err)
+	}
+	if local == "" {
+		return LocalRoute{}, fmt.Errorf("路由 %s 缺少本地地址", cidr)
+	}
+	return LocalRoute{Prefix: prefix.Masked(), Local: local}, nil
+}
+
+// initHostRoutes 将主机名路由规则的主机名合并到向服务器注册的主机名路由键中（重复的只注册一次）
+func (c *Client) initHostRoutes() {
+	if len(c.hostRoutes) == 0 {
+		return
+	}
+	hostnames := append([]string(nil), c.hostnames...)
+	for _, route := range c.hostRoutes {
+		registered := false
+		for _, hostname := range hostnames {
+			if strings.EqualFold(hostname, route.Hostname) {
+				registered = true
+				break
+			}
+		}
+		if !registered {
+			hostnames = append(hostnames, route.Hostname)
+		}
+	}
+	c.hostnames = hostnames
+}
+
+// selectLocalAddr 根据公开连接的主机名和来源地址选择本地服务地址
+// 主机名路由优先（精确匹配优先于通配符，与服务器选择客户端的规则相同），其次是来源路由，都未命中时使用 localAddr
+func (c *Client) selectLocalAddr(sourceAddr, host string) string {
+	if host != "" {
+		best, bestScore := "", -1
+		for _, route := range c.hostRoutes {
+			if score := matchHostname(route.Hostname, host); score > bestScore {
+				best, bestScore = route.Local, score
+			}
+		}
+		if bestScore >= 0 {
+			return best
+		}
+	}
+
+	if len(c.localRoutes) == 0 || sourceAddr == "" {
+		return c.localAddr
+	}
+
+	host, _, err := net.SplitHostPort(sourceAddr)
+	if err != nil {
+		host = sourceAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return c.localAddr
+	}
+	ip = ip.Unmap()
+
+	for _, route := range c.localRoutes {
+		if route.Prefix.Contains(ip) {
+			return route.Local
+		}
+	}
+	return c.localAddr
+}
@@ -0,0 +1,159 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"reverse-tunnel/internal/pqctls"
+)
+
+// rejectAuthFailed 控制连接握手成功但客户端身份未被策略允许
+const rejectAuthFailed = "auth_failed"
+
+// rejectFrameMAC 控制连接的帧完整性校验协商失败（客户端未启用或共享密钥不匹配）
+const rejectFrameMAC = "frame_mac_failed"
+
+// rejectDuplicateIdentity 重复身份策略为 reject-new 时，相同身份的客户端已在线
+const rejectDuplicateIdentity = "duplicate_identity"
+
+// rejectDecodeErrors 来源反复发送无法解码的控制帧，处于拒绝重连期间
+const rejectDecodeErrors = "decode_errors"
+
+// rejectInitBudget 控制连接在完成 INIT 之前超出了字节或时间预算，或 INIT 负载超过上限（见 initbudget.go）
+const rejectInitBudget = "init_budget"
+
+// handshakeRejectReasons 指标中始终输出的拒绝原因（保证时间序列稳定）
+var handshakeRejectReasons = []string{
+	pqctls.RejectNonPQC,
+	pqctls.RejectCertificate,
+	pqctls.RejectUnknownProtocol,
+	pqctls.RejectHandshake,
+	rejectAuthFailed,
+	rejectFrameMAC,
+	rejectDuplicateIdentity,
+	rejectDecodeErrors,
+	rejectInitBudget,
+}
+
+// SecurityRecord 表示一条被拒绝的控制连接记录（JSON Lines 格式，每次拒绝一行），供 IDS/SIEM 采集；
+// 启用 WithServerLogPeerCertChain 时还为每个注册成功的客户端写入一条 handshake_accepted 记录（携带证书链）
+type SecurityRecord struct {
+	Name     string            `json:"name,omitempty"` // 服务器实例名称（未配置时省略）
+	Time     time.Time         `json:"time"`
+	Event    string            `json:"event"`               // handshake_rejected | handshake_accepted
+	Source   string            `json:"source"`              // 对端地址
+	Reason   string            `json:"reason,omitempty"`    // non_pqc | cert_rejected | unknown_protocol | handshake_failed | auth_failed | frame_mac_failed | duplicate_identity | decode_errors | init_budget
+	Identity string            `json:"identity,omitempty"`  // reason 为 auth_failed 或 duplicate_identity 时的客户端身份，以及注册成功的客户端身份
+	ClientID string            `json:"client_id,omitempty"` // handshake_accepted 的客户端 ID
+	Chain    []CertificateInfo `json:"chain,omitempty"`     // handshake_accepted 时客户端的证书链（叶证书在前）
+	Error    string            `json:"error,omitempty"`
+}
+
+// securityLogger 记录被拒绝的控制连接握手：按原因计数，并在配置了输出时写入安全日志（与运行日志分离）
+type securityLogger struct {
+	mu      sync.Mutex
+	w       io.Writer         // 安全日志输出（nil 表示只计数）
+	name    string            // 服务器实例名称（写入每条记录）
+	rejects map[string]uint64 // 按原因统计的拒绝次数
+}
+
+// newSecurityLogger 创建安全日志记录器，w 为 nil 时只统计拒绝次数
+func newSecurityLogger(w io.Writer) *securityLogger {
+	return &securityLogger{w: w, rejects: make(map[string]uint64)}
+}
+
+// handshakeError 判断控制监听器的 accept 错误是否为被拒绝的握手
+func handshakeError(err error) (*pqctls.HandshakeError, bool) {
+	var hsErr *pqctls.HandshakeError
+	if errors.As(err, &hsErr) {
+		return hsErr, true
+	}
+	return nil, false
+}
+
+// rejected 记录一次被拒绝的控制连接
+func (l *securityLogger) rejected(source net.Addr, reason, identity string, err error) {
+	addr := ""
+	if source != nil {
+		addr = source.String()
+	}
+	log.Printf("拒绝控制连接 %s (原因=%s): %v", addr, reason, err)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rejects[reason]++
+	if l.w == nil {
+		return
+	}
+
+	l.write(&SecurityRecord{
+		Name:     l.name,
+		Time:     time.Now(),
+		Event:    "handshake_rejected",
+		Source:   addr,
+		Reason:   reason,
+		Identity: identity,
+		Error:    err.Error(),
+	})
+}
+
+// accepted 记录一个注册成功的客户端及其证书链（未配置输出时忽略）
+func (l *securityLogger) accepted(source net.Addr, clientID, identity string, chain []CertificateInfo) {
+	addr := ""
+	if source != nil {
+		addr = source.String()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		return
+	}
+	l.write(&SecurityRecord{
+		Name:     l.name,
+		Time:     time.Now(),
+		Event:    "handshake_accepted",
+		Source:   addr,
+		Identity: identity,
+		ClientID: clientID,
+		Chain:    chain,
+	})
+}
+
+// write 写入一条安全日志记录，调用方持有 l.mu
+func (l *securityLogger) write(rec *SecurityRecord) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("编码安全日志失败: %v", err)
+		return
+	}
+	if _, err := l.w.Write(append(data, '\n')); err != nil {
+		log.Printf("写入安全日志失败: %v", err)
+	}
+}
+
+// rejectCounts 返回按原因统计的拒绝次数（已知原因始终包含，未出现过的为 0），按原因排序
+func (l *securityLogger) rejectCounts() ([]string, map[string]uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := make(map[string]uint64, len(handshakeRejectReasons))
+	for _, reason := range handshakeRejectReasons {
+		counts[reason] = 0
+	}
+	for reason, n := range l.rejects {
+		counts[reason] = n
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	return reasons, counts
+}
@@ -2,27 +2,97 @@ package tunnel
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
-	"strings"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"reverse-tunnel/internal/proto"
-	"reverse-tunnel/internal/pqctls"
 )
 
+// 控制连接帧速率超限时的策略
+const (
+	FrameRatePolicyThrottle = "throttle" // 延迟处理超出的帧（默认）
+	FrameRatePolicyDrop     = "drop"     // 断开控制连接
+)
+
+// PublicErrorResponseHTTP 客户端连接本地服务失败时向外部连接回复 HTTP 502、本地服务熔断中时回复 HTTP 503（默认不回复，直接关闭）
+const PublicErrorResponseHTTP = "http"
+
+// publicErrorWriteTimeout 向外部连接写入错误响应的超时
+const publicErrorWriteTimeout = time.Second
+
+// httpBadGatewayResponse 连接本地服务失败时回复给外部连接的最小 HTTP 响应
+var httpBadGatewayResponse = func() string {
+	body := "502 Bad Gateway: the tunnel client could not connect to the local service\n"
+	return fmt.Sprintf("HTTP/1.1 502 Bad Gateway\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+}()
+
+// httpBackendUnavailableResponse 客户端的本地服务熔断中（CloseUnavailable）时回复给外部连接的最小 HTTP 响应
+var httpBackendUnavailableResponse = func() string {
+	body := "503 Service Unavailable: the local service behind the tunnel is temporarily unavailable\n"
+	return fmt.Sprintf("HTTP/1.1 503 Service Unavailable\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+}()
+
+// defaultShutdownTimeout 关闭时清理资源的默认最长时间
+const defaultShutdownTimeout = 10 * time.Second
+
+// afterPublicListen INIT 处理创建专用公开端口监听器后、重新确认客户端状态前调用（测试用于在绑定期间注销客户端，nil 表示不调用）
+var afterPublicListen func(clientID string)
+
 // ClientInfo 表示一个客户端的信息
+// LocalAddr、RemotePort、PublicListener、Hostnames、Weight 在注册后由 INIT 处理更新，并被其他 goroutine 并发读取，读写时需持有 Server.clientsMu
 type ClientInfo struct {
-	ID           string      // 客户端唯一标识
-	Conn         net.Conn    // 控制连接
-	ConnMap      sync.Map    // map[uint32]net.Conn - 该客户端的连接映射
-	NextConnID   uint32      // 该客户端的下一个连接ID
-	LocalAddr    string      // 客户端本地地址（从INIT帧获取）
-	RemotePort   int         // 客户端指定的远程端口
-	PublicListener net.Listener // 该客户端专用的公开端口监听器（如果指定了远程端口）
+	ID             string         // 客户端唯一标识
+	Conn           net.Conn       // 控制连接
+	ConnMap        sync.Map       // map[uint32]*trackedConn - 该客户端的连接映射
+	NextConnID     uint32         // 该客户端最近分配的连接ID（只增不减，见 allocConnID）
+	LocalAddr      string         // 客户端本地地址（从INIT帧获取）
+	RemotePort     int            // 客户端指定的远程端口
+	PublicListener net.Listener   // 该客户端专用的公开端口监听器（如果指定了远程端口）
+	ConnectedAt    time.Time      // 控制连接建立时间
+	Hostnames      []string       // 主机名路由键（从INIT帧获取，支持 *.example.com 通配符）
+	Identity       string         // 客户端身份（客户端证书的 CN，非 TLS 连接为空）
+	Features       proto.Features // 特性协商结果（双方都支持的可选特性，未进行 HELLO 协商时为 0）
+	Weight         int            // 负载均衡权重（从INIT帧获取，0 表示默认权重 1），多个客户端匹配同一路由键时按权重分配公开连接
+
+	balance int // 平滑加权轮询的当前值（见 pickWeighted），读写时需持有 Server.balanceMu
+
+	healthProbe atomic.Uint32 // 尚未回复的健康检查序号（0 表示没有），见 health.go
+	unhealthy   atomic.Bool   // 最近一次健康检查报告本地服务不可用（或未按时回复），不参与全局公开端口的路由
+	paused      atomic.Bool   // 管理接口暂停了该客户端的转发（见 pause.go）
+
+	negotiated bool // 是否已完成特性协商（HELLO）
+
+	connIDExhausted atomic.Bool // connID 空间已用尽，已要求客户端重建控制连接（见 allocConnID）
+
+	exited atomic.Bool // 客户端发送了 BYE（正常退出），注销时据此区分意外断开
+
+	greeted  atomic.Bool // 已收到 HELLO 或 INIT（见 inittimeout.go）
+	initDone atomic.Bool // 已完成 INIT（见 initbudget.go）
+
+	maxData atomic.Uint32 // HELLO 协商的 DATA 帧负载上限（字节，双方上限的较小值，未协商时为 0），读取循环据此在分配缓冲区之前拒绝帧，见 maxdata.go
+
+	acceptLoops sync.WaitGroup // 该客户端的公开端口 accept 循环（注销时等待其退出）
+
+	tenant  *tenant       // 该身份的配额状态（未配置策略时为 nil）
+	writeMu controlWriter // 串行化控制连接上的帧写入并统计写入阻塞（见 writeFrame）
+
+	inRate  *rateMeter // 从公开连接收到的字节吞吐
+	outRate *rateMeter // 写入公开连接的字节吞吐
+
+	usage *identityCounters // 该身份跨重连的累计用量（见 metering.go）
+
+	setups *setupLimiter // 已发送 NEW_CONN、尚未确认建立的连接数及其上限（见 setuplimit.go）
 }
 
 // Server 表示反向隧道服务器
@@ -30,41 +100,286 @@ type Server struct {
 	controlListenAddr string // 控制端口监听地址
 	publicListenAddr  string // 公开端口监听地址（可选，如果为空则由客户端指定）
 
+	// 客户端指定的远程端口绑定的 IP 地址（空表示所有接口）
+	clientPortBindAddr string
+	// 租给客户端的固定远程端口池（nil 表示不启用），见 portpool.go
+	portPool *portPool
+
+	// 实例名称（可选），作为 name 标签附加到所有指标，并写入访问日志和安全日志记录
+	name string
+
 	// PQC mTLS 配置（可选）
 	useTLS     bool
 	tlsCertFile string
 	tlsKeyFile  string
 	tlsCAFile   string
+	// 是否允许 TLS 会话恢复（默认禁用，每次重连都进行完整的 PQC 握手）
+	tlsSessionResumption bool
+	// 是否不要求客户端证书（默认要求，即 mTLS；不要求时没有证书的客户端身份为空）
+	tlsClientCertOptional bool
+	// 要求的最低 NIST 安全级别（0 表示接受全部 ML-KEM/ML-DSA 参数集）
+	tlsMinSecurityLevel int
+	// 同时进行的 PQC mTLS 握手数上限（0 表示不并发握手）及达到上限时的策略
+	maxHandshakes        int
+	handshakeLimitPolicy string
+	handshakeStats       handshakeLimitStats
+
+	// 控制连接的传输（可选，nil 表示按是否启用 TLS 选择 PQC mTLS 或纯 TCP）
+	transport Transport
+	// 控制端口和公开端口监听 socket 的缓冲区大小（零值表示系统默认，接受的连接继承该设置）
+	socketBuffers SocketBuffers
+
+	// 外部提供的控制/全局公开端口监听器（可选，nil 表示按地址监听 TCP），用于替换传输（例如测试中的内存管道）
+	injectedControlListener net.Listener
+	injectedPublicListener  net.Listener
+
+	// 全局公开端口终止 TLS 使用的配置（nil 表示原样转发）
+	publicTLS *tls.Config
+	// 按 SNI 选择的公开端口证书（nil 表示未使用，证书不能重新加载），见 publictls.go
+	publicCerts *PublicCertStore
 
 	// 多客户端支持：管理所有客户端连接
 	clients     map[string]*ClientInfo // map[clientID]*ClientInfo
 	clientsMu   sync.RWMutex
+	// 串行化平滑加权轮询的状态更新（ClientInfo.balance，路由时只持有 clientsMu 读锁）
+	balanceMu sync.Mutex
+	// 客户端注册或更新路由键时关闭并替换（唤醒等待客户端的公开连接），读写时需持有 clientsMu，见 noclient.go
+	clientsChanged chan struct{}
+
+	// 全局公开端口没有可用客户端时的策略（空表示 NoClientPolicyClose）及 NoClientPolicyHold 的最长等待时间（0 表示默认值）
+	noClientPolicy      string
+	noClientHoldTimeout time.Duration
+	// NoClientPolicyHold 同时等待客户端的连接数上限（0 表示默认值），见 noclient.go
+	noClientMaxHolds      int
+	noClientHolds         atomic.Int64
+	noClientHoldRejected  atomic.Uint64 // 因等待的连接数达到上限被关闭的公开连接数
+	noClientHoldRejectLog rateLimitedLog
+	// 没有可用客户端时回复的静态 HTTP 页面（nil 表示按 noClientPolicy 处理），见 fallback.go
+	publicFallback       *PublicFallback
+	publicFallbackServed atomic.Uint64 // 回复了静态页面的公开连接数
+	// 客户端暂停期间新公开连接的策略（空表示 PausePolicyReject）及 PausePolicyHold 的最长等待时间（0 表示默认值），见 pause.go
+	pausePolicy      string
+	pauseHoldTimeout time.Duration
+	pausedRejected   atomic.Uint64 // 因客户端暂停被关闭的公开连接数
+	pauseRejectLog   rateLimitedLog
 	
+	// 客户端连接本地服务失败时向外部连接回复的错误响应（空表示直接关闭，PublicErrorResponseHTTP 表示回复 HTTP 502）
+	publicErrorResponse string
+	// 单个公开连接最多传输的字节数（两个方向合计，0 表示不限制）
+	maxBytesPerConn int64
+	// 向客户端发送健康检查的间隔（0 表示不检查），见 health.go
+	healthCheckInterval time.Duration
+	// 控制连接帧跟踪（可选，nil 表示不记录），见 frametrace.go
+	frameTracer FrameTracer
+	// 控制连接帧完整性校验的共享密钥（未启用 TLS 时可选，nil 表示不启用，见 framemac.go）
+	frameMACKey []byte
+
+	// 客户端必须支持的协议特性（0 表示不要求，未进行特性协商的客户端照常使用）
+	requiredFeatures proto.Features
+	// 本端能接收的 DATA 帧负载上限（字节，0 表示 dataChunkSize），在 HELLO 中与客户端协商，见 maxdata.go
+	maxDataPayload int
+	// 未配置全局公开端口时拒绝没有请求远程端口的客户端（默认只记录警告）
+	requirePublicEndpoint bool
+	// 未配置全局公开端口时等待客户端请求远程端口的时间（0 表示 defaultPublicEndpointGrace），见 endpoint.go
+	publicEndpointGrace time.Duration
+
 	// 全局公开端口监听器（如果服务器指定了公开端口，所有客户端共享）
 	publicListener net.Listener
 	publicListenerMu sync.RWMutex
 	
-	// 公开连接通道（用于全局监听器）
-	publicConnChan chan net.Conn
+	// 公开连接队列：accept 循环放入，worker 取出后调用 handlePublicConnection
+	publicConnQueue     chan publicConnJob
+	publicQueueSize     int    // 队列容量（0 表示默认值）
+	publicQueuePolicy   string // 队列满时的策略（block / reject）
+	publicWorkers       int    // worker 数量（0 表示默认值）
+	publicQueueRejected uint64 // 因队列满被拒绝的连接数（原子操作）
+
+	// 按来源 IP 限制公开连接（nil 表示不限制，由下面的设置在构造时生成），见 sourcelimit.go
+	sourceLimit          *sourceLimiter
+	publicSourceMaxConns int // 每个来源同时打开的连接数上限
+	publicSourceRate     int // 每个来源每秒新建连接数
+	publicSourceBurst    int // 每个来源允许的突发连接数
+
+	// 按客户端限制全局公开端口上的份额（nil 表示不限制，由下面的设置在构造时生成），见 sharelimit.go
+	clientShare         *clientShareLimiter
+	clientShareMaxConns int // 每个客户端同时打开的连接数上限
+	clientShareRate     int // 每个客户端每秒新建连接数
+
+	// 转发公开连接数据的 goroutine（每个公开连接一个 forwardPublicConn）及其上限，见 pipeline.go
+	maxForwarders      int          // 上限（0 表示不限制）
+	activeForwarders   atomic.Int64 // 当前的转发 goroutine 数（含已占用名额、正在等待首字节或发送 NEW_CONN 的连接）
+	forwardersRejected atomic.Uint64
+	forwarderRejectLog rateLimitedLog
+
+	// 发送 NEW_CONN 后等待客户端建立本地连接的最长时间（0 表示不限制）及超时关闭的公开连接数，见 connsetup.go
+	connSetupTimeout  time.Duration
+	connSetupTimeouts atomic.Uint64
+	// 每个客户端建立中（已发送 NEW_CONN、尚未确认）的连接数上限（0 表示不限制），达到上限时的等待次数、
+	// 等待超时关闭的公开连接数，见 setuplimit.go
+	maxPendingNewConns int
+	setupBackpressure  atomic.Uint64
+	setupRejected      atomic.Uint64
+	setupRejectLog     rateLimitedLog
+	// 观察者连接数上限（0 表示不接受观察者）和当前的观察者，见 observer.go
+	maxObservers int
+	observers    observerSet
+	// 控制连接建立后等待首个 HELLO 或 INIT 的宽限期（0 表示不限制）及因此断开的控制连接数，见 inittimeout.go
+	initTimeout  time.Duration
+	initTimeouts atomic.Uint64
+	// 公开连接因发送窗口用完而暂停读取的次数（协商了 flow_control 的客户端），见 flowcontrol.go
+	flowStalls atomic.Uint64
+	// 完成 INIT 之前的握手预算：INIT 负载上限（0 表示 proto.MaxInitPayloadSize）、读取的字节数上限和时限（0 表示不限制），见 initbudget.go
+	initMaxPayload    int
+	initBudgetBytes   int
+	initBudgetTimeout time.Duration
+	// 按归类统计关闭的公开连接数，见 closereason.go
+	closeCategories closeCategoryStats
+	// 公开连接的空闲超时和最长存活时间（0 表示不限制），与建立超时一起由 connDeadlines 管理，见 deadlines.go
+	connIdleTimeout time.Duration
+	connMaxLifetime time.Duration
+
+	// 全局监听器按客户端划分的公平队列（publicClientQueueSize 为 0 时为 nil，路由后直接处理）
+	publicFairQueue       *fairQueue
+	publicClientQueueSize int // 每个客户端最多排队的连接数
+
+	// 按帧类型和处理结果的计数（/metrics 输出）
+	frameStats frameStats
+	// DATA 帧负载大小直方图（/metrics 输出，nil 表示未启用），见 payloadsize.go
+	payloadSizes *payloadHistogram
+	// 按类别的控制帧解码错误计数，及反复出现解码错误的来源的重连限制，见 decodeerr.go
+	decodeErrors decodeErrorTracker
 	
 	// 下一个客户端ID
 	nextClientID uint32
+	// 每个客户端的 connID 从随机起点开始（默认从 1 开始），见 connIDBase
+	randomConnIDBase bool
+	// 为 HTTP 公开连接的第一个请求注入 W3C traceparent（沿用请求携带的 trace-id），见 injectTraceparent
+	traceContext bool
+	// 按内容类型 gzip 压缩 HTTP/1.1 公开连接的响应，见 compressConn
+	httpCompression bool
+	httpCompressed  atomic.Uint64 // 压缩的响应数
+	// 公开连接发送第一个字节后才发送 NEW_CONN，及等待首字节的最长时间，见 awaitFirstByte
+	lazyNewConn        bool
+	lazyNewConnTimeout time.Duration
+	lazyConnsDropped   atomic.Uint64 // 未发送数据即断开或超时的公开连接数
+	lazyDropLog        rateLimitedLog
+
+	// 监听使用的网络类型（tcp 双栈 / tcp4 / tcp6，空表示 tcp）
+	network string
+
+	// 以下设置可在运行时修改（见 settings.go），读写时需持有 settingsMu
+	settingsMu sync.RWMutex
+	// 控制连接单帧写入超时（0 表示不设超时）
+	controlWriteTimeout time.Duration
+	// 控制连接最大存活时间（0 表示不限制）
+	maxControlConnLifetime time.Duration
+	// 关闭时清理资源的最长时间（0 表示 defaultShutdownTimeout）
+	shutdownTimeout time.Duration
+	// 每个控制连接每秒最多处理的帧数（0 表示不限制）及超出时的策略（throttle / drop）
+	maxFrameRate    int
+	frameRatePolicy string
+
+	// 公开连接访问日志（可选，nil 表示不记录）
+	accessLog *accessLogger
+	// 被拒绝的控制连接握手的安全日志输出（可选，nil 表示只计数），及由其生成的记录器
+	securityLogWriter io.Writer
+	securityLog       *securityLogger
+	logPeerCertChain  bool // 客户端注册成功时记录其证书链（WithServerLogPeerCertChain）
+
+	// 辅助监听器（指标/状态）地址，及其绑定失败时是否使 Run 失败
+	metricsListenAddr  string
+	strictAuxListeners bool
+
+	// 是否在辅助监听器上挂载 pprof，及访问所需的管理令牌
+	enablePprof bool
+	adminToken  string
+
+	// 是否在辅助监听器上挂载内置 HTML 状态页（同样需要管理令牌）
+	enableStatusUI bool
+
+	// 按客户端身份的配额策略（可选，nil 表示不限制），及每个身份共享的配额状态
+	// 策略可在运行时从 policyFile 重新加载，读写时需持有 policyMu
+	policy       *PolicyStore
+	policyMu     sync.RWMutex
+	policyFile   string // 重新加载策略使用的文件（空表示不支持重新加载）
+	policyRevoke bool   // 重新加载后断开已被撤销身份的在线客户端（运行时可修改，读写时需持有 settingsMu）
+	tenants      map[string]*tenant
+	tenantsMu    sync.Mutex
+
+	// 相同身份的客户端重复连接时的策略（空表示 DuplicateIdentityAllow）
+	duplicateIdentityPolicy string
+
+	// 全局公开端口的路由策略（空表示未配置：多个客户端匹配同一连接时拒绝该连接，见 pickGlobalClient）
+	globalRouting        string
+	ambiguousRouteWarnAt atomic.Int64 // 上次记录路由歧义警告的时间（UnixNano），限制警告频率
+
+	// 端口分配通知（写文件/webhook/回调，可选，nil 表示不通知），由构造时的选项生成
+	portNotifier      *portNotifier
+	portNotifyFile    string
+	portNotifyWebhook string
+	portCallback      func(PortEvent)
+
+	// 按客户端身份的累计用量（GET /metering），meteringFile/meteringWebhook 非空时周期性写入/推送快照，由构造时的选项生成
+	meter            *meter
+	meteringFile     string
+	meteringWebhook  string
+	meteringInterval time.Duration
+
+	// 生命周期事件导出（可选，nil 表示不导出），由构造时的选项生成
+	events    *eventExporter
+	eventSink EventSink
+
+	// OpenTelemetry 指标推送（可选，nil 表示不推送），由构造时的选项生成
+	otlp       *otlpExporter
+	otlpConfig OTLPConfig
+
+	// Run 的运行状态（用于 Shutdown）
+	run runState
+
+	// 服务器是否正在关闭（原子操作，用于区分连接关闭原因）
+	shuttingDown int32
+
+	// 全局吞吐统计
+	inRate  *rateMeter
+	outRate *rateMeter
 }
 
 // NewServer 创建一个新的服务器实例
-func NewServer(controlListenAddr, publicListenAddr string) *Server {
-	return &Server{
+func NewServer(controlListenAddr, publicListenAddr string, opts ...ServerOption) *Server {
+	s := &Server{
 		controlListenAddr: controlListenAddr,
 		publicListenAddr:  publicListenAddr,
 		useTLS:            false,
 		clients:           make(map[string]*ClientInfo),
-		publicConnChan:    make(chan net.Conn, 100), // 缓冲通道，支持多个连接
+		inRate:            newRateMeter(),
+		outRate:           newRateMeter(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.initPublicQueue()
+	s.sourceLimit = newSourceLimiter(s.publicSourceMaxConns, s.publicSourceRate, s.publicSourceBurst)
+	s.clientShare = newClientShareLimiter(s.clientShareMaxConns, s.clientShareRate)
+	s.portNotifier = newPortNotifier(s.portNotifyFile, s.portNotifyWebhook, s.portCallback)
+	s.meter = newMeter(s.meteringFile, s.meteringWebhook, s.meteringInterval)
+	s.events = newEventExporter(s.eventSink)
+	s.otlp = newOTLPExporter(s.otlpConfig, "reverse-tunnel-server", s.name, s.writeMetrics)
+	s.initRecordLoggers()
+	return s
+}
+
+// initRecordLoggers 在应用选项后创建安全日志记录器，并为访问日志和安全日志设置实例名称
+func (s *Server) initRecordLoggers() {
+	s.securityLog = newSecurityLogger(s.securityLogWriter)
+	s.securityLog.name = s.name
+	if s.accessLog != nil {
+		s.accessLog.name = s.name
 	}
 }
 
 // NewServerWithTLS 创建一个启用 PQC mTLS 的服务器实例
-func NewServerWithTLS(controlListenAddr, publicListenAddr, certFile, keyFile, caFile string) *Server {
-	return &Server{
+func NewServerWithTLS(controlListenAddr, publicListenAddr, certFile, keyFile, caFile string, opts ...ServerOption) *Server {
+	s := &Server{
 		controlListenAddr: controlListenAddr,
 		publicListenAddr:  publicListenAddr,
 		useTLS:            true,
@@ -72,43 +387,86 @@ func NewServerWithTLS(controlListenAddr, publicListenAddr, certFile, keyFile, ca
 		tlsKeyFile:        keyFile,
 		tlsCAFile:         caFile,
 		clients:           make(map[string]*ClientInfo),
-		publicConnChan:    make(chan net.Conn, 100), // 缓冲通道，支持多个连接
+		inRate:            newRateMeter(),
+		outRate:           newRateMeter(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.initPublicQueue()
+	s.sourceLimit = newSourceLimiter(s.publicSourceMaxConns, s.publicSourceRate, s.publicSourceBurst)
+	s.clientShare = newClientShareLimiter(s.clientShareMaxConns, s.clientShareRate)
+	s.portNotifier = newPortNotifier(s.portNotifyFile, s.portNotifyWebhook, s.portCallback)
+	s.meter = newMeter(s.meteringFile, s.meteringWebhook, s.meteringInterval)
+	s.events = newEventExporter(s.eventSink)
+	s.otlp = newOTLPExporter(s.otlpConfig, "reverse-tunnel-server", s.name, s.writeMetrics)
+	s.initRecordLoggers()
+	return s
 }
 
-// Run 启动服务器，监听控制端口和公开端口
+// controlTransport 返回控制连接使用的传输：显式设置的传输优先，否则按是否启用 TLS 选择 PQC mTLS 或纯 TCP
+func (s *Server) controlTransport() Transport {
+	if s.transport != nil {
+		return s.transport
+	}
+	if s.useTLS {
+		return &PQCTLSTransport{
+			CertFile:           s.tlsCertFile,
+			KeyFile:            s.tlsKeyFile,
+			CAFile:             s.tlsCAFile,
+			SessionResumption:  s.tlsSessionResumption,
+			ClientCertOptional: s.tlsClientCertOptional,
+			MinSecurityLevel:   s.tlsMinSecurityLevel,
+			SocketBuffers:      s.socketBuffers,
+
+			MaxHandshakes:        s.maxHandshakes,
+			HandshakeLimitPolicy: s.handshakeLimitPolicy,
+			handshakeStats:       &s.handshakeStats,
+		}
+	}
+	return TCPTransport{SocketBuffers: s.socketBuffers}
+}
+
+// Run 启动服务器，监听控制端口和公开端口，直到 ctx 取消或调用 Shutdown，清理完成后返回
 func (s *Server) Run(ctx context.Context) error {
+	ctx, stop := s.run.start(ctx)
+	defer stop()
+
 	// 启动控制端口监听器（支持 TLS）
 	var controlListener net.Listener
 	var err error
 
-	if s.useTLS {
-		// 使用 PQC mTLS（通过 OpenSSL）
-		baseListener, err := net.Listen("tcp", s.controlListenAddr)
-		if err != nil {
+	if s.injectedControlListener != nil {
+		// 使用外部提供的监听器（原样使用，不做 TLS 封装）
+		controlListener = s.injectedControlListener
+		log.Printf("控制端口监听器已启动 (外部传输): %s", controlListener.Addr())
+	} else {
+		if err := s.checkAnonymousPolicy(s.currentPolicy()); err != nil {
 			return err
 		}
-
-		controlListener, err = pqctls.NewPQCListenerOpenSSL(baseListener, s.tlsCertFile, s.tlsKeyFile, s.tlsCAFile)
-		if err != nil {
-			baseListener.Close()
-			return fmt.Errorf("创建 PQC TLS 监听器失败: %v", err)
-		}
-		log.Printf("控制端口监听器已启动 (PQC mTLS via OpenSSL): %s", s.controlListenAddr)
-	} else {
-		// 使用纯 TCP
-		controlListener, err = net.Listen("tcp", s.controlListenAddr)
+		transport := s.controlTransport()
+		controlListener, err = transport.Listen(s.listenNetwork(), s.controlListenAddr)
 		if err != nil {
 			return err
 		}
-		log.Printf("控制端口监听器已启动: %s", s.controlListenAddr)
+		log.Printf("控制端口监听器已启动 (%s): %s", transport, s.controlListenAddr)
+		if s.useTLS && s.tlsSessionResumption {
+			log.Printf("TLS 会话恢复: 已启用")
+		}
+		if s.useTLS && s.tlsClientCertOptional {
+			log.Printf("未要求客户端证书（单向 TLS），没有证书的客户端身份为空，由配额策略拒绝")
+		}
 	}
 	defer controlListener.Close()
 
 	// 启动公开端口监听器（如果已指定）
 	var publicListener net.Listener
-	if s.publicListenAddr != "" {
-		publicListener, err = net.Listen("tcp", s.publicListenAddr)
+	if s.injectedPublicListener != nil {
+		publicListener = s.injectedPublicListener
+		defer publicListener.Close()
+		log.Printf("公开端口监听器已启动 (外部传输): %s", publicListener.Addr())
+	} else if s.publicListenAddr != "" {
+		publicListener, err = s.socketBuffers.listen(s.listenNetwork(), s.publicListenAddr)
 		if err != nil {
 			return err
 		}
@@ -118,16 +476,40 @@ func (s *Server) Run(ctx context.Context) error {
 		log.Printf("公开端口未指定，等待客户端配置...")
 	}
 
+	// 处理公开连接的 worker
+	s.startPublicWorkers(ctx)
+
 	// 处理公开端口连接的 goroutine（如果已启动全局监听器）
 	if publicListener != nil {
 		s.publicListenerMu.Lock()
 		s.publicListener = publicListener
 		s.publicListenerMu.Unlock()
-		go s.acceptPublicConnections(ctx, publicListener)
+		go s.acceptPublicConnections(ctx, publicListener, "")
+	}
+
+	// 辅助监听器（可选，失败不影响隧道服务，除非启用严格模式）
+	if err := s.startAuxListeners(ctx); err != nil {
+		return err
+	}
+
+	// 吞吐统计定时器
+	go s.runRateTicker(ctx)
+
+	// 客户端本地服务的健康检查（可选）
+	if s.healthCheckInterval > 0 {
+		go s.runHealthChecks(ctx)
 	}
 
+	// 端口分配通知
+	go s.portNotifier.run(ctx)
+	go s.meter.run(ctx)
+	go s.events.run(ctx)
+	go s.otlp.run(ctx)
+	go s.watchPolicyFile(ctx)
+
 	// 持续接受客户端连接的 goroutine
 	go func() {
+		var backoff acceptBackoff
 		for {
 			select {
 			case <-ctx.Done():
@@ -136,19 +518,32 @@ func (s *Server) Run(ctx context.Context) error {
 				log.Printf("等待 client 连接...")
 				conn, err := controlListener.Accept()
 				if err != nil {
-					if ctx.Err() != nil {
+					// 被拒绝的握手只影响该对端，记录安全日志后继续接受
+					if hsErr, ok := handshakeError(err); ok {
+						s.securityLog.rejected(hsErr.RemoteAddr, hsErr.Reason, "", hsErr.Err)
+						continue
+					}
+					if !backoff.handle(ctx, err, "控制连接") {
 						return
 					}
-					log.Printf("接受控制连接错误: %v", err)
 					continue
 				}
-				
-				// 为新客户端分配ID并注册
-				clientID := s.registerClient(conn)
-				log.Printf("客户端已连接: %s (clientID=%s)", conn.RemoteAddr(), clientID)
-				
-				// 为每个客户端启动独立的帧处理 goroutine
-				go s.handleClientConnection(ctx, clientID, conn)
+				backoff.reset()
+
+				// 启用帧完整性校验时先完成 MAC 协商（在独立的 goroutine 中进行，不阻塞接受其他连接）
+				if s.frameMACKey != nil {
+					go func() {
+						macConn, err := serverFrameMAC(conn, s.frameMACKey)
+						if err != nil {
+							s.securityLog.rejected(conn.RemoteAddr(), rejectFrameMAC, "", err)
+							conn.Close()
+							return
+						}
+						s.acceptClient(ctx, macConn)
+					}()
+					continue
+				}
+				s.acceptClient(ctx, conn)
 			}
 		}
 	}()
@@ -160,25 +555,137 @@ func (s *Server) Run(ctx context.Context) error {
 	return ctx.Err()
 }
 
+// acceptClient 注册新接受的控制连接并启动其帧处理 goroutine
+func (s *Server) acceptClient(ctx context.Context, conn net.Conn) {
+	// 反复发送无法解码的帧的来源在 backoff 内被拒绝
+	if s.decodeErrorBlocked(conn) {
+		err := errDecodeBlocked
+		s.securityLog.rejected(conn.RemoteAddr(), rejectDecodeErrors, peerIdentity(conn), err)
+		s.publishEvent(&proto.Event{Kind: proto.EventError, Identity: peerIdentity(conn), Addr: conn.RemoteAddr().String(), Detail: err.Error()})
+		s.sendInitResult(conn.RemoteAddr().String(), conn, nil, proto.FrameTypeERROR, err.Error())
+		conn.Close()
+		return
+	}
+	// 为新客户端分配ID并注册（身份不被策略允许时回复 ERROR 并关闭）
+	clientID, err := s.registerClient(conn)
+	if err != nil {
+		reason := rejectAuthFailed
+		if errors.Is(err, errDuplicateIdentity) {
+			reason = rejectDuplicateIdentity
+		}
+		s.securityLog.rejected(conn.RemoteAddr(), reason, peerIdentity(conn), err)
+		s.publishEvent(&proto.Event{Kind: proto.EventError, Identity: peerIdentity(conn), Addr: conn.RemoteAddr().String(), Detail: err.Error()})
+		s.sendInitResult(conn.RemoteAddr().String(), conn, nil, proto.FrameTypeERROR, err.Error())
+		conn.Close()
+		return
+	}
+	log.Printf("客户端已连接: %s (clientID=%s)", conn.RemoteAddr(), clientID)
+	if s.logPeerCertChain {
+		s.recordPeerCertChain(clientID, conn)
+	}
+	s.publishEvent(&proto.Event{Kind: proto.EventConnected, ClientID: clientID, Identity: peerIdentity(conn), Addr: conn.RemoteAddr().String()})
+
+	// 为每个客户端启动独立的帧处理 goroutine
+	go s.handleClientConnection(ctx, clientID, conn)
+}
+
+// listenNetwork 返回监听使用的网络类型
+func (s *Server) listenNetwork() string {
+	if s.network == "" {
+		return "tcp"
+	}
+	return s.network
+}
+
 // registerClient 注册新客户端并返回clientID
-func (s *Server) registerClient(conn net.Conn) string {
+// 配置了配额策略时按客户端身份查找配额，策略不允许该身份时返回错误；
+// 相同身份的客户端已在线时按重复身份策略拒绝新客户端或断开原客户端
+func (s *Server) registerClient(conn net.Conn) (string, error) {
+	identity := peerIdentity(conn)
+	var t *tenant
+	policy := s.currentPolicy()
+	if policy != nil {
+		quota, ok := policy.Quota(identity)
+		if !ok {
+			return "", fmt.Errorf("客户端身份 %q 未在配额策略中配置", identity)
+		}
+		t = s.tenantFor(identity, quota)
+	}
+
 	clientID := fmt.Sprintf("client-%d", atomic.AddUint32(&s.nextClientID, 1))
 	
 	clientInfo := &ClientInfo{
-		ID:         clientID,
-		Conn:       conn,
-		NextConnID: 0,
+		ID:          clientID,
+		Conn:        conn,
+		NextConnID:  s.connIDBase(),
+		ConnectedAt: time.Now(),
+		Identity:    identity,
+		Hostnames:   policy.HostnamesFor(identity),
+		tenant:      t,
+		inRate:      newRateMeter(),
+		outRate:     newRateMeter(),
+		usage:       s.meter.session(identity),
+		setups:      newSetupLimiter(s.maxPendingNewConns),
 	}
+	clientInfo.writeMu.tracer = s.frameTracer
 	
 	s.clientsMu.Lock()
+	var replaced []string
+	if s.duplicateIdentityPolicy == DuplicateIdentityRejectNew || s.duplicateIdentityPolicy == DuplicateIdentityReplaceOld {
+		replaced = s.duplicatesLocked(identity)
+	}
+	if len(replaced) > 0 && s.duplicateIdentityPolicy == DuplicateIdentityRejectNew {
+		s.clientsMu.Unlock()
+		log.Printf("客户端身份 %q 重复（已在线: %v），按策略 %s 拒绝新客户端 %s", identity, replaced, DuplicateIdentityRejectNew, conn.RemoteAddr())
+		return "", fmt.Errorf("%w: %q", errDuplicateIdentity, identity)
+	}
 	s.clients[clientID] = clientInfo
+	s.notifyClientsChanged()
 	s.clientsMu.Unlock()
-	
-	return clientID
+
+	// 新客户端注册后再断开原客户端（在 INIT 之前完成，原客户端的端口和主机名随即可被新客户端绑定）
+	s.replaceDuplicates(identity, clientID, replaced)
+	return clientID, nil
+}
+
+// tenantFor 返回身份对应的配额状态（同一身份的多个客户端共享连接数和带宽配额）
+func (s *Server) tenantFor(identity string, quota ClientQuota) *tenant {
+	s.tenantsMu.Lock()
+	defer s.tenantsMu.Unlock()
+
+	if s.tenants == nil {
+		s.tenants = make(map[string]*tenant)
+	}
+	t, ok := s.tenants[identity]
+	if !ok {
+		t = newTenant(identity, quota)
+		s.tenants[identity] = t
+	} else if t.currentQuota() != quota {
+		t.setQuota(quota)
+	}
+	return t
+}
+
+// identityPortCountLocked 返回同一身份的客户端当前占用的专用公开端口数（调用方需持有 clientsMu）
+func (s *Server) identityPortCountLocked(identity string) int {
+	count := 0
+	for _, info := range s.clients {
+		if info.Identity == identity && info.PublicListener != nil {
+			count++
+		}
+	}
+	return count
 }
 
 // unregisterClient 注销客户端
 func (s *Server) unregisterClient(clientID string) {
+	// 释放锁后等待公开端口 accept 循环退出（accept 循环转发的连接需要获取 clientsMu）
+	var released *ClientInfo
+	defer func() {
+		if released != nil {
+			waitAcceptLoops(released)
+		}
+	}()
 	s.clientsMu.Lock()
 	defer s.clientsMu.Unlock()
 	
@@ -188,18 +695,25 @@ func (s *Server) unregisterClient(clientID string) {
 	}
 	
 	// 清理该客户端的所有连接
-	clientInfo.ConnMap.Range(func(key, value interface{}) bool {
-		if conn, ok := value.(net.Conn); ok {
-			conn.Close()
-		}
-		clientInfo.ConnMap.Delete(key)
-		return true
-	})
+	reason := closeReasonClientGone
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		reason = closeReasonShutdown
+	} else if clientInfo.exited.Load() {
+		reason = closeReasonClientExit
+	}
+	s.dropClientConnsLocked(clientID, clientInfo, reason)
 	
 	// 关闭该客户端的公开端口监听器
 	if clientInfo.PublicListener != nil {
 		clientInfo.PublicListener.Close()
 	}
+	released = clientInfo
+	// 唤醒等待建立名额的公开连接和暂停的 accept 循环
+	clientInfo.setups.close()
+	s.portNotifier.released(clientID)
+	if s.publicFairQueue != nil {
+		s.publicFairQueue.forget(clientID)
+	}
 	
 	// 关闭控制连接
 	if clientInfo.Conn != nil {
@@ -207,142 +721,139 @@ func (s *Server) unregisterClient(clientID string) {
 	}
 	
 	delete(s.clients, clientID)
-	log.Printf("客户端已注销: %s", clientID)
+	// 唤醒等待该客户端恢复的公开连接（见 awaitResume）
+	s.notifyClientsChanged()
+	s.publishEvent(&proto.Event{Kind: proto.EventDisconnected, ClientID: clientID, Identity: clientInfo.Identity})
+	if clientInfo.exited.Load() {
+		log.Printf("客户端已注销 (正常退出): %s", clientID)
+	} else {
+		log.Printf("客户端已注销: %s", clientID)
+	}
+}
+
+// dropClientConnsLocked 关闭客户端的所有公开连接（不再通过控制连接发送 CLOSE_CONN），调用方需持有 clientsMu
+func (s *Server) dropClientConnsLocked(clientID string, clientInfo *ClientInfo, reason string) {
+	clientInfo.ConnMap.Range(func(key, value interface{}) bool {
+		tc := value.(*trackedConn)
+		// 控制连接已断开（或不再转发），转发 goroutine 随后读到的错误不再触发 CLOSE_CONN
+		tc.markClosed()
+		if tc.out != nil {
+			tc.out.stop()
+		}
+		tc.Close()
+		clientInfo.ConnMap.Delete(key)
+		s.finishPublicConn(clientID, key.(uint32), tc, reason)
+		return true
+	})
 }
 
 // handleClientConnection 处理单个客户端连接
 func (s *Server) handleClientConnection(ctx context.Context, clientID string, conn net.Conn) {
+	done := make(chan struct{})
 	defer func() {
+		close(done)
 		s.unregisterClient(clientID)
 	}()
+
+	if lifetime := s.controlConnLifetime(); lifetime > 0 {
+		go s.enforceControlLifetime(clientID, conn, lifetime, done)
+	}
+	if s.requiredFeatures != 0 {
+		go s.enforceHello(clientID, conn, done)
+	}
+	if s.initTimeout > 0 {
+		go s.enforceInitTimeout(clientID, conn, done)
+	}
+	if s.initBudgetTimeout > 0 {
+		go s.enforceInitBudgetTimeout(clientID, conn, done)
+	}
+	if s.publicListenAddr == "" {
+		go s.checkPublicEndpoint(clientID, conn, done)
+	}
 	
 	// 启动从客户端读取帧的 goroutine
 	s.handleFramesFromClient(ctx, clientID, conn)
 }
 
-// handlePublicConnection 处理新的公开连接
-// 注意：这个方法需要知道应该转发到哪个客户端
-// 当前实现：如果只有一个客户端，转发给它；如果有多个，需要根据端口或其他方式路由
-func (s *Server) handlePublicConnection(ctx context.Context, publicConn net.Conn, clientID string) {
-	// 获取客户端信息
+// maxConnIDBase 随机 connID 起点的上限，保证每个控制连接至少还有 2^31 个 connID 可用
+const maxConnIDBase = 1 << 31
+
+// connIDBase 返回新控制连接的 connID 起点（NextConnID 的初值，第一个连接使用 base+1）
+// 默认为 0；启用 randomConnIDBase 时为 [0, 2^31) 内的随机值，不同客户端（以及同一客户端的多次重连）
+// 的 connID 落在不同区间，日志和抓包中不易混淆，也不会从 connID 推断出服务器上的连接数
+func (s *Server) connIDBase() uint32 {
+	if !s.randomConnIDBase {
+		return 0
+	}
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Printf("生成随机 connID 起点失败，从 1 开始: %v", err)
+		return 0
+	}
+	return binary.BigEndian.Uint32(b[:]) % maxConnIDBase
+}
+
+// allocConnID 为客户端的新公开连接分配 connID
+//
+// 不变量：同一控制连接上的 connID 从 connIDBase()+1（默认为 1）开始严格递增、从不复用（0 保留给控制帧），
+// 因此刚关闭的连接迟到的 DATA/CLOSE_CONN 帧只会因 connID 未知被丢弃，不会被误送到新连接。
+// 32 位空间用尽时不回绕（回绕会与仍在使用或刚关闭的 connID 冲突），而是拒绝新连接并要求客户端重建控制连接，
+// 新的控制连接有独立的连接映射，connID 重新从起点开始
+func (s *Server) allocConnID(clientInfo *ClientInfo) (uint32, bool) {
+	for {
+		last := atomic.LoadUint32(&clientInfo.NextConnID)
+		if last == math.MaxUint32 {
+			break
+		}
+		if atomic.CompareAndSwapUint32(&clientInfo.NextConnID, last, last+1) {
+			return last + 1, true
+		}
+	}
+
+	if clientInfo.connIDExhausted.CompareAndSwap(false, true) {
+		log.Printf("客户端的 connID 已用尽，拒绝新连接并要求客户端重建控制连接: clientID=%s", clientInfo.ID)
+		frame := &proto.Frame{Type: proto.FrameTypeREDIRECT, ConnID: 0}
+		if err := writeFrame(clientInfo.Conn, &clientInfo.writeMu, frame, s.writeTimeout()); err != nil {
+			log.Printf("发送重建请求失败 (clientID=%s): %v", clientInfo.ID, err)
+		}
+	}
+	return 0, false
+}
+
+// enforceControlLifetime 控制连接达到最大存活时间后要求客户端重建连接
+// 先发送空 REDIRECT 帧，客户端在 controlRecycleGrace 内未断开则强制关闭
+func (s *Server) enforceControlLifetime(clientID string, conn net.Conn, lifetime time.Duration, done <-chan struct{}) {
+	lifetimeTimer := time.NewTimer(lifetime)
+	defer lifetimeTimer.Stop()
+	select {
+	case <-done:
+		return
+	case <-lifetimeTimer.C:
+	}
+
+	log.Printf("控制连接已达最大存活时间 (%v)，要求客户端重建: clientID=%s", lifetime, clientID)
 	s.clientsMu.RLock()
 	clientInfo, ok := s.clients[clientID]
 	s.clientsMu.RUnlock()
-	
 	if !ok {
-		log.Printf("错误: 客户端不存在 (clientID=%s)，关闭外部连接", clientID)
-		publicConn.Close()
 		return
 	}
-	
-	// 为该客户端生成新的 connID
-	connID := atomic.AddUint32(&clientInfo.NextConnID, 1)
-	log.Printf("新外部连接: %s, clientID=%s, connID=%d", publicConn.RemoteAddr(), clientID, connID)
-
-	// 先发送 NEW_CONN 帧，等待客户端建立本地连接
-	// 注意：此时先不将连接存入 map，等客户端确认建立成功后再存入
 	frame := &proto.Frame{
-		Type:    proto.FrameTypeNEW_CONN,
-		ConnID:  connID,
-		Payload: nil,
+		Type:   proto.FrameTypeREDIRECT,
+		ConnID: 0,
 	}
-
-	frameData, err := proto.EncodeFrame(frame)
-	if err != nil {
-		log.Printf("编码 NEW_CONN 帧错误 (clientID=%s, connID=%d): %v", clientID, connID, err)
-		publicConn.Close()
-		return
+	if err := writeFrame(conn, &clientInfo.writeMu, frame, s.writeTimeout()); err != nil {
+		log.Printf("发送重建请求失败 (clientID=%s): %v", clientID, err)
 	}
 
-	if _, err := clientInfo.Conn.Write(frameData); err != nil {
-		log.Printf("发送 NEW_CONN 帧错误 (clientID=%s, connID=%d): %v", clientID, connID, err)
-		publicConn.Close()
-		return
+	graceTimer := time.NewTimer(controlRecycleGrace)
+	defer graceTimer.Stop()
+	select {
+	case <-done:
+	case <-graceTimer.C:
+		log.Printf("客户端未在 %v 内重建控制连接，强制关闭: clientID=%s", controlRecycleGrace, clientID)
+		conn.Close()
 	}
-
-	// 将连接存入该客户端的 map（在发送 NEW_CONN 之后）
-	// 这样即使客户端连接本地服务失败，我们也能正确处理 CLOSE_CONN
-	clientInfo.ConnMap.Store(connID, publicConn)
-
-	// 启动两个方向的转发：
-	// 1. 从公开连接读取数据，发送 DATA 帧给 client
-	// 2. 从 client 接收 DATA 帧（在 handleFramesFromClient 中处理）
-
-	// 从公开连接读取并转发给 client
-	// 注意：这里立即开始读取，但如果客户端连接本地服务失败，可能会收到 CLOSE_CONN
-	// 此时连接会被客户端关闭，导致 "use of closed network connection" 错误
-	go func() {
-		defer func() {
-			// 检查连接是否还在 map 中（可能已经被 handleCloseFrame 删除了）
-			if _, exists := clientInfo.ConnMap.Load(connID); exists {
-				publicConn.Close()
-				clientInfo.ConnMap.Delete(connID)
-				log.Printf("外部连接已关闭: clientID=%s, connID=%d", clientID, connID)
-			}
-		}()
-
-		buf := make([]byte, 4096)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				// 检查连接是否还在 map 中
-				if _, exists := clientInfo.ConnMap.Load(connID); !exists {
-					// 连接已经被删除（可能是客户端发送了 CLOSE_CONN）
-					return
-				}
-				
-				n, err := publicConn.Read(buf)
-				if err != nil {
-					// 检查是否是连接关闭错误
-					if err != io.EOF {
-						// 检查是否是 "use of closed network connection" 错误
-						// 这通常发生在客户端已经关闭了本地连接并发送了 CLOSE_CONN
-						errStr := err.Error()
-						if strings.Contains(errStr, "use of closed network connection") {
-							// 连接已经被关闭，可能是客户端主动关闭的（连接本地服务失败）
-							// 不需要再发送 CLOSE_CONN，因为客户端已经发送了
-							log.Printf("公开连接已关闭 (clientID=%s, connID=%d)，可能是客户端连接本地服务失败", clientID, connID)
-						} else {
-							log.Printf("读取公开连接数据错误 (clientID=%s, connID=%d): %v", clientID, connID, err)
-							// 发送 CLOSE_CONN 帧通知客户端
-							s.sendCloseFrame(clientID, connID)
-						}
-					} else {
-						// EOF，正常关闭
-						s.sendCloseFrame(clientID, connID)
-					}
-					return
-				}
-
-				if n > 0 {
-					// 检查连接是否还在 map 中（可能在读取期间被关闭了）
-					if _, exists := clientInfo.ConnMap.Load(connID); !exists {
-						return
-					}
-					
-					// 发送 DATA 帧给 client
-					dataFrame := &proto.Frame{
-						Type:    proto.FrameTypeDATA,
-						ConnID:  connID,
-						Payload: buf[:n],
-					}
-
-					frameData, err := proto.EncodeFrame(dataFrame)
-					if err != nil {
-						log.Printf("编码 DATA 帧错误 (clientID=%s, connID=%d): %v", clientID, connID, err)
-						return
-					}
-
-					if _, err := clientInfo.Conn.Write(frameData); err != nil {
-						log.Printf("发送 DATA 帧错误 (clientID=%s, connID=%d): %v", clientID, connID, err)
-						return
-					}
-				}
-			}
-		}
-	}()
 }
 
 // handleFramesFromClient 处理来自 client 的帧
@@ -352,38 +863,156 @@ func (s *Server) handleFramesFromClient(ctx context.Context, clientID string, co
 		log.Printf("控制连接已关闭: clientID=%s", clientID)
 	}()
 
+	// 帧速率限制在控制连接建立时确定，运行时修改只影响之后建立的控制连接
+	maxFrameRate, frameRatePolicy := s.frameRateLimit()
+	var frameLimiter *bandwidthLimiter
+	if maxFrameRate > 0 {
+		frameLimiter = newBandwidthLimiter(int64(maxFrameRate))
+	}
+	// 对端可反复触发的日志按连接限频
+	var throttleLog, unknownFrameLog rateLimitedLog
+	// 是否已完成特性协商（服务器要求特性时，HELLO 之前的其他帧视为协议错误）
+	negotiated := false
+	// 是否已收到首个 HELLO 或 INIT（之后不再受 initTimeout 限制）
+	greeted := false
+	// 是否已收到 INIT（之后不能再转为观察者）
+	initSent := false
+	// 完成 INIT 之前读取的字节数预算
+	budget := s.newInitBudget()
+	// 协商的 DATA 负载上限由 handleHelloFrame 写入，读取循环每次读取帧时无锁读取
+	s.clientsMu.RLock()
+	clientInfo := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if clientInfo == nil {
+		return
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			frame, err := proto.DecodeFrame(conn)
+			limit, err := budget.frameLimit()
 			if err != nil {
+				s.rejectInitBudgetConn(clientID, conn, err)
+				return
+			}
+			agreedData := clientInfo.maxData.Load()
+			frame, err := readFrameLimit(conn, s.frameTracer, limit, agreedData)
+			if err != nil {
+				// 超过协商的负载上限视为协议错误，回复 ERROR 后断开控制连接
+				if agreedData > 0 && errors.Is(err, proto.ErrDataPayloadTooLarge) {
+					s.rejectOversizedData(clientInfo, err)
+				}
+				if budgetErr := budget.exceeded(err); budgetErr != nil {
+					s.rejectInitBudgetConn(clientID, conn, budgetErr)
+					return
+				}
 				if err != io.EOF {
 					log.Printf("解码帧错误 (clientID=%s): %v", clientID, err)
 				}
+				if kind := decodeErrorKind(err); kind != "" {
+					s.recordDecodeError(clientID, conn, kind)
+				}
+				return
+			}
+			budget.consume(frame)
+
+			// 帧速率限制：drop 策略下超出即断开，否则延迟处理（同时停止读取，通过 TCP 反压减慢对端）
+			if frameLimiter != nil {
+				if frameRatePolicy == FrameRatePolicyDrop {
+					if !frameLimiter.allow(1) {
+						log.Printf("客户端帧速率超过 %d 帧/秒，断开控制连接: clientID=%s", maxFrameRate, clientID)
+						return
+					}
+				} else if frameLimiter.wait(1) > 0 {
+					throttleLog.printf("客户端帧速率超过 %d 帧/秒，已限速: clientID=%s", maxFrameRate, clientID)
+				}
+			}
+
+			if !greeted && greetingFrame(frame) {
+				greeted = true
+				s.markGreeted(clientID)
+			}
+
+			if !negotiated && s.requiredFeatures != 0 && frame.Type != proto.FrameTypeHELLO {
+				s.frameStats.inc(frame.Type, frameRejected)
+				log.Printf("客户端未进行特性协商，断开控制连接 (clientID=%s, 首个帧=%v)", clientID, frame.Type)
+				s.sendHelloError(clientID, conn, fmt.Sprintf("服务器要求特性协商，必需的协议特性: %s", s.requiredFeatures))
 				return
 			}
 
 			switch frame.Type {
+			case proto.FrameTypeHELLO:
+				// 特性协商，缺少必需的特性时断开控制连接
+				if !s.handleHelloFrame(clientID, frame) {
+					return
+				}
+				negotiated = true
 			case proto.FrameTypeINIT:
-				// 处理初始化配置（客户端指定远程端口）
-				s.handleInitFrame(ctx, clientID, frame)
+				// 处理初始化配置（客户端指定远程端口），INIT 负载格式错误视为协议错误，断开控制连接
+				initSent = true
+				if s.rejectOversizedInit(clientID, conn, frame) {
+					s.frameStats.inc(frame.Type, frameRejected)
+					return
+				}
+				if err := s.handleInitFrame(ctx, clientID, frame); err != nil {
+					s.frameStats.inc(frame.Type, frameParseError)
+					log.Printf("协议错误，断开控制连接 (clientID=%s): %v", clientID, err)
+					return
+				}
+				budget.complete()
+				s.markInitDone(clientID)
 			case proto.FrameTypeDATA:
 				// 将数据写入对应的外部连接
-				s.handleDataFrame(clientID, frame)
+				s.payloadSizes.observe(payloadReceived, len(frame.Payload))
+				s.frameStats.inc(frame.Type, s.handleDataFrame(clientID, frame))
 			case proto.FrameTypeCLOSE:
 				// 关闭对应的外部连接
-				s.handleCloseFrame(clientID, frame)
+				s.frameStats.inc(frame.Type, s.handleCloseFrame(clientID, frame))
+			case proto.FrameTypeHEALTH_REPORT:
+				s.frameStats.inc(frame.Type, s.handleHealthReport(clientID, frame))
+			case proto.FrameTypeNEW_CONN_ACK:
+				s.frameStats.inc(frame.Type, s.handleNewConnAck(clientID, frame))
+			case proto.FrameTypeWINDOW_UPDATE:
+				s.frameStats.inc(frame.Type, s.handleWindowUpdate(clientID, frame))
+			case proto.FrameTypeERROR:
+				// 客户端报告的协议错误（例如 DATA 帧超过协商的上限），客户端随后断开控制连接
+				s.frameStats.inc(frame.Type, frameOK)
+				log.Printf("客户端返回错误 (clientID=%s): %s", clientID, string(frame.Payload))
+			case proto.FrameTypeBYE:
+				// 客户端正常退出，立即注销（不等待控制连接断开）
+				s.frameStats.inc(frame.Type, s.handleByeFrame(clientID))
+				return
+			case proto.FrameTypeOBSERVE:
+				// 转为观察者，此后只接收事件，直到连接断开
+				s.markInitDone(clientID)
+				s.serveObserver(ctx, clientID, conn, initSent)
+				return
 			default:
-				log.Printf("未知帧类型: %d, clientID=%s, connID=%d", frame.Type, clientID, frame.ConnID)
+				s.frameStats.inc(frame.Type, frameIgnored)
+				s.recordDecodeError(clientID, conn, decodeUnknownType)
+				unknownFrameLog.printf("未知帧类型: %d, clientID=%s, connID=%d", frame.Type, clientID, frame.ConnID)
 			}
 		}
 	}
 }
 
-// handleDataFrame 处理来自 client 的 DATA 帧
-func (s *Server) handleDataFrame(clientID string, frame *proto.Frame) {
+// handleByeFrame 标记客户端正常退出，返回处理结果（用于帧计数）；随后 handleFramesFromClient 返回并注销客户端
+func (s *Server) handleByeFrame(clientID string) string {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok {
+		return frameIgnored
+	}
+	clientInfo.exited.Store(true)
+	log.Printf("客户端正常退出: clientID=%s", clientID)
+	return frameOK
+}
+
+// handleDataFrame 处理来自 client 的 DATA 帧，返回处理结果（用于帧计数）
+func (s *Server) handleDataFrame(clientID string, frame *proto.Frame) string {
 	// 获取客户端信息
 	s.clientsMu.RLock()
 	clientInfo, ok := s.clients[clientID]
@@ -391,35 +1020,53 @@ func (s *Server) handleDataFrame(clientID string, frame *proto.Frame) {
 	
 	if !ok {
 		log.Printf("警告: 客户端不存在 (clientID=%s)", clientID)
-		return
+		return frameUnknownConn
 	}
 	
-	conn, ok := clientInfo.ConnMap.Load(frame.ConnID)
+	value, ok := clientInfo.ConnMap.Load(frame.ConnID)
 	if !ok {
 		log.Printf("警告: 未找到连接 (clientID=%s, connID=%d)", clientID, frame.ConnID)
-		return
+		return frameUnknownConn
 	}
+	tc := value.(*trackedConn)
+	// 客户端发来数据说明本地连接已建立（未协商 conn_ack 的客户端以此代替 NEW_CONN_ACK）
+	tc.setupDone()
 
-	publicConn, ok := conn.(net.Conn)
-	if !ok {
-		log.Printf("错误: 连接类型错误 (clientID=%s, connID=%d)", clientID, frame.ConnID)
-		return
+	// 将数据写入外部连接（限速的连接交给其写入 goroutine，不在分发循环中休眠）
+	if len(frame.Payload) > 0 && tc.out != nil {
+		if !tc.out.push(frame.Payload) {
+			return frameUnknownConn
+		}
+		return frameOK
 	}
-
-	// 将数据写入外部连接
 	if len(frame.Payload) > 0 {
-		if _, err := publicConn.Write(frame.Payload); err != nil {
-			log.Printf("写入外部连接错误 (clientID=%s, connID=%d): %v", clientID, frame.ConnID, err)
-			// 连接可能已关闭，清理并发送 CLOSE_CONN
-			publicConn.Close()
-			clientInfo.ConnMap.Delete(frame.ConnID)
-			s.sendCloseFrame(clientID, frame.ConnID)
+		kind := tc.deadlines.armWrite(tc.Conn)
+		n, err := tc.Write(frame.Payload)
+		s.recordBytesOut(clientInfo, n)
+		if err != nil && isDeadlineErr(err) && kind != "" {
+			// 外部连接在截止时间内没有接收数据
+			s.expirePublicConn(clientInfo, frame.ConnID, tc, kind)
+			return frameWriteError
+		}
+		if err != nil {
+			// 连接已被关闭（本端或客户端先关闭）时由对应的一方完成清理
+			if !tc.closeLocal() {
+				return frameUnknownConn
+			}
+			log.Printf("写入外部连接错误 (clientID=%s, connID=%d, trace=%s): %v", clientID, frame.ConnID, tc.traceID, err)
+			s.sendCloseFrame(clientID, frame.ConnID, tc.traceID, proto.CloseError)
+			s.releasePublicConn(clientInfo, clientID, frame.ConnID, tc, closeReasonError)
+			return frameWriteError
+		}
+		if s.connQuotaExceeded(tc) {
+			s.closeOverQuota(clientInfo, clientID, frame.ConnID, tc)
 		}
 	}
+	return frameOK
 }
 
-// handleCloseFrame 处理来自 client 的 CLOSE_CONN 帧
-func (s *Server) handleCloseFrame(clientID string, frame *proto.Frame) {
+// handleCloseFrame 处理来自 client 的 CLOSE_CONN 帧，返回处理结果（用于帧计数）
+func (s *Server) handleCloseFrame(clientID string, frame *proto.Frame) string {
 	// 获取客户端信息
 	s.clientsMu.RLock()
 	clientInfo, ok := s.clients[clientID]
@@ -427,112 +1074,231 @@ func (s *Server) handleCloseFrame(clientID string, frame *proto.Frame) {
 	
 	if !ok {
 		log.Printf("警告: 收到 CLOSE_CONN 帧但客户端不存在 (clientID=%s, connID=%d)", clientID, frame.ConnID)
-		return
+		return frameUnknownConn
 	}
 	
-	// 尝试删除连接（可能已经被读取 goroutine 删除了）
-	conn, ok := clientInfo.ConnMap.LoadAndDelete(frame.ConnID)
+	// 映射不存在（连接已清理）或本端已先关闭（客户端对服务器所发 CLOSE_CONN 的回发、双方同时关闭）时忽略。
+	// 服务器收到 CLOSE_CONN 后只关闭外部连接，从不回发（见 README“连接关闭”）
+	value, ok := clientInfo.ConnMap.Load(frame.ConnID)
 	if !ok {
-		// 连接可能已经关闭，这是正常的（可能客户端连接本地服务失败，或读取 goroutine 已经关闭）
-		// 不记录日志，避免日志噪音
-		return
+		return frameUnknownConn
+	}
+	tc := value.(*trackedConn)
+	if !tc.closeRemote() {
+		return frameUnknownConn
 	}
 
-	publicConn, ok := conn.(net.Conn)
-	if !ok {
-		return
+	// 按客户端给出的原因关闭外部连接（本地连接被重置或出错时以 RST 关闭）
+	// 限速的连接由写入 goroutine 写完已排队的数据后再关闭
+	reason := proto.DecodeCloseReason(frame.Payload)
+	clientInfo.ConnMap.Delete(frame.ConnID)
+	tc.peerCloseReason.Store(reason.String())
+	if tc.out != nil {
+		tc.out.closeQueue(reason)
+	} else {
+		s.closePublicConn(tc, reason)
+		s.finishPublicConn(clientID, frame.ConnID, tc, closeReasonClientClose)
 	}
+	log.Printf("收到 CLOSE_CONN 帧 (原因=%s)，已关闭外部连接: clientID=%s, connID=%d, trace=%s", reason, clientID, frame.ConnID, tc.traceID)
+	return frameOK
+}
 
-	// 关闭外部连接
-	publicConn.Close()
-	log.Printf("收到 CLOSE_CONN 帧，已关闭外部连接: clientID=%s, connID=%d", clientID, frame.ConnID)
+// closePublicConn 按客户端 CLOSE_CONN 的原因关闭外部连接
+// 启用 PublicErrorResponseHTTP 时，客户端在向外部连接写出任何数据之前以 CloseError（连接本地服务失败）
+// 或 CloseUnavailable（本地服务熔断中）关闭，先回复 HTTP 502 或 503 再正常关闭，外部访问者看到明确的错误而不是无数据的断开
+func (s *Server) closePublicConn(tc *trackedConn, reason proto.CloseReason) {
+	tc.closedBeforeData.Store(atomic.LoadUint64(&tc.bytesOut) == 0)
+	if (reason == proto.CloseError || reason == proto.CloseUnavailable) && s.publicErrorResponse == PublicErrorResponseHTTP && atomic.LoadUint64(&tc.bytesOut) == 0 {
+		response := httpBadGatewayResponse
+		if reason == proto.CloseUnavailable {
+			response = httpBackendUnavailableResponse
+		}
+		// 响应很小，通常直接进入发送缓冲区；设置超时避免外部连接不读取时阻塞帧分发循环
+		tc.SetWriteDeadline(time.Now().Add(publicErrorWriteTimeout))
+		if _, err := io.WriteString(tc, response); err == nil {
+			closeWithReason(tc, proto.CloseGraceful)
+			return
+		}
+	}
+	closeWithReason(tc, reason)
 }
 
-// sendCloseFrame 发送 CLOSE_CONN 帧给 client
-func (s *Server) sendCloseFrame(clientID string, connID uint32) {
-	// 获取客户端信息
+// RedirectClient 通知指定客户端断开并改连 addr 指定的服务器（用于滚动升级时迁移客户端）
+// 客户端连接 addr 失败时会回退到其配置的服务器
+func (s *Server) RedirectClient(clientID, addr string) error {
+	if _, err := proto.DecodeRedirect([]byte(addr)); err != nil {
+		return err
+	}
+
 	s.clientsMu.RLock()
 	clientInfo, ok := s.clients[clientID]
 	s.clientsMu.RUnlock()
-	
 	if !ok || clientInfo.Conn == nil {
-		return
+		return fmt.Errorf("客户端不存在: %s", clientID)
 	}
 
 	frame := &proto.Frame{
-		Type:    proto.FrameTypeCLOSE,
-		ConnID:  connID,
-		Payload: nil,
+		Type:    proto.FrameTypeREDIRECT,
+		ConnID:  0,
+		Payload: proto.EncodeRedirect(addr),
 	}
+	if err := writeFrame(clientInfo.Conn, &clientInfo.writeMu, frame, s.writeTimeout()); err != nil {
+		return fmt.Errorf("发送 REDIRECT 帧失败: %w", err)
+	}
+	log.Printf("已要求客户端 %s 重定向到 %s", clientID, addr)
+	return nil
+}
 
-	frameData, err := proto.EncodeFrame(frame)
-	if err != nil {
-		log.Printf("编码 CLOSE_CONN 帧错误 (clientID=%s, connID=%d): %v", clientID, connID, err)
-		return
+// RedirectClients 通知所有客户端改连 addr 指定的服务器，返回成功通知的客户端数
+func (s *Server) RedirectClients(addr string) int {
+	s.clientsMu.RLock()
+	clientIDs := make([]string, 0, len(s.clients))
+	for clientID := range s.clients {
+		clientIDs = append(clientIDs, clientID)
 	}
+	s.clientsMu.RUnlock()
 
-	if _, err := clientInfo.Conn.Write(frameData); err != nil {
-		log.Printf("发送 CLOSE_CONN 帧错误 (clientID=%s, connID=%d): %v", clientID, connID, err)
+	count := 0
+	for _, clientID := range clientIDs {
+		if err := s.RedirectClient(clientID, addr); err != nil {
+			log.Printf("重定向客户端 %s 失败: %v", clientID, err)
+			continue
+		}
+		count++
 	}
+	return count
 }
 
-// acceptPublicConnections 接受公开端口连接（全局监听器）
-func (s *Server) acceptPublicConnections(ctx context.Context, listener net.Listener) {
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				log.Printf("接受公开连接错误: %v", err)
-				continue
-			}
+// finishPublicConn 在公开连接从映射中移除时调用，记录访问日志（每个连接只记录一次）
+func (s *Server) finishPublicConn(clientID string, connID uint32, tc *trackedConn, reason string) {
+	tc.finish(reason, func(c *trackedConn, reason string) {
+		c.tenant.releaseConn()
+		c.releaseSetup()
+		category := closeCategory(reason, c)
+		s.closeCategories.inc(category)
+		s.accessLog.logPublicConn(clientID, connID, c, reason, category)
+		if s.events == nil {
+			return
 		}
-		
-		// 对于全局监听器，需要路由到某个客户端
-		// 当前实现：路由到第一个可用的客户端（简单策略）
-		// 未来可以改进：通过某种标识（如SNI、路径等）路由到特定客户端
-		s.clientsMu.RLock()
-		var targetClientID string
-		for id := range s.clients {
-			targetClientID = id
-			break // 使用第一个客户端
-		}
-		s.clientsMu.RUnlock()
-		
-		if targetClientID == "" {
-			log.Printf("警告: 没有可用的客户端，关闭公开连接: %s", conn.RemoteAddr())
-			conn.Close()
-			continue
+		source := ""
+		if addr := c.RemoteAddr(); addr != nil {
+			source = addr.String()
 		}
-		
-		// 转发到目标客户端
-		s.handlePublicConnection(ctx, conn, targetClientID)
-	}
+		s.events.emit(LifecycleEvent{
+			Kind:        EventConnClose,
+			ClientID:    clientID,
+			ConnID:      connID,
+			TraceID:     c.traceID,
+			Source:      source,
+			BytesIn:     atomic.LoadUint64(&c.bytesIn),
+			BytesOut:    atomic.LoadUint64(&c.bytesOut),
+			DurationMs:  time.Since(c.start).Milliseconds(),
+			CloseReason: reason,
+		})
+	})
 }
 
-// acceptPublicConnectionsForClient 为特定客户端接受公开端口连接
-func (s *Server) acceptPublicConnectionsForClient(ctx context.Context, clientID string, listener net.Listener) {
+// runThrottledWriter 按身份的带宽配额把 tc.out 中排队的 DATA 负载写入外部连接
+// 客户端 CLOSE_CONN 关闭队列后写完剩余数据再按其原因关闭连接；连接被本端清理或控制连接断开时退出
+func (s *Server) runThrottledWriter(clientInfo *ClientInfo, clientID string, connID uint32, tc *trackedConn) {
+	w := tc.out
+	defer w.stop()
 	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				log.Printf("接受公开连接错误 (clientID=%s): %v", clientID, err)
-				continue
+		payload, closed, ok := w.pop()
+		if ok && !closed {
+			ok = tc.tenant.waitOut(w.ctx, len(payload)) == nil
+		}
+		if !ok {
+			// 排空队列期间控制连接断开或服务器关闭：连接已不在映射中，由这里结束
+			if w.closing.Load() {
+				tc.Close()
+				s.finishPublicConn(clientID, connID, tc, closeReasonClientClose)
 			}
+			return
 		}
-		
-		// 直接转发到指定客户端
-		s.handlePublicConnection(ctx, conn, clientID)
+		if closed {
+			w.mu.Lock()
+			reason := w.reason
+			w.mu.Unlock()
+			s.closePublicConn(tc, reason)
+			s.finishPublicConn(clientID, connID, tc, closeReasonClientClose)
+			return
+		}
+		kind := tc.deadlines.armWrite(tc.Conn)
+		n, err := tc.Write(payload)
+		s.recordBytesOut(clientInfo, n)
+		if err != nil && isDeadlineErr(err) && kind != "" {
+			s.expirePublicConn(clientInfo, connID, tc, kind)
+			return
+		}
+		if err != nil && tc.closeLocal() {
+			log.Printf("写入外部连接错误 (clientID=%s, connID=%d, trace=%s): %v", clientID, connID, tc.traceID, err)
+			s.sendCloseFrame(clientID, connID, tc.traceID, proto.CloseError)
+			s.releasePublicConn(clientInfo, clientID, connID, tc, closeReasonError)
+			return
+		}
+		if err == nil && s.connQuotaExceeded(tc) {
+			s.closeOverQuota(clientInfo, clientID, connID, tc)
+			return
+		}
+		// 写入失败但客户端已发送 CLOSE_CONN：继续排空队列，由队列关闭的分支完成清理
+	}
+}
+
+// releasePublicConn 关闭外部连接、删除映射并记录连接结束
+// 只由 closeLocal 返回 true 的一方调用（客户端先关闭时由 handleCloseFrame 清理）
+func (s *Server) releasePublicConn(clientInfo *ClientInfo, clientID string, connID uint32, tc *trackedConn, reason string) {
+	if tc.out != nil {
+		tc.out.stop()
+	}
+	tc.Close()
+	clientInfo.ConnMap.Delete(connID)
+	s.finishPublicConn(clientID, connID, tc, reason)
+	log.Printf("外部连接已关闭: clientID=%s, connID=%d, trace=%s", clientID, connID, tc.traceID)
+}
+
+// connQuotaExceeded 判断公开连接的累计传输字节数（两个方向合计）是否已超出 maxBytesPerConn
+func (s *Server) connQuotaExceeded(tc *trackedConn) bool {
+	return s.maxBytesPerConn > 0 && atomic.LoadUint64(&tc.bytesIn)+atomic.LoadUint64(&tc.bytesOut) > uint64(s.maxBytesPerConn)
+}
+
+// closeOverQuota 关闭超出传输字节配额的公开连接，并以 CloseQuota 通知客户端关闭对应的本地连接
+// 连接已被另一方关闭（closeLocal 返回 false）时由其完成清理
+func (s *Server) closeOverQuota(clientInfo *ClientInfo, clientID string, connID uint32, tc *trackedConn) {
+	if !tc.closeLocal() {
+		return
+	}
+	log.Printf("外部连接传输字节数超出配额 (%d)，强制关闭: clientID=%s, connID=%d, trace=%s", s.maxBytesPerConn, clientID, connID, tc.traceID)
+	s.sendCloseFrame(clientID, connID, tc.traceID, proto.CloseQuota)
+	s.releasePublicConn(clientInfo, clientID, connID, tc, closeReasonQuota)
+}
+
+// sendCloseFrame 发送携带关闭原因的 CLOSE_CONN 帧给 client
+func (s *Server) sendCloseFrame(clientID string, connID uint32, traceID string, reason proto.CloseReason) {
+	// 获取客户端信息
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	
+	if !ok || clientInfo.Conn == nil {
+		return
+	}
+
+	frame := &proto.Frame{
+		Type:    proto.FrameTypeCLOSE,
+		ConnID:  connID,
+		Payload: proto.EncodeCloseReason(reason),
+	}
+
+	if err := writeFrame(clientInfo.Conn, &clientInfo.writeMu, frame, s.writeTimeout()); err != nil {
+		log.Printf("发送 CLOSE_CONN 帧错误 (clientID=%s, connID=%d, trace=%s): %v", clientID, connID, traceID, err)
 	}
 }
 
 // handleInitFrame 处理初始化配置帧
-func (s *Server) handleInitFrame(ctx context.Context, clientID string, frame *proto.Frame) {
+// 负载无法解析（格式错误、过大）时回复 ERROR 并返回错误，由调用方断开控制连接；
+// 端口无效、绑定失败等配置问题只回复 ERROR，控制连接保持
+func (s *Server) handleInitFrame(ctx context.Context, clientID string, frame *proto.Frame) error {
 	// 获取客户端信息
 	s.clientsMu.Lock()
 	clientInfo, ok := s.clients[clientID]
@@ -540,63 +1306,356 @@ func (s *Server) handleInitFrame(ctx context.Context, clientID string, frame *pr
 	
 	if !ok {
 		log.Printf("错误: 客户端不存在 (clientID=%s)", clientID)
-		return
+		return nil
 	}
 	
-	// 如果服务器已经指定了公开端口，客户端使用全局监听器
+	// 解析配置
+	config, err := proto.DecodeInitConfig(frame.Payload)
+	if err != nil {
+		s.sendInitResult(clientID, clientInfo.Conn, &clientInfo.writeMu, proto.FrameTypeERROR, fmt.Sprintf("无效的 INIT 配置: %v", err))
+		return fmt.Errorf("无效的 INIT 帧 (%d 字节): %w", len(frame.Payload), err)
+	}
+	for _, hostname := range config.Hostnames {
+		if err := ValidateHostnamePattern(hostname); err != nil {
+			log.Printf("INIT 配置中的主机名无效 (clientID=%s): %v", clientID, err)
+			s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, err.Error())
+			return nil
+		}
+	}
+	// 使用证书认证的客户端只能声明其证书 SAN 覆盖的主机名，防止抢占其他租户的主机名
+	if cert := peerCertificate(clientInfo.Conn); cert != nil {
+		for _, hostname := range config.Hostnames {
+			if !certCoversHostname(cert, hostname) {
+				log.Printf("拒绝客户端声明的主机名 %q: 不在客户端证书的 SAN 中 (clientID=%s, 身份=%q)", hostname, clientID, clientInfo.Identity)
+				s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("主机名 %q 不在客户端证书的 SAN 中", hostname))
+				return nil
+			}
+		}
+	}
+	// 策略的路由规则限制该身份可以声明的主机名和请求的远程端口（全局模式下远程端口被忽略，不检查）
+	rule, restricted := s.currentPolicy().RoutesFor(clientInfo.Identity)
+	// 单向 TLS 下没有证书的客户端无法证明主机名归属，只能声明策略路由规则明确允许的主机名
+	if s.useTLS && s.tlsClientCertOptional && len(config.Hostnames) > 0 && peerCertificate(clientInfo.Conn) == nil &&
+		(!restricted || len(rule.Hostnames) == 0) {
+		log.Printf("拒绝没有客户端证书的客户端声明主机名 %q: 策略未明确允许 (clientID=%s)", config.Hostnames, clientID)
+		s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, "没有客户端证书的客户端只能声明策略路由规则允许的主机名")
+		return nil
+	}
+	if restricted {
+		for _, hostname := range config.Hostnames {
+			if !rule.AllowsHostname(hostname) {
+				log.Printf("拒绝客户端声明的主机名 %q: 策略不允许 (clientID=%s, 身份=%q)", hostname, clientID, clientInfo.Identity)
+				s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("策略不允许身份 %q 使用主机名 %q", clientInfo.Identity, hostname))
+				return nil
+			}
+		}
+	}
+
+	// 公开监听器只接受 TCP 连接，数据报隧道需要 UDP 公开端口，服务器尚不支持
+	if config.Framing != proto.FramingStream {
+		log.Printf("拒绝客户端的 %s 隧道: 服务器只支持字节流隧道 (clientID=%s)", config.Framing, clientID)
+		s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("服务器不支持 %s 分帧的隧道", config.Framing))
+		return nil
+	}
+
+	if config.Weight > MaxClientWeight {
+		log.Printf("INIT 配置中的权重无效 (clientID=%s): %d", clientID, config.Weight)
+		s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("无效的权重: %d（不能大于 %d）", config.Weight, MaxClientWeight))
+		return nil
+	}
+
+	// 如果服务器已经指定了公开端口，客户端使用全局监听器（可按主机名路由）
+	// 客户端请求的远程端口被忽略，在 ASSIGNED 中明确告知客户端，避免客户端误以为独占端口已生效
 	if s.publicListenAddr != "" {
-		log.Printf("服务器已指定公开端口，客户端 %s 使用全局监听器", clientID)
+		log.Printf("服务器已指定公开端口，客户端 %s 使用全局监听器 (主机名=%q)", clientID, config.Hostnames)
+		if config.RemotePort > 0 {
+			log.Printf("忽略客户端 %s 请求的远程端口 %d（服务器使用全局公开端口 %s）", clientID, config.RemotePort, s.publicListenAddr)
+		}
+		s.clientsMu.Lock()
+		features := clientInfo.Features
 		clientInfo.LocalAddr = ""
 		clientInfo.RemotePort = 0
-		return
+		// 策略为该身份分配的主机名与客户端声明的主机名合并
+		clientInfo.Hostnames = append(append([]string(nil), s.currentPolicy().HostnamesFor(clientInfo.Identity)...), config.Hostnames...)
+		clientInfo.Weight = config.Weight
+		if s.clients[clientID] == clientInfo {
+			s.notifyClientsChanged()
+			s.publicListenerMu.Lock()
+			if s.publicListener != nil {
+				s.notifyPortAssigned(clientInfo, s.publicListener)
+			}
+			s.publicListenerMu.Unlock()
+		}
+		s.clientsMu.Unlock()
+		// 未协商 FeatureAssignmentInfo 的客户端把整个负载当作地址，只发送地址
+		assignment := &proto.Assignment{Addr: s.publicListenAddr}
+		if features&proto.FeatureAssignmentInfo != 0 {
+			assignment.IgnoredPort = config.RemotePort
+		}
+		s.replyInit(clientID, clientInfo, proto.FrameTypeASSIGNED, string(proto.EncodeAssignment(assignment)))
+		return nil
 	}
-
-	// 解析配置
-	config, err := proto.DecodeInitConfig(frame.Payload)
-	if err != nil {
-		log.Printf("解析 INIT 配置错误 (clientID=%s): %v", clientID, err)
-		return
+	// 启用端口池时，远程端口为 0 表示从端口池租用一个空闲端口（已租用池内端口的客户端保持原端口）
+	// 租用的端口在创建监听器后由监听器持有（关闭时归还），此前返回时归还
+	leasedPort := 0
+	defer func() {
+		if leasedPort != 0 {
+			s.portPool.release(leasedPort)
+		}
+	}()
+	if s.portPool != nil && config.RemotePort == 0 && len(config.Hostnames) == 0 {
+		if port := s.pooledPort(clientInfo); port != 0 {
+			config.RemotePort = port
+		} else {
+			port, ok := s.portPool.lease(clientID, func(port int) bool { return !restricted || rule.AllowsPort(port) })
+			if !ok {
+				size, _ := s.portPool.stats()
+				log.Printf("端口池已耗尽 (%d 个端口)，拒绝客户端 %s", size, clientID)
+				s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("端口池中没有可用的端口 (共 %d 个)", size))
+				return nil
+			}
+			log.Printf("客户端 %s 从端口池租用端口 %d", clientID, port)
+			config.RemotePort, leasedPort = port, port
+		}
+	}
+	// 远程端口为 0 且未声明主机名（客户端运行期间取消远程端口）：释放专用端口，控制连接保持
+	// 取消后客户端没有公开入口，启用 requirePublicEndpoint 时拒绝，保持原端口
+	if config.RemotePort == 0 && len(config.Hostnames) == 0 {
+		if s.requirePublicEndpoint {
+			log.Printf("拒绝客户端 %s 取消远程端口: 服务器未配置全局公开端口", clientID)
+			s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, errNoPublicEndpoint)
+			return nil
+		}
+		log.Printf("警告: 客户端 %s 已取消远程端口，服务器未配置全局公开端口，该客户端没有可达的公开入口", clientID)
+		s.releaseClientPort(clientID, clientInfo, config.LocalAddr)
+		return nil
+	}
+	if len(config.Hostnames) > 0 && config.RemotePort == 0 {
+		s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, "服务器未启用全局公开端口，不支持主机名路由")
+		return nil
+	}
+	if config.RemotePort <= 0 || config.RemotePort > 65535 {
+		log.Printf("INIT 配置中的远程端口无效 (clientID=%s): %d", clientID, config.RemotePort)
+		s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("无效的远程端口: %d", config.RemotePort))
+		return nil
+	}
+	if restricted && !rule.AllowsPort(config.RemotePort) {
+		log.Printf("拒绝客户端请求的远程端口 %d: 策略不允许 (clientID=%s, 身份=%q)", config.RemotePort, clientID, clientInfo.Identity)
+		s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("策略不允许身份 %q 使用远程端口 %d", clientInfo.Identity, config.RemotePort))
+		return nil
+	}
+	// 显式请求池内端口的客户端同样租用该端口（已由该客户端租用时保持原监听器）
+	if leasedPort == 0 && s.portPool != nil && s.portPool.contains(config.RemotePort) && !s.holdsPoolPort(clientInfo, config.RemotePort) {
+		if err := s.portPool.leasePort(clientID, config.RemotePort); err != nil {
+			log.Printf("拒绝客户端请求的远程端口 %d (clientID=%s): %v", config.RemotePort, clientID, err)
+			s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, err.Error())
+			return nil
+		}
+		leasedPort = config.RemotePort
 	}
 
-	// 更新客户端信息
+	// 更新客户端信息（ClientInfo 的字段会被 unregisterClient、ClientStatus 等并发读取，需持有 clientsMu）
+	// 远程端口在新监听器就绪后才更新，更换端口失败时保持原端口
+	s.clientsMu.Lock()
 	clientInfo.LocalAddr = config.LocalAddr
-	clientInfo.RemotePort = config.RemotePort
+	existing := clientInfo.PublicListener
+	if existing == nil {
+		clientInfo.RemotePort = config.RemotePort
+	}
+	portsInUse := s.identityPortCountLocked(clientInfo.Identity)
+	s.clientsMu.Unlock()
 
 	// 如果客户端指定了远程端口，为该客户端创建独立的监听器
 	if config.RemotePort > 0 {
-		// 检查该客户端是否已经有监听器
-		if clientInfo.PublicListener != nil {
-			log.Printf("客户端 %s 的公开端口监听器已存在，忽略新配置", clientID)
-			return
+		// 已有监听器且端口未变化：保持原监听器
+		if existing != nil {
+			if listenerPort(existing) == config.RemotePort {
+				log.Printf("客户端 %s 的公开端口监听器已存在，忽略新配置", clientID)
+				s.replyInit(clientID, clientInfo, proto.FrameTypeASSIGNED, existing.Addr().String())
+				return nil
+			}
+			// 更换端口：新端口替换原端口，不额外占用配额
+			portsInUse--
+		}
+
+		// 身份的端口数配额
+		if s.portQuotaExceeded(clientInfo, portsInUse) {
+			s.rejectPortQuota(clientID, clientInfo, config.RemotePort)
+			return nil
 		}
 
 		// 创建该客户端专用的公开端口监听器
-		publicAddr := fmt.Sprintf(":%d", config.RemotePort)
-		listener, err := net.Listen("tcp", publicAddr)
+		publicAddr := net.JoinHostPort(s.clientPortBindAddr, strconv.Itoa(config.RemotePort))
+		listener, err := s.listenPublicPort(clientID, publicAddr)
 		if err != nil {
 			log.Printf("创建公开端口监听器失败 (clientID=%s, 端口 %d): %v", clientID, config.RemotePort, err)
-			return
+			s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("创建公开端口监听器失败 (端口 %d): %v", config.RemotePort, err))
+			return nil
+		}
+		if leasedPort != 0 {
+			listener = &leasedListener{Listener: listener, pool: s.portPool, port: leasedPort}
+			leasedPort = 0
+		}
+		if afterPublicListen != nil {
+			afterPublicListen(clientID)
 		}
 
+		// 绑定期间未持有锁：重新确认客户端仍在注册表中（可能已断开或服务器正在关闭）、
+		// 监听器未被并发的 INIT 替换且端口配额仍未超出，否则关闭刚创建的监听器，避免泄漏端口和 accept goroutine
+		s.clientsMu.Lock()
+		if current, ok := s.clients[clientID]; !ok || current != clientInfo {
+			s.clientsMu.Unlock()
+			listener.Close()
+			log.Printf("客户端 %s 在 INIT 处理期间已断开，关闭公开端口监听器: %s", clientID, publicAddr)
+			return nil
+		}
+		if clientInfo.PublicListener != existing {
+			s.clientsMu.Unlock()
+			listener.Close()
+			log.Printf("客户端 %s 的公开端口监听器在 INIT 处理期间已被替换，关闭监听器: %s", clientID, publicAddr)
+			return nil
+		}
+		portsInUse = s.identityPortCountLocked(clientInfo.Identity)
+		if existing != nil {
+			portsInUse--
+		}
+		if s.portQuotaExceeded(clientInfo, portsInUse) {
+			s.clientsMu.Unlock()
+			listener.Close()
+			s.rejectPortQuota(clientID, clientInfo, config.RemotePort)
+			return nil
+		}
 		clientInfo.PublicListener = listener
+		clientInfo.RemotePort = config.RemotePort
+		s.notifyPortAssigned(clientInfo, listener)
+		clientInfo.acceptLoops.Add(1)
+		s.clientsMu.Unlock()
+		if existing != nil {
+			s.drainPublicListener(clientID, existing)
+		}
 		log.Printf("根据客户端 %s 配置，公开端口监听器已启动: %s", clientID, publicAddr)
+		s.replyInit(clientID, clientInfo, proto.FrameTypeASSIGNED, listener.Addr().String())
 
 		// 启动接受连接的 goroutine（专门为该客户端）
-		go s.acceptPublicConnectionsForClient(ctx, clientID, listener)
+		go func() {
+			defer clientInfo.acceptLoops.Done()
+			s.acceptPublicConnections(ctx, listener, clientID)
+		}()
 	}
+	return nil
 }
 
-// cleanup 清理所有资源
-func (s *Server) cleanup() {
-	// 清理所有客户端
+// releaseClientPort 释放客户端的专用公开端口：关闭监听器（已建立的连接继续转发直到结束）并通知端口已释放，
+// 回复负载为空的 ASSIGNED 表示客户端当前没有公开端口
+func (s *Server) releaseClientPort(clientID string, clientInfo *ClientInfo, localAddr string) {
 	s.clientsMu.Lock()
-	for clientID := range s.clients {
-		s.unregisterClient(clientID)
+	existing := clientInfo.PublicListener
+	clientInfo.PublicListener = nil
+	clientInfo.RemotePort = 0
+	clientInfo.LocalAddr = localAddr
+	if existing != nil && s.clients[clientID] == clientInfo {
+		s.portNotifier.released(clientID)
 	}
-	s.clients = make(map[string]*ClientInfo)
 	s.clientsMu.Unlock()
+	if existing != nil {
+		s.drainPublicListener(clientID, existing)
+	}
+	s.replyInit(clientID, clientInfo, proto.FrameTypeASSIGNED, "")
+}
+
+// drainPublicListener 客户端更换或取消远程端口后关闭原端口的监听器：原端口不再接受新连接（accept 循环随之结束），
+// 已建立的公开连接不受影响，继续通过同一控制连接转发直到任一端关闭
+func (s *Server) drainPublicListener(clientID string, listener net.Listener) {
+	addr := listener.Addr().String()
+	if err := listener.Close(); err != nil {
+		log.Printf("关闭客户端 %s 原公开端口监听器失败 (%s): %v", clientID, addr, err)
+	}
+	log.Printf("客户端 %s 已更换或取消远程端口，原公开端口 %s 停止接受新连接，已建立的连接继续转发直到结束", clientID, addr)
+}
+
+// notifyPortAssigned 通知外部服务发现客户端的隧道已在 listener 上就绪
+// 调用方需持有 clientsMu，保证与 unregisterClient 中的释放通知有序
+func (s *Server) notifyPortAssigned(clientInfo *ClientInfo, listener net.Listener) {
+	s.portNotifier.assigned(PortAssignment{
+		ClientID: clientInfo.ID,
+		Identity: clientInfo.Identity,
+		Port:     listenerPort(listener),
+		Addr:     listener.Addr().String(),
+	})
+}
+
+// portQuotaExceeded 判断客户端所属身份在已占用 portsInUse 个端口时是否不能再占用新端口
+func (s *Server) portQuotaExceeded(clientInfo *ClientInfo, portsInUse int) bool {
+	t := clientInfo.tenant
+	if t == nil {
+		return false
+	}
+	quota := t.currentQuota()
+	return quota.MaxPorts > 0 && portsInUse >= quota.MaxPorts
+}
+
+// rejectPortQuota 记录日志并回复端口配额超出的 ERROR 帧
+func (s *Server) rejectPortQuota(clientID string, clientInfo *ClientInfo, remotePort int) {
+	maxPorts := clientInfo.tenant.currentQuota().MaxPorts
+	log.Printf("客户端身份 %q 的公开端口数已达配额 (%d)，拒绝端口 %d (clientID=%s)", clientInfo.Identity, maxPorts, remotePort, clientID)
+	s.replyInit(clientID, clientInfo, proto.FrameTypeERROR, fmt.Sprintf("超出配额: 最多 %d 个公开端口", maxPorts))
+}
+
+// replyInit 回复 INIT 处理结果，并按结果（生效或被拒绝）计数
+func (s *Server) replyInit(clientID string, clientInfo *ClientInfo, frameType proto.FrameType, payload string) {
+	outcome := frameOK
+	if frameType == proto.FrameTypeERROR {
+		outcome = frameRejected
+	}
+	s.frameStats.inc(proto.FrameTypeINIT, outcome)
+	s.sendInitResult(clientID, clientInfo.Conn, &clientInfo.writeMu, frameType, payload)
+	if frameType == proto.FrameTypeERROR {
+		s.publishEvent(&proto.Event{Kind: proto.EventError, ClientID: clientID, Identity: clientInfo.Identity, Detail: payload})
+	} else if a, err := proto.DecodeAssignment([]byte(payload)); err == nil {
+		s.publishEvent(&proto.Event{Kind: proto.EventReady, ClientID: clientID, Identity: clientInfo.Identity, Addr: a.Addr})
+	}
+}
 
-	// 关闭全局公开端口监听器
+// sendInitResult 向客户端回复 INIT 处理结果（ASSIGNED 或 ERROR），writeMu 为该控制连接的写锁（注册前为 nil）
+func (s *Server) sendInitResult(clientID string, conn net.Conn, writeMu *controlWriter, frameType proto.FrameType, payload string) {
+	frame := &proto.Frame{
+		Type:    frameType,
+		ConnID:  0,
+		Payload: []byte(payload),
+	}
+	if err := writeFrame(conn, writeMu, frame, s.writeTimeout()); err != nil {
+		log.Printf("发送 INIT 响应失败 (clientID=%s): %v", clientID, err)
+	}
+}
+
+// cleanup 清理所有资源
+// 清理超过 shutdownTimeout 仍未完成时（例如某个连接的 Close 阻塞）放弃剩余的关闭操作直接返回，
+// 未关闭的连接随进程退出释放，保证进程能够按时退出
+func (s *Server) cleanup() {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+
+	timeout := s.currentShutdownTimeout()
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	done := make(chan struct{})
+	go func() {
+		s.closeAll()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Printf("服务器资源已清理")
+	case <-time.After(timeout):
+		log.Printf("警告: 清理资源超过 %v 仍未完成，放弃剩余的关闭操作", timeout)
+	}
+}
+
+// closeAll 按顺序关闭服务器资源：
+//  1. 关闭全局和各客户端专用的公开端口监听器，不再接受新的公开连接
+//  2. 关闭进行中的公开连接，并通过仍然打开的控制连接向客户端发送 CLOSE_CONN
+//  3. 注销所有客户端，最后关闭控制连接
+func (s *Server) closeAll() {
 	s.publicListenerMu.Lock()
 	if s.publicListener != nil {
 		s.publicListener.Close()
@@ -604,5 +1663,39 @@ func (s *Server) cleanup() {
 	}
 	s.publicListenerMu.Unlock()
 
-	log.Printf("服务器资源已清理")
+	// unregisterClient 自行加锁，这里只收集客户端
+	s.clientsMu.RLock()
+	clients := make(map[string]*ClientInfo, len(s.clients))
+	for clientID, clientInfo := range s.clients {
+		clients[clientID] = clientInfo
+		if clientInfo.PublicListener != nil {
+			clientInfo.PublicListener.Close()
+		}
+	}
+	s.clientsMu.RUnlock()
+	for _, clientInfo := range clients {
+		waitAcceptLoops(clientInfo)
+	}
+
+	for clientID, clientInfo := range clients {
+		s.closeClientConns(clientID, clientInfo)
+	}
+
+	for clientID := range clients {
+		s.unregisterClient(clientID)
+	}
+	s.closeObservers()
+}
+
+// closeClientConns 服务器关闭时关闭客户端的所有公开连接，并发送 CLOSE_CONN 通知客户端关闭对应的本地连接
+// 已由对端或转发 goroutine 关闭的连接（closeLocal 返回 false）留给其清理方，随后的 unregisterClient 统一收尾
+func (s *Server) closeClientConns(clientID string, clientInfo *ClientInfo) {
+	clientInfo.ConnMap.Range(func(key, value interface{}) bool {
+		connID, tc := key.(uint32), value.(*trackedConn)
+		if tc.closeLocal() {
+			s.sendCloseFrame(clientID, connID, tc.traceID, proto.CloseShutdown)
+			s.releasePublicConn(clientInfo, clientID, connID, tc, closeReasonShutdown)
+		}
+		return true
+	})
 }
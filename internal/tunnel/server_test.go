@@ -1,13 +1,26 @@
 package tunnel
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"net/http"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+
+	"reverse-tunnel/internal/pqctls"
+	"reverse-tunnel/internal/proto"
 )
 
 // TestReverseTunnelFlow 测试完整的反向隧道流程
@@ -44,7 +57,7 @@ func TestReverseTunnelFlow(t *testing.T) {
 	t.Logf("反向隧道服务器已启动: control=%s, public=%s", controlAddr, publicAddr)
 
 	// 3. 启动客户端
-	client := NewClient(controlAddr, localAddr)
+	client := NewClient(controlAddr, localAddr, 0)
 	clientCtx, clientCancel := context.WithCancel(context.Background())
 	defer clientCancel()
 
@@ -156,7 +169,7 @@ func TestMultipleConnections(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// 启动客户端
-	client := NewClient(controlAddr, localAddr)
+	client := NewClient(controlAddr, localAddr, 0)
 	clientCtx, clientCancel := context.WithCancel(context.Background())
 	defer clientCancel()
 
@@ -276,7 +289,7 @@ func TestClientReconnect(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// 启动客户端
-	client := NewClient(controlAddr, localAddr)
+	client := NewClient(controlAddr, localAddr, 0)
 	clientCtx, clientCancel := context.WithCancel(context.Background())
 	defer clientCancel()
 
@@ -356,6 +369,93 @@ func TestClientReconnect(t *testing.T) {
 	}
 }
 
+// TestClientReconnectRemotePort 测试客户端指定的远程端口在服务器重启后重新绑定：
+// 客户端重连后重新发送 INIT，新服务器在同一端口上监听并转发（原服务器关闭的公开连接处于 TIME_WAIT 时同样可以绑定）
+func TestClientReconnectRemotePort(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	remotePort := getFreePort(t)
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", remotePort)
+
+	// echo 通过公开端口发送一条消息并检查回显
+	echo := func(conn net.Conn, msg string) error {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, response); err != nil {
+			return err
+		}
+		if string(response) != msg {
+			return fmt.Errorf("响应不匹配: 期望 %q, 得到 %q", msg, response)
+		}
+		return nil
+	}
+
+	server := NewServer(controlAddr, "")
+	go server.Run(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewClient(controlAddr, localAddr, remotePort).Run(ctx)
+
+	var conn net.Conn
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if conn, err = net.DialTimeout("tcp", publicAddr, time.Second); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("远程端口 %d 未就绪: %v", remotePort, err)
+	}
+	if err := echo(conn, "before restart"); err != nil {
+		t.Fatalf("重启前转发失败: %v", err)
+	}
+
+	// 重启服务器：关闭时原公开连接由服务器一端关闭，公开端口上留下 TIME_WAIT 连接
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("关闭服务器失败: %v", err)
+	}
+	conn.Close()
+	if c, err := net.DialTimeout("tcp", publicAddr, time.Second); err == nil {
+		c.Close()
+		t.Fatalf("服务器关闭后远程端口 %d 仍在监听", remotePort)
+	}
+
+	server2 := NewServer(controlAddr, "")
+	server2Ctx, server2Cancel := context.WithCancel(context.Background())
+	defer server2Cancel()
+	go server2.Run(server2Ctx)
+
+	// 客户端重连间隔为 5 秒
+	var lastErr error
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); time.Sleep(200 * time.Millisecond) {
+		conn, lastErr = net.DialTimeout("tcp", publicAddr, time.Second)
+		if lastErr != nil {
+			continue
+		}
+		lastErr = echo(conn, "after restart")
+		conn.Close()
+		if lastErr == nil {
+			break
+		}
+	}
+	if lastErr != nil {
+		t.Fatalf("客户端重连后远程端口 %d 不可用: %v", remotePort, lastErr)
+	}
+	if status := server2.ClientStatus(); len(status) != 1 || status[0].RemotePort != remotePort {
+		t.Errorf("重连后客户端应使用远程端口 %d，得到 %+v", remotePort, status)
+	}
+}
+
 // TestLargeDataTransfer 测试大数据传输
 func TestLargeDataTransfer(t *testing.T) {
 	localPort := getFreePort(t)
@@ -375,7 +475,7 @@ func TestLargeDataTransfer(t *testing.T) {
 	go server.Run(serverCtx)
 	time.Sleep(100 * time.Millisecond)
 
-	client := NewClient(controlAddr, localAddr)
+	client := NewClient(controlAddr, localAddr, 0)
 	clientCtx, clientCancel := context.WithCancel(context.Background())
 	defer clientCancel()
 
@@ -409,3 +509,2194 @@ func TestLargeDataTransfer(t *testing.T) {
 		t.Logf("大数据传输测试通过: %d 字节", len(largeData))
 	}
 }
+
+// TestClientWaitsForLocalReady 测试客户端在本地服务就绪前不连接服务器
+func TestClientWaitsForLocalReady(t *testing.T) {
+	localPort := getFreePort(t)
+	localAddr := fmt.Sprintf("127.0.0.1:%d", localPort)
+
+	controlPort := getFreePort(t)
+	publicPort := getFreePort(t)
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", controlPort)
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", publicPort)
+
+	server := NewServer(controlAddr, publicAddr)
+	serverCtx, serverCancel := context.WithCancel(context.Background())
+	defer serverCancel()
+
+	go server.Run(serverCtx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, 0, WithLocalReadyTimeout(10*time.Second))
+	clientCtx, clientCancel := context.WithCancel(context.Background())
+	defer clientCancel()
+
+	go client.Run(clientCtx)
+	time.Sleep(500 * time.Millisecond)
+
+	// 本地服务尚未启动，客户端不应注册到服务器
+	server.clientsMu.RLock()
+	numClients := len(server.clients)
+	server.clientsMu.RUnlock()
+	if numClients != 0 {
+		t.Fatalf("本地服务未就绪时客户端不应连接服务器，当前客户端数: %d", numClients)
+	}
+
+	// 启动本地服务后客户端应连接并正常转发
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+	time.Sleep(1500 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer conn.Close()
+
+	testMsg := "Local Ready"
+	if _, err := conn.Write([]byte(testMsg)); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response := make([]byte, len(testMsg))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	if string(response) != testMsg {
+		t.Errorf("响应不匹配: 期望 %q, 得到 %q", testMsg, string(response))
+	}
+}
+
+// TestControlWriteTimeoutBlackhole 测试控制连接对端不读取数据时，写入超时会注销该客户端
+func TestControlWriteTimeoutBlackhole(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	server := NewServer(controlAddr, publicAddr, WithServerControlWriteTimeout(300*time.Millisecond))
+	serverCtx, serverCancel := context.WithCancel(context.Background())
+	defer serverCancel()
+
+	go server.Run(serverCtx)
+	time.Sleep(100 * time.Millisecond)
+
+	// 黑洞客户端：连接控制端口但从不读取
+	blackhole, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接控制端口失败: %v", err)
+	}
+	defer blackhole.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	publicConn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer publicConn.Close()
+
+	// 持续写入直到填满内核缓冲区，使服务器的 DATA 帧写入阻塞
+	go func() {
+		chunk := make([]byte, 64*1024)
+		for {
+			publicConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := publicConn.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		server.clientsMu.RLock()
+		numClients := len(server.clients)
+		server.clientsMu.RUnlock()
+		if numClients == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("写入超时后客户端未被注销")
+}
+
+// syncBuffer 是并发安全的 bytes.Buffer，用于收集日志输出
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestAccessLog 测试公开连接结束时写入访问日志
+func TestAccessLog(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	accessLog := &syncBuffer{}
+	server := NewServer(controlAddr, publicAddr, WithServerAccessLog(accessLog))
+	serverCtx, serverCancel := context.WithCancel(context.Background())
+	defer serverCancel()
+
+	go server.Run(serverCtx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, 0)
+	clientCtx, clientCancel := context.WithCancel(context.Background())
+	defer clientCancel()
+
+	go client.Run(clientCtx)
+	time.Sleep(500 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	testMsg := "access log"
+	if _, err := conn.Write([]byte(testMsg)); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, len(testMsg))); err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	conn.Close()
+	time.Sleep(300 * time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(accessLog.String()), "\n")
+	if len(lines) != 1 || lines[0] == "" {
+		t.Fatalf("期望 1 条访问日志，得到 %d 条: %q", len(lines), accessLog.String())
+	}
+
+	var rec AccessRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("解析访问日志失败: %v", err)
+	}
+	if rec.BytesIn != uint64(len(testMsg)) || rec.BytesOut != uint64(len(testMsg)) {
+		t.Errorf("字节数不匹配: in=%d out=%d, 期望 %d", rec.BytesIn, rec.BytesOut, len(testMsg))
+	}
+	if rec.CloseReason != closeReasonEOF || rec.CloseCategory != closeCategoryClientEOF {
+		t.Errorf("关闭原因不匹配: 期望 %q (%s), 得到 %q (%s)", closeReasonEOF, closeCategoryClientEOF, rec.CloseReason, rec.CloseCategory)
+	}
+	if rec.TraceID == "" || rec.ClientID == "" || rec.Source == "" {
+		t.Errorf("访问日志缺少字段: %+v", rec)
+	}
+}
+
+// TestMaxBytesPerConn 测试公开连接累计传输字节数刚超出 MaxBytesPerConn 时被强制关闭，访问日志记录 quota 原因
+func TestMaxBytesPerConn(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	const limit = 1024
+	accessLog := &syncBuffer{}
+	server := NewServer(controlAddr, publicAddr, WithServerAccessLog(accessLog), WithServerMaxBytesPerConn(limit))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, 0)
+	go client.Run(ctx)
+	time.Sleep(500 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	// 恰好达到配额（512 字节发出 + 512 字节回显）：连接保持
+	msg := strings.Repeat("x", limit/2)
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, len(msg))); err != nil {
+		t.Fatalf("达到配额前读取回显失败: %v", err)
+	}
+
+	// 再发送 1 字节即超出配额：连接被关闭
+	if _, err := conn.Write([]byte("y")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	if _, err := io.ReadAll(conn); err != nil && !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("超出配额后连接应被关闭: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for accessLog.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var rec AccessRecord
+	if err := json.Unmarshal([]byte(strings.TrimSpace(accessLog.String())), &rec); err != nil {
+		t.Fatalf("解析访问日志失败: %v (%q)", err, accessLog.String())
+	}
+	if rec.CloseReason != closeReasonQuota || rec.CloseCategory != closeCategoryRateLimited {
+		t.Errorf("关闭原因为 %q (%s)，期望 %q (%s)", rec.CloseReason, rec.CloseCategory, closeReasonQuota, closeCategoryRateLimited)
+	}
+	if total := rec.BytesIn + rec.BytesOut; total <= limit {
+		t.Errorf("关闭时累计传输 %d 字节，应刚超出配额 %d", total, limit)
+	}
+}
+
+// TestClientBye 测试客户端停止时发送 BYE，服务器收到后立即注销客户端（不等待控制连接断开），访问日志记录 client_exit 原因
+func TestClientBye(t *testing.T) {
+	t.Run("client", func(t *testing.T) {
+		control := newMemListener("control")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := NewClient("control", "local", 0, WithControlDialer(control))
+		go client.Run(ctx)
+
+		conn, err := control.Accept()
+		if err != nil {
+			t.Fatalf("接受控制连接失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if frame, err := proto.DecodeFrame(conn); err != nil || frame.Type != proto.FrameTypeHELLO {
+			t.Fatalf("期望 HELLO 帧，得到 %+v, %v", frame, err)
+		}
+		if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(&proto.Hello{})}, time.Second); err != nil {
+			t.Fatalf("发送 HELLO 失败: %v", err)
+		}
+
+		go client.Shutdown(context.Background())
+		if frame, err := proto.DecodeFrame(conn); err != nil || frame.Type != proto.FrameTypeBYE {
+			t.Fatalf("客户端停止时应发送 BYE，得到 %+v, %v", frame, err)
+		}
+	})
+
+	t.Run("server", func(t *testing.T) {
+		control := newMemListener("control")
+		public := newMemListener("public")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		accessLog := &syncBuffer{}
+		server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerAccessLog(accessLog))
+		go server.Run(ctx)
+
+		conn, err := control.DialContext(ctx, "mem", "control")
+		if err != nil {
+			t.Fatalf("连接控制监听器失败: %v", err)
+		}
+		defer conn.Close()
+		clientCount := func() int { return len(server.ClientStatus()) }
+		waitStat(t, "已注册的客户端", clientCount, 1)
+
+		publicConn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer publicConn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if frame, err := proto.DecodeFrame(conn); err != nil || frame.Type != proto.FrameTypeNEW_CONN {
+			t.Fatalf("期望 NEW_CONN 帧，得到 %+v, %v", frame, err)
+		}
+		// 收到 DATA 帧时连接已登记（转发在登记后开始）
+		go publicConn.Write([]byte("ping"))
+		if frame, err := proto.DecodeFrame(conn); err != nil || frame.Type != proto.FrameTypeDATA {
+			t.Fatalf("期望 DATA 帧，得到 %+v, %v", frame, err)
+		}
+
+		// 发送 BYE 后不关闭控制连接：服务器应立即注销客户端并关闭控制连接
+		if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeBYE}, time.Second); err != nil {
+			t.Fatalf("发送 BYE 失败: %v", err)
+		}
+		waitStat(t, "已注册的客户端", clientCount, 0)
+		if _, err := proto.DecodeFrame(conn); err == nil {
+			t.Errorf("服务器收到 BYE 后应关闭控制连接")
+		}
+
+		waitStat(t, "访问日志", func() bool { return accessLog.String() != "" }, true)
+		var rec AccessRecord
+		if err := json.Unmarshal([]byte(strings.TrimSpace(accessLog.String())), &rec); err != nil {
+			t.Fatalf("解析访问日志失败: %v (%q)", err, accessLog.String())
+		}
+		if rec.CloseReason != closeReasonClientExit || rec.CloseCategory != closeCategoryShutdown {
+			t.Errorf("关闭原因为 %q (%s)，期望 %q (%s)", rec.CloseReason, rec.CloseCategory, closeReasonClientExit, closeCategoryShutdown)
+		}
+	})
+}
+
+// rejectingListener 第一次 Accept 返回被拒绝的握手，之后委托给内部监听器
+type rejectingListener struct {
+	net.Listener
+	rejected atomic.Bool
+}
+
+func (l *rejectingListener) Accept() (net.Conn, error) {
+	if l.rejected.CompareAndSwap(false, true) {
+		return nil, &pqctls.HandshakeError{
+			RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000},
+			Reason:     pqctls.RejectNonPQC,
+			Err:        errors.New("handshake succeeded but non-PQC algorithms were negotiated, connection rejected"),
+		}
+	}
+	return l.Listener.Accept()
+}
+
+// TestSecurityLog 测试被拒绝的握手写入安全日志并计入指标，且不影响之后的客户端连接
+func TestSecurityLog(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	securityLog := &syncBuffer{}
+	server := NewServer("", publicAddr, WithServerControlListener(&rejectingListener{Listener: inner}), WithServerSecurityLog(securityLog))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	go NewClient(inner.Addr().String(), localAddr, 0).Run(ctx)
+	deadline := time.Now().Add(3 * time.Second)
+	for len(server.ClientStatus()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(server.ClientStatus()) == 0 {
+		t.Fatalf("拒绝握手后服务器应继续接受客户端连接")
+	}
+
+	var rec SecurityRecord
+	if err := json.Unmarshal([]byte(strings.TrimSpace(securityLog.String())), &rec); err != nil {
+		t.Fatalf("解析安全日志失败: %v (%q)", err, securityLog.String())
+	}
+	if rec.Event != "handshake_rejected" || rec.Reason != pqctls.RejectNonPQC || rec.Source != "192.0.2.1:40000" || rec.Error == "" {
+		t.Errorf("安全日志记录不匹配: %+v", rec)
+	}
+
+	var buf bytes.Buffer
+	server.writeMetrics(&buf)
+	for _, want := range []string{
+		`reverse_tunnel_handshake_rejected_total{reason="non_pqc"} 1`,
+		`reverse_tunnel_handshake_rejected_total{reason="auth_failed"} 0`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("指标缺少 %s", want)
+		}
+	}
+}
+
+// TestCloseReasonReset 测试本地服务重置连接时，公开连接同样被重置，并在访问日志中记录客户端给出的关闭原因
+func TestCloseReasonReset(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localListener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		t.Fatalf("启动本地服务失败: %v", err)
+	}
+	defer localListener.Close()
+	go func() {
+		for {
+			conn, err := localListener.Accept()
+			if err != nil {
+				return
+			}
+			// 读到请求后以 RST 关闭连接
+			conn.Read(make([]byte, 64))
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+	}()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	accessLog := &syncBuffer{}
+	server := NewServer(controlAddr, publicAddr, WithServerAccessLog(accessLog))
+	serverCtx, serverCancel := context.WithCancel(context.Background())
+	defer serverCancel()
+
+	go server.Run(serverCtx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, 0)
+	clientCtx, clientCancel := context.WithCancel(context.Background())
+	defer clientCancel()
+
+	go client.Run(clientCtx)
+	time.Sleep(500 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("reset me")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 64))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("期望公开连接被重置，得到: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(accessLog.String()), "\n")
+	if len(lines) != 1 || lines[0] == "" {
+		t.Fatalf("期望 1 条访问日志，得到 %d 条: %q", len(lines), accessLog.String())
+	}
+	var rec AccessRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("解析访问日志失败: %v", err)
+	}
+	if rec.CloseReason != closeReasonClientClose || rec.ClientCloseReason != proto.CloseReset.String() || rec.CloseCategory != closeCategoryError {
+		t.Errorf("关闭原因不匹配: close_reason=%q client_close_reason=%q close_category=%q", rec.CloseReason, rec.ClientCloseReason, rec.CloseCategory)
+	}
+}
+
+// TestPublicErrorResponse 测试客户端连接本地服务失败时，启用 http 错误响应的服务器向公开连接回复 502，未启用时直接关闭
+func TestPublicErrorResponse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 本地地址上没有服务，客户端连接本地服务失败
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	for _, mode := range []string{"", PublicErrorResponseHTTP} {
+		controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+		publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+		server := NewServer(controlAddr, publicAddr, WithServerPublicErrorResponse(mode))
+		go server.Run(ctx)
+		time.Sleep(100 * time.Millisecond)
+		go NewClient(controlAddr, localAddr, 0).Run(ctx)
+		time.Sleep(300 * time.Millisecond)
+
+		conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+		if err != nil {
+			t.Fatalf("连接公开端口失败: %v", err)
+		}
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		resp, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil && !errors.Is(err, syscall.ECONNRESET) {
+			t.Fatalf("模式 %q: 读取公开连接失败: %v", mode, err)
+		}
+
+		if mode == "" {
+			if len(resp) != 0 {
+				t.Errorf("未启用错误响应时不应收到数据，得到 %q", resp)
+			}
+			continue
+		}
+		if !strings.HasPrefix(string(resp), "HTTP/1.1 502 Bad Gateway\r\n") {
+			t.Fatalf("期望 502 响应，得到 %q", resp)
+		}
+		parsed, err := http.ReadResponse(bufio.NewReader(strings.NewReader(string(resp))), nil)
+		if err != nil {
+			t.Fatalf("解析 502 响应失败: %v", err)
+		}
+		body, err := io.ReadAll(parsed.Body)
+		if err != nil || int64(len(body)) != parsed.ContentLength {
+			t.Errorf("响应体与 Content-Length 不一致: %q (Content-Length=%d), %v", body, parsed.ContentLength, err)
+		}
+	}
+}
+
+// TestClientRedirect 测试服务器通过 REDIRECT 帧将客户端迁移到另一台服务器，
+// 以及重定向目标不可达时回退到配置的服务器
+func TestClientRedirect(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	controlA := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	controlB := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicB := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverA := NewServer(controlA, "")
+	serverB := NewServer(controlB, publicB)
+	go serverA.Run(ctx)
+	go serverB.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlA, localAddr, 0)
+	go client.Run(ctx)
+
+	waitClients := func(s *Server, want int) bool {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if len(s.ClientStatus()) == want {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	if !waitClients(serverA, 1) {
+		t.Fatal("客户端未连接到服务器 A")
+	}
+
+	// 重定向到不可达地址：客户端应回退到服务器 A
+	unreachable := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	if n := serverA.RedirectClients(unreachable); n != 1 {
+		t.Fatalf("期望通知 1 个客户端，实际 %d", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !waitClients(serverA, 1) {
+		t.Fatal("重定向目标不可达时客户端未回退到服务器 A")
+	}
+
+	// 重定向到服务器 B
+	if n := serverA.RedirectClients(controlB); n != 1 {
+		t.Fatalf("期望通知 1 个客户端，实际 %d", n)
+	}
+	if !waitClients(serverB, 1) {
+		t.Fatal("客户端未迁移到服务器 B")
+	}
+	if !waitClients(serverA, 0) {
+		t.Fatal("客户端迁移后仍留在服务器 A")
+	}
+
+	// 验证服务器 B 上的隧道可用
+	conn, err := net.DialTimeout("tcp", publicB, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接服务器 B 公开端口失败: %v", err)
+	}
+	defer conn.Close()
+
+	msg := "redirected"
+	conn.Write([]byte(msg))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	if string(response) != msg {
+		t.Errorf("响应不匹配: 期望 %q, 得到 %q", msg, string(response))
+	}
+
+	if err := serverB.RedirectClient("client-1", "not-an-address"); err == nil {
+		t.Error("无效的重定向地址应返回错误")
+	}
+}
+
+// TestIPv6Tunnel 测试通过 [::1] 建立控制连接和公开连接
+func TestIPv6Tunnel(t *testing.T) {
+	probe, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("环境不支持 IPv6: %v", err)
+	}
+	probe.Close()
+
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	controlAddr := fmt.Sprintf("[::1]:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("[::1]:%d", getFreePort(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, publicAddr, WithServerNetwork("tcp6"))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, 0, WithNetwork("tcp6"))
+	go client.Run(ctx)
+	time.Sleep(500 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp6", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接 IPv6 公开端口失败: %v", err)
+	}
+	defer conn.Close()
+
+	msg := "hello over ipv6"
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatalf("读取响应失败: %v", err)
+	}
+	if string(response) != msg {
+		t.Errorf("响应不匹配: 期望 %q, 得到 %q", msg, string(response))
+	}
+
+	statuses := server.ClientStatus()
+	if len(statuses) != 1 || !strings.HasPrefix(statuses[0].RemoteAddr, "[::1]:") {
+		t.Errorf("客户端控制连接应来自 [::1]，得到 %+v", statuses)
+	}
+}
+
+// TestMaxControlConnLifetime 测试控制连接达到最大存活时间后由客户端或服务器触发重建
+func TestMaxControlConnLifetime(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	waitClientID := func(s *Server, id string) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			statuses := s.ClientStatus()
+			if len(statuses) == 1 && statuses[0].ID == id {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	for _, side := range []string{"client", "server"} {
+		controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+		publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var serverOpts []ServerOption
+		var clientOpts []ClientOption
+		if side == "server" {
+			serverOpts = append(serverOpts, WithServerMaxControlConnLifetime(500*time.Millisecond))
+		} else {
+			clientOpts = append(clientOpts, WithMaxControlConnLifetime(500*time.Millisecond))
+		}
+
+		server := NewServer(controlAddr, publicAddr, serverOpts...)
+		go server.Run(ctx)
+		time.Sleep(100 * time.Millisecond)
+
+		client := NewClient(controlAddr, localAddr, 0, clientOpts...)
+		go client.Run(ctx)
+
+		if !waitClientID(server, "client-1") {
+			cancel()
+			t.Fatalf("[%s] 客户端未连接", side)
+		}
+		// 到期后客户端立即重建控制连接（没有活跃连接，无需等待）
+		if !waitClientID(server, "client-2") {
+			cancel()
+			t.Fatalf("[%s] 控制连接到期后未重建", side)
+		}
+
+		conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+		if err != nil {
+			cancel()
+			t.Fatalf("[%s] 重建后连接公开端口失败: %v", side, err)
+		}
+		msg := "after recycle"
+		conn.Write([]byte(msg))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response := make([]byte, len(msg))
+		_, err = io.ReadFull(conn, response)
+		conn.Close()
+		cancel()
+		if err != nil || string(response) != msg {
+			t.Fatalf("[%s] 重建后隧道不可用: %v", side, err)
+		}
+	}
+}
+
+// TestPublicQueueRejectPolicy 测试队列满且策略为 reject 时新连接被关闭并计数
+func TestPublicQueueRejectPolicy(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "", WithServerPublicQueue(1, QueuePolicyReject, 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 不启动 worker，队列容量为 1：第一个连接入队，第二个被拒绝
+	first, firstPeer := net.Pipe()
+	defer first.Close()
+	defer firstPeer.Close()
+	second, secondPeer := net.Pipe()
+	defer secondPeer.Close()
+
+	server.enqueuePublicConn(ctx, first, "client-1")
+	server.enqueuePublicConn(ctx, second, "client-1")
+
+	if depth := len(server.publicConnQueue); depth != 1 {
+		t.Errorf("队列深度应为 1，得到 %d", depth)
+	}
+	if rejected := atomic.LoadUint64(&server.publicQueueRejected); rejected != 1 {
+		t.Errorf("拒绝计数应为 1，得到 %d", rejected)
+	}
+	secondPeer.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := secondPeer.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("被拒绝的连接应已关闭，读取得到: %v", err)
+	}
+}
+
+// TestPublicFairQueue 测试公平队列按客户端轮询出队、每个客户端的排队数有上限，并输出排队指标
+func TestPublicFairQueue(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "", WithServerPublicClientQueue(2))
+	q := server.publicFairQueue
+	if q == nil {
+		t.Fatalf("设置每客户端队列容量后应启用公平队列")
+	}
+
+	// client-a 突发 3 个连接（第 3 个超出容量被拒绝），client-b 和 client-c 随后到达
+	for _, clientID := range []string{"client-a", "client-a", "client-a", "client-b", "client-c", "client-c"} {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		if !q.push(publicConnJob{conn: conn, clientID: clientID}) && clientID != "client-a" {
+			t.Fatalf("%s 的连接不应被拒绝", clientID)
+		}
+	}
+
+	var buf bytes.Buffer
+	server.writeMetrics(&buf)
+	for _, want := range []string{
+		`reverse_tunnel_client_queue_depth{client_id="client-a"} 2`,
+		`reverse_tunnel_client_queue_depth{client_id="client-c"} 2`,
+		`reverse_tunnel_client_queue_rejected_total{client_id="client-a"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("指标中缺少 %q", want)
+		}
+	}
+
+	var order []string
+	for {
+		job, ok := q.pop()
+		if !ok {
+			break
+		}
+		order = append(order, job.clientID)
+	}
+	want := []string{"client-a", "client-b", "client-c", "client-a", "client-c"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("出队顺序应为 %v，得到 %v", want, order)
+	}
+
+	q.forget("client-a")
+	if stats := q.stats(); len(stats) != 0 {
+		t.Errorf("队列清空且客户端注销后不应再输出排队指标，得到 %+v", stats)
+	}
+
+	// 启用公平队列时全局公开端口的连接经 worker 路由、入队后正常转发
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server = NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public), WithServerPublicClientQueue(2))
+	go server.Run(ctx)
+	client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local))
+	go client.Run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(server.ClientStatus()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 5; i++ {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		msg := []byte(fmt.Sprintf("fair %d", i))
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		go conn.Write(msg)
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, got); err != nil || string(got) != string(msg) {
+			t.Fatalf("连接 %d 回显失败: %q, %v", i, got, err)
+		}
+		conn.Close()
+	}
+}
+
+// TestInitAcknowledgement 测试客户端发送 INIT 后等待服务器的 ASSIGNED/ERROR 响应
+func TestInitAcknowledgement(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, "")
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// 端口可用：收到 ASSIGNED
+	client := NewClient(controlAddr, localAddr, getFreePort(t))
+	if err := client.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	if err := client.setupTunnel(ctx); err != nil {
+		t.Errorf("端口可用时隧道应建立成功: %v", err)
+	}
+	client.closeControlConn()
+
+	// 端口已被占用：收到 ERROR
+	occupied, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("占用端口失败: %v", err)
+	}
+	defer occupied.Close()
+
+	busyPort := occupied.Addr().(*net.TCPAddr).Port
+	client2 := NewClient(controlAddr, localAddr, busyPort)
+	if err := client2.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer client2.closeControlConn()
+	err = client2.setupTunnel(ctx)
+	if err == nil || !strings.Contains(err.Error(), "服务器拒绝隧道配置") {
+		t.Errorf("端口被占用时应收到服务器拒绝，得到: %v", err)
+	}
+}
+
+// TestInitAckTimeout 测试 INIT 确认超时：未进行特性协商的旧版本服务器继续使用该控制连接；
+// 完成特性协商的服务器未确认、或超时时帧只读取了一部分，返回错误由调用方重连
+func TestInitAckTimeout(t *testing.T) {
+	oldTimeout := initAckTimeout
+	initAckTimeout = 200 * time.Millisecond
+	defer func() { initAckTimeout = oldTimeout }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// setup 让客户端连接假服务器，服务器读取 HELLO 后的行为由 respond 决定，返回 setupTunnel 的结果
+	setup := func(respond func(conn net.Conn)) error {
+		control := newMemListener("control")
+		defer control.Close()
+		go func() {
+			conn, err := control.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if _, err := proto.DecodeFrame(conn); err != nil {
+				return
+			}
+			// net.Pipe 同步写入：响应与读取客户端的 INIT 并发进行
+			go respond(conn)
+			io.Copy(io.Discard, conn)
+		}()
+		client := NewClient("control", "127.0.0.1:80", 9000, WithControlDialer(control))
+		if err := client.connectToServer(ctx); err != nil {
+			t.Fatalf("连接服务器失败: %v", err)
+		}
+		defer client.closeControlConn()
+		if err := client.negotiateFeatures(ctx); err != nil {
+			t.Fatalf("特性协商失败: %v", err)
+		}
+		return client.setupTunnel(ctx)
+	}
+
+	if err := setup(func(net.Conn) {}); err != nil {
+		t.Errorf("旧版本服务器不回复 INIT 时应继续使用控制连接: %v", err)
+	}
+	hello := &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(&proto.Hello{Features: proto.SupportedFeatures})}
+	if err := setup(func(conn net.Conn) { writeFrame(conn, nil, hello, time.Second) }); err == nil {
+		t.Error("完成特性协商的服务器未确认 INIT 时应返回错误")
+	}
+	// 帧头写到一半后停止
+	if err := setup(func(conn net.Conn) { conn.Write([]byte{byte(proto.FrameTypeASSIGNED), 0, 0}) }); err == nil ||
+		!strings.Contains(err.Error(), "3 字节") {
+		t.Errorf("帧读取到一半时超时应返回错误, 得到 %v", err)
+	}
+}
+
+// TestClientPortBindAddr 测试客户端指定的远程端口绑定到 WithServerClientPortBindAddr 设置的地址，而不是所有接口
+func TestClientPortBindAddr(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerClientPortBindAddr("127.0.0.1"))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	port := getFreePort(t)
+	init := &proto.InitConfig{RemotePort: port, LocalAddr: "127.0.0.1:80"}
+	writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(init)}, time.Second)
+	frame, err := proto.DecodeFrame(conn)
+	if err != nil || frame.Type != proto.FrameTypeASSIGNED {
+		t.Fatalf("期望 ASSIGNED 帧，得到 %+v, %v", frame, err)
+	}
+	if want := fmt.Sprintf("127.0.0.1:%d", port); string(frame.Payload) != want {
+		t.Errorf("远程端口应绑定到 %s，得到 %q", want, frame.Payload)
+	}
+}
+
+// TestInitGlobalModeIgnoredPort 测试服务器使用全局公开端口时在 ASSIGNED 中明确告知客户端请求的远程端口被忽略
+// （只对协商了 FeatureAssignmentInfo 的客户端，未进行特性协商的旧版本客户端只收到地址）
+func TestInitGlobalModeIgnoredPort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, publicAddr)
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	for _, tc := range []struct {
+		init        proto.InitConfig
+		hello       bool
+		ignoredPort int
+	}{
+		{proto.InitConfig{RemotePort: 9000, LocalAddr: "127.0.0.1:80"}, true, 9000},
+		{proto.InitConfig{LocalAddr: "127.0.0.1:80", Hostnames: []string{"app.example.com"}}, true, 0},
+		{proto.InitConfig{RemotePort: 9000, LocalAddr: "127.0.0.1:80"}, false, 0},
+	} {
+		conn, err := net.Dial("tcp", controlAddr)
+		if err != nil {
+			t.Fatalf("连接服务器失败: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if tc.hello {
+			hello := &proto.Hello{Features: proto.SupportedFeatures}
+			writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeHELLO, Payload: proto.EncodeHello(hello)}, time.Second)
+			if frame, err := proto.DecodeFrame(conn); err != nil || frame.Type != proto.FrameTypeHELLO {
+				t.Fatalf("期望 HELLO 帧，得到 %+v, %v", frame, err)
+			}
+		}
+		writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&tc.init)}, time.Second)
+		frame, err := proto.DecodeFrame(conn)
+		conn.Close()
+		if err != nil || frame.Type != proto.FrameTypeASSIGNED {
+			t.Fatalf("期望 ASSIGNED 帧，得到 %+v, %v", frame, err)
+		}
+		if !tc.hello && string(frame.Payload) != publicAddr {
+			t.Errorf("未进行特性协商的客户端应只收到地址，得到 %q", frame.Payload)
+		}
+		assignment, err := proto.DecodeAssignment(frame.Payload)
+		if err != nil {
+			t.Fatalf("解码 ASSIGNED 负载失败: %v", err)
+		}
+		if assignment.Addr != publicAddr || assignment.IgnoredPort != tc.ignoredPort {
+			t.Errorf("INIT %+v (hello=%v): 期望地址 %s、忽略端口 %d，得到 %+v", tc.init, tc.hello, publicAddr, tc.ignoredPort, assignment)
+		}
+	}
+}
+
+// TestRequirePublicEndpoint 测试未配置全局公开端口时，启用 requirePublicEndpoint 的服务器断开未请求远程端口的客户端，
+// 拒绝取消远程端口，请求了远程端口的客户端不受影响
+func TestRequirePublicEndpoint(t *testing.T) {
+	const grace = 200 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerRequirePublicEndpoint(true), WithServerPublicEndpointGrace(grace))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// 未请求远程端口：超过等待时间后收到 ERROR 并被断开
+	idle, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := proto.DecodeFrame(idle)
+	if err != nil || frame.Type != proto.FrameTypeERROR || string(frame.Payload) != errNoPublicEndpoint {
+		t.Fatalf("期望 ERROR 帧，得到 %+v, %v", frame, err)
+	}
+	if _, err := proto.DecodeFrame(idle); err == nil {
+		t.Errorf("没有公开入口的客户端应被断开")
+	}
+
+	// 请求了远程端口：超过等待时间后控制连接保持，取消远程端口被拒绝
+	conn, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer conn.Close()
+	remotePort := getFreePort(t)
+	for i, port := range []int{remotePort, 0} {
+		init := &proto.InitConfig{RemotePort: port, LocalAddr: "127.0.0.1:80"}
+		writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(init)}, time.Second)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		frame, err := proto.DecodeFrame(conn)
+		want := []proto.FrameType{proto.FrameTypeASSIGNED, proto.FrameTypeERROR}[i]
+		if err != nil || frame.Type != want {
+			t.Fatalf("INIT 远程端口 %d: 期望 %v 帧，得到 %+v, %v", port, want, frame, err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * grace))
+	if frame, err := proto.DecodeFrame(conn); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("有公开端口的客户端不应被断开，得到 %+v, %v", frame, err)
+	}
+	if status := server.ClientStatus(); len(status) != 1 || status[0].RemotePort != remotePort {
+		t.Errorf("取消被拒绝后应保持原端口 %d，得到 %+v", remotePort, status)
+	}
+}
+
+// TestInitAckOldServer 测试旧版本服务器不回复 INIT 时客户端在超时后继续使用控制连接，而不是断开重连
+func TestInitAckOldServer(t *testing.T) {
+	const ackTimeout = 200 * time.Millisecond
+	oldTimeout := initAckTimeout
+	initAckTimeout = ackTimeout
+	defer func() { initAckTimeout = oldTimeout }()
+
+	// 旧版本服务器：读取 INIT 后不回复
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := proto.DecodeFrame(conn); err != nil {
+			return
+		}
+		time.Sleep(2 * ackTimeout)
+		io.Copy(io.Discard, conn)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := NewClient(listener.Addr().String(), "127.0.0.1:1", getFreePort(t))
+	if err := client.connectToServer(ctx); err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer client.closeControlConn()
+	if err := client.setupTunnel(ctx); err != nil {
+		t.Errorf("旧版本服务器不回复 INIT 时应视为隧道已建立, 得到: %v", err)
+	}
+	client.controlMu.RLock()
+	connected := client.controlConn != nil
+	client.controlMu.RUnlock()
+	if !connected {
+		t.Errorf("控制连接不应被关闭")
+	}
+}
+
+// TestRemotePortChange 测试客户端运行期间更换远程端口：新端口开始接受连接，原端口停止接受新连接，
+// 原端口上已建立的连接继续转发，控制连接不中断
+func TestRemotePortChange(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	oldPort := getFreePort(t)
+	newPort := getFreePort(t)
+
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, "")
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, oldPort)
+	go client.Run(ctx)
+
+	echo := func(conn net.Conn, msg string) error {
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			return err
+		}
+		if string(got) != msg {
+			return fmt.Errorf("回显不匹配: 期望 %q, 得到 %q", msg, got)
+		}
+		return nil
+	}
+	dialPort := func(port int) (net.Conn, error) {
+		return net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	}
+
+	var oldConn net.Conn
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		conn, err := dialPort(oldPort)
+		if err == nil {
+			oldConn = conn
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("原端口未就绪: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer oldConn.Close()
+	if err := echo(oldConn, "before"); err != nil {
+		t.Fatalf("原端口转发失败: %v", err)
+	}
+	clientID := server.ClientStatus()[0].ID
+
+	if err := client.SetRemotePort(newPort); err != nil {
+		t.Fatalf("更换远程端口失败: %v", err)
+	}
+
+	var newConn net.Conn
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		conn, err := dialPort(newPort)
+		if err == nil {
+			newConn = conn
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("新端口未就绪: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer newConn.Close()
+	if err := echo(newConn, "new port"); err != nil {
+		t.Fatalf("新端口转发失败: %v", err)
+	}
+
+	// 原端口已关闭监听，但已建立的连接继续转发
+	if conn, err := dialPort(oldPort); err == nil {
+		conn.Close()
+		t.Errorf("原端口应停止接受新连接")
+	}
+	if err := echo(oldConn, "draining"); err != nil {
+		t.Errorf("原端口上已建立的连接应继续转发: %v", err)
+	}
+
+	status := server.ClientStatus()
+	if len(status) != 1 || status[0].ID != clientID {
+		t.Fatalf("控制连接不应中断: %+v", status)
+	}
+	if status[0].RemotePort != newPort {
+		t.Errorf("期望远程端口 %d, 得到 %d", newPort, status[0].RemotePort)
+	}
+
+	// 新端口被占用：服务器拒绝并保持原端口，客户端同样保持
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("占用端口失败: %v", err)
+	}
+	defer occupied.Close()
+	if err := client.SetRemotePort(occupied.Addr().(*net.TCPAddr).Port); err != nil {
+		t.Fatalf("发送 INIT 失败: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if got := client.currentRemotePort(); got != newPort {
+		t.Errorf("服务器拒绝后客户端应保持端口 %d, 得到 %d", newPort, got)
+	}
+	if status := server.ClientStatus(); len(status) != 1 || status[0].RemotePort != newPort {
+		t.Errorf("服务器拒绝后应保持端口 %d: %+v", newPort, status)
+	}
+
+	// 取消远程端口：专用端口关闭，控制连接保持
+	if err := client.SetRemotePort(0); err != nil {
+		t.Fatalf("取消远程端口失败: %v", err)
+	}
+	deadline = time.Now().Add(3 * time.Second)
+	for client.currentRemotePort() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未收到取消远程端口的确认")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if conn, err := dialPort(newPort); err == nil {
+		conn.Close()
+		t.Errorf("取消远程端口后不应再接受新连接")
+	}
+	if status := server.ClientStatus(); len(status) != 1 || status[0].ID != clientID || status[0].RemotePort != 0 {
+		t.Errorf("取消远程端口后控制连接应保持且端口为 0: %+v", status)
+	}
+}
+
+// TestAddRemoveTunnel 测试运行期间增删隧道：添加后服务器在远程端口上监听，删除后端口关闭、已建立的连接被关闭，控制连接保持
+func TestAddRemoveTunnel(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	port := getFreePort(t)
+
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, "")
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, 0)
+	go client.Run(ctx)
+	waitStat(t, "客户端注册", func() int { return len(server.ClientStatus()) }, 1)
+	clientID := server.ClientStatus()[0].ID
+
+	id, err := client.AddTunnel(TunnelSpec{RemotePort: port})
+	if err != nil || id != port {
+		t.Fatalf("添加隧道失败: %d, %v", id, err)
+	}
+	if _, err := client.AddTunnel(TunnelSpec{RemotePort: port + 1}); !errors.Is(err, errTunnelExists) {
+		t.Errorf("已有隧道时添加应返回 errTunnelExists, 得到 %v", err)
+	}
+
+	var conn net.Conn
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		conn, err = net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("隧道端口未就绪: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Fatalf("隧道转发失败: %q, %v", got, err)
+	}
+
+	if err := client.RemoveTunnel(port + 1); !errors.Is(err, errTunnelNotFound) {
+		t.Errorf("删除不存在的隧道应返回 errTunnelNotFound, 得到 %v", err)
+	}
+	if err := client.RemoveTunnel(id); err != nil {
+		t.Fatalf("删除隧道失败: %v", err)
+	}
+
+	// 已建立的连接被关闭，端口不再接受新连接，控制连接保持
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := conn.Read(got); err == nil || isDeadlineErr(err) {
+		t.Errorf("删除隧道后已建立的连接应被关闭, 得到 %d 字节, %v", n, err)
+	}
+	waitStat(t, "客户端远程端口", client.currentRemotePort, 0)
+	if c, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second); err == nil {
+		c.Close()
+		t.Errorf("删除隧道后端口不应再接受新连接")
+	}
+	waitStat(t, "服务器端的转发连接数", func() int { return server.ClientStatus()[0].ActiveConns }, 0)
+	if status := server.ClientStatus(); len(status) != 1 || status[0].ID != clientID || status[0].RemotePort != 0 {
+		t.Errorf("删除隧道后控制连接应保持且端口为 0: %+v", status)
+	}
+
+	// 删除后可以重新添加
+	if _, err := client.AddTunnel(TunnelSpec{RemotePort: port}); err != nil {
+		t.Errorf("删除后重新添加隧道失败: %v", err)
+	}
+}
+
+// TestReconnectRetriesRejectedPort 测试重连时远程端口已被占用：服务器拒绝该端口，但重连不失败（控制连接保持），
+// 客户端定期重试该端口，端口释放后隧道恢复
+func TestReconnectRetriesRejectedPort(t *testing.T) {
+	oldDelay, oldInterval, oldBindRetry := reconnectDelay, remotePortRetryInterval, portBindRetryTimeout
+	reconnectDelay, remotePortRetryInterval, portBindRetryTimeout = 500*time.Millisecond, 100*time.Millisecond, 0
+	defer func() { reconnectDelay, remotePortRetryInterval, portBindRetryTimeout = oldDelay, oldInterval, oldBindRetry }()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	port := getFreePort(t)
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", port)
+
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 测试结束时等待服务器和客户端退出之后再恢复上面的全局变量
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	server := NewServer(controlAddr, "")
+	wg.Add(1)
+	go func() { defer wg.Done(); server.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	client := NewClient(controlAddr, localAddr, port)
+	wg.Add(1)
+	go func() { defer wg.Done(); client.Run(ctx) }()
+	waitStat(t, "远程端口", func() int {
+		for _, st := range server.ClientStatus() {
+			return st.RemotePort
+		}
+		return 0
+	}, port)
+
+	// 断开控制连接，在客户端重连之前占用远程端口
+	server.clientsMu.RLock()
+	for _, info := range server.clients {
+		info.Conn.Close()
+	}
+	server.clientsMu.RUnlock()
+	var occupied net.Listener
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var err error
+		if occupied, err = net.Listen("tcp", publicAddr); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("占用远程端口失败: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 重连成功，端口被拒绝后持续重试，控制连接不断开
+	waitStat(t, "重试的远程端口", client.currentRetryPort, port)
+	status := server.ClientStatus()
+	if len(status) != 1 {
+		t.Fatalf("端口被拒绝后控制连接应保持: %+v", status)
+	}
+	clientID := status[0].ID
+	time.Sleep(3 * remotePortRetryInterval)
+	if status := server.ClientStatus(); len(status) != 1 || status[0].ID != clientID {
+		t.Errorf("重试端口期间控制连接不应断开: %+v", status)
+	}
+
+	// 端口释放后重试成功，隧道恢复
+	occupied.Close()
+	waitStat(t, "重试的远程端口", client.currentRetryPort, 0)
+	if status := server.ClientStatus(); len(status) != 1 || status[0].ID != clientID || status[0].RemotePort != port {
+		t.Fatalf("重试成功后应在同一控制连接上恢复远程端口 %d: %+v", port, status)
+	}
+	conn, err := net.DialTimeout("tcp", publicAddr, time.Second)
+	if err != nil {
+		t.Fatalf("连接远程端口失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Errorf("恢复后的隧道转发失败: %q, %v", got, err)
+	}
+}
+
+// TestMalformedInitDisconnects 测试无法解析的 INIT 帧：服务器回复 ERROR 并断开控制连接；负载长度超限的帧直接断开
+func TestMalformedInitDisconnects(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, "")
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	payloads := map[string][]byte{
+		"超长负载": bytes.Repeat([]byte("1"), proto.MaxInitPayloadSize+1),
+		"控制字符": []byte("8080;hostname=a\x00b"),
+	}
+	for name, payload := range payloads {
+		conn, err := net.Dial("tcp", controlAddr)
+		if err != nil {
+			t.Fatalf("连接服务器失败: %v", err)
+		}
+		data, err := proto.EncodeFrame(&proto.Frame{Type: proto.FrameTypeINIT, Payload: payload})
+		if err != nil {
+			t.Fatalf("编码 INIT 失败: %v", err)
+		}
+		if _, err := conn.Write(data); err != nil {
+			t.Fatalf("发送 INIT 失败: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		frame, err := proto.DecodeFrame(conn)
+		if err != nil || frame.Type != proto.FrameTypeERROR {
+			t.Errorf("%s: 应收到 ERROR 帧，得到 %+v, %v", name, frame, err)
+		}
+		if _, err := proto.DecodeFrame(conn); err == nil {
+			t.Errorf("%s: 控制连接应被断开", name)
+		}
+		conn.Close()
+	}
+
+	// 帧头声明的负载长度超过上限：服务器不分配内存，直接断开
+	conn, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接服务器失败: %v", err)
+	}
+	defer conn.Close()
+	header := []byte{byte(proto.FrameTypeDATA), 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff}
+	if _, err := conn.Write(header); err != nil {
+		t.Fatalf("写入帧头失败: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("负载长度超限时控制连接应被断开")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Errorf("负载长度超限时控制连接应被断开，但读取超时")
+	}
+}
+
+// TestClientChurn 在公开连接持续转发的同时快速连接/断开大量客户端（配合 -race 检查数据竞争）
+func TestClientChurn(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	localServer := startEchoServer(t, localAddr)
+	defer localServer.Close()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer(controlAddr, "")
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// 并发读取状态，与注册/注销/INIT 处理竞争
+	statusDone := make(chan struct{})
+	go func() {
+		defer close(statusDone)
+		for ctx.Err() == nil {
+			server.ClientStatus()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	const workers = 8
+	const iterations = 5
+	var wg sync.WaitGroup
+	var served int64
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				// 发送 INIT 后立即断开，与 INIT 处理竞争
+				if conn, err := net.Dial("tcp", controlAddr); err == nil {
+					init := &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&proto.InitConfig{RemotePort: getFreePort(t), LocalAddr: localAddr})}
+					writeFrame(conn, nil, init, 0)
+					conn.Close()
+				}
+
+				// 正常建立隧道，转发数据后断开
+				remotePort := getFreePort(t)
+				clientCtx, clientCancel := context.WithCancel(ctx)
+				go NewClient(controlAddr, localAddr, remotePort).Run(clientCtx)
+
+				publicAddr := fmt.Sprintf("127.0.0.1:%d", remotePort)
+				var conn net.Conn
+				var err error
+				for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+					if conn, err = net.Dial("tcp", publicAddr); err == nil {
+						break
+					}
+				}
+				if err == nil {
+					msg := []byte("churn")
+					conn.Write(msg)
+					conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+					if _, err := io.ReadFull(conn, make([]byte, len(msg))); err == nil {
+						atomic.AddInt64(&served, 1)
+					}
+					// 公开连接仍在转发时断开客户端
+					go io.Copy(io.Discard, conn)
+					conn.Write(msg)
+				}
+				clientCancel()
+				if conn != nil {
+					conn.Close()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if served == 0 {
+		t.Errorf("客户端频繁连接/断开期间没有任何公开连接转发成功")
+	}
+
+	// 所有客户端断开后应全部注销
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.ClientStatus()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := len(server.ClientStatus()); n != 0 {
+		t.Errorf("所有客户端断开后仍有 %d 个客户端未注销", n)
+	}
+	cancel()
+	<-statusDone
+}
+
+// TestUnregisterDuringInitBind 测试 INIT 绑定公开端口期间客户端被注销：新绑定的监听器被关闭、端口释放，且不访问已注销的客户端状态
+func TestUnregisterDuringInitBind(t *testing.T) {
+	defer func() { afterPublicListen = nil }()
+
+	for _, reinit := range []bool{false, true} {
+		server := NewServer("127.0.0.1:0", "")
+		serverSide, clientSide := net.Pipe()
+		go io.Copy(io.Discard, clientSide)
+		clientID, err := server.registerClient(serverSide)
+		if err != nil {
+			t.Fatalf("注册客户端失败: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		initFrame := func(port int) *proto.Frame {
+			return &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&proto.InitConfig{RemotePort: port, LocalAddr: "127.0.0.1:1"})}
+		}
+
+		// reinit 时客户端先在原端口上建立监听器，再在更换端口的绑定期间被注销
+		var ports []int
+		afterPublicListen = nil
+		if reinit {
+			oldPort := getFreePort(t)
+			if err := server.handleInitFrame(ctx, clientID, initFrame(oldPort)); err != nil {
+				t.Fatalf("处理 INIT 失败: %v", err)
+			}
+			if len(server.ClientStatus()) != 1 {
+				t.Fatalf("INIT 后客户端应仍处于注册状态")
+			}
+			ports = append(ports, oldPort)
+		}
+		newPort := getFreePort(t)
+		ports = append(ports, newPort)
+		afterPublicListen = func(id string) { server.unregisterClient(id) }
+		if err := server.handleInitFrame(ctx, clientID, initFrame(newPort)); err != nil {
+			t.Errorf("reinit=%v: 绑定期间注销客户端后 INIT 处理不应返回错误: %v", reinit, err)
+		}
+		afterPublicListen = nil
+
+		if n := len(server.ClientStatus()); n != 0 {
+			t.Errorf("reinit=%v: 客户端注销后仍有 %d 个客户端", reinit, n)
+		}
+		for _, port := range ports {
+			l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+			if err != nil {
+				t.Errorf("reinit=%v: 客户端注销后端口 %d 未释放: %v", reinit, port, err)
+				continue
+			}
+			l.Close()
+		}
+		cancel()
+		clientSide.Close()
+	}
+}
+
+// TestPublicPortRebindRetry 测试专用公开端口短暂被占用（例如旧会话尚未注销）时服务器重试绑定，
+// 超出重试时长仍被占用时拒绝
+func TestPublicPortRebindRetry(t *testing.T) {
+	defer func(timeout time.Duration) { portBindRetryTimeout = timeout }(portBindRetryTimeout)
+
+	server := NewServer("127.0.0.1:0", "")
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	go io.Copy(io.Discard, clientSide)
+	clientID, err := server.registerClient(serverSide)
+	if err != nil {
+		t.Fatalf("注册客户端失败: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	initFrame := func(port int) *proto.Frame {
+		return &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(&proto.InitConfig{RemotePort: port, LocalAddr: "127.0.0.1:1"})}
+	}
+	remotePort := func() int {
+		status := server.ClientStatus()
+		if len(status) != 1 {
+			t.Fatalf("应有 1 个客户端，实际 %d", len(status))
+		}
+		return status[0].RemotePort
+	}
+
+	// 重试时长内被释放：绑定成功
+	occupied, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("占用端口失败: %v", err)
+	}
+	port := occupied.Addr().(*net.TCPAddr).Port
+	time.AfterFunc(300*time.Millisecond, func() { occupied.Close() })
+	if err := server.handleInitFrame(ctx, clientID, initFrame(port)); err != nil {
+		t.Fatalf("处理 INIT 失败: %v", err)
+	}
+	if got := remotePort(); got != port {
+		t.Errorf("端口释放后应重试绑定成功，远程端口为 %d，期望 %d", got, port)
+	}
+
+	// 超出重试时长仍被占用：拒绝并保持原端口
+	portBindRetryTimeout = 300 * time.Millisecond
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("占用端口失败: %v", err)
+	}
+	defer busy.Close()
+	start := time.Now()
+	if err := server.handleInitFrame(ctx, clientID, initFrame(busy.Addr().(*net.TCPAddr).Port)); err != nil {
+		t.Fatalf("处理 INIT 失败: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("端口被占用时应重试 %v 后才拒绝，实际 %v", portBindRetryTimeout, elapsed)
+	}
+	if got := remotePort(); got != port {
+		t.Errorf("新端口绑定失败后应保持原端口 %d，实际 %d", port, got)
+	}
+
+	// 注销后原端口立即可以重新绑定
+	server.unregisterClient(clientID)
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		t.Fatalf("客户端注销后端口 %d 未释放: %v", port, err)
+	}
+	l.Close()
+}
+
+// TestMaxFrameRate 测试超出帧速率时 throttle 策略延迟处理、drop 策略断开控制连接
+func TestMaxFrameRate(t *testing.T) {
+	for _, policy := range []string{FrameRatePolicyThrottle, FrameRatePolicyDrop} {
+		controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+		ctx, cancel := context.WithCancel(context.Background())
+
+		server := NewServer(controlAddr, "", WithServerMaxFrameRate(20, policy))
+		go server.Run(ctx)
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", controlAddr)
+		if err != nil {
+			cancel()
+			t.Fatalf("[%s] 连接服务器失败: %v", policy, err)
+		}
+
+		// 40 个帧：前 20 个消耗突发配额，其余超限
+		for i := 0; i < 40; i++ {
+			if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeCLOSE, ConnID: uint32(i + 1)}, time.Second); err != nil {
+				break
+			}
+		}
+
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		switch policy {
+		case FrameRatePolicyDrop:
+			// 服务器断开时可能还有未读取的帧，读取得到 EOF 或 RST
+			if ne, ok := err.(net.Error); err == nil || (ok && ne.Timeout()) {
+				t.Errorf("[drop] 超出帧速率后控制连接应被断开，读取得到: %v", err)
+			}
+		case FrameRatePolicyThrottle:
+			// 限速期间连接保持，读取超时
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				t.Errorf("[throttle] 限速时不应断开控制连接，读取得到: %v", err)
+			}
+		}
+		conn.Close()
+		cancel()
+	}
+}
+
+// blockingCloseConn Close 一直阻塞到 release 关闭的连接（模拟关闭时等待对端）
+type blockingCloseConn struct {
+	net.Conn
+	release chan struct{}
+}
+
+func (c *blockingCloseConn) Close() error {
+	<-c.release
+	return c.Conn.Close()
+}
+
+// TestShutdownTimeout 测试某个连接的 Close 阻塞时，Run 在清理超时后仍能返回
+func TestShutdownTimeout(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, "", WithServerShutdownTimeout(200*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.Run(ctx) }()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	stuck := &blockingCloseConn{Conn: conn, release: make(chan struct{})}
+	defer close(stuck.release)
+	server.clientsMu.Lock()
+	server.clients["client-stuck"] = &ClientInfo{ID: "client-stuck", Conn: stuck}
+	server.clientsMu.Unlock()
+
+	start := time.Now()
+	cancel()
+	select {
+	case <-errCh:
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Run 返回过慢: %v", elapsed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("连接关闭阻塞时 Run 未在清理超时后返回")
+	}
+}
+
+// TestShutdownOrder 测试服务器关闭时先停止接受公开连接，再通过仍然打开的控制连接为进行中的连接发送 CLOSE_CONN，最后关闭控制连接
+func TestShutdownOrder(t *testing.T) {
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	server := NewServer(controlAddr, publicAddr)
+	go server.Run(context.Background())
+	time.Sleep(100 * time.Millisecond)
+
+	control, err := net.Dial("tcp", controlAddr)
+	if err != nil {
+		t.Fatalf("连接控制端口失败: %v", err)
+	}
+	defer control.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	public, err := net.Dial("tcp", publicAddr)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer public.Close()
+	control.SetReadDeadline(time.Now().Add(3 * time.Second))
+	frame, err := proto.DecodeFrame(control)
+	if err != nil || frame.Type != proto.FrameTypeNEW_CONN {
+		t.Fatalf("应收到 NEW_CONN，得到 %v (err=%v)", frame, err)
+	}
+	connID := frame.ConnID
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- server.Shutdown(context.Background()) }()
+
+	// 控制连接关闭之前应收到该连接的 CLOSE_CONN（原因为服务器关闭）
+	gotClose := false
+	for {
+		frame, err := proto.DecodeFrame(control)
+		if err != nil {
+			break
+		}
+		if frame.Type == proto.FrameTypeCLOSE && frame.ConnID == connID {
+			gotClose = true
+			if reason := proto.DecodeCloseReason(frame.Payload); reason != proto.CloseShutdown {
+				t.Errorf("CLOSE_CONN 原因为 %v，期望 %v", reason, proto.CloseShutdown)
+			}
+		}
+	}
+	if !gotClose {
+		t.Error("控制连接关闭前未收到进行中连接的 CLOSE_CONN")
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown 返回错误: %v", err)
+	}
+
+	public.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := public.Read(make([]byte, 1)); err == nil {
+		t.Error("服务器关闭后公开连接应已关闭")
+	}
+	if conn, err := net.DialTimeout("tcp", publicAddr, time.Second); err == nil {
+		conn.Close()
+		t.Error("服务器关闭后公开端口应不再接受连接")
+	}
+}
+
+// TestCloseNoFeedbackLoop 测试一次 CLOSE_CONN 交换后不再为已关闭的 connID 产生任何帧：
+// 客户端对服务器的 CLOSE_CONN 只回发一次，对重复的 CLOSE_CONN 不回发；服务器收到 CLOSE_CONN 从不回发
+func TestCloseNoFeedbackLoop(t *testing.T) {
+	// readFrames 在后台读取控制连接上的帧
+	readFrames := func(conn net.Conn) <-chan *proto.Frame {
+		frames := make(chan *proto.Frame, 16)
+		go func() {
+			defer close(frames)
+			for {
+				frame, err := proto.DecodeFrame(conn)
+				if err != nil {
+					return
+				}
+				frames <- frame
+			}
+		}()
+		return frames
+	}
+	// expectFrames 统计 wait 时间内收到的 connID 相关帧
+	expectFrames := func(frames <-chan *proto.Frame, connID uint32, wait time.Duration) []*proto.Frame {
+		var got []*proto.Frame
+		timeout := time.After(wait)
+		for {
+			select {
+			case frame, ok := <-frames:
+				if !ok {
+					return got
+				}
+				if frame.ConnID == connID {
+					got = append(got, frame)
+				}
+			case <-timeout:
+				return got
+			}
+		}
+	}
+	closeFrame := func(connID uint32) *proto.Frame {
+		return &proto.Frame{Type: proto.FrameTypeCLOSE, ConnID: connID, Payload: proto.EncodeCloseReason(proto.CloseGraceful)}
+	}
+
+	t.Run("client", func(t *testing.T) {
+		localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+		localServer := startEchoServer(t, localAddr)
+		defer localServer.Close()
+
+		control, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("监听控制端口失败: %v", err)
+		}
+		defer control.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := NewClient(control.Addr().String(), localAddr, 0)
+		go client.Run(ctx)
+
+		conn, err := control.Accept()
+		if err != nil {
+			t.Fatalf("接受控制连接失败: %v", err)
+		}
+		defer conn.Close()
+		frames := readFrames(conn)
+
+		const connID = 7
+		if err := writeFrame(conn, nil, &proto.Frame{Type: proto.FrameTypeNEW_CONN, ConnID: connID}, time.Second); err != nil {
+			t.Fatalf("发送 NEW_CONN 失败: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+
+		if err := writeFrame(conn, nil, closeFrame(connID), time.Second); err != nil {
+			t.Fatalf("发送 CLOSE_CONN 失败: %v", err)
+		}
+		if got := expectFrames(frames, connID, 500*time.Millisecond); len(got) != 1 || got[0].Type != proto.FrameTypeCLOSE {
+			t.Fatalf("期望客户端只回发 1 个 CLOSE_CONN, 得到 %d 个帧", len(got))
+		}
+
+		// 模拟服务器对回发的再次回应：客户端不应再发送任何帧
+		if err := writeFrame(conn, nil, closeFrame(connID), time.Second); err != nil {
+			t.Fatalf("发送 CLOSE_CONN 失败: %v", err)
+		}
+		if got := expectFrames(frames, connID, 300*time.Millisecond); len(got) != 0 {
+			t.Errorf("已关闭的 connID 不应再产生帧, 得到 %d 个", len(got))
+		}
+	})
+
+	t.Run("server", func(t *testing.T) {
+		controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+		publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+		server := NewServer(controlAddr, publicAddr)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go server.Run(ctx)
+		time.Sleep(100 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", controlAddr)
+		if err != nil {
+			t.Fatalf("连接控制端口失败: %v", err)
+		}
+		defer conn.Close()
+		frames := readFrames(conn)
+		time.Sleep(100 * time.Millisecond)
+
+		public, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+		if err != nil {
+			t.Fatalf("连接公开端口失败: %v", err)
+		}
+		defer public.Close()
+
+		var connID uint32
+		select {
+		case frame := <-frames:
+			if frame.Type != proto.FrameTypeNEW_CONN {
+				t.Fatalf("期望 NEW_CONN 帧, 得到 %s", frame.Type)
+			}
+			connID = frame.ConnID
+		case <-time.After(2 * time.Second):
+			t.Fatalf("等待 NEW_CONN 帧超时")
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := writeFrame(conn, nil, closeFrame(connID), time.Second); err != nil {
+				t.Fatalf("发送 CLOSE_CONN 失败: %v", err)
+			}
+			if got := expectFrames(frames, connID, 300*time.Millisecond); len(got) != 0 {
+				t.Fatalf("服务器不应回发 CLOSE_CONN (第 %d 次), 得到 %d 个帧", i+1, len(got))
+			}
+		}
+		public.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := public.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("期望公开连接被关闭 (EOF), 得到: %v", err)
+		}
+	})
+}
+
+// TestControlWriteQueueTimeout 测试写入超时包括等待其他帧写完的时间：对端读取缓慢、排队超过超时的写入者失败并关闭控制连接，
+// 而不是在拿到写锁后重新开始计时
+func TestControlWriteQueueTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go io.Copy(io.Discard, peer)
+
+	w := &controlWriter{}
+	end := w.begin() // 另一个写入者正在向读取缓慢的对端写入
+	done := make(chan error, 1)
+	go func() {
+		done <- writeFrame(conn, w, &proto.Frame{Type: proto.FrameTypeDATA, ConnID: 1, Payload: []byte("x")}, 100*time.Millisecond)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	end()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("排队超过写入超时的帧应失败, 得到 %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("排队的写入应在拿到写锁后立即失败")
+	}
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("写入超时后应关闭控制连接, 得到 %v", err)
+	}
+}
+
+// TestControlWriteFailure 测试客户端在转发中途停止响应时，控制连接写入失败会关闭该客户端的所有外部连接，而不是让它们一直挂起
+func TestControlWriteFailure(t *testing.T) {
+	control := newMemListener("control")
+	public := newMemListener("public")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public),
+		WithServerControlWriteTimeout(200*time.Millisecond))
+	go server.Run(ctx)
+
+	// 模拟客户端：收到两个 NEW_CONN 后不再读取控制连接（内存管道上服务器的写入随之阻塞直到超时）
+	conn, err := control.DialContext(ctx, "mem", "control")
+	if err != nil {
+		t.Fatalf("连接控制监听器失败: %v", err)
+	}
+	defer conn.Close()
+	newConns := make(chan uint32, 2)
+	go func() {
+		for n := 0; n < cap(newConns); {
+			frame, err := proto.DecodeFrame(conn)
+			if err != nil {
+				return
+			}
+			if frame.Type == proto.FrameTypeNEW_CONN {
+				newConns <- frame.ConnID
+				n++
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(server.ClientStatus()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var publics []net.Conn
+	for i := 0; i < 2; i++ {
+		p, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer p.Close()
+		publics = append(publics, p)
+		select {
+		case <-newConns:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("等待 NEW_CONN 帧超时")
+		}
+	}
+
+	// 第一个外部连接继续发送数据，服务器转发 DATA 帧时写入超时
+	go publics[0].Write([]byte("data that the client never reads"))
+
+	for i, p := range publics {
+		p.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := p.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("外部连接 %d 期望被关闭 (EOF), 得到: %v", i, err)
+		}
+	}
+
+	deadline = time.Now().Add(2 * time.Second)
+	for len(server.ClientStatus()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("写入失败后客户端未被注销")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestConnIDExhaustion 测试接近 32 位上限的 connID 正常转发，空间用尽后不回绕，
+// 而是拒绝新连接并要求客户端重建控制连接，新的控制连接上 connID 重新从 1 开始
+func TestConnIDExhaustion(t *testing.T) {
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+	go server.Run(ctx)
+	client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local))
+	go client.Run(ctx)
+
+	// onlyClient 返回当前唯一注册的客户端（重建期间可能暂时没有）
+	onlyClient := func() *ClientInfo {
+		server.clientsMu.RLock()
+		defer server.clientsMu.RUnlock()
+		for _, info := range server.clients {
+			return info
+		}
+		return nil
+	}
+	// echo 通过公开监听器发送一条消息并读取回显
+	echo := func() error {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		go conn.Write([]byte("ping"))
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		return err
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for onlyClient() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	first := onlyClient()
+	atomic.StoreUint32(&first.NextConnID, math.MaxUint32-1)
+
+	// 最后一个可用的 connID
+	if err := echo(); err != nil {
+		t.Fatalf("connID=%d 的连接转发失败: %v", uint32(math.MaxUint32), err)
+	}
+	if got := atomic.LoadUint32(&first.NextConnID); got != math.MaxUint32 {
+		t.Fatalf("期望分配 connID=%d, 得到 %d", uint32(math.MaxUint32), got)
+	}
+
+	// 空间用尽：拒绝新连接且不回绕
+	if err := echo(); err == nil {
+		t.Fatalf("connID 用尽后应拒绝新连接")
+	}
+	if got := atomic.LoadUint32(&first.NextConnID); got != math.MaxUint32 {
+		t.Errorf("connID 不应回绕, 得到 %d", got)
+	}
+
+	// 客户端重建控制连接后恢复转发，connID 从 1 开始
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		if info := onlyClient(); info != nil && info != first && echo() == nil {
+			if got := atomic.LoadUint32(&info.NextConnID); got != 1 {
+				t.Errorf("新的控制连接上 connID 应从 1 开始, 得到 %d", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未重建控制连接")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestRandomConnIDBase 测试启用 randomConnIDBase 后每个控制连接的 connID 从 [0, 2^31) 内的随机起点开始，默认从 1 开始
+func TestRandomConnIDBase(t *testing.T) {
+	// register 注册一个基于 net.Pipe 的客户端，返回其 ClientInfo
+	register := func(server *Server) *ClientInfo {
+		serverSide, clientSide := net.Pipe()
+		t.Cleanup(func() { clientSide.Close() })
+		go io.Copy(io.Discard, clientSide)
+		clientID, err := server.registerClient(serverSide)
+		if err != nil {
+			t.Fatalf("注册客户端失败: %v", err)
+		}
+		server.clientsMu.RLock()
+		defer server.clientsMu.RUnlock()
+		return server.clients[clientID]
+	}
+
+	if got := atomic.LoadUint32(&register(NewServer("", "")).NextConnID); got != 0 {
+		t.Errorf("默认 connID 应从 1 开始, NextConnID=%d", got)
+	}
+
+	server := NewServer("", "", WithServerRandomConnIDBase(true))
+	bases := make(map[uint32]bool)
+	for i := 0; i < 4; i++ {
+		info := register(server)
+		base := atomic.LoadUint32(&info.NextConnID)
+		if base >= maxConnIDBase {
+			t.Errorf("随机起点应小于 2^31, 得到 %d", base)
+		}
+		bases[base] = true
+		if connID, ok := server.allocConnID(info); !ok || connID != base+1 {
+			t.Errorf("第一个 connID 应为起点加 1: 得到 %d (ok=%v), 起点 %d", connID, ok, base)
+		}
+	}
+	// 4 个 [0, 2^31) 内的随机值全部相同的概率可以忽略
+	if len(bases) < 2 {
+		t.Errorf("不同控制连接的 connID 起点应随机选择, 得到 %v", bases)
+	}
+}
+
+// TestInitRejectsDatagramFraming 测试服务器拒绝声明数据报分帧的 INIT（公开监听器只支持 TCP），不分配公开端口
+func TestInitRejectsDatagramFraming(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "")
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	clientID, err := server.registerClient(serverSide)
+	if err != nil {
+		t.Fatalf("注册客户端失败: %v", err)
+	}
+	defer server.unregisterClient(clientID)
+
+	replies := make(chan *proto.Frame, 1)
+	go func() {
+		frame, _ := proto.DecodeFrame(clientSide)
+		replies <- frame
+		io.Copy(io.Discard, clientSide)
+	}()
+	config := &proto.InitConfig{RemotePort: getFreePort(t), LocalAddr: "127.0.0.1:53", Framing: proto.FramingDatagram}
+	frame := &proto.Frame{Type: proto.FrameTypeINIT, Payload: proto.EncodeInitConfig(config)}
+	if err := server.handleInitFrame(context.Background(), clientID, frame); err != nil {
+		t.Fatalf("处理 INIT 失败: %v", err)
+	}
+	select {
+	case reply := <-replies:
+		if reply == nil || reply.Type != proto.FrameTypeERROR || !strings.Contains(string(reply.Payload), "datagram") {
+			t.Errorf("应回复 ERROR, 得到 %+v", reply)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("等待 INIT 回复超时")
+	}
+	if status := server.ClientStatus(); len(status) != 1 || status[0].RemotePort != 0 {
+		t.Errorf("数据报隧道不应分配公开端口, 得到 %+v", status)
+	}
+}
@@ -0,0 +1,111 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// 以下设置可在运行时修改（例如收到 SIGHUP 重新读取配置文件后），读写时需持有 settingsMu：
+// 控制连接写入超时、控制连接最大存活时间、关闭超时、帧速率限制、策略重新加载后是否撤销在线客户端。
+// 修改不会断开已连接的客户端；最大存活时间和帧速率限制在控制连接建立时读取，只影响之后建立的控制连接
+
+// SetControlWriteTimeout 修改控制连接单帧写入超时（0 表示不设超时），对之后的写入立即生效
+func (s *Server) SetControlWriteTimeout(d time.Duration) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	if s.controlWriteTimeout != d {
+		log.Printf("控制连接写入超时: %v -> %v", s.controlWriteTimeout, d)
+		s.controlWriteTimeout = d
+	}
+}
+
+// SetMaxControlConnLifetime 修改控制连接最大存活时间（0 表示不限制），只影响之后建立的控制连接
+func (s *Server) SetMaxControlConnLifetime(d time.Duration) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	if s.maxControlConnLifetime != d {
+		log.Printf("控制连接最大存活时间: %v -> %v（对之后建立的控制连接生效）", s.maxControlConnLifetime, d)
+		s.maxControlConnLifetime = d
+	}
+}
+
+// SetShutdownTimeout 修改关闭时清理资源的最长时间（0 表示默认 10 秒）
+func (s *Server) SetShutdownTimeout(d time.Duration) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	if s.shutdownTimeout != d {
+		log.Printf("关闭超时: %v -> %v", s.shutdownTimeout, d)
+		s.shutdownTimeout = d
+	}
+}
+
+// SetFrameRateLimit 修改每个控制连接每秒最多处理的帧数（0 表示不限制）及超出时的策略，只影响之后建立的控制连接
+func (s *Server) SetFrameRateLimit(rate int, policy string) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	if s.maxFrameRate != rate || s.frameRatePolicy != policy {
+		log.Printf("帧速率限制: %d 帧/秒 (%s) -> %d 帧/秒 (%s)（对之后建立的控制连接生效）",
+			s.maxFrameRate, s.frameRatePolicy, rate, policy)
+		s.maxFrameRate = rate
+		s.frameRatePolicy = policy
+	}
+}
+
+// SetPolicyRevoke 修改重新加载策略后是否断开身份已不被允许的在线客户端
+func (s *Server) SetPolicyRevoke(revoke bool) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	if s.policyRevoke != revoke {
+		log.Printf("撤销在线客户端: %v -> %v", s.policyRevoke, revoke)
+		s.policyRevoke = revoke
+	}
+}
+
+// writeTimeout 返回当前的控制连接写入超时
+func (s *Server) writeTimeout() time.Duration {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.controlWriteTimeout
+}
+
+// controlConnLifetime 返回当前的控制连接最大存活时间
+func (s *Server) controlConnLifetime() time.Duration {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.maxControlConnLifetime
+}
+
+// frameRateLimit 返回当前的帧速率限制及策略
+func (s *Server) frameRateLimit() (int, string) {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.maxFrameRate, s.frameRatePolicy
+}
+
+// currentShutdownTimeout 返回当前的关闭超时
+func (s *Server) currentShutdownTimeout() time.Duration {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.shutdownTimeout
+}
+
+// currentPolicyRevoke 返回重新加载策略后是否撤销在线客户端
+func (s *Server) currentPolicyRevoke() bool {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.policyRevoke
+}
+
+// ReloadPublicCerts 重新加载公开端口按 SNI 选择的证书（增删租户的主机名或更新证书文件），之后的 TLS 握手使用新证书；
+// 加载失败时保留原有证书。只能在启动时通过 WithServerPublicCertStore 启用了公开端口 TLS 的服务器上调用
+func (s *Server) ReloadPublicCerts(certFile, keyFile string, hosts map[string]PublicCertFiles) error {
+	if s.publicCerts == nil {
+		return fmt.Errorf("未启用公开端口 TLS")
+	}
+	if err := s.publicCerts.Load(certFile, keyFile, hosts); err != nil {
+		return err
+	}
+	log.Printf("公开端口 TLS 证书已重新加载: 默认证书 %q, %d 个主机名的证书", certFile, len(hosts))
+	return nil
+}
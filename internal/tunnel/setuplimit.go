@@ -0,0 +1,215 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// defaultSetupWaitTimeout 未设置建立超时（connSetupTimeout）时，公开连接等待建立名额的最长时间
+const defaultSetupWaitTimeout = 10 * time.Second
+
+// setupLimiter 统计一个客户端已发送 NEW_CONN、尚未确认建立的连接数（建立中的连接），并限制其上限（maxPendingNewConns）：
+// 连接在收到 NEW_CONN_ACK、客户端发来该连接的首个 DATA 或连接结束（包括建立超时）时归还名额。
+// 只统计协商了 conn_ack 的客户端，未协商的客户端没有可靠的确认，不受限制。nil 表示不统计（方法均可在 nil 上调用）
+type setupLimiter struct {
+	limit int // 建立中的连接数上限（0 表示只统计、不限制）
+
+	mu      sync.Mutex
+	pending int
+	freed   chan struct{} // 名额归还或限制器关闭时关闭并替换，唤醒等待者
+	closed  bool          // 客户端已注销
+}
+
+// newSetupLimiter 创建上限为 limit 的建立名额计数（limit 为 0 时只统计）
+func newSetupLimiter(limit int) *setupLimiter {
+	return &setupLimiter{limit: limit, freed: make(chan struct{})}
+}
+
+// inFlight 返回建立中的连接数
+func (l *setupLimiter) inFlight() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pending
+}
+
+// fullLocked 返回名额是否已用完（调用方持有 mu）
+func (l *setupLimiter) fullLocked() bool {
+	return l.limit > 0 && l.pending >= l.limit
+}
+
+// full 返回名额是否已用完
+func (l *setupLimiter) full() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fullLocked()
+}
+
+// wait 等待名额未用完；take 为 true 时同时占用一个名额。
+// 等待超过 timeout、ctx 结束或客户端注销时返回 false
+func (l *setupLimiter) wait(ctx context.Context, timeout time.Duration, take bool) bool {
+	var expired <-chan time.Time
+	for {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return false
+		}
+		if !l.fullLocked() {
+			if take {
+				l.pending++
+			}
+			l.mu.Unlock()
+			return true
+		}
+		freed := l.freed
+		l.mu.Unlock()
+
+		if expired == nil && timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-freed:
+		case <-expired:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// tryTake 名额未用完时占用一个名额并返回 true，不等待
+func (l *setupLimiter) tryTake() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.fullLocked() {
+		return false
+	}
+	l.pending++
+	return true
+}
+
+// release 归还一个名额并唤醒等待者
+func (l *setupLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending--
+	l.wakeLocked()
+}
+
+// close 在客户端注销时唤醒所有等待者，此后的等待立即失败
+func (l *setupLimiter) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.wakeLocked()
+}
+
+func (l *setupLimiter) wakeLocked() {
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// releaseSetup 归还连接占用的建立名额（未占用或已归还时不做任何事）
+func (c *trackedConn) releaseSetup() {
+	if c.setupSlot != nil && c.setupHeld.CompareAndSwap(true, false) {
+		c.setupSlot.release()
+	}
+}
+
+// usesSetupSlots 判断客户端的连接是否占用建立名额（协商了 conn_ack）
+func (s *Server) usesSetupSlots(clientInfo *ClientInfo) bool {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	return clientInfo.Features&proto.FeatureConnAck != 0
+}
+
+// setupWaitTimeout 返回公开连接等待建立名额的最长时间：建立中的连接最迟在建立超时后归还名额
+func (s *Server) setupWaitTimeout() time.Duration {
+	if s.connSetupTimeout > 0 {
+		return s.connSetupTimeout
+	}
+	return defaultSetupWaitTimeout
+}
+
+// acquireSetupSlot 在发送 NEW_CONN 之前为公开连接占用客户端的一个建立名额：名额用完时等待（反压），
+// 直到有连接确认建立或超时关闭；等待超时或客户端注销时关闭公开连接并返回 false。
+// 占用名额时返回 true 和需要登记到连接上的限制器（客户端未协商 conn_ack 时为 nil）
+func (s *Server) acquireSetupSlot(ctx context.Context, clientInfo *ClientInfo, publicConn net.Conn) (*setupLimiter, bool) {
+	if clientInfo.setups == nil || !s.usesSetupSlots(clientInfo) {
+		return nil, true
+	}
+	l := clientInfo.setups
+	if l.full() {
+		s.setupBackpressure.Add(1)
+	}
+	if !l.wait(ctx, s.setupWaitTimeout(), true) {
+		s.setupRejected.Add(1)
+		s.setupRejectLog.printf("客户端建立中的连接数已达上限 (%d)，等待 %v 后仍未有连接完成建立，关闭外部连接: %s, clientID=%s",
+			s.maxPendingNewConns, s.setupWaitTimeout(), publicConn.RemoteAddr(), clientInfo.ID)
+		publicConn.Close()
+		return nil, false
+	}
+	return l, true
+}
+
+// trySetupSlot 在 worker 中为公开连接占用客户端的一个建立名额而不等待：名额已用完时返回 false，
+// 由调用方转到单独的 goroutine 中等待（acquireSetupSlot），一个客户端不确认 NEW_CONN 不会阻塞其他客户端的连接
+func (s *Server) trySetupSlot(clientInfo *ClientInfo) (*setupLimiter, bool) {
+	if clientInfo.setups == nil || !s.usesSetupSlots(clientInfo) {
+		return nil, true
+	}
+	if !clientInfo.setups.tryTake() {
+		return nil, false
+	}
+	return clientInfo.setups, true
+}
+
+// waitSetupCapacity 客户端专用公开端口的 accept 循环在客户端的建立名额用完时暂停接受新连接（连接留在内核的 accept 队列中），
+// 直到有名额归还；客户端注销或 ctx 结束时返回
+func (s *Server) waitSetupCapacity(ctx context.Context, clientID string) {
+	s.clientsMu.RLock()
+	clientInfo, ok := s.clients[clientID]
+	s.clientsMu.RUnlock()
+	if !ok || !clientInfo.setups.full() {
+		return
+	}
+	s.setupBackpressure.Add(1)
+	log.Printf("客户端建立中的连接数已达上限 (%d)，暂停接受其公开端口的新连接: clientID=%s", s.maxPendingNewConns, clientID)
+	clientInfo.setups.wait(ctx, 0, false)
+}
+
+// setupAvailable 从候选客户端中筛选建立名额未用完的，全部用完时原样返回（之后在转发阶段等待名额）
+func setupAvailable(candidates []*ClientInfo) []*ClientInfo {
+	if len(candidates) <= 1 {
+		return candidates
+	}
+	var within []*ClientInfo
+	for _, info := range candidates {
+		if !info.setups.full() {
+			within = append(within, info)
+		}
+	}
+	if len(within) == 0 {
+		return candidates
+	}
+	return within
+}
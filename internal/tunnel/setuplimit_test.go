@@ -0,0 +1,129 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMaxPendingNewConns 测试客户端建立中的连接数达到上限时服务器不再发送 NEW_CONN，
+// 等待的公开连接在已发送的连接确认建立后继续转发，建立中的连接数以指标输出
+func TestMaxPendingNewConns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public),
+		WithServerMaxPendingNewConns(2))
+	go server.Run(ctx)
+	dialer := &gateDialer{gate: make(chan struct{}), next: local}
+	go NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(dialer)).Run(ctx)
+	waitStat(t, "已注册的客户端", func() string { return registeredClients(server) }, "client-1")
+
+	inFlight := func() string {
+		for _, st := range server.ClientStatus() {
+			return strconv.Itoa(st.NewConnInFlight)
+		}
+		return ""
+	}
+	conns := make([]net.Conn, 4)
+	for i := range conns {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		conns[i] = conn
+	}
+	waitStat(t, "建立中的连接数", inFlight, "2")
+	time.Sleep(100 * time.Millisecond)
+	if st := server.ClientStatus(); st[0].ActiveConns != 2 {
+		t.Fatalf("达到上限后应只向客户端发送 2 个 NEW_CONN, 得到 %d 个连接", st[0].ActiveConns)
+	}
+	if server.setupBackpressure.Load() == 0 {
+		t.Error("超出上限的公开连接应计入反压次数")
+	}
+	var buf bytes.Buffer
+	server.writeMetrics(&buf)
+	if !strings.Contains(buf.String(), `reverse_tunnel_client_new_conn_in_flight{client_id="client-1"} 2`) {
+		t.Errorf("指标应输出建立中的连接数:\n%s", buf.String())
+	}
+
+	// 放行本地拨号，已发送的连接确认建立后等待的连接依次发送 NEW_CONN
+	close(dialer.gate)
+	for i, conn := range conns {
+		msg := "conn-" + strconv.Itoa(i)
+		go conn.Write([]byte(msg))
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, got); err != nil || string(got) != msg {
+			t.Fatalf("连接 %d 回显失败: %q, %v", i, got, err)
+		}
+	}
+	waitStat(t, "建立中的连接数", inFlight, "0")
+	if got := server.setupRejected.Load(); got != 0 {
+		t.Errorf("等待的连接不应被关闭, 得到 %d", got)
+	}
+}
+
+// TestSetupBackpressurePerClient 测试全局公开端口上一个客户端不确认 NEW_CONN 时只有它的连接等待建立名额，
+// 只有一个 worker 时其他客户端的连接仍照常转发
+func TestSetupBackpressurePerClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	server := NewServer(controlAddr, "", WithServerPublicListener(public), WithServerPublicQueue(0, "", 1),
+		WithServerGlobalRouting(GlobalRoutingRoundRobin), WithServerMaxPendingNewConns(1))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+	stuck := &gateDialer{gate: make(chan struct{}), next: local}
+	defer close(stuck.gate)
+	go NewClient(controlAddr, "local", 0, WithLocalDialer(stuck), WithHostnames("stuck.example.com")).Run(ctx)
+	go NewClient(controlAddr, "local", 0, WithLocalDialer(local), WithHostnames("ok.example.com")).Run(ctx)
+	waitStat(t, "主机名路由", func() int {
+		server.clientsMu.RLock()
+		defer server.clientsMu.RUnlock()
+		n := 0
+		for _, info := range server.clients {
+			n += len(info.Hostnames)
+		}
+		return n
+	}, 2)
+
+	request := func(host string) net.Conn {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		go conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		return conn
+	}
+	// 第一个连接占用 stuck 客户端唯一的建立名额，之后的连接等待名额
+	for i := 0; i < 3; i++ {
+		defer request("stuck.example.com").Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	conn := request("ok.example.com")
+	defer conn.Close()
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "GET" {
+		t.Fatalf("其他客户端的连接应照常转发: %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,178 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// clientShareLimiter 限制每个客户端在全局公开端口上占用的份额：同时打开的连接数上限，以及新建连接速率
+// （漏桶，与 sourceLimiter 相同，容量等于每秒速率）。全局公开端口由所有客户端共享，
+// 一个繁忙的客户端（或其背后的突发流量）不会占满共享的连接容量而让其他客户端无法接受新连接。
+// 在路由阶段生效：同一路由键的多个客户端中优先选择仍有剩余份额的，全部用完时关闭连接。
+// 每个客户端独占的公开端口不受影响
+type clientShareLimiter struct {
+	maxConns int     // 每个客户端同时打开的连接数上限（0 表示不限制）
+	rate     float64 // 每个客户端每秒新建连接数（0 表示不限制）
+	burst    float64 // 漏桶容量
+
+	mu        sync.Mutex
+	clients   map[string]*sourceState // map[clientID]状态（与来源限制共用状态结构）
+	rejected  map[string]uint64       // map[reason]拒绝数
+	lastSweep time.Time
+	rejectLog rateLimitedLog
+}
+
+// newClientShareLimiter 创建按客户端的份额限制，maxConns 和 rate 都为 0 时返回 nil（不限制）
+func newClientShareLimiter(maxConns, rate int) *clientShareLimiter {
+	if maxConns <= 0 && rate <= 0 {
+		return nil
+	}
+	return &clientShareLimiter{
+		maxConns: maxConns,
+		rate:     float64(rate),
+		burst:    float64(max(rate, 1)),
+		clients:  make(map[string]*sourceState),
+		rejected: make(map[string]uint64),
+	}
+}
+
+// exceededLocked 返回客户端当前超出的限制（拒绝原因），未超出时返回空（调用方持有 mu）
+func (l *clientShareLimiter) exceededLocked(clientID string, now time.Time) string {
+	st, ok := l.clients[clientID]
+	if !ok {
+		return ""
+	}
+	st.leak(now, l.rate)
+	switch {
+	case l.maxConns > 0 && st.conns >= l.maxConns:
+		return sourceRejectConns
+	case l.rate > 0 && st.level+1 > l.burst:
+		return sourceRejectRate
+	}
+	return ""
+}
+
+// available 从候选客户端中筛选仍有剩余份额的，全部用完时原样返回（之后由 admit 拒绝连接）
+func (l *clientShareLimiter) available(candidates []*ClientInfo) []*ClientInfo {
+	if l == nil || len(candidates) <= 1 {
+		return candidates
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var within []*ClientInfo
+	for _, info := range candidates {
+		if l.exceededLocked(info.ID, now) == "" {
+			within = append(within, info)
+		}
+	}
+	if len(within) == 0 {
+		return candidates
+	}
+	return within
+}
+
+// admit 登记路由到 clientID 的新连接，超出该客户端的份额时关闭连接并返回 false；
+// 允许时返回包装后的连接，关闭它（任意一次）时释放份额，应替代原连接使用（预读过的连接仍是 *peekedConn）
+func (l *clientShareLimiter) admit(conn net.Conn, clientID string) (net.Conn, bool) {
+	if l == nil {
+		return conn, true
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	l.sweepLocked(now)
+	if reason := l.exceededLocked(clientID, now); reason != "" {
+		l.clients[clientID].rejected++
+		l.rejected[reason]++
+		l.mu.Unlock()
+		l.rejectLog.printf("客户端在全局公开端口上的份额已用完 (%s)，关闭公开连接: %s, clientID=%s", reason, conn.RemoteAddr(), clientID)
+		conn.Close()
+		return nil, false
+	}
+	st, ok := l.clients[clientID]
+	if !ok {
+		st = &sourceState{last: now}
+		l.clients[clientID] = st
+	}
+	st.conns++
+	st.level++
+	l.mu.Unlock()
+
+	release := func() { l.release(clientID) }
+	if pc, ok := conn.(*peekedConn); ok {
+		// 包装底层连接，保留预读数据供 awaitFirstByte、injectTraceparent 识别
+		pc.Conn = &limitedConn{Conn: pc.Conn, release: release}
+		return pc, true
+	}
+	return &limitedConn{Conn: conn, release: release}, true
+}
+
+// release 释放客户端的一个连接
+func (l *clientShareLimiter) release(clientID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if st, ok := l.clients[clientID]; ok && st.conns > 0 {
+		st.conns--
+	}
+}
+
+// sweepLocked 每隔 sourceSweepInterval 删除没有打开的连接且水位已降到 0 的客户端（调用方持有 mu）
+func (l *clientShareLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < sourceSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for clientID, st := range l.clients {
+		st.leak(now, l.rate)
+		if st.conns == 0 && st.level == 0 {
+			delete(l.clients, clientID)
+		}
+	}
+}
+
+// writeMetrics 写入按客户端份额限制的指标：按原因的拒绝总数，以及每个被跟踪的客户端当前占用的连接数
+// （客户端空闲后随状态一起不再列出）
+func (l *clientShareLimiter) writeMetrics(buf *bytes.Buffer) {
+	if l == nil {
+		return
+	}
+	type clientUsage struct {
+		clientID string
+		conns    int
+		rejected uint64
+	}
+	l.mu.Lock()
+	byReason := map[string]uint64{sourceRejectConns: l.rejected[sourceRejectConns], sourceRejectRate: l.rejected[sourceRejectRate]}
+	usage := make([]clientUsage, 0, len(l.clients))
+	for clientID, st := range l.clients {
+		usage = append(usage, clientUsage{clientID, st.conns, st.rejected})
+	}
+	l.mu.Unlock()
+	sort.Slice(usage, func(i, j int) bool { return usage[i].clientID < usage[j].clientID })
+
+	buf.WriteString("# HELP reverse_tunnel_client_share_rejected_total Global listener connections rejected because the routed client used up its share.\n")
+	buf.WriteString("# TYPE reverse_tunnel_client_share_rejected_total counter\n")
+	for _, reason := range []string{sourceRejectConns, sourceRejectRate} {
+		fmt.Fprintf(buf, "reverse_tunnel_client_share_rejected_total{reason=%q} %d\n", reason, byReason[reason])
+	}
+	buf.WriteString("# HELP reverse_tunnel_client_share_conns Open global listener connections counted against each client's share.\n")
+	buf.WriteString("# TYPE reverse_tunnel_client_share_conns gauge\n")
+	for _, u := range usage {
+		fmt.Fprintf(buf, "reverse_tunnel_client_share_conns{client_id=%q} %d\n", u.clientID, u.conns)
+	}
+	buf.WriteString("# HELP reverse_tunnel_client_share_rejected_by_client Global listener connections rejected per tracked client because it used up its share.\n")
+	buf.WriteString("# TYPE reverse_tunnel_client_share_rejected_by_client gauge\n")
+	for _, u := range usage {
+		fmt.Fprintf(buf, "reverse_tunnel_client_share_rejected_by_client{client_id=%q} %d\n", u.clientID, u.rejected)
+	}
+	if l.maxConns > 0 {
+		buf.WriteString("# HELP reverse_tunnel_client_share_max_conns Configured per-client limit of open global listener connections.\n")
+		buf.WriteString("# TYPE reverse_tunnel_client_share_max_conns gauge\n")
+		fmt.Fprintf(buf, "reverse_tunnel_client_share_max_conns %d\n", l.maxConns)
+	}
+}
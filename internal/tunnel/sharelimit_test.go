@@ -0,0 +1,77 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// TestClientShareLimit 测试全局公开端口按客户端的份额限制：路由时跳过份额已用完的客户端，全部用完时拒绝连接，关闭连接后释放份额
+func TestClientShareLimit(t *testing.T) {
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", WithServerClientShareLimit(1, 0), WithServerGlobalRouting(GlobalRoutingWeighted))
+	for _, id := range []string{"client-1", "client-2"} {
+		server.clients[id] = &ClientInfo{ID: id}
+	}
+	route := func() (publicConnJob, bool) {
+		return server.routePublicConn(context.Background(), publicConnJob{conn: connFrom(t, "10.0.0.1")})
+	}
+
+	first, ok := route()
+	if !ok || first.clientID != "client-1" {
+		t.Fatalf("第一个连接应路由到 client-1，得到 %q (%v)", first.clientID, ok)
+	}
+	second, ok := route()
+	if !ok || second.clientID != "client-2" {
+		t.Fatalf("第二个连接应路由到 client-2，得到 %q (%v)", second.clientID, ok)
+	}
+	// 轮询下一个本应是 client-1，但其份额已用完
+	second.conn.Close()
+	third, ok := route()
+	if !ok || third.clientID != "client-2" {
+		t.Fatalf("应跳过份额已用完的 client-1，得到 %q (%v)", third.clientID, ok)
+	}
+	if _, ok := route(); ok {
+		t.Errorf("所有客户端的份额都用完时应拒绝连接")
+	}
+	// 关闭连接（重复关闭只释放一次）后份额释放
+	first.conn.Close()
+	first.conn.Close()
+	if job, ok := route(); !ok || job.clientID != "client-1" {
+		t.Errorf("连接关闭后应释放 client-1 的份额，得到 %q (%v)", job.clientID, ok)
+	}
+	if _, ok := route(); ok {
+		t.Errorf("重复关闭不应多次释放份额")
+	}
+
+	var buf bytes.Buffer
+	server.clientShare.writeMetrics(&buf)
+	for _, want := range []string{
+		`reverse_tunnel_client_share_rejected_total{reason="max_conns"} 2`,
+		`reverse_tunnel_client_share_conns{client_id="client-1"} 1`,
+		`reverse_tunnel_client_share_conns{client_id="client-2"} 1`,
+		`reverse_tunnel_client_share_max_conns 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("指标输出缺少 %q:\n%s", want, buf.String())
+		}
+	}
+
+	// 预读过的连接仍是 *peekedConn，预读的数据不会丢失
+	local, peer := net.Pipe()
+	defer peer.Close()
+	go peer.Write([]byte("GET"))
+	pc := &peekedConn{Conn: local, r: bufio.NewReader(local)}
+	pc.r.Peek(3)
+	conn, ok := newClientShareLimiter(0, 10).admit(pc, "client-1")
+	if _, peeked := conn.(*peekedConn); !ok || !peeked {
+		t.Fatalf("预读过的连接应保持为 *peekedConn")
+	}
+	conn.Close()
+
+	if newClientShareLimiter(0, 0) != nil {
+		t.Errorf("并发和速率都不限制时不应创建限制器")
+	}
+}
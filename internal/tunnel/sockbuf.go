@@ -0,0 +1,68 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// SocketBuffers 操作系统 socket 的接收/发送缓冲区大小（字节，0 表示使用系统默认值），以及 IP 包的 DSCP 标记
+// 在高带宽时延积链路（卫星、跨洲）上默认缓冲区会限制吞吐；缓冲区在连接建立（或开始监听）之前设置，
+// 因此在 TLS/WebSocket 封装之前生效，并参与 TCP 窗口缩放的协商。监听 socket 上的设置由接受的连接继承。
+// DSCP 供配置了 QoS 策略的网络按优先级处理隧道流量，只在支持的平台（Linux、macOS）上生效，其他平台忽略
+type SocketBuffers struct {
+	Read  int // SO_RCVBUF
+	Write int // SO_SNDBUF
+	DSCP  int // IP_TOS / IPV6_TCLASS 的高 6 位（0-63，0 表示不标记）
+}
+
+// enabled 判断是否需要设置缓冲区大小或 DSCP 标记
+func (b SocketBuffers) enabled() bool {
+	return b.Read > 0 || b.Write > 0 || b.DSCP > 0
+}
+
+// control 返回在 socket 连接/监听前设置缓冲区大小和 DSCP 标记的控制函数（都未设置时返回 nil）
+func (b SocketBuffers) control() func(network, address string, c syscall.RawConn) error {
+	if !b.enabled() {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if err := setSocketBuffers(c, b.Read, b.Write); err != nil {
+			return err
+		}
+		return setSocketDSCP(network, c, b.DSCP)
+	}
+}
+
+// dialer 返回应用缓冲区大小的 net.Dialer
+func (b SocketBuffers) dialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, Control: b.control()}
+}
+
+// listen 监听 TCP 地址，监听 socket 按 b 设置缓冲区大小
+func (b SocketBuffers) listen(network, address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: b.control()}
+	return lc.Listen(context.Background(), network, address)
+}
+
+// chainControl 依次调用多个 socket 控制函数（忽略 nil），任一返回错误时停止
+func chainControl(fns ...func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	var chained []func(network, address string, c syscall.RawConn) error
+	for _, fn := range fns {
+		if fn != nil {
+			chained = append(chained, fn)
+		}
+	}
+	if len(chained) == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		for _, fn := range chained {
+			if err := fn(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
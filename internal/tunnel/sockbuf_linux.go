@@ -0,0 +1,32 @@
+//go:build linux
+// +build linux
+
+package tunnel
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setSocketBuffers 设置 socket 的接收/发送缓冲区大小（0 表示保持系统默认值）
+// 内核会将请求值加倍并受 net.core.rmem_max / wmem_max 限制，超出上限时按上限生效
+func setSocketBuffers(c syscall.RawConn, read, write int) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if read > 0 {
+			if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, read); err != nil {
+				sockErr = fmt.Errorf("设置 socket 接收缓冲区失败: %w", err)
+				return
+			}
+		}
+		if write > 0 {
+			if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, write); err != nil {
+				sockErr = fmt.Errorf("设置 socket 发送缓冲区失败: %w", err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
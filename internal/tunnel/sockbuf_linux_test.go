@@ -0,0 +1,132 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// socketBufferSize 读取 TCP 连接的 SO_RCVBUF / SO_SNDBUF
+func socketBufferSize(t *testing.T, conn net.Conn, opt int) int {
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("获取 socket 失败: %v", err)
+	}
+	var size int
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		size, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	})
+	if sockErr != nil {
+		t.Fatalf("读取 socket 缓冲区大小失败: %v", sockErr)
+	}
+	return size
+}
+
+// TestSocketBuffers 测试监听 socket 上设置的缓冲区大小由接受的连接继承，拨号的连接在连接前应用缓冲区大小
+func TestSocketBuffers(t *testing.T) {
+	// 请求值高于常见的默认缓冲区（tcp_rmem/tcp_wmem 的默认值），内核按请求值的两倍分配（受 rmem_max/wmem_max 限制）
+	const read, write = 200 * 1024, 80 * 1024
+	bufs := SocketBuffers{Read: read, Write: write}
+
+	listener, err := TCPTransport{SocketBuffers: bufs}.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	dialed, err := TCPTransport{SocketBuffers: bufs}.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer dialed.Close()
+
+	var serverConn net.Conn
+	select {
+	case serverConn = <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("等待接受连接超时")
+	}
+	if serverConn == nil {
+		t.Fatal("接受连接失败")
+	}
+	defer serverConn.Close()
+
+	for name, conn := range map[string]net.Conn{"接受的连接": serverConn, "拨号的连接": dialed} {
+		if got := socketBufferSize(t, conn, syscall.SO_RCVBUF); got < read {
+			t.Errorf("%s的接收缓冲区为 %d，期望至少 %d", name, got, read)
+		}
+		if got := socketBufferSize(t, conn, syscall.SO_SNDBUF); got < write {
+			t.Errorf("%s的发送缓冲区为 %d，期望至少 %d", name, got, write)
+		}
+	}
+}
+
+// TestSocketDSCP 测试 IPv4 拨号的连接在连接前设置 DSCP 标记，监听 socket 上的标记由接受的连接继承
+func TestSocketDSCP(t *testing.T) {
+	const dscp = 46 // EF
+	bufs := SocketBuffers{DSCP: dscp}
+
+	listener, err := TCPTransport{SocketBuffers: bufs}.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	dialed, err := TCPTransport{SocketBuffers: bufs}.DialContext(context.Background(), "tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer dialed.Close()
+
+	var serverConn net.Conn
+	select {
+	case serverConn = <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("等待接受连接超时")
+	}
+	if serverConn == nil {
+		t.Fatal("接受连接失败")
+	}
+	defer serverConn.Close()
+
+	for name, conn := range map[string]net.Conn{"接受的连接": serverConn, "拨号的连接": dialed} {
+		raw, err := conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			t.Fatalf("获取 socket 失败: %v", err)
+		}
+		var tos int
+		var sockErr error
+		raw.Control(func(fd uintptr) {
+			tos, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+		})
+		if sockErr != nil {
+			t.Fatalf("读取 IP_TOS 失败: %v", sockErr)
+		}
+		if got := tos >> 2; got != dscp {
+			t.Errorf("%s的 DSCP 为 %d，期望 %d", name, got, dscp)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package tunnel
+
+import "syscall"
+
+// setSocketBuffers 当前平台不支持在连接前设置缓冲区大小，不做任何处理（使用系统默认值）
+func setSocketBuffers(c syscall.RawConn, read, write int) error {
+	return nil
+}
@@ -0,0 +1,217 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 按来源 IP 限制时拒绝公开连接的原因（指标的 reason 标签）
+const (
+	sourceRejectConns = "max_conns" // 该来源同时打开的连接数已达上限
+	sourceRejectRate  = "rate"      // 该来源新建连接的速率超限
+)
+
+// 按来源 IP 限制的状态维护参数
+const (
+	sourceSweepInterval = time.Minute // 清理空闲来源状态的最小间隔
+	sourceMetricsTopN   = 10          // 指标中按来源列出的拒绝数最多的来源数
+)
+
+// sourceLimiter 按来源 IP 限制公开连接：同时打开的连接数上限，以及新建连接速率（漏桶：每个新连接使水位加 1，
+// 水位以 rate/秒 的速度下降，超过 burst 时拒绝）。与全局队列和按身份的配额不同，它只约束单个来源，
+// 直接暴露在公网的公开端口上一个滥用的来源不会挤占其他正常来源的连接
+type sourceLimiter struct {
+	maxConns int     // 每个来源同时打开的连接数上限（0 表示不限制）
+	rate     float64 // 每个来源每秒新建连接数（0 表示不限制）
+	burst    float64 // 漏桶容量（允许的突发连接数）
+
+	mu        sync.Mutex
+	sources   map[string]*sourceState
+	rejected  map[string]uint64 // map[reason]拒绝数
+	lastSweep time.Time
+	rejectLog rateLimitedLog
+}
+
+// sourceState 一个来源 IP 的连接数和漏桶水位
+type sourceState struct {
+	conns    int
+	level    float64
+	last     time.Time
+	rejected uint64 // 该来源被拒绝的连接数（状态被清理时一并清除）
+}
+
+// newSourceLimiter 创建按来源 IP 的限制，maxConns 和 rate 都为 0 时返回 nil（不限制）；burst 为 0 时等于 rate（至少为 1）
+func newSourceLimiter(maxConns, rate, burst int) *sourceLimiter {
+	if maxConns <= 0 && rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(rate, 1)
+	}
+	return &sourceLimiter{
+		maxConns: maxConns,
+		rate:     float64(rate),
+		burst:    float64(burst),
+		sources:  make(map[string]*sourceState),
+		rejected: make(map[string]uint64),
+	}
+}
+
+// sourceKey 返回连接的来源 IP（无法解析时使用完整的对端地址）
+func sourceKey(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// admit 登记来源的新连接，超出限制时关闭连接并返回 false；
+// 允许时返回包装后的连接，关闭它（任意一次）时释放该来源的连接数，应替代原连接使用
+func (l *sourceLimiter) admit(conn net.Conn) (net.Conn, bool) {
+	if l == nil {
+		return conn, true
+	}
+	key := sourceKey(conn)
+	now := time.Now()
+
+	l.mu.Lock()
+	l.sweepLocked(now)
+	st, ok := l.sources[key]
+	if !ok {
+		st = &sourceState{last: now}
+		l.sources[key] = st
+	}
+	st.leak(now, l.rate)
+	reason := ""
+	switch {
+	case l.maxConns > 0 && st.conns >= l.maxConns:
+		reason = sourceRejectConns
+	case l.rate > 0 && st.level+1 > l.burst:
+		reason = sourceRejectRate
+	}
+	if reason != "" {
+		st.rejected++
+		l.rejected[reason]++
+		l.mu.Unlock()
+		l.rejectLog.printf("来源 %s 的公开连接超出限制 (%s)，关闭连接", key, reason)
+		conn.Close()
+		return nil, false
+	}
+	st.conns++
+	st.level++
+	l.mu.Unlock()
+	return &limitedConn{Conn: conn, release: func() { l.release(key) }}, true
+}
+
+// release 释放来源的一个连接
+func (l *sourceLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if st, ok := l.sources[key]; ok && st.conns > 0 {
+		st.conns--
+	}
+}
+
+// leak 按经过的时间降低漏桶水位
+func (st *sourceState) leak(now time.Time, rate float64) {
+	if rate > 0 {
+		st.level = max(st.level-now.Sub(st.last).Seconds()*rate, 0)
+	}
+	st.last = now
+}
+
+// sweepLocked 每隔 sourceSweepInterval 删除没有打开的连接且水位已降到 0 的来源（调用方持有 mu）
+func (l *sourceLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < sourceSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, st := range l.sources {
+		st.leak(now, l.rate)
+		if st.conns == 0 && st.level == 0 {
+			delete(l.sources, key)
+		}
+	}
+}
+
+// writeMetrics 写入按来源 IP 限制的指标：按原因的拒绝总数，以及当前跟踪的来源中拒绝数最多的 sourceMetricsTopN 个来源
+// （按来源的序列在来源空闲后随状态一起消失，避免大量来源使指标无限增长）
+func (l *sourceLimiter) writeMetrics(buf *bytes.Buffer) {
+	if l == nil {
+		return
+	}
+	type sourceRejects struct {
+		source string
+		count  uint64
+	}
+	l.mu.Lock()
+	byReason := map[string]uint64{sourceRejectConns: l.rejected[sourceRejectConns], sourceRejectRate: l.rejected[sourceRejectRate]}
+	tracked := len(l.sources)
+	var top []sourceRejects
+	for key, st := range l.sources {
+		if st.rejected > 0 {
+			top = append(top, sourceRejects{key, st.rejected})
+		}
+	}
+	l.mu.Unlock()
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].source < top[j].source
+	})
+	if len(top) > sourceMetricsTopN {
+		top = top[:sourceMetricsTopN]
+	}
+
+	buf.WriteString("# HELP reverse_tunnel_public_source_rejected_total Public connections rejected by the per-source-IP limit.\n")
+	buf.WriteString("# TYPE reverse_tunnel_public_source_rejected_total counter\n")
+	for _, reason := range []string{sourceRejectConns, sourceRejectRate} {
+		fmt.Fprintf(buf, "reverse_tunnel_public_source_rejected_total{reason=%q} %d\n", reason, byReason[reason])
+	}
+	buf.WriteString("# HELP reverse_tunnel_public_source_tracked Source IPs currently tracked by the per-source-IP limit.\n")
+	buf.WriteString("# TYPE reverse_tunnel_public_source_tracked gauge\n")
+	fmt.Fprintf(buf, "reverse_tunnel_public_source_tracked %d\n", tracked)
+	buf.WriteString("# HELP reverse_tunnel_public_source_rejected_by_source Public connections rejected per source IP, for the tracked sources with the most rejections.\n")
+	buf.WriteString("# TYPE reverse_tunnel_public_source_rejected_by_source gauge\n")
+	for _, st := range top {
+		fmt.Fprintf(buf, "reverse_tunnel_public_source_rejected_by_source{source=%q} %d\n", st.source, st.count)
+	}
+}
+
+// limitedConn 关闭时释放所占限制名额（来源连接数、客户端份额）的公开连接
+type limitedConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// CloseWrite 转发到底层连接（closeWithReason 依赖，底层不支持时返回错误）
+func (c *limitedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("连接不支持 CloseWrite")
+}
+
+// SetLinger 转发到底层连接（closeWithReason 依赖，底层不支持时返回错误）
+func (c *limitedConn) SetLinger(sec int) error {
+	if l, ok := c.Conn.(interface{ SetLinger(sec int) error }); ok {
+		return l.SetLinger(sec)
+	}
+	return errors.New("连接不支持 SetLinger")
+}
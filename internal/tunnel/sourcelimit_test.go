@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+// remoteAddrConn 指定对端地址的连接
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+// connFrom 返回对端地址为 ip 的连接
+func connFrom(t *testing.T, ip string) net.Conn {
+	local, peer := net.Pipe()
+	t.Cleanup(func() { local.Close(); peer.Close() })
+	return &remoteAddrConn{Conn: local, remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+}
+
+// TestSourceLimit 测试按来源 IP 的并发连接数和新建连接速率限制只影响超出限制的来源，并输出对应的指标
+func TestSourceLimit(t *testing.T) {
+	l := newSourceLimiter(2, 0, 0)
+	first, ok := l.admit(connFrom(t, "10.0.0.1"))
+	if !ok {
+		t.Fatalf("第一个连接应被接受")
+	}
+	if _, ok := l.admit(connFrom(t, "10.0.0.1")); !ok {
+		t.Fatalf("第二个连接应被接受")
+	}
+	if _, ok := l.admit(connFrom(t, "10.0.0.1")); ok {
+		t.Errorf("超出并发上限的连接应被拒绝")
+	}
+	if _, ok := l.admit(connFrom(t, "10.0.0.2")); !ok {
+		t.Errorf("其他来源的连接不应受影响")
+	}
+	// 关闭连接（重复关闭只释放一次）后名额释放
+	first.Close()
+	first.Close()
+	if _, ok := l.admit(connFrom(t, "10.0.0.1")); !ok {
+		t.Errorf("连接关闭后应释放名额")
+	}
+	if _, ok := l.admit(connFrom(t, "10.0.0.1")); ok {
+		t.Errorf("重复关闭不应多次释放名额")
+	}
+
+	// 每秒 1 个、突发 2 个：连续的第三个连接超出速率
+	l = newSourceLimiter(0, 1, 2)
+	for i := 0; i < 2; i++ {
+		conn, ok := l.admit(connFrom(t, "10.0.0.3"))
+		if !ok {
+			t.Fatalf("突发范围内的连接 %d 应被接受", i)
+		}
+		conn.Close()
+	}
+	if _, ok := l.admit(connFrom(t, "10.0.0.3")); ok {
+		t.Errorf("超出速率的连接应被拒绝")
+	}
+
+	var buf bytes.Buffer
+	l.writeMetrics(&buf)
+	for _, want := range []string{
+		`reverse_tunnel_public_source_rejected_total{reason="rate"} 1`,
+		`reverse_tunnel_public_source_rejected_total{reason="max_conns"} 0`,
+		`reverse_tunnel_public_source_rejected_by_source{source="10.0.0.3"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("指标输出缺少 %q:\n%s", want, buf.String())
+		}
+	}
+
+	if newSourceLimiter(0, 0, 5) != nil {
+		t.Errorf("并发和速率都不限制时不应创建限制器")
+	}
+}
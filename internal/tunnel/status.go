@@ -0,0 +1,155 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+)
+
+// ClientStatus 表示一个客户端的运行状态快照
+type ClientStatus struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`  // 控制连接的对端地址
+	Identity    string    `json:"identity"`     // 客户端身份（证书 CN，非 TLS 连接为空）
+	RemotePort  int       `json:"remote_port"`  // 客户端指定的远程端口（0 表示使用全局监听器）
+	ConnectedAt time.Time `json:"connected_at"` // 控制连接建立时间
+	ActiveConns int       `json:"active_conns"` // 当前活跃的公开连接数
+	BytesIn     uint64    `json:"bytes_in"`     // 从公开连接收到的累计字节数
+	BytesOut    uint64    `json:"bytes_out"`    // 写入公开连接的累计字节数
+	InRate      float64   `json:"in_rate"`      // 入方向吞吐（字节/秒，EWMA）
+	OutRate     float64   `json:"out_rate"`     // 出方向吞吐（字节/秒，EWMA）
+	Features    string    `json:"features"`     // 特性协商结果（逗号分隔的特性名称，未协商为 none）
+	MaxData     int       `json:"max_data"`     // 协商的 DATA 帧负载上限（字节，0 表示未协商）
+	Weight      int       `json:"weight"`       // 负载均衡权重（未声明时为默认值 1）
+	Healthy     bool      `json:"healthy"`      // 最近一次健康检查的结果（未启用健康检查或客户端不支持时始终为 true）
+	Paused      bool      `json:"paused"`       // 转发已被管理接口暂停（见 Server.PauseClient）
+
+	NewConnInFlight int `json:"new_conn_in_flight"` // 已发送 NEW_CONN、尚未确认建立的连接数（只统计协商了 conn_ack 的客户端），见 setuplimit.go
+
+	// 控制连接写入统计（观察队头阻塞）：写入累计耗时（包括等待其他写入者和对端接收窗口）、当前和最大排队帧数
+	ControlWriteBlocked  float64 `json:"control_write_blocked_seconds"`
+	ControlWriteQueue    int     `json:"control_write_queue"`
+	ControlWriteQueueMax int     `json:"control_write_queue_max"`
+}
+
+// ClientStatus 返回所有已连接客户端的状态（按 ID 排序）
+func (s *Server) ClientStatus() []ClientStatus {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	statuses := make([]ClientStatus, 0, len(s.clients))
+	for _, info := range s.clients {
+		st := ClientStatus{
+			ID:          info.ID,
+			Identity:    info.Identity,
+			RemotePort:  info.RemotePort,
+			ConnectedAt: info.ConnectedAt,
+			BytesIn:     info.inRate.Total(),
+			BytesOut:    info.outRate.Total(),
+			InRate:      info.inRate.Rate(),
+			OutRate:     info.outRate.Rate(),
+			Features:    info.Features.String(),
+			MaxData:     int(info.maxData.Load()),
+			Weight:      max(info.Weight, 1),
+			Healthy:     !info.unhealthy.Load(),
+			Paused:      info.paused.Load(),
+
+			NewConnInFlight: info.setups.inFlight(),
+		}
+		st.ControlWriteBlocked, st.ControlWriteQueue, st.ControlWriteQueueMax = info.writeMu.writeStats()
+		if info.Conn != nil {
+			st.RemoteAddr = info.Conn.RemoteAddr().String()
+		}
+		info.ConnMap.Range(func(_, value interface{}) bool {
+			if _, ok := value.(net.Conn); ok {
+				st.ActiveConns++
+			}
+			return true
+		})
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// PortForIdentity 返回身份（证书 CN）当前使用的公开端口
+// 同一身份有多个客户端在线时取最早连接且已完成 INIT 的客户端；使用全局监听器的客户端返回全局公开端口
+// 该身份没有在线客户端或尚未分配端口时 ok 为 false
+func (s *Server) PortForIdentity(cn string) (port int, ok bool) {
+	s.clientsMu.RLock()
+	var found *ClientInfo
+	for _, info := range s.clients {
+		if info.Identity != cn || (info.PublicListener == nil && info.RemotePort != 0) {
+			continue
+		}
+		if found == nil || info.ConnectedAt.Before(found.ConnectedAt) {
+			found = info
+		}
+	}
+	var listener net.Listener
+	if found != nil {
+		listener = found.PublicListener
+	}
+	s.clientsMu.RUnlock()
+
+	if found == nil {
+		return 0, false
+	}
+	if listener == nil {
+		s.publicListenerMu.Lock()
+		listener = s.publicListener
+		s.publicListenerMu.Unlock()
+	}
+	if listener == nil {
+		return 0, false
+	}
+	port = listenerPort(listener)
+	return port, port != 0
+}
+
+// Throughput 返回全局入/出方向吞吐（字节/秒，EWMA）
+func (s *Server) Throughput() (in, out float64) {
+	return s.inRate.Rate(), s.outRate.Rate()
+}
+
+// recordBytesIn 记录从公开连接收到的字节（全局、客户端和身份累计用量三级）
+func (s *Server) recordBytesIn(info *ClientInfo, n int) {
+	s.inRate.add(n)
+	info.inRate.add(n)
+	if info.usage != nil && n > 0 {
+		info.usage.in.Add(uint64(n))
+	}
+}
+
+// recordBytesOut 记录写入公开连接的字节（全局、客户端和身份累计用量三级）
+func (s *Server) recordBytesOut(info *ClientInfo, n int) {
+	s.outRate.add(n)
+	info.outRate.add(n)
+	if info.usage != nil && n > 0 {
+		info.usage.out.Add(uint64(n))
+	}
+}
+
+// runRateTicker 周期性更新全局和每个客户端的吞吐统计
+func (s *Server) runRateTicker(ctx context.Context) {
+	ticker := time.NewTicker(rateTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.inRate.tick(rateTickInterval)
+			s.outRate.tick(rateTickInterval)
+
+			s.clientsMu.RLock()
+			for _, info := range s.clients {
+				info.inRate.tick(rateTickInterval)
+				info.outRate.tick(rateTickInterval)
+			}
+			s.clientsMu.RUnlock()
+		}
+	}
+}
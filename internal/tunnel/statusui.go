@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// statusUIRefresh 状态页自动刷新间隔（秒）
+const statusUIRefresh = 5
+
+// statusUITemplate 内置状态页（单个自包含页面，不引用外部资源，可在隔离网络中使用）
+var statusUITemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"since": func(t time.Time) string { return time.Since(t).Truncate(time.Second).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>反向隧道状态</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f0f0f0; }
+td.num { text-align: right; font-family: monospace; }
+.muted { color: #888; }
+</style>
+</head>
+<body>
+<h1>反向隧道状态</h1>
+<p>客户端数: {{len .Clients}}，全局吞吐: 入 {{bytes .InRate}}/s，出 {{bytes .OutRate}}/s{{if .PublicListen}}，全局公开端口: {{.PublicListen}}{{end}}</p>
+<table>
+<tr><th>客户端</th><th>身份</th><th>远程地址</th><th>公开端口</th><th>已连接</th><th>活跃连接</th><th>入</th><th>出</th><th>入速率</th><th>出速率</th></tr>
+{{range .Clients}}<tr>
+<td>{{.ID}}</td>
+<td>{{if .Identity}}{{.Identity}}{{else}}<span class="muted">-</span>{{end}}</td>
+<td>{{.RemoteAddr}}</td>
+<td class="num">{{if .RemotePort}}{{.RemotePort}}{{else}}<span class="muted">全局</span>{{end}}</td>
+<td>{{since .ConnectedAt}}</td>
+<td class="num">{{.ActiveConns}}</td>
+<td class="num">{{bytes .BytesIn}}</td>
+<td class="num">{{bytes .BytesOut}}</td>
+<td class="num">{{bytes .InRate}}/s</td>
+<td class="num">{{bytes .OutRate}}/s</td>
+</tr>
+{{else}}<tr><td colspan="10" class="muted">没有已连接的客户端</td></tr>
+{{end}}</table>
+<p class="muted">每 {{.Refresh}} 秒自动刷新 · {{.Now.Format "2006-01-02 15:04:05"}}</p>
+</body>
+</html>
+`))
+
+// formatBytes 将字节数格式化为便于阅读的形式（接受 uint64 累计值和 float64 速率）
+func formatBytes(v interface{}) string {
+	var n float64
+	switch x := v.(type) {
+	case uint64:
+		n = float64(x)
+	case float64:
+		n = x
+	}
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// StatusUIHandler 返回内置 HTML 状态页的处理器（客户端、身份、端口、连接数和吞吐，自动刷新）
+func (s *Server) StatusUIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		in, out := s.Throughput()
+		data := struct {
+			Clients      []ClientStatus
+			InRate       float64
+			OutRate      float64
+			PublicListen string
+			Refresh      int
+			Now          time.Time
+		}{s.ClientStatus(), in, out, s.publicListenAddr, statusUIRefresh, time.Now()}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusUITemplate.Execute(w, data); err != nil {
+			log.Printf("输出状态页失败: %v", err)
+		}
+	})
+}
+
+// mountStatusUI 在 mux 上挂载 /ui/，要求携带管理令牌
+// 浏览器无法为自动刷新附加 Bearer 头，因此同时接受 HTTP Basic 认证（用户名任意，密码为令牌）
+func mountStatusUI(mux *http.ServeMux, s *Server) bool {
+	if s.adminToken == "" {
+		log.Printf("警告: 已启用状态页但未设置管理令牌，状态页不会挂载")
+		return false
+	}
+	mux.Handle("/ui/", requireBrowserToken(s.adminToken, s.StatusUIHandler()))
+	log.Printf("状态页已挂载: /ui/（需要管理令牌）")
+	return true
+}
+
+// requireBrowserToken 要求请求携带 "Authorization: Bearer <token>" 或密码为令牌的 Basic 认证
+func requireBrowserToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			got = password
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="reverse-tunnel"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
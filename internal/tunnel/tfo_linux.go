@@ -0,0 +1,17 @@
+//go:build linux
+// +build linux
+
+package tunnel
+
+import "syscall"
+
+// tcpFastOpenConnect 对应 Linux 的 TCP_FASTOPEN_CONNECT（内核 4.11+）
+const tcpFastOpenConnect = 30
+
+// setTCPFastOpenConnect 在拨号前为 socket 启用 TCP Fast Open
+// 内核不支持时 setsockopt 失败，此时忽略错误，按普通 TCP 连接
+func setTCPFastOpenConnect(network, address string, c syscall.RawConn) error {
+	return c.Control(func(fd uintptr) {
+		_ = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package tunnel
+
+import "syscall"
+
+// setTCPFastOpenConnect 当前平台不支持 TCP_FASTOPEN_CONNECT，不做任何处理
+func setTCPFastOpenConnect(network, address string, c syscall.RawConn) error {
+	return nil
+}
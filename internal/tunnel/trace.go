@@ -0,0 +1,106 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// traceparentHeader W3C Trace Context 的请求头名称
+const traceparentHeader = "traceparent"
+
+// newTraceID 生成一个随机追踪 ID（32 个十六进制字符，128 位）
+// 格式与 W3C Trace Context 的 trace-id 相同，多个服务器实例之间无需协调也不会冲突，
+// 启用 trace context 时可直接作为注入后端的 traceparent 的 trace-id
+func newTraceID() string {
+	return randomHex(16)
+}
+
+// newSpanID 生成一个随机 span ID（16 个十六进制字符，W3C Trace Context 的 parent-id）
+func newSpanID() string {
+	return randomHex(8)
+}
+
+// randomHex 返回 n 个随机字节的十六进制编码，随机数不可用时返回 "-"
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "-"
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseTraceparent 解析 traceparent 请求头（version 00：00-<trace-id>-<parent-id>-<flags>），返回 trace-id 和 flags
+// trace-id 或 parent-id 全为 0、长度或字符不合法时返回 false（按规范视为没有该请求头）
+func parseTraceparent(value string) (traceID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return "", "", false
+	}
+	for i, size := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != size || strings.Trim(parts[i], "0123456789abcdef") != "" {
+			return "", "", false
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+// injectTraceparent 为 HTTP/1.x 公开连接的第一个请求注入 traceparent 请求头，返回替代原连接使用的连接、追踪 ID 和注入的 traceparent
+// 请求已携带合法的 traceparent 时沿用其 trace-id 和 flags（公开请求的追踪延续到后端），否则生成新的 trace-id（flags 为 01，采样）；
+// parent-id 总是新生成的，表示隧道这一跳。原有的 traceparent 被替换，tracestate 等其他请求头原样保留。
+// 首包不是 HTTP/1.x 请求（TLS、其他协议）、请求头超过 hostPeekMaxSize 或等待 hostPeekTimeout 仍不完整时不修改数据，
+// 只返回新生成的追踪 ID，traceparent 为空。同一连接上之后的请求（keep-alive）不做修改
+func injectTraceparent(conn net.Conn) (net.Conn, string, string) {
+	pc, ok := conn.(*peekedConn)
+	if !ok {
+		pc = &peekedConn{Conn: conn, r: bufio.NewReaderSize(conn, hostPeekMaxSize)}
+	}
+	conn.SetReadDeadline(time.Now().Add(hostPeekTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	if first, err := pc.r.Peek(1); err != nil || first[0] < 'A' || first[0] > 'Z' {
+		return pc, newTraceID(), ""
+	}
+	header := peekHTTPHeader(pc.r)
+	if header == nil {
+		return pc, newTraceID(), ""
+	}
+	lines := strings.Split(string(header[:len(header)-4]), "\r\n")
+	if !strings.HasPrefix(lines[0][strings.LastIndexByte(lines[0], ' ')+1:], "HTTP/1.") {
+		return pc, newTraceID(), ""
+	}
+
+	traceID, flags := "", "01"
+	kept := lines[:1]
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(strings.TrimSpace(name), traceparentHeader) {
+			kept = append(kept, line)
+			continue
+		}
+		if id, f, ok := parseTraceparent(value); ok && traceID == "" {
+			traceID, flags = id, f
+		}
+	}
+	if traceID == "" {
+		traceID = newTraceID()
+	}
+	traceparent := "00-" + traceID + "-" + newSpanID() + "-" + flags
+
+	var rewritten bytes.Buffer
+	rewritten.WriteString(kept[0] + "\r\n" + traceparentHeader + ": " + traceparent + "\r\n")
+	for _, line := range kept[1:] {
+		rewritten.WriteString(line + "\r\n")
+	}
+	rewritten.WriteString("\r\n")
+	pc.r.Discard(len(header))
+	return &peekedConn{Conn: pc.Conn, r: bufio.NewReader(io.MultiReader(&rewritten, pc.r))}, traceID, traceparent
+}
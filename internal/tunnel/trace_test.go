@@ -0,0 +1,145 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// TestParseTraceparent 测试 traceparent 请求头的解析：只接受 version 00 的合法值，trace-id/parent-id 全为 0 时无效
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value   string
+		traceID string
+		flags   string
+		ok      bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "01", true},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", "4bf92f3577b34da6a3ce929d0e0e4736", "00", true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", "", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", "", "", false},
+		{"garbage", "", "", false},
+	}
+	for _, tt := range tests {
+		traceID, flags, ok := parseTraceparent(tt.value)
+		if traceID != tt.traceID || flags != tt.flags || ok != tt.ok {
+			t.Errorf("parseTraceparent(%q) = %q, %q, %v; 期望 %q, %q, %v", tt.value, traceID, flags, ok, tt.traceID, tt.flags, tt.ok)
+		}
+	}
+}
+
+// TestInjectTraceparent 测试为 HTTP 请求注入 traceparent：沿用请求携带的 trace-id、替换原有的值、没有时新生成；
+// 非 HTTP 数据原样转发，只生成追踪 ID
+func TestInjectTraceparent(t *testing.T) {
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+	tests := []struct {
+		name        string
+		request     string
+		wantTraceID string // 为空表示新生成
+		wantFlags   string
+	}{
+		{"沿用请求的 trace-id", "GET /a HTTP/1.1\r\nHost: app.example.com\r\nContent-Length: 4\r\nTraceparent: " + incoming + "\r\nTracestate: k=v\r\n\r\nbody", "4bf92f3577b34da6a3ce929d0e0e4736", "00"},
+		{"没有 traceparent", "POST /b HTTP/1.1\r\nHost: app.example.com\r\nContent-Length: 4\r\n\r\nbody", "", "01"},
+		{"无效的 traceparent", "GET /c HTTP/1.0\r\nContent-Length: 4\r\ntraceparent: 00-xyz\r\n\r\nbody", "", "01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			go client.Write([]byte(tt.request))
+
+			conn, traceID, traceparent := injectTraceparent(server)
+			defer conn.Close()
+			if tt.wantTraceID != "" && traceID != tt.wantTraceID {
+				t.Errorf("应沿用请求的 trace-id: 得到 %q, 期望 %q", traceID, tt.wantTraceID)
+			}
+			if len(traceID) != 32 {
+				t.Errorf("追踪 ID 应为 32 个十六进制字符: %q", traceID)
+			}
+			id, flags, ok := parseTraceparent(traceparent)
+			if !ok || id != traceID || flags != tt.wantFlags || traceparent == incoming {
+				t.Errorf("注入的 traceparent 无效: %q (trace=%s, flags 期望 %s，parent-id 应重新生成)", traceparent, traceID, tt.wantFlags)
+			}
+
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err != nil {
+				t.Fatalf("读取改写后的请求失败: %v", err)
+			}
+			if got := req.Header.Values("Traceparent"); len(got) != 1 || got[0] != traceparent {
+				t.Errorf("后端应只收到注入的 traceparent: %q, 期望 %q", got, traceparent)
+			}
+			if strings.Contains(tt.request, "Tracestate") && req.Header.Get("Tracestate") != "k=v" {
+				t.Errorf("tracestate 应原样保留: %q", req.Header.Get("Tracestate"))
+			}
+			if req.Host != "app.example.com" && strings.Contains(tt.request, "Host:") {
+				t.Errorf("其他请求头应原样保留: Host=%q", req.Host)
+			}
+			body, _ := io.ReadAll(io.LimitReader(req.Body, 4))
+			if string(body) != "body" {
+				t.Errorf("请求体应原样转发: %q", body)
+			}
+		})
+	}
+
+	// 非 HTTP 数据（TLS ClientHello）原样转发
+	server, client := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte{0x16, 0x03, 0x01, 0x00, 0x01, 0x01})
+	conn, traceID, traceparent := injectTraceparent(server)
+	defer conn.Close()
+	if traceparent != "" || len(traceID) != 32 {
+		t.Errorf("非 HTTP 连接不应注入: traceparent=%q, trace=%q", traceparent, traceID)
+	}
+	got := make([]byte, 6)
+	if _, err := io.ReadFull(conn, got); err != nil || got[0] != 0x16 || got[5] != 0x01 {
+		t.Errorf("非 HTTP 数据应原样转发: %x, %v", got, err)
+	}
+}
+
+// TestTraceparentDoesNotBlockWorker 测试启用 trace context 时预读第一个请求在转发 goroutine 中进行，
+// 不发送数据的公开连接不会阻塞调用 handlePublicConnection 的 worker，请求到达后才发送 NEW_CONN
+func TestTraceparentDoesNotBlockWorker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := NewServer("127.0.0.1:0", "127.0.0.1:0", WithServerTraceContext(true))
+	control, clientSide := net.Pipe()
+	defer control.Close()
+	defer clientSide.Close()
+	server.clients["client-1"] = &ClientInfo{ID: "client-1", Conn: control}
+	publicConn, peer := net.Pipe()
+	defer peer.Close()
+
+	done := make(chan struct{})
+	go func() {
+		server.handlePublicConnection(ctx, publicConn, "client-1", "")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(hostPeekTimeout / 3):
+		t.Fatal("handlePublicConnection 不应等待公开连接发送请求")
+	}
+	if got := server.activeForwarders.Load(); got != 1 {
+		t.Errorf("等待请求期间应占用转发名额, 得到 %d", got)
+	}
+
+	go peer.Write([]byte("GET / HTTP/1.1\r\nHost: app.example.com\r\n\r\n"))
+	clientSide.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame, err := proto.DecodeFrame(clientSide)
+	if err != nil || frame.Type != proto.FrameTypeNEW_CONN {
+		t.Fatalf("请求到达后应发送 NEW_CONN: %+v, %v", frame, err)
+	}
+	if info, _ := proto.DecodeNewConnInfo(frame.Payload); len(info.TraceID) != 32 {
+		t.Errorf("NEW_CONN 应携带追踪 ID: %+v", info)
+	}
+}
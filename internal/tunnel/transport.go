@@ -0,0 +1,248 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"reverse-tunnel/internal/pqctls"
+)
+
+// Dialer 建立连接的拨号器，*net.Dialer 满足该接口
+// 客户端默认通过 TCP（或 PQC mTLS）连接服务器、通过 TCP 连接本地服务；
+// 通过 WithControlDialer / WithLocalDialer 替换后可使用其他传输（例如测试中的内存管道）
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Transport 控制连接的传输方式：客户端通过 DialContext 连接服务器，服务器通过 Listen 接受控制连接
+// 隧道帧在返回的 net.Conn 上传输，与具体的承载方式（TCP、PQC mTLS、WebSocket 等）无关
+type Transport interface {
+	Dialer
+	Listen(network, address string) (net.Listener, error)
+	String() string // 传输名称（用于日志）
+}
+
+// 传输名称（配置项 transport 的取值）
+const (
+	TransportTCP       = "tcp"
+	TransportWebSocket = "websocket"
+)
+
+// controlDialTimeout 建立控制连接的超时时间
+const controlDialTimeout = 10 * time.Second
+
+// tlsHandshakeTimeout PQC mTLS 客户端握手的默认超时时间
+const tlsHandshakeTimeout = 10 * time.Second
+
+// ControlALPN PQC mTLS 控制连接的 ALPN 协议标识
+// 服务器和客户端都只接受该协议，未协商出该协议的连接被拒绝；以后修订控制协议时使用新的标识（例如 rt/2）
+const ControlALPN = "rt/1"
+
+// TCPTransport 纯 TCP 传输（默认）
+type TCPTransport struct {
+	Proxy         *url.URL      // 客户端：上游 HTTP 代理（nil 表示直接连接），通过 CONNECT 建立到服务器的隧道
+	SocketBuffers SocketBuffers // socket 接收/发送缓冲区大小（零值表示系统默认）
+}
+
+// DialContext 建立 TCP 连接（设置了 Proxy 时经 HTTP 代理的 CONNECT 隧道）
+func (t TCPTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if t.Proxy != nil {
+		return dialHTTPProxy(ctx, t.Proxy, network, address, controlDialTimeout, t.SocketBuffers)
+	}
+	return t.SocketBuffers.dialer(controlDialTimeout).DialContext(ctx, network, address)
+}
+
+// Listen 监听 TCP 地址
+func (t TCPTransport) Listen(network, address string) (net.Listener, error) {
+	return t.SocketBuffers.listen(network, address)
+}
+
+func (TCPTransport) String() string { return "TCP" }
+
+// PQCTLSTransport 基于 TCP 的 PQC mTLS 传输（通过 OpenSSL）
+type PQCTLSTransport struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+
+	SessionResumption  bool                 // 服务器：允许客户端恢复会话（默认禁用）
+	ClientCertOptional bool                 // 服务器：不要求客户端证书（单向 TLS，默认要求，即 mTLS）
+	SessionCache       *pqctls.SessionCache // 客户端：会话缓存（nil 表示不恢复会话）
+	MinSecurityLevel   int                  // 要求的最低 NIST 安全级别（1-5，0 表示接受全部 ML-KEM/ML-DSA 参数集）
+	ServerFingerprint  []byte               // 客户端：固定的服务器证书 SHA-256 指纹（nil 表示不校验，CAFile 为空时只校验指纹）
+
+	DialTimeout      time.Duration // 客户端：TCP 连接超时（0 表示 controlDialTimeout）
+	HandshakeTimeout time.Duration // 客户端：TLS 握手超时（0 表示 tlsHandshakeTimeout）
+	Proxy            *url.URL      // 客户端：上游 HTTP 代理（nil 表示直接连接），在 CONNECT 隧道上进行握手
+	SocketBuffers    SocketBuffers // socket 接收/发送缓冲区大小（零值表示系统默认），在握手之前设置
+
+	MaxHandshakes        int    // 服务器：同时进行的握手数上限（0 表示不并发握手，逐个在 Accept 中完成）
+	HandshakeLimitPolicy string // 服务器：握手数达到上限时的策略，HandshakeLimitQueue（默认）或 HandshakeLimitReject
+
+	handshakeStats *handshakeLimitStats // 服务器：并发握手限制的统计（由 Server 设置，nil 表示不输出指标）
+
+	// 客户端：重连时复用的拨号器（SSL_CTX），只在首次拨号和证书文件变化时创建，由 Close 释放
+	dialerMu    sync.Mutex
+	dialer      *pqctls.PQCDialer
+	dialerStamp string // 创建 dialer 时证书文件的状态（见 certFileStamp）
+}
+
+// CheckFiles 检查证书、私钥和 CA 文件存在且可以加载（见 pqctls.CheckCertificateFiles）
+// 证书配置错误不会因重试而恢复，Listen 和 Client.Run 在开始前检查一次，给出明确的错误
+func (t *PQCTLSTransport) CheckFiles() error {
+	if err := pqctls.CheckCertificateFiles(t.CertFile, t.KeyFile, t.CAFile); err != nil {
+		return fmt.Errorf("PQC mTLS 证书配置无效: %w", err)
+	}
+	return nil
+}
+
+// DialContext 建立 TCP 连接并完成 PQC mTLS 握手（ctx 只作用于 TCP 连接阶段，握手受 HandshakeTimeout 约束）
+// 拨号器在多次调用间复用（见 clientDialer），同一时间只进行一次拨号
+func (t *PQCTLSTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	t.dialerMu.Lock()
+	defer t.dialerMu.Unlock()
+	dialer, err := t.clientDialer()
+	if err != nil {
+		return nil, err
+	}
+	dialTimeout := t.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = controlDialTimeout
+	}
+
+	var conn net.Conn
+	if t.Proxy != nil {
+		tunnelConn, err := dialHTTPProxy(ctx, t.Proxy, network, address, dialTimeout, t.SocketBuffers)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = dialer.Client(tunnelConn)
+	} else {
+		conn, err = dialer.DialContext(ctx, network, address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("PQC TLS 连接失败: %w", err)
+	}
+	return conn, nil
+}
+
+// clientDialer 返回复用的拨号器：首次调用或证书文件变化（例如证书轮换后）时创建新的 SSL_CTX 并释放旧的，
+// 否则沿用已有的，重连时不再重复加载和解析证书（调用方需持有 dialerMu）
+// 已建立的连接各自持有 SSL_CTX 的引用，释放旧的拨号器不影响它们
+func (t *PQCTLSTransport) clientDialer() (*pqctls.PQCDialer, error) {
+	stamp := certFileStamp(t.CertFile, t.KeyFile, t.CAFile)
+	if t.dialer != nil && stamp == t.dialerStamp {
+		return t.dialer, nil
+	}
+
+	dialer, err := pqctls.NewPQCDialerOpenSSL(t.CertFile, t.KeyFile, t.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("创建 PQC TLS 拨号器失败: %w", err)
+	}
+	dialer.SetSessionCache(t.SessionCache)
+	dialer.SetServerFingerprint(t.ServerFingerprint)
+	if err := dialer.SetALPNProtocols([]string{ControlALPN}); err != nil {
+		dialer.Close()
+		return nil, fmt.Errorf("设置 ALPN 失败: %w", err)
+	}
+	if t.MinSecurityLevel > 0 {
+		if err := dialer.SetMinSecurityLevel(t.MinSecurityLevel); err != nil {
+			dialer.Close()
+			return nil, fmt.Errorf("设置最低安全级别失败: %w", err)
+		}
+	}
+	dialTimeout, handshakeTimeout := t.DialTimeout, t.HandshakeTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = controlDialTimeout
+	}
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = tlsHandshakeTimeout
+	}
+	dialer.SetDialTimeout(dialTimeout)
+	dialer.SetHandshakeTimeout(handshakeTimeout)
+	dialer.SetDialControl(t.SocketBuffers.control())
+
+	if t.dialer != nil {
+		log.Printf("证书文件已变化，重新加载 PQC TLS 拨号器")
+		t.dialer.Close()
+	}
+	t.dialer, t.dialerStamp = dialer, stamp
+	return dialer, nil
+}
+
+// Close 释放复用的拨号器（可重复调用，之后的 DialContext 重新创建）
+func (t *PQCTLSTransport) Close() error {
+	t.dialerMu.Lock()
+	defer t.dialerMu.Unlock()
+	if t.dialer != nil {
+		t.dialer.Close()
+		t.dialer, t.dialerStamp = nil, ""
+	}
+	return nil
+}
+
+// certFileStamp 返回文件的修改时间和大小（用于检测证书文件是否变化），无法访问的文件记为其错误
+func certFileStamp(files ...string) string {
+	var b strings.Builder
+	for _, f := range files {
+		if f == "" {
+			b.WriteString("-;")
+			continue
+		}
+		if fi, err := os.Stat(f); err != nil {
+			fmt.Fprintf(&b, "%v;", err)
+		} else {
+			fmt.Fprintf(&b, "%d:%d;", fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	return b.String()
+}
+
+// Listen 监听 TCP 地址，接受的连接完成 PQC mTLS 握手后返回
+func (t *PQCTLSTransport) Listen(network, address string) (net.Listener, error) {
+	if err := t.CheckFiles(); err != nil {
+		return nil, err
+	}
+	baseListener, err := t.SocketBuffers.listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := pqctls.NewPQCListenerOpenSSL(baseListener, t.CertFile, t.KeyFile, t.CAFile)
+	if err != nil {
+		baseListener.Close()
+		return nil, fmt.Errorf("创建 PQC TLS 监听器失败: %w", err)
+	}
+	if err := listener.SetALPNProtocols([]string{ControlALPN}); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("设置 ALPN 失败: %w", err)
+	}
+	if t.ClientCertOptional {
+		listener.SetRequireClientCert(false)
+	}
+	if t.SessionResumption {
+		if err := listener.SetSessionResumption(true); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("启用 TLS 会话恢复失败: %w", err)
+		}
+	}
+	if t.MinSecurityLevel > 0 {
+		if err := listener.SetMinSecurityLevel(t.MinSecurityLevel); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("设置最低安全级别失败: %w", err)
+		}
+	}
+	if t.MaxHandshakes > 0 {
+		return newHandshakeLimitListener(listener, t.MaxHandshakes, t.HandshakeLimitPolicy, t.handshakeStats), nil
+	}
+	return listener, nil
+}
+
+func (t *PQCTLSTransport) String() string { return "PQC mTLS via OpenSSL" }
@@ -0,0 +1,402 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// memAddr 内存传输的地址
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// memListener 基于 net.Pipe 的内存监听器，同时作为拨号器：每次 DialContext 创建一对管道，一端交给 Accept
+type memListener struct {
+	addr      memAddr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMemListener(name string) *memListener {
+	return &memListener{
+		addr:  memAddr(name),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr { return l.addr }
+
+func (l *memListener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, fmt.Errorf("dial %s: %w", l.addr, net.ErrClosed)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// serveMemEcho 在内存监听器上运行回显服务
+func serveMemEcho(l *memListener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+	}
+}
+
+// TestInMemoryTransport 测试控制连接、公开连接和本地连接全部使用内存管道时的完整转发流程（不占用端口）
+func TestInMemoryTransport(t *testing.T) {
+	control := newMemListener("control")
+	public := newMemListener("public")
+	local := newMemListener("local")
+	defer local.Close()
+	go serveMemEcho(local)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewServer("", "", WithServerControlListener(control), WithServerPublicListener(public))
+	go server.Run(ctx)
+	client := NewClient("control", "local", 0, WithControlDialer(control), WithLocalDialer(local))
+	go client.Run(ctx)
+
+	// 等待客户端注册
+	deadline := time.Now().Add(2 * time.Second)
+	for len(server.ClientStatus()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("客户端未能通过内存传输注册")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := public.DialContext(ctx, "mem", "public")
+		if err != nil {
+			t.Fatalf("连接公开监听器失败: %v", err)
+		}
+		msg := []byte(fmt.Sprintf("hello over pipe %d", i))
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		go conn.Write(msg)
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("连接 %d 读取回显失败: %v", i, err)
+		}
+		if string(got) != string(msg) {
+			t.Errorf("连接 %d 回显不匹配: 期望 %q, 得到 %q", i, msg, got)
+		}
+		conn.Close()
+	}
+}
+
+// TestWebSocketTransport 测试控制连接通过 WebSocket 传输时的完整转发流程，以及非升级请求被拒绝
+func TestWebSocketTransport(t *testing.T) {
+	localAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	echo := startEchoServer(t, localAddr)
+	defer echo.Close()
+
+	controlAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+	publicAddr := fmt.Sprintf("127.0.0.1:%d", getFreePort(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := &WebSocketTransport{Path: "/ws"}
+	server := NewServer(controlAddr, publicAddr, WithServerTransport(transport))
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// 普通 HTTP 请求不会被当作控制连接
+	resp, err := http.Get("http://" + controlAddr + "/ws")
+	if err != nil {
+		t.Fatalf("HTTP 请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("非升级请求应返回 400，得到 %d", resp.StatusCode)
+	}
+
+	client := NewClient(controlAddr, localAddr, 0, WithTransport(transport))
+	go client.Run(ctx)
+	time.Sleep(300 * time.Millisecond)
+
+	conn, err := net.DialTimeout("tcp", publicAddr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("连接公开端口失败: %v", err)
+	}
+	defer conn.Close()
+
+	// 超过 125 和 65535 字节的消息分别使用 16 位和 64 位长度
+	for _, size := range []int{10, 1000, 100000} {
+		msg := bytes.Repeat([]byte{byte(size)}, size)
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		go conn.Write(msg)
+		got := make([]byte, size)
+		if _, err := io.ReadFull(conn, got); err != nil {
+			t.Fatalf("读取 %d 字节回显失败: %v", size, err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("%d 字节回显不匹配", size)
+		}
+	}
+}
+
+// TestWebSocketFrameValidation 测试服务器端拒绝未加掩码的帧和文本帧（以状态码 1002 发送 close 并断开），
+// 收到 close 时以状态码 1000 回复
+func TestWebSocketFrameValidation(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		frame []byte // 对端发送的原始帧
+		code  uint16 // 服务器回复的 close 状态码
+	}{
+		{"未加掩码", []byte{0x80 | wsOpBinary, 2, 'h', 'i'}, wsCloseProtocolError},
+		{"文本帧", []byte{0x80 | wsOpText, 0x80 | 2, 0, 0, 0, 0, 'h', 'i'}, wsCloseProtocolError},
+		{"close", []byte{0x80 | wsOpClose, 0x80 | 2, 0, 0, 0, 0, 0x03, 0xE8}, wsCloseNormal},
+	} {
+		serverSide, peer := net.Pipe()
+		peer.SetDeadline(time.Now().Add(2 * time.Second))
+		ws := newWSConn(serverSide, bufio.NewReader(serverSide), false)
+		readErr := make(chan error, 1)
+		go func() {
+			_, err := ws.Read(make([]byte, 10))
+			readErr <- err
+		}()
+		go peer.Write(c.frame)
+
+		var header [2]byte
+		if _, err := io.ReadFull(peer, header[:]); err != nil {
+			t.Fatalf("%s: 读取 close 帧失败: %v", c.name, err)
+		}
+		payload := make([]byte, header[1]&0x7F)
+		if _, err := io.ReadFull(peer, payload); err != nil {
+			t.Fatalf("%s: 读取 close 帧负载失败: %v", c.name, err)
+		}
+		if header[0]&0x0F != wsOpClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != c.code {
+			t.Errorf("%s: 应回复状态码 %d 的 close 帧, 得到 %x %x", c.name, c.code, header, payload)
+		}
+		if err := <-readErr; (c.code == wsCloseNormal) != (err == io.EOF) || err == nil {
+			t.Errorf("%s: Read 返回 %v", c.name, err)
+		}
+		peer.Close()
+		serverSide.Close()
+	}
+}
+
+// yieldConn 每次 Write 前让出处理器，放大并发写入者在帧头和负载之间交错的机会
+type yieldConn struct {
+	net.Conn
+}
+
+func (c yieldConn) Write(p []byte) (int, error) {
+	runtime.Gosched()
+	return c.Conn.Write(p)
+}
+
+// TestConcurrentFrameWrites 测试多个 goroutine 通过同一写锁并发向非 TCP 连接写帧时帧头和负载不会与其他帧交错，
+// 并且写锁统计到排队的帧数和写入阻塞时间
+func TestConcurrentFrameWrites(t *testing.T) {
+	serverSide, pipeSide := net.Pipe()
+	defer serverSide.Close()
+	defer pipeSide.Close()
+	clientSide := yieldConn{pipeSide}
+
+	const writers = 8
+	const frames = 50
+	var cw controlWriter
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			payload := bytes.Repeat([]byte{byte(w)}, 1000+w)
+			for i := 0; i < frames; i++ {
+				if err := writeFrame(clientSide, &cw, &proto.Frame{Type: proto.FrameTypeDATA, ConnID: uint32(w), Payload: payload}, 0); err != nil {
+					return
+				}
+			}
+		}(w)
+	}
+
+	serverSide.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < writers*frames; i++ {
+		frame, err := proto.DecodeFrame(serverSide)
+		if err != nil {
+			t.Fatalf("读取第 %d 个帧失败: %v", i, err)
+		}
+		w := int(frame.ConnID)
+		if !bytes.Equal(frame.Payload, bytes.Repeat([]byte{byte(w)}, 1000+w)) {
+			t.Fatalf("第 %d 个帧 (connID=%d) 的负载与其他帧交错", i, frame.ConnID)
+		}
+	}
+
+	// net.Pipe 没有缓冲，读取方每次只接收一个写入，其余写入者在写锁上排队
+	blocked, _, maxDepth := cw.writeStats()
+	if blocked <= 0 || maxDepth < 2 || maxDepth > writers {
+		t.Errorf("写入统计不合理: 阻塞 %gs, 最大排队 %d 帧", blocked, maxDepth)
+	}
+	deadline := time.Now().Add(time.Second)
+	for _, depth, _ := cw.writeStats(); depth != 0; _, depth, _ = cw.writeStats() {
+		if time.Now().After(deadline) {
+			t.Fatalf("所有帧写完后排队帧数应为 0，得到 %d", depth)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestBatchedControlFrames 测试并发写入的小控制帧合并写出时与 DATA 帧互不交错、每个写入者的帧保持顺序，且写入次数少于帧数
+func TestBatchedControlFrames(t *testing.T) {
+	serverSide, pipeSide := net.Pipe()
+	defer serverSide.Close()
+	defer pipeSide.Close()
+	clientSide := &countingConn{Conn: pipeSide, delay: 100 * time.Microsecond}
+
+	const writers = 8
+	const frames = 50
+	var cw controlWriter
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			for i := 0; i < frames; i++ {
+				frame := &proto.Frame{Type: proto.FrameTypeCLOSE, ConnID: uint32(w), Payload: []byte{byte(i)}}
+				if i%10 == 9 {
+					frame = &proto.Frame{Type: proto.FrameTypeDATA, ConnID: uint32(w), Payload: bytes.Repeat([]byte{byte(i)}, 1000)}
+				}
+				if err := writeFrame(clientSide, &cw, frame, 0); err != nil {
+					return
+				}
+			}
+		}(w)
+	}
+
+	serverSide.SetReadDeadline(time.Now().Add(5 * time.Second))
+	next := make([]int, writers)
+	for i := 0; i < writers*frames; i++ {
+		frame, err := proto.DecodeFrame(serverSide)
+		if err != nil {
+			t.Fatalf("读取第 %d 个帧失败: %v", i, err)
+		}
+		w := int(frame.ConnID)
+		if len(frame.Payload) == 0 || int(frame.Payload[0]) != next[w] {
+			t.Fatalf("写入者 %d 的帧乱序或与其他帧交错: 期望第 %d 个, 得到 %s %x", w, next[w], frame.Type, frame.Payload)
+		}
+		next[w]++
+	}
+	if writes := clientSide.writes.Load(); writes >= writers*frames {
+		t.Errorf("小控制帧应合并写出: %d 个帧写入了 %d 次", writers*frames, writes)
+	}
+}
+
+// countingConn 统计 Write 调用次数的连接，每次 Write 前等待 delay（模拟写入系统调用的耗时，期间其他写入者排队）
+// Conn 为 nil 时丢弃写入的数据
+type countingConn struct {
+	net.Conn
+	delay  time.Duration
+	writes atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	if c.delay > 0 {
+		time.Sleep(c.delay)
+	}
+	if c.Conn == nil {
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+// BenchmarkControlFrameWrites 模拟短连接密集的隧道：多个 goroutine 并发为各自的连接写 NEW_CONN 和 CLOSE，
+// 对比合并小控制帧前后每对帧的写入次数（writes/op）。每次写入耗时 10µs，模拟写入系统调用
+func BenchmarkControlFrameWrites(b *testing.B) {
+	for _, batch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batch=%v", batch), func(b *testing.B) {
+			defer func(old bool) { batchControlFrames = old }(batchControlFrames)
+			batchControlFrames = batch
+			conn := &countingConn{delay: 10 * time.Microsecond}
+			var cw controlWriter
+			var connID atomic.Uint32
+
+			b.ReportAllocs()
+			b.SetParallelism(8)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := connID.Add(1)
+					if err := writeFrame(conn, &cw, &proto.Frame{Type: proto.FrameTypeNEW_CONN, ConnID: id}, 0); err != nil {
+						b.Error(err)
+						return
+					}
+					if err := writeFrame(conn, &cw, &proto.Frame{Type: proto.FrameTypeCLOSE, ConnID: id}, 0); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(conn.writes.Load())/float64(b.N), "writes/op")
+		})
+	}
+}
+
+// TestCertFileStamp 测试证书文件内容或修改时间变化时状态随之变化（触发重新创建拨号器），未变化时保持不变
+func TestCertFileStamp(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	for _, f := range []string{cert, key} {
+		if err := os.WriteFile(f, []byte("v1"), 0600); err != nil {
+			t.Fatalf("写入文件失败: %v", err)
+		}
+	}
+	stamp := certFileStamp(cert, key, "")
+	if again := certFileStamp(cert, key, ""); again != stamp {
+		t.Errorf("文件未变化时状态不应变化: %q != %q", again, stamp)
+	}
+
+	// 证书轮换：内容和修改时间都变化
+	if err := os.WriteFile(cert, []byte("v2-rotated"), 0600); err != nil {
+		t.Fatalf("写入文件失败: %v", err)
+	}
+	os.Chtimes(cert, time.Now(), time.Now().Add(time.Minute))
+	rotated := certFileStamp(cert, key, "")
+	if rotated == stamp {
+		t.Errorf("证书文件变化后状态应变化")
+	}
+
+	os.Remove(key)
+	if certFileStamp(cert, key, "") == rotated {
+		t.Errorf("私钥文件被删除后状态应变化")
+	}
+}
@@ -0,0 +1,76 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// 运行期间增删隧道：客户端通过一个控制连接承载一个隧道（服务器上的一个专用远程端口，以远程端口标识），
+// AddTunnel/RemoveTunnel 在 SetRemotePort 之上为嵌入客户端的程序提供显式的增删接口。
+// 删除隧道时除让服务器关闭该端口外，还关闭隧道上所有已建立的连接（与 SetRemotePort 取消端口时已建立的连接继续转发不同）
+
+// errTunnelExists 客户端已有隧道（一个客户端只承载一个隧道）
+var errTunnelExists = errors.New("客户端已有隧道")
+
+// errTunnelNotFound 要删除的隧道不存在
+var errTunnelNotFound = errors.New("隧道不存在")
+
+// TunnelSpec 客户端隧道的配置（AddTunnel 的参数）
+type TunnelSpec struct {
+	RemotePort int // 服务器要监听的远程端口（1-65535），同时是隧道的标识
+}
+
+// AddTunnel 添加隧道，返回隧道的标识（即远程端口）：已连接时立即发送 INIT，服务器在该端口上监听，
+// 服务器的确认或拒绝异步到达（见 SetRemotePort）；未连接时在下次连接时生效。客户端已有隧道时返回错误（先调用 RemoveTunnel）
+func (c *Client) AddTunnel(spec TunnelSpec) (int, error) {
+	if spec.RemotePort <= 0 || spec.RemotePort > 65535 {
+		return 0, fmt.Errorf("无效的远程端口: %d", spec.RemotePort)
+	}
+	c.tunnelMu.Lock()
+	defer c.tunnelMu.Unlock()
+	if port := c.targetRemotePort(); port != 0 {
+		return 0, fmt.Errorf("%w（远程端口 %d）", errTunnelExists, port)
+	}
+	if err := c.SetRemotePort(spec.RemotePort); err != nil {
+		return 0, err
+	}
+	log.Printf("已添加隧道: 远程端口=%d", spec.RemotePort)
+	return spec.RemotePort, nil
+}
+
+// RemoveTunnel 删除标识为 id 的隧道：取消远程端口（服务器关闭该端口的监听器），
+// 并关闭隧道上所有已建立的连接，以 CloseShutdown 原因通知服务器关闭对应的公开连接。控制连接保持
+func (c *Client) RemoveTunnel(id int) error {
+	c.tunnelMu.Lock()
+	defer c.tunnelMu.Unlock()
+	if port := c.targetRemotePort(); port == 0 || port != id {
+		return fmt.Errorf("%w: %d", errTunnelNotFound, id)
+	}
+	if err := c.SetRemotePort(0); err != nil {
+		return err
+	}
+	closed := c.closeTunnelConns()
+	log.Printf("已删除隧道: 远程端口=%d，关闭了 %d 个连接", id, closed)
+	return nil
+}
+
+// closeTunnelConns 关闭隧道上所有已建立的本地连接并通知服务器关闭对应的公开连接，返回关闭的连接数
+// 已被服务器关闭的连接（closeLocal 返回 false）由 handleCloseFrame 完成清理；转发 goroutine 随后读到错误退出
+func (c *Client) closeTunnelConns() int {
+	closed := 0
+	c.connMap.Range(func(key, value interface{}) bool {
+		connID, localConn := key.(uint32), value.(*trackedConn)
+		if !localConn.closeLocal() {
+			return true
+		}
+		c.connMap.Delete(connID)
+		localConn.Close()
+		c.sendCloseFrame(connID, localConn.traceID, proto.CloseShutdown)
+		closed++
+		return true
+	})
+	return closed
+}
@@ -0,0 +1,409 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWebSocketPath WebSocket 传输的默认升级路径
+const DefaultWebSocketPath = "/tunnel"
+
+// websocketGUID 计算 Sec-WebSocket-Accept 使用的固定 GUID（RFC 6455）
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket 帧操作码
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// close 帧的状态码（RFC 6455 7.4.1）
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+)
+
+// wsHandshakeTimeout WebSocket 升级握手超时
+const wsHandshakeTimeout = 10 * time.Second
+
+// WebSocketTransport 基于 WebSocket 的传输（RFC 6455，二进制帧），用于穿越只放行 HTTP 的网络和反向代理
+// 隧道帧作为 WebSocket 二进制消息的负载传输；不提供加密，需要加密时在前面部署终止 TLS 的反向代理
+type WebSocketTransport struct {
+	Path          string        // 升级路径（空表示 DefaultWebSocketPath）
+	Proxy         *url.URL      // 客户端：上游 HTTP 代理（nil 表示直接连接）
+	SocketBuffers SocketBuffers // socket 接收/发送缓冲区大小（零值表示系统默认）
+}
+
+func (t *WebSocketTransport) path() string {
+	if t.Path == "" {
+		return DefaultWebSocketPath
+	}
+	return t.Path
+}
+
+func (t *WebSocketTransport) String() string { return "WebSocket " + t.path() }
+
+// DialContext 建立 TCP 连接并完成 WebSocket 升级握手
+func (t *WebSocketTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := TCPTransport{Proxy: t.Proxy, SocketBuffers: t.SocketBuffers}.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyBytes [16]byte
+	if _, err := rand.Read(keyBytes[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes[:])
+
+	conn.SetDeadline(time.Now().Add(wsHandshakeTimeout))
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", t.path(), address, key)
+	if _, err := io.WriteString(conn, req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("发送 WebSocket 升级请求失败: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("读取 WebSocket 升级响应失败: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket 升级失败: HTTP %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		conn.Close()
+		return nil, errors.New("WebSocket 升级失败: Sec-WebSocket-Accept 不匹配")
+	}
+	conn.SetDeadline(time.Time{})
+
+	return newWSConn(conn, br, true), nil
+}
+
+// Listen 监听 TCP 地址，在升级路径上接受 WebSocket 连接，其他请求返回 404
+func (t *WebSocketTransport) Listen(network, address string) (net.Listener, error) {
+	listener, err := t.SocketBuffers.listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	wl := &wsListener{
+		listener: listener,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(t.path(), wl.upgrade)
+	wl.server = &http.Server{Handler: mux, ReadHeaderTimeout: wsHandshakeTimeout}
+	go func() {
+		if err := wl.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("WebSocket 监听器错误: %v", err)
+		}
+		wl.Close()
+	}()
+	return wl, nil
+}
+
+// websocketAccept 计算 Sec-WebSocket-Key 对应的 Sec-WebSocket-Accept
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// wsListener 将升级成功的 WebSocket 连接作为 net.Conn 交给 Accept
+type wsListener struct {
+	listener  net.Listener
+	server    *http.Server
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// upgrade 处理 WebSocket 升级请求
+func (l *wsListener) upgrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("WebSocket 升级失败: %v", err)
+		return
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(wsHandshakeTimeout))
+	if _, err := io.WriteString(conn, resp); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	select {
+	case l.conns <- newWSConn(conn, rw.Reader, false):
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// headerContainsToken 判断逗号分隔的请求头中是否包含指定值（不区分大小写）
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Accept 返回下一个升级成功的 WebSocket 连接
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听器（已建立的 WebSocket 连接不受影响）
+func (l *wsListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.listener.Close()
+		go l.server.Close()
+	})
+	return err
+}
+
+// Addr 返回监听地址
+func (l *wsListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// wsConn 在 WebSocket 连接上实现 net.Conn：每次 Write 发送一个二进制帧，Read 按字节流返回数据帧的负载
+// 客户端发送的帧按 RFC 6455 加掩码；收到 ping 时回复 pong，收到 close 时回复 close（状态码 1000）并返回 io.EOF。
+// 服务器端收到未加掩码的帧、任一端收到文本帧时以状态码 1002 发送 close 并断开连接
+type wsConn struct {
+	net.Conn
+	br     *bufio.Reader
+	client bool // 是否为客户端（发送的帧需要加掩码）
+
+	readMu    sync.Mutex
+	remaining int64   // 当前数据帧未读的负载字节数
+	mask      [4]byte // 当前数据帧的掩码
+	masked    bool
+	maskPos   int
+	closed    bool // 已收到 close 帧
+
+	writeMu sync.Mutex
+}
+
+// newWSConn 创建 WebSocket 连接，br 为握手时使用的读缓冲（可能已包含后续帧）
+func newWSConn(conn net.Conn, br *bufio.Reader, client bool) *wsConn {
+	return &wsConn{Conn: conn, br: br, client: client}
+}
+
+// Read 读取数据帧的负载
+func (c *wsConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for c.remaining == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.br.Read(b)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			b[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// nextFrame 读取下一个帧头；控制帧在此处理，数据帧设置 remaining 后由 Read 读取负载
+func (c *wsConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return errors.New("websocket frame too large")
+		}
+	}
+
+	// 客户端发往服务器的帧必须加掩码（RFC 6455 5.1）
+	if !c.client && !masked {
+		return c.fail("websocket: unmasked client frame")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch opcode {
+	case wsOpText:
+		// 隧道只使用二进制帧
+		return c.fail("websocket: text frames are not supported")
+	case wsOpBinary, wsOpContinuation:
+		c.remaining = length
+		c.mask = mask
+		c.masked = masked
+		c.maskPos = 0
+		return nil
+	case wsOpClose, wsOpPing, wsOpPong:
+		if length > 125 {
+			return errors.New("websocket control frame too large")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i&3]
+			}
+		}
+		switch opcode {
+		case wsOpPing:
+			return c.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			c.closed = true
+			c.writeFrame(wsOpClose, closePayload(wsCloseNormal, ""))
+		}
+		return nil
+	default:
+		return c.fail(fmt.Sprintf("websocket: unknown opcode 0x%x", opcode))
+	}
+}
+
+// fail 以状态码 1002（协议错误）发送 close 帧并关闭连接，返回描述原因的错误
+func (c *wsConn) fail(reason string) error {
+	c.closed = true
+	c.writeFrame(wsOpClose, closePayload(wsCloseProtocolError, reason))
+	c.Conn.Close()
+	return errors.New(reason)
+}
+
+// closePayload 构造 close 帧的负载：2 字节状态码加原因文本
+func closePayload(code uint16, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
+
+// Write 将数据作为一个二进制帧发送
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeFrame 发送一个完整的帧（客户端加掩码）
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 0, 14)
+	header = append(header, 0x80|opcode)
+
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, maskBit|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	frame := payload
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		header = append(header, mask[:]...)
+		frame = make([]byte, len(payload))
+		for i := range payload {
+			frame[i] = payload[i] ^ mask[i&3]
+		}
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := (&net.Buffers{header, frame}).WriteTo(c.Conn)
+	return err
+}
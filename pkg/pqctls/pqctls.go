@@ -0,0 +1,97 @@
+// Package pqctls 是 PQC mTLS（ML-KEM 密钥交换、ML-DSA 证书）连接的公开 API
+//
+// 该包是 reverse-tunnel/internal/pqctls 的薄封装，需要 cgo 和支持 ML-KEM/ML-DSA 的 OpenSSL（见 README）。
+// 嵌入隧道时通常不需要直接使用，NewServerWithTLS / NewClientWithTLS 会创建对应的监听器和拨号器
+package pqctls
+
+import (
+	"net"
+
+	"reverse-tunnel/internal/pqctls"
+)
+
+// PQCConn 已完成握手的 PQC TLS 连接
+type PQCConn = pqctls.PQCConn
+
+// PQCDialer PQC mTLS 拨号器
+type PQCDialer = pqctls.PQCDialer
+
+// PQCListener PQC mTLS 监听器
+type PQCListener = pqctls.PQCListener
+
+// ConnectionState PQC TLS 连接的协商结果（PQCConn.ConnectionState 返回）
+type ConnectionState = pqctls.ConnectionState
+
+// SessionCache 客户端 TLS 会话缓存，可在多个 PQCDialer 之间共享
+type SessionCache = pqctls.SessionCache
+
+// HandshakeStat 按角色、结果、密钥交换组和对端签名算法统计的握手次数、耗时和字节数（HandshakeStats 返回）
+type HandshakeStat = pqctls.HandshakeStat
+
+// HandshakeError 服务器拒绝的一次握手（PQCListener.Accept 返回），Reason 为 Reject* 常量之一
+type HandshakeError = pqctls.HandshakeError
+
+// 服务器拒绝握手的原因分类（HandshakeError.Reason）
+const (
+	RejectNonPQC          = pqctls.RejectNonPQC
+	RejectCertificate     = pqctls.RejectCertificate
+	RejectUnknownProtocol = pqctls.RejectUnknownProtocol
+	RejectHandshake       = pqctls.RejectHandshake
+)
+
+// 可以用 errors.Is 判断的错误：证书文件不存在、证书配置无效、握手时证书未通过验证、未协商 PQC 算法、服务器证书指纹不符和握手超时。
+// 这些错误经隧道（NewServerWithTLS / NewClientWithTLS）返回时同样保留，网络错误保留原始类型（可以用 errors.As 判断 *net.OpError）
+var (
+	ErrCertificateNotFound = pqctls.ErrCertificateNotFound
+	ErrInvalidCertificate  = pqctls.ErrInvalidCertificate
+	ErrCertificateRejected = pqctls.ErrCertificateRejected
+	ErrNonPQC              = pqctls.ErrNonPQC
+	ErrFingerprintMismatch = pqctls.ErrFingerprintMismatch
+	ErrHandshakeTimeout    = pqctls.ErrHandshakeTimeout
+)
+
+// OpenSSLConfEnv 指定 OpenSSL 配置文件的环境变量，DefaultOpenSSLConf 为未设置时的默认路径
+const (
+	OpenSSLConfEnv     = pqctls.OpenSSLConfEnv
+	DefaultOpenSSLConf = pqctls.DefaultOpenSSLConf
+)
+
+// Ready 返回 OpenSSL 初始化的错误（nil 表示 PQC 算法可用）
+func Ready() error {
+	return pqctls.Ready()
+}
+
+// OpenSSLConfPath 返回使用的 OpenSSL 配置文件路径
+func OpenSSLConfPath() string {
+	return pqctls.OpenSSLConfPath()
+}
+
+// PQCAlgorithms 返回达到指定 NIST 安全级别的密钥交换组和签名算法列表（0 表示全部）
+func PQCAlgorithms(level int) (groups, sigalgs string, err error) {
+	return pqctls.PQCAlgorithms(level)
+}
+
+// NewPQCDialerOpenSSL 使用客户端证书、私钥和 CA 创建拨号器
+func NewPQCDialerOpenSSL(certFile, keyFile, caFile string) (*PQCDialer, error) {
+	return pqctls.NewPQCDialerOpenSSL(certFile, keyFile, caFile)
+}
+
+// NewPQCListenerOpenSSL 在 listener 上创建要求客户端证书的 PQC mTLS 监听器
+func NewPQCListenerOpenSSL(listener net.Listener, certFile, keyFile, caFile string) (*PQCListener, error) {
+	return pqctls.NewPQCListenerOpenSSL(listener, certFile, keyFile, caFile)
+}
+
+// ParseFingerprint 解析证书的 SHA-256 指纹（十六进制，字节之间可以用冒号分隔），用于 PQCDialer.SetServerFingerprint
+func ParseFingerprint(s string) ([]byte, error) {
+	return pqctls.ParseFingerprint(s)
+}
+
+// HandshakeStats 返回进程内 PQC 握手统计的快照
+func HandshakeStats() []HandshakeStat {
+	return pqctls.HandshakeStats()
+}
+
+// NewSessionCache 创建客户端 TLS 会话缓存
+func NewSessionCache() *SessionCache {
+	return pqctls.NewSessionCache()
+}
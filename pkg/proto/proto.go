@@ -0,0 +1,193 @@
+// Package proto 是隧道控制连接帧协议的公开 API，供实现兼容的服务器/客户端或调试工具使用
+//
+// 该包是 reverse-tunnel/internal/proto 的薄封装，帧格式：1 byte frame_type | 4 bytes conn_id | 4 bytes payload_len | payload
+package proto
+
+import (
+	"io"
+
+	"reverse-tunnel/internal/proto"
+)
+
+// Frame 协议帧
+type Frame = proto.Frame
+
+// FrameType 帧类型
+type FrameType = proto.FrameType
+
+// CloseReason CLOSE_CONN 帧携带的关闭原因
+type CloseReason = proto.CloseReason
+
+// InitConfig INIT 帧携带的隧道配置
+type InitConfig = proto.InitConfig
+
+// Framing 隧道的 DATA 帧分帧方式
+type Framing = proto.Framing
+
+// NewConnInfo NEW_CONN 帧携带的连接元信息
+type NewConnInfo = proto.NewConnInfo
+
+// Assignment ASSIGNED 帧携带的隧道配置结果
+type Assignment = proto.Assignment
+
+// Features 可协商的可选协议特性（位掩码）
+type Features = proto.Features
+
+// Hello HELLO 帧携带的特性协商信息
+type Hello = proto.Hello
+
+// Event EVENT 帧携带的隧道事件（推送给观察者连接）
+type Event = proto.Event
+
+// 帧类型
+const (
+	FrameTypeNEW_CONN = proto.FrameTypeNEW_CONN
+	FrameTypeDATA     = proto.FrameTypeDATA
+	FrameTypeCLOSE    = proto.FrameTypeCLOSE
+	FrameTypeINIT     = proto.FrameTypeINIT
+	FrameTypeREDIRECT = proto.FrameTypeREDIRECT
+	FrameTypeASSIGNED = proto.FrameTypeASSIGNED
+	FrameTypeERROR    = proto.FrameTypeERROR
+	FrameTypeHELLO    = proto.FrameTypeHELLO
+	FrameTypeOBSERVE  = proto.FrameTypeOBSERVE
+	FrameTypeEVENT    = proto.FrameTypeEVENT
+)
+
+// 可协商的协议特性
+const (
+	FeatureDataKeepalive  = proto.FeatureDataKeepalive
+	FeatureAssignmentInfo = proto.FeatureAssignmentInfo
+	SupportedFeatures     = proto.SupportedFeatures
+)
+
+// 关闭原因
+const (
+	CloseGraceful = proto.CloseGraceful
+	CloseError    = proto.CloseError
+	CloseReset    = proto.CloseReset
+	CloseIdle     = proto.CloseIdle
+	CloseShutdown = proto.CloseShutdown
+)
+
+// 隧道事件类型
+const (
+	EventConnected    = proto.EventConnected
+	EventReady        = proto.EventReady
+	EventDisconnected = proto.EventDisconnected
+	EventError        = proto.EventError
+)
+
+// 分帧方式
+const (
+	FramingStream   = proto.FramingStream
+	FramingDatagram = proto.FramingDatagram
+)
+
+// 负载长度上限
+const (
+	MaxPayloadSize     = proto.MaxPayloadSize
+	MaxInitPayloadSize = proto.MaxInitPayloadSize
+)
+
+// 解码帧的错误（可用 errors.Is 判断）
+var (
+	ErrTruncatedHeader  = proto.ErrTruncatedHeader
+	ErrTruncatedPayload = proto.ErrTruncatedPayload
+	ErrPayloadTooLarge  = proto.ErrPayloadTooLarge
+	ErrDatagramTooLarge = proto.ErrDatagramTooLarge
+)
+
+// DecodeFrame 从 r 读取一个帧
+func DecodeFrame(r io.Reader) (*Frame, error) {
+	return proto.DecodeFrame(r)
+}
+
+// EncodeFrame 将帧编码为字节
+func EncodeFrame(f *Frame) ([]byte, error) {
+	return proto.EncodeFrame(f)
+}
+
+// WriteFrame 将帧写入 w
+func WriteFrame(w io.Writer, f *Frame) error {
+	return proto.WriteFrame(w, f)
+}
+
+// DataFrames 按分帧方式把数据转为 DATA 帧（数据报不拆分，超过 max 时返回 ErrDatagramTooLarge）
+func DataFrames(framing Framing, connID uint32, data []byte, max int) ([]*Frame, error) {
+	return proto.DataFrames(framing, connID, data, max)
+}
+
+// EncodeInitConfig 编码 INIT 帧负载
+func EncodeInitConfig(config *InitConfig) []byte {
+	return proto.EncodeInitConfig(config)
+}
+
+// DecodeInitConfig 解码 INIT 帧负载
+func DecodeInitConfig(data []byte) (*InitConfig, error) {
+	return proto.DecodeInitConfig(data)
+}
+
+// EncodeNewConnInfo 编码 NEW_CONN 帧负载
+func EncodeNewConnInfo(info *NewConnInfo) []byte {
+	return proto.EncodeNewConnInfo(info)
+}
+
+// DecodeNewConnInfo 解码 NEW_CONN 帧负载
+func DecodeNewConnInfo(data []byte) (*NewConnInfo, error) {
+	return proto.DecodeNewConnInfo(data)
+}
+
+// EncodeCloseReason 编码 CLOSE_CONN 帧负载
+func EncodeCloseReason(r CloseReason) []byte {
+	return proto.EncodeCloseReason(r)
+}
+
+// DecodeCloseReason 解码 CLOSE_CONN 帧负载（空负载为 CloseGraceful）
+func DecodeCloseReason(data []byte) CloseReason {
+	return proto.DecodeCloseReason(data)
+}
+
+// EncodeRedirect 编码 REDIRECT 帧负载
+func EncodeRedirect(addr string) []byte {
+	return proto.EncodeRedirect(addr)
+}
+
+// DecodeRedirect 解码 REDIRECT 帧负载
+func DecodeRedirect(data []byte) (string, error) {
+	return proto.DecodeRedirect(data)
+}
+
+// EncodeAssignment 编码 ASSIGNED 帧负载
+func EncodeAssignment(a *Assignment) []byte {
+	return proto.EncodeAssignment(a)
+}
+
+// DecodeAssignment 解码 ASSIGNED 帧负载（旧版本服务器的负载只有地址）
+func DecodeAssignment(data []byte) (*Assignment, error) {
+	return proto.DecodeAssignment(data)
+}
+
+// EncodeHello 编码 HELLO 帧负载
+func EncodeHello(h *Hello) []byte {
+	return proto.EncodeHello(h)
+}
+
+// DecodeHello 解码 HELLO 帧负载
+func DecodeHello(data []byte) (*Hello, error) {
+	return proto.DecodeHello(data)
+}
+
+// EncodeEvent 编码 EVENT 帧负载
+func EncodeEvent(e *Event) []byte {
+	return proto.EncodeEvent(e)
+}
+
+// DecodeEvent 解码 EVENT 帧负载
+func DecodeEvent(data []byte) (*Event, error) {
+	return proto.DecodeEvent(data)
+}
+
+// ParseFeatures 按名称解析特性集合
+func ParseFeatures(names []string) (Features, error) {
+	return proto.ParseFeatures(names)
+}
@@ -0,0 +1,93 @@
+package tunnel_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"reverse-tunnel/pkg/tunnel"
+)
+
+// 在同一进程中嵌入服务器和客户端：把本地 echo 服务通过客户端指定的公开端口发布出去
+func Example() {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	// 本地服务（echo）
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println("启动本地服务失败:", err)
+		return
+	}
+	defer local.Close()
+	go func() {
+		for {
+			conn, err := local.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	// 服务器：使用调用方创建的控制端口监听器（也可以传入地址，由服务器自行监听）
+	control, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println("监听控制端口失败:", err)
+		return
+	}
+	ready := make(chan tunnel.PortEvent, 1)
+	server := tunnel.NewServer("", "",
+		tunnel.WithServerControlListener(control),
+		tunnel.WithServerPortCallback(func(ev tunnel.PortEvent) {
+			if ev.Event == "assigned" {
+				ready <- ev
+			}
+		}),
+	)
+	go server.Run(context.Background())
+
+	// 客户端：要求服务器在一个空闲端口上为其监听
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println("获取空闲端口失败:", err)
+		return
+	}
+	remotePort := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+	client := tunnel.NewClient(control.Addr().String(), local.Addr().String(), remotePort)
+	go client.Run(context.Background())
+
+	// 隧道就绪后通过公开端口访问本地服务
+	var ev tunnel.PortEvent
+	select {
+	case ev = <-ready:
+	case <-time.After(5 * time.Second):
+		fmt.Println("等待隧道就绪超时")
+		return
+	}
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", ev.Port))
+	if err != nil {
+		fmt.Println("连接公开端口失败:", err)
+		return
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		fmt.Println("读取回显失败:", err)
+		return
+	}
+	conn.Close()
+	fmt.Println(string(buf), len(server.ClientStatus()))
+
+	// 先停止客户端再停止服务器，两者都等待清理完成
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client.Shutdown(ctx)
+	server.Shutdown(ctx)
+	// Output: hello 1
+}
@@ -0,0 +1,569 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"reverse-tunnel/internal/tunnel"
+	"reverse-tunnel/pkg/proto"
+)
+
+// 服务器选项
+
+// WithServerAccessLog 设置公开连接访问日志输出（JSON Lines，每个连接结束时写入一行）
+func WithServerAccessLog(w io.Writer) ServerOption {
+	return tunnel.WithServerAccessLog(w)
+}
+
+// WithServerSecurityLog 设置安全日志输出（JSON Lines，每次拒绝控制连接握手或客户端身份时写入一行）
+func WithServerSecurityLog(w io.Writer) ServerOption {
+	return tunnel.WithServerSecurityLog(w)
+}
+
+// WithServerLogPeerCertChain 设置是否在客户端注册成功时把其完整证书链写入运行日志和安全日志（默认关闭）
+func WithServerLogPeerCertChain(enabled bool) ServerOption {
+	return tunnel.WithServerLogPeerCertChain(enabled)
+}
+
+// WithServerSocketBuffers 设置控制端口和公开端口监听 socket 的接收/发送缓冲区大小（字节，0 表示系统默认）
+func WithServerSocketBuffers(read, write int) ServerOption {
+	return tunnel.WithServerSocketBuffers(read, write)
+}
+
+// WithServerDSCP 设置控制端口和公开端口 socket 的 DSCP 标记（0-63，0 表示不标记，只在 Linux 和 macOS 上生效）
+func WithServerDSCP(dscp int) ServerOption {
+	return tunnel.WithServerDSCP(dscp)
+}
+
+// WithServerName 设置服务器实例名称，作为 name 标签附加到所有指标，并写入访问日志和安全日志的每条记录
+func WithServerName(name string) ServerOption {
+	return tunnel.WithServerName(name)
+}
+
+// WithServerPublicErrorResponse 设置客户端连接本地服务失败时向外部连接回复的错误（PublicErrorResponseHTTP 回复 HTTP 502，空字符串直接关闭）
+func WithServerPublicErrorResponse(mode string) ServerOption {
+	return tunnel.WithServerPublicErrorResponse(mode)
+}
+
+// WithServerPublicSourceLimit 按来源 IP 限制公开连接：每个来源同时打开的连接数（maxConns）和每秒新建连接数（rate，允许 burst 个突发），0 表示不限制该项
+func WithServerPublicSourceLimit(maxConns, rate, burst int) ServerOption {
+	return tunnel.WithServerPublicSourceLimit(maxConns, rate, burst)
+}
+
+// WithServerClientShareLimit 限制每个客户端在全局公开端口上的份额：同时打开的连接数（maxConns）和每秒新建连接数（rate），0 表示不限制该项
+func WithServerClientShareLimit(maxConns, rate int) ServerOption {
+	return tunnel.WithServerClientShareLimit(maxConns, rate)
+}
+
+// WithServerMaxForwarders 设置转发公开连接数据的 goroutine 数上限（每个公开连接一个，0 表示不限制），达到上限时关闭新的公开连接
+func WithServerMaxForwarders(n int) ServerOption {
+	return tunnel.WithServerMaxForwarders(n)
+}
+
+// WithServerNoClientPolicy 设置全局公开端口没有可用客户端时的策略（NoClientPolicyClose / NoClientPolicyHold / NoClientPolicyError），
+// holdTimeout 为 NoClientPolicyHold 等待客户端（重新）连接的最长时间（0 表示默认 5 秒）
+func WithServerNoClientPolicy(policy string, holdTimeout time.Duration) ServerOption {
+	return tunnel.WithServerNoClientPolicy(policy, holdTimeout)
+}
+
+// WithServerNoClientMaxHolds 设置 NoClientPolicyHold 同时等待客户端的公开连接数上限（0 表示默认 1024）
+func WithServerNoClientMaxHolds(n int) ServerOption {
+	return tunnel.WithServerNoClientMaxHolds(n)
+}
+
+// WithServerPublicFallback 设置全局公开端口没有可用客户端时回复的静态 HTTP 页面（fb 由 NewPublicFallback 创建）
+func WithServerPublicFallback(fb *PublicFallback) ServerOption {
+	return tunnel.WithServerPublicFallback(fb)
+}
+
+// WithServerConnSetupTimeout 设置发送 NEW_CONN 后等待客户端建立本地连接的最长时间（0 表示不限制），超时后关闭公开连接
+func WithServerConnSetupTimeout(d time.Duration) ServerOption {
+	return tunnel.WithServerConnSetupTimeout(d)
+}
+
+// WithServerMaxPendingNewConns 设置每个客户端建立中（已发送 NEW_CONN、尚未确认）的连接数上限（0 表示不限制），达到上限时暂停向该客户端转发新的公开连接
+func WithServerMaxPendingNewConns(n int) ServerOption {
+	return tunnel.WithServerMaxPendingNewConns(n)
+}
+
+// WithServerMaxObservers 设置观察者连接数上限（0 表示不接受观察者）：观察者连接不分配端口、不参与转发，只接收隧道事件
+func WithServerMaxObservers(n int) ServerOption {
+	return tunnel.WithServerMaxObservers(n)
+}
+
+// WithServerInitTimeout 设置控制连接建立后等待客户端首个 HELLO 或 INIT 的宽限期（0 表示不限制），宽限期内未发送任何有效帧的控制连接被断开
+func WithServerInitTimeout(d time.Duration) ServerOption {
+	return tunnel.WithServerInitTimeout(d)
+}
+
+// WithServerConnIdleTimeout 设置公开连接的空闲超时（0 表示不限制），两个方向都没有数据超过该时间时关闭连接
+func WithServerConnIdleTimeout(d time.Duration) ServerOption {
+	return tunnel.WithServerConnIdleTimeout(d)
+}
+
+// WithServerConnMaxLifetime 设置公开连接的最长存活时间（0 表示不限制），到期后关闭连接
+func WithServerConnMaxLifetime(d time.Duration) ServerOption {
+	return tunnel.WithServerConnMaxLifetime(d)
+}
+
+// WithServerLazyNewConn 设置是否在公开连接发送第一个字节后才发送 NEW_CONN（timeout 为等待首字节的最长时间，0 表示默认 10 秒）
+func WithServerLazyNewConn(enable bool, timeout time.Duration) ServerOption {
+	return tunnel.WithServerLazyNewConn(enable, timeout)
+}
+
+// WithServerDecodeErrorLimit 设置反复发送无法解码的控制帧的客户端在 backoff 内累计 limit 次错误后被拒绝重连（limit 为 0 表示不限制）
+func WithServerDecodeErrorLimit(limit int, backoff time.Duration) ServerOption {
+	return tunnel.WithServerDecodeErrorLimit(limit, backoff)
+}
+
+// WithServerHTTPCompression 设置是否按内容类型 gzip 压缩 HTTP/1.1 公开连接的响应（只压缩可压缩的 Content-Type）
+func WithServerHTTPCompression(enable bool) ServerOption {
+	return tunnel.WithServerHTTPCompression(enable)
+}
+
+// WithServerPausePolicy 设置客户端被暂停期间新公开连接的策略（PausePolicyReject / PausePolicyHold），
+// holdTimeout 为 PausePolicyHold 等待客户端恢复的最长时间（0 表示默认 30 秒）
+func WithServerPausePolicy(policy string, holdTimeout time.Duration) ServerOption {
+	return tunnel.WithServerPausePolicy(policy, holdTimeout)
+}
+
+// WithServerMaxBytesPerConn 设置单个公开连接最多传输的字节数（两个方向合计，0 表示不限制），超出后强制关闭
+func WithServerMaxBytesPerConn(n int64) ServerOption {
+	return tunnel.WithServerMaxBytesPerConn(n)
+}
+
+// WithServerHealthCheck 设置向客户端发送健康检查的间隔（0 表示不检查），本地服务不可用的客户端不参与全局公开端口的路由
+func WithServerHealthCheck(interval time.Duration) ServerOption {
+	return tunnel.WithServerHealthCheck(interval)
+}
+
+// WithServerFrameTracer 设置控制连接的帧跟踪（读写的每个帧交给 t 记录，不含负载内容，nil 表示不记录）
+func WithServerFrameTracer(t FrameTracer) ServerOption {
+	return tunnel.WithServerFrameTracer(t)
+}
+
+// WithServerFrameMAC 设置控制连接帧完整性校验的共享密钥（仅用于未启用 TLS 的明文模式，nil 表示不启用）
+func WithServerFrameMAC(secret []byte) ServerOption {
+	return tunnel.WithServerFrameMAC(secret)
+}
+
+// WithServerRequiredFeatures 设置客户端必须支持的协议特性（未完成特性协商或协商结果缺少时断开控制连接）
+func WithServerRequiredFeatures(features proto.Features) ServerOption {
+	return tunnel.WithServerRequiredFeatures(features)
+}
+
+// WithServerMaxDataPayload 设置服务器能接收的 DATA 帧负载上限（字节，0 表示默认 4096），与客户端协商后使用双方的较小值
+func WithServerMaxDataPayload(n int) ServerOption {
+	return tunnel.WithServerMaxDataPayload(n)
+}
+
+// WithServerRequirePublicEndpoint 设置未配置全局公开端口时是否拒绝没有请求远程端口的客户端（默认只记录警告）
+func WithServerRequirePublicEndpoint(require bool) ServerOption {
+	return tunnel.WithServerRequirePublicEndpoint(require)
+}
+
+// WithServerPublicEndpointGrace 设置未配置全局公开端口时等待客户端请求远程端口的时间（0 表示默认 10 秒）
+func WithServerPublicEndpointGrace(d time.Duration) ServerOption {
+	return tunnel.WithServerPublicEndpointGrace(d)
+}
+
+// WithServerTraceContext 设置是否为 HTTP 公开连接的第一个请求注入 W3C traceparent 请求头（沿用请求携带的 trace-id）
+func WithServerTraceContext(enable bool) ServerOption {
+	return tunnel.WithServerTraceContext(enable)
+}
+
+// WithServerRandomConnIDBase 设置每个控制连接的 connID 是否从随机起点开始（默认从 1 开始）
+func WithServerRandomConnIDBase(enable bool) ServerOption {
+	return tunnel.WithServerRandomConnIDBase(enable)
+}
+
+// WithServerPortPool 设置租给客户端的固定远程端口池，请求远程端口 0 的客户端从池中租用一个空闲端口（ports 为空表示不启用）
+func WithServerPortPool(ports []int) ServerOption {
+	return tunnel.WithServerPortPool(ports)
+}
+
+// WithServerClientPortBindAddr 设置客户端指定的远程端口绑定的 IP 地址（留空表示所有接口）
+func WithServerClientPortBindAddr(addr string) ServerOption {
+	return tunnel.WithServerClientPortBindAddr(addr)
+}
+
+// WithServerNetwork 设置监听使用的网络类型：tcp（默认，通配地址在支持的平台上为双栈）、tcp4 或 tcp6
+func WithServerNetwork(network string) ServerOption {
+	return tunnel.WithServerNetwork(network)
+}
+
+// WithServerMaxControlConnLifetime 设置控制连接最大存活时间
+func WithServerMaxControlConnLifetime(d time.Duration) ServerOption {
+	return tunnel.WithServerMaxControlConnLifetime(d)
+}
+
+// WithServerMetricsListen 设置指标/状态 HTTP 监听地址（提供 /metrics 和 /status）
+func WithServerMetricsListen(addr string) ServerOption {
+	return tunnel.WithServerMetricsListen(addr)
+}
+
+// WithServerFramePayloadHistogram 设置是否在 /metrics 中输出 DATA 帧负载大小直方图（按发送/接收方向）
+func WithServerFramePayloadHistogram(enable bool) ServerOption {
+	return tunnel.WithServerFramePayloadHistogram(enable)
+}
+
+// WithServerStrictAuxListeners 设置辅助监听器（指标/状态）绑定失败时是否使 Run 返回错误
+func WithServerStrictAuxListeners(strict bool) ServerOption {
+	return tunnel.WithServerStrictAuxListeners(strict)
+}
+
+// WithServerPprof 设置是否在指标/状态监听器上挂载 /debug/pprof/
+func WithServerPprof(enabled bool) ServerOption {
+	return tunnel.WithServerPprof(enabled)
+}
+
+// WithServerStatusUI 设置是否在指标/状态监听器上挂载内置 HTML 状态页 /ui/
+func WithServerStatusUI(enabled bool) ServerOption {
+	return tunnel.WithServerStatusUI(enabled)
+}
+
+// WithServerAdminToken 设置访问管理接口（如 pprof）所需的令牌（Authorization: Bearer <token>）
+func WithServerAdminToken(token string) ServerOption {
+	return tunnel.WithServerAdminToken(token)
+}
+
+// WithServerGlobalRouting 设置全局公开端口的路由策略（hostname / round-robin / weighted，未设置时只允许一个客户端）
+func WithServerGlobalRouting(policy string) ServerOption {
+	return tunnel.WithServerGlobalRouting(policy)
+}
+
+// WithServerDuplicateIdentityPolicy 设置相同身份（证书 CN）的客户端重复连接时的策略（allow / reject-new / replace-old）
+func WithServerDuplicateIdentityPolicy(policy string) ServerOption {
+	return tunnel.WithServerDuplicateIdentityPolicy(policy)
+}
+
+// WithServerPublicClientQueue 为全局公开端口启用按客户端划分的公平队列，每个客户端最多排队 size 个连接（0 表示不启用）
+func WithServerPublicClientQueue(size int) ServerOption {
+	return tunnel.WithServerPublicClientQueue(size)
+}
+
+// WithServerPublicQueue 设置公开连接队列容量、队列满时的策略（QueuePolicyBlock / QueuePolicyReject）和 worker 数量
+func WithServerPublicQueue(size int, policy string, workers int) ServerOption {
+	return tunnel.WithServerPublicQueue(size, policy, workers)
+}
+
+// WithServerPolicy 设置按客户端身份（证书 CN）的配额策略
+func WithServerPolicy(policy *PolicyStore) ServerOption {
+	return tunnel.WithServerPolicy(policy)
+}
+
+// WithServerPublicTLS 在全局公开端口上终止 TLS（cfg 通常由 NewPublicTLSConfig 创建），按握手的 SNI 路由到对应主机名的客户端
+func WithServerPublicTLS(cfg *tls.Config) ServerOption {
+	return tunnel.WithServerPublicTLS(cfg)
+}
+
+// WithServerPublicCertStore 在全局公开端口上终止 TLS，按 SNI 从 store 中选择每个主机名的证书
+func WithServerPublicCertStore(store *PublicCertStore) ServerOption {
+	return tunnel.WithServerPublicCertStore(store)
+}
+
+// WithServerPolicyReload 设置重新加载配额策略使用的文件
+func WithServerPolicyReload(path string, revoke bool) ServerOption {
+	return tunnel.WithServerPolicyReload(path, revoke)
+}
+
+// WithServerMaxFrameRate 设置每个控制连接每秒最多处理的帧数及超出时的策略
+func WithServerMaxFrameRate(rate int, policy string) ServerOption {
+	return tunnel.WithServerMaxFrameRate(rate, policy)
+}
+
+// WithServerOTLPMetrics 把与 /metrics 相同的指标周期性地以 OTLP/HTTP 推送到 OpenTelemetry collector（Endpoint 为空表示不推送）
+func WithServerOTLPMetrics(cfg OTLPConfig) ServerOption {
+	return tunnel.WithServerOTLPMetrics(cfg)
+}
+
+// WithServerEventSink 把隧道生命周期事件攒批导出到 sink（队列已满或发布失败时丢弃，不阻塞转发）
+func WithServerEventSink(sink EventSink) ServerOption {
+	return tunnel.WithServerEventSink(sink)
+}
+
+// WithServerInitBudget 限制控制连接在完成 INIT 之前的 INIT 负载大小、读取的字节数和时间，超出时中止连接
+func WithServerInitBudget(maxInitPayload, maxBytes int, timeout time.Duration) ServerOption {
+	return tunnel.WithServerInitBudget(maxInitPayload, maxBytes, timeout)
+}
+
+// WithServerPortNotify 设置隧道就绪/断开时的端口分配通知，用于外部服务发现（DNS、反向代理等）
+func WithServerPortNotify(file, webhook string) ServerOption {
+	return tunnel.WithServerPortNotify(file, webhook)
+}
+
+// WithServerMetering 设置按客户端身份累计用量的计量记录：file 用于恢复和周期性写入快照，webhook 周期性接收快照，interval 为 0 时为 1 分钟
+func WithServerMetering(file, webhook string, interval time.Duration) ServerOption {
+	return tunnel.WithServerMetering(file, webhook, interval)
+}
+
+// WithServerPortCallback 设置隧道就绪/断开时的进程内回调（嵌入服务器的程序用于服务发现），事件与 webhook 相同
+func WithServerPortCallback(fn func(PortEvent)) ServerOption {
+	return tunnel.WithServerPortCallback(fn)
+}
+
+// WithServerTLSMinSecurityLevel 要求 PQC mTLS 握手达到指定的 NIST 安全级别（1-5，仅 PQC mTLS 生效）
+func WithServerTLSMinSecurityLevel(level int) ServerOption {
+	return tunnel.WithServerTLSMinSecurityLevel(level)
+}
+
+// WithServerMaxHandshakes 设置同时进行的 PQC mTLS 握手数上限及达到上限时的策略（HandshakeLimitQueue 或 HandshakeLimitReject，0 表示不并发握手）
+func WithServerMaxHandshakes(n int, policy string) ServerOption {
+	return tunnel.WithServerMaxHandshakes(n, policy)
+}
+
+// WithServerTLSSessionResumption 设置是否允许客户端恢复 TLS 会话（仅 PQC mTLS 生效）
+func WithServerTLSSessionResumption(enabled bool) ServerOption {
+	return tunnel.WithServerTLSSessionResumption(enabled)
+}
+
+// WithServerTLSRequireClientCert 设置是否要求客户端证书（仅 PQC mTLS 生效，默认要求，不要求时为单向 TLS，
+// 此时必须通过 WithServerPolicy 配置拒绝空身份的配额策略）
+func WithServerTLSRequireClientCert(require bool) ServerOption {
+	return tunnel.WithServerTLSRequireClientCert(require)
+}
+
+// WithServerTransport 设置控制连接的传输（例如 WebSocketTransport）
+func WithServerTransport(t Transport) ServerOption {
+	return tunnel.WithServerTransport(t)
+}
+
+// WithServerControlListener 使用外部提供的控制连接监听器替代按地址监听（例如测试中的内存管道）
+func WithServerControlListener(l net.Listener) ServerOption {
+	return tunnel.WithServerControlListener(l)
+}
+
+// WithServerPublicListener 使用外部提供的全局公开端口监听器替代按地址监听
+func WithServerPublicListener(l net.Listener) ServerOption {
+	return tunnel.WithServerPublicListener(l)
+}
+
+// WithServerControlWriteTimeout 设置服务器向控制连接写入单个帧的超时时间
+func WithServerControlWriteTimeout(d time.Duration) ServerOption {
+	return tunnel.WithServerControlWriteTimeout(d)
+}
+
+// WithServerShutdownTimeout 设置关闭时清理资源（注销客户端、关闭连接）的最长时间
+func WithServerShutdownTimeout(d time.Duration) ServerOption {
+	return tunnel.WithServerShutdownTimeout(d)
+}
+
+// 客户端选项
+
+// WithExitOnFatal 设置遇到致命错误（见 IsFatal）时 Run 是否直接返回该错误而不是重连（默认关闭）
+func WithExitOnFatal(enabled bool) ClientOption {
+	return tunnel.WithExitOnFatal(enabled)
+}
+
+// WithLocalReadyTimeout 设置连接服务器前等待本地服务就绪的超时时间
+func WithLocalReadyTimeout(d time.Duration) ClientOption {
+	return tunnel.WithLocalReadyTimeout(d)
+}
+
+// WithLocalTCPFastOpen 设置拨号本地服务时是否启用 TCP Fast Open
+func WithLocalTCPFastOpen(enabled bool) ClientOption {
+	return tunnel.WithLocalTCPFastOpen(enabled)
+}
+
+// WithLocalTLS 设置连接本地服务时使用 TLS（本地服务为 HTTPS/mTLS 时使用）
+func WithLocalTLS(cfg *tls.Config) ClientOption {
+	return tunnel.WithLocalTLS(cfg)
+}
+
+// WithHostname 设置主机名路由键（支持 *.example.com 通配符）
+func WithHostname(hostname string) ClientOption {
+	return tunnel.WithHostname(hostname)
+}
+
+// WithHostnames 设置多个主机名路由键，匹配其中任意一个的公开连接都路由到该客户端
+func WithHostnames(hostnames ...string) ClientOption {
+	return tunnel.WithHostnames(hostnames...)
+}
+
+// WithWeight 设置负载均衡权重（多个客户端匹配同一路由键时服务器按权重分配公开连接，0 表示默认权重 1）
+func WithWeight(weight int) ClientOption {
+	return tunnel.WithWeight(weight)
+}
+
+// WithLeaseRemotePort 设置远程端口为 0 时是否从服务器的端口池租用一个远程端口
+func WithLeaseRemotePort(lease bool) ClientOption {
+	return tunnel.WithLeaseRemotePort(lease)
+}
+
+// WithHostRoutes 设置按公开连接主机名（TLS SNI 或 HTTP Host）选择本地服务的路由规则，主机名同时注册到服务器
+func WithHostRoutes(routes []HostRoute) ClientOption {
+	return tunnel.WithHostRoutes(routes)
+}
+
+// WithLocalResolver 设置 NEW_CONN 时解析本地地址的解析器（服务发现），nil 表示使用静态选择的地址
+func WithLocalResolver(r LocalResolver) ClientOption {
+	return tunnel.WithLocalResolver(r)
+}
+
+// WithLocalRoutes 设置按公开连接来源 IP 选择本地服务的路由规则
+func WithLocalRoutes(routes []LocalRoute) ClientOption {
+	return tunnel.WithLocalRoutes(routes)
+}
+
+// WithLocalDialSource 设置拨号本地服务使用的源 IP（多网卡主机上配合策略路由或防火墙规则使用）
+func WithLocalDialSource(ip net.IP) ClientOption {
+	return tunnel.WithLocalDialSource(ip)
+}
+
+// WithLocalPool 启用本地连接池：保持 size 个预热的本地连接，NEW_CONN 优先使用池中连接
+func WithLocalPool(size int, reuse bool) ClientOption {
+	return tunnel.WithLocalPool(size, reuse)
+}
+
+// WithLocalMultiplex 启用本地多路复用：与本地服务保持一条持久连接，公开连接作为其上按隧道帧格式收发的流（后端必须实现该约定，默认关闭）
+func WithLocalMultiplex(enabled bool) ClientOption {
+	return tunnel.WithLocalMultiplex(enabled)
+}
+
+// WithLocalBalance 设置本地地址包含多个以逗号分隔的后端时的负载均衡策略（BalanceRoundRobin / BalanceRandom）
+func WithLocalBalance(strategy string, unhealthyFor time.Duration) ClientOption {
+	return tunnel.WithLocalBalance(strategy, unhealthyFor)
+}
+
+// WithCircuitBreaker 设置本地服务熔断：连续 failures 次拨号失败后 cooldown 内直接拒绝新连接（failures 为 0 表示不启用）
+func WithCircuitBreaker(failures int, cooldown time.Duration) ClientOption {
+	return tunnel.WithCircuitBreaker(failures, cooldown)
+}
+
+// WithLocalDialWorkers 限制同时拨号本地服务的连接数，超出的排队等待，排队超过 queue 个时关闭新连接（workers 为 0 表示不启用）
+func WithLocalDialWorkers(workers, queue int) ClientOption {
+	return tunnel.WithLocalDialWorkers(workers, queue)
+}
+
+// WithNetwork 设置连接服务器使用的网络类型：tcp（默认）、tcp4 或 tcp6
+func WithNetwork(network string) ClientOption {
+	return tunnel.WithNetwork(network)
+}
+
+// WithMaxControlConnLifetime 设置控制连接最大存活时间
+func WithMaxControlConnLifetime(d time.Duration) ClientOption {
+	return tunnel.WithMaxControlConnLifetime(d)
+}
+
+// WithName 设置客户端实例名称，作为 name 标签附加到所有指标
+func WithName(name string) ClientOption {
+	return tunnel.WithName(name)
+}
+
+// WithSocketBuffers 设置控制连接和本地连接 socket 的接收/发送缓冲区大小（字节，0 表示系统默认）
+func WithSocketBuffers(read, write int) ClientOption {
+	return tunnel.WithSocketBuffers(read, write)
+}
+
+// WithDSCP 设置控制连接和本地连接 socket 的 DSCP 标记（0-63，0 表示不标记，只在 Linux 和 macOS 上生效）
+func WithDSCP(dscp int) ClientOption {
+	return tunnel.WithDSCP(dscp)
+}
+
+// WithDataKeepalive 设置数据连接保活间隔（空闲连接每个间隔发送一个零长度 DATA 帧），0 表示不启用
+func WithDataKeepalive(interval time.Duration) ClientOption {
+	return tunnel.WithDataKeepalive(interval)
+}
+
+// WithConnIdleTimeout 设置本地连接的空闲超时（0 表示不限制），两个方向都没有数据超过该时间时关闭连接
+func WithConnIdleTimeout(d time.Duration) ClientOption {
+	return tunnel.WithConnIdleTimeout(d)
+}
+
+// WithConnMaxLifetime 设置本地连接的最长存活时间（0 表示不限制），到期后关闭连接
+func WithConnMaxLifetime(d time.Duration) ClientOption {
+	return tunnel.WithConnMaxLifetime(d)
+}
+
+// WithFrameBuffer 设置已从控制连接读取、等待处理的帧数上限（0 表示默认 10），达到上限时停止读取，对服务器施加反压
+func WithFrameBuffer(n int) ClientOption {
+	return tunnel.WithFrameBuffer(n)
+}
+
+// WithRequiredFeatures 设置服务器必须支持的协议特性（服务器未响应特性协商或协商结果缺少时断开并重连）
+func WithRequiredFeatures(features proto.Features) ClientOption {
+	return tunnel.WithRequiredFeatures(features)
+}
+
+// WithMaxDataPayload 设置客户端能接收的 DATA 帧负载上限（字节，0 表示默认 4096），与服务器协商后使用双方的较小值
+func WithMaxDataPayload(n int) ClientOption {
+	return tunnel.WithMaxDataPayload(n)
+}
+
+// WithPprofListen 设置客户端 pprof 调试监听地址（建议仅监听本机）
+func WithPprofListen(addr string) ClientOption {
+	return tunnel.WithPprofListen(addr)
+}
+
+// WithOTLPMetrics 把与 /metrics 相同的指标周期性地以 OTLP/HTTP 推送到 OpenTelemetry collector（Endpoint 为空表示不推送）
+func WithOTLPMetrics(cfg OTLPConfig) ClientOption {
+	return tunnel.WithOTLPMetrics(cfg)
+}
+
+// WithFramePayloadHistogram 设置是否在 pprof 监听器的 /metrics 中输出 DATA 帧负载大小直方图（按发送/接收方向）
+func WithFramePayloadHistogram(enable bool) ClientOption {
+	return tunnel.WithFramePayloadHistogram(enable)
+}
+
+// WithAdminToken 设置访问客户端调试接口所需的令牌（Authorization: Bearer <token>）
+func WithAdminToken(token string) ClientOption {
+	return tunnel.WithAdminToken(token)
+}
+
+// WithControlWriteTimeout 设置客户端向控制连接写入单个帧的超时时间
+func WithControlWriteTimeout(d time.Duration) ClientOption {
+	return tunnel.WithControlWriteTimeout(d)
+}
+
+// WithTLSMinSecurityLevel 要求 PQC mTLS 握手达到指定的 NIST 安全级别（见 WithServerTLSMinSecurityLevel）
+func WithTLSMinSecurityLevel(level int) ClientOption {
+	return tunnel.WithTLSMinSecurityLevel(level)
+}
+
+// WithTLSServerFingerprint 固定服务器证书的 SHA-256 指纹，握手后证书指纹不匹配时拒绝连接（CA 文件为空时只校验指纹）
+func WithTLSServerFingerprint(fp []byte) ClientOption {
+	return tunnel.WithTLSServerFingerprint(fp)
+}
+
+// WithHTTPProxy 通过上游 HTTP 代理连接服务器：先连接代理并发送 CONNECT，再在隧道上进行握手（PQC mTLS 或纯 TCP）
+func WithHTTPProxy(proxy *url.URL) ClientOption {
+	return tunnel.WithHTTPProxy(proxy)
+}
+
+// WithTLSTimeouts 设置 PQC mTLS 连接服务器的 TCP 连接超时和握手超时（0 表示使用默认值，均为 10 秒）
+func WithTLSTimeouts(dial, handshake time.Duration) ClientOption {
+	return tunnel.WithTLSTimeouts(dial, handshake)
+}
+
+// WithTLSSessionResumption 设置是否在重连时恢复 TLS 会话（仅 PQC mTLS 生效，需要服务器同时启用）
+func WithTLSSessionResumption(enabled bool) ClientOption {
+	return tunnel.WithTLSSessionResumption(enabled)
+}
+
+// WithFrameTracer 设置控制连接的帧跟踪（读写的每个帧交给 t 记录，不含负载内容，nil 表示不记录）
+func WithFrameTracer(t FrameTracer) ClientOption {
+	return tunnel.WithFrameTracer(t)
+}
+
+// WithFrameMAC 设置控制连接帧完整性校验的共享密钥（仅用于未启用 TLS 的明文模式，必须与服务器一致）
+func WithFrameMAC(secret []byte) ClientOption {
+	return tunnel.WithFrameMAC(secret)
+}
+
+// WithTransport 设置连接服务器使用的传输（必须与服务器一致，例如 WebSocketTransport）
+func WithTransport(t Transport) ClientOption {
+	return tunnel.WithTransport(t)
+}
+
+// WithControlDialer 使用外部提供的拨号器建立控制连接（例如测试中的内存管道）
+func WithControlDialer(d Dialer) ClientOption {
+	return tunnel.WithControlDialer(d)
+}
+
+// WithLocalDialer 使用外部提供的拨号器连接本地服务（启用本地 TLS 时仍在其返回的连接上握手）
+func WithLocalDialer(d Dialer) ClientOption {
+	return tunnel.WithLocalDialer(d)
+}
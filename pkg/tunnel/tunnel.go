@@ -0,0 +1,274 @@
+// Package tunnel 是反向隧道的公开 API，供其他 Go 程序嵌入隧道服务器或客户端
+//
+// 该包是 reverse-tunnel/internal/tunnel 的薄封装：类型为内部类型的别名，方法与命令行程序使用的实现完全相同，
+// 随内部实现一起演进（不单独承诺兼容性，升级时请查看变更记录）。主要入口：
+//
+//   - 构造函数 NewServer、NewServerWithTLS、NewClient、NewClientWithTLS 及 With* 选项
+//   - Server.Run / Client.Run：阻塞运行直到 ctx 取消，返回 ctx 的错误
+//   - Server.Shutdown / Client.Shutdown：取消 Run 并等待清理完成
+//   - 统计：Server.ClientStatus、Server.Throughput、Server.PortForIdentity，
+//     及 Server.MetricsHandler / StatusHandler 等可挂载到调用方 HTTP 服务上的处理器
+//   - 回调：WithServerPortCallback（隧道就绪/断开）、WithServerAccessLog（每个公开连接结束时的访问记录）
+//
+// 运行日志通过标准库 log 输出，嵌入方可用 log.SetOutput 重定向
+package tunnel
+
+import (
+	"crypto/tls"
+	"io"
+	"net/url"
+
+	"reverse-tunnel/internal/tunnel"
+)
+
+// Server 反向隧道服务器
+type Server = tunnel.Server
+
+// Client 反向隧道客户端
+type Client = tunnel.Client
+
+// ServerOption 服务器可选配置项
+type ServerOption = tunnel.ServerOption
+
+// ClientOption 客户端可选配置项
+type ClientOption = tunnel.ClientOption
+
+// ClientStatus 一个已连接客户端的运行状态快照（Server.ClientStatus 返回）
+type ClientStatus = tunnel.ClientStatus
+
+// PortAssignment 一个已就绪隧道的公开端口分配
+type PortAssignment = tunnel.PortAssignment
+
+// PortEvent 端口变更事件（assigned / released），传给 WithServerPortCallback 设置的回调
+type PortEvent = tunnel.PortEvent
+
+// EventSink 接收批量的隧道生命周期事件（WithServerEventSink 设置）
+type EventSink = tunnel.EventSink
+
+// OTLPConfig OpenTelemetry 指标推送配置（WithServerOTLPMetrics / WithOTLPMetrics 设置）
+type OTLPConfig = tunnel.OTLPConfig
+
+// LifecycleEvent 导出到 EventSink 的隧道生命周期事件
+type LifecycleEvent = tunnel.LifecycleEvent
+
+// WebhookEventSink 把一批事件以 JSON 数组 POST 到 URL 的 EventSink
+type WebhookEventSink = tunnel.WebhookEventSink
+
+// PublicCertStore 全局公开端口按 SNI 选择的证书（NewPublicCertStore 创建，可重新加载）
+type PublicCertStore = tunnel.PublicCertStore
+
+// PublicCertFiles 公开端口某个主机名使用的证书和私钥文件
+type PublicCertFiles = tunnel.PublicCertFiles
+
+// AccessRecord 访问日志中一个公开连接的记录
+type AccessRecord = tunnel.AccessRecord
+
+// SecurityRecord 安全日志中一次被拒绝的控制连接的记录
+type SecurityRecord = tunnel.SecurityRecord
+
+// CertificateInfo 安全日志 handshake_accepted 记录中证书链的一个证书
+type CertificateInfo = tunnel.CertificateInfo
+
+// PolicyStore 按客户端身份的配额策略（LoadPolicyFile 加载）
+type PolicyStore = tunnel.PolicyStore
+
+// ClientQuota 一个身份的配额
+type ClientQuota = tunnel.ClientQuota
+
+// RouteRule 一个身份允许请求的远程端口和主机名
+type RouteRule = tunnel.RouteRule
+
+// LocalRoute 按公开连接来源 IP 选择本地服务的路由规则
+type LocalRoute = tunnel.LocalRoute
+
+// HostRoute 按公开连接主机名（TLS SNI 或 HTTP Host）选择本地服务的路由规则
+type HostRoute = tunnel.HostRoute
+
+// LocalResolver 在 NEW_CONN 时把公开连接映射为要连接的本地地址（WithLocalResolver 设置）
+type LocalResolver = tunnel.LocalResolver
+
+// LocalResolverFunc 将普通函数适配为 LocalResolver
+type LocalResolverFunc = tunnel.LocalResolverFunc
+
+// LocalResolveRequest 一次本地地址解析请求
+type LocalResolveRequest = tunnel.LocalResolveRequest
+
+// FrameTracer 接收控制连接上读写的每个帧（帧跟踪，WithServerFrameTracer / WithFrameTracer 设置）
+type FrameTracer = tunnel.FrameTracer
+
+// FrameTraceEvent 帧跟踪中的一个帧（只包含帧头信息）
+type FrameTraceEvent = tunnel.FrameTraceEvent
+
+// LogFile 可按原路径重新打开的追加写入日志文件（收到 SIGHUP 时调用 Reopen 配合 logrotate 轮转）
+type LogFile = tunnel.LogFile
+
+// TunnelSpec 客户端隧道的配置（Client.AddTunnel 的参数）
+type TunnelSpec = tunnel.TunnelSpec
+
+// LocalCheckResult 一个本地服务的可达性检查结果（Client.CheckLocal 返回）
+type LocalCheckResult = tunnel.LocalCheckResult
+
+// Dialer 建立连接的拨号器，*net.Dialer 满足该接口
+type Dialer = tunnel.Dialer
+
+// Transport 控制连接的传输方式
+type Transport = tunnel.Transport
+
+// TCPTransport 纯 TCP 传输（可经上游 HTTP 代理）
+type TCPTransport = tunnel.TCPTransport
+
+// WebSocketTransport WebSocket 传输
+type WebSocketTransport = tunnel.WebSocketTransport
+
+// PQCTLSTransport PQC mTLS 传输
+type PQCTLSTransport = tunnel.PQCTLSTransport
+
+// SocketBuffers socket 接收/发送缓冲区大小（传输的 SocketBuffers 字段）
+type SocketBuffers = tunnel.SocketBuffers
+
+// PublicFallback 全局公开端口没有可用客户端时回复的静态 HTTP 页面
+type PublicFallback = tunnel.PublicFallback
+
+// 负载均衡、队列和帧速率策略，重复身份策略，公开连接错误响应，传输名称，帧跟踪方向，公开连接的生命周期事件类型
+const (
+	BalanceRoundRobin = tunnel.BalanceRoundRobin
+	BalanceRandom     = tunnel.BalanceRandom
+
+	BuiltinEcho = tunnel.BuiltinEcho
+	BuiltinHTTP = tunnel.BuiltinHTTP
+
+	QueuePolicyBlock  = tunnel.QueuePolicyBlock
+	QueuePolicyReject = tunnel.QueuePolicyReject
+
+	FrameRatePolicyThrottle = tunnel.FrameRatePolicyThrottle
+	FrameRatePolicyDrop     = tunnel.FrameRatePolicyDrop
+
+	DuplicateIdentityAllow      = tunnel.DuplicateIdentityAllow
+	DuplicateIdentityRejectNew  = tunnel.DuplicateIdentityRejectNew
+	DuplicateIdentityReplaceOld = tunnel.DuplicateIdentityReplaceOld
+
+	GlobalRoutingHostname   = tunnel.GlobalRoutingHostname
+	GlobalRoutingRoundRobin = tunnel.GlobalRoutingRoundRobin
+	GlobalRoutingWeighted   = tunnel.GlobalRoutingWeighted
+
+	HandshakeLimitQueue  = tunnel.HandshakeLimitQueue
+	HandshakeLimitReject = tunnel.HandshakeLimitReject
+
+	PublicErrorResponseHTTP = tunnel.PublicErrorResponseHTTP
+
+	NoClientPolicyClose = tunnel.NoClientPolicyClose
+	NoClientPolicyHold  = tunnel.NoClientPolicyHold
+	NoClientPolicyError = tunnel.NoClientPolicyError
+
+	PausePolicyReject = tunnel.PausePolicyReject
+	PausePolicyHold   = tunnel.PausePolicyHold
+
+	TransportTCP       = tunnel.TransportTCP
+	TransportWebSocket = tunnel.TransportWebSocket
+
+	FrameTraceIn  = tunnel.FrameTraceIn
+	FrameTraceOut = tunnel.FrameTraceOut
+
+	EventConnOpen  = tunnel.EventConnOpen
+	EventConnClose = tunnel.EventConnClose
+)
+
+// MaxClientWeight 客户端负载均衡权重的最大值
+const MaxClientWeight = tunnel.MaxClientWeight
+
+// DefaultWebSocketPath WebSocket 传输的默认升级路径
+const DefaultWebSocketPath = tunnel.DefaultWebSocketPath
+
+// ControlALPN PQC mTLS 控制连接协商的 ALPN 协议标识
+const ControlALPN = tunnel.ControlALPN
+
+// 客户端的致命错误（见 IsFatal）：服务器在注册阶段拒绝了客户端、与服务器的协议不兼容
+var (
+	ErrServerRejected       = tunnel.ErrServerRejected
+	ErrProtocolIncompatible = tunnel.ErrProtocolIncompatible
+)
+
+// IsFatal 判断 Client.Run 遇到的错误是否不会因重连而恢复（服务器拒绝、协议不兼容、证书缺失或无效、未协商 PQC 算法等），见 WithExitOnFatal
+func IsFatal(err error) bool {
+	return tunnel.IsFatal(err)
+}
+
+// NewServer 创建服务器。publicListenAddr 为空时由每个客户端在 INIT 中指定公开端口
+func NewServer(controlListenAddr, publicListenAddr string, opts ...ServerOption) *Server {
+	return tunnel.NewServer(controlListenAddr, publicListenAddr, opts...)
+}
+
+// NewServerWithTLS 创建启用 PQC mTLS 的服务器（需要支持 ML-KEM/ML-DSA 的 OpenSSL）
+func NewServerWithTLS(controlListenAddr, publicListenAddr, certFile, keyFile, caFile string, opts ...ServerOption) *Server {
+	return tunnel.NewServerWithTLS(controlListenAddr, publicListenAddr, certFile, keyFile, caFile, opts...)
+}
+
+// NewClient 创建客户端。remotePort 为 0 时使用服务器的全局公开端口
+func NewClient(serverAddr, localAddr string, remotePort int, opts ...ClientOption) *Client {
+	return tunnel.NewClient(serverAddr, localAddr, remotePort, opts...)
+}
+
+// NewClientWithTLS 创建启用 PQC mTLS 的客户端
+func NewClientWithTLS(serverAddr, localAddr string, remotePort int, certFile, keyFile, caFile, serverName string, opts ...ClientOption) *Client {
+	return tunnel.NewClientWithTLS(serverAddr, localAddr, remotePort, certFile, keyFile, caFile, serverName, opts...)
+}
+
+// NewFrameTraceWriter 返回将每个帧写为一行文本的 FrameTracer
+func NewFrameTraceWriter(w io.Writer) FrameTracer {
+	return tunnel.NewFrameTraceWriter(w)
+}
+
+// OpenLogFile 以追加方式打开（不存在时创建）path 处的日志文件
+func OpenLogFile(path string) (*LogFile, error) {
+	return tunnel.OpenLogFile(path)
+}
+
+// NewWebhookEventSink 创建把事件批量 POST 到 url 的 EventSink
+func NewWebhookEventSink(url string) *WebhookEventSink {
+	return tunnel.NewWebhookEventSink(url)
+}
+
+// LoadPolicyFile 加载配额策略文件（格式见 config/README.md）
+func LoadPolicyFile(path string) (*PolicyStore, error) {
+	return tunnel.LoadPolicyFile(path)
+}
+
+// ParseHostRoute 解析一条按主机名选择本地服务的路由规则
+func ParseHostRoute(hostname, local string) (HostRoute, error) {
+	return tunnel.ParseHostRoute(hostname, local)
+}
+
+// ParseLocalRoute 解析一条按来源 IP 选择本地服务的路由规则
+func ParseLocalRoute(cidr, local string) (LocalRoute, error) {
+	return tunnel.ParseLocalRoute(cidr, local)
+}
+
+// ParseHTTPProxy 解析上游 HTTP 代理地址（http://[用户名:密码@]主机[:端口]）
+func ParseHTTPProxy(s string) (*url.URL, error) {
+	return tunnel.ParseHTTPProxy(s)
+}
+
+// ValidateHostnamePattern 校验主机名路由键（支持前导通配符 *.）
+func ValidateHostnamePattern(pattern string) error {
+	return tunnel.ValidateHostnamePattern(pattern)
+}
+
+// NewLocalTLSConfig 创建连接本地 HTTPS/mTLS 服务的标准 TLS 配置
+func NewLocalTLSConfig(certFile, keyFile, caFile, serverName string) (*tls.Config, error) {
+	return tunnel.NewLocalTLSConfig(certFile, keyFile, caFile, serverName)
+}
+
+// NewPublicTLSConfig 创建在全局公开端口上终止 TLS 的配置
+func NewPublicTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	return tunnel.NewPublicTLSConfig(certFile, keyFile)
+}
+
+// NewPublicCertStore 加载全局公开端口的默认证书和按主机名（SNI）选择的证书
+func NewPublicCertStore(certFile, keyFile string, hosts map[string]PublicCertFiles) (*PublicCertStore, error) {
+	return tunnel.NewPublicCertStore(certFile, keyFile, hosts)
+}
+
+// NewPublicFallback 读取 bodyFile 创建没有可用客户端时回复的静态 HTTP 页面（status 为 0 时使用 503，contentType 为空时使用 text/html）
+func NewPublicFallback(status int, contentType, bodyFile string) (*PublicFallback, error) {
+	return tunnel.NewPublicFallback(status, contentType, bodyFile)
+}